@@ -1,14 +1,21 @@
 package admin
 
 import (
-	"net"
 	"net/http"
+	"os"
 
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/internal/listen"
 	"github.com/gorilla/mux"
 )
 
 type AdminApi struct {
 	http.Server
+
+	// The permissions of Addr's socket file, if it's a unix socket
+	SocketMode os.FileMode
+
+	// The proxy's version, recorded in cache exports
+	Version string
 }
 
 func (a *AdminApi) Init(listenAddr string) {
@@ -22,7 +29,7 @@ func (a *AdminApi) Handle(path string, handler http.Handler) {
 }
 
 func (a *AdminApi) Start() error {
-	listener, err := net.Listen("tcp", a.Addr)
+	listener, err := listen.Listen(a.Addr, a.SocketMode)
 	if err != nil {
 		return err
 	}
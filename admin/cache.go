@@ -0,0 +1,214 @@
+package admin
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/executionlayer"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/mux"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+// CacheReader is the subset of the ExecutionLayer the cache endpoints read from
+type CacheReader interface {
+	GetNodeInfo(nodeAddr common.Address) (*executionlayer.NodeInfo, error)
+	ValidatorFeeRecipient(ctx context.Context, pubkey rptypes.ValidatorPubkey, queryNodeAddr *common.Address) (*feerecipient.Info, error)
+	Status(ctx context.Context) (*executionlayer.Status, error)
+	InconsistentMinipools() ([]executionlayer.InconsistentMinipool, error)
+	ExportCache() (*executionlayer.CacheExport, error)
+}
+
+type nodeResponse struct {
+	Address           string   `json:"address"`
+	SmoothingPool     bool     `json:"smoothing_pool"`
+	FeeDistributor    string   `json:"fee_distributor"`
+	MinipoolPubkeys   []string `json:"minipool_pubkeys"`
+	WithdrawalAddress string   `json:"withdrawal_address"`
+	RPLStake          string   `json:"rpl_stake,omitempty"`
+	Bond              string   `json:"bond,omitempty"`
+	UnknownFields     []string `json:"unknown_fields,omitempty"`
+}
+
+type validatorResponse struct {
+	Pubkey         string `json:"pubkey"`
+	Node           string `json:"node"`
+	SmoothingPool  bool   `json:"smoothing_pool"`
+	FeeRecipient   string `json:"fee_recipient"`
+	Source         string `json:"source"`
+	MinipoolStatus string `json:"minipool_status"`
+}
+
+type inconsistentMinipoolResponse struct {
+	Pubkey string `json:"pubkey"`
+	Node   string `json:"node"`
+}
+
+type inconsistentResponse struct {
+	Count     int                            `json:"count"`
+	Minipools []inconsistentMinipoolResponse `json:"minipools"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, code int, err string) {
+	writeJSON(w, code, &errorResponse{Error: err})
+}
+
+func cacheError(w http.ResponseWriter, err error, notFound string) {
+	if _, ok := err.(*executionlayer.NotFoundError); ok {
+		writeError(w, http.StatusNotFound, notFound)
+		return
+	}
+
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+func nodeHandler(el CacheReader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		addr := mux.Vars(r)["address"]
+		if !common.IsHexAddress(addr) {
+			writeError(w, http.StatusBadRequest, "invalid node address "+addr)
+			return
+		}
+		nodeAddr := common.HexToAddress(addr)
+
+		nodeInfo, err := el.GetNodeInfo(nodeAddr)
+		if err != nil {
+			cacheError(w, err, "node "+nodeAddr.String()+" is not a known rocket pool node")
+			return
+		}
+
+		out := &nodeResponse{
+			Address:           nodeAddr.String(),
+			SmoothingPool:     nodeInfo.InSmoothingPool,
+			FeeDistributor:    nodeInfo.FeeDistributor.String(),
+			MinipoolPubkeys:   make([]string, 0, len(nodeInfo.MinipoolPubkeys)),
+			WithdrawalAddress: nodeInfo.WithdrawalAddress.String(),
+			UnknownFields:     nodeInfo.UnknownFields.Names(),
+		}
+		for _, pubkey := range nodeInfo.MinipoolPubkeys {
+			out.MinipoolPubkeys = append(out.MinipoolPubkeys, pubkey.String())
+		}
+		if nodeInfo.RPLStake != nil {
+			out.RPLStake = nodeInfo.RPLStake.String()
+		}
+		if nodeInfo.Bond != nil {
+			out.Bond = nodeInfo.Bond.String()
+		}
+
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+func validatorHandler(el CacheReader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pubkey, err := rptypes.HexToValidatorPubkey(strings.TrimPrefix(mux.Vars(r)["pubkey"], "0x"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		feeRecipient, err := el.ValidatorFeeRecipient(r.Context(), pubkey, nil)
+		if errors.Is(err, feerecipient.ErrNotMinipool) {
+			writeError(w, http.StatusNotFound, "validator "+pubkey.String()+" is not a known minipool")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, &validatorResponse{
+			Pubkey:         pubkey.String(),
+			Node:           feeRecipient.NodeAddress.String(),
+			SmoothingPool:  feeRecipient.Source == feerecipient.SourceSmoothingPool,
+			FeeRecipient:   feeRecipient.Expected.String(),
+			Source:         feeRecipient.Source.String(),
+			MinipoolStatus: feeRecipient.Status.String(),
+		})
+	}
+}
+
+func cacheStatsHandler(el CacheReader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, err := el.Status(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, status)
+	}
+}
+
+// inconsistentHandler lists the minipools whose node is missing from the node index, which the proxy refuses
+// to validate until the cache is rebuilt. The indexes are cross-checked on demand, so it costs a pass over them.
+func inconsistentHandler(el CacheReader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		minipools, err := el.InconsistentMinipools()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		out := &inconsistentResponse{
+			Count:     len(minipools),
+			Minipools: make([]inconsistentMinipoolResponse, 0, len(minipools)),
+		}
+		for _, minipool := range minipools {
+			out.Minipools = append(out.Minipools, inconsistentMinipoolResponse{
+				Pubkey: minipool.Pubkey.String(),
+				Node:   minipool.NodeAddress.String(),
+			})
+		}
+
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+// exportHandler streams a gzipped json copy of the node and minipool indexes, for "rescue-proxy cache diff".
+// The indexes are copied before any of it is written, so a slow download doesn't hold up the event loop.
+func exportHandler(el CacheReader, version string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		export, err := el.ExportCache()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		export.Version = version
+
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"rescue-proxy-cache-%d.json.gz\"", export.HighestBlock))
+		gz := gzip.NewWriter(w)
+		// Once the body has started, there's no way to report an error but to cut it short
+		if err := json.NewEncoder(gz).Encode(export); err != nil {
+			return
+		}
+		_ = gz.Close()
+	}
+}
+
+// HandleCache serves the contents of the EL cache, to explain fee recipient decisions.
+// It exposes every node's state, so must only be used on a listener which isn't public.
+func (a *AdminApi) HandleCache(el CacheReader) {
+	a.Handle("/admin/node/{address}", nodeHandler(el))
+	a.Handle("/admin/validator/{pubkey}", validatorHandler(el))
+	a.Handle("/admin/cache/stats", cacheStatsHandler(el))
+	a.Handle("/admin/cache/inconsistent", inconsistentHandler(el))
+	a.Handle("/admin/cache/export", exportHandler(el, a.Version))
+}
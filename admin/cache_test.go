@@ -0,0 +1,223 @@
+package admin
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/executionlayer"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+var (
+	testNode           = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	testFeeDistributor = common.HexToAddress("0x2222222222222222222222222222222222222222")
+	testPubkey         = rptypes.BytesToValidatorPubkey(append([]byte{0x01}, make([]byte, rptypes.ValidatorPubkeyLength-1)...))
+)
+
+type fakeCacheReader struct {
+	statusErr    error
+	inconsistent []executionlayer.InconsistentMinipool
+}
+
+func (f *fakeCacheReader) GetNodeInfo(nodeAddr common.Address) (*executionlayer.NodeInfo, error) {
+	if nodeAddr != testNode {
+		return nil, &executionlayer.NotFoundError{}
+	}
+
+	return &executionlayer.NodeInfo{
+		FeeDistributor:  testFeeDistributor,
+		MinipoolPubkeys: []rptypes.ValidatorPubkey{testPubkey},
+		Bond:            big.NewInt(8),
+		UnknownFields:   executionlayer.RPLStakeField,
+	}, nil
+}
+
+func (f *fakeCacheReader) ValidatorFeeRecipient(ctx context.Context, pubkey rptypes.ValidatorPubkey, queryNodeAddr *common.Address) (*feerecipient.Info, error) {
+	if pubkey != testPubkey {
+		return nil, feerecipient.ErrNotMinipool
+	}
+
+	return &feerecipient.Info{
+		Expected:    testFeeDistributor,
+		Source:      feerecipient.SourceFeeDistributor,
+		NodeAddress: testNode,
+		Status:      feerecipient.MinipoolStatusStaking,
+	}, nil
+}
+
+func (f *fakeCacheReader) Status(ctx context.Context) (*executionlayer.Status, error) {
+	if f.statusErr != nil {
+		return nil, f.statusErr
+	}
+
+	return &executionlayer.Status{HighestBlock: 100, Head: 101, Nodes: 1, Minipools: 1}, nil
+}
+
+func (f *fakeCacheReader) InconsistentMinipools() ([]executionlayer.InconsistentMinipool, error) {
+	return f.inconsistent, nil
+}
+
+func (f *fakeCacheReader) ExportCache() (*executionlayer.CacheExport, error) {
+	return &executionlayer.CacheExport{
+		HighestBlock: 100,
+		Nodes:        map[common.Address]executionlayer.ExportNode{testNode: {FeeDistributor: testFeeDistributor}},
+		Minipools:    map[string]common.Address{testPubkey.String(): testNode},
+	}, nil
+}
+
+func get(t *testing.T, a *AdminApi, path string, body any) int {
+	w := httptest.NewRecorder()
+	a.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("%s: expected json, got %q", path, ct)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), body); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return w.Code
+}
+
+func TestNodeHandler(t *testing.T) {
+	a := &AdminApi{}
+	a.Init("")
+	a.HandleCache(&fakeCacheReader{})
+
+	var node nodeResponse
+	if code := get(t, a, "/admin/node/"+testNode.String(), &node); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if node.FeeDistributor != testFeeDistributor.String() || node.SmoothingPool {
+		t.Fatalf("unexpected node %+v", node)
+	}
+	if len(node.MinipoolPubkeys) != 1 || node.MinipoolPubkeys[0] != testPubkey.String() {
+		t.Fatalf("unexpected minipools %v", node.MinipoolPubkeys)
+	}
+	if node.Bond != "8" || node.RPLStake != "" || len(node.UnknownFields) != 1 || node.UnknownFields[0] != "rpl_stake" {
+		t.Fatalf("unexpected enrichment fields %+v", node)
+	}
+
+	var e errorResponse
+	if code := get(t, a, "/admin/node/0x3333333333333333333333333333333333333333", &e); code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", code)
+	}
+	if code := get(t, a, "/admin/node/not-an-address", &e); code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", code)
+	}
+	if e.Error == "" {
+		t.Fatal("expected an error message")
+	}
+}
+
+func TestValidatorHandler(t *testing.T) {
+	a := &AdminApi{}
+	a.Init("")
+	a.HandleCache(&fakeCacheReader{})
+
+	// Pubkeys are accepted with or without a 0x prefix
+	for _, pubkey := range []string{testPubkey.String(), "0x" + testPubkey.String()} {
+		var validator validatorResponse
+		if code := get(t, a, "/admin/validator/"+pubkey, &validator); code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+		if validator.Node != testNode.String() || validator.FeeRecipient != testFeeDistributor.String() || validator.SmoothingPool ||
+			validator.Source != "fee_distributor" || validator.MinipoolStatus != "staking" {
+			t.Fatalf("unexpected validator %+v", validator)
+		}
+	}
+
+	var e errorResponse
+	unknown := rptypes.BytesToValidatorPubkey(make([]byte, rptypes.ValidatorPubkeyLength))
+	if code := get(t, a, "/admin/validator/"+unknown.String(), &e); code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", code)
+	}
+	if code := get(t, a, "/admin/validator/0x1234", &e); code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", code)
+	}
+}
+
+func TestCacheStatsHandler(t *testing.T) {
+	el := &fakeCacheReader{}
+	a := &AdminApi{}
+	a.Init("")
+	a.HandleCache(el)
+
+	var status executionlayer.Status
+	if code := get(t, a, "/admin/cache/stats", &status); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if status.HighestBlock != 100 || status.Nodes != 1 || status.Minipools != 1 {
+		t.Fatalf("unexpected stats %+v", status)
+	}
+
+	el.statusErr = fmt.Errorf("connection refused")
+	var e errorResponse
+	if code := get(t, a, "/admin/cache/stats", &e); code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", code)
+	}
+}
+
+func TestInconsistentHandler(t *testing.T) {
+	el := &fakeCacheReader{}
+	a := &AdminApi{}
+	a.Init("")
+	a.HandleCache(el)
+
+	var inconsistent inconsistentResponse
+	if code := get(t, a, "/admin/cache/inconsistent", &inconsistent); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if inconsistent.Count != 0 || inconsistent.Minipools == nil {
+		t.Fatalf("expected an empty list, got %+v", inconsistent)
+	}
+
+	el.inconsistent = []executionlayer.InconsistentMinipool{{Pubkey: testPubkey, NodeAddress: testNode}}
+	if code := get(t, a, "/admin/cache/inconsistent", &inconsistent); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	expected := inconsistentMinipoolResponse{Pubkey: testPubkey.String(), Node: testNode.String()}
+	if inconsistent.Count != 1 || len(inconsistent.Minipools) != 1 || inconsistent.Minipools[0] != expected {
+		t.Fatalf("unexpected inconsistent minipools %+v", inconsistent)
+	}
+}
+
+func TestExportHandler(t *testing.T) {
+	a := &AdminApi{Version: "v1.2.3"}
+	a.Init("")
+	a.HandleCache(&fakeCacheReader{})
+
+	w := httptest.NewRecorder()
+	a.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/cache/export", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/gzip" {
+		t.Fatalf("expected gzip, got %q", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="rescue-proxy-cache-100.json.gz"` {
+		t.Fatalf("unexpected Content-Disposition %q", cd)
+	}
+
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var export executionlayer.CacheExport
+	if err := json.NewDecoder(gz).Decode(&export); err != nil {
+		t.Fatal(err)
+	}
+	if export.Version != "v1.2.3" || export.HighestBlock != 100 {
+		t.Fatalf("unexpected export %+v", export)
+	}
+	if export.Nodes[testNode].FeeDistributor != testFeeDistributor || export.Minipools[testPubkey.String()] != testNode {
+		t.Fatalf("unexpected indexes %+v", export)
+	}
+}
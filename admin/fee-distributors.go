@@ -0,0 +1,37 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/executionlayer"
+)
+
+// FeeDistributorRefetcher re-reads every cached node's fee distributor address from the chain
+type FeeDistributorRefetcher interface {
+	RefetchFeeDistributors(ctx context.Context) (*executionlayer.FeeDistributorCheck, error)
+}
+
+func feeDistributorRefetchHandler(refetcher FeeDistributorRefetcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "expected POST")
+			return
+		}
+
+		check, err := refetcher.RefetchFeeDistributors(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, check)
+	}
+}
+
+// HandleFeeDistributorRefetch serves an endpoint to refetch every cached fee distributor address
+// and repair any which changed, without restarting the proxy.
+// Like HandleCache, it must only be used on a listener which isn't public.
+func (a *AdminApi) HandleFeeDistributorRefetch(refetcher FeeDistributorRefetcher) {
+	a.Handle("/admin/cache/fee-distributors/refetch", feeDistributorRefetchHandler(refetcher))
+}
@@ -0,0 +1,62 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/executionlayer"
+)
+
+type fakeRefetcher struct {
+	check     *executionlayer.FeeDistributorCheck
+	err       error
+	refetches int
+}
+
+func (f *fakeRefetcher) RefetchFeeDistributors(ctx context.Context) (*executionlayer.FeeDistributorCheck, error) {
+	f.refetches++
+	return f.check, f.err
+}
+
+func TestFeeDistributorRefetchHandler(t *testing.T) {
+	refetcher := &fakeRefetcher{
+		check: &executionlayer.FeeDistributorCheck{Block: 100, Checked: 10, Mismatched: 1, Repaired: 1},
+	}
+	a := &AdminApi{}
+	a.Init("")
+	a.HandleFeeDistributorRefetch(refetcher)
+
+	request := func(method string) (int, *executionlayer.FeeDistributorCheck) {
+		w := httptest.NewRecorder()
+		a.Handler.ServeHTTP(w, httptest.NewRequest(method, "/admin/cache/fee-distributors/refetch", nil))
+
+		var out executionlayer.FeeDistributorCheck
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w.Code, &out
+	}
+
+	if code, _ := request(http.MethodGet); code != http.StatusMethodNotAllowed || refetcher.refetches != 0 {
+		t.Fatalf("expected GET to be refused without refetching, got %d after %d refetches", code, refetcher.refetches)
+	}
+
+	code, out := request(http.MethodPost)
+	if code != http.StatusOK || *out != *refetcher.check {
+		t.Fatalf("expected the check to be reported, got %d %+v", code, out)
+	}
+
+	refetcher.err = errors.New("ec unavailable")
+	if code, _ := request(http.MethodPost); code != http.StatusInternalServerError {
+		t.Fatalf("expected a failed refetch to be reported, got %d", code)
+	}
+	if refetcher.refetches != 2 {
+		t.Fatalf("expected 2 refetches, got %d", refetcher.refetches)
+	}
+}
@@ -0,0 +1,58 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// DebugLogging switches the proxy's logs to debug level for a while
+type DebugLogging interface {
+	DebugFor(d time.Duration) time.Time
+	StopDebug()
+	DebugUntil() time.Time
+}
+
+type debugLoggingResponse struct {
+	Debug bool `json:"debug"`
+	// When debug logging stops, if it's on
+	Until *time.Time `json:"until,omitempty"`
+}
+
+func debugLoggingHandler(logging DebugLogging, maxDuration time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			d := maxDuration
+			if s := r.URL.Query().Get("duration"); s != "" {
+				var err error
+				d, err = time.ParseDuration(s)
+				if err != nil || d <= 0 || d > maxDuration {
+					writeError(w, http.StatusBadRequest, fmt.Sprintf("expected a duration up to %v", maxDuration))
+					return
+				}
+			}
+			logging.DebugFor(d)
+		case http.MethodDelete:
+			logging.StopDebug()
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "expected GET, POST or DELETE")
+			return
+		}
+
+		out := &debugLoggingResponse{}
+		if until := logging.DebugUntil(); !until.IsZero() {
+			out.Debug = true
+			out.Until = &until
+		}
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+// HandleDebugLogging serves an endpoint to turn debug logging on for up to maxDuration, like SIGUSR1 does.
+// GET reports whether it's on, POST turns it on for ?duration=, or maxDuration, and DELETE turns it back off.
+// Like HandleCache, it must only be used on a listener which isn't public.
+func (a *AdminApi) HandleDebugLogging(logging DebugLogging, maxDuration time.Duration) {
+	a.Handle("/admin/logging/debug", debugLoggingHandler(logging, maxDuration))
+}
@@ -0,0 +1,73 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeDebugLogging struct {
+	until time.Time
+	now   time.Time
+}
+
+func (f *fakeDebugLogging) DebugFor(d time.Duration) time.Time {
+	f.until = f.now.Add(d)
+	return f.until
+}
+
+func (f *fakeDebugLogging) StopDebug() {
+	f.until = time.Time{}
+}
+
+func (f *fakeDebugLogging) DebugUntil() time.Time {
+	return f.until
+}
+
+func TestDebugLoggingHandler(t *testing.T) {
+	logging := &fakeDebugLogging{now: time.Unix(1700000000, 0).UTC()}
+	a := &AdminApi{}
+	a.Init("")
+	a.HandleDebugLogging(logging, time.Hour)
+
+	request := func(method string, query string) (int, *debugLoggingResponse) {
+		w := httptest.NewRecorder()
+		a.Handler.ServeHTTP(w, httptest.NewRequest(method, "/admin/logging/debug"+query, nil))
+
+		var out debugLoggingResponse
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w.Code, &out
+	}
+
+	if code, out := request(http.MethodGet, ""); code != http.StatusOK || out.Debug || out.Until != nil {
+		t.Fatalf("expected debug logging to be off, got %d %+v", code, out)
+	}
+
+	code, out := request(http.MethodPost, "?duration=10m")
+	if code != http.StatusOK || !out.Debug || !out.Until.Equal(logging.now.Add(10*time.Minute)) {
+		t.Fatalf("expected debug logging for 10 minutes, got %d %+v", code, out)
+	}
+
+	// Without a duration, the longest is used, and longer ones are refused
+	if code, out := request(http.MethodPost, ""); code != http.StatusOK || !out.Until.Equal(logging.now.Add(time.Hour)) {
+		t.Fatalf("expected debug logging for an hour, got %d %+v", code, out)
+	}
+	for _, query := range []string{"?duration=2h", "?duration=-1m", "?duration=soon"} {
+		if code, _ := request(http.MethodPost, query); code != http.StatusBadRequest {
+			t.Fatalf("expected %s to be refused, got %d", query, code)
+		}
+	}
+
+	if code, out := request(http.MethodDelete, ""); code != http.StatusOK || out.Debug {
+		t.Fatalf("expected debug logging to be turned off, got %d %+v", code, out)
+	}
+	if code, _ := request(http.MethodPut, ""); code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected PUT to be refused, got %d", code)
+	}
+}
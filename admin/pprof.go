@@ -0,0 +1,20 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/gorilla/mux"
+)
+
+// HandlePprof serves the runtime profiles under /debug/pprof/, for `go tool pprof`.
+// Profiles reveal the process's internals, and can be expensive to take, so it must only be used on a listener which isn't public.
+func (a *AdminApi) HandlePprof() {
+	a.Handle("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
+	a.Handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
+	a.Handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+	a.Handle("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+
+	// The index serves the named profiles, like /debug/pprof/heap, as well as the list of them
+	a.Handler.(*mux.Router).PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
+}
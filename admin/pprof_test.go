@@ -0,0 +1,35 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPprofHandlers(t *testing.T) {
+	a := &AdminApi{}
+	a.Init("127.0.0.1:0")
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		a.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	// Nothing is served unless it's asked for
+	if w := serve("/debug/pprof/"); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 before HandlePprof, got %d", w.Code)
+	}
+
+	a.HandlePprof()
+	if w := serve("/debug/pprof/"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "heap") {
+		t.Fatalf("expected the profile index, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve("/debug/pprof/heap?debug=1"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "heap profile") {
+		t.Fatalf("expected a heap profile, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve("/debug/pprof/cmdline"); w.Code != http.StatusOK {
+		t.Fatalf("expected the command line, got %d", w.Code)
+	}
+}
@@ -0,0 +1,38 @@
+package admin
+
+import (
+	"net/http"
+)
+
+// Reloader re-reads the proxy's reloadable settings, and returns a description of each one which changed
+type Reloader func() ([]string, error)
+
+type reloadResponse struct {
+	Changed []string `json:"changed"`
+}
+
+func reloadHandler(reload Reloader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "expected POST")
+			return
+		}
+
+		changed, err := reload()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if changed == nil {
+			changed = []string{}
+		}
+		writeJSON(w, http.StatusOK, &reloadResponse{Changed: changed})
+	}
+}
+
+// HandleReload serves an endpoint to reload the proxy's settings, like SIGHUP does.
+// Like HandleCache, it must only be used on a listener which isn't public.
+func (a *AdminApi) HandleReload(reload Reloader) {
+	a.Handle("/admin/reload", reloadHandler(reload))
+}
@@ -0,0 +1,57 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReloadHandler(t *testing.T) {
+	var changed []string
+	var err error
+	reloads := 0
+	a := &AdminApi{}
+	a.Init("")
+	a.HandleReload(func() ([]string, error) {
+		reloads++
+		return changed, err
+	})
+
+	request := func(method string) (int, *reloadResponse) {
+		w := httptest.NewRecorder()
+		a.Handler.ServeHTTP(w, httptest.NewRequest(method, "/admin/reload", nil))
+
+		var out reloadResponse
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w.Code, &out
+	}
+
+	if code, _ := request(http.MethodGet); code != http.StatusMethodNotAllowed || reloads != 0 {
+		t.Fatalf("expected GET to be refused without reloading, got %d after %d reloads", code, reloads)
+	}
+
+	code, out := request(http.MethodPost)
+	if code != http.StatusOK || out.Changed == nil || len(out.Changed) != 0 {
+		t.Fatalf("expected an empty list of changes, got %d %+v", code, out)
+	}
+
+	changed = []string{"unknown_validator_policy: allow -> deny"}
+	code, out = request(http.MethodPost)
+	if code != http.StatusOK || len(out.Changed) != 1 || out.Changed[0] != changed[0] {
+		t.Fatalf("expected the change to be reported, got %d %+v", code, out)
+	}
+
+	err = errors.New("invalid settings")
+	if code, _ := request(http.MethodPost); code != http.StatusInternalServerError {
+		t.Fatalf("expected a failed reload to be reported, got %d", code)
+	}
+	if reloads != 3 {
+		t.Fatalf("expected 3 reloads, got %d", reloads)
+	}
+}
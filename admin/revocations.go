@@ -0,0 +1,92 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/mux"
+)
+
+// Revocations is the list of revoked credentials the revocation endpoints manage
+type Revocations interface {
+	List() ([]common.Address, []string)
+	Revoke(entry string) error
+	Unrevoke(entry string) error
+	Reload() error
+}
+
+type revocationsResponse struct {
+	Nodes         []string `json:"nodes"`
+	CredentialIDs []string `json:"credential_ids"`
+}
+
+func listRevocations(w http.ResponseWriter, revocations Revocations) {
+	nodes, ids := revocations.List()
+	out := &revocationsResponse{
+		Nodes:         make([]string, 0, len(nodes)),
+		CredentialIDs: ids,
+	}
+	for _, node := range nodes {
+		out.Nodes = append(out.Nodes, node.String())
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+func revocationsHandler(revocations Revocations) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "expected GET")
+			return
+		}
+
+		listRevocations(w, revocations)
+	}
+}
+
+func revocationHandler(revocations Revocations) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry := mux.Vars(r)["entry"]
+
+		var err error
+		switch r.Method {
+		case http.MethodPut:
+			err = revocations.Revoke(entry)
+		case http.MethodDelete:
+			err = revocations.Unrevoke(entry)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "expected PUT or DELETE")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		listRevocations(w, revocations)
+	}
+}
+
+func reloadRevocationsHandler(revocations Revocations) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "expected POST")
+			return
+		}
+
+		if err := revocations.Reload(); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		listRevocations(w, revocations)
+	}
+}
+
+// HandleRevocations serves endpoints to list, add and remove revoked node addresses and credential IDs,
+// and to reload them from disk. Like HandleCache, it must only be used on a listener which isn't public.
+func (a *AdminApi) HandleRevocations(revocations Revocations) {
+	a.Handle("/admin/revocations", revocationsHandler(revocations))
+	a.Handle("/admin/revocations/reload", reloadRevocationsHandler(revocations))
+	a.Handle("/admin/revocations/{entry}", revocationHandler(revocations))
+}
@@ -0,0 +1,81 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/auth"
+)
+
+func revocationRequest(t *testing.T, a *AdminApi, method, path string) (int, *revocationsResponse) {
+	w := httptest.NewRecorder()
+	a.Handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+
+	var out revocationsResponse
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return w.Code, &out
+}
+
+func TestRevocationHandlers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoked")
+	list, err := auth.LoadRevocationList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &AdminApi{}
+	a.Init("")
+	a.HandleRevocations(list)
+
+	if code, _ := revocationRequest(t, a, http.MethodPut, "/admin/revocations/"+testNode.String()); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	code, revocations := revocationRequest(t, a, http.MethodPut, "/admin/revocations/0x1111111111111111111111111111111111111111:1700000000")
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(revocations.Nodes) != 1 || revocations.Nodes[0] != testNode.String() ||
+		len(revocations.CredentialIDs) != 1 || revocations.CredentialIDs[0] != "0x1111111111111111111111111111111111111111:1700000000" {
+		t.Fatalf("unexpected revocations %+v", revocations)
+	}
+
+	// Changes are saved, so they survive a restart
+	reloaded, err := auth.LoadRevocationList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodes, ids := reloaded.List(); len(nodes) != 1 || len(ids) != 1 {
+		t.Fatalf("expected the revocations to be saved, got %v %v", nodes, ids)
+	}
+
+	if code, revocations = revocationRequest(t, a, http.MethodDelete, "/admin/revocations/"+testNode.String()); code != http.StatusOK || len(revocations.Nodes) != 0 {
+		t.Fatalf("expected the node to be unrevoked, got %d %+v", code, revocations)
+	}
+
+	// Edits to the file are picked up by a reload
+	if err := os.WriteFile(path, []byte(testFeeDistributor.String()+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	code, revocations = revocationRequest(t, a, http.MethodPost, "/admin/revocations/reload")
+	if code != http.StatusOK || len(revocations.Nodes) != 1 || revocations.Nodes[0] != testFeeDistributor.String() || len(revocations.CredentialIDs) != 0 {
+		t.Fatalf("expected the file to be reloaded, got %d %+v", code, revocations)
+	}
+
+	if code, _ := revocationRequest(t, a, http.MethodGet, "/admin/revocations"); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if code, _ := revocationRequest(t, a, http.MethodPost, "/admin/revocations"); code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", code)
+	}
+	if code, _ := revocationRequest(t, a, http.MethodPut, "/admin/revocations/%23comment"); code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", code)
+	}
+}
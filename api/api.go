@@ -2,68 +2,363 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"net"
+	"os"
 
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/auth"
 	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/executionlayer"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/guarded"
+	_ "github.com/Rocket-Pool-Rescue-Node/rescue-proxy/internal/compression"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/internal/listen"
 	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
 	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/pb"
 	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 type API struct {
 	pb.UnimplementedApiServer
-	EL         *executionlayer.ExecutionLayer
+	EL         executionlayer.RPInfoProvider
 	Logger     *zap.Logger
 	ListenAddr string
+	// The permissions of ListenAddr's socket file, if it's a unix socket
+	SocketMode os.FileMode
 	listener   net.Listener
 	server     *grpc.Server
 	m          *metrics.MetricsRegistry
+
+	// Leave CertFile blank to listen in plaintext.
+	// If ClientCAFile is set, clients must present a certificate signed by one of its CAs.
+	TLS struct {
+		CertFile     string
+		KeyFile      string
+		ClientCAFile string
+	}
+
+	// Used by the admin RPCs to issue and introspect credentials. They're refused if Credentials is nil.
+	// Revocations is optional.
+	Credentials *auth.HMACVerifier
+	Revocations *auth.RevocationList
+	// If set, admin RPCs require it as a bearer token in the authorization metadata. Otherwise, they're only
+	// served if TLS.ClientCAFile is set, so every client has a trusted certificate.
+	AdminToken string
+
+	// Streamed to admins by StreamGuardedRequests, which is refused if it's nil
+	GuardedRequests *guarded.Feed
+
+	// Closed by Deinit() to end any streams, which would otherwise block GracefulStop()
+	done chan struct{}
 }
 
-func NewAPI(listenAddr string, el *executionlayer.ExecutionLayer, logger *zap.Logger) *API {
+func NewAPI(listenAddr string, el executionlayer.RPInfoProvider, logger *zap.Logger) *API {
 	out := &API{
 		EL:         el,
 		Logger:     logger,
 		ListenAddr: listenAddr,
 		m:          metrics.NewMetricsRegistry("api"),
+		done:       make(chan struct{}),
 	}
 
 	return out
 }
 
 func (a *API) GetRocketPoolNodes(ctx context.Context, request *pb.RocketPoolNodesRequest) (*pb.RocketPoolNodes, error) {
-	out := &pb.RocketPoolNodes{}
-	out.NodeIds = make([][]byte, 0, 1024)
+	snapshot := a.EL.SnapshotNodes()
+	out := &pb.RocketPoolNodes{
+		NodeIds: make([][]byte, 0, len(snapshot.Nodes)),
+		Block:   snapshot.Block,
+	}
+
+	if request.GetIncludeDetails() {
+		out.Nodes = make([]*pb.RocketPoolNode, 0, len(snapshot.Nodes))
+	}
+	for _, node := range snapshot.Nodes {
+		out.NodeIds = append(out.NodeIds, node.Address.Bytes())
+		if request.GetIncludeDetails() {
+			out.Nodes = append(out.Nodes, &pb.RocketPoolNode{
+				NodeId:         node.Address.Bytes(),
+				SmoothingPool:  node.InSmoothingPool,
+				FeeDistributor: node.FeeDistributor.Bytes(),
+			})
+		}
+	}
+
+	a.m.Counter("get_rocket_pool_nodes_ok").Inc()
+	return out, nil
+}
 
-	err := a.EL.ForEachNode(func(addr common.Address) bool {
-		out.NodeIds = append(out.NodeIds, addr.Bytes())
-		return true
-	})
+func (a *API) GetValidatorFeeRecipient(ctx context.Context, request *pb.ValidatorFeeRecipientRequest) (*pb.ValidatorFeeRecipient, error) {
+	if len(request.Pubkey) != len(rptypes.ValidatorPubkey{}) {
+		a.m.Counter("get_validator_fee_recipient_invalid").Inc()
+		return nil, status.Errorf(codes.InvalidArgument, "pubkey must be %d bytes, got %d", len(rptypes.ValidatorPubkey{}), len(request.Pubkey))
+	}
+	pubkey := rptypes.BytesToValidatorPubkey(request.Pubkey)
 
+	feeRecipient, err := a.EL.ValidatorFeeRecipient(ctx, pubkey, nil)
 	if err != nil {
-		a.m.Counter("get_rocket_pool_nodes_error").Inc()
+		if errors.Is(err, feerecipient.ErrNotMinipool) {
+			a.m.Counter("get_validator_fee_recipient_not_found").Inc()
+			return nil, status.Errorf(codes.NotFound, "validator %s is not a known minipool", pubkey.String())
+		}
+
+		a.m.Counter("get_validator_fee_recipient_error").Inc()
+		requestLogger(ctx, a.Logger).Warn("Error reading validator from the EL cache", zap.String("pubkey", pubkey.String()), zap.Error(err))
 		return nil, err
 	}
 
-	a.m.Counter("get_rocket_pool_nodes_ok").Inc()
+	a.m.Counter("get_validator_fee_recipient_ok").Inc()
+	return &pb.ValidatorFeeRecipient{
+		FeeRecipient:  feeRecipient.Expected.Bytes(),
+		SmoothingPool: feeRecipient.Source == feerecipient.SourceSmoothingPool,
+		NodeId:        feeRecipient.NodeAddress.Bytes(),
+	}, nil
+}
+
+func (a *API) GetNodeInfo(ctx context.Context, request *pb.NodeInfoRequest) (*pb.NodeInfo, error) {
+	if len(request.NodeId) != common.AddressLength {
+		a.m.Counter("get_node_info_invalid").Inc()
+		return nil, status.Errorf(codes.InvalidArgument, "node_id must be %d bytes, got %d", common.AddressLength, len(request.NodeId))
+	}
+	nodeAddr := common.BytesToAddress(request.NodeId)
+
+	nodeInfo, err := a.EL.GetNodeInfo(nodeAddr)
+	if err != nil {
+		if _, ok := err.(*executionlayer.NotFoundError); ok {
+			a.m.Counter("get_node_info_not_found").Inc()
+			return nil, status.Errorf(codes.NotFound, "node %s is not a known rocket pool node", nodeAddr.String())
+		}
+
+		a.m.Counter("get_node_info_error").Inc()
+		requestLogger(ctx, a.Logger).Warn("Error reading node from the EL cache", zap.String("node", nodeAddr.String()), zap.Error(err))
+		return nil, err
+	}
+
+	out := &pb.NodeInfo{
+		SmoothingPool:   nodeInfo.InSmoothingPool,
+		FeeDistributor:  nodeInfo.FeeDistributor.Bytes(),
+		MinipoolPubkeys: make([][]byte, 0, len(nodeInfo.MinipoolPubkeys)),
+	}
+	for _, pubkey := range nodeInfo.MinipoolPubkeys {
+		out.MinipoolPubkeys = append(out.MinipoolPubkeys, pubkey.Bytes())
+	}
+
+	a.m.Counter("get_node_info_ok").Inc()
 	return out, nil
 }
 
+func (a *API) GetNodeMinipools(ctx context.Context, request *pb.NodeMinipoolsRequest) (*pb.NodeMinipools, error) {
+	if len(request.NodeId) != common.AddressLength {
+		a.m.Counter("get_node_minipools_invalid").Inc()
+		return nil, status.Errorf(codes.InvalidArgument, "node_id must be %d bytes, got %d", common.AddressLength, len(request.NodeId))
+	}
+	nodeAddr := common.BytesToAddress(request.NodeId)
+
+	minipools, err := a.EL.GetNodeMinipools(nodeAddr)
+	if err != nil {
+		if _, ok := err.(*executionlayer.NotFoundError); ok {
+			a.m.Counter("get_node_minipools_not_found").Inc()
+			return nil, status.Errorf(codes.NotFound, "node %s is not a known rocket pool node", nodeAddr.String())
+		}
+
+		a.m.Counter("get_node_minipools_error").Inc()
+		requestLogger(ctx, a.Logger).Warn("Error reading node minipools from the EL cache", zap.String("node", nodeAddr.String()), zap.Error(err))
+		return nil, err
+	}
+
+	out := &pb.NodeMinipools{
+		Pubkeys: make([][]byte, 0, len(minipools.Pubkeys)),
+		Block:   minipools.Block,
+	}
+	for _, pubkey := range minipools.Pubkeys {
+		out.Pubkeys = append(out.Pubkeys, pubkey.Bytes())
+	}
+
+	a.m.Counter("get_node_minipools_ok").Inc()
+	return out, nil
+}
+
+func (a *API) GetSmoothingPoolInfo(ctx context.Context, request *pb.SmoothingPoolInfoRequest) (*pb.SmoothingPoolInfo, error) {
+	info, err := a.EL.SmoothingPoolInfo()
+	if err != nil {
+		if _, ok := err.(*executionlayer.NotFoundError); ok {
+			a.m.Counter("get_smoothing_pool_info_unavailable").Inc()
+			return nil, status.Error(codes.Unavailable, "the smoothing pool isn't known until the EL cache has warmed up")
+		}
+
+		a.m.Counter("get_smoothing_pool_info_error").Inc()
+		requestLogger(ctx, a.Logger).Warn("Error reading smoothing pool info from the EL cache", zap.Error(err))
+		return nil, err
+	}
+
+	a.m.Counter("get_smoothing_pool_info_ok").Inc()
+	return &pb.SmoothingPoolInfo{
+		Address:      info.Address.Bytes(),
+		OptedInNodes: uint64(info.OptedInNodes),
+		Nodes:        uint64(info.Nodes),
+		Block:        info.Block,
+	}, nil
+}
+
+var nodeEventTypes = map[executionlayer.NodeEventType]pb.RocketPoolNodeEvent_Type{
+	executionlayer.NodeRegistered:                 pb.RocketPoolNodeEvent_NODE_REGISTERED,
+	executionlayer.NodeSmoothingPoolStatusChanged: pb.RocketPoolNodeEvent_SMOOTHING_POOL_STATUS_CHANGED,
+	executionlayer.NodeRegistrationReverted:       pb.RocketPoolNodeEvent_NODE_REGISTRATION_REVERTED,
+}
+
+func (a *API) StreamRocketPoolNodeEvents(request *pb.RocketPoolNodeEventsRequest, stream pb.Api_StreamRocketPoolNodeEventsServer) error {
+	events, unsubscribe := a.EL.SubscribeNodeEvents()
+	defer unsubscribe()
+
+	a.m.Counter("stream_rocket_pool_node_events_started").Inc()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-a.done:
+			return status.Error(codes.Unavailable, "the server is shutting down")
+		case event, ok := <-events:
+			if !ok {
+				// The stream fell too far behind, and events were dropped
+				a.m.Counter("stream_rocket_pool_node_events_aborted").Inc()
+				return status.Error(codes.Aborted, "node events were dropped, resync with GetRocketPoolNodes and subscribe again")
+			}
+
+			err := stream.Send(&pb.RocketPoolNodeEvent{
+				Type:          nodeEventTypes[event.Type],
+				NodeId:        event.NodeAddress.Bytes(),
+				SmoothingPool: event.InSmoothingPool,
+				Block:         event.Block,
+			})
+			if err != nil {
+				return err
+			}
+			a.m.Counter("stream_rocket_pool_node_events_sent").Inc()
+		}
+	}
+}
+
+// StreamGuardedRequests sends the proxy's decision about every guarded request from now on.
+// It's best-effort: if the stream falls behind, requests are dropped rather than holding up the proxy.
+func (a *API) StreamGuardedRequests(request *pb.GuardedRequestsRequest, stream pb.Api_StreamGuardedRequestsServer) error {
+	if a.GuardedRequests == nil {
+		return status.Error(codes.Unimplemented, "guarded requests aren't published by this proxy")
+	}
+
+	requests, unsubscribe := a.GuardedRequests.Subscribe()
+	defer unsubscribe()
+
+	a.m.Counter("stream_guarded_requests_started").Inc()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-a.done:
+			return status.Error(codes.Unavailable, "the server is shutting down")
+		case request := <-requests:
+			err := stream.Send(&pb.GuardedRequest{
+				TimestampMs: request.Time.UnixMilli(),
+				NodeId:      request.NodeAddress.Bytes(),
+				Endpoint:    request.Endpoint,
+				Validators:  uint32(request.Validators),
+				Accepted:    request.Accepted,
+				Reason:      request.Reason,
+			})
+			if err != nil {
+				return err
+			}
+			a.m.Counter("stream_guarded_requests_sent").Inc()
+		}
+	}
+}
+
+// loggingCredentials logs failed handshakes, which grpc otherwise only logs through its own logger
+type loggingCredentials struct {
+	credentials.TransportCredentials
+	logger *zap.Logger
+	m      *metrics.MetricsRegistry
+}
+
+func (l *loggingCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	out, info, err := l.TransportCredentials.ServerHandshake(conn)
+	if err != nil {
+		l.m.Counter("tls_handshake_failed").Inc()
+		l.logger.Warn("TLS handshake with gRPC API client failed", zap.String("peer", conn.RemoteAddr().String()), zap.Error(err))
+	}
+	return out, info, err
+}
+
+func (a *API) transportCredentials() (credentials.TransportCredentials, error) {
+
+	if a.TLS.CertFile == "" {
+		return insecure.NewCredentials(), nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(a.TLS.CertFile, a.TLS.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if a.TLS.ClientCAFile != "" {
+		pem, err := os.ReadFile(a.TLS.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", a.TLS.ClientCAFile)
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return &loggingCredentials{
+		TransportCredentials: credentials.NewTLS(config),
+		logger:               a.Logger,
+		m:                    a.m,
+	}, nil
+}
+
 func (a *API) Init() error {
 	var err error
 
-	a.listener, err = net.Listen("tcp", a.ListenAddr)
+	tc, err := a.transportCredentials()
+	if err != nil {
+		return err
+	}
+
+	a.listener, err = listen.Listen(a.ListenAddr, a.SocketMode)
 	if err != nil {
 		return err
 	}
 
-	a.server = grpc.NewServer()
+	// Responses are compressed with whichever registered compressor the call was, and left alone otherwise,
+	// so clients opt in per call. See the compression package for which are registered.
+	a.server = grpc.NewServer(grpc.Creds(tc),
+		grpc.ChainUnaryInterceptor(otelgrpc.UnaryServerInterceptor(), a.metricsInterceptor(), a.requestIDInterceptor(), a.adminInterceptor()),
+		grpc.ChainStreamInterceptor(otelgrpc.StreamServerInterceptor(), a.metricsStreamInterceptor(), a.adminStreamInterceptor()))
 
 	pb.RegisterApiServer(a.server, a)
 
-	a.Logger.Info("Starting grpc server", zap.String("url", a.ListenAddr))
+	a.Logger.Info("Starting grpc server", zap.String("url", a.ListenAddr), zap.Bool("tls", a.TLS.CertFile != ""), zap.Bool("mtls", a.TLS.ClientCAFile != ""))
 	go func() {
 		if err := a.server.Serve(a.listener); err != nil {
 			a.Logger.Panic("gRPC server stopped", zap.Error(err))
@@ -74,6 +369,26 @@ func (a *API) Init() error {
 }
 
 func (a *API) Deinit() {
-	a.server.GracefulStop()
+	a.Shutdown(context.Background())
+}
+
+// Shutdown stops accepting new requests, and waits for in-flight ones to finish until ctx is done,
+// at which point any that remain are cancelled.
+func (a *API) Shutdown(ctx context.Context) {
+	close(a.done)
+
+	stopped := make(chan struct{})
+	go func() {
+		a.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		a.Logger.Warn("Timed out draining gRPC API requests")
+		a.server.Stop()
+		<-stopped
+	}
 	a.listener.Close()
 }
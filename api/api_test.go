@@ -0,0 +1,439 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/guarded"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/internal/mocks"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/pb"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	// Paths to the PEM encoded certificate and key
+	certFile string
+	keyFile  string
+}
+
+// newTestCert creates a certificate signed by parent, or a self-signed CA if parent is nil
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		// What clients of unix sockets expect
+		DNSNames: []string{"localhost"},
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(t.TempDir(), name+".crt"),
+		keyFile:  filepath.Join(t.TempDir(), name+".key"),
+	}
+	if err := os.WriteFile(out.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(out.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// setup starts an API, after letting each of configure change it
+func setup(t *testing.T, server *testCert, clientCA *testCert, configure ...func(*API)) (*API, *observer.ObservedLogs, func()) {
+	_, err := metrics.Init("api_test_" + t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	core, logs := observer.New(zap.WarnLevel)
+	a := NewAPI("127.0.0.1:0", nil, zap.New(core))
+	a.TLS.CertFile = server.certFile
+	a.TLS.KeyFile = server.keyFile
+	if clientCA != nil {
+		a.TLS.ClientCAFile = clientCA.certFile
+	}
+	for _, f := range configure {
+		f(a)
+	}
+
+	if err := a.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	return a, logs, func() {
+		a.Deinit()
+		metrics.Deinit()
+	}
+}
+
+// call makes a request which reaches the handler without needing an ExecutionLayer
+func call(t *testing.T, a *API, tc credentials.TransportCredentials) error {
+	target := a.listener.Addr().String()
+	if a.listener.Addr().Network() == "unix" {
+		target = "unix://" + target
+	}
+	conn, err := grpc.Dial(target, grpc.WithTransportCredentials(tc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = pb.NewApiClient(conn).GetValidatorFeeRecipient(ctx, &pb.ValidatorFeeRecipientRequest{})
+	return err
+}
+
+func clientCredentials(ca *testCert, client *testCert) credentials.TransportCredentials {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	config := &tls.Config{RootCAs: pool}
+	if client != nil {
+		config.Certificates = []tls.Certificate{{
+			Certificate: [][]byte{client.cert.Raw},
+			PrivateKey:  client.key,
+		}}
+	}
+	return credentials.NewTLS(config)
+}
+
+func expectHandled(t *testing.T, err error) {
+	t.Helper()
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected the request to reach the handler, got %v", err)
+	}
+}
+
+func expectRejected(t *testing.T, err error) {
+	t.Helper()
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected the connection to be rejected, got %v", err)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	server := newTestCert(t, "server", ca)
+
+	a, _, teardown := setup(t, server, nil)
+	defer teardown()
+
+	expectHandled(t, call(t, a, clientCredentials(ca, nil)))
+	expectRejected(t, call(t, a, insecure.NewCredentials()))
+}
+
+func TestUnixSocket(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	server := newTestCert(t, "server", ca)
+	path := filepath.Join(t.TempDir(), "api.sock")
+
+	a, _, teardown := setup(t, server, nil, func(a *API) {
+		a.ListenAddr = "unix://" + path
+		a.SocketMode = 0o600
+	})
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("unexpected socket mode %v", info.Mode())
+	}
+
+	expectHandled(t, call(t, a, clientCredentials(ca, nil)))
+
+	// Shutting down removes the socket
+	teardown()
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the socket to be removed, got %v", err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	server := newTestCert(t, "server", ca)
+	client := newTestCert(t, "client", ca)
+
+	// A client with a certificate signed by a different CA
+	rogueCA := newTestCert(t, "rogue-ca", nil)
+	rogue := newTestCert(t, "rogue", rogueCA)
+
+	a, logs, teardown := setup(t, server, ca)
+	defer teardown()
+
+	expectHandled(t, call(t, a, clientCredentials(ca, client)))
+	expectRejected(t, call(t, a, clientCredentials(ca, nil)))
+	expectRejected(t, call(t, a, clientCredentials(ca, rogue)))
+
+	// Failed handshakes are logged with the peer's address
+	deadline := time.Now().Add(5 * time.Second)
+	for logs.FilterMessage("TLS handshake with gRPC API client failed").Len() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 2 failed handshakes to be logged, got %d", logs.FilterMessage("TLS handshake with gRPC API client failed").Len())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, entry := range logs.FilterMessage("TLS handshake with gRPC API client failed").All() {
+		peer, ok := entry.ContextMap()["peer"].(string)
+		if !ok || !strings.HasPrefix(peer, "127.0.0.1:") {
+			t.Errorf("expected the peer address to be logged, got %v", entry.ContextMap())
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	server := newTestCert(t, "server", ca)
+
+	a, _, teardown := setup(t, server, nil)
+	defer teardown()
+
+	conn, err := grpc.Dial(a.listener.Addr().String(), grpc.WithTransportCredentials(clientCredentials(ca, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewApiClient(conn)
+
+	requestID := func(ctx context.Context) string {
+		var header metadata.MD
+		_, err := client.GetValidatorFeeRecipient(ctx, &pb.ValidatorFeeRecipientRequest{}, grpc.Header(&header))
+		expectHandled(t, err)
+		if ids := header.Get(requestIDMetadataKey); len(ids) == 1 {
+			return ids[0]
+		}
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// An ID is generated if the client didn't send one
+	if id := requestID(ctx); len(id) != 32 {
+		t.Fatalf("expected a generated request ID, got %q", id)
+	}
+
+	// The client's ID is honored
+	if id := requestID(metadata.AppendToOutgoingContext(ctx, requestIDMetadataKey, "vc-1234")); id != "vc-1234" {
+		t.Fatalf("expected the client's request ID, got %q", id)
+	}
+}
+
+func TestStreamGuardedRequests(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	server := newTestCert(t, "server", ca)
+	var feed *guarded.Feed
+	a, _, teardown := setup(t, server, nil, func(a *API) {
+		feed = guarded.NewFeed()
+		a.AdminToken = testAdminToken
+		a.GuardedRequests = feed
+	})
+	defer teardown()
+
+	// Who's using the proxy is for admins only
+	c, ctx := adminClient(t, a, clientCredentials(ca, nil), "")
+	stream, err := c.StreamGuardedRequests(ctx, &pb.GuardedRequestsRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	expectCode(t, err, codes.Unauthenticated)
+
+	c, ctx = adminClient(t, a, clientCredentials(ca, nil), testAdminToken)
+	stream, err = c.StreamGuardedRequests(ctx, &pb.GuardedRequestsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests published before the stream subscribes are dropped, so keep publishing until one arrives
+	published := guarded.Request{
+		Time:        time.UnixMilli(1700000000000),
+		NodeAddress: testNode,
+		Endpoint:    "/eth/v1/validator/prepare_beacon_proposer",
+		Validators:  3,
+		Reason:      "rate limit exceeded",
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				feed.Publish(published)
+			}
+		}
+	}()
+
+	request, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.TimestampMs != 1700000000000 || !bytes.Equal(request.NodeId, testNode.Bytes()) || request.Endpoint != published.Endpoint ||
+		request.Validators != 3 || request.Accepted || request.Reason != published.Reason {
+		t.Fatalf("unexpected request %v", request)
+	}
+}
+
+func TestGetSmoothingPoolInfo(t *testing.T) {
+	if _, err := metrics.Init("api_test_" + t.Name()); err != nil {
+		t.Fatal(err)
+	}
+	defer metrics.Deinit()
+
+	smoothingPool := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	el := mocks.NewMockExecutionLayer(smoothingPool)
+	el.AddNode(common.HexToAddress("0x1111111111111111111111111111111111111111"), true, common.Address{})
+	el.AddNode(common.HexToAddress("0x2222222222222222222222222222222222222222"), false, common.Address{})
+	el.SetBlocks(100, 101)
+	a := NewAPI("", el, zap.NewNop())
+
+	info, err := a.GetSmoothingPoolInfo(context.Background(), &pb.SmoothingPoolInfoRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(info.GetAddress(), smoothingPool.Bytes()) || info.GetOptedInNodes() != 1 || info.GetNodes() != 2 || info.GetBlock() != 100 {
+		t.Fatalf("unexpected smoothing pool info %v", info)
+	}
+}
+
+func TestGetRocketPoolNodes(t *testing.T) {
+	if _, err := metrics.Init("api_test_" + t.Name()); err != nil {
+		t.Fatal(err)
+	}
+	defer metrics.Deinit()
+
+	el := mocks.NewMockExecutionLayer(common.Address{})
+	nodes := []common.Address{
+		common.HexToAddress("0x1111111111111111111111111111111111111111"),
+		common.HexToAddress("0x2222222222222222222222222222222222222222"),
+	}
+	feeDistributor := common.HexToAddress("0xfd22222222222222222222222222222222222222")
+	el.AddNode(nodes[0], true, common.Address{})
+	el.AddNode(nodes[1], false, feeDistributor)
+	el.SetBlocks(100, 101)
+	a := NewAPI("", el, zap.NewNop())
+
+	for _, details := range []bool{false, true} {
+		r, err := a.GetRocketPoolNodes(context.Background(), &pb.RocketPoolNodesRequest{IncludeDetails: details})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r.GetNodeIds()) != 2 || !bytes.Equal(r.GetNodeIds()[0], nodes[0].Bytes()) || !bytes.Equal(r.GetNodeIds()[1], nodes[1].Bytes()) {
+			t.Fatalf("unexpected nodes %v", r.GetNodeIds())
+		}
+		if r.GetBlock() != 100 {
+			t.Fatalf("expected the snapshot to be as of block 100, got %d", r.GetBlock())
+		}
+		if !details {
+			if len(r.GetNodes()) != 0 {
+				t.Fatalf("expected no details, got %v", r.GetNodes())
+			}
+			continue
+		}
+		if len(r.GetNodes()) != 2 || !r.GetNodes()[0].GetSmoothingPool() || r.GetNodes()[1].GetSmoothingPool() ||
+			!bytes.Equal(r.GetNodes()[1].GetFeeDistributor(), feeDistributor.Bytes()) {
+			t.Fatalf("unexpected details %v", r.GetNodes())
+		}
+	}
+}
+
+func TestGetNodeMinipools(t *testing.T) {
+	if _, err := metrics.Init("api_test_" + t.Name()); err != nil {
+		t.Fatal(err)
+	}
+	defer metrics.Deinit()
+
+	node := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	pubkey := rptypes.ValidatorPubkey{0x01}
+	el := mocks.NewMockExecutionLayer(common.Address{})
+	el.AddNode(node, false, common.Address{})
+	el.AddMinipool(node, pubkey)
+	el.SetBlocks(100, 101)
+	a := NewAPI("", el, zap.NewNop())
+
+	r, err := a.GetNodeMinipools(context.Background(), &pb.NodeMinipoolsRequest{NodeId: node.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.GetPubkeys()) != 1 || !bytes.Equal(r.GetPubkeys()[0], pubkey.Bytes()) || r.GetBlock() != 100 {
+		t.Fatalf("unexpected minipools %v", r)
+	}
+
+	for _, tc := range []struct {
+		nodeID []byte
+		code   codes.Code
+	}{
+		{common.HexToAddress("0x2222222222222222222222222222222222222222").Bytes(), codes.NotFound},
+		{[]byte{0x01}, codes.InvalidArgument},
+	} {
+		if _, err := a.GetNodeMinipools(context.Background(), &pb.NodeMinipoolsRequest{NodeId: tc.nodeID}); status.Code(err) != tc.code {
+			t.Fatalf("expected %v for node %x, got %v", tc.code, tc.nodeID, err)
+		}
+	}
+}
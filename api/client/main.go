@@ -7,18 +7,40 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/client"
 	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/pb"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 )
 
 func main() {
 	addr := flag.String("addr", "0.0.0.0:8080", "the address where the api is responding to grpc requests")
+	pubkey := flag.String("pubkey", "", "a validator pubkey to get the expected fee recipient of, instead of listing the rocket pool nodes")
+	node := flag.String("node", "", "a node address to get the smoothing pool status, fee distributor and minipools of, instead of listing the rocket pool nodes")
+	details := flag.Bool("details", false, "include each rocket pool node's smoothing pool status and fee distributor when listing them")
+	stream := flag.Bool("stream", false, "print node events as they happen, instead of listing the rocket pool nodes")
+	caFile := flag.String("ca-file", "", "a CA bundle to verify the api's TLS certificate with. Leave blank to connect in plaintext")
+	certFile := flag.String("cert-file", "", "a TLS certificate to present to the api, if it requires one")
+	keyFile := flag.String("key-file", "", "the key for -cert-file")
+	createCredential := flag.String("create-credential", "", "a node address to issue a credential for, instead of listing the rocket pool nodes. Requires admin access")
+	solo := flag.Bool("solo", false, "issue a -create-credential credential for a solo staker, rather than a rocket pool node")
+	ttl := flag.Duration("ttl", 0, "how long a -create-credential credential should be valid for. 0 means the proxy's full validity window")
+	introspect := flag.String("introspect", "", "a username:password credential to describe, instead of listing the rocket pool nodes. Requires admin access")
+	streamGuarded := flag.Bool("stream-guarded", false, "print the proxy's decisions about guarded requests as they happen, instead of listing the rocket pool nodes. Requires admin access")
+	adminTokenFile := flag.String("admin-token-file", "", "a file containing the api's admin token, sent with -create-credential, -introspect and -stream-guarded")
+	compressor := flag.String("compression", "none", "the compressor to ask the api to compress its responses with, zstd or gzip, or none")
 	flag.Parse()
 
-	conn, err := grpc.Dial(*addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	// New refuses unknown -compression values
+	conn, err := client.New(client.Config{
+		Addr:        *addr,
+		CAFile:      *caFile,
+		CertFile:    *certFile,
+		KeyFile:     *keyFile,
+		Compression: *compressor,
+	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
@@ -26,22 +48,219 @@ func main() {
 	}
 	defer conn.Close()
 
-	c := pb.NewApiClient(conn)
+	c := conn.API()
+
+	if *stream {
+		printNodeEvents(c)
+		return
+	}
+
+	if *streamGuarded {
+		printGuardedRequests(withAdminToken(context.Background(), *adminTokenFile), c)
+		return
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
-	r, err := c.GetRocketPoolNodes(ctx, &pb.RocketPoolNodesRequest{})
+
+	if *pubkey != "" {
+		printFeeRecipient(ctx, c, *pubkey)
+		return
+	}
+
+	if *node != "" {
+		printNodeInfo(ctx, c, *node)
+		return
+	}
+
+	if *createCredential != "" || *introspect != "" {
+		ctx = withAdminToken(ctx, *adminTokenFile)
+		if *createCredential != "" {
+			printCreatedCredential(ctx, c, *createCredential, *solo, *ttl)
+		} else {
+			printCredentialInfo(ctx, c, *introspect)
+		}
+		return
+	}
+
+	r, err := c.GetRocketPoolNodes(ctx, &pb.RocketPoolNodesRequest{IncludeDetails: *details})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+		return
+	}
+
+	var out any
+	if *details {
+		nodes := make([]map[string]any, 0, len(r.GetNodes()))
+		for _, node := range r.GetNodes() {
+			nodes = append(nodes, map[string]any{
+				"address":         "0x" + hex.EncodeToString(node.GetNodeId()),
+				"smoothing_pool":  node.GetSmoothingPool(),
+				"fee_distributor": "0x" + hex.EncodeToString(node.GetFeeDistributor()),
+			})
+		}
+		out = nodes
+	} else {
+		nodeIds := r.GetNodeIds()
+		addrs := make([]string, 0, len(nodeIds))
+		for _, addr := range nodeIds {
+			addrs = append(addrs, "0x"+hex.EncodeToString(addr))
+		}
+		out = addrs
+	}
+
+	j, err := json.Marshal(out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+		return
+	}
+
+	fmt.Printf("%s\n", j)
+}
+
+// withAdminToken adds the admin token in tokenFile, if any, to the metadata sent with ctx
+func withAdminToken(ctx context.Context, tokenFile string) context.Context {
+	if tokenFile == "" {
+		return ctx
+	}
+
+	token, err := os.ReadFile(tokenFile)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
+		return nil
+	}
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+strings.TrimSpace(string(token)))
+}
+
+func printFeeRecipient(ctx context.Context, c pb.ApiClient, pubkey string) {
+	pubkeyBytes, err := hex.DecodeString(strings.TrimPrefix(pubkey, "0x"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -pubkey: %v\n", err)
+		os.Exit(1)
 		return
 	}
 
-	nodeIds := r.GetNodeIds()
-	out := make([]string, 0, len(nodeIds))
+	r, err := c.GetValidatorFeeRecipient(ctx, &pb.ValidatorFeeRecipientRequest{Pubkey: pubkeyBytes})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+		return
+	}
+
+	j, err := json.Marshal(map[string]any{
+		"fee_recipient":  "0x" + hex.EncodeToString(r.GetFeeRecipient()),
+		"smoothing_pool": r.GetSmoothingPool(),
+		"node_id":        "0x" + hex.EncodeToString(r.GetNodeId()),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+		return
+	}
+
+	fmt.Printf("%s\n", j)
+}
+
+func printNodeInfo(ctx context.Context, c pb.ApiClient, node string) {
+	nodeBytes, err := hex.DecodeString(strings.TrimPrefix(node, "0x"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -node: %v\n", err)
+		os.Exit(1)
+		return
+	}
+
+	r, err := c.GetNodeInfo(ctx, &pb.NodeInfoRequest{NodeId: nodeBytes})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+		return
+	}
 
-	for _, addr := range nodeIds {
-		out = append(out, "0x"+hex.EncodeToString(addr))
+	pubkeys := make([]string, 0, len(r.GetMinipoolPubkeys()))
+	for _, pubkey := range r.GetMinipoolPubkeys() {
+		pubkeys = append(pubkeys, "0x"+hex.EncodeToString(pubkey))
+	}
+
+	j, err := json.Marshal(map[string]any{
+		"smoothing_pool":   r.GetSmoothingPool(),
+		"fee_distributor":  "0x" + hex.EncodeToString(r.GetFeeDistributor()),
+		"minipool_pubkeys": pubkeys,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+		return
+	}
+
+	fmt.Printf("%s\n", j)
+}
+
+func printCreatedCredential(ctx context.Context, c pb.ApiClient, node string, solo bool, ttl time.Duration) {
+	nodeBytes, err := hex.DecodeString(strings.TrimPrefix(node, "0x"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -create-credential: %v\n", err)
+		os.Exit(1)
+		return
+	}
+
+	request := &pb.CreateCredentialRequest{NodeId: nodeBytes, TtlSeconds: uint64(ttl / time.Second)}
+	if solo {
+		request.OperatorType = pb.OperatorType_SOLO
+	}
+	r, err := c.CreateCredential(ctx, request)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+		return
+	}
+
+	j, err := json.Marshal(map[string]any{
+		"username":      r.GetUsername(),
+		"password":      r.GetPassword(),
+		"credential_id": r.GetCredentialId(),
+		"issued_at":     time.Unix(r.GetIssuedAt(), 0).UTC(),
+		"expires_at":    time.Unix(r.GetExpiresAt(), 0).UTC(),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+		return
+	}
+
+	fmt.Printf("%s\n", j)
+}
+
+func printCredentialInfo(ctx context.Context, c pb.ApiClient, credential string) {
+	username, password, ok := strings.Cut(credential, ":")
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Invalid -introspect: expected username:password\n")
+		os.Exit(1)
+		return
+	}
+
+	r, err := c.IntrospectCredential(ctx, &pb.IntrospectCredentialRequest{Username: username, Password: password})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+		return
+	}
+
+	out := map[string]any{
+		"valid":  r.GetValid(),
+		"reason": r.GetReason(),
+	}
+	if len(r.GetNodeId()) != 0 {
+		out["node_id"] = "0x" + hex.EncodeToString(r.GetNodeId())
+		out["operator_type"] = r.GetOperatorType().String()
+		out["credential_id"] = r.GetCredentialId()
+		out["issued_at"] = time.Unix(r.GetIssuedAt(), 0).UTC()
+		out["expires_at"] = time.Unix(r.GetExpiresAt(), 0).UTC()
+		out["expired"] = r.GetExpired()
+		out["within_grace"] = r.GetWithinGrace()
+		out["revoked"] = r.GetRevoked()
 	}
 
 	j, err := json.Marshal(out)
@@ -53,3 +272,69 @@ func main() {
 
 	fmt.Printf("%s\n", j)
 }
+
+func printNodeEvents(c pb.ApiClient) {
+	stream, err := c.StreamRocketPoolNodeEvents(context.Background(), &pb.RocketPoolNodeEventsRequest{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+		return
+	}
+
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+			return
+		}
+
+		j, err := json.Marshal(map[string]any{
+			"type":           event.GetType().String(),
+			"node_id":        "0x" + hex.EncodeToString(event.GetNodeId()),
+			"smoothing_pool": event.GetSmoothingPool(),
+			"block":          event.GetBlock(),
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+			return
+		}
+
+		fmt.Printf("%s\n", j)
+	}
+}
+
+func printGuardedRequests(ctx context.Context, c pb.ApiClient) {
+	stream, err := c.StreamGuardedRequests(ctx, &pb.GuardedRequestsRequest{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+		return
+	}
+
+	for {
+		request, err := stream.Recv()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+			return
+		}
+
+		j, err := json.Marshal(map[string]any{
+			"time":       time.UnixMilli(request.GetTimestampMs()).UTC().Format(time.RFC3339Nano),
+			"node_id":    "0x" + hex.EncodeToString(request.GetNodeId()),
+			"endpoint":   request.GetEndpoint(),
+			"validators": request.GetValidators(),
+			"accepted":   request.GetAccepted(),
+			"reason":     request.GetReason(),
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+			return
+		}
+
+		fmt.Printf("%s\n", j)
+	}
+}
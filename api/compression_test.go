@@ -0,0 +1,118 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/internal/compression"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/internal/mocks"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/pb"
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/proto"
+)
+
+// randomNodes returns an execution layer with count nodes, with random addresses as they'd be on chain.
+// Every other node is in the smoothing pool, and the rest have fee distributors.
+func randomNodes(count int) *mocks.MockExecutionLayer {
+	el := mocks.NewMockExecutionLayer(common.Address{})
+	for i := 0; i < count; i++ {
+		var node, feeDistributor common.Address
+		_, _ = rand.Read(node[:])
+		_, _ = rand.Read(feeDistributor[:])
+		el.AddNode(node, i%2 == 0, feeDistributor)
+	}
+	el.SetBlocks(100, 101)
+	return el
+}
+
+func TestCompressedCalls(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	server := newTestCert(t, "server", ca)
+
+	el := randomNodes(100)
+	a, _, teardown := setup(t, server, nil, func(a *API) {
+		a.EL = el
+	})
+	defer teardown()
+
+	conn, err := grpc.Dial(a.listener.Addr().String(), grpc.WithTransportCredentials(clientCredentials(ca, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewApiClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The server decompresses calls made with each of its compressors, and compresses its responses to them the same way
+	for _, name := range append(compression.Names(), "") {
+		var opts []grpc.CallOption
+		if name != "" {
+			opts = append(opts, grpc.UseCompressor(name))
+		}
+		r, err := client.GetRocketPoolNodes(ctx, &pb.RocketPoolNodesRequest{IncludeDetails: true}, opts...)
+		if err != nil {
+			t.Fatalf("%q: %v", name, err)
+		}
+		if len(r.GetNodes()) != 100 || len(r.GetNodeIds()) != 100 || !bytes.Equal(r.GetNodes()[0].GetNodeId(), r.GetNodeIds()[0]) {
+			t.Fatalf("%q: unexpected response with %d nodes", name, len(r.GetNodes()))
+		}
+	}
+}
+
+// BenchmarkGetRocketPoolNodesCompression compresses a 5k node GetRocketPoolNodes response with each compressor,
+// and reports how big it is compared to the uncompressed response. Run with -bench GetRocketPoolNodesCompression.
+func BenchmarkGetRocketPoolNodesCompression(b *testing.B) {
+	if _, err := metrics.Init("api_test_" + b.Name()); err != nil {
+		b.Fatal(err)
+	}
+	defer metrics.Deinit()
+
+	a := NewAPI("", randomNodes(5000), zap.NewNop())
+	for _, details := range []bool{false, true} {
+		r, err := a.GetRocketPoolNodes(context.Background(), &pb.RocketPoolNodesRequest{IncludeDetails: details})
+		if err != nil {
+			b.Fatal(err)
+		}
+		message, err := proto.Marshal(r)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		variant := "ids"
+		if details {
+			variant = "details"
+		}
+		for _, name := range compression.Names() {
+			c := encoding.GetCompressor(name)
+			b.Run(variant+"/"+name, func(b *testing.B) {
+				var compressed bytes.Buffer
+				b.SetBytes(int64(len(message)))
+				for i := 0; i < b.N; i++ {
+					compressed.Reset()
+					w, err := c.Compress(&compressed)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if _, err := w.Write(message); err != nil {
+						b.Fatal(err)
+					}
+					if err := w.Close(); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(len(message)), "uncompressed-bytes")
+				b.ReportMetric(float64(compressed.Len()), "compressed-bytes")
+				b.ReportMetric(float64(compressed.Len())/float64(len(message)), "ratio")
+			})
+		}
+	}
+}
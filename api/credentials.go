@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/auth"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/pb"
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// The RPCs which mint and inspect credentials, or reveal who's using the proxy, and so must not be reachable by the public
+var adminMethods = map[string]bool{
+	"/pb.Api/CreateCredential":      true,
+	"/pb.Api/IntrospectCredential":  true,
+	"/pb.Api/StreamGuardedRequests": true,
+}
+
+var operatorTypes = map[pb.OperatorType]auth.OperatorType{
+	pb.OperatorType_ROCKET_POOL: auth.OperatorRocketPool,
+	pb.OperatorType_SOLO:        auth.OperatorSolo,
+}
+
+var pbOperatorTypes = map[auth.OperatorType]pb.OperatorType{
+	auth.OperatorRocketPool: pb.OperatorType_ROCKET_POOL,
+	auth.OperatorSolo:       pb.OperatorType_SOLO,
+}
+
+// authorizeAdmin checks that the client of an admin RPC sent the admin token, or, if there isn't one,
+// that every client has had to present a trusted certificate to connect
+func (a *API) authorizeAdmin(ctx context.Context) error {
+	if a.AdminToken == "" {
+		if a.TLS.ClientCAFile != "" {
+			return nil
+		}
+		return status.Error(codes.PermissionDenied, "admin RPCs require an admin token or mTLS, and neither is configured")
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if !strings.HasPrefix(value, "Bearer ") {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(value, "Bearer ")), []byte(a.AdminToken)) == 1 {
+			return nil
+		}
+	}
+
+	return status.Error(codes.Unauthenticated, "admin RPCs require \"authorization: Bearer <admin token>\" metadata")
+}
+
+// adminInterceptor refuses admin RPCs from clients authorizeAdmin doesn't approve of
+func (a *API) adminInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if adminMethods[info.FullMethod] {
+			if err := a.authorizeAdmin(ctx); err != nil {
+				a.m.Counter("admin_unauthorized").Inc()
+				requestLogger(ctx, a.Logger).Warn("Refused unauthorized admin RPC", zap.String("method", info.FullMethod), zap.Error(err))
+				return nil, err
+			}
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// adminStreamInterceptor is adminInterceptor for streaming RPCs
+func (a *API) adminStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if adminMethods[info.FullMethod] {
+			if err := a.authorizeAdmin(stream.Context()); err != nil {
+				a.m.Counter("admin_unauthorized").Inc()
+				requestLogger(stream.Context(), a.Logger).Warn("Refused unauthorized admin RPC", zap.String("method", info.FullMethod), zap.Error(err))
+				return err
+			}
+		}
+
+		return handler(srv, stream)
+	}
+}
+
+func (a *API) CreateCredential(ctx context.Context, request *pb.CreateCredentialRequest) (*pb.Credential, error) {
+	if a.Credentials == nil {
+		return nil, status.Error(codes.Unimplemented, "credentials can't be issued by this proxy")
+	}
+
+	if len(request.NodeId) != common.AddressLength {
+		a.m.Counter("create_credential_invalid").Inc()
+		return nil, status.Errorf(codes.InvalidArgument, "node_id must be %d bytes, got %d", common.AddressLength, len(request.NodeId))
+	}
+	nodeAddr := common.BytesToAddress(request.NodeId)
+
+	operatorType, ok := operatorTypes[request.OperatorType]
+	if !ok {
+		a.m.Counter("create_credential_invalid").Inc()
+		return nil, status.Errorf(codes.InvalidArgument, "unknown operator_type %d", request.OperatorType)
+	}
+
+	if request.TtlSeconds > math.MaxInt64/uint64(time.Second) {
+		a.m.Counter("create_credential_invalid").Inc()
+		return nil, status.Errorf(codes.InvalidArgument, "ttl_seconds %d is too long", request.TtlSeconds)
+	}
+
+	username, password, issued, err := a.Credentials.Issue(nodeAddr, operatorType, time.Duration(request.TtlSeconds)*time.Second)
+	if err != nil {
+		a.m.Counter("create_credential_invalid").Inc()
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	a.m.Counter("create_credential_ok").Inc()
+	requestLogger(ctx, a.Logger).Info("Issued credential", zap.String("node", nodeAddr.String()),
+		zap.String("operator_type", string(operatorType)), zap.String("credential_id", issued.Credential.ID),
+		zap.Time("expires_at", issued.ExpiresAt))
+	return &pb.Credential{
+		Username:     username,
+		Password:     password,
+		CredentialId: issued.Credential.ID,
+		IssuedAt:     issued.IssuedAt.Unix(),
+		ExpiresAt:    issued.ExpiresAt.Unix(),
+	}, nil
+}
+
+func (a *API) IntrospectCredential(ctx context.Context, request *pb.IntrospectCredentialRequest) (*pb.CredentialInfo, error) {
+	if a.Credentials == nil {
+		return nil, status.Error(codes.Unimplemented, "credentials can't be introspected by this proxy")
+	}
+
+	if request.Username == "" || request.Password == "" {
+		a.m.Counter("introspect_credential_invalid").Inc()
+		return nil, status.Error(codes.InvalidArgument, "username and password are required")
+	}
+
+	introspection, err := a.Credentials.Introspect(request.Username, request.Password)
+	if introspection == nil {
+		if errors.Is(err, auth.ErrMalformed) {
+			a.m.Counter("introspect_credential_invalid").Inc()
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		// The credential isn't authentic, so there are no claims to describe
+		a.m.Counter("introspect_credential_ok").Inc()
+		return &pb.CredentialInfo{Reason: err.Error()}, nil
+	}
+
+	credential := introspection.Credential
+	out := &pb.CredentialInfo{
+		Valid:        err == nil,
+		OperatorType: pbOperatorTypes[credential.OperatorType],
+		CredentialId: credential.ID,
+		IssuedAt:     introspection.IssuedAt.Unix(),
+		ExpiresAt:    introspection.ExpiresAt.Unix(),
+		Expired:      errors.Is(err, auth.ErrExpired),
+		WithinGrace:  credential.WithinGrace,
+	}
+	// Credentials issued to validators or partners have no node
+	if credential.IsNode() {
+		out.NodeId = credential.NodeAddress.Bytes()
+	}
+	if err != nil {
+		out.Reason = err.Error()
+	}
+	if a.Revocations != nil && a.Revocations.Revoked(credential) {
+		out.Valid = false
+		out.Revoked = true
+		if out.Reason == "" {
+			out.Reason = auth.ErrRevoked.Error()
+		}
+	}
+
+	a.m.Counter("introspect_credential_ok").Inc()
+	return out, nil
+}
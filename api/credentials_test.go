@@ -0,0 +1,227 @@
+package api
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/auth"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/pb"
+	"github.com/ethereum/go-ethereum/common"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var testNode = common.HexToAddress("0x1111111111111111111111111111111111111111")
+
+const testAdminToken = "admin-token"
+
+func hmacVerifier(t *testing.T, secret string) *auth.HMACVerifier {
+	v, err := auth.NewHMACVerifier([][]byte{[]byte(secret)}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// adminClient connects to a, and returns a context carrying token, if it's set
+func adminClient(t *testing.T, a *API, tc credentials.TransportCredentials, token string) (pb.ApiClient, context.Context) {
+	conn, err := grpc.Dial(a.listener.Addr().String(), grpc.WithTransportCredentials(tc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	if token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	}
+	return pb.NewApiClient(conn), ctx
+}
+
+func expectCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	if status.Code(err) != code {
+		t.Fatalf("expected %s, got %v", code, err)
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	server := newTestCert(t, "server", ca)
+	a, _, teardown := setup(t, server, nil, func(a *API) {
+		a.Credentials = hmacVerifier(t, "test")
+		a.AdminToken = testAdminToken
+	})
+	defer teardown()
+	request := &pb.CreateCredentialRequest{NodeId: testNode.Bytes()}
+
+	for _, token := range []string{"", "wrong-token"} {
+		c, ctx := adminClient(t, a, clientCredentials(ca, nil), token)
+		_, err := c.CreateCredential(ctx, request)
+		expectCode(t, err, codes.Unauthenticated)
+	}
+	c, ctx := adminClient(t, a, clientCredentials(ca, nil), testAdminToken)
+	if _, err := c.CreateCredential(ctx, request); err != nil {
+		t.Fatal(err)
+	}
+
+	// Other RPCs don't need the token
+	c, ctx = adminClient(t, a, clientCredentials(ca, nil), "")
+	_, err := c.GetValidatorFeeRecipient(ctx, &pb.ValidatorFeeRecipientRequest{})
+	expectHandled(t, err)
+}
+
+func TestAdminMutualTLS(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	server := newTestCert(t, "server", ca)
+	client := newTestCert(t, "client", ca)
+
+	// Without a token, a client certificate is enough
+	a, _, teardown := setup(t, server, ca, func(a *API) { a.Credentials = hmacVerifier(t, "test") })
+	defer teardown()
+
+	c, ctx := adminClient(t, a, clientCredentials(ca, client), "")
+	if _, err := c.CreateCredential(ctx, &pb.CreateCredentialRequest{NodeId: testNode.Bytes()}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAdminUnprotected(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	server := newTestCert(t, "server", ca)
+
+	// With neither a token nor mTLS, admin RPCs are never served
+	a, _, teardown := setup(t, server, nil, func(a *API) { a.Credentials = hmacVerifier(t, "test") })
+	defer teardown()
+
+	c, ctx := adminClient(t, a, clientCredentials(ca, nil), "")
+	_, err := c.IntrospectCredential(ctx, &pb.IntrospectCredentialRequest{Username: "u", Password: "p"})
+	expectCode(t, err, codes.PermissionDenied)
+}
+
+func TestCreateCredential(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	server := newTestCert(t, "server", ca)
+	verifier := hmacVerifier(t, "test")
+	a, _, teardown := setup(t, server, nil, func(a *API) {
+		a.Credentials = verifier
+		a.AdminToken = testAdminToken
+	})
+	defer teardown()
+	c, ctx := adminClient(t, a, clientCredentials(ca, nil), testAdminToken)
+
+	created, err := c.CreateCredential(ctx, &pb.CreateCredentialRequest{
+		NodeId:       testNode.Bytes(),
+		OperatorType: pb.OperatorType_SOLO,
+		TtlSeconds:   600,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ExpiresAt-created.IssuedAt != int64(time.Hour/time.Second) {
+		t.Fatalf("expected the credential to be valid for the verifier's window, got %d to %d", created.IssuedAt, created.ExpiresAt)
+	}
+	if expires := time.Unix(created.ExpiresAt, 0); time.Until(expires) > 10*time.Minute || time.Until(expires) < 9*time.Minute {
+		t.Fatalf("expected the credential to expire in 10 minutes, got %s", expires)
+	}
+
+	// The proxy accepts it
+	credential, err := verifier.Verify(created.Username, created.Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credential.NodeAddress != testNode || credential.OperatorType != auth.OperatorSolo || credential.ID != created.CredentialId {
+		t.Fatalf("unexpected credential %+v", credential)
+	}
+
+	for name, request := range map[string]*pb.CreateCredentialRequest{
+		"short node id":         {NodeId: testNode.Bytes()[1:]},
+		"unknown operator type": {NodeId: testNode.Bytes(), OperatorType: pb.OperatorType(7)},
+		"ttl too long":          {NodeId: testNode.Bytes(), TtlSeconds: 3601},
+		"ttl overflows":         {NodeId: testNode.Bytes(), TtlSeconds: 1 << 63},
+	} {
+		if _, err := c.CreateCredential(ctx, request); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s: expected %s, got %v", name, codes.InvalidArgument, err)
+		}
+	}
+}
+
+func TestIntrospectCredential(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	server := newTestCert(t, "server", ca)
+	verifier := hmacVerifier(t, "test")
+	revocations, err := auth.LoadRevocationList(filepath.Join(t.TempDir(), "revoked"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _, teardown := setup(t, server, nil, func(a *API) {
+		a.Credentials = verifier
+		a.Revocations = revocations
+		a.AdminToken = testAdminToken
+	})
+	defer teardown()
+	c, ctx := adminClient(t, a, clientCredentials(ca, nil), testAdminToken)
+
+	introspect := func(username, password string) *pb.CredentialInfo {
+		t.Helper()
+		info, err := c.IntrospectCredential(ctx, &pb.IntrospectCredentialRequest{Username: username, Password: password})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+
+	// A current credential
+	username, password, err := verifier.Create(testNode, auth.OperatorRocketPool, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := introspect(username, password)
+	if !info.Valid || info.Reason != "" || common.BytesToAddress(info.NodeId) != testNode ||
+		info.OperatorType != pb.OperatorType_ROCKET_POOL || info.Expired || info.Revoked {
+		t.Fatalf("unexpected info %+v", info)
+	}
+
+	// An expired one still has its claims described
+	username, password, err = verifier.Create(testNode, auth.OperatorSolo, time.Now().Add(-2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info = introspect(username, password)
+	if info.Valid || !info.Expired || info.OperatorType != pb.OperatorType_SOLO || common.BytesToAddress(info.NodeId) != testNode {
+		t.Fatalf("unexpected info %+v", info)
+	}
+
+	// Revoking the node invalidates its current credentials too
+	if err := revocations.Revoke(testNode.String()); err != nil {
+		t.Fatal(err)
+	}
+	username, password, err = verifier.Create(testNode, auth.OperatorRocketPool, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info = introspect(username, password); info.Valid || !info.Revoked || info.Reason != auth.ErrRevoked.Error() {
+		t.Fatalf("unexpected info %+v", info)
+	}
+
+	// A credential signed with another secret has no claims to describe
+	username, password, err = hmacVerifier(t, "other").Create(testNode, auth.OperatorRocketPool, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info = introspect(username, password); info.Valid || info.Reason == "" || len(info.NodeId) != 0 {
+		t.Fatalf("unexpected info %+v", info)
+	}
+
+	// Malformed credentials are refused outright
+	for _, pair := range [][2]string{{"", password}, {username, ""}, {"!!!", "!!!"}} {
+		_, err := c.IntrospectCredential(ctx, &pb.IntrospectCredentialRequest{Username: pair[0], Password: pair[1]})
+		expectCode(t, err, codes.InvalidArgument)
+	}
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// methodName strips the service from a full gRPC method name, so "/pb.Api/GetNodeInfo" is labelled "GetNodeInfo"
+func methodName(fullMethod string) string {
+	return fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+}
+
+// observe records the outcome and duration of a finished RPC.
+// Handlers return the context's error when the client goes away, which is counted as the code the client sees.
+func (a *API) observe(fullMethod string, start time.Time, err error) {
+	method := methodName(fullMethod)
+	code := status.Code(err)
+	if code == codes.Unknown {
+		code = status.FromContextError(err).Code()
+	}
+	a.m.CounterVec("grpc_server_handled_total", "method", "code").WithLabelValues(method, code.String()).Inc()
+	a.m.HistogramVec("grpc_server_handling_seconds", "method").WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+// metricsInterceptor counts unary RPCs by method and status code, and times them.
+// It runs before the admin checks, so refused requests are counted too.
+func (a *API) metricsInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		a.observe(info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// metricsStreamInterceptor is metricsInterceptor for streaming RPCs, which also tracks how many are open
+func (a *API) metricsStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		a.m.Gauge("grpc_server_open_streams").Inc()
+		err := handler(srv, stream)
+		a.m.Gauge("grpc_server_open_streams").Dec()
+		a.observe(info.FullMethod, start, err)
+		return err
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/guarded"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/pb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/grpc/codes"
+)
+
+// gather scrapes the default registry, which the metrics package registers with, and returns the metric families by name
+func gather(t *testing.T) map[string]*dto.MetricFamily {
+	t.Helper()
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]*dto.MetricFamily, len(families))
+	for _, family := range families {
+		out[family.GetName()] = family
+	}
+	return out
+}
+
+// hasSeries checks whether family has a series with the given label values
+func hasSeries(family *dto.MetricFamily, labels map[string]string) bool {
+	if family == nil {
+		return false
+	}
+
+	for _, metric := range family.GetMetric() {
+		matched := 0
+		for _, label := range metric.GetLabel() {
+			if value, ok := labels[label.GetName()]; ok && value == label.GetValue() {
+				matched++
+			}
+		}
+		if matched == len(labels) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGRPCMetrics(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	server := newTestCert(t, "server", ca)
+	a, _, teardown := setup(t, server, nil, func(a *API) {
+		a.AdminToken = testAdminToken
+		a.GuardedRequests = guarded.NewFeed()
+	})
+	defer teardown()
+
+	// A unary call which fails validation, and a stream refused by the admin check
+	expectHandled(t, call(t, a, clientCredentials(ca, nil)))
+	c, ctx := adminClient(t, a, clientCredentials(ca, nil), "")
+	stream, err := c.StreamGuardedRequests(ctx, &pb.GuardedRequestsRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	expectCode(t, err, codes.Unauthenticated)
+
+	// An authorized stream stays open until it's cancelled
+	c, ctx = adminClient(t, a, clientCredentials(ca, nil), testAdminToken)
+	streamCtx, cancel := context.WithCancel(ctx)
+	if _, err := c.StreamGuardedRequests(streamCtx, &pb.GuardedRequestsRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForStreams := func(expected float64) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for testutil.ToFloat64(a.m.Gauge("grpc_server_open_streams")) != expected {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %v open streams, got %v", expected, testutil.ToFloat64(a.m.Gauge("grpc_server_open_streams")))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitForStreams(1)
+	cancel()
+	waitForStreams(0)
+
+	prefix := "api_test_" + t.Name() + "_api_"
+	families := gather(t)
+	for _, labels := range []map[string]string{
+		{"method": "GetValidatorFeeRecipient", "code": codes.InvalidArgument.String()},
+		{"method": "StreamGuardedRequests", "code": codes.Unauthenticated.String()},
+		{"method": "StreamGuardedRequests", "code": codes.Canceled.String()},
+	} {
+		if !hasSeries(families[prefix+"grpc_server_handled_total"], labels) {
+			t.Errorf("expected a handled series with labels %v", labels)
+		}
+	}
+	for _, method := range []string{"GetValidatorFeeRecipient", "StreamGuardedRequests"} {
+		if !hasSeries(families[prefix+"grpc_server_handling_seconds"], map[string]string{"method": method}) {
+			t.Errorf("expected a latency series for %s", method)
+		}
+	}
+	if families[prefix+"grpc_server_open_streams"] == nil {
+		t.Error("expected the open streams gauge to be scraped")
+	}
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// The metadata key request IDs are read from and returned to the client in
+const requestIDMetadataKey = "x-request-id"
+
+// Incoming request IDs longer than this are replaced, so they can't bloat the logs
+const maxRequestIDLength = 128
+
+// Used to avoid collisions in context.WithValue()
+type apiContextKey string
+
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID checks that a client-provided request ID is printable ascii, without
+// spaces, so that it can't be used to forge log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestLogger returns the logger tagged with the request ID of ctx, or fallback if there isn't one
+func requestLogger(ctx context.Context, fallback *zap.Logger) *zap.Logger {
+	if logger, ok := ctx.Value(apiContextKey("logger")).(*zap.Logger); ok {
+		return logger
+	}
+	return fallback
+}
+
+// requestIDInterceptor tags each request with an ID, honoring the client's if it sent one in the
+// x-request-id metadata. The ID is returned in the response headers, and included in every log line for the request.
+func (a *API) requestIDInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var id string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if ids := md.Get(requestIDMetadataKey); len(ids) > 0 {
+				id = ids[0]
+			}
+		}
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		if err := grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadataKey, id)); err != nil {
+			a.Logger.Debug("Unable to set request ID header", zap.Error(err))
+		}
+
+		logger := a.Logger.With(zap.String("request_id", id))
+		ctx = context.WithValue(ctx, apiContextKey("logger"), logger)
+
+		logger.Debug("Handling gRPC API request", zap.String("method", info.FullMethod))
+		resp, err := handler(ctx, req)
+		if err != nil {
+			logger.Debug("gRPC API request failed", zap.String("method", info.FullMethod),
+				zap.String("code", status.Code(err).String()), zap.Error(err))
+		}
+		return resp, err
+	}
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+// Verifiers wrap these, so callers can tell why a credential was rejected with errors.Is()
+var (
+	ErrMalformed = errors.New("malformed credentials")
+	ErrInvalid   = errors.New("invalid credentials")
+	ErrExpired   = errors.New("expired credentials")
+)
+
+// OperatorType is the kind of node operator a credential was issued to
+type OperatorType string
+
+const (
+	OperatorRocketPool OperatorType = "rocketpool"
+	OperatorSolo       OperatorType = "solo"
+)
+
+// ParseOperatorType checks that s is a known OperatorType
+func ParseOperatorType(s string) (OperatorType, error) {
+	switch operatorType := OperatorType(s); operatorType {
+	case OperatorRocketPool, OperatorSolo:
+		return operatorType, nil
+	}
+
+	return "", fmt.Errorf("unknown operator type %q, expected rocketpool or solo", s)
+}
+
+// SubjectType is what a credential was issued to
+type SubjectType string
+
+const (
+	// A Rocket Pool or solo node, by its address. The default, and the only subject older credentials have.
+	SubjectNodeAddress SubjectType = "node_address"
+	// A single validator, by its pubkey, which is the only one the credential may be used for
+	SubjectValidatorPubkey SubjectType = "validator_pubkey"
+	// A staking partner, by an opaque ID, whose validators aren't tied to a node
+	SubjectPartner SubjectType = "partner"
+)
+
+// Partner IDs are kept short and printable, since they end up in usernames, logs and credential IDs
+var partnerIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// ParseSubject checks that subject is a valid subject of subjectType, and returns it in its canonical form:
+// a checksummed node address, a 0x-prefixed lowercase validator pubkey, or the partner ID as it was given
+func ParseSubject(subjectType SubjectType, subject string) (string, error) {
+	switch subjectType {
+	case SubjectNodeAddress:
+		if !common.IsHexAddress(subject) {
+			return "", fmt.Errorf("%q is not a node address", subject)
+		}
+		return common.HexToAddress(subject).String(), nil
+	case SubjectValidatorPubkey:
+		pubkey, err := rptypes.HexToValidatorPubkey(strings.TrimPrefix(subject, "0x"))
+		if err != nil {
+			return "", fmt.Errorf("%q is not a validator pubkey: %w", subject, err)
+		}
+		return "0x" + pubkey.Hex(), nil
+	case SubjectPartner:
+		if !partnerIDPattern.MatchString(subject) {
+			return "", fmt.Errorf("partner ID %q must be 1 to 64 letters, digits, dots, dashes or underscores", subject)
+		}
+		return subject, nil
+	}
+
+	return "", fmt.Errorf("unknown subject type %q", subjectType)
+}
+
+// Credential is what a Verifier vouches for, whichever scheme the credential used
+type Credential struct {
+	// The node the credential was issued to. The zero address unless the subject is a node address.
+	NodeAddress  common.Address
+	OperatorType OperatorType
+	// Identifies the credential, so it can be revoked on its own. May be blank.
+	ID string
+	// Set if the credential had expired, but was accepted within the verifier's grace period
+	WithinGrace bool
+	// If set, validators must use one of these fee recipients, rather than the ones NodeAddress would be
+	// expected to use. The node address may be a placeholder which owns nothing.
+	FeeRecipients []common.Address
+	// What the credential was issued to, and its canonical form, as ParseSubject returns it. Verifiers which
+	// only know of nodes may leave these blank, which is the same as a node address subject of NodeAddress.
+	SubjectType SubjectType
+	Subject     string
+}
+
+// IsNode reports whether the credential was issued to a node, so belongs to NodeAddress
+func (c *Credential) IsNode() bool {
+	return c.SubjectType == "" || c.SubjectType == SubjectNodeAddress
+}
+
+// SubjectKey names the credential's subject uniquely across subject types, eg, validator_pubkey:0xabcd...,
+// for rate limiting and logs
+func (c *Credential) SubjectKey() string {
+	if c.IsNode() {
+		return string(SubjectNodeAddress) + ":" + c.NodeAddress.String()
+	}
+	return string(c.SubjectType) + ":" + c.Subject
+}
+
+// Verifier checks the username and password sent by a validator client, and returns the credential
+// they carry, or an error wrapping ErrMalformed, ErrInvalid or ErrExpired.
+type Verifier interface {
+	Verify(username, password string) (*Credential, error)
+}
+
+// UserVerifier is a Verifier for plain usernames and passwords, which can say which usernames are its own
+type UserVerifier interface {
+	Verifier
+	Knows(username string) bool
+}
+
+// FormatVerifier picks the scheme to verify a credential with by the format of its password.
+// JWTs have three dot-separated segments, whereas HMAC passwords are unpadded base64url, with none.
+// Passwords of users the Basic verifier knows can be anything, so it's asked first.
+type FormatVerifier struct {
+	HMAC Verifier
+	// Optional. If nil, JWTs are rejected
+	JWT Verifier
+	// Optional. If nil, only HMAC credentials and JWTs are accepted
+	Basic UserVerifier
+}
+
+func isJWT(password string) bool {
+	return strings.Count(password, ".") == 2
+}
+
+func (f *FormatVerifier) Verify(username, password string) (*Credential, error) {
+	if len(username) == 0 || len(password) == 0 {
+		return nil, fmt.Errorf("%w: username or password missing", ErrMalformed)
+	}
+
+	if f.Basic != nil && f.Basic.Knows(username) {
+		return f.Basic.Verify(username, password)
+	}
+
+	if !isJWT(password) {
+		return f.HMAC.Verify(username, password)
+	}
+
+	if f.JWT == nil {
+		return nil, fmt.Errorf("%w: JWT credentials are not accepted", ErrInvalid)
+	}
+
+	return f.JWT.Verify(username, password)
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestFormatVerifier(t *testing.T) {
+	testMetrics(t)
+	jwtVerifier, err := NewJWTVerifier(testSecret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &FormatVerifier{HMAC: testHMACVerifier(t, "test"), JWT: jwtVerifier}
+
+	// Both schemes authenticate the same node
+	username, password := hmacCredential(t, []byte("test"), time.Now())
+	token := sign(t, jwt.SigningMethodHS256, testClaims(time.Now().Add(time.Hour)), testSecret)
+	for name, pair := range map[string][2]string{
+		"hmac": {username, password},
+		"jwt":  {"node", token},
+	} {
+		credential, err := v.Verify(pair[0], pair[1])
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if credential.NodeAddress != testNode {
+			t.Fatalf("%s: expected node %s, got %s", name, testNode, credential.NodeAddress)
+		}
+	}
+
+	if _, err := v.Verify("", password); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("expected a missing username to be malformed, got %v", err)
+	}
+	if _, err := v.Verify(username, ""); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("expected a missing password to be malformed, got %v", err)
+	}
+
+	// Without a JWT verifier, JWTs are rejected
+	v.JWT = nil
+	if _, err := v.Verify("node", token); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected a JWT to be rejected, got %v", err)
+	}
+}
+
+func TestFormatVerifierBasic(t *testing.T) {
+	testMetrics(t)
+	htpasswdPath, mappingPath := writeHtpasswd(t, t.TempDir(), htpasswdLine(t, "alice", "a.b.c"),
+		`{"alice": {"fee_recipients": ["`+testFeeRecipient.String()+`"]}}`)
+	basic, err := LoadHtpasswdVerifier(htpasswdPath, mappingPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &FormatVerifier{HMAC: testHMACVerifier(t, "test"), Basic: basic}
+
+	// alice's password looks like a JWT, but she's an htpasswd user
+	credential, err := v.Verify("alice", "a.b.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(credential.FeeRecipients) != 1 {
+		t.Fatalf("expected alice's fee recipients, got %+v", credential)
+	}
+	if _, err := v.Verify("alice", "hunter2"); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected a wrong password to be rejected, got %v", err)
+	}
+
+	// Everyone else still uses HMAC credentials
+	username, password := hmacCredential(t, []byte("test"), time.Now())
+	if credential, err := v.Verify(username, password); err != nil || credential.NodeAddress != testNode {
+		t.Fatalf("expected the HMAC credential to verify, got %+v, %v", credential, err)
+	}
+}
+
+func TestParseOperatorType(t *testing.T) {
+	for _, s := range []string{"rocketpool", "solo"} {
+		if operatorType, err := ParseOperatorType(s); err != nil || string(operatorType) != s {
+			t.Fatalf("expected %s to parse, got %q, %v", s, operatorType, err)
+		}
+	}
+
+	if _, err := ParseOperatorType("lido"); err == nil {
+		t.Fatal("expected an unknown operator type to be rejected")
+	}
+}
+
+// FuzzFormatVerifier checks that no username and password, as sent in basic auth, panics whichever scheme they look like
+func FuzzFormatVerifier(f *testing.F) {
+	testMetrics(f)
+	jwtVerifier, err := NewJWTVerifier(testSecret, nil)
+	if err != nil {
+		f.Fatal(err)
+	}
+	v := &FormatVerifier{HMAC: testHMACVerifier(f, "test"), JWT: jwtVerifier}
+
+	username, password := hmacCredential(f, []byte("test"), time.Now())
+	f.Add(username, password)
+	f.Add(username, password[:len(password)/2])
+	f.Add(username[1:], password)
+	f.Add("node", sign(f, jwt.SigningMethodHS256, testClaims(time.Now().Add(time.Hour)), testSecret))
+	f.Add("node", "eyJhbGciOiJub25lIn0.e30.")
+	f.Add("node", "..")
+	f.Add("", "")
+
+	f.Fuzz(func(t *testing.T, username, password string) {
+		credential, err := v.Verify(username, password)
+		if err == nil && credential == nil {
+			t.Fatal("expected a credential or an error")
+		}
+	})
+}
@@ -0,0 +1,444 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/credentials"
+	"github.com/Rocket-Pool-Rescue-Node/credentials/pb"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// The operator type is carried in field 3 of the Credential message, which the credentials package doesn't
+// know about. It keeps unknown fields when it re-marshals a Credential to check its MAC, so they're authenticated
+// like the node id and timestamp are. Credentials without the field were issued to Rocket Pool node operators.
+const operatorTypeField protowire.Number = 3
+
+// The subject type is carried in field 4, the same way. Credentials without it were issued to the node whose
+// address is their node id; otherwise the node id holds a validator pubkey or partner ID. Proxies which predate
+// subject types read those as addresses which own no minipools.
+const subjectTypeField protowire.Number = 4
+
+const (
+	// How long after an HMAC credential expires it's still accepted, for clients whose clocks are behind
+	DefaultExpiryGrace = 5 * time.Minute
+	// How far in the future an HMAC credential may have been issued, for issuers whose clocks are ahead
+	DefaultClockSkew = 30 * time.Second
+)
+
+var operatorTypeValues = map[OperatorType]uint64{
+	OperatorRocketPool: 0,
+	OperatorSolo:       1,
+}
+
+var subjectTypeValues = map[SubjectType]uint64{
+	SubjectNodeAddress:     0,
+	SubjectValidatorPubkey: 1,
+	SubjectPartner:         2,
+}
+
+// HMACVerifier verifies the rescue node's own credentials, whose username is the base64url encoded
+// subject, usually a node address, and whose password carries the issue time and an HMAC of them.
+//
+// It may hold several secrets, so they can be rotated: credentials are created with the first,
+// but any of them verifies a credential.
+//
+// Credentials expire validityWindow after they're issued, but are accepted for ExpiryGrace longer,
+// and may have been issued up to ClockSkew in the future.
+type HMACVerifier struct {
+	sync.RWMutex
+	ExpiryGrace    time.Duration
+	ClockSkew      time.Duration
+	managers       []*credentials.CredentialManager
+	signingSecret  []byte
+	validityWindow time.Duration
+	m              *metrics.MetricsRegistry
+	// Replaceable for testing
+	now func() time.Time
+}
+
+// NewHMACVerifier creates an HMACVerifier which accepts credentials signed with any of secrets, for validityWindow after they're issued
+func NewHMACVerifier(secrets [][]byte, validityWindow time.Duration) (*HMACVerifier, error) {
+	out := &HMACVerifier{
+		ExpiryGrace:    DefaultExpiryGrace,
+		ClockSkew:      DefaultClockSkew,
+		validityWindow: validityWindow,
+		m:              metrics.NewMetricsRegistry("authentication"),
+		now:            time.Now,
+	}
+
+	if err := out.SetSecrets(secrets); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// SetSecrets replaces the secrets credentials are created and verified with. Requests being verified
+// while it's called use either the old secrets or the new ones.
+func (h *HMACVerifier) SetSecrets(secrets [][]byte) error {
+	if len(secrets) == 0 {
+		return errors.New("at least one HMAC secret is required")
+	}
+
+	managers := make([]*credentials.CredentialManager, 0, len(secrets))
+	for i, secret := range secrets {
+		if len(secret) == 0 {
+			return fmt.Errorf("HMAC secret %d is empty", i)
+		}
+		managers = append(managers, credentials.NewCredentialManager(sha256.New, secret))
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	h.managers = managers
+	h.signingSecret = secrets[0]
+	return nil
+}
+
+// LoadSecrets reads HMAC secrets from path, one per line, newest first.
+// Blank lines and lines starting with # are ignored.
+func LoadSecrets(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out [][]byte
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		out = append(out, append([]byte(nil), line...))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%s contains no HMAC secrets", path)
+	}
+
+	return out, nil
+}
+
+func (h *HMACVerifier) currentManagers() []*credentials.CredentialManager {
+	h.RLock()
+	defer h.RUnlock()
+	return h.managers
+}
+
+// Create makes a credential for a node operator of operatorType at nodeAddr, issued at timestamp
+// and signed with the first secret, and returns it as a username and password
+func (h *HMACVerifier) Create(nodeAddr common.Address, operatorType OperatorType, timestamp time.Time) (string, string, error) {
+	return h.CreateSubject(SubjectNodeAddress, nodeAddr.String(), operatorType, timestamp)
+}
+
+// CreateSubject makes a credential like Create, for a subject of any type. Its username is the base64url
+// encoded subject: the bytes of a node address or validator pubkey, or a partner ID as it is.
+func (h *HMACVerifier) CreateSubject(subjectType SubjectType, subject string, operatorType OperatorType, timestamp time.Time) (string, string, error) {
+	operatorTypeValue, ok := operatorTypeValues[operatorType]
+	if !ok {
+		return "", "", fmt.Errorf("unknown operator type %q", operatorType)
+	}
+	subjectTypeValue, ok := subjectTypeValues[subjectType]
+	if !ok {
+		return "", "", fmt.Errorf("unknown subject type %q", subjectType)
+	}
+	subjectID, err := encodeSubject(subjectType, subject)
+	if err != nil {
+		return "", "", err
+	}
+
+	h.RLock()
+	secret := h.signingSecret
+	h.RUnlock()
+
+	// Rocket Pool node credentials are left as they were, so proxies which predate operator and subject types
+	// still accept them. Other types are added as unknown fields, which the MAC covers.
+	var unknown []byte
+	if operatorType != OperatorRocketPool {
+		unknown = protowire.AppendTag(unknown, operatorTypeField, protowire.VarintType)
+		unknown = protowire.AppendVarint(unknown, operatorTypeValue)
+	}
+	if subjectType != SubjectNodeAddress {
+		unknown = protowire.AppendTag(unknown, subjectTypeField, protowire.VarintType)
+		unknown = protowire.AppendVarint(unknown, subjectTypeValue)
+	}
+
+	ac := &credentials.AuthenticatedCredential{
+		Credential: &pb.Credential{NodeId: subjectID, Timestamp: timestamp.Unix()},
+	}
+	ac.Credential.ProtoReflect().SetUnknown(unknown)
+
+	body, err := proto.Marshal(ac.Credential)
+	if err != nil {
+		return "", "", err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	ac.Mac = mac.Sum(nil)
+
+	password, err := ac.Base64URLEncodePassword()
+	if err != nil {
+		return "", "", err
+	}
+
+	return ac.Base64URLEncodeUsername(), password, nil
+}
+
+// Issue creates a credential for a node operator of operatorType at nodeAddr, which expires ttl from now,
+// or after the whole validity window if ttl is 0. Credentials are valid for the validity window from their
+// timestamp, so shorter lived ones are backdated. The returned Introspection describes the credential.
+func (h *HMACVerifier) Issue(nodeAddr common.Address, operatorType OperatorType, ttl time.Duration) (string, string, *Introspection, error) {
+	return h.IssueSubject(SubjectNodeAddress, nodeAddr.String(), operatorType, ttl)
+}
+
+// IssueSubject issues a credential like Issue, for a subject of any type
+func (h *HMACVerifier) IssueSubject(subjectType SubjectType, subject string, operatorType OperatorType, ttl time.Duration) (string, string, *Introspection, error) {
+	if ttl == 0 {
+		ttl = h.validityWindow
+	}
+	if ttl < 0 || ttl > h.validityWindow {
+		return "", "", nil, fmt.Errorf("ttl must be between 0 and %s, got %s", h.validityWindow, ttl)
+	}
+
+	subject, err := ParseSubject(subjectType, subject)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	ts := time.Unix(h.now().Add(ttl-h.validityWindow).Unix(), 0)
+	username, password, err := h.CreateSubject(subjectType, subject, operatorType, ts)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	return username, password, &Introspection{
+		Credential: subjectCredential(subjectType, subject, operatorType, ts.Unix()),
+		IssuedAt:   ts,
+		ExpiresAt:  ts.Add(h.validityWindow),
+	}, nil
+}
+
+// subjectCredential describes an HMAC credential issued to subject, which must be in its canonical form
+func subjectCredential(subjectType SubjectType, subject string, operatorType OperatorType, timestamp int64) *Credential {
+	out := &Credential{
+		OperatorType: operatorType,
+		ID:           hmacCredentialID(subjectType, subject, timestamp),
+		SubjectType:  subjectType,
+		Subject:      subject,
+	}
+	if subjectType == SubjectNodeAddress {
+		out.NodeAddress = common.HexToAddress(subject)
+	}
+	return out
+}
+
+// hmacCredentialID identifies an HMAC credential by its subject and the second it was issued, eg, 0xabcd...:1700000000.
+// Partner IDs are prefixed, eg, partner:acme:1700000000, so they can't be mistaken for an address or pubkey.
+func hmacCredentialID(subjectType SubjectType, subject string, timestamp int64) string {
+	if subjectType == SubjectPartner {
+		subject = string(SubjectPartner) + ":" + subject
+	}
+	return strings.ToLower(subject) + ":" + strconv.FormatInt(timestamp, 10)
+}
+
+// encodeSubject converts a subject to the bytes carried in a credential's node id
+func encodeSubject(subjectType SubjectType, subject string) ([]byte, error) {
+	subject, err := ParseSubject(subjectType, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	switch subjectType {
+	case SubjectNodeAddress:
+		return common.HexToAddress(subject).Bytes(), nil
+	case SubjectValidatorPubkey:
+		return common.FromHex(subject), nil
+	}
+	return []byte(subject), nil
+}
+
+// decodeSubject converts the node id of a credential back to its subject, in its canonical form
+func decodeSubject(subjectType SubjectType, nodeID []byte) (string, error) {
+	switch subjectType {
+	case SubjectNodeAddress:
+		if len(nodeID) != common.AddressLength {
+			return "", fmt.Errorf("node address is %d bytes, expected %d", len(nodeID), common.AddressLength)
+		}
+		return common.BytesToAddress(nodeID).String(), nil
+	case SubjectValidatorPubkey:
+		if len(nodeID) != rptypes.ValidatorPubkeyLength {
+			return "", fmt.Errorf("validator pubkey is %d bytes, expected %d", len(nodeID), rptypes.ValidatorPubkeyLength)
+		}
+		return "0x" + hex.EncodeToString(nodeID), nil
+	}
+	return ParseSubject(subjectType, string(nodeID))
+}
+
+// credentialClaims reads the operator and subject types from a credential's unknown fields
+func credentialClaims(ac *credentials.AuthenticatedCredential) (OperatorType, SubjectType, error) {
+	operatorType, subjectType := OperatorRocketPool, SubjectNodeAddress
+	unknown := []byte(ac.Credential.ProtoReflect().GetUnknown())
+	for len(unknown) > 0 {
+		number, wireType, n := protowire.ConsumeTag(unknown)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		unknown = unknown[n:]
+
+		if number != operatorTypeField && number != subjectTypeField {
+			n = protowire.ConsumeFieldValue(number, wireType, unknown)
+			if n < 0 {
+				return "", "", protowire.ParseError(n)
+			}
+			unknown = unknown[n:]
+			continue
+		}
+
+		if wireType != protowire.VarintType {
+			return "", "", fmt.Errorf("field %d has wire type %d, expected a varint", number, wireType)
+		}
+		value, n := protowire.ConsumeVarint(unknown)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		unknown = unknown[n:]
+
+		found := false
+		if number == operatorTypeField {
+			for t, v := range operatorTypeValues {
+				if v == value {
+					operatorType, found = t, true
+				}
+			}
+			if !found {
+				return "", "", fmt.Errorf("unknown operator type %d", value)
+			}
+			continue
+		}
+		for t, v := range subjectTypeValues {
+			if v == value {
+				subjectType, found = t, true
+			}
+		}
+		if !found {
+			return "", "", fmt.Errorf("unknown subject type %d", value)
+		}
+	}
+
+	return operatorType, subjectType, nil
+}
+
+// decodeHMACCredential decodes the username and password of an HMAC credential. The credentials package assumes
+// the password has a Credential message in it, and panics if it doesn't, so passwords without one are refused first.
+func decodeHMACCredential(username, password string) (*credentials.AuthenticatedCredential, error) {
+	decoded, err := io.ReadAll(base64.NewDecoder(base64.URLEncoding, strings.NewReader(password)))
+	if err != nil {
+		return nil, err
+	}
+	var parsed pb.AuthenticatedCredential
+	if err := proto.Unmarshal(decoded, &parsed); err != nil {
+		return nil, err
+	}
+	if parsed.GetCredential() == nil {
+		return nil, errors.New("password has no credential in it")
+	}
+
+	ac := &credentials.AuthenticatedCredential{}
+	if err := ac.Base64URLDecode(username, password); err != nil {
+		return nil, err
+	}
+	return ac, nil
+}
+
+// Introspection is what HMACVerifier.Introspect can tell about a credential
+type Introspection struct {
+	Credential *Credential
+	IssuedAt   time.Time
+	// When the credential stops being accepted, not counting the grace period
+	ExpiresAt time.Time
+}
+
+// Introspect verifies a credential like Verify, and also says when it was issued and expires. If the credential
+// is authentic, but expired or from the future, its Introspection is returned along with the error.
+func (h *HMACVerifier) Introspect(username, password string) (*Introspection, error) {
+	ac, err := decodeHMACCredential(username, password)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	// Try each secret in turn, counting which one verified the credential, so it's clear when an old one can be removed
+	verified := false
+	for i, cm := range h.currentManagers() {
+		if err = cm.Verify(ac); err == nil {
+			h.m.CounterVec("hmac_secret_verifications", "secret_index").WithLabelValues(strconv.Itoa(i)).Inc()
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	operatorType, subjectType, err := credentialClaims(ac)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	subject, err := decodeSubject(subjectType, ac.Credential.NodeId)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	ts := time.Unix(ac.Credential.Timestamp, 0)
+	out := &Introspection{
+		Credential: subjectCredential(subjectType, subject, operatorType, ac.Credential.Timestamp),
+		IssuedAt:   ts,
+		ExpiresAt:  ts.Add(h.validityWindow),
+	}
+
+	// Make sure the credential is recent enough, give or take the clocks involved
+	now := h.now()
+	if ts.Sub(now) > h.ClockSkew {
+		return out, fmt.Errorf("%w: issued %s in the future", ErrInvalid, ts.Sub(now))
+	}
+	age := now.Sub(ts)
+	if age > h.validityWindow+h.ExpiryGrace {
+		return out, ErrExpired
+	}
+	out.Credential.WithinGrace = age > h.validityWindow
+
+	return out, nil
+}
+
+func (h *HMACVerifier) Verify(username, password string) (*Credential, error) {
+	introspection, err := h.Introspect(username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if introspection.Credential.WithinGrace {
+		h.m.Counter("hmac_expired_within_grace").Inc()
+	}
+	return introspection.Credential, nil
+}
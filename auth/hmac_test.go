@@ -0,0 +1,504 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/credentials"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+func testMetrics(t testing.TB) {
+	_, err := metrics.Init("auth_test_" + t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(metrics.Deinit)
+}
+
+func testHMACVerifier(t testing.TB, secrets ...string) *HMACVerifier {
+	keys := make([][]byte, 0, len(secrets))
+	for _, secret := range secrets {
+		keys = append(keys, []byte(secret))
+	}
+
+	v, err := NewHMACVerifier(keys, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func hmacCredential(t testing.TB, secret []byte, issued time.Time) (string, string) {
+	cm := credentials.NewCredentialManager(sha256.New, secret)
+	cred, err := cm.Create(issued, testNode.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	password, err := cred.Base64URLEncodePassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cred.Base64URLEncodeUsername(), password
+}
+
+func TestHMACVerifier(t *testing.T) {
+	testMetrics(t)
+	v := testHMACVerifier(t, "test")
+
+	username, password := hmacCredential(t, []byte("test"), time.Now())
+	credential, err := v.Verify(username, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credential.NodeAddress != testNode || credential.OperatorType != OperatorRocketPool {
+		t.Fatalf("unexpected credential %+v", credential)
+	}
+
+	username, password = hmacCredential(t, []byte("test"), time.Now().Add(-2*time.Hour))
+	if _, err := v.Verify(username, password); !errors.Is(err, ErrExpired) {
+		t.Fatalf("expected %v, got %v", ErrExpired, err)
+	}
+
+	username, password = hmacCredential(t, []byte("wrong"), time.Now())
+	if _, err := v.Verify(username, password); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected %v, got %v", ErrInvalid, err)
+	}
+
+	if _, err := v.Verify("!!!", password); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("expected %v, got %v", ErrMalformed, err)
+	}
+}
+
+func TestHMACExpiryGrace(t *testing.T) {
+	testMetrics(t)
+	v := testHMACVerifier(t, "test")
+	issued := time.Unix(1700000000, 0)
+	username, password := hmacCredential(t, []byte("test"), issued)
+
+	for _, tc := range []struct {
+		name        string
+		now         time.Time
+		err         error
+		withinGrace bool
+	}{
+		{"just issued", issued, nil, false},
+		{"at expiry", issued.Add(time.Hour), nil, false},
+		{"within grace", issued.Add(time.Hour + DefaultExpiryGrace - time.Second), nil, true},
+		{"past grace", issued.Add(time.Hour + DefaultExpiryGrace + time.Second), ErrExpired, false},
+		{"issued slightly in the future", issued.Add(-DefaultClockSkew), nil, false},
+		{"issued too far in the future", issued.Add(-DefaultClockSkew - time.Second), ErrInvalid, false},
+	} {
+		now := tc.now
+		v.now = func() time.Time { return now }
+
+		credential, err := v.Verify(username, password)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.err, err)
+			continue
+		}
+		if err == nil && credential.WithinGrace != tc.withinGrace {
+			t.Errorf("%s: expected WithinGrace to be %v", tc.name, tc.withinGrace)
+		}
+	}
+
+	// Credentials saved by the grace period are counted
+	if got := testutil.ToFloat64(v.m.Counter("hmac_expired_within_grace")); got != 1 {
+		t.Fatalf("expected 1 credential accepted within the grace period, got %v", got)
+	}
+
+	// Both can be turned off
+	v.ExpiryGrace, v.ClockSkew = 0, 0
+	v.now = func() time.Time { return issued.Add(time.Hour + time.Second) }
+	if _, err := v.Verify(username, password); !errors.Is(err, ErrExpired) {
+		t.Fatalf("expected %v without a grace period, got %v", ErrExpired, err)
+	}
+	v.now = func() time.Time { return issued.Add(-time.Second) }
+	if _, err := v.Verify(username, password); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected %v without clock skew tolerance, got %v", ErrInvalid, err)
+	}
+}
+
+func TestHMACIssue(t *testing.T) {
+	testMetrics(t)
+	v := testHMACVerifier(t, "test")
+	now := time.Unix(1700000000, 0)
+	v.now = func() time.Time { return now }
+
+	// A short lived credential is backdated, so it expires after ttl
+	username, password, issued, err := v.Issue(testNode, OperatorSolo, 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !issued.ExpiresAt.Equal(now.Add(10*time.Minute)) || !issued.IssuedAt.Equal(now.Add(10*time.Minute-time.Hour)) {
+		t.Fatalf("unexpected issue and expiry times %s, %s", issued.IssuedAt, issued.ExpiresAt)
+	}
+
+	introspection, err := v.Introspect(username, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(introspection.Credential, issued.Credential) || !introspection.ExpiresAt.Equal(issued.ExpiresAt) {
+		t.Fatalf("expected %+v, got %+v", issued.Credential, introspection.Credential)
+	}
+
+	// Once it's expired, Introspect still describes it
+	now = now.Add(time.Hour)
+	introspection, err = v.Introspect(username, password)
+	if !errors.Is(err, ErrExpired) {
+		t.Fatalf("expected %v, got %v", ErrExpired, err)
+	}
+	if introspection == nil || introspection.Credential.NodeAddress != testNode || introspection.Credential.OperatorType != OperatorSolo {
+		t.Fatalf("expected the expired credential's claims, got %+v", introspection)
+	}
+
+	// A ttl of 0 is the whole validity window
+	if _, _, issued, err = v.Issue(testNode, OperatorRocketPool, 0); err != nil || !issued.IssuedAt.Equal(now) {
+		t.Fatalf("expected a credential issued now, got %+v, %v", issued, err)
+	}
+	for _, ttl := range []time.Duration{-time.Second, time.Hour + time.Second} {
+		if _, _, _, err := v.Issue(testNode, OperatorRocketPool, ttl); err == nil {
+			t.Fatalf("expected a ttl of %s to be refused", ttl)
+		}
+	}
+
+	// Credentials which don't verify have nothing to describe
+	if introspection, err := v.Introspect(username, password+"A"); err == nil || introspection != nil {
+		t.Fatalf("expected a tampered credential to be refused, got %+v, %v", introspection, err)
+	}
+}
+
+func TestHMACSecretRotation(t *testing.T) {
+	testMetrics(t)
+	v := testHMACVerifier(t, "new", "old")
+
+	// New credentials are signed with the first secret
+	username, password, err := v.Create(testNode, OperatorRocketPool, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac := credentials.AuthenticatedCredential{}
+	if err := ac.Base64URLDecode(username, password); err != nil {
+		t.Fatal(err)
+	}
+	if err := credentials.NewCredentialManager(sha256.New, []byte("new")).Verify(&ac); err != nil {
+		t.Fatalf("expected the credential to be signed with the first secret, got %v", err)
+	}
+
+	// But credentials signed with either are accepted, and counted per secret
+	oldUsername, oldPassword := hmacCredential(t, []byte("old"), time.Now())
+	for _, pair := range [][2]string{{username, password}, {oldUsername, oldPassword}, {oldUsername, oldPassword}} {
+		if _, err := v.Verify(pair[0], pair[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	verifications := v.m.CounterVec("hmac_secret_verifications", "secret_index")
+	for index, expected := range map[string]float64{"0": 1, "1": 2} {
+		if got := testutil.ToFloat64(verifications.WithLabelValues(index)); got != expected {
+			t.Fatalf("expected %v verifications with secret %s, got %v", expected, index, got)
+		}
+	}
+
+	// Once the old secret is removed, its credentials stop working
+	if err := v.SetSecrets([][]byte{[]byte("new")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(oldUsername, oldPassword); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected %v, got %v", ErrInvalid, err)
+	}
+	if _, err := v.Verify(username, password); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.SetSecrets(nil); err == nil {
+		t.Fatal("expected an empty list of secrets to be refused")
+	}
+	if err := v.SetSecrets([][]byte{[]byte("new"), {}}); err == nil {
+		t.Fatal("expected an empty secret to be refused")
+	}
+	if _, err := v.Verify(username, password); err != nil {
+		t.Fatalf("expected refused secrets to leave the current ones in place, got %v", err)
+	}
+}
+
+// operatorTypeCredential signs a credential whose operator type field is set to value, even an unknown one
+func operatorTypeCredential(t *testing.T, secret []byte, value uint64) (string, string) {
+	return unknownFieldCredential(t, secret, testNode.Bytes(), operatorTypeField, value)
+}
+
+// unknownFieldCredential signs a credential for nodeID with a varint unknown field, like HMACVerifier.CreateSubject does
+func unknownFieldCredential(t *testing.T, secret []byte, nodeID []byte, number protowire.Number, value uint64) (string, string) {
+	cm := credentials.NewCredentialManager(sha256.New, secret)
+	cred, err := cm.Create(time.Now(), testNode.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred.Credential.NodeId = nodeID
+	field := protowire.AppendTag(nil, number, protowire.VarintType)
+	cred.Credential.ProtoReflect().SetUnknown(protowire.AppendVarint(field, value))
+	if err := cm.Verify(cred); err == nil {
+		t.Fatal("expected the unknown field to be covered by the MAC")
+	}
+
+	body, err := proto.Marshal(cred.Credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	cred.Mac = mac.Sum(nil)
+
+	password, err := cred.Base64URLEncodePassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cred.Base64URLEncodeUsername(), password
+}
+
+func TestHMACOperatorType(t *testing.T) {
+	testMetrics(t)
+	v := testHMACVerifier(t, "test")
+
+	for _, operatorType := range []OperatorType{OperatorRocketPool, OperatorSolo} {
+		username, password, err := v.Create(testNode, operatorType, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		credential, err := v.Verify(username, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if credential.OperatorType != operatorType {
+			t.Fatalf("expected operator type %s, got %s", operatorType, credential.OperatorType)
+		}
+	}
+
+	if _, _, err := v.Create(testNode, OperatorType("staking-pool"), time.Now()); err == nil {
+		t.Fatal("expected an unknown operator type to be refused")
+	}
+
+	// Credentials with operator types this proxy doesn't know are rejected, rather than treated as Rocket Pool ones
+	username, password := operatorTypeCredential(t, []byte("test"), 7)
+	if _, err := v.Verify(username, password); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("expected %v, got %v", ErrMalformed, err)
+	}
+
+	// Changing the operator type of a credential invalidates it
+	username, password, err := v.Create(testNode, OperatorRocketPool, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac := credentials.AuthenticatedCredential{}
+	if err := ac.Base64URLDecode(username, password); err != nil {
+		t.Fatal(err)
+	}
+	field := protowire.AppendTag(nil, operatorTypeField, protowire.VarintType)
+	ac.Credential.ProtoReflect().SetUnknown(protowire.AppendVarint(field, operatorTypeValues[OperatorSolo]))
+	password, err = ac.Base64URLEncodePassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(username, password); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected %v, got %v", ErrInvalid, err)
+	}
+}
+
+func TestHMACSubjects(t *testing.T) {
+	testMetrics(t)
+	v := testHMACVerifier(t, "test")
+	pubkey := "0x" + strings.Repeat("ab", 48)
+
+	for _, tc := range []struct {
+		subjectType SubjectType
+		subject     string
+		expected    string
+		id          string
+		nodeAddress common.Address
+	}{
+		{SubjectNodeAddress, strings.ToLower(testNode.String()), testNode.String(), strings.ToLower(testNode.String()), testNode},
+		{SubjectValidatorPubkey, strings.ToUpper(pubkey[2:]), pubkey, pubkey, common.Address{}},
+		{SubjectPartner, "acme-staking", "acme-staking", "partner:acme-staking", common.Address{}},
+	} {
+		username, password, issued, err := v.IssueSubject(tc.subjectType, tc.subject, OperatorSolo, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		credential, err := v.Verify(username, password)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.subjectType, err)
+		}
+
+		if credential.SubjectType != tc.subjectType || credential.Subject != tc.expected {
+			t.Fatalf("expected subject %s %s, got %s %s", tc.subjectType, tc.expected, credential.SubjectType, credential.Subject)
+		}
+		if credential.IsNode() != (tc.subjectType == SubjectNodeAddress) {
+			t.Fatalf("%s: unexpected IsNode %v", tc.subjectType, credential.IsNode())
+		}
+		if credential.NodeAddress != tc.nodeAddress {
+			t.Fatalf("%s: expected node address %s, got %s", tc.subjectType, tc.nodeAddress, credential.NodeAddress)
+		}
+		if credential.OperatorType != OperatorSolo {
+			t.Fatalf("%s: expected operator type %s, got %s", tc.subjectType, OperatorSolo, credential.OperatorType)
+		}
+		if !strings.HasPrefix(credential.ID, tc.id+":") {
+			t.Fatalf("%s: expected an ID starting with %s, got %s", tc.subjectType, tc.id, credential.ID)
+		}
+		if !reflect.DeepEqual(credential, issued.Credential) {
+			t.Fatalf("%s: expected the issued credential %+v, got %+v", tc.subjectType, issued.Credential, credential)
+		}
+	}
+
+	// Credentials from before subject types were issued to nodes
+	username, password := hmacCredential(t, []byte("test"), time.Now())
+	credential, err := v.Verify(username, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credential.SubjectType != SubjectNodeAddress || credential.Subject != testNode.String() {
+		t.Fatalf("expected a node address subject, got %s %s", credential.SubjectType, credential.Subject)
+	}
+
+	// Node credentials are unchanged by subject types, so older proxies still accept them
+	username, password, err = v.Create(testNode, OperatorRocketPool, time.Unix(1700000000, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred, err := credentials.NewCredentialManager(sha256.New, []byte("test")).Create(time.Unix(1700000000, 0), testNode.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := cred.Base64URLEncodePassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != cred.Base64URLEncodeUsername() || password != expected {
+		t.Fatal("expected node credentials to be encoded as they were before subject types")
+	}
+
+	for _, tc := range []struct {
+		subjectType SubjectType
+		subject     string
+	}{
+		{SubjectNodeAddress, "0x1234"},
+		{SubjectValidatorPubkey, testNode.String()},
+		{SubjectPartner, ""},
+		{SubjectPartner, "acme staking"},
+		{SubjectPartner, strings.Repeat("a", 65)},
+		{SubjectType("pool"), "acme"},
+	} {
+		if _, _, err := v.CreateSubject(tc.subjectType, tc.subject, OperatorRocketPool, time.Now()); err == nil {
+			t.Fatalf("expected subject %s %q to be refused", tc.subjectType, tc.subject)
+		}
+	}
+
+	// Subject types this proxy doesn't know are rejected, as are subjects of the wrong length for their type
+	for name, nodeID := range map[string][]byte{"unknown subject type": testNode.Bytes(), "short pubkey": testNode.Bytes()} {
+		value := subjectTypeValues[SubjectValidatorPubkey]
+		if name == "unknown subject type" {
+			value = 9
+		}
+		username, password := unknownFieldCredential(t, []byte("test"), nodeID, subjectTypeField, value)
+		if _, err := v.Verify(username, password); !errors.Is(err, ErrMalformed) {
+			t.Fatalf("%s: expected %v, got %v", name, ErrMalformed, err)
+		}
+	}
+
+	// Changing the subject type of a credential invalidates it
+	username, password, err = v.CreateSubject(SubjectPartner, "0123456789abcdefghij", OperatorRocketPool, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac := credentials.AuthenticatedCredential{}
+	if err := ac.Base64URLDecode(username, password); err != nil {
+		t.Fatal(err)
+	}
+	ac.Credential.ProtoReflect().SetUnknown(nil)
+	password, err = ac.Base64URLEncodePassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(username, password); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected %v, got %v", ErrInvalid, err)
+	}
+}
+
+func TestLoadSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets")
+	if err := os.WriteFile(path, []byte("# rotated 2026-10-01\nnew\n\n  old  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	secrets, err := LoadSecrets(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != 2 || string(secrets[0]) != "new" || string(secrets[1]) != "old" {
+		t.Fatalf("unexpected secrets %q", secrets)
+	}
+
+	if err := os.WriteFile(path, []byte("# nothing here\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSecrets(path); err == nil {
+		t.Fatal("expected a file without secrets to be refused")
+	}
+}
+
+// FuzzHMACCredential checks authentic credentials with arbitrary contents, which a leaked or misused secret could produce,
+// are parsed without panicking, and only verify if they name a node and a known operator type
+func FuzzHMACCredential(f *testing.F) {
+	testMetrics(f)
+	v := testHMACVerifier(f, "test")
+	now := time.Unix(1700000000, 0)
+	v.now = func() time.Time { return now }
+
+	operatorType := protowire.AppendVarint(protowire.AppendTag(nil, operatorTypeField, protowire.VarintType), operatorTypeValues[OperatorSolo])
+	f.Add(testNode.Bytes(), now.Unix(), []byte{})
+	f.Add(testNode.Bytes(), now.Unix(), operatorType)
+	f.Add(testNode.Bytes(), now.Unix(), append(protowire.AppendString(protowire.AppendTag(nil, 9, protowire.BytesType), "extra"), operatorType...))
+	f.Add([]byte{}, now.Unix()-1, []byte{0x18})
+	f.Add(bytes.Repeat([]byte{0xff}, 64), int64(-1), []byte{0x1a, 0xff, 0xff, 0xff, 0xff, 0x0f})
+
+	f.Fuzz(func(t *testing.T, nodeID []byte, timestamp int64, unknown []byte) {
+		cm := credentials.NewCredentialManager(sha256.New, []byte("test"))
+		cred, err := cm.Create(time.Unix(timestamp, 0), nodeID)
+		if err != nil {
+			return
+		}
+		cred.Credential.ProtoReflect().SetUnknown(unknown)
+		body, err := proto.Marshal(cred.Credential)
+		if err != nil {
+			return
+		}
+		mac := hmac.New(sha256.New, []byte("test"))
+		mac.Write(body)
+		cred.Mac = mac.Sum(nil)
+		password, err := cred.Base64URLEncodePassword()
+		if err != nil {
+			return
+		}
+
+		credential, err := v.Verify(cred.Base64URLEncodeUsername(), password)
+		if err != nil {
+			return
+		}
+		if credential.NodeAddress != common.BytesToAddress(nodeID) {
+			t.Fatalf("expected node %s, got %s", common.BytesToAddress(nodeID), credential.NodeAddress)
+		}
+		if _, ok := operatorTypeValues[credential.OperatorType]; !ok {
+			t.Fatalf("unexpected operator type %q", credential.OperatorType)
+		}
+	})
+}
@@ -0,0 +1,275 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// htpasswdUser is what the mapping file says about a user. Exactly one of NodeAddress and FeeRecipients is set.
+type htpasswdUser struct {
+	NodeAddress *common.Address `json:"node_address,omitempty"`
+	// Only with NodeAddress. Defaults to rocketpool.
+	OperatorType  OperatorType     `json:"operator_type,omitempty"`
+	FeeRecipients []common.Address `json:"fee_recipients,omitempty"`
+}
+
+func (u *htpasswdUser) validate() error {
+	if (u.NodeAddress == nil) == (len(u.FeeRecipients) == 0) {
+		return errors.New("exactly one of node_address and fee_recipients is required")
+	}
+
+	if u.OperatorType == "" {
+		u.OperatorType = OperatorRocketPool
+		return nil
+	}
+	if u.NodeAddress == nil {
+		return errors.New("operator_type can only be set with node_address")
+	}
+	_, err := ParseOperatorType(string(u.OperatorType))
+	return err
+}
+
+// htpasswdNodeAddress stands in for the node address of a user who's only allowed some fee recipients,
+// so requests are still rate limited and counted per user. Like an Ethereum address, it's the last
+// 20 bytes of a keccak256 hash, so nobody holds its key, and it owns no minipools.
+func htpasswdNodeAddress(username string) common.Address {
+	return common.BytesToAddress(crypto.Keccak256([]byte("rescue-proxy htpasswd user:" + username))[12:])
+}
+
+// HtpasswdVerifier checks plain basic auth usernames and passwords against an htpasswd file of bcrypt hashes.
+// A json mapping file alongside it says which node address each user authenticates as, or which fee recipients
+// they may use:
+//
+//	{"alice": {"node_address": "0x1234...", "operator_type": "solo"}, "bob": {"fee_recipients": ["0x5678..."]}}
+//
+// bcrypt is deliberately slow and every proxied request is authenticated, so each user's password is
+// remembered once it verifies, until the files change.
+type HtpasswdVerifier struct {
+	sync.RWMutex
+	htpasswdPath string
+	mappingPath  string
+	hashes       map[string][]byte
+	users        map[string]*htpasswdUser
+	// sha256 of the last password which verified for each user
+	verified map[string][sha256.Size]byte
+	modTimes [2]time.Time
+	m        *metrics.MetricsRegistry
+}
+
+// LoadHtpasswdVerifier reads users from htpasswdPath, and what they authenticate as from mappingPath
+func LoadHtpasswdVerifier(htpasswdPath, mappingPath string) (*HtpasswdVerifier, error) {
+	out := &HtpasswdVerifier{
+		htpasswdPath: htpasswdPath,
+		mappingPath:  mappingPath,
+		m:            metrics.NewMetricsRegistry("authentication"),
+	}
+	if err := out.Reload(); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func readHtpasswd(path string) (map[string][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	out := make(map[string][]byte)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		entry := bytes.TrimSpace(scanner.Bytes())
+		if len(entry) == 0 || entry[0] == '#' {
+			continue
+		}
+
+		username, hash, ok := strings.Cut(string(entry), ":")
+		if !ok || username == "" {
+			return nil, fmt.Errorf("%s line %d: expected username:hash", path, line)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("%s line %d: only bcrypt hashes are supported: %w", path, line, err)
+		}
+		if _, ok := out[username]; ok {
+			return nil, fmt.Errorf("%s line %d: user %s is listed more than once", path, line, username)
+		}
+		out[username] = []byte(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func readHtpasswdMapping(path string) (map[string]*htpasswdUser, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var out map[string]*htpasswdUser
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	for username, user := range out {
+		if user == nil {
+			return nil, fmt.Errorf("error parsing %s: user %s has no mapping", path, username)
+		}
+		if err := user.validate(); err != nil {
+			return nil, fmt.Errorf("error parsing %s: user %s: %w", path, username, err)
+		}
+	}
+
+	return out, nil
+}
+
+func (h *HtpasswdVerifier) currentModTimes() ([2]time.Time, error) {
+	var out [2]time.Time
+	for i, path := range []string{h.htpasswdPath, h.mappingPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return out, err
+		}
+		out[i] = info.ModTime()
+	}
+
+	return out, nil
+}
+
+// Reload re-reads both files. If either can't be read, or a user in the htpasswd file isn't mapped,
+// the users already loaded are kept.
+func (h *HtpasswdVerifier) Reload() error {
+	modTimes, err := h.currentModTimes()
+	if err != nil {
+		return err
+	}
+
+	hashes, err := readHtpasswd(h.htpasswdPath)
+	if err != nil {
+		return err
+	}
+	users, err := readHtpasswdMapping(h.mappingPath)
+	if err != nil {
+		return err
+	}
+	for username := range hashes {
+		if _, ok := users[username]; !ok {
+			return fmt.Errorf("user %s isn't in %s", username, h.mappingPath)
+		}
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	h.hashes = hashes
+	h.users = users
+	h.verified = make(map[string][sha256.Size]byte)
+	h.modTimes = modTimes
+	h.m.Counter("htpasswd_reloads").Inc()
+	return nil
+}
+
+// Watch checks every interval whether either file has changed, and reloads them if so, until the returned
+// function is called.
+// Requests in flight carry on with the users they were verified against. onReload is called with the
+// result of each reload.
+func (h *HtpasswdVerifier) Watch(interval time.Duration, onReload func(error)) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		// A failed reload is only reported once, not retried until the files change again
+		h.RLock()
+		tried := h.modTimes
+		h.RUnlock()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			modTimes, err := h.currentModTimes()
+			if err != nil || modTimes == tried {
+				continue
+			}
+			tried = modTimes
+			onReload(h.Reload())
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+			<-stopped
+		})
+	}
+}
+
+// Knows returns true if username is one of the htpasswd file's users
+func (h *HtpasswdVerifier) Knows(username string) bool {
+	h.RLock()
+	defer h.RUnlock()
+	_, ok := h.hashes[username]
+	return ok
+}
+
+func (h *HtpasswdVerifier) Verify(username, password string) (*Credential, error) {
+	h.RLock()
+	hash, ok := h.hashes[username]
+	user := h.users[username]
+	verified, cached := h.verified[username]
+	h.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown user", ErrInvalid)
+	}
+
+	sum := sha256.Sum256([]byte(password))
+	if cached && subtle.ConstantTimeCompare(sum[:], verified[:]) == 1 {
+		h.m.Counter("htpasswd_cache_hit").Inc()
+	} else {
+		if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+
+		h.Lock()
+		// Unless the files were reloaded while the password was being checked
+		if current, ok := h.hashes[username]; ok && bytes.Equal(current, hash) {
+			h.verified[username] = sum
+		}
+		h.Unlock()
+	}
+
+	out := &Credential{
+		OperatorType:  user.OperatorType,
+		ID:            "htpasswd:" + username,
+		FeeRecipients: user.FeeRecipients,
+	}
+	if user.NodeAddress != nil {
+		out.NodeAddress = *user.NodeAddress
+	} else {
+		out.NodeAddress = htpasswdNodeAddress(username)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testFeeRecipient = common.HexToAddress("0x3333333333333333333333333333333333333333")
+
+func htpasswdLine(t *testing.T, username, password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return username + ":" + string(hash) + "\n"
+}
+
+// writeHtpasswd writes an htpasswd file and mapping to dir. Rewrites bump their modification times
+// by a second, so a watcher notices them even on filesystems with coarse timestamps.
+func writeHtpasswd(t *testing.T, dir, htpasswd, mapping string) (string, string) {
+	htpasswdPath := filepath.Join(dir, "htpasswd")
+	mappingPath := filepath.Join(dir, "users.json")
+	modTime := time.Now()
+	if info, err := os.Stat(htpasswdPath); err == nil {
+		modTime = info.ModTime().Add(time.Second)
+	}
+	for path, data := range map[string]string{htpasswdPath: htpasswd, mappingPath: mapping} {
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return htpasswdPath, mappingPath
+}
+
+func TestHtpasswdVerifier(t *testing.T) {
+	testMetrics(t)
+	mapping := `{"alice": {"node_address": "` + testNode.String() + `", "operator_type": "solo"},
+		"bob": {"fee_recipients": ["` + testFeeRecipient.String() + `"]}}`
+	htpasswdPath, mappingPath := writeHtpasswd(t, t.TempDir(),
+		"# operators\n"+htpasswdLine(t, "alice", "hunter2")+"\n"+htpasswdLine(t, "bob", "correct horse"), mapping)
+
+	v, err := LoadHtpasswdVerifier(htpasswdPath, mappingPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Knows("alice") || !v.Knows("bob") || v.Knows("carol") {
+		t.Fatal("expected alice and bob to be the only users")
+	}
+
+	credential, err := v.Verify("alice", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credential.NodeAddress != testNode || credential.OperatorType != OperatorSolo || len(credential.FeeRecipients) != 0 {
+		t.Fatalf("unexpected credential %+v", credential)
+	}
+	if credential.ID != "htpasswd:alice" {
+		t.Fatalf("unexpected ID %s", credential.ID)
+	}
+
+	credential, err = v.Verify("bob", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credential.OperatorType != OperatorRocketPool || len(credential.FeeRecipients) != 1 || credential.FeeRecipients[0] != testFeeRecipient {
+		t.Fatalf("unexpected credential %+v", credential)
+	}
+	if credential.NodeAddress != htpasswdNodeAddress("bob") || credential.NodeAddress == htpasswdNodeAddress("alice") {
+		t.Fatalf("expected bob's placeholder node address, got %s", credential.NodeAddress)
+	}
+
+	// The second time, the password is checked against the cache
+	cacheHits := v.m.Counter("htpasswd_cache_hit")
+	if _, err := v.Verify("bob", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if hits := testutil.ToFloat64(cacheHits); hits != 1 {
+		t.Fatalf("expected 1 cache hit, got %v", hits)
+	}
+
+	// Wrong passwords are rejected, cached or not
+	for _, pair := range [][2]string{{"alice", "hunter3"}, {"bob", "correct horse battery"}, {"carol", "hunter2"}} {
+		if _, err := v.Verify(pair[0], pair[1]); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("%s: expected %v, got %v", pair[0], ErrInvalid, err)
+		}
+	}
+	if hits := testutil.ToFloat64(cacheHits); hits != 1 {
+		t.Fatalf("expected 1 cache hit, got %v", hits)
+	}
+}
+
+func TestHtpasswdVerifierInvalidFiles(t *testing.T) {
+	testMetrics(t)
+	alice := htpasswdLine(t, "alice", "hunter2")
+	aliceMapping := `{"alice": {"node_address": "` + testNode.String() + `"}}`
+
+	for _, tc := range []struct {
+		name     string
+		htpasswd string
+		mapping  string
+		contains string
+	}{
+		{"unmapped user", alice + htpasswdLine(t, "bob", "hunter2"), aliceMapping, "isn't in"},
+		{"duplicate user", alice + alice, aliceMapping, "more than once"},
+		{"no hash", "alice\n", aliceMapping, "username:hash"},
+		{"sha1 hash", "alice:{SHA}8mCMLBYAm8ake3+4plDIP1xHNMM=\n", aliceMapping, "only bcrypt"},
+		{"invalid json", alice, `{"alice": `, "error parsing"},
+		{"empty mapping", alice, `{"alice": {}}`, "exactly one"},
+		{"node address and fee recipients", alice,
+			`{"alice": {"node_address": "` + testNode.String() + `", "fee_recipients": ["` + testFeeRecipient.String() + `"]}}`, "exactly one"},
+		{"fee recipients and operator type", alice,
+			`{"alice": {"fee_recipients": ["` + testFeeRecipient.String() + `"], "operator_type": "solo"}}`, "operator_type"},
+		{"unknown operator type", alice,
+			`{"alice": {"node_address": "` + testNode.String() + `", "operator_type": "lido"}}`, "lido"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			htpasswdPath, mappingPath := writeHtpasswd(t, t.TempDir(), tc.htpasswd, tc.mapping)
+			_, err := LoadHtpasswdVerifier(htpasswdPath, mappingPath)
+			if err == nil || !strings.Contains(err.Error(), tc.contains) {
+				t.Fatalf("expected an error containing %q, got %v", tc.contains, err)
+			}
+		})
+	}
+}
+
+func TestHtpasswdVerifierReload(t *testing.T) {
+	testMetrics(t)
+	dir := t.TempDir()
+	htpasswdPath, mappingPath := writeHtpasswd(t, dir, htpasswdLine(t, "alice", "hunter2"),
+		`{"alice": {"node_address": "`+testNode.String()+`"}}`)
+	v, err := LoadHtpasswdVerifier(htpasswdPath, mappingPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify("alice", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	reloads := make(chan error, 1)
+	stop := v.Watch(10*time.Millisecond, func(err error) { reloads <- err })
+	defer stop()
+
+	// A broken mapping is reported, and alice carries on as before
+	writeHtpasswd(t, dir, htpasswdLine(t, "alice", "hunter3"), `{"alice": `)
+	select {
+	case err := <-reloads:
+		if err == nil {
+			t.Fatal("expected the reload to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a reload")
+	}
+	if _, err := v.Verify("alice", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once it's fixed, alice's new password replaces the cached one
+	writeHtpasswd(t, dir, htpasswdLine(t, "alice", "hunter3"), `{"alice": {"node_address": "`+testNode.String()+`"}}`)
+	select {
+	case err := <-reloads:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a reload")
+	}
+	if _, err := v.Verify("alice", "hunter2"); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected the old password to be rejected, got %v", err)
+	}
+	if _, err := v.Verify("alice", "hunter3"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stopping twice is harmless
+	stop()
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// jwtClaims are the claims a JWT credential must carry, alongside exp. jti, if present, is its ID.
+type jwtClaims struct {
+	NodeAddress  string `json:"node_address"`
+	OperatorType string `json:"operator_type"`
+	jwt.RegisteredClaims
+}
+
+// Valid requires exp, which jwt.RegisteredClaims treats as optional
+func (c jwtClaims) Valid() error {
+	if c.ExpiresAt == nil {
+		return errors.New("the exp claim is missing")
+	}
+
+	return c.RegisteredClaims.Valid()
+}
+
+// JWTVerifier verifies JWTs issued by third parties, signed with HS256 or ES256.
+// The username is ignored, since the token carries the node address.
+type JWTVerifier struct {
+	hs256Secret []byte
+	es256Key    *ecdsa.PublicKey
+	parser      *jwt.Parser
+}
+
+// NewJWTVerifier creates a JWTVerifier which accepts HS256 tokens signed with hs256Secret, and ES256 tokens
+// signed with the private half of es256Key. Either may be left empty to reject tokens using that algorithm.
+func NewJWTVerifier(hs256Secret []byte, es256Key *ecdsa.PublicKey) (*JWTVerifier, error) {
+	methods := make([]string, 0, 2)
+	if len(hs256Secret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if es256Key != nil {
+		methods = append(methods, jwt.SigningMethodES256.Alg())
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("an HS256 secret or ES256 public key is required")
+	}
+
+	return &JWTVerifier{
+		hs256Secret: hs256Secret,
+		es256Key:    es256Key,
+		parser:      jwt.NewParser(jwt.WithValidMethods(methods)),
+	}, nil
+}
+
+// LoadES256PublicKey reads a PEM encoded ECDSA public key from path
+func LoadES256PublicKey(path string) (*ecdsa.PublicKey, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return jwt.ParseECPublicKeyFromPEM(pem)
+}
+
+func (j *JWTVerifier) key(token *jwt.Token) (interface{}, error) {
+	// The parser has already checked the algorithm is one we have a key for
+	if token.Method == jwt.SigningMethodES256 {
+		return j.es256Key, nil
+	}
+
+	return j.hs256Secret, nil
+}
+
+func (j *JWTVerifier) Verify(username, password string) (*Credential, error) {
+	var claims jwtClaims
+	_, err := j.parser.ParseWithClaims(password, &claims, j.key)
+	if err != nil {
+		var vErr *jwt.ValidationError
+		switch {
+		case errors.Is(err, jwt.ErrTokenExpired):
+			return nil, ErrExpired
+		case errors.As(err, &vErr) && vErr.Errors&jwt.ValidationErrorMalformed != 0:
+			return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	if !common.IsHexAddress(claims.NodeAddress) {
+		return nil, fmt.Errorf("%w: node_address %q is not an address", ErrMalformed, claims.NodeAddress)
+	}
+
+	operatorType, err := ParseOperatorType(claims.OperatorType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	return &Credential{
+		NodeAddress:  common.HexToAddress(claims.NodeAddress),
+		OperatorType: operatorType,
+		ID:           claims.ID,
+	}, nil
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	testNode   = common.HexToAddress("0x0011223344556677889999887766554433221100")
+	testSecret = []byte("jwt-test")
+)
+
+func testClaims(exp time.Time) jwt.MapClaims {
+	return jwt.MapClaims{
+		"node_address":  testNode.String(),
+		"operator_type": "rocketpool",
+		"exp":           exp.Unix(),
+	}
+}
+
+func sign(t testing.TB, method jwt.SigningMethod, claims jwt.Claims, key interface{}) string {
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func newES256Key(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestJWTVerifierValid(t *testing.T) {
+	esKey := newES256Key(t)
+	v, err := NewJWTVerifier(testSecret, &esKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := testClaims(time.Now().Add(time.Hour))
+	claims["operator_type"] = "solo"
+	for name, token := range map[string]string{
+		"HS256": sign(t, jwt.SigningMethodHS256, claims, testSecret),
+		"ES256": sign(t, jwt.SigningMethodES256, claims, esKey),
+	} {
+		t.Run(name, func(t *testing.T) {
+			credential, err := v.Verify("", token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if credential.NodeAddress != testNode || credential.OperatorType != OperatorSolo {
+				t.Fatalf("unexpected credential %+v", credential)
+			}
+		})
+	}
+}
+
+func TestJWTVerifierRejects(t *testing.T) {
+	esKey := newES256Key(t)
+	otherKey := newES256Key(t)
+	v, err := NewJWTVerifier(testSecret, &esKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hsOnly, err := NewJWTVerifier(testSecret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := testClaims(time.Now().Add(time.Hour))
+	noExp := testClaims(time.Now())
+	delete(noExp, "exp")
+	badNode := testClaims(time.Now().Add(time.Hour))
+	badNode["node_address"] = "0x1234"
+	badOperator := testClaims(time.Now().Add(time.Hour))
+	badOperator["operator_type"] = "lido"
+
+	for _, tc := range []struct {
+		name     string
+		verifier *JWTVerifier
+		token    string
+		err      error
+	}{
+		{"expired", v, sign(t, jwt.SigningMethodHS256, testClaims(time.Now().Add(-time.Minute)), testSecret), ErrExpired},
+		{"expired ES256", v, sign(t, jwt.SigningMethodES256, testClaims(time.Now().Add(-time.Minute)), esKey), ErrExpired},
+		{"malformed", v, "not.a.jwt", ErrMalformed},
+		{"truncated", v, sign(t, jwt.SigningMethodHS256, valid, testSecret)[:20] + ".x.y", ErrMalformed},
+		{"wrong HS256 secret", v, sign(t, jwt.SigningMethodHS256, valid, []byte("wrong")), ErrInvalid},
+		{"wrong ES256 key", v, sign(t, jwt.SigningMethodES256, valid, otherKey), ErrInvalid},
+		{"algorithm not configured", hsOnly, sign(t, jwt.SigningMethodES256, valid, esKey), ErrInvalid},
+		{"unsigned", v, sign(t, jwt.SigningMethodNone, valid, jwt.UnsafeAllowNoneSignatureType), ErrInvalid},
+		{"missing exp", v, sign(t, jwt.SigningMethodHS256, noExp, testSecret), ErrInvalid},
+		{"malformed node address", v, sign(t, jwt.SigningMethodHS256, badNode, testSecret), ErrMalformed},
+		{"unknown operator type", v, sign(t, jwt.SigningMethodHS256, badOperator, testSecret), ErrMalformed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.verifier.Verify("", tc.token)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected %v, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestNewJWTVerifierRequiresKey(t *testing.T) {
+	if _, err := NewJWTVerifier(nil, nil); err == nil {
+		t.Fatal("expected an error without any key material")
+	}
+}
+
+func TestLoadES256PublicKey(t *testing.T) {
+	key := newES256Key(t)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadES256PublicKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Equal(&key.PublicKey) {
+		t.Fatal("expected the loaded key to match")
+	}
+
+	if _, err := LoadES256PublicKey(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ErrRevoked is returned by a Verifier wrapped with Revoking for a valid credential which was revoked
+var ErrRevoked = errors.New("credential revoked")
+
+// RevocationList is a set of revoked node addresses and credential IDs, backed by a file with one per line.
+// Lines with a 0x-prefixed address revoke every credential for that node, and any other line revokes the
+// credential with that ID. Blank lines and lines starting with # are ignored.
+type RevocationList struct {
+	sync.RWMutex
+	path  string
+	nodes map[common.Address]struct{}
+	ids   map[string]struct{}
+}
+
+// LoadRevocationList reads the revocations in path. If path doesn't exist yet, the list starts
+// empty, and the file is created the first time it changes.
+func LoadRevocationList(path string) (*RevocationList, error) {
+	out := &RevocationList{path: path}
+	if err := out.Reload(); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func isNodeRevocation(entry string) bool {
+	return strings.HasPrefix(entry, "0x") && common.IsHexAddress(entry)
+}
+
+// Reload replaces the revocations with the contents of the file
+func (l *RevocationList) Reload() error {
+	nodes := make(map[common.Address]struct{})
+	ids := make(map[string]struct{})
+
+	buf, err := os.ReadFile(l.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for scanner.Scan() {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || entry[0] == '#' {
+			continue
+		}
+
+		if isNodeRevocation(entry) {
+			nodes[common.HexToAddress(entry)] = struct{}{}
+			continue
+		}
+		ids[entry] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	l.nodes = nodes
+	l.ids = ids
+	return nil
+}
+
+// Revoked reports whether c's node, or c itself, was revoked
+func (l *RevocationList) Revoked(c *Credential) bool {
+	l.RLock()
+	defer l.RUnlock()
+
+	if _, ok := l.nodes[c.NodeAddress]; ok && c.IsNode() {
+		return true
+	}
+
+	if c.ID == "" {
+		return false
+	}
+	_, ok := l.ids[c.ID]
+	return ok
+}
+
+// List returns the revoked node addresses and credential IDs, sorted
+func (l *RevocationList) List() ([]common.Address, []string) {
+	l.RLock()
+	defer l.RUnlock()
+
+	nodes := make([]common.Address, 0, len(l.nodes))
+	for node := range l.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return bytes.Compare(nodes[i].Bytes(), nodes[j].Bytes()) < 0
+	})
+
+	ids := make([]string, 0, len(l.ids))
+	for id := range l.ids {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return nodes, ids
+}
+
+// Revoke adds a node address or credential ID to the list, and saves it
+func (l *RevocationList) Revoke(entry string) error {
+	return l.update(entry, true)
+}
+
+// Unrevoke removes a node address or credential ID from the list, and saves it
+func (l *RevocationList) Unrevoke(entry string) error {
+	return l.update(entry, false)
+}
+
+func (l *RevocationList) update(entry string, revoke bool) error {
+	entry = strings.TrimSpace(entry)
+	if entry == "" || entry[0] == '#' || strings.ContainsAny(entry, "\r\n") {
+		return fmt.Errorf("invalid revocation %q", entry)
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	// Apply the change, and undo it if it can't be saved
+	var apply func(revoke bool)
+	if isNodeRevocation(entry) {
+		node := common.HexToAddress(entry)
+		_, was := l.nodes[node]
+		if was == revoke {
+			return nil
+		}
+		apply = func(revoke bool) {
+			if revoke {
+				l.nodes[node] = struct{}{}
+			} else {
+				delete(l.nodes, node)
+			}
+		}
+	} else {
+		_, was := l.ids[entry]
+		if was == revoke {
+			return nil
+		}
+		apply = func(revoke bool) {
+			if revoke {
+				l.ids[entry] = struct{}{}
+			} else {
+				delete(l.ids, entry)
+			}
+		}
+	}
+
+	apply(revoke)
+	if err := l.save(); err != nil {
+		apply(!revoke)
+		return err
+	}
+	return nil
+}
+
+// save writes the list to a temporary file, then renames it over the original, so a crash can't truncate it.
+// The lock must be held.
+func (l *RevocationList) save() error {
+	var buf bytes.Buffer
+	buf.WriteString("# Revoked node addresses and credential IDs, one per line\n")
+
+	nodes := make([]string, 0, len(l.nodes))
+	for node := range l.nodes {
+		nodes = append(nodes, node.String())
+	}
+	sort.Strings(nodes)
+	ids := make([]string, 0, len(l.ids))
+	for id := range l.ids {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, entry := range append(nodes, ids...) {
+		buf.WriteString(entry + "\n")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), "."+filepath.Base(l.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), l.path)
+}
+
+type revokingVerifier struct {
+	Verifier
+	list *RevocationList
+}
+
+// Revoking wraps v, so that credentials it verifies are rejected with ErrRevoked if they're in list
+func Revoking(v Verifier, list *RevocationList) Verifier {
+	return &revokingVerifier{Verifier: v, list: list}
+}
+
+func (r *revokingVerifier) Verify(username, password string) (*Credential, error) {
+	credential, err := r.Verifier.Verify(username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.list.Revoked(credential) {
+		return nil, fmt.Errorf("%w: node %s, credential %q", ErrRevoked, credential.NodeAddress, credential.ID)
+	}
+
+	return credential, nil
+}
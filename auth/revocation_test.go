@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestRevocationList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoked")
+	other := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	if err := os.WriteFile(path, []byte("# leaked\n"+testNode.String()+"\n\n"+other.String()+":1700000000\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := LoadRevocationList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		credential Credential
+		revoked    bool
+	}{
+		{"revoked node", Credential{NodeAddress: testNode, ID: "anything"}, true},
+		{"revoked node without an ID", Credential{NodeAddress: testNode}, true},
+		{"revoked ID", Credential{NodeAddress: other, ID: other.String() + ":1700000000"}, true},
+		{"other ID", Credential{NodeAddress: other, ID: other.String() + ":1700000001"}, false},
+		{"no ID", Credential{NodeAddress: other}, false},
+	} {
+		if revoked := list.Revoked(&tc.credential); revoked != tc.revoked {
+			t.Errorf("%s: expected revoked to be %v", tc.name, tc.revoked)
+		}
+	}
+
+	// Changes are saved, and survive a reload
+	if err := list.Unrevoke(testNode.String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := list.Revoke("jwt-1234"); err != nil {
+		t.Fatal(err)
+	}
+	if err := list.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	nodes, ids := list.List()
+	if len(nodes) != 0 || len(ids) != 2 || ids[1] != "jwt-1234" {
+		t.Fatalf("unexpected revocations %v %v", nodes, ids)
+	}
+
+	if err := list.Revoke(" "); err == nil {
+		t.Fatal("expected a blank revocation to be refused")
+	}
+	if err := list.Revoke("a\nb"); err == nil {
+		t.Fatal("expected a multi-line revocation to be refused")
+	}
+}
+
+func TestRevocationListMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoked")
+	list, err := LoadRevocationList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Revoked(&Credential{NodeAddress: testNode}) {
+		t.Fatal("expected an empty list")
+	}
+
+	// The file is created the first time the list changes
+	if err := list.Revoke(testNode.String()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRevoking(t *testing.T) {
+	testMetrics(t)
+	list, err := LoadRevocationList(filepath.Join(t.TempDir(), "revoked"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmacVerifier := testHMACVerifier(t, "test")
+	v := Revoking(hmacVerifier, list)
+
+	username, password, err := hmacVerifier.Create(testNode, OperatorRocketPool, time.Unix(time.Now().Unix(), 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, err := v.Verify(username, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Revoking the credential's ID locks it out
+	if err := list.Revoke(credential.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(username, password); !errors.Is(err, ErrRevoked) {
+		t.Fatalf("expected %v, got %v", ErrRevoked, err)
+	}
+
+	// But other credentials for the same node still work, until the node is revoked
+	username, password, err = hmacVerifier.Create(testNode, OperatorRocketPool, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(username, password); err != nil {
+		t.Fatal(err)
+	}
+	if err := list.Revoke(testNode.String()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(username, password); !errors.Is(err, ErrRevoked) {
+		t.Fatalf("expected %v, got %v", ErrRevoked, err)
+	}
+
+	// Credentials which fail verification fail as usual
+	if _, err := v.Verify(username, "bogus"); !errors.Is(err, ErrMalformed) && !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected the underlying error, got %v", err)
+	}
+}
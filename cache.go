@@ -0,0 +1,171 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/executionlayer"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Exit codes of the cache subcommand, which follow diff(1)'s
+const (
+	cacheSame      = 0
+	cacheDifferent = 1
+	cacheFailed    = 2
+)
+
+const cacheUsageText = `Usage: rescue-proxy cache diff <a.json.gz> <b.json.gz>
+
+Compares two exports of the EL cache, downloaded from /admin/cache/export on a proxy's -inspect-addr,
+and prints the nodes and minipools added (+), removed (-) and changed (~) from a to b.
+
+Exits with 0 if they're the same, 1 if they differ, and 2 for errors.
+`
+
+// runCache runs the cache subcommand with args, the command line after "cache", and returns its exit code
+func runCache(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) != 3 || args[0] != "diff" {
+		fmt.Fprint(stderr, cacheUsageText)
+		return cacheFailed
+	}
+
+	a, err := readCacheExport(args[1])
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return cacheFailed
+	}
+	b, err := readCacheExport(args[2])
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return cacheFailed
+	}
+
+	if diffCacheExports(stdout, a, b) == 0 {
+		return cacheSame
+	}
+	return cacheDifferent
+}
+
+func readCacheExport(path string) (*executionlayer.CacheExport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	out := &executionlayer.CacheExport{}
+	if err := json.NewDecoder(gz).Decode(out); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return out, nil
+}
+
+// exportNodeFields returns a node's fields as name=value pairs, in a fixed order, so they can be printed and compared
+func exportNodeFields(node executionlayer.ExportNode) [][2]string {
+	return [][2]string{
+		{"smoothing_pool", fmt.Sprint(node.InSmoothingPool)},
+		{"fee_distributor", node.FeeDistributor.String()},
+		{"withdrawal_address", node.WithdrawalAddress.String()},
+		{"rpl_stake", node.RPLStake},
+		{"bond", node.Bond},
+		{"unknown_fields", strings.Join(node.UnknownFields, ",")},
+	}
+}
+
+func sortedAddresses(nodes ...map[common.Address]executionlayer.ExportNode) []common.Address {
+	seen := make(map[common.Address]bool)
+	var out []common.Address
+	for _, m := range nodes {
+		for addr := range m {
+			if !seen[addr] {
+				seen[addr] = true
+				out = append(out, addr)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Hex() < out[j].Hex()
+	})
+	return out
+}
+
+func sortedPubkeys(minipools ...map[string]common.Address) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, m := range minipools {
+		for pubkey := range m {
+			if !seen[pubkey] {
+				seen[pubkey] = true
+				out = append(out, pubkey)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// diffCacheExports prints the entries added, removed and changed from a to b, and returns how many there were
+func diffCacheExports(w io.Writer, a *executionlayer.CacheExport, b *executionlayer.CacheExport) int {
+	fmt.Fprintf(w, "version: %s -> %s\n", a.Version, b.Version)
+	fmt.Fprintf(w, "highest_block: %d -> %d\n", a.HighestBlock, b.HighestBlock)
+
+	added, removed, changed := 0, 0, 0
+	for _, addr := range sortedAddresses(a.Nodes, b.Nodes) {
+		before, inA := a.Nodes[addr]
+		after, inB := b.Nodes[addr]
+		switch {
+		case !inA:
+			added++
+			fields := make([]string, 0)
+			for _, field := range exportNodeFields(after) {
+				fields = append(fields, field[0]+"="+field[1])
+			}
+			fmt.Fprintf(w, "+ node %s %s\n", addr, strings.Join(fields, " "))
+		case !inB:
+			removed++
+			fmt.Fprintf(w, "- node %s\n", addr)
+		default:
+			var changes []string
+			afterFields := exportNodeFields(after)
+			for i, field := range exportNodeFields(before) {
+				if field[1] != afterFields[i][1] {
+					changes = append(changes, fmt.Sprintf("%s %s -> %s", field[0], field[1], afterFields[i][1]))
+				}
+			}
+			if len(changes) > 0 {
+				changed++
+				fmt.Fprintf(w, "~ node %s: %s\n", addr, strings.Join(changes, ", "))
+			}
+		}
+	}
+
+	for _, pubkey := range sortedPubkeys(a.Minipools, b.Minipools) {
+		before, inA := a.Minipools[pubkey]
+		after, inB := b.Minipools[pubkey]
+		switch {
+		case !inA:
+			added++
+			fmt.Fprintf(w, "+ minipool %s node=%s\n", pubkey, after)
+		case !inB:
+			removed++
+			fmt.Fprintf(w, "- minipool %s\n", pubkey)
+		case before != after:
+			changed++
+			fmt.Fprintf(w, "~ minipool %s: node %s -> %s\n", pubkey, before, after)
+		}
+	}
+
+	fmt.Fprintf(w, "%d added, %d removed, %d changed\n", added, removed, changed)
+	return added + removed + changed
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/executionlayer"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+func writeCacheExport(t *testing.T, export *executionlayer.CacheExport) string {
+	path := filepath.Join(t.TempDir(), "cache.json.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	if err := json.NewEncoder(gz).Encode(export); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func cacheDiff(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := runCache(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCacheDiff(t *testing.T) {
+	otherNode := common.HexToAddress("0x4444444444444444444444444444444444444444")
+	otherPubkey := rptypes.BytesToValidatorPubkey(bytes.Repeat([]byte{0xcd}, 48))
+	newPubkey := rptypes.BytesToValidatorPubkey(bytes.Repeat([]byte{0xef}, 48))
+
+	a := writeCacheExport(t, &executionlayer.CacheExport{
+		Version:      "v1.0.0",
+		HighestBlock: 100,
+		Nodes: map[common.Address]executionlayer.ExportNode{
+			queryNodeAddr: {FeeDistributor: queryFeeDistributor},
+			otherNode:     {FeeDistributor: queryFeeDistributor},
+		},
+		Minipools: map[string]common.Address{
+			queryPubkey.String(): queryNodeAddr,
+			otherPubkey.String(): otherNode,
+		},
+	})
+	b := writeCacheExport(t, &executionlayer.CacheExport{
+		Version:      "v1.0.0",
+		HighestBlock: 120,
+		Nodes: map[common.Address]executionlayer.ExportNode{
+			queryNodeAddr: {InSmoothingPool: true, FeeDistributor: queryFeeDistributor},
+		},
+		Minipools: map[string]common.Address{
+			queryPubkey.String(): queryNodeAddr,
+			otherPubkey.String(): queryNodeAddr,
+			newPubkey.String():   queryNodeAddr,
+		},
+	})
+
+	code, stdout, stderr := cacheDiff("diff", a, b)
+	if code != cacheDifferent {
+		t.Fatalf("expected exit code %d, got %d: %s", cacheDifferent, code, stderr)
+	}
+	for _, line := range []string{
+		"highest_block: 100 -> 120\n",
+		"~ node " + queryNodeAddr.String() + ": smoothing_pool false -> true\n",
+		"- node " + otherNode.String() + "\n",
+		"~ minipool " + otherPubkey.String() + ": node " + otherNode.String() + " -> " + queryNodeAddr.String() + "\n",
+		"+ minipool " + newPubkey.String() + " node=" + queryNodeAddr.String() + "\n",
+		"1 added, 1 removed, 2 changed\n",
+	} {
+		if !strings.Contains(stdout, line) {
+			t.Fatalf("expected %q in the diff, got\n%s", line, stdout)
+		}
+	}
+	if strings.Contains(stdout, queryPubkey.String()) {
+		t.Fatalf("expected unchanged minipools to be left out, got\n%s", stdout)
+	}
+
+	// An export doesn't differ from itself
+	if code, stdout, _ := cacheDiff("diff", a, a); code != cacheSame || !strings.HasSuffix(stdout, "0 added, 0 removed, 0 changed\n") {
+		t.Fatalf("expected no differences, got %d\n%s", code, stdout)
+	}
+}
+
+func TestCacheDiffErrors(t *testing.T) {
+	if code, _, stderr := cacheDiff("diff", "a.json.gz"); code != cacheFailed || !strings.Contains(stderr, "Usage") {
+		t.Fatalf("expected usage, got %d %s", code, stderr)
+	}
+
+	notGzip := filepath.Join(t.TempDir(), "cache.json")
+	if err := os.WriteFile(notGzip, []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if code, _, stderr := cacheDiff("diff", notGzip, notGzip); code != cacheFailed || !strings.Contains(stderr, notGzip) {
+		t.Fatalf("expected an error naming the file, got %d %s", code, stderr)
+	}
+}
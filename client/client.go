@@ -0,0 +1,422 @@
+// Package client is a Go client for the proxy's gRPC API, for services which want to ask it about Rocket Pool
+// without dialing pb.ApiClient themselves.
+//
+// It only depends on the generated pb package, grpc, go-ethereum's common package and klauspost/compress's zstd
+// package, so importing it doesn't pull in the proxy's execution layer, rocketpool-go, or anything else the server needs.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/internal/compression"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/pb"
+	"github.com/ethereum/go-ethereum/common"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+)
+
+const defaultTimeout = 10 * time.Second
+const defaultMaxRetries = 3
+const defaultRetryWait = 250 * time.Millisecond
+const maxRetryWait = 5 * time.Second
+
+// ErrNotFound is wrapped by the errors returned for nodes and validators the proxy doesn't know
+var ErrNotFound = errors.New("not found")
+
+// Config describes how to connect to the API. Only Addr is required.
+type Config struct {
+	// The address the proxy serves the API on, its -api-addr. Unix sockets are given as unix:///path/to/socket
+	Addr string
+
+	// Used as-is to connect with TLS, if set. Otherwise, CAFile enables TLS, and CertFile and KeyFile
+	// are presented to proxies started with -api-tls-client-ca-file. Without either, the connection is plaintext.
+	TLS      *tls.Config
+	CAFile   string
+	CertFile string
+	KeyFile  string
+
+	// Sent as a bearer token with every call, for the admin RPCs, if set
+	AdminToken string
+
+	// How long each call, including its retries, may take if its context has no deadline. Defaults to 10s
+	Timeout time.Duration
+	// How many times a call which fails because the API is unavailable is retried, waiting RetryWait before
+	// the first retry and doubling the wait after each. They default to 3 and 250ms. A negative MaxRetries disables retries.
+	MaxRetries int
+	RetryWait  time.Duration
+
+	// The compressor the API is asked to compress its responses with, zstd or gzip, or none to leave them
+	// uncompressed. Defaults to none, since node addresses are random and make up most of a response, so
+	// compression saves little. It may still be worth it for detailed node lists over a slow link.
+	Compression string
+}
+
+// Client calls the proxy's API. It's safe for concurrent use.
+type Client struct {
+	conn *grpc.ClientConn
+	api  pb.ApiClient
+
+	timeout    time.Duration
+	maxRetries int
+	retryWait  time.Duration
+}
+
+// New connects to the API described by config.
+// Connecting happens in the background, so an unreachable proxy makes calls fail rather than New.
+func New(config Config) (*Client, error) {
+	if config.Addr == "" {
+		return nil, errors.New("an address is required")
+	}
+
+	tc, err := transportCredentials(config)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &Client{
+		timeout:    config.Timeout,
+		maxRetries: config.MaxRetries,
+		retryWait:  config.RetryWait,
+	}
+	if out.timeout <= 0 {
+		out.timeout = defaultTimeout
+	}
+	if out.maxRetries == 0 {
+		out.maxRetries = defaultMaxRetries
+	}
+	if out.retryWait <= 0 {
+		out.retryWait = defaultRetryWait
+	}
+
+	compressor := config.Compression
+	if compressor == "" {
+		compressor = "none"
+	}
+	if compressor != "none" && encoding.GetCompressor(compressor) == nil {
+		return nil, fmt.Errorf("unknown compression %q, expected one of %s or none", compressor, strings.Join(compression.Names(), ", "))
+	}
+
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(tc),
+		grpc.WithUnaryInterceptor(out.unaryInterceptor),
+	}
+	if compressor != "none" {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.UseCompressor(compressor)))
+	}
+	if config.AdminToken != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(bearerToken{token: config.AdminToken, secure: tc.Info().SecurityProtocol != "insecure"}))
+	}
+
+	out.conn, err = grpc.Dial(config.Addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	out.api = pb.NewApiClient(out.conn)
+	return out, nil
+}
+
+// Close disconnects from the API
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// API returns the underlying generated client, eg, for the streaming RPCs.
+// Its unary calls get the same deadlines and retries as Client's.
+func (c *Client) API() pb.ApiClient {
+	return c.api
+}
+
+func transportCredentials(config Config) (credentials.TransportCredentials, error) {
+	if config.TLS != nil {
+		return credentials.NewTLS(config.TLS), nil
+	}
+
+	if config.CAFile == "" {
+		if config.CertFile != "" {
+			return nil, errors.New("CertFile requires CAFile")
+		}
+		return insecure.NewCredentials(), nil
+	}
+
+	pem, err := os.ReadFile(config.CAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", config.CAFile)
+	}
+
+	tlsConfig := &tls.Config{RootCAs: pool}
+	if config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// bearerToken sends the admin token in the authorization metadata, like api/client does
+type bearerToken struct {
+	token  string
+	secure bool
+}
+
+func (b bearerToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + b.token}, nil
+}
+
+// RequireTransportSecurity is false for plaintext connections, eg, to a unix socket, so the token can still be sent
+func (b bearerToken) RequireTransportSecurity() bool {
+	return b.secure
+}
+
+// unaryInterceptor applies the default deadline, and retries calls which failed because the API was unavailable,
+// eg, while the proxy restarts
+func (c *Client) unaryInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	wait := c.retryWait
+	for attempt := 0; ; attempt++ {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if status.Code(err) != codes.Unavailable || attempt >= c.maxRetries {
+			return err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		wait *= 2
+		if wait > maxRetryWait {
+			wait = maxRetryWait
+		}
+	}
+}
+
+// notFound wraps NotFound errors from the API in ErrNotFound, keeping the API's description of what wasn't found
+func notFound(err error) error {
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("%w: %s", ErrNotFound, status.Convert(err).Message())
+	}
+	return err
+}
+
+// ValidatorPubkey is a validator's BLS public key
+type ValidatorPubkey [48]byte
+
+// ParseValidatorPubkey parses a hex encoded pubkey, with or without its 0x prefix
+func ParseValidatorPubkey(s string) (ValidatorPubkey, error) {
+	var out ValidatorPubkey
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return out, err
+	}
+	if len(b) != len(out) {
+		return out, fmt.Errorf("pubkey must be %d bytes, got %d", len(out), len(b))
+	}
+	copy(out[:], b)
+	return out, nil
+}
+
+// String returns the pubkey hex encoded, with a 0x prefix
+func (p ValidatorPubkey) String() string {
+	return "0x" + hex.EncodeToString(p[:])
+}
+
+// Node is a Rocket Pool node in the proxy's cache
+type Node struct {
+	Address        common.Address
+	SmoothingPool  bool
+	FeeDistributor common.Address
+}
+
+// NodeInfo is a Rocket Pool node in the proxy's cache, with its minipools
+type NodeInfo struct {
+	Node
+	MinipoolPubkeys []ValidatorPubkey
+}
+
+// NodeMinipools are the pubkeys of a node's minipools
+type NodeMinipools struct {
+	Pubkeys []ValidatorPubkey
+	// The block the pubkeys are current as of
+	Block uint64
+}
+
+// FeeRecipient is the fee recipient the proxy expects a minipool validator to use
+type FeeRecipient struct {
+	FeeRecipient common.Address
+	// Whether the fee recipient is the smoothing pool, rather than the node's fee distributor
+	SmoothingPool bool
+	NodeAddress   common.Address
+}
+
+// SmoothingPoolInfo is the smoothing pool's address, and how many of the proxy's nodes are opted in to it
+type SmoothingPoolInfo struct {
+	Address      common.Address
+	OptedInNodes uint64
+	Nodes        uint64
+	// The block the counts are current as of
+	Block uint64
+}
+
+// Credential is a credential issued by CreateCredential
+type Credential struct {
+	Username  string
+	Password  string
+	ID        string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// RocketPoolNodes returns the address of every node in the proxy's cache
+func (c *Client) RocketPoolNodes(ctx context.Context) ([]common.Address, error) {
+	r, err := c.api.GetRocketPoolNodes(ctx, &pb.RocketPoolNodesRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]common.Address, 0, len(r.GetNodeIds()))
+	for _, nodeID := range r.GetNodeIds() {
+		out = append(out, common.BytesToAddress(nodeID))
+	}
+	return out, nil
+}
+
+// RocketPoolNodeDetails returns every node in the proxy's cache, with its smoothing pool status and fee distributor
+func (c *Client) RocketPoolNodeDetails(ctx context.Context) ([]Node, error) {
+	r, err := c.api.GetRocketPoolNodes(ctx, &pb.RocketPoolNodesRequest{IncludeDetails: true})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]Node, 0, len(r.GetNodes()))
+	for _, node := range r.GetNodes() {
+		out = append(out, Node{
+			Address:        common.BytesToAddress(node.GetNodeId()),
+			SmoothingPool:  node.GetSmoothingPool(),
+			FeeDistributor: common.BytesToAddress(node.GetFeeDistributor()),
+		})
+	}
+	return out, nil
+}
+
+// NodeInfo returns a node's smoothing pool status, fee distributor and minipools.
+// The error wraps ErrNotFound if the proxy doesn't know the node.
+func (c *Client) NodeInfo(ctx context.Context, nodeAddr common.Address) (*NodeInfo, error) {
+	r, err := c.api.GetNodeInfo(ctx, &pb.NodeInfoRequest{NodeId: nodeAddr.Bytes()})
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	out := &NodeInfo{
+		Node: Node{
+			Address:        nodeAddr,
+			SmoothingPool:  r.GetSmoothingPool(),
+			FeeDistributor: common.BytesToAddress(r.GetFeeDistributor()),
+		},
+		MinipoolPubkeys: make([]ValidatorPubkey, 0, len(r.GetMinipoolPubkeys())),
+	}
+	for _, b := range r.GetMinipoolPubkeys() {
+		var pubkey ValidatorPubkey
+		copy(pubkey[:], b)
+		out.MinipoolPubkeys = append(out.MinipoolPubkeys, pubkey)
+	}
+	return out, nil
+}
+
+// NodeMinipools returns the pubkeys of a node's minipools, eg, to check a validator claimed by a node is its own.
+// The error wraps ErrNotFound if the proxy doesn't know the node.
+func (c *Client) NodeMinipools(ctx context.Context, nodeAddr common.Address) (*NodeMinipools, error) {
+	r, err := c.api.GetNodeMinipools(ctx, &pb.NodeMinipoolsRequest{NodeId: nodeAddr.Bytes()})
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	out := &NodeMinipools{
+		Pubkeys: make([]ValidatorPubkey, 0, len(r.GetPubkeys())),
+		Block:   r.GetBlock(),
+	}
+	for _, b := range r.GetPubkeys() {
+		var pubkey ValidatorPubkey
+		copy(pubkey[:], b)
+		out.Pubkeys = append(out.Pubkeys, pubkey)
+	}
+	return out, nil
+}
+
+// ValidatorFeeRecipient returns the fee recipient the proxy expects a minipool validator to use.
+// The error wraps ErrNotFound if the validator isn't a known minipool.
+func (c *Client) ValidatorFeeRecipient(ctx context.Context, pubkey ValidatorPubkey) (*FeeRecipient, error) {
+	r, err := c.api.GetValidatorFeeRecipient(ctx, &pb.ValidatorFeeRecipientRequest{Pubkey: pubkey[:]})
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return &FeeRecipient{
+		FeeRecipient:  common.BytesToAddress(r.GetFeeRecipient()),
+		SmoothingPool: r.GetSmoothingPool(),
+		NodeAddress:   common.BytesToAddress(r.GetNodeId()),
+	}, nil
+}
+
+// SmoothingPoolInfo returns the smoothing pool's address, and how many of the proxy's nodes are opted in to it
+func (c *Client) SmoothingPoolInfo(ctx context.Context) (*SmoothingPoolInfo, error) {
+	r, err := c.api.GetSmoothingPoolInfo(ctx, &pb.SmoothingPoolInfoRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &SmoothingPoolInfo{
+		Address:      common.BytesToAddress(r.GetAddress()),
+		OptedInNodes: r.GetOptedInNodes(),
+		Nodes:        r.GetNodes(),
+		Block:        r.GetBlock(),
+	}, nil
+}
+
+// CreateCredential issues a credential for nodeAddr, valid for ttl, or the proxy's whole validity window if it's 0.
+// It's an admin RPC, so the client needs an AdminToken or a trusted certificate.
+func (c *Client) CreateCredential(ctx context.Context, nodeAddr common.Address, operatorType pb.OperatorType, ttl time.Duration) (*Credential, error) {
+	r, err := c.api.CreateCredential(ctx, &pb.CreateCredentialRequest{
+		NodeId:       nodeAddr.Bytes(),
+		OperatorType: operatorType,
+		TtlSeconds:   uint64(ttl / time.Second),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		Username:  r.GetUsername(),
+		Password:  r.GetPassword(),
+		ID:        r.GetCredentialId(),
+		IssuedAt:  time.Unix(r.GetIssuedAt(), 0).UTC(),
+		ExpiresAt: time.Unix(r.GetExpiresAt(), 0).UTC(),
+	}, nil
+}
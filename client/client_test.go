@@ -0,0 +1,260 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/client"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/pb"
+	"github.com/ethereum/go-ethereum/common"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	testNode           = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	testFeeDistributor = common.HexToAddress("0x2222222222222222222222222222222222222222")
+	testSmoothingPool  = common.HexToAddress("0x3333333333333333333333333333333333333333")
+	testPubkey         = client.ValidatorPubkey{0xab}
+)
+
+// fakeAPI knows testNode, with testPubkey as its one minipool. It fails the first unavailable calls made to it.
+type fakeAPI struct {
+	pb.UnimplementedApiServer
+
+	unavailable atomic.Int32
+	calls       atomic.Int32
+	// The authorization metadata of the last CreateCredential call
+	authorization atomic.Value
+}
+
+func (f *fakeAPI) fail() error {
+	f.calls.Add(1)
+	if f.unavailable.Add(-1) >= 0 {
+		return status.Error(codes.Unavailable, "restarting")
+	}
+	return nil
+}
+
+func (f *fakeAPI) GetRocketPoolNodes(ctx context.Context, request *pb.RocketPoolNodesRequest) (*pb.RocketPoolNodes, error) {
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+
+	out := &pb.RocketPoolNodes{NodeIds: [][]byte{testNode.Bytes()}}
+	if request.GetIncludeDetails() {
+		out.Nodes = []*pb.RocketPoolNode{{NodeId: testNode.Bytes(), SmoothingPool: true, FeeDistributor: testFeeDistributor.Bytes()}}
+	}
+	return out, nil
+}
+
+func (f *fakeAPI) GetNodeInfo(ctx context.Context, request *pb.NodeInfoRequest) (*pb.NodeInfo, error) {
+	if common.BytesToAddress(request.GetNodeId()) != testNode {
+		return nil, status.Error(codes.NotFound, "node is not a known rocket pool node")
+	}
+	return &pb.NodeInfo{FeeDistributor: testFeeDistributor.Bytes(), MinipoolPubkeys: [][]byte{testPubkey[:]}}, nil
+}
+
+func (f *fakeAPI) GetNodeMinipools(ctx context.Context, request *pb.NodeMinipoolsRequest) (*pb.NodeMinipools, error) {
+	if common.BytesToAddress(request.GetNodeId()) != testNode {
+		return nil, status.Error(codes.NotFound, "node is not a known rocket pool node")
+	}
+	return &pb.NodeMinipools{Pubkeys: [][]byte{testPubkey[:]}, Block: 100}, nil
+}
+
+func (f *fakeAPI) GetValidatorFeeRecipient(ctx context.Context, request *pb.ValidatorFeeRecipientRequest) (*pb.ValidatorFeeRecipient, error) {
+	if !bytes.Equal(request.GetPubkey(), testPubkey[:]) {
+		return nil, status.Error(codes.NotFound, "validator is not a known minipool")
+	}
+	return &pb.ValidatorFeeRecipient{FeeRecipient: testFeeDistributor.Bytes(), NodeId: testNode.Bytes()}, nil
+}
+
+func (f *fakeAPI) GetSmoothingPoolInfo(ctx context.Context, request *pb.SmoothingPoolInfoRequest) (*pb.SmoothingPoolInfo, error) {
+	return &pb.SmoothingPoolInfo{Address: testSmoothingPool.Bytes(), OptedInNodes: 1, Nodes: 1, Block: 100}, nil
+}
+
+func (f *fakeAPI) CreateCredential(ctx context.Context, request *pb.CreateCredentialRequest) (*pb.Credential, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	f.authorization.Store(md.Get("authorization"))
+	return &pb.Credential{Username: "user", Password: "pass", IssuedAt: 1700000000, ExpiresAt: 1700000000 + int64(request.GetTtlSeconds())}, nil
+}
+
+// IntrospectCredential never answers, so deadlines can be tested
+func (f *fakeAPI) IntrospectCredential(ctx context.Context, request *pb.IntrospectCredentialRequest) (*pb.CredentialInfo, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// serveFakeAPI serves a fakeAPI until stop is called, and returns the address it listens on
+func serveFakeAPI() (*fakeAPI, string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	fake := &fakeAPI{}
+	server := grpc.NewServer()
+	pb.RegisterApiServer(server, fake)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	return fake, listener.Addr().String(), server.Stop
+}
+
+func newClient(t *testing.T, config client.Config) (*fakeAPI, *client.Client) {
+	fake, addr, stop := serveFakeAPI()
+	t.Cleanup(stop)
+
+	config.Addr = addr
+	c, err := client.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+	})
+	return fake, c
+}
+
+func TestClient(t *testing.T) {
+	_, c := newClient(t, client.Config{})
+	ctx := context.Background()
+
+	nodes, err := c.RocketPoolNodes(ctx)
+	if err != nil || len(nodes) != 1 || nodes[0] != testNode {
+		t.Fatalf("expected testNode, got %v %v", nodes, err)
+	}
+
+	details, err := c.RocketPoolNodeDetails(ctx)
+	if err != nil || len(details) != 1 || details[0] != (client.Node{Address: testNode, SmoothingPool: true, FeeDistributor: testFeeDistributor}) {
+		t.Fatalf("expected testNode's details, got %+v %v", details, err)
+	}
+
+	info, err := c.NodeInfo(ctx, testNode)
+	if err != nil || info.Address != testNode || info.FeeDistributor != testFeeDistributor || len(info.MinipoolPubkeys) != 1 || info.MinipoolPubkeys[0] != testPubkey {
+		t.Fatalf("expected testNode's info, got %+v %v", info, err)
+	}
+
+	feeRecipient, err := c.ValidatorFeeRecipient(ctx, testPubkey)
+	if err != nil || *feeRecipient != (client.FeeRecipient{FeeRecipient: testFeeDistributor, NodeAddress: testNode}) {
+		t.Fatalf("expected testNode's fee distributor, got %+v %v", feeRecipient, err)
+	}
+
+	minipools, err := c.NodeMinipools(ctx, testNode)
+	if err != nil || len(minipools.Pubkeys) != 1 || minipools.Pubkeys[0] != testPubkey || minipools.Block != 100 {
+		t.Fatalf("expected testNode's minipool, got %+v %v", minipools, err)
+	}
+
+	sp, err := c.SmoothingPoolInfo(ctx)
+	if err != nil || *sp != (client.SmoothingPoolInfo{Address: testSmoothingPool, OptedInNodes: 1, Nodes: 1, Block: 100}) {
+		t.Fatalf("expected the smoothing pool's info, got %+v %v", sp, err)
+	}
+}
+
+func TestClientNotFound(t *testing.T) {
+	_, c := newClient(t, client.Config{})
+
+	if _, err := c.NodeInfo(context.Background(), testFeeDistributor); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an unknown node, got %v", err)
+	}
+	if _, err := c.NodeMinipools(context.Background(), testFeeDistributor); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an unknown node's minipools, got %v", err)
+	}
+	if _, err := c.ValidatorFeeRecipient(context.Background(), client.ValidatorPubkey{0xcd}); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an unknown validator, got %v", err)
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	fake, c := newClient(t, client.Config{MaxRetries: 2, RetryWait: time.Millisecond})
+
+	// Two retries are enough to get past two failures
+	fake.unavailable.Store(2)
+	if _, err := c.RocketPoolNodes(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if fake.calls.Load() != 3 {
+		t.Fatalf("expected 3 attempts, got %d", fake.calls.Load())
+	}
+
+	// But not three
+	fake.calls.Store(0)
+	fake.unavailable.Store(3)
+	if _, err := c.RocketPoolNodes(context.Background()); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected the last attempt's error, got %v", err)
+	}
+	if fake.calls.Load() != 3 {
+		t.Fatalf("expected 3 attempts, got %d", fake.calls.Load())
+	}
+}
+
+func TestClientNoRetries(t *testing.T) {
+	fake, c := newClient(t, client.Config{MaxRetries: -1})
+
+	fake.unavailable.Store(1)
+	if _, err := c.RocketPoolNodes(context.Background()); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected the call not to be retried, got %v", err)
+	}
+	if fake.calls.Load() != 1 {
+		t.Fatalf("expected 1 attempt, got %d", fake.calls.Load())
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	_, c := newClient(t, client.Config{Timeout: 50 * time.Millisecond})
+
+	_, err := c.API().IntrospectCredential(context.Background(), &pb.IntrospectCredentialRequest{})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expected the default deadline to apply, got %v", err)
+	}
+}
+
+func TestClientAdminToken(t *testing.T) {
+	fake, c := newClient(t, client.Config{AdminToken: "secret"})
+
+	credential, err := c.CreateCredential(context.Background(), testNode, pb.OperatorType_SOLO, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credential.Username != "user" || credential.ExpiresAt.Sub(credential.IssuedAt) != time.Hour {
+		t.Fatalf("unexpected credential %+v", credential)
+	}
+	if authorization, _ := fake.authorization.Load().([]string); len(authorization) != 1 || authorization[0] != "Bearer secret" {
+		t.Fatalf("expected the admin token to be sent, got %v", authorization)
+	}
+}
+
+func TestParseValidatorPubkey(t *testing.T) {
+	pubkey, err := client.ParseValidatorPubkey(testPubkey.String())
+	if err != nil || pubkey != testPubkey {
+		t.Fatalf("expected %s, got %s %v", testPubkey, pubkey, err)
+	}
+
+	for _, s := range []string{"0x1234", "0xzz"} {
+		if _, err := client.ParseValidatorPubkey(s); err == nil {
+			t.Fatalf("expected %q to be refused", s)
+		}
+	}
+}
+
+func TestClientCompression(t *testing.T) {
+	// The server answers compressed calls the same way, so each compressor round trips
+	for _, compression := range []string{"", "zstd", "gzip", "none"} {
+		_, c := newClient(t, client.Config{Compression: compression})
+		details, err := c.RocketPoolNodeDetails(context.Background())
+		if err != nil || len(details) != 1 || details[0].FeeDistributor != testFeeDistributor {
+			t.Fatalf("%q: expected testNode's details, got %v %v", compression, details, err)
+		}
+	}
+
+	if _, err := client.New(client.Config{Addr: "127.0.0.1:1", Compression: "brotli"}); err == nil {
+		t.Fatal("expected an unknown compression to be refused")
+	}
+}
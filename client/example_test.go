@@ -0,0 +1,69 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/client"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func ExampleClient_RocketPoolNodes() {
+	// A stand-in for a running proxy's -api-addr
+	_, addr, stop := serveFakeAPI()
+	defer stop()
+
+	c, err := client.New(client.Config{Addr: addr})
+	if err != nil {
+		panic(err)
+	}
+	defer c.Close()
+
+	nodes, err := c.RocketPoolNodes(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	for _, node := range nodes {
+		fmt.Println(node)
+	}
+	// Output: 0x1111111111111111111111111111111111111111
+}
+
+func ExampleClient_ValidatorFeeRecipient() {
+	_, addr, stop := serveFakeAPI()
+	defer stop()
+
+	c, err := client.New(client.Config{Addr: addr})
+	if err != nil {
+		panic(err)
+	}
+	defer c.Close()
+
+	pubkey, err := client.ParseValidatorPubkey("0xab0000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000")
+	if err != nil {
+		panic(err)
+	}
+	feeRecipient, err := c.ValidatorFeeRecipient(context.Background(), pubkey)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(feeRecipient.FeeRecipient, feeRecipient.NodeAddress)
+	// Output: 0x2222222222222222222222222222222222222222 0x1111111111111111111111111111111111111111
+}
+
+func ExampleClient_NodeInfo() {
+	_, addr, stop := serveFakeAPI()
+	defer stop()
+
+	c, err := client.New(client.Config{Addr: addr})
+	if err != nil {
+		panic(err)
+	}
+	defer c.Close()
+
+	// Nodes the proxy doesn't know are reported with ErrNotFound
+	_, err = c.NodeInfo(context.Background(), common.HexToAddress("0x3333333333333333333333333333333333333333"))
+	fmt.Println(errors.Is(err, client.ErrNotFound))
+	// Output: true
+}
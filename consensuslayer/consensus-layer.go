@@ -2,150 +2,227 @@ package consensuslayer
 
 import (
 	"context"
-	"encoding/hex"
 	"net/url"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
-	"github.com/allegro/bigcache/v3"
 	apiv1 "github.com/attestantio/go-eth2-client/api/v1"
-	"github.com/attestantio/go-eth2-client/http"
 	"github.com/attestantio/go-eth2-client/spec/phase0"
 	rptypes "github.com/rocket-pool/rocketpool-go/types"
-	"github.com/rs/zerolog"
 	"go.uber.org/zap"
 )
 
-const cacheTTL time.Duration = 10 * time.Hour
-const cacheShards int = 32
-const cacheGC time.Duration = 30 * time.Second
-const cacheHardMaxMB int = 512
+// How often to update the effective balance of validators using the proxy
+const recentStakeInterval = 10 * time.Minute
+
+// beaconClient is the subset of the beacon node client used once connected, so it can be faked in tests
+type beaconClient interface {
+	Validators(ctx context.Context, stateID string, validatorIndices []phase0.ValidatorIndex) (map[phase0.ValidatorIndex]*apiv1.Validator, error)
+	ValidatorsByPubKey(ctx context.Context, stateID string, validatorPubKeys []phase0.BLSPubKey) (map[phase0.ValidatorIndex]*apiv1.Validator, error)
+}
+
+// slotsPerEpochProvider is the part of the beacon node client the chain spec comes from
+type slotsPerEpochProvider interface {
+	SlotsPerEpoch(ctx context.Context) (uint64, error)
+}
 
 // ConsensusLayer provides an abstraction for the rescue proxy over the consensus layer
 // It's specifically needed to map validator indices to pubkeys prior to EL validation
 type ConsensusLayer struct {
-	bnURL  *url.URL
 	logger *zap.Logger
 
-	// Client for the BN
-	client *http.Service
+	// Lookups go to the first healthy BN, and fail over to the others
+	endpoints           []*endpoint
+	active              atomic.Int64
+	dial                func(context.Context, *url.URL) (beaconClient, error)
+	HealthCheckInterval time.Duration
 
 	// Caches index->pubkey for prepare_beacon_proposer
-	pubkeyCache *bigcache.BigCache
+	pubkeyCache *pubkeyCache
+
+	// Caches pubkey->status for register_validator, for StatusTTL
+	statusCache *statusCache
+	StatusTTL   time.Duration
+
+	// Head events from the active BN prefetch new validators each epoch.
+	// nextIndex is only used by prefetchLoop.
+	HeadTimeout time.Duration
+	lastEpoch   atomic.Uint64
+	prefetches  chan struct{}
+	nextIndex   phase0.ValidatorIndex
+
+	// Limits how many withdrawal credential lookups are sent to the BNs at once
+	withdrawalLookups chan struct{}
 
-	// Disconnects from the bn
+	// Disconnects from the bn, and waits for the background loops to stop
 	disconnect func()
+	wg         sync.WaitGroup
 
 	m             *metrics.MetricsRegistry
 	slotsPerEpoch uint64
 }
 
-// NewConsensusLayer creates a new consensus layer client using the provided url and logger
-func NewConsensusLayer(bnURL *url.URL, logger *zap.Logger) *ConsensusLayer {
+// NewConsensusLayer creates a new consensus layer client using the provided urls and logger.
+// Lookups are sent to the first healthy, synced beacon node in bnURLs.
+func NewConsensusLayer(bnURLs []*url.URL, logger *zap.Logger) *ConsensusLayer {
 	out := &ConsensusLayer{}
-	out.bnURL = bnURL
 	out.logger = logger
 	out.m = metrics.NewMetricsRegistry("consensus_layer")
-
-	return out
-}
-
-func (c *ConsensusLayer) onHeadUpdate(e *apiv1.Event) {
-	headEvent, ok := e.Data.(*apiv1.HeadEvent)
-	if !ok {
-		c.logger.Warn("Couldn't convert event to headEvent", zap.Any("event", e))
-		return
+	out.dial = dialEndpoint
+	out.HealthCheckInterval = DefaultHealthCheckInterval
+	out.active.Store(-1)
+	for _, bnURL := range bnURLs {
+		out.endpoints = append(out.endpoints, &endpoint{url: bnURL})
 	}
+	out.pubkeyCache = newPubkeyCache()
+	out.statusCache = newStatusCache()
+	out.StatusTTL = DefaultStatusTTL
+	out.HeadTimeout = DefaultHeadTimeout
+	out.prefetches = make(chan struct{}, 1)
+	out.withdrawalLookups = make(chan struct{}, withdrawalCredentialsConcurrency)
 
-	c.logger.Debug("Observed consensus slot", zap.Uint64("slot", uint64(headEvent.Slot)), zap.Bool("new_epoch", headEvent.EpochTransition))
-
-	// The CL doesn't report events very reliably, probably an issue with the attestantio client.
-	// So, every single slot, we will check to see if the epoch advanced.
-	epoch := uint64(headEvent.Slot) / c.slotsPerEpoch
-
-	metrics.OnHead(epoch)
+	return out
 }
 
-// Init connects to the consensus layer and initializes the cache
+// Init connects to the consensus layer and initializes the cache.
+// Beacon nodes which can't be connected to yet are retried by the health checks, but at least one must be reachable.
 func (c *ConsensusLayer) Init() error {
 	var err error
 	var ctx context.Context
 
-	// Connect to BN
+	// Connect to the BNs
 	ctx, c.disconnect = context.WithCancel(context.Background())
-	client, err := http.New(ctx,
-		http.WithAddress(c.bnURL.String()),
-		// It's very chatty if we don't quiet it down
-		http.WithLogLevel(zerolog.WarnLevel))
-	if err != nil {
+	var spec slotsPerEpochProvider
+	for i, e := range c.endpoints {
+		if connectErr := c.connect(ctx, i); connectErr != nil {
+			err = connectErr
+			c.m.CounterVec("endpoint_errors", "endpoint").WithLabelValues(e.url.Host).Inc()
+			c.logger.Warn("Couldn't connect to Beacon Node", zap.String("host", e.url.Host), zap.Error(connectErr))
+			continue
+		}
+
+		// Every beacon node which could be connected to is assumed healthy until it's checked
+		c.setHealthy(i, true)
+		if spec == nil {
+			spec, _ = e.getClient().(slotsPerEpochProvider)
+		}
+	}
+	if len(c.candidates()) == 0 {
+		c.disconnect()
+		if err == nil {
+			err = errNoEndpoints
+		}
 		return err
 	}
-	c.client = client.(*http.Service)
 
-	c.slotsPerEpoch, err = c.client.SlotsPerEpoch(context.Background())
-	if err != nil {
-		c.logger.Warn("Couldn't get slots per epoch, defaulting to 32", zap.Error(err))
-		c.slotsPerEpoch = 32
-	} else {
-		c.logger.Debug("Fetched slots per epoch", zap.Uint64("slots", c.slotsPerEpoch))
+	c.slotsPerEpoch = 32
+	if spec != nil {
+		slotsPerEpoch, err := spec.SlotsPerEpoch(context.Background())
+		if err != nil {
+			c.logger.Warn("Couldn't get slots per epoch, defaulting to 32", zap.Error(err))
+		} else {
+			c.slotsPerEpoch = slotsPerEpoch
+			c.logger.Debug("Fetched slots per epoch", zap.Uint64("slots", c.slotsPerEpoch))
+		}
 	}
 
-	c.logger.Debug("Connected to Beacon Node", zap.String("url", c.bnURL.String()))
+	loops := []func(context.Context){c.recentStakeLoop, c.headLoop, c.prefetchLoop}
+	if len(c.endpoints) > 1 {
+		loops = append(loops, func(ctx context.Context) { c.healthLoop(ctx, c.HealthCheckInterval) })
+	}
+	for _, loop := range loops {
+		c.wg.Add(1)
+		go func(loop func(context.Context)) {
+			defer c.wg.Done()
+			loop(ctx)
+		}(loop)
+	}
 
-	// Listen for head updates
-	err = c.client.Events(context.Background(), []string{"head"}, c.onHeadUpdate)
-	if err != nil {
-		c.logger.Warn("Clouldn't subscribe to CL events. Metrics will be inaccurate", zap.Error(err))
+	return nil
+}
+
+// recentStakeLoop periodically updates the recent stake gauges until ctx is cancelled.
+// The set of recent validators is maintained on the request path, but querying the BN is too slow for it.
+func (c *ConsensusLayer) recentStakeLoop(ctx context.Context) {
+	ticker := time.NewTicker(recentStakeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.updateRecentStake(ctx); err != nil && ctx.Err() == nil {
+				c.logger.Warn("Couldn't get the effective balance of recent validators", zap.Error(err))
+			}
+		}
 	}
+}
 
-	cacheConfig := bigcache.DefaultConfig(cacheTTL)
-	cacheConfig.CleanWindow = cacheGC
-	cacheConfig.Shards = cacheShards
-	cacheConfig.HardMaxCacheSize = cacheHardMaxMB
+// updateRecentStake sums the effective balance of validators which sent guarded requests in the last day
+func (c *ConsensusLayer) updateRecentStake(ctx context.Context) error {
+	pubkeys := metrics.RecentValidators()
+	c.m.Gauge("recent_validators").Set(float64(len(pubkeys)))
 
-	c.pubkeyCache, err = bigcache.New(ctx, cacheConfig)
-	if err != nil {
-		return err
+	blsPubkeys := make([]phase0.BLSPubKey, 0, len(pubkeys))
+	for _, pubkey := range pubkeys {
+		blsPubkeys = append(blsPubkeys, phase0.BLSPubKey(pubkey))
 	}
 
-	c.logger.Debug("Initialized pubkey cache")
+	var total phase0.Gwei
+	if len(blsPubkeys) > 0 {
+		var resp map[phase0.ValidatorIndex]*apiv1.Validator
+		err := c.withClient(ctx, func(client beaconClient) (err error) {
+			resp, err = client.ValidatorsByPubKey(ctx, "head", blsPubkeys)
+			return
+		})
+		if err != nil {
+			return err
+		}
 
+		for _, validator := range resp {
+			total += validator.Validator.EffectiveBalance
+		}
+	}
+
+	c.m.Gauge("recent_validators_effective_balance_eth").Set(float64(total) / 1e9)
 	return nil
 }
 
-const pubkeyBytes = 48
-
-// GetValidatorPubkey maps a validator index to a pubkey.
-// It caches responses from the beacon client in memory for an arbitrary amount of time to save resources.
-func (c *ConsensusLayer) GetValidatorPubkey(validatorIndices []string) (map[string]rptypes.ValidatorPubkey, error) {
+// GetValidatorPubkey maps validator indices to pubkeys.
+// Indices which have been seen before are answered from memory, and the rest are looked up on the beacon node in one request,
+// which is abandoned if ctx is done first. Invalid or unknown indices are left out of the returned map.
+func (c *ConsensusLayer) GetValidatorPubkey(ctx context.Context, validatorIndices []string) (map[string]rptypes.ValidatorPubkey, error) {
 
 	// Pre-allocate the retval based on the argument length
 	out := make(map[string]rptypes.ValidatorPubkey, len(validatorIndices))
 	missing := make([]phase0.ValidatorIndex, 0, len(validatorIndices))
+	missingSet := make(map[phase0.ValidatorIndex]bool)
 
 	for _, validatorIndex := range validatorIndices {
+		index, err := strconv.ParseUint(validatorIndex, 10, 64)
+		if err != nil {
+			c.logger.Warn("Invalid validator index", zap.String("index", validatorIndex))
+			continue
+		}
+
 		// Check the cache first
-		pubkey, err := c.pubkeyCache.Get(validatorIndex)
-		if err == nil {
-			if len(pubkey) != pubkeyBytes {
-				c.logger.Warn("Invalid pubkey from beacon node", zap.String("key", hex.EncodeToString(pubkey)))
-				continue
-			}
-			// Add the pubkey to the output. We have to cast it to an array, but the length is correct (see above)
-			out[validatorIndex] = *(*rptypes.ValidatorPubkey)(pubkey)
+		if pubkey, ok := c.pubkeyCache.get(phase0.ValidatorIndex(index)); ok {
+			out[validatorIndex] = pubkey
 			c.logger.Debug("Cache hit", zap.String("validator", validatorIndex))
 			c.m.Counter("cache_hit").Inc()
-		} else {
-			// An error means the record wasn't in the cache
-			// Add the index to the list to be queried against the BN
-			index, err := strconv.ParseUint(validatorIndex, 10, 64)
-			if err != nil {
-				c.logger.Warn("Invalid validator index", zap.String("index", validatorIndex))
-			}
+			continue
+		}
+
+		// Add the index to the list to be queried against the BN
+		c.m.Counter("cache_miss").Inc()
+		c.logger.Debug("Cache miss", zap.String("validator", validatorIndex))
+		if !missingSet[phase0.ValidatorIndex(index)] {
+			missingSet[phase0.ValidatorIndex(index)] = true
 			missing = append(missing, phase0.ValidatorIndex(index))
-			c.m.Counter("cache_miss").Inc()
-			c.logger.Debug("Cache miss", zap.String("validator", validatorIndex))
 		}
 	}
 
@@ -156,18 +233,20 @@ func (c *ConsensusLayer) GetValidatorPubkey(validatorIndices []string) (map[stri
 	}
 
 	// Grab the index->validator map from the client if missing from the cache
-	resp, err := c.client.Validators(context.Background(), "head", missing)
+	start := time.Now()
+	var resp map[phase0.ValidatorIndex]*apiv1.Validator
+	err := c.withClient(ctx, func(client beaconClient) (err error) {
+		resp, err = client.Validators(ctx, "head", missing)
+		return
+	})
+	c.m.Histogram("validator_lookup_seconds").Observe(time.Since(start).Seconds())
 	if err != nil {
+		c.m.Counter("validator_lookup_error").Inc()
 		return nil, err
 	}
+	c.addValidators(resp)
 	for index, validator := range resp {
-		strIndex := strconv.FormatUint(uint64(index), 10)
-		pubkey := rptypes.ValidatorPubkey(validator.Validator.PublicKey)
-		out[strIndex] = pubkey
-
-		// Add it to the cache. Ignore errors, we can always look the key up later
-		_ = c.pubkeyCache.Set(strIndex, pubkey[:])
-		c.m.Counter("cache_add").Inc()
+		out[strconv.FormatUint(uint64(index), 10)] = rptypes.ValidatorPubkey(validator.Validator.PublicKey)
 	}
 
 	return out, nil
@@ -175,7 +254,7 @@ func (c *ConsensusLayer) GetValidatorPubkey(validatorIndices []string) (map[stri
 
 // Deinit shuts down the consensus layer client
 func (c *ConsensusLayer) Deinit() {
-	c.pubkeyCache.Close()
 	c.disconnect()
+	c.wg.Wait()
 	c.logger.Debug("HTTP Client Disconnected from the BN")
 }
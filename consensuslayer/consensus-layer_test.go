@@ -0,0 +1,248 @@
+package consensuslayer
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	apiv1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+	"go.uber.org/zap"
+)
+
+// fakeBeacon answers validator lookups from a map, and remembers what it was asked for
+type fakeBeacon struct {
+	sync.Mutex
+	validators map[phase0.ValidatorIndex]*apiv1.Validator
+	err        error
+
+	lookups       [][]phase0.ValidatorIndex
+	pubkeyLookups [][]phase0.BLSPubKey
+
+	// If set, pubkey lookups take this long, and the most in flight at once is recorded
+	delay       time.Duration
+	inFlight    int
+	maxInFlight int
+}
+
+func (f *fakeBeacon) Validators(ctx context.Context, stateID string, validatorIndices []phase0.ValidatorIndex) (map[phase0.ValidatorIndex]*apiv1.Validator, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.lookups = append(f.lookups, validatorIndices)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	out := make(map[phase0.ValidatorIndex]*apiv1.Validator)
+	for _, index := range validatorIndices {
+		if validator, ok := f.validators[index]; ok {
+			out[index] = validator
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeBeacon) ValidatorsByPubKey(ctx context.Context, stateID string, validatorPubKeys []phase0.BLSPubKey) (map[phase0.ValidatorIndex]*apiv1.Validator, error) {
+	f.Lock()
+	f.inFlight++
+	if f.inFlight > f.maxInFlight {
+		f.maxInFlight = f.inFlight
+	}
+	f.Unlock()
+	time.Sleep(f.delay)
+
+	f.Lock()
+	defer f.Unlock()
+	f.inFlight--
+
+	f.pubkeyLookups = append(f.pubkeyLookups, validatorPubKeys)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	out := make(map[phase0.ValidatorIndex]*apiv1.Validator)
+	for _, pubkey := range validatorPubKeys {
+		for index, validator := range f.validators {
+			if validator.Validator.PublicKey == pubkey {
+				out[index] = validator
+			}
+		}
+	}
+	return out, nil
+}
+
+func testValidator(index phase0.ValidatorIndex, b byte) *apiv1.Validator {
+	validator := &apiv1.Validator{Index: index, Status: apiv1.ValidatorStateActiveOngoing, Validator: &phase0.Validator{}}
+	validator.Validator.PublicKey[0] = b
+	return validator
+}
+
+func setup(t *testing.T) (*ConsensusLayer, *fakeBeacon) {
+	_, err := metrics.Init("consensuslayer_test_" + t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(metrics.Deinit)
+
+	fake := &fakeBeacon{validators: map[phase0.ValidatorIndex]*apiv1.Validator{
+		1: testValidator(1, 0x01),
+		2: testValidator(2, 0x02),
+	}}
+	c := NewConsensusLayer([]*url.URL{{Scheme: "http", Host: "fake"}}, zap.NewNop())
+	c.endpoints[0].client = fake
+	c.endpoints[0].healthy.Store(true)
+	return c, fake
+}
+
+func TestGetValidatorPubkey(t *testing.T) {
+	c, fake := setup(t)
+
+	// Duplicates are only looked up once, and invalid indices are left out
+	pubkeys, err := c.GetValidatorPubkey(context.Background(), []string{"1", "2", "1", "0x3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pubkeys) != 2 || pubkeys["1"] != rptypes.ValidatorPubkey(fake.validators[1].Validator.PublicKey) ||
+		pubkeys["2"] != rptypes.ValidatorPubkey(fake.validators[2].Validator.PublicKey) {
+		t.Fatalf("unexpected pubkeys %v", pubkeys)
+	}
+	if len(fake.lookups) != 1 {
+		t.Fatalf("expected one lookup, got %v", fake.lookups)
+	}
+	lookup := fake.lookups[0]
+	sort.Slice(lookup, func(i, j int) bool { return lookup[i] < lookup[j] })
+	if !reflect.DeepEqual(lookup, []phase0.ValidatorIndex{1, 2}) {
+		t.Fatalf("unexpected lookup %v", lookup)
+	}
+
+	// Known indices are answered from the cache, and only the unknown one is looked up
+	pubkeys, err = c.GetValidatorPubkey(context.Background(), []string{"2", "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pubkeys) != 1 || pubkeys["2"] != rptypes.ValidatorPubkey(fake.validators[2].Validator.PublicKey) {
+		t.Fatalf("unexpected pubkeys %v", pubkeys)
+	}
+	if len(fake.lookups) != 2 || !reflect.DeepEqual(fake.lookups[1], []phase0.ValidatorIndex{3}) {
+		t.Fatalf("unexpected lookups %v", fake.lookups)
+	}
+
+	// Once it exists, it's found
+	fake.validators[3] = testValidator(3, 0x03)
+	pubkeys, err = c.GetValidatorPubkey(context.Background(), []string{"3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pubkeys) != 1 {
+		t.Fatalf("unexpected pubkeys %v", pubkeys)
+	}
+
+	if _, err := c.GetValidatorPubkey(context.Background(), []string{"1", "2", "3"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.lookups) != 3 {
+		t.Fatalf("expected cached indices not to be looked up, got %v", fake.lookups)
+	}
+	if size := c.pubkeyCache.len(); size != 3 {
+		t.Fatalf("expected 3 cached pubkeys, got %d", size)
+	}
+}
+
+func TestGetValidatorPubkeyError(t *testing.T) {
+	c, fake := setup(t)
+	fake.err = errors.New("beacon node unavailable")
+
+	if _, err := c.GetValidatorPubkey(context.Background(), []string{"1"}); !errors.Is(err, fake.err) {
+		t.Fatalf("expected %v, got %v", fake.err, err)
+	}
+
+	// Failures aren't cached
+	fake.err = nil
+	pubkeys, err := c.GetValidatorPubkey(context.Background(), []string{"1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pubkeys) != 1 {
+		t.Fatalf("unexpected pubkeys %v", pubkeys)
+	}
+}
+
+func TestGetValidatorPubkeyCancelled(t *testing.T) {
+	c, _ := setup(t)
+
+	// A lookup abandoned by the client isn't the beacon node's fault, so it stays healthy
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.GetValidatorPubkey(ctx, []string{"1"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+	if !c.endpoints[0].healthy.Load() {
+		t.Fatal("expected the endpoint to stay healthy")
+	}
+	if cancelled := testutil.ToFloat64(c.m.Counter("lookup_cancelled")); cancelled != 1 {
+		t.Fatalf("expected 1 cancelled lookup, got %v", cancelled)
+	}
+}
+
+func TestGetValidatorStatuses(t *testing.T) {
+	c, fake := setup(t)
+	now := time.Now()
+	c.statusCache.now = func() time.Time { return now }
+	active := rptypes.ValidatorPubkey(fake.validators[1].Validator.PublicKey)
+	slashed := rptypes.ValidatorPubkey(fake.validators[2].Validator.PublicKey)
+	fake.validators[2].Status = apiv1.ValidatorStateActiveSlashed
+	var unknown rptypes.ValidatorPubkey
+	unknown[0] = 0xff
+
+	statuses, err := c.GetValidatorStatuses(context.Background(), []rptypes.ValidatorPubkey{active, slashed, unknown, active})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 || statuses[active] != apiv1.ValidatorStateActiveOngoing || statuses[slashed] != apiv1.ValidatorStateActiveSlashed {
+		t.Fatalf("unexpected statuses %v", statuses)
+	}
+	if len(fake.pubkeyLookups) != 1 || len(fake.pubkeyLookups[0]) != 3 {
+		t.Fatalf("expected one lookup of 3 pubkeys, got %v", fake.pubkeyLookups)
+	}
+
+	// The indices came along with the statuses, so index lookups don't need the beacon node
+	if _, err := c.GetValidatorPubkey(context.Background(), []string{"1", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.lookups) != 0 {
+		t.Fatalf("expected no index lookups, got %v", fake.lookups)
+	}
+
+	// Statuses are remembered until they're StatusTTL old
+	fake.validators[1].Status = apiv1.ValidatorStateExitedUnslashed
+	now = now.Add(c.StatusTTL - time.Second)
+	if statuses, err = c.GetValidatorStatuses(context.Background(), []rptypes.ValidatorPubkey{active}); err != nil {
+		t.Fatal(err)
+	}
+	if statuses[active] != apiv1.ValidatorStateActiveOngoing || len(fake.pubkeyLookups) != 1 {
+		t.Fatalf("expected the cached status, got %v after %d lookups", statuses, len(fake.pubkeyLookups))
+	}
+
+	now = now.Add(time.Second)
+	if statuses, err = c.GetValidatorStatuses(context.Background(), []rptypes.ValidatorPubkey{active}); err != nil {
+		t.Fatal(err)
+	}
+	if statuses[active] != apiv1.ValidatorStateExitedUnslashed || len(fake.pubkeyLookups) != 2 {
+		t.Fatalf("expected the new status, got %v after %d lookups", statuses, len(fake.pubkeyLookups))
+	}
+}
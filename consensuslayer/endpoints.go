@@ -0,0 +1,207 @@
+package consensuslayer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	eth2http "github.com/attestantio/go-eth2-client/http"
+	"github.com/rs/zerolog"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultHealthCheckInterval = 10 * time.Second
+	healthCheckTimeout         = 5 * time.Second
+	syncingPath                = "/eth/v1/node/syncing"
+)
+
+var errNoEndpoints = errors.New("no beacon node is connected")
+
+// endpoint is one of the beacon nodes lookups may be sent to
+type endpoint struct {
+	url     *url.URL
+	healthy atomic.Bool
+
+	// nil until the beacon node has been connected to
+	lock   sync.RWMutex
+	client beaconClient
+}
+
+func (e *endpoint) getClient() beaconClient {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	return e.client
+}
+
+// dialEndpoint creates a beacon node client for the given url. It fails if the beacon node can't be reached.
+func dialEndpoint(ctx context.Context, bnURL *url.URL) (beaconClient, error) {
+	client, err := eth2http.New(ctx,
+		eth2http.WithAddress(bnURL.String()),
+		// It's very chatty if we don't quiet it down
+		eth2http.WithLogLevel(zerolog.WarnLevel))
+	if err != nil {
+		return nil, err
+	}
+
+	return client.(*eth2http.Service), nil
+}
+
+// connect dials the endpoint with the given index, if it hasn't been already
+func (c *ConsensusLayer) connect(ctx context.Context, index int) error {
+	e := c.endpoints[index]
+	if e.getClient() != nil {
+		return nil
+	}
+
+	client, err := c.dial(ctx, e.url)
+	if err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+	e.client = client
+	e.lock.Unlock()
+	c.logger.Debug("Connected to Beacon Node", zap.String("host", e.url.Host))
+	return nil
+}
+
+// candidates returns the indices of connected endpoints in the order lookups should try them:
+// healthy ones in the order given, then unhealthy ones, which may still answer
+func (c *ConsensusLayer) candidates() []int {
+	out := make([]int, 0, len(c.endpoints))
+	for _, healthy := range []bool{true, false} {
+		for i, e := range c.endpoints {
+			if e.healthy.Load() == healthy && e.getClient() != nil {
+				out = append(out, i)
+			}
+		}
+	}
+
+	return out
+}
+
+// withClient runs lookup against the first healthy beacon node. If it fails, the beacon node is marked unhealthy
+// until its next health check, and lookup is retried on the others in turn. Lookups don't depend on which
+// beacon node answered them, so nothing cached needs to change when another one is used.
+// lookup should make its requests with ctx. Once ctx is done, failures are down to whoever cancelled it, rather
+// than the beacon node, so it's neither marked unhealthy nor are the others tried.
+func (c *ConsensusLayer) withClient(ctx context.Context, lookup func(beaconClient) error) error {
+	err := errNoEndpoints
+	for _, index := range c.candidates() {
+		e := c.endpoints[index]
+		c.setActive(index)
+
+		err = lookup(e.getClient())
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			c.m.Counter("lookup_cancelled").Inc()
+			return err
+		}
+
+		c.m.CounterVec("endpoint_errors", "endpoint").WithLabelValues(e.url.Host).Inc()
+		c.logger.Warn("Beacon node lookup failed", zap.String("host", e.url.Host), zap.Error(err))
+		c.setHealthy(index, false)
+	}
+
+	return err
+}
+
+// setActive records which endpoint lookups are being sent to
+func (c *ConsensusLayer) setActive(index int) {
+	if c.active.Swap(int64(index)) == int64(index) {
+		return
+	}
+
+	c.m.Gauge("active_endpoint").Set(float64(index))
+	c.logger.Info("Using beacon node for lookups", zap.Int("index", index), zap.String("host", c.endpoints[index].url.Host))
+}
+
+func (c *ConsensusLayer) setHealthy(index int, healthy bool) {
+	e := c.endpoints[index]
+	if e.healthy.Swap(healthy) == healthy {
+		return
+	}
+
+	if healthy {
+		c.logger.Info("Beacon node is healthy again", zap.String("host", e.url.Host))
+		c.m.Gauge("endpoints_healthy").Inc()
+	} else {
+		c.logger.Warn("Beacon node is unhealthy", zap.String("host", e.url.Host))
+		c.m.Gauge("endpoints_healthy").Dec()
+	}
+}
+
+// syncing asks a beacon node whether it's syncing
+func syncing(ctx context.Context, client *http.Client, bnURL *url.URL) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(bnURL.String(), "/")+syncingPath, nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, errors.New(resp.Status)
+	}
+
+	var body struct {
+		Data struct {
+			IsSyncing bool `json:"is_syncing"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return false, err
+	}
+
+	return body.Data.IsSyncing, nil
+}
+
+// checkHealth connects to any beacon nodes which couldn't be connected to before,
+// and marks those which are reachable and synced healthy
+func (c *ConsensusLayer) checkHealth(ctx context.Context, client *http.Client) {
+	for i, e := range c.endpoints {
+		isSyncing, err := syncing(ctx, client, e.url)
+		if err == nil {
+			err = c.connect(ctx, i)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			c.m.CounterVec("endpoint_errors", "endpoint").WithLabelValues(e.url.Host).Inc()
+			c.logger.Debug("Beacon node health check failed", zap.String("host", e.url.Host), zap.Error(err))
+		}
+
+		c.setHealthy(i, err == nil && !isSyncing)
+	}
+}
+
+// healthLoop checks the health of every endpoint on the given interval until ctx is cancelled
+func (c *ConsensusLayer) healthLoop(ctx context.Context, interval time.Duration) {
+	client := &http.Client{Timeout: healthCheckTimeout}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.checkHealth(ctx, client)
+		}
+	}
+}
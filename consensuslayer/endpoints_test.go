@@ -0,0 +1,266 @@
+package consensuslayer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	apiv1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+	"go.uber.org/zap"
+)
+
+// fakeCL is a beacon node serving just enough of the beacon API to be connected to, look up validator 1,
+// and stream the head events sent to it
+type fakeCL struct {
+	*httptest.Server
+	url *url.URL
+
+	// If set, every request fails with this status
+	status  atomic.Int32
+	syncing atomic.Bool
+	lookups atomic.Int64
+
+	// Each slot sent is streamed as a head event, and sending to drop ends the stream
+	heads         chan uint64
+	drop          chan struct{}
+	subscriptions atomic.Int64
+}
+
+var fakeValidatorPubkey = "0x01" + strings.Repeat("00", 47)
+
+var fakeCLResponses = map[string]string{
+	"/eth/v1/beacon/genesis": `{"data":{"genesis_time":"1606824023",` +
+		`"genesis_validators_root":"0x4b363db94e286120d76eb905340fdd4e54bfe9f06bf33ff6cf5ad27f511bfe95","genesis_fork_version":"0x00000000"}}`,
+	"/eth/v1/config/spec":             `{"data":{"SLOTS_PER_EPOCH":"32"}}`,
+	"/eth/v1/config/deposit_contract": `{"data":{"chain_id":"1","address":"0x00000000219ab540356cbb839cbe05303d7705fa"}}`,
+	"/eth/v1/config/fork_schedule":    `{"data":[{"previous_version":"0x00000000","current_version":"0x00000000","epoch":"0"}]}`,
+	"/eth/v1/node/version":            `{"data":{"version":"fake/v1.0.0"}}`,
+	"/eth/v1/beacon/states/head/validators": `{"data":[{"index":"1","balance":"32000000000","status":"active_ongoing",` +
+		`"validator":{"pubkey":"` + fakeValidatorPubkey + `","withdrawal_credentials":"0x` + strings.Repeat("00", 32) + `",` +
+		`"effective_balance":"32000000000","slashed":false,"activation_eligibility_epoch":"0","activation_epoch":"0",` +
+		`"exit_epoch":"18446744073709551615","withdrawable_epoch":"18446744073709551615"}}]}`,
+}
+
+func newFakeCL(t *testing.T) *fakeCL {
+	f := &fakeCL{heads: make(chan uint64), drop: make(chan struct{})}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status := f.status.Load(); status != 0 {
+			w.WriteHeader(int(status))
+			return
+		}
+
+		switch r.URL.Path {
+		case syncingPath:
+			fmt.Fprintf(w, `{"data":{"head_slot":"100","sync_distance":"0","is_syncing":%t}}`, f.syncing.Load())
+			return
+		case "/eth/v1/events":
+			f.streamHeads(w, r)
+			return
+		}
+
+		response, ok := fakeCLResponses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/eth/v1/beacon/states/") {
+			f.lookups.Add(1)
+
+			// Only validator 1 exists
+			ids := strings.Split(r.URL.Query().Get("id"), ",")
+			found := false
+			for _, id := range ids {
+				found = found || id == "1" || id == fakeValidatorPubkey
+			}
+			if !found {
+				response = `{"data":[]}`
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, response)
+	}))
+	t.Cleanup(f.Close)
+
+	var err error
+	f.url, err = url.Parse(f.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func (f *fakeCL) streamHeads(w http.ResponseWriter, r *http.Request) {
+	f.subscriptions.Add(1)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-f.drop:
+			return
+		case slot := <-f.heads:
+			fmt.Fprintf(w, "event: head\ndata: {\"slot\":\"%d\",\"epoch_transition\":false}\n\n", slot)
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+// newFakeConsensusLayer creates a ConsensusLayer using the given fakes, which the caller initializes
+func newFakeConsensusLayer(t *testing.T, fakes ...*fakeCL) *ConsensusLayer {
+	_, err := metrics.Init("consensuslayer_test_" + t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(metrics.Deinit)
+	metrics.InitEpochMetrics()
+
+	urls := make([]*url.URL, 0, len(fakes))
+	for _, fake := range fakes {
+		urls = append(urls, fake.url)
+	}
+	c := NewConsensusLayer(urls, zap.NewNop())
+	// The health checks are run by the tests
+	c.HealthCheckInterval = time.Hour
+	return c
+}
+
+func initFakeCLs(t *testing.T, fakes ...*fakeCL) *ConsensusLayer {
+	c := newFakeConsensusLayer(t, fakes...)
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Deinit)
+	return c
+}
+
+// lookupFakeValidator looks up the status of validator 1, which isn't cached, so the beacon node is always asked
+func lookupFakeValidator(t *testing.T, c *ConsensusLayer) {
+	t.Helper()
+
+	c.StatusTTL = 0
+	pubkey := rptypes.BytesToValidatorPubkey(common.FromHex(fakeValidatorPubkey))
+	statuses, err := c.GetValidatorStatuses(context.Background(), []rptypes.ValidatorPubkey{pubkey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses[pubkey] != apiv1.ValidatorStateActiveOngoing {
+		t.Fatalf("unexpected statuses %v", statuses)
+	}
+}
+
+func TestEndpointFailover(t *testing.T) {
+	primary, fallback := newFakeCL(t), newFakeCL(t)
+	c := initFakeCLs(t, primary, fallback)
+
+	pubkeys, err := c.GetValidatorPubkey(context.Background(), []string{"1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pubkey, ok := pubkeys["1"]; !ok || "0x"+pubkey.Hex() != fakeValidatorPubkey {
+		t.Fatalf("unexpected pubkeys %v", pubkeys)
+	}
+	lookupFakeValidator(t, c)
+	if primary.lookups.Load() != 2 || fallback.lookups.Load() != 0 {
+		t.Fatalf("expected the primary to be used, got %d and %d lookups", primary.lookups.Load(), fallback.lookups.Load())
+	}
+	if got := testutil.ToFloat64(c.m.Gauge("active_endpoint")); got != 0 {
+		t.Fatalf("expected the active endpoint to be 0, got %v", got)
+	}
+
+	// The primary starts failing, and lookups move to the fallback straight away
+	primary.status.Store(http.StatusServiceUnavailable)
+	lookupFakeValidator(t, c)
+	if fallback.lookups.Load() != 1 {
+		t.Fatalf("expected the fallback to answer the lookup, got %d", fallback.lookups.Load())
+	}
+	if got := testutil.ToFloat64(c.m.Gauge("active_endpoint")); got != 1 {
+		t.Fatalf("expected the active endpoint to be 1, got %v", got)
+	}
+	if got := testutil.ToFloat64(c.m.CounterVec("endpoint_errors", "endpoint").WithLabelValues(primary.url.Host)); got != 1 {
+		t.Fatalf("expected 1 primary error, got %v", got)
+	}
+
+	// The fallback keeps being used while the primary fails its health checks
+	client := &http.Client{Timeout: healthCheckTimeout}
+	c.checkHealth(context.Background(), client)
+	lookupFakeValidator(t, c)
+	if primary.lookups.Load() != 2 || fallback.lookups.Load() != 2 {
+		t.Fatalf("expected the fallback to be used, got %d and %d lookups", primary.lookups.Load(), fallback.lookups.Load())
+	}
+
+	// Once the primary is healthy again, it's preferred
+	primary.status.Store(0)
+	c.checkHealth(context.Background(), client)
+	lookupFakeValidator(t, c)
+	if primary.lookups.Load() != 3 || fallback.lookups.Load() != 2 {
+		t.Fatalf("expected the primary to be used again, got %d and %d lookups", primary.lookups.Load(), fallback.lookups.Load())
+	}
+	if got := testutil.ToFloat64(c.m.Gauge("active_endpoint")); got != 0 {
+		t.Fatalf("expected the active endpoint to be 0, got %v", got)
+	}
+}
+
+func TestEndpointSyncing(t *testing.T) {
+	primary, fallback := newFakeCL(t), newFakeCL(t)
+	c := initFakeCLs(t, primary, fallback)
+
+	// A syncing beacon node answers, but with stale data, so it isn't used
+	primary.syncing.Store(true)
+	c.checkHealth(context.Background(), &http.Client{Timeout: healthCheckTimeout})
+	lookupFakeValidator(t, c)
+	if primary.lookups.Load() != 0 || fallback.lookups.Load() != 1 {
+		t.Fatalf("expected the fallback to be used, got %d and %d lookups", primary.lookups.Load(), fallback.lookups.Load())
+	}
+	if got := testutil.ToFloat64(c.m.Gauge("endpoints_healthy")); got != 1 {
+		t.Fatalf("expected 1 healthy endpoint, got %v", got)
+	}
+}
+
+func TestEndpointUnreachableAtStartup(t *testing.T) {
+	primary, fallback := newFakeCL(t), newFakeCL(t)
+
+	// The primary can't be connected to, but the proxy still starts
+	primary.status.Store(http.StatusServiceUnavailable)
+	c := initFakeCLs(t, primary, fallback)
+	lookupFakeValidator(t, c)
+	if fallback.lookups.Load() != 1 {
+		t.Fatalf("expected the fallback to be used, got %d lookups", fallback.lookups.Load())
+	}
+
+	// It's connected to once it comes up
+	primary.status.Store(0)
+	c.checkHealth(context.Background(), &http.Client{Timeout: healthCheckTimeout})
+	lookupFakeValidator(t, c)
+	if primary.lookups.Load() != 1 {
+		t.Fatalf("expected the primary to be used, got %d lookups", primary.lookups.Load())
+	}
+}
+
+func TestNoEndpointReachable(t *testing.T) {
+	_, err := metrics.Init("consensuslayer_test_" + t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer metrics.Deinit()
+
+	down := newFakeCL(t)
+	down.status.Store(http.StatusServiceUnavailable)
+	c := NewConsensusLayer([]*url.URL{down.url}, zap.NewNop())
+	if err := c.Init(); err == nil {
+		c.Deinit()
+		t.Fatal("expected Init to fail")
+	}
+}
@@ -0,0 +1,292 @@
+package consensuslayer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/internal/backoff"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	apiv1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"go.uber.org/zap"
+)
+
+const (
+	eventsPath = "/eth/v1/events?topics=head"
+
+	// How long to go without a head event before resubscribing, unless the ConsensusLayer's HeadTimeout says otherwise.
+	// 0 disables the check.
+	DefaultHeadTimeout = time.Minute
+
+	// How many indices above the highest known to ask the beacon node for at a time when prefetching
+	prefetchChunkSize = 256
+)
+
+// The waits between failed reconnect attempts, from a second up to a minute
+var reconnectBackoff = backoff.Backoff{Initial: time.Second, Max: time.Minute}
+
+type headEvent struct {
+	Slot string `json:"slot"`
+}
+
+// streamHeads reads head events from the given beacon node until the stream ends, ctx is cancelled,
+// or a nonzero HeadTimeout passes without one. It returns whether any head events were received.
+func (c *ConsensusLayer) streamHeads(ctx context.Context, client *http.Client, index int) (bool, error) {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	e := c.endpoints[index]
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodGet, strings.TrimSuffix(e.url.String(), "/")+eventsPath, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("event stream returned %s", resp.Status)
+	}
+	c.logger.Debug("Subscribed to head events", zap.String("host", e.url.Host))
+
+	// If the stream goes quiet, it's assumed to have silently died, and is torn down so it can be reconnected
+	heads := make(chan struct{}, 1)
+	timedOut := make(chan struct{})
+	if c.HeadTimeout > 0 {
+		go c.watchHeads(streamCtx, cancel, heads, timedOut)
+	}
+
+	received := false
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		// Only head events are subscribed to, so the event: lines can be ignored
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimPrefix(line, "data:")
+
+		var event headEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+			c.logger.Warn("Couldn't parse head event", zap.String("data", data), zap.Error(err))
+			continue
+		}
+		slot, err := strconv.ParseUint(event.Slot, 10, 64)
+		if err != nil {
+			c.logger.Warn("Invalid slot in head event", zap.String("slot", event.Slot))
+			continue
+		}
+
+		received = true
+		select {
+		case heads <- struct{}{}:
+		default:
+		}
+		c.m.Gauge("seconds_since_last_head").Set(0)
+		c.onHead(slot)
+	}
+
+	select {
+	case <-timedOut:
+		return received, fmt.Errorf("no head events received for %v", c.HeadTimeout)
+	default:
+	}
+	if err := scanner.Err(); err != nil {
+		return received, err
+	}
+	return received, errors.New("event stream closed")
+}
+
+// watchHeads tracks the time since the last head event until ctx is cancelled.
+// If it reaches HeadTimeout, timedOut is closed and the stream is cancelled.
+func (c *ConsensusLayer) watchHeads(ctx context.Context, cancel context.CancelFunc, heads <-chan struct{}, timedOut chan<- struct{}) {
+	lastHead := time.Now()
+	ticker := time.NewTicker(c.HeadTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heads:
+			lastHead = time.Now()
+		case <-ticker.C:
+			since := time.Since(lastHead)
+			c.m.Gauge("seconds_since_last_head").Set(since.Seconds())
+			if since >= c.HeadTimeout {
+				c.m.Counter("head_timeout").Inc()
+				close(timedOut)
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// headLoop keeps a head event stream open to the active beacon node, reconnecting with backoff when it drops,
+// until ctx is cancelled. While it's disconnected, validators are looked up as they're needed instead.
+func (c *ConsensusLayer) headLoop(ctx context.Context) {
+	// The stream is long lived, so only connecting to it times out
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ResponseHeaderTimeout: healthCheckTimeout,
+	}}
+	defer client.CloseIdleConnections()
+
+	for attempt := 0; ; attempt++ {
+		var received bool
+		err := errNoEndpoints
+		if candidates := c.candidates(); len(candidates) > 0 {
+			received, err = c.streamHeads(ctx, client, candidates[0])
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		// A stream which delivered events was working, so reconnecting starts over
+		if received {
+			attempt = 0
+		}
+
+		c.m.Counter("head_stream_disconnected").Inc()
+		wait := reconnectBackoff.Wait(attempt)
+		c.logger.Warn("Head event stream disconnected", zap.Duration("wait", wait), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		c.m.Counter("reconnection_attempt").Inc()
+	}
+}
+
+// onHead tracks the epoch, and prefetches the validators which joined the beacon chain at each new one
+func (c *ConsensusLayer) onHead(slot uint64) {
+	epoch := slot / c.slotsPerEpoch
+	c.logger.Debug("Observed consensus slot", zap.Uint64("slot", slot))
+	metrics.OnHead(epoch)
+
+	if c.lastEpoch.Swap(epoch) == epoch {
+		return
+	}
+
+	// Only one prefetch runs at a time. If one's already pending, it'll include these validators too.
+	select {
+	case c.prefetches <- struct{}{}:
+	default:
+	}
+}
+
+// prefetchLoop runs the prefetches onHead asks for until ctx is cancelled
+func (c *ConsensusLayer) prefetchLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.prefetches:
+			if err := c.prefetchNewValidators(ctx); err != nil && ctx.Err() == nil {
+				c.m.Counter("prefetch_error").Inc()
+				c.logger.Warn("Couldn't prefetch new validators", zap.Error(err))
+			}
+		}
+	}
+}
+
+// validatorExists checks whether a validator has been assigned the given index
+func (c *ConsensusLayer) validatorExists(ctx context.Context, index phase0.ValidatorIndex) (bool, error) {
+	var resp map[phase0.ValidatorIndex]*apiv1.Validator
+	err := c.withClient(ctx, func(client beaconClient) (err error) {
+		resp, err = client.Validators(ctx, "head", []phase0.ValidatorIndex{index})
+		return
+	})
+	return len(resp) > 0, err
+}
+
+// findNextIndex finds the lowest index which hasn't been assigned to a validator yet
+func (c *ConsensusLayer) findNextIndex(ctx context.Context) (phase0.ValidatorIndex, error) {
+	exists, err := c.validatorExists(ctx, 0)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	// Double the upper bound until it's past the last validator, then narrow it down
+	low, high := phase0.ValidatorIndex(0), phase0.ValidatorIndex(1)
+	for {
+		exists, err := c.validatorExists(ctx, high)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			break
+		}
+		low, high = high, high*2
+	}
+
+	// low has been assigned, and high hasn't
+	for high-low > 1 {
+		mid := low + (high-low)/2
+		exists, err := c.validatorExists(ctx, mid)
+		if err != nil {
+			return 0, err
+		}
+		if exists {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+
+	return high, nil
+}
+
+// prefetchNewValidators caches the validators which were assigned indices since it last ran, so their first
+// requests don't wait on the beacon node. Indices are assigned in order, so they're the ones from nextIndex up.
+// The first time, the highest index has to be found, and older validators are left to be looked up as they're needed.
+func (c *ConsensusLayer) prefetchNewValidators(ctx context.Context) error {
+	if c.nextIndex == 0 {
+		next, err := c.findNextIndex(ctx)
+		if err != nil {
+			return err
+		}
+		c.nextIndex = next
+		c.logger.Debug("Found the next validator index", zap.Uint64("index", uint64(next)))
+		return nil
+	}
+
+	for {
+		indices := make([]phase0.ValidatorIndex, prefetchChunkSize)
+		for i := range indices {
+			indices[i] = c.nextIndex + phase0.ValidatorIndex(i)
+		}
+
+		var resp map[phase0.ValidatorIndex]*apiv1.Validator
+		err := c.withClient(ctx, func(client beaconClient) (err error) {
+			resp, err = client.Validators(ctx, "head", indices)
+			return
+		})
+		if err != nil {
+			return err
+		}
+
+		c.addValidators(resp)
+		c.m.Counter("prefetched_validators").Add(float64(len(resp)))
+		for index := range resp {
+			if index >= c.nextIndex {
+				c.nextIndex = index + 1
+			}
+		}
+		if len(resp) < prefetchChunkSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package consensuslayer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// waitFor polls cond until it's true, failing the test if it takes too long
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPrefetchNewValidators(t *testing.T) {
+	c, fake := setup(t)
+	for i := phase0.ValidatorIndex(0); i < 10; i++ {
+		fake.validators[i] = testValidator(i, byte(i))
+	}
+
+	// The first prefetch only finds where the validators end, and doesn't cache the existing ones
+	if err := c.prefetchNewValidators(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if c.nextIndex != 10 {
+		t.Fatalf("expected the next index to be 10, got %d", c.nextIndex)
+	}
+	if size := c.pubkeyCache.len(); size != 0 {
+		t.Fatalf("expected nothing to be cached, got %d", size)
+	}
+
+	// More than a chunk of validators join, and they're all cached
+	for i := phase0.ValidatorIndex(10); i < 10+prefetchChunkSize+5; i++ {
+		fake.validators[i] = testValidator(i, byte(i))
+	}
+	fake.lookups = nil
+	if err := c.prefetchNewValidators(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if c.nextIndex != 10+prefetchChunkSize+5 {
+		t.Fatalf("expected the next index to be %d, got %d", 10+prefetchChunkSize+5, c.nextIndex)
+	}
+	if len(fake.lookups) != 2 {
+		t.Fatalf("expected 2 chunks to be looked up, got %d", len(fake.lookups))
+	}
+	if size := c.pubkeyCache.len(); size != prefetchChunkSize+5 {
+		t.Fatalf("expected %d cached pubkeys, got %d", prefetchChunkSize+5, size)
+	}
+
+	// So their first requests don't wait on the beacon node
+	if _, err := c.GetValidatorPubkey(context.Background(), []string{"10", "100"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.lookups) != 2 {
+		t.Fatalf("expected prefetched validators not to be looked up, got %d lookups", len(fake.lookups))
+	}
+}
+
+func TestHeadEvents(t *testing.T) {
+	oldBackoff := reconnectBackoff
+	reconnectBackoff.Initial = time.Millisecond
+	t.Cleanup(func() { reconnectBackoff = oldBackoff })
+
+	fake := newFakeCL(t)
+	c := initFakeCLs(t, fake)
+
+	fake.heads <- 64
+	waitFor(t, "epoch 2", func() bool { return c.lastEpoch.Load() == 2 })
+	if got := testutil.ToFloat64(c.m.Gauge("seconds_since_last_head")); got != 0 {
+		t.Fatalf("expected 0 seconds since the last head, got %v", got)
+	}
+
+	// The stream drops, and is resubscribed to
+	fake.drop <- struct{}{}
+	waitFor(t, "a resubscription", func() bool { return fake.subscriptions.Load() == 2 })
+	fake.heads <- 96
+	waitFor(t, "epoch 3", func() bool { return c.lastEpoch.Load() == 3 })
+	if got := testutil.ToFloat64(c.m.Counter("head_stream_disconnected")); got != 1 {
+		t.Fatalf("expected 1 disconnection, got %v", got)
+	}
+}
+
+func TestHeadTimeout(t *testing.T) {
+	oldBackoff := reconnectBackoff
+	reconnectBackoff.Initial = time.Millisecond
+	t.Cleanup(func() { reconnectBackoff = oldBackoff })
+
+	// The stream stays open, but goes quiet, so it's torn down and resubscribed to
+	fake := newFakeCL(t)
+	c := newFakeConsensusLayer(t, fake)
+	c.HeadTimeout = 100 * time.Millisecond
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Deinit)
+
+	waitFor(t, "a resubscription", func() bool { return fake.subscriptions.Load() >= 2 })
+	if got := testutil.ToFloat64(c.m.Counter("head_timeout")); got < 1 {
+		t.Fatalf("expected a head timeout, got %v", got)
+	}
+}
@@ -0,0 +1,44 @@
+package consensuslayer
+
+import (
+	"sync"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+// pubkeyCache maps validator indices to pubkeys.
+// A validator's index never changes once it's assigned, so entries are kept forever.
+// That's around 2 million entries, or 100MB, on mainnet.
+type pubkeyCache struct {
+	sync.RWMutex
+	pubkeys map[phase0.ValidatorIndex]rptypes.ValidatorPubkey
+}
+
+func newPubkeyCache() *pubkeyCache {
+	return &pubkeyCache{
+		pubkeys: make(map[phase0.ValidatorIndex]rptypes.ValidatorPubkey),
+	}
+}
+
+func (p *pubkeyCache) get(index phase0.ValidatorIndex) (rptypes.ValidatorPubkey, bool) {
+	p.RLock()
+	defer p.RUnlock()
+
+	pubkey, ok := p.pubkeys[index]
+	return pubkey, ok
+}
+
+func (p *pubkeyCache) add(index phase0.ValidatorIndex, pubkey rptypes.ValidatorPubkey) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.pubkeys[index] = pubkey
+}
+
+func (p *pubkeyCache) len() int {
+	p.RLock()
+	defer p.RUnlock()
+
+	return len(p.pubkeys)
+}
@@ -0,0 +1,121 @@
+package consensuslayer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	apiv1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+	"go.uber.org/zap"
+)
+
+// How long a validator's status is remembered for, unless the ConsensusLayer's StatusTTL says otherwise
+const DefaultStatusTTL = 10 * time.Minute
+
+type statusEntry struct {
+	status  apiv1.ValidatorState
+	fetched time.Time
+}
+
+// statusCache maps pubkeys to validator statuses.
+// Unlike pubkeys, statuses change as validators activate, exit and are slashed, so entries expire.
+type statusCache struct {
+	sync.RWMutex
+	statuses map[rptypes.ValidatorPubkey]statusEntry
+	now      func() time.Time
+}
+
+func newStatusCache() *statusCache {
+	return &statusCache{
+		statuses: make(map[rptypes.ValidatorPubkey]statusEntry),
+		now:      time.Now,
+	}
+}
+
+func (s *statusCache) get(pubkey rptypes.ValidatorPubkey, ttl time.Duration) (apiv1.ValidatorState, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	entry, ok := s.statuses[pubkey]
+	if !ok || s.now().Sub(entry.fetched) >= ttl {
+		return apiv1.ValidatorStateUnknown, false
+	}
+	return entry.status, true
+}
+
+func (s *statusCache) add(pubkey rptypes.ValidatorPubkey, status apiv1.ValidatorState, ttl time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.now()
+	s.statuses[pubkey] = statusEntry{status: status, fetched: now}
+
+	// Drop expired entries now and then, so validators which stop using the proxy are forgotten
+	if len(s.statuses)%1024 == 0 {
+		for pubkey, entry := range s.statuses {
+			if now.Sub(entry.fetched) >= ttl {
+				delete(s.statuses, pubkey)
+			}
+		}
+	}
+}
+
+// addValidators remembers the pubkeys and statuses of validators returned by the beacon node
+func (c *ConsensusLayer) addValidators(validators map[phase0.ValidatorIndex]*apiv1.Validator) {
+	for index, validator := range validators {
+		pubkey := rptypes.ValidatorPubkey(validator.Validator.PublicKey)
+		c.pubkeyCache.add(index, pubkey)
+		c.statusCache.add(pubkey, validator.Status, c.StatusTTL)
+		c.m.Counter("cache_add").Inc()
+	}
+	c.m.Gauge("cache_size").Set(float64(c.pubkeyCache.len()))
+}
+
+// GetValidatorStatuses returns the statuses of validators at head.
+// Statuses are remembered for StatusTTL, and the rest are looked up on the beacon node in one request, which is
+// abandoned if ctx is done first. Validators the beacon node doesn't know of yet are left out of the returned map.
+func (c *ConsensusLayer) GetValidatorStatuses(ctx context.Context, pubkeys []rptypes.ValidatorPubkey) (map[rptypes.ValidatorPubkey]apiv1.ValidatorState, error) {
+	out := make(map[rptypes.ValidatorPubkey]apiv1.ValidatorState, len(pubkeys))
+	missing := make([]phase0.BLSPubKey, 0, len(pubkeys))
+	missingSet := make(map[rptypes.ValidatorPubkey]bool)
+
+	for _, pubkey := range pubkeys {
+		if status, ok := c.statusCache.get(pubkey, c.StatusTTL); ok {
+			out[pubkey] = status
+			c.m.Counter("status_cache_hit").Inc()
+			continue
+		}
+
+		c.m.Counter("status_cache_miss").Inc()
+		if !missingSet[pubkey] {
+			missingSet[pubkey] = true
+			missing = append(missing, phase0.BLSPubKey(pubkey))
+		}
+	}
+
+	if len(missing) == 0 {
+		return out, nil
+	}
+
+	start := time.Now()
+	var resp map[phase0.ValidatorIndex]*apiv1.Validator
+	err := c.withClient(ctx, func(client beaconClient) (err error) {
+		resp, err = client.ValidatorsByPubKey(ctx, "head", missing)
+		return
+	})
+	c.m.Histogram("validator_lookup_seconds").Observe(time.Since(start).Seconds())
+	if err != nil {
+		c.m.Counter("validator_lookup_error").Inc()
+		return nil, err
+	}
+
+	c.addValidators(resp)
+	for _, validator := range resp {
+		out[rptypes.ValidatorPubkey(validator.Validator.PublicKey)] = validator.Status
+	}
+	c.logger.Debug("Fetched validator statuses", zap.Int("requested", len(missing)), zap.Int("found", len(resp)))
+
+	return out, nil
+}
@@ -0,0 +1,89 @@
+package consensuslayer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	apiv1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+const (
+	// How many pubkeys to look up withdrawal credentials for in each request to the beacon node
+	withdrawalCredentialsChunkSize = 64
+
+	// How many of those requests may be in flight at once, across all callers
+	withdrawalCredentialsConcurrency = 4
+)
+
+// GetValidatorsWithdrawalCredentials returns the withdrawal credentials of the validators with the given pubkeys at head.
+// They're looked up withdrawalCredentialsChunkSize at a time, and a failed chunk or unknown validator only fails
+// its own pubkeys, so every pubkey is in exactly one of the returned maps.
+// They aren't cached here, as they change when a validator submits a BLS to execution change.
+// Chunks still waiting for a request slot, or in flight, when ctx is done fail with its error.
+func (c *ConsensusLayer) GetValidatorsWithdrawalCredentials(ctx context.Context, pubkeys []rptypes.ValidatorPubkey) (map[rptypes.ValidatorPubkey][]byte, map[rptypes.ValidatorPubkey]error) {
+	credentials := make(map[rptypes.ValidatorPubkey][]byte, len(pubkeys))
+	errs := make(map[rptypes.ValidatorPubkey]error)
+
+	unique := make([]phase0.BLSPubKey, 0, len(pubkeys))
+	seen := make(map[rptypes.ValidatorPubkey]bool, len(pubkeys))
+	for _, pubkey := range pubkeys {
+		if !seen[pubkey] {
+			seen[pubkey] = true
+			unique = append(unique, phase0.BLSPubKey(pubkey))
+		}
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for start := 0; start < len(unique); start += withdrawalCredentialsChunkSize {
+		end := start + withdrawalCredentialsChunkSize
+		if end > len(unique) {
+			end = len(unique)
+		}
+		chunk := unique[start:end]
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var resp map[phase0.ValidatorIndex]*apiv1.Validator
+			var err error
+			select {
+			case c.withdrawalLookups <- struct{}{}:
+				err = c.withClient(ctx, func(client beaconClient) (err error) {
+					resp, err = client.ValidatorsByPubKey(ctx, "head", chunk)
+					return
+				})
+				<-c.withdrawalLookups
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				c.m.Counter("withdrawal_credentials_error").Inc()
+				for _, pubkey := range chunk {
+					errs[rptypes.ValidatorPubkey(pubkey)] = err
+				}
+				return
+			}
+
+			for _, validator := range resp {
+				c.m.Counter("withdrawal_credentials_fetched").Inc()
+				credentials[rptypes.ValidatorPubkey(validator.Validator.PublicKey)] = validator.Validator.WithdrawalCredentials
+			}
+			for _, pubkey := range chunk {
+				if _, ok := credentials[rptypes.ValidatorPubkey(pubkey)]; !ok {
+					errs[rptypes.ValidatorPubkey(pubkey)] = fmt.Errorf("validator %s not found", rptypes.ValidatorPubkey(pubkey).String())
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return credentials, errs
+}
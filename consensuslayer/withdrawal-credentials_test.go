@@ -0,0 +1,82 @@
+package consensuslayer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+func TestGetValidatorsWithdrawalCredentials(t *testing.T) {
+	c, fake := setup(t)
+	fake.delay = 10 * time.Millisecond
+
+	pubkeys := make([]rptypes.ValidatorPubkey, 0)
+	for i := phase0.ValidatorIndex(1); i <= 5*withdrawalCredentialsChunkSize; i++ {
+		validator := testValidator(i, byte(i))
+		validator.Validator.PublicKey[1] = byte(i >> 8)
+		validator.Validator.WithdrawalCredentials = []byte{0x01, byte(i)}
+		fake.validators[i] = validator
+		pubkeys = append(pubkeys, rptypes.ValidatorPubkey(validator.Validator.PublicKey))
+	}
+	var unknown rptypes.ValidatorPubkey
+	unknown[2] = 0xff
+	pubkeys = append(pubkeys, unknown, pubkeys[0])
+
+	// The unknown validator fails on its own, and the rest are looked up a chunk at a time
+	credentials, errs := c.GetValidatorsWithdrawalCredentials(context.Background(), pubkeys)
+	if len(credentials) != 5*withdrawalCredentialsChunkSize {
+		t.Fatalf("expected %d withdrawal credentials, got %d", 5*withdrawalCredentialsChunkSize, len(credentials))
+	}
+	if got := credentials[pubkeys[1]]; len(got) != 2 || got[1] != 2 {
+		t.Fatalf("unexpected withdrawal credentials %x", got)
+	}
+	if _, ok := errs[unknown]; !ok || len(errs) != 1 {
+		t.Fatalf("expected only the unknown validator to fail, got %v", errs)
+	}
+	if len(fake.pubkeyLookups) != 6 {
+		t.Fatalf("expected 6 chunks, got %d", len(fake.pubkeyLookups))
+	}
+	for _, lookup := range fake.pubkeyLookups {
+		if len(lookup) > withdrawalCredentialsChunkSize {
+			t.Fatalf("expected chunks of at most %d, got %d", withdrawalCredentialsChunkSize, len(lookup))
+		}
+	}
+	if fake.maxInFlight > withdrawalCredentialsConcurrency {
+		t.Fatalf("expected at most %d lookups at once, got %d", withdrawalCredentialsConcurrency, fake.maxInFlight)
+	}
+
+	// A failed lookup fails every pubkey in it
+	fake.err = errors.New("beacon node unavailable")
+	credentials, errs = c.GetValidatorsWithdrawalCredentials(context.Background(), pubkeys[:2])
+	if len(credentials) != 0 || !errors.Is(errs[pubkeys[0]], fake.err) || !errors.Is(errs[pubkeys[1]], fake.err) {
+		t.Fatalf("expected both pubkeys to fail, got %v and %v", credentials, errs)
+	}
+}
+
+func TestGetValidatorsWithdrawalCredentialsCancelled(t *testing.T) {
+	c, _ := setup(t)
+
+	pubkeys := []rptypes.ValidatorPubkey{rptypes.ValidatorPubkey(testValidator(1, 0x01).Validator.PublicKey)}
+	for i := 0; i < 2*withdrawalCredentialsChunkSize; i++ {
+		var pubkey rptypes.ValidatorPubkey
+		pubkey[0], pubkey[1] = 0xee, byte(i)
+		pubkeys = append(pubkeys, pubkey)
+	}
+
+	// Lookups for a request which has gone away are abandoned, rather than left to finish
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	credentials, errs := c.GetValidatorsWithdrawalCredentials(ctx, pubkeys)
+	if len(credentials) != 0 || len(errs) != len(pubkeys) {
+		t.Fatalf("expected every pubkey to fail, got %d credentials and %d errors", len(credentials), len(errs))
+	}
+	for pubkey, err := range errs {
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected %s to fail with the context's error, got %v", pubkey.String(), err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package executionlayer
+
+import (
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+)
+
+// DefaultFeeRecipientAllowlist is accepted as any minipool's fee recipient unless FeeRecipientAllowlist says otherwise.
+// rETH is where the protocol sends penalized minipools' rewards.
+var DefaultFeeRecipientAllowlist = []string{"rocketTokenRETH"}
+
+// AllowedFeeRecipient is a fee recipient any minipool may use, besides the one expected of it
+type AllowedFeeRecipient struct {
+	// The rocketStorage contract name the address was resolved from, or the address itself if it was configured as one
+	Name    string
+	Address common.Address
+}
+
+// resolveAllowlist looks up each of FeeRecipientAllowlist's contract names in rocketStorage.
+// Entries which are already addresses are used as they are.
+func (e *ExecutionLayer) resolveAllowlist(opts *bind.CallOpts) ([]AllowedFeeRecipient, error) {
+	out := make([]AllowedFeeRecipient, 0, len(e.FeeRecipientAllowlist))
+	for _, entry := range e.FeeRecipientAllowlist {
+		if common.IsHexAddress(entry) {
+			addr := common.HexToAddress(entry)
+			out = append(out, AllowedFeeRecipient{Name: addr.String(), Address: addr})
+			continue
+		}
+
+		contract, err := e.chain.contract(entry, opts)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, AllowedFeeRecipient{Name: entry, Address: *contract.Address})
+	}
+
+	return out, nil
+}
+
+// checkAllowlistUpgrades re-resolves the allowlist, so it follows upgrades of the contracts in it.
+// If it can't be resolved, the previous allowlist is kept.
+func (e *ExecutionLayer) checkAllowlistUpgrades(opts *bind.CallOpts) {
+	allowlist, err := e.resolveAllowlist(opts)
+	if err != nil {
+		e.logger.Warn("Couldn't check the fee recipient allowlist for upgrades", zap.Error(err))
+		return
+	}
+
+	// Both are resolved from FeeRecipientAllowlist, so entries line up
+	previous := e.AllowedFeeRecipients()
+	for i := 0; i < len(previous) && i < len(allowlist); i++ {
+		if previous[i] == allowlist[i] {
+			continue
+		}
+
+		e.m.Counter("allowlist_upgrade_detected").Inc()
+		e.logger.Warn("Allowlisted fee recipient contract upgrade detected",
+			zap.String("name", allowlist[i].Name),
+			zap.String("old", previous[i].Address.String()),
+			zap.String("new", allowlist[i].Address.String()))
+	}
+
+	e.allowlist.Store(&allowlist)
+}
+
+// AllowedFeeRecipients returns the fee recipients any minipool may use, besides the one expected of it
+func (e *ExecutionLayer) AllowedFeeRecipients() []AllowedFeeRecipient {
+	allowlist := e.allowlist.Load()
+	if allowlist == nil {
+		return nil
+	}
+	return *allowlist
+}
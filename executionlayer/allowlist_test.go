@@ -0,0 +1,73 @@
+package executionlayer
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestResolveAllowlist(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	rETH := common.HexToAddress("0xaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeae")
+	extra := common.HexToAddress("0xbebebebebebebebebebebebebebebebebebebebe")
+	chain.contracts["rocketTokenRETH"] = rETH
+	e.FeeRecipientAllowlist = []string{"rocketTokenRETH", extra.String()}
+
+	opts := &bind.CallOpts{BlockNumber: big.NewInt(100)}
+	allowlist, err := e.resolveAllowlist(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []AllowedFeeRecipient{{Name: "rocketTokenRETH", Address: rETH}, {Name: extra.String(), Address: extra}}
+	if len(allowlist) != len(expected) || allowlist[0] != expected[0] || allowlist[1] != expected[1] {
+		t.Fatalf("expected %+v, got %+v", expected, allowlist)
+	}
+
+	// Unknown contracts aren't silently left out
+	e.FeeRecipientAllowlist = []string{"rocketTokenRETH", "rocketNonexistent"}
+	if _, err := e.resolveAllowlist(opts); err == nil {
+		t.Fatal("expected an unknown contract to fail")
+	}
+}
+
+func TestAllowlistUpgrades(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	rETH := common.HexToAddress("0xaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeae")
+	chain.contracts["rocketTokenRETH"] = rETH
+	opts := &bind.CallOpts{BlockNumber: big.NewInt(100)}
+
+	e.checkAllowlistUpgrades(opts)
+	if allowlist := e.AllowedFeeRecipients(); len(allowlist) != 1 || allowlist[0].Address != rETH {
+		t.Fatalf("expected rETH to be allowed, got %+v", allowlist)
+	}
+
+	// Resolving the same addresses again isn't an upgrade
+	e.checkAllowlistUpgrades(opts)
+	if got := testutil.ToFloat64(e.m.Counter("allowlist_upgrade_detected")); got != 0 {
+		t.Fatalf("expected no upgrade, got %v", got)
+	}
+
+	upgraded := common.HexToAddress("0xafafafafafafafafafafafafafafafafafafafaf")
+	chain.contracts["rocketTokenRETH"] = upgraded
+	e.checkAllowlistUpgrades(opts)
+	if allowlist := e.AllowedFeeRecipients(); len(allowlist) != 1 || allowlist[0].Address != upgraded {
+		t.Fatalf("expected the upgraded rETH to be allowed, got %+v", allowlist)
+	}
+	if got := testutil.ToFloat64(e.m.Counter("allowlist_upgrade_detected")); got != 1 {
+		t.Fatalf("expected the upgrade to be counted, got %v", got)
+	}
+
+	// If it can't be resolved, the last allowlist stays
+	delete(chain.contracts, "rocketTokenRETH")
+	e.checkAllowlistUpgrades(opts)
+	if allowlist := e.AllowedFeeRecipients(); len(allowlist) != 1 || allowlist[0].Address != upgraded {
+		t.Fatalf("expected the allowlist to be kept, got %+v", allowlist)
+	}
+}
@@ -0,0 +1,66 @@
+package executionlayer
+
+import (
+	"context"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+)
+
+// How often to update the gauges describing the contents of the cache
+const cacheMetricsInterval = time.Minute
+
+// cacheMetricsLoop periodically updates the cache gauges until ctx is cancelled
+func (e *ExecutionLayer) cacheMetricsLoop(ctx context.Context) {
+	ticker := time.NewTicker(cacheMetricsInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := e.updateCacheMetrics(); err != nil {
+			e.logger.Warn("Couldn't update cache metrics", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// updateCacheMetrics counts the minipools, nodes, and smoothing pool members in the cache
+func (e *ExecutionLayer) updateCacheMetrics() error {
+	minipools, err := e.cache.countMinipools()
+	if err != nil {
+		return err
+	}
+
+	// Collect the addresses first, so the cache isn't read from inside its own iterator
+	var nodes []common.Address
+	err = e.cache.forEachNode(func(addr common.Address) bool {
+		nodes = append(nodes, addr)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	inSP := 0
+	for _, addr := range nodes {
+		n, err := e.cache.getNodeInfo(addr)
+		if err != nil {
+			// Removed since it was listed
+			continue
+		}
+
+		if n.inSmoothingPool {
+			inSP++
+		}
+	}
+
+	e.m.Gauge("minipools").Set(float64(minipools))
+	e.m.Gauge("nodes").Set(float64(len(nodes)))
+	e.m.Gauge("smoothing_pool_nodes").Set(float64(inSP))
+	return nil
+}
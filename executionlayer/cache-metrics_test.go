@@ -0,0 +1,32 @@
+package executionlayer
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCacheMetrics(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.updateCacheMetrics(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]float64{
+		"minipools":            3,
+		"nodes":                2,
+		"smoothing_pool_nodes": 1,
+	} {
+		if got := testutil.ToFloat64(e.m.Gauge(name)); got != expected {
+			t.Errorf("expected %s to be %v, got %v", name, expected, got)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package executionlayer
 
 import (
 	"math/big"
+	"sync/atomic"
 
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
 	"github.com/ethereum/go-ethereum/common"
 	rptypes "github.com/rocket-pool/rocketpool-go/types"
 )
@@ -11,6 +13,12 @@ type NotFoundError struct{}
 
 type ForEachNodeClosure func(common.Address) bool
 
+// ForEachNodeInfoClosure is like ForEachNodeClosure, but is also passed the node's smoothing pool status and fee distributor
+type ForEachNodeInfoClosure func(addr common.Address, inSmoothingPool bool, feeDistributor common.Address) bool
+
+// ForEachMinipoolClosure is passed each minipool's pubkey and the node which owns it
+type ForEachMinipoolClosure func(pubkey rptypes.ValidatorPubkey, nodeAddr common.Address) bool
+
 func (e *NotFoundError) Error() string {
 	return "Key not found in cache"
 }
@@ -19,11 +27,38 @@ type Cache interface {
 	init() error
 	getMinipoolNode(rptypes.ValidatorPubkey) (common.Address, error)
 	addMinipoolNode(rptypes.ValidatorPubkey, common.Address) error
+	removeMinipoolNode(rptypes.ValidatorPubkey) error
+	getNodeMinipools(common.Address) ([]rptypes.ValidatorPubkey, error)
 	getNodeInfo(common.Address) (*nodeInfo, error)
 	addNodeInfo(common.Address, *nodeInfo) error
+	removeNodeInfo(common.Address) error
 	forEachNode(ForEachNodeClosure) error
+	forEachMinipool(ForEachMinipoolClosure) error
+	getMinipoolStatus(rptypes.ValidatorPubkey) (feerecipient.MinipoolStatus, error)
+	getMinipoolPubkey(minipoolAddr common.Address) (rptypes.ValidatorPubkey, error)
+	setMinipoolStatus(pubkey rptypes.ValidatorPubkey, minipoolAddr common.Address, status feerecipient.MinipoolStatus) error
+	getWithdrawalAddress(rptypes.ValidatorPubkey) (common.Address, error)
+	addWithdrawalAddress(rptypes.ValidatorPubkey, common.Address) error
+	countNodes() (int, error)
+	countMinipools() (int, error)
 	setHighestBlock(*big.Int)
 	getHighestBlock() *big.Int
 	deinit() error
 	reset() error
 }
+
+// storeHighestBlock atomically raises highestBlock to block, if block is higher.
+// Returns true if highestBlock was updated.
+func storeHighestBlock(highestBlock *atomic.Uint64, block *big.Int) bool {
+	n := block.Uint64()
+	for {
+		current := highestBlock.Load()
+		if current >= n {
+			return false
+		}
+
+		if highestBlock.CompareAndSwap(current, n) {
+			return true
+		}
+	}
+}
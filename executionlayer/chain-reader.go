@@ -0,0 +1,133 @@
+package executionlayer
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/rocket-pool/rocketpool-go/minipool"
+	"github.com/rocket-pool/rocketpool-go/node"
+	"github.com/rocket-pool/rocketpool-go/rocketpool"
+	"github.com/rocket-pool/rocketpool-go/storage"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+// ecClient is the subset of ethclient.Client the ExecutionLayer uses directly
+type ecClient interface {
+	bind.ContractCaller
+	ChainID(ctx context.Context) (*big.Int, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error)
+	SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error)
+	SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error)
+}
+
+// chainReader abstracts the rocketpool-go calls the ExecutionLayer makes.
+//
+// Reads are split into two classes. Essential reads are required to enforce
+// fee recipients, and failing them is fatal during the preload. Enrichment
+// reads are nice-to-have, and are the first to break when rocketpool-go lags
+// a protocol upgrade, so failing them only marks the field as unknown.
+type chainReader interface {
+	// Essential reads
+	nodeAddresses(opts *bind.CallOpts) ([]common.Address, error)
+	smoothingPoolStatus(nodeAddr common.Address, opts *bind.CallOpts) (bool, error)
+	feeDistributor(nodeAddr common.Address, opts *bind.CallOpts) (common.Address, error)
+	nodeMinipools(nodeAddr common.Address, opts *bind.CallOpts) ([]nodeMinipool, error)
+	minipoolPubkey(minipoolAddr common.Address, opts *bind.CallOpts) (rptypes.ValidatorPubkey, error)
+	nodeExists(nodeAddr common.Address, opts *bind.CallOpts) (bool, error)
+
+	// Enrichment reads
+	withdrawalAddress(nodeAddr common.Address, opts *bind.CallOpts) (common.Address, error)
+	rplStake(nodeAddr common.Address, opts *bind.CallOpts) (*big.Int, error)
+	minipoolStatus(minipoolAddr common.Address, opts *bind.CallOpts) (rptypes.MinipoolStatus, error)
+
+	// Protocol reads
+	nodeCount(opts *bind.CallOpts) (uint64, error)
+	minipoolCount(opts *bind.CallOpts) (uint64, error)
+	contract(name string, opts *bind.CallOpts) (*rocketpool.Contract, error)
+}
+
+// nodeMinipool is one of a node's minipools, as read by nodeMinipools
+type nodeMinipool struct {
+	addr   common.Address
+	pubkey rptypes.ValidatorPubkey
+}
+
+// rpChainReader implements chainReader with a live rocketpool-go client
+type rpChainReader struct {
+	rp *rocketpool.RocketPool
+}
+
+func (r *rpChainReader) nodeAddresses(opts *bind.CallOpts) ([]common.Address, error) {
+	return node.GetNodeAddresses(r.rp, opts)
+}
+
+func (r *rpChainReader) smoothingPoolStatus(nodeAddr common.Address, opts *bind.CallOpts) (bool, error) {
+	return node.GetSmoothingPoolRegistrationState(r.rp, nodeAddr, opts)
+}
+
+func (r *rpChainReader) feeDistributor(nodeAddr common.Address, opts *bind.CallOpts) (common.Address, error) {
+	return node.GetDistributorAddress(r.rp, nodeAddr, opts)
+}
+
+func (r *rpChainReader) nodeMinipools(nodeAddr common.Address, opts *bind.CallOpts) ([]nodeMinipool, error) {
+	minipools, err := minipool.GetNodeMinipools(r.rp, nodeAddr, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Destroyed minipools are removed from the node's set by the manager contract,
+	// but skip any whose details have already been cleared just in case.
+	out := make([]nodeMinipool, 0, len(minipools))
+	for _, mp := range minipools {
+		if !mp.Exists {
+			continue
+		}
+		out = append(out, nodeMinipool{addr: mp.Address, pubkey: mp.Pubkey})
+	}
+	return out, nil
+}
+
+func (r *rpChainReader) minipoolPubkey(minipoolAddr common.Address, opts *bind.CallOpts) (rptypes.ValidatorPubkey, error) {
+	details, err := minipool.GetMinipoolDetails(r.rp, minipoolAddr, opts)
+	if err != nil {
+		return rptypes.ValidatorPubkey{}, err
+	}
+	return details.Pubkey, nil
+}
+
+func (r *rpChainReader) nodeExists(nodeAddr common.Address, opts *bind.CallOpts) (bool, error) {
+	return node.GetNodeExists(r.rp, nodeAddr, opts)
+}
+
+func (r *rpChainReader) withdrawalAddress(nodeAddr common.Address, opts *bind.CallOpts) (common.Address, error) {
+	return storage.GetNodeWithdrawalAddress(r.rp, nodeAddr, opts)
+}
+
+func (r *rpChainReader) rplStake(nodeAddr common.Address, opts *bind.CallOpts) (*big.Int, error) {
+	return node.GetNodeRPLStake(r.rp, nodeAddr, opts)
+}
+
+func (r *rpChainReader) minipoolStatus(minipoolAddr common.Address, opts *bind.CallOpts) (rptypes.MinipoolStatus, error) {
+	mp, err := minipool.NewMinipool(r.rp, minipoolAddr, opts)
+	if err != nil {
+		return rptypes.Initialized, err
+	}
+	return mp.GetStatus(opts)
+}
+
+func (r *rpChainReader) nodeCount(opts *bind.CallOpts) (uint64, error) {
+	return node.GetNodeCount(r.rp, opts)
+}
+
+func (r *rpChainReader) minipoolCount(opts *bind.CallOpts) (uint64, error) {
+	return minipool.GetMinipoolCount(r.rp, opts)
+}
+
+func (r *rpChainReader) contract(name string, opts *bind.CallOpts) (*rocketpool.Contract, error) {
+	return r.rp.GetContract(name, opts)
+}
@@ -0,0 +1,137 @@
+package executionlayer
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/rocket-pool/rocketpool-go/rocketpool"
+	"go.uber.org/zap"
+)
+
+// ecConnection is everything built on top of a single EC endpoint
+type ecConnection struct {
+	client ecClient
+	rp     *rocketpool.RocketPool
+	chain  chainReader
+	close  func()
+}
+
+// dialEndpoint creates an ethclient and rocketpool-go client for the given endpoint
+func (e *ExecutionLayer) dialEndpoint(ctx context.Context, endpoint *url.URL) (*ecConnection, error) {
+	client, err := ethclient.DialContext(ctx, endpoint.String())
+	if err != nil {
+		return nil, err
+	}
+
+	rp, err := rocketpool.NewRocketPool(client, common.HexToAddress(e.rocketStorageAddr))
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &ecConnection{
+		client: client,
+		rp:     rp,
+		chain:  &rpChainReader{rp: rp},
+		close:  client.Close,
+	}, nil
+}
+
+// connectEndpoint dials the endpoint with the given index, and makes sure it's serving requests for the right chain
+func (e *ExecutionLayer) connectEndpoint(ctx context.Context, index int) (*ecConnection, error) {
+	conn, err := e.dial(ctx, e.ecURLs[index])
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.client.HeaderByNumber(ctx, nil)
+	if err == nil {
+		err = e.verifyChainID(ctx, conn.client)
+	}
+	if err != nil {
+		if conn.close != nil {
+			conn.close()
+		}
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// useConnection makes conn the connection every EC call goes through, and closes the previous one.
+// Only Init() and the event loop may call it. Other goroutines must use currentConnection().
+func (e *ExecutionLayer) useConnection(index int, conn *ecConnection) {
+	e.connLock.Lock()
+	previous := e.closeConnection
+	e.endpoint = index
+	e.client = conn.client
+	e.rp = conn.rp
+	e.chain = conn.chain
+	e.closeConnection = conn.close
+	if e.multicall != nil {
+		// The contracts are the same on every endpoint, so only the caller needs replacing
+		multicall := *e.multicall
+		mc := *multicall.mc
+		mc.caller = conn.client
+		multicall.mc = &mc
+		e.multicall = &multicall
+	}
+	e.connLock.Unlock()
+
+	if previous != nil {
+		previous()
+	}
+
+	e.m.Gauge("active_endpoint").Set(float64(index))
+	e.logger.Info("Using execution client endpoint", zap.Int("index", index), zap.String("host", e.ecURLs[index].Host))
+}
+
+// currentConnection returns the client and readers in use, for goroutines other than the event loop
+func (e *ExecutionLayer) currentConnection() (ecClient, chainReader, *multicallNodeReader) {
+	e.connLock.RLock()
+	defer e.connLock.RUnlock()
+
+	return e.client, e.chain, e.multicall
+}
+
+// connectFirstHealthyEndpoint connects to the first endpoint, in the order given, which is serving requests
+func (e *ExecutionLayer) connectFirstHealthyEndpoint(ctx context.Context) error {
+	if len(e.ecURLs) == 0 {
+		return fmt.Errorf("no execution client endpoints were provided")
+	}
+
+	var err error
+	for i := range e.ecURLs {
+		var conn *ecConnection
+		conn, err = e.connectEndpoint(ctx, i)
+		if err == nil {
+			e.useConnection(i, conn)
+			return nil
+		}
+
+		e.m.Counter("endpoint_unhealthy").Inc()
+		e.logger.Warn("Execution client endpoint is unhealthy", zap.Int("index", i), zap.String("host", e.ecURLs[i].Host), zap.Error(err))
+	}
+
+	return fmt.Errorf("no execution client endpoint is healthy: %w", err)
+}
+
+// rotateEndpoint connects to the endpoint after the current one, and switches to it.
+// If it isn't healthy, the current connection is kept.
+func (e *ExecutionLayer) rotateEndpoint(ctx context.Context) error {
+	next := (e.endpoint + 1) % len(e.ecURLs)
+
+	conn, err := e.connectEndpoint(ctx, next)
+	if err != nil {
+		e.m.Counter("endpoint_unhealthy").Inc()
+		return fmt.Errorf("couldn't fail over to endpoint %d: %w", next, err)
+	}
+
+	e.m.Counter("endpoint_failover").Inc()
+	e.logger.Warn("Failing over to the next execution client endpoint", zap.Int("from", e.endpoint), zap.Int("to", next))
+	e.useConnection(next, conn)
+	return nil
+}
@@ -0,0 +1,145 @@
+package executionlayer
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// withEndpoints makes e dial the given fake clients instead of real endpoints
+func withEndpoints(t *testing.T, e *ExecutionLayer, clients ...*fakeECClient) {
+	e.ecURLs = nil
+	byURL := make(map[string]*fakeECClient)
+	for i, client := range clients {
+		u, err := url.Parse(fmt.Sprintf("ws://ec%d:8546", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.ecURLs = append(e.ecURLs, u)
+		byURL[u.String()] = client
+	}
+
+	chain := e.chain
+	e.dial = func(ctx context.Context, endpoint *url.URL) (*ecConnection, error) {
+		return &ecConnection{client: byURL[endpoint.String()], chain: chain}, nil
+	}
+}
+
+func TestConnectFirstHealthyEndpoint(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	primary := &fakeECClient{headerErr: fmt.Errorf("connection refused")}
+	fallback := &fakeECClient{head: 100}
+	withEndpoints(t, e, primary, fallback)
+
+	if err := e.connectFirstHealthyEndpoint(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if e.endpoint != 1 || e.client != fallback {
+		t.Fatalf("expected the fallback to be used, got endpoint %d", e.endpoint)
+	}
+	if got := testutil.ToFloat64(e.m.Gauge("active_endpoint")); got != 1 {
+		t.Fatalf("expected the active endpoint metric to be 1, got %v", got)
+	}
+
+	fallback.headerErr = fmt.Errorf("connection refused")
+	if err := e.connectFirstHealthyEndpoint(context.Background()); err == nil {
+		t.Fatal("expected an error when no endpoint is healthy")
+	}
+}
+
+func TestEndpointFailover(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	// The fallback is behind the highest block processed from the primary
+	primary := &fakeECClient{head: 110}
+	fallback := &fakeECClient{head: 105}
+	withEndpoints(t, e, primary, fallback)
+	if err := e.connectFirstHealthyEndpoint(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	e.MaxReconnectAttempts = 2
+	e.events = make(chan types.Log, 32)
+	e.newHeaders = make(chan *types.Header, 32)
+	e.cache.setHighestBlock(big.NewInt(110))
+
+	// The primary goes away entirely
+	primary.subscribeErr = fmt.Errorf("connection refused")
+	subs := &subscriptions{logs: newFakeSubscription(), headers: newFakeSubscription()}
+	subs, err := e.handleSubscriptionError(context.Background(), fmt.Errorf("websocket: close 1006 (abnormal closure)"), subs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subs.unsubscribe()
+
+	if e.endpoint != 1 || e.client != fallback {
+		t.Fatalf("expected to fail over to the fallback, got endpoint %d", e.endpoint)
+	}
+	if primary.subscribeAttempts.Load() != 1 || fallback.subscribeAttempts.Load() != 1 {
+		t.Fatalf("expected one attempt on each endpoint, got %d and %d",
+			primary.subscribeAttempts.Load(), fallback.subscribeAttempts.Load())
+	}
+	if got := testutil.ToFloat64(e.m.Gauge("active_endpoint")); got != 1 {
+		t.Fatalf("expected the active endpoint metric to be 1, got %v", got)
+	}
+
+	// There was nothing to backfill, and the highest block didn't go backwards
+	if len(fallback.calls) != 0 {
+		t.Fatalf("expected no backfill, got %v", fallback.calls)
+	}
+	if e.backfillFailed.Load() {
+		t.Fatal("expected the backfill to succeed")
+	}
+	if e.cache.getHighestBlock().Uint64() != 110 {
+		t.Fatalf("expected the highest block to stay at 110, got %d", e.cache.getHighestBlock().Uint64())
+	}
+}
+
+func TestEndpointOnWrongChain(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	e.Network, _ = LookupNetwork("mainnet")
+	holesky := &fakeECClient{head: 100, chainID: 17000}
+	mainnet := &fakeECClient{head: 100, chainID: 1}
+	withEndpoints(t, e, holesky, mainnet)
+
+	if err := e.connectFirstHealthyEndpoint(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if e.endpoint != 1 || e.client != mainnet {
+		t.Fatalf("expected the endpoint on the wrong chain to be skipped, got endpoint %d", e.endpoint)
+	}
+
+	withEndpoints(t, e, holesky)
+	err := e.connectFirstHealthyEndpoint(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "chain ID 17000") {
+		t.Fatalf("expected a chain ID mismatch, got %v", err)
+	}
+}
+
+func TestVerifyRocketStorage(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	e.rocketStorageAddr = "0x1d8f8f00cfa6758d7bE78336684788Fb0ee0Fa46"
+	client := &fakeECClient{}
+	if err := e.verifyRocketStorage(context.Background(), client); err == nil {
+		t.Fatal("expected an error when rocketStorage isn't deployed")
+	}
+
+	client.code = map[common.Address][]byte{common.HexToAddress(e.rocketStorageAddr): {0x60, 0x80}}
+	if err := e.verifyRocketStorage(context.Background(), client); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,133 @@
+package executionlayer
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+)
+
+// Bonds are the sum of every minipool's node deposit, which is too many calls to make one by one
+var errBondsNeedMulticall = errors.New("bonds are only read through Multicall3")
+var errMinipoolDepositUnread = errors.New("a minipool's node deposit couldn't be read")
+
+// nodeEnrichment is a node's enrichment fields as read from the chain, with the error for each one that couldn't be
+type nodeEnrichment struct {
+	withdrawalAddress common.Address
+	rplStake          *big.Int
+	bond              *big.Int
+	errs              map[NodeField]error
+}
+
+// readNodeEnrichment reads a node's withdrawal address and RPL stake. Its bond is read in batches, see readBonds.
+func readNodeEnrichment(chain chainReader, addr common.Address, opts *bind.CallOpts) *nodeEnrichment {
+	var err error
+	out := &nodeEnrichment{errs: make(map[NodeField]error)}
+
+	out.withdrawalAddress, err = chain.withdrawalAddress(addr, opts)
+	if err != nil {
+		out.errs[WithdrawalAddressField] = err
+	}
+
+	out.rplStake, err = chain.rplStake(addr, opts)
+	if err != nil {
+		out.errs[RPLStakeField] = err
+		out.rplStake = nil
+	}
+
+	return out
+}
+
+// setBond sets the node's bond, as returned by readBonds
+func (n *nodeEnrichment) setBond(bond *big.Int, err error) {
+	n.bond = bond
+	if bond != nil {
+		return
+	}
+	if err == nil {
+		err = errMinipoolDepositUnread
+	}
+	n.errs[BondField] = err
+}
+
+// readBonds sums the ETH each node deposited for its minipools, given each node's minipool addresses, through
+// Multicall3 in as few calls as possible. A node's bond is nil if any of its minipools' deposits couldn't be read,
+// and err is set if none could.
+func readBonds(multicall *multicallNodeReader, minipools [][]common.Address, opts *bind.CallOpts) ([]*big.Int, error) {
+	var addrs []common.Address
+	for _, m := range minipools {
+		addrs = append(addrs, m...)
+	}
+
+	var deposits []*big.Int
+	var err error
+	if len(addrs) > 0 {
+		if multicall == nil {
+			err = errBondsNeedMulticall
+		} else {
+			deposits, err = multicall.minipoolNodeDeposits(addrs, opts)
+		}
+	}
+
+	out := make([]*big.Int, len(minipools))
+	next := 0
+	for i, m := range minipools {
+		// Nodes without minipools have nothing bonded, whether or not the others could be read
+		if len(m) == 0 {
+			out[i] = new(big.Int)
+		} else if err == nil {
+			out[i] = new(big.Int)
+			for _, deposit := range deposits[next : next+len(m)] {
+				if deposit == nil {
+					out[i] = nil
+					break
+				}
+				out[i].Add(out[i], deposit)
+			}
+		}
+		next += len(m)
+	}
+
+	return out, err
+}
+
+// setEnrichment copies the enrichment fields to n, marking those which couldn't be read as unknown
+func (e *ExecutionLayer) setEnrichment(addr common.Address, n *nodeInfo, enrichment *nodeEnrichment) {
+	n.withdrawalAddress = enrichment.withdrawalAddress
+	n.rplStake = enrichment.rplStake
+	n.bond = enrichment.bond
+	n.unknownFields = 0
+
+	for _, f := range nodeFieldNames {
+		err, ok := enrichment.errs[f.field]
+		if !ok {
+			continue
+		}
+
+		n.unknownFields |= f.field
+		if !errors.Is(err, errBondsNeedMulticall) {
+			e.m.Counter("enrichment_read_failed").Inc()
+		}
+		e.logger.Debug("Couldn't read enrichment field for node", zap.String("node", addr.String()), zap.String("field", f.name), zap.Error(err))
+	}
+}
+
+// applyEnrichment sets a node's enrichment fields
+func (e *ExecutionLayer) applyEnrichment(lookup *chainLookup) {
+	n, err := e.cache.getNodeInfo(lookup.addr)
+	if err != nil {
+		e.logger.Warn("Fetched enrichment fields for node which isn't in the cache", zap.String("node", lookup.addr.String()), zap.Error(err))
+		return
+	}
+
+	// The cached nodeInfo may be read concurrently, so update a copy
+	n = n.clone()
+	e.setEnrichment(lookup.addr, n, lookup.enrichment)
+	err = e.storeNodeInfo(lookup.addr, n)
+	if err != nil {
+		e.logger.Error("Failed to add nodeInfo to cache", zap.Error(err))
+	}
+	e.logger.Debug("Added enrichment fields for node", zap.String("node", lookup.addr.String()), zap.Strings("unknown", n.unknownFields.Names()))
+}
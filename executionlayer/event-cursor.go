@@ -0,0 +1,96 @@
+package executionlayer
+
+import (
+	"math"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"go.uber.org/zap"
+)
+
+// logPosition is where a log was emitted in the chain. Logs are ordered by block, then transaction, then log index.
+type logPosition struct {
+	block    uint64
+	txIndex  uint
+	logIndex uint
+}
+
+func eventPosition(event types.Log) logPosition {
+	return logPosition{
+		block:    event.BlockNumber,
+		txIndex:  event.TxIndex,
+		logIndex: event.Index,
+	}
+}
+
+// after returns true if p comes later in the chain than other
+func (p logPosition) after(other logPosition) bool {
+	if p.block != other.block {
+		return p.block > other.block
+	}
+	if p.txIndex != other.txIndex {
+		return p.txIndex > other.txIndex
+	}
+	return p.logIndex > other.logIndex
+}
+
+// endOfBlock returns the position after every log in block
+func endOfBlock(block uint64) logPosition {
+	return logPosition{
+		block:    block,
+		txIndex:  math.MaxUint,
+		logIndex: math.MaxUint,
+	}
+}
+
+// minipoolStatusCursor is the cursor key shared by every minipool's status events. There are far too many
+// minipools to keep a cursor for each, and since they're never upgraded, they can share one.
+var minipoolStatusCursor = common.Address{}
+
+// eventCursorKey returns the key of the cursor event is checked against
+func (e *ExecutionLayer) eventCursorKey(event types.Log) common.Address {
+	if e.isMinipoolStatusEvent(event) {
+		return minipoolStatusCursor
+	}
+	return event.Address
+}
+
+// advanceEventCursor records event as processed, and returns false if it was already: that is, if its contract
+// already had an event at or after its position processed. Backfills and subscriptions overlap whenever we
+// (re)connect, since the subscription is created first, so the same log can be delivered by both.
+//
+// The cursor is kept per contract, since after a contract upgrade, the new contract's events are backfilled
+// from before events the old contract emitted in the meantime. Minipool status events share one, see eventCursorKey.
+func (e *ExecutionLayer) advanceEventCursor(event types.Log) bool {
+	pos := eventPosition(event)
+	key := e.eventCursorKey(event)
+	last, ok := e.eventCursors[key]
+	if ok && !pos.after(last) {
+		e.m.Counter("duplicate_event_skipped").Inc()
+		e.logger.Debug("Skipping event which was already processed",
+			zap.Uint64("block", event.BlockNumber),
+			zap.Uint("tx index", event.TxIndex),
+			zap.Uint("log index", event.Index),
+			zap.String("contract", event.Address.String()))
+		return false
+	}
+
+	e.eventCursors[key] = pos
+	return true
+}
+
+// rewindEventCursor moves the cursor of a reorged out event's contract back to before the event's block,
+// so the replacement blocks' events aren't mistaken for ones we've already processed.
+func (e *ExecutionLayer) rewindEventCursor(event types.Log) {
+	key := e.eventCursorKey(event)
+	last, ok := e.eventCursors[key]
+	if !ok || eventPosition(event).after(last) {
+		return
+	}
+
+	if event.BlockNumber == 0 {
+		delete(e.eventCursors, key)
+		return
+	}
+	e.eventCursors[key] = endOfBlock(event.BlockNumber - 1)
+}
@@ -0,0 +1,128 @@
+package executionlayer
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestOverlappingReplay(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+	e.cache.setHighestBlock(big.NewInt(100))
+
+	newNode := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	chain.addNode(newNode, false)
+	minipoolAddr := common.HexToAddress("0x0909090909090909090909090909090909090909")
+	pubkey, _ := chain.minipoolPubkey(minipoolAddr, nil)
+
+	// A node registers and opts in within one transaction, another opts in and back out,
+	// and a minipool is created and destroyed
+	registered := nodeRegisteredLog(e, newNode, 110)
+	newNodeOptIn := spStatusChangedLog(e, newNode, true, 110)
+	newNodeOptIn.Index = 1
+	optIn := spStatusChangedLog(e, testNode1, true, 115)
+	created := minipoolCreatedLog(e, minipoolAddr, testNode1, 115)
+	optOut := spStatusChangedLog(e, testNode1, false, 120)
+	destroyed := minipoolDestroyedLog(e, minipoolAddr, testNode1, 125)
+	e.client = &fakeECClient{
+		head: 130,
+		logs: []types.Log{registered, newNodeOptIn, optIn, created, optOut, destroyed},
+	}
+
+	expect := func() {
+		t.Helper()
+		info, err := e.SmoothingPoolInfo()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.OptedInNodes != 2 || info.Nodes != 3 {
+			t.Fatalf("expected 2 of 3 nodes in the smoothing pool, got %+v", info)
+		}
+		if n, err := e.cache.getNodeInfo(testNode1); err != nil || n.inSmoothingPool {
+			t.Fatalf("expected %s to have opted out, got %+v %v", testNode1.String(), n, err)
+		}
+		if _, err := e.cache.getMinipoolNode(pubkey); err == nil {
+			t.Fatal("expected the destroyed minipool to stay removed")
+		}
+	}
+
+	if err := e.backfillEvents(); err != nil {
+		t.Fatal(err)
+	}
+	expect()
+
+	// The subscription, created before the backfill, delivers some of the same logs again
+	for _, event := range []types.Log{newNodeOptIn, optIn, created, destroyed} {
+		e.handleEvent(event)
+	}
+	expect()
+	if skipped := testutil.ToFloat64(e.m.Counter("duplicate_event_skipped")); skipped != 4 {
+		t.Fatalf("expected 4 duplicate events to be skipped, got %v", skipped)
+	}
+
+	// Backfilling the same window again changes nothing either
+	if err := e.backfillEventsFrom(101); err != nil {
+		t.Fatal(err)
+	}
+	expect()
+	if skipped := testutil.ToFloat64(e.m.Counter("duplicate_event_skipped")); skipped != 10 {
+		t.Fatalf("expected 10 duplicate events to be skipped, got %v", skipped)
+	}
+}
+
+func TestEventCursorReorg(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	inSP := func() bool {
+		t.Helper()
+		n, err := e.cache.getNodeInfo(testNode1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n.inSmoothingPool
+	}
+
+	optIn := spStatusChangedLog(e, testNode1, true, 120)
+	optIn.TxIndex = 3
+	e.handleEvent(optIn)
+	if !inSP() {
+		t.Fatal("expected the node to have opted in")
+	}
+
+	// Block 120 is reorged out, and the replacement includes the same log earlier in the block
+	e.handleEvent(removed(optIn))
+	if inSP() {
+		t.Fatal("expected the opt in to be reverted")
+	}
+	optIn.TxIndex = 1
+	e.handleEvent(optIn)
+	if !inSP() {
+		t.Fatal("expected the replacement opt in to be applied")
+	}
+
+	// Cursors are per contract, so events backfilled from an upgraded contract aren't mistaken for duplicates
+	e.handleEvent(spStatusChangedLog(e, testNode1, false, 130))
+	minipoolAddr := common.HexToAddress("0x0909090909090909090909090909090909090909")
+	e.handleEvent(minipoolCreatedLog(e, minipoolAddr, testNode1, 125))
+	pubkey, _ := e.chain.minipoolPubkey(minipoolAddr, nil)
+	if _, err := e.cache.getMinipoolNode(pubkey); err != nil {
+		t.Fatalf("expected the minipool to be indexed, got %v", err)
+	}
+	if skipped := testutil.ToFloat64(e.m.Counter("duplicate_event_skipped")); skipped != 0 {
+		t.Fatalf("expected no events to be skipped, got %v", skipped)
+	}
+}
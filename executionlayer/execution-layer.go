@@ -3,47 +3,213 @@ package executionlayer
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"math/big"
 	"net/url"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/internal/backoff"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/internal/ttlcache"
 	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/rocket-pool/rocketpool-go/minipool"
-	"github.com/rocket-pool/rocketpool-go/node"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/rocket-pool/rocketpool-go/rocketpool"
 	rptypes "github.com/rocket-pool/rocketpool-go/types"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 )
 
-const reconnectRetries = 10
 const maxCacheAgeBlocks = 64
+const defaultBackfillChunkSize = 1000
+const defaultPreloadConcurrency = 16
+const defaultStaleBlocks = 16
+
+// How far behind the head PreloadBlock may be before warning that the backfill after warming up will be slow.
+// About a week of mainnet blocks.
+const preloadBlockWarnDistance = 50400
+const defaultBackfillRetryWindow = 5 * time.Minute
+const maxBackfillRetryWait = 30 * time.Second
+const defaultHeaderTimeout = 60 * time.Second
+
+// The wait after the first failed backfill attempt, which grows linearly with each attempt
+var backfillRetryWait = time.Second
+
+// The waits between failed reconnect attempts, from a second up to a minute
+var reconnectBackoff = backoff.Backoff{Initial: time.Second, Max: time.Minute}
+
+// How often to check RocketStorage for upgrades of the contracts we subscribe to
+const contractCheckIntervalBlocks = 32
+
+// How many blocks to remember new minipools for, so their index entries can be reverted on reorg
+const maxReorgDepthBlocks = 64
+
+// NodeField is a bitmask identifying the enrichment fields of a node record
+type NodeField uint8
+
+const (
+	// WithdrawalAddressField is the node's withdrawal address
+	WithdrawalAddressField NodeField = 1 << iota
+	// RPLStakeField is the node's staked RPL
+	RPLStakeField
+	// BondField is the ETH the node deposited for its minipools
+	BondField
+)
+
+// Every enrichment field, which are unknown on new nodes until their enrichment lookup completes
+const enrichmentFields = WithdrawalAddressField | RPLStakeField | BondField
+
+var nodeFieldNames = []struct {
+	field NodeField
+	name  string
+}{
+	{WithdrawalAddressField, "withdrawal_address"},
+	{RPLStakeField, "rpl_stake"},
+	{BondField, "bond"},
+}
+
+// Names returns the names of the fields set in the bitmask
+func (f NodeField) Names() []string {
+	out := make([]string, 0, len(nodeFieldNames))
+	for _, n := range nodeFieldNames {
+		if f&n.field != 0 {
+			out = append(out, n.name)
+		}
+	}
+	return out
+}
+
+type recentMinipool struct {
+	pubkey rptypes.ValidatorPubkey
+	block  uint64
+}
 
+// nodeInfo is treated as immutable once it has been added to the cache, since it's read
+// concurrently from the request path. To update a node, add a modified clone() instead.
 type nodeInfo struct {
 	inSmoothingPool bool
 	feeDistributor  common.Address
+
+	// Enrichment fields. These aren't needed to enforce fee recipients, so they
+	// are read on a best-effort basis, and unknownFields marks any we failed to read.
+	withdrawalAddress common.Address
+	rplStake          *big.Int
+	bond              *big.Int
+	unknownFields     NodeField
 }
 
 // ExecutionLayer is a bespoke execution layer client for the rescue proxy.
 // It abstracts away all the work to cache in-memory the data needed to enforce
 // that fee recipients are 'correct'.
 type ExecutionLayer struct {
+	// The maximum number of blocks to request events for in a single FilterLogs call while backfilling
+	BackfillChunkSize uint64
+
+	// The number of nodes to read concurrently while warming up the cache
+	PreloadConcurrency int
+
+	// The block to warm up the cache at, instead of the EC's head. Events from it to the head are backfilled
+	// once subscribed, so the result is the same, but reproducible on private networks and forks. 0 means the head.
+	PreloadBlock uint64
+
+	// The address of the Multicall3 contract used to batch reads while warming up the cache.
+	// Leave blank to read nodes individually.
+	MulticallAddr string
+
+	// The number of blocks the cache may lag the EC's head by before it's considered stale
+	StaleBlocks uint64
+
+	// How long to retry a failed backfill for before reporting the cache as stale
+	BackfillRetryWindow time.Duration
+
+	// The number of failed attempts to reconnect to the EC after which the cache is reported as stale. Attempts go on
+	// at the longest backoff until one succeeds. 0 means the cache is never reported as stale for it.
+	MaxReconnectAttempts int
+
+	// How long to go without a new header before reconnecting to the EC. 0 disables the check.
+	HeaderTimeout time.Duration
+
+	// The chain every endpoint must be on
+	Network Network
+
+	// Whether to poll the EC for new events instead of subscribing to them
+	PollMode PollMode
+
+	// How often to poll the EC for new events, when polling
+	PollInterval time.Duration
+
+	// The number of events, and of headers, to buffer from the EC's subscriptions while the event loop is busy
+	SubscriptionBufferSize int
+
+	// The number of new minipools' pubkeys and new nodes' fee distributors to read from the EC at once, off the event loop
+	LookupWorkers int
+
+	// The number of lookups which may wait for a worker before the event loop waits with them
+	LookupQueueSize int
+
+	// How often to compare a sample of cached nodes against the chain. 0 disables reconciliation.
+	ReconcileInterval time.Duration
+
+	// The number of nodes to compare against the chain each time the cache is reconciled
+	ReconcileSampleSize int
+
+	// Fee recipients any minipool may use, besides the one expected of it. Each is a contract name, resolved
+	// through rocketStorage so it follows upgrades, or an address.
+	FeeRecipientAllowlist []string
+
+	// How often to compare a rolling sample of cached fee distributor addresses against the chain. 0 disables the check.
+	FeeDistributorCheckInterval time.Duration
+
+	// The number of nodes whose fee distributor addresses are compared against the chain each time they're checked
+	FeeDistributorCheckSampleSize int
+
+	// Looks up withdrawal credentials for SoloValidatorFeeRecipient()
+	WithdrawalCredentials WithdrawalCredentialsProvider
+
+	// How long to trust that a validator has BLS withdrawal credentials before asking the CL again.
+	// 0 means one epoch. 0x01 credentials can't change, so they're cached indefinitely.
+	BLSCredentialsTTL time.Duration
+
+	// How long to remember that the chain said a node isn't registered, before NodeRegistered() asks it again.
+	// 0 means one minute.
+	UnregisteredNodeTTL time.Duration
+
 	// Fields passed in by the constructor which are later referenced
 
 	logger            *zap.Logger
-	ecURL             *url.URL
+	ecURLs            []*url.URL
 	rocketStorageAddr string
 
+	// Connects to an EC endpoint. Replaceable for testing.
+	dial func(ctx context.Context, endpoint *url.URL) (*ecConnection, error)
+
+	// The index in ecURLs of the endpoint in use
+	endpoint int
+
 	// The rocketpool-go client and its ethclient instance
 
 	rp     *rocketpool.RocketPool
-	client *ethclient.Client
+	client ecClient
+
+	// Wraps rp for the contract reads we make
+	chain chainReader
+
+	// Batches preload reads and new minipools' lookups, or nil if Multicall3 isn't available
+	multicall *multicallNodeReader
+
+	// client, rp, chain and multicall are replaced when failing over to another endpoint.
+	// That only happens on the event loop, under connLock, so goroutines other than the
+	// event loop must hold it to read them. See currentConnection().
+	connLock        sync.RWMutex
+	closeConnection func()
 
 	// Smart contracts we either read from or need the address of
 
@@ -51,61 +217,202 @@ type ExecutionLayer struct {
 	rocketMinipoolManager *rocketpool.Contract
 	smoothingPool         *rocketpool.Contract
 
+	// The block at which the contract addresses were last resolved.
+	// Only accessed from the event loop.
+	contractsCheckedBlock uint64
+
 	// The "topics" of the events we subscribe to
 
 	nodeRegisteredTopic             common.Hash
 	smoothingPoolStatusChangedTopic common.Hash
 	minipoolLaunchedTopic           common.Hash
+	minipoolDestroyedTopic          common.Hash
+	// Emitted by each minipool contract, rather than the minipool manager
+	minipoolStatusUpdatedTopic common.Hash
 
-	// The "topics" and contract filter for the events we subscribe to
-	query ethereum.FilterQuery
+	// The "topics" and contract filter for the events we subscribe to, and the topic of minipools' status updates
+	query       ethereum.FilterQuery
+	statusQuery ethereum.FilterQuery
 
 	// Channels for those subscriptions
 	events     chan types.Log
 	newHeaders chan *types.Header
 
+	// The number of the last header received. Only accessed from the event loop.
+	headerBlock uint64
+
+	// New minipools and nodes waiting for the chain to be read, the workers' results, and failed lookups due a retry.
+	// See queueLookup().
+	lookups       chan *lookupBatch
+	lookupResults chan *lookupBatch
+	lookupRetries chan *chainLookup
+
+	// New minipools waiting to be looked up together in a single multicall, and when they're due to be,
+	// if they aren't flushed sooner. Only accessed from the event loop. See batchMinipoolLookup().
+	minipoolBatch    []*chainLookup
+	minipoolBatchDue <-chan time.Time
+
+	// Lookups which haven't succeeded yet, so reorgs and destroys can cancel them,
+	// and how many the workers have queued or in progress. Only accessed from the event loop.
+	pendingLookups  map[lookupKey]*chainLookup
+	lookupsInFlight int
+
+	// The block at which lookups which ran out of retries were last tried again.
+	// Only accessed from the event loop.
+	lookupsSweptBlock uint64
+
 	// Somewhere to store chain data we care about
 	cache Cache
 
-	// ethclient subscription needs to be manually closed on shutdown
-	ethclientShutdownCb func()
+	// Recently launched minipools, by minipool address, so we can revert them on reorg
+	// without querying a contract that may no longer exist.
+	// Only accessed from the event loop.
+	recentMinipools map[common.Address]recentMinipool
+
+	// The block of the last event which updated each node, so reconciliation doesn't
+	// overwrite changes made after the block it read the chain at.
+	// Only accessed from the event loop.
+	nodeUpdatedBlocks map[common.Address]uint64
+
+	// The position of the last event processed from each contract, whether it was backfilled or
+	// delivered by a subscription. See advanceEventCursor. Only accessed from the event loop.
+	eventCursors map[common.Address]logPosition
+
+	// Used by the reconciler to have the event loop snapshot and repair the cache
+	reconcileRequests chan chan *reconcileSnapshot
+	reconcileResults  chan *reconcileResult
+
+	// Used by fee distributor checks to have the event loop update mismatched nodes
+	feeDistributorRepairs chan *feeDistributorRepair
+
+	// FeeRecipientAllowlist as resolved at the last contract upgrade check
+	allowlist atomic.Pointer[[]AllowedFeeRecipient]
+
+	// Serializes fee distributor checks, and guards the last node the previous rolling sample checked
+	feeDistributorCheckLock sync.Mutex
+	feeDistributorCursor    common.Address
 
 	// wg to be blocked on to let pending events be processed for graceful shutdown
 	wg sync.WaitGroup
 
+	// Cancelled by Deinit() to stop the event loop, which owns the ethclient subscriptions
+	// and closes them on the way out. This also aborts any reconnect or backfill retries.
 	// Sometimes, we get errors from the subscription error channels on shutdown-
-	// presumably, the Unsubscribe() call is less graceful than ideal.
-	// Set this to true before calling Unsubscribe() so we can ignore subsequent errors
-	shutdown bool
+	// presumably, the Unsubscribe() call is less graceful than ideal, so errors received
+	// after cancellation are ignored.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// The EC's head as of the last refresh, and when it was fetched (in unix nanoseconds). See headRefreshLoop.
+	head          atomic.Uint64
+	headRefreshed atomic.Int64
+	headUnknown   atomic.Bool
+
+	// Validators known to have BLS withdrawal credentials, until blsCredentialsTTL() passes. The cache is bounded,
+	// since any validator may be asked about. Validators with 0x01 or 0x02 credentials are stored in the cache instead.
+	blsCredentials     *ttlcache.Cache[struct{}]
+	blsCredentialsOnce sync.Once
+
+	// When each node the chain said isn't registered was last checked. See NodeRegistered().
+	unregisteredNodes sync.Map
+
+	// Consumers of node events, see SubscribeNodeEvents()
+	nodeEvents nodeEventSubscribers
+
+	// Set when a backfill couldn't complete within BackfillRetryWindow
+	backfillFailed atomic.Bool
+
+	// Set when MaxReconnectAttempts reconnects in a row have failed, until one succeeds
+	reconnectFailed atomic.Bool
+
+	// Set once Init has warmed up the cache and started the event loop
+	warm atomic.Bool
+
+	// Which nodes are cached, and how many are in the smoothing pool. See storeNodeInfo.
+	nodeCounts nodeCounts
 
 	m *metrics.MetricsRegistry
 }
 
-// NewExecutionLayer creates an ExecutionLayer with the provided ec URL, rocketStorage address, cache, and logger
-func NewExecutionLayer(ecURL *url.URL, rocketStorageAddr string, cache Cache, logger *zap.Logger) *ExecutionLayer {
+// NewExecutionLayer creates an ExecutionLayer with the provided ec URLs, rocketStorage address, cache, and logger.
+// The first healthy ec URL is used, and the others are failed over to in order if it stops responding.
+func NewExecutionLayer(ecURLs []*url.URL, rocketStorageAddr string, cache Cache, logger *zap.Logger) *ExecutionLayer {
 	out := &ExecutionLayer{}
 	out.logger = logger
 	out.rocketStorageAddr = rocketStorageAddr
-	out.ecURL = ecURL
+	out.ecURLs = ecURLs
+	out.dial = out.dialEndpoint
 	out.cache = cache
+	out.recentMinipools = make(map[common.Address]recentMinipool)
+	out.nodeUpdatedBlocks = make(map[common.Address]uint64)
+	out.eventCursors = make(map[common.Address]logPosition)
+	out.reconcileRequests = make(chan chan *reconcileSnapshot)
+	out.reconcileResults = make(chan *reconcileResult)
+	out.feeDistributorRepairs = make(chan *feeDistributorRepair)
+	out.pendingLookups = make(map[lookupKey]*chainLookup)
+	out.lookupRetries = make(chan *chainLookup)
+	out.BackfillChunkSize = defaultBackfillChunkSize
+	out.PreloadConcurrency = defaultPreloadConcurrency
+	out.MulticallAddr = DefaultMulticallAddr
+	out.StaleBlocks = defaultStaleBlocks
+	out.BackfillRetryWindow = defaultBackfillRetryWindow
+	out.HeaderTimeout = defaultHeaderTimeout
+	out.PollMode = PollModeAuto
+	out.PollInterval = defaultPollInterval
+	out.SubscriptionBufferSize = defaultSubscriptionBufferSize
+	out.LookupWorkers = defaultLookupWorkers
+	out.LookupQueueSize = defaultLookupQueueSize
+	out.ReconcileInterval = defaultReconcileInterval
+	out.ReconcileSampleSize = defaultReconcileSampleSize
+	out.FeeRecipientAllowlist = DefaultFeeRecipientAllowlist
+	out.FeeDistributorCheckInterval = defaultFeeDistributorCheckInterval
+	out.FeeDistributorCheckSampleSize = defaultFeeDistributorCheckSampleSize
 	out.m = metrics.NewMetricsRegistry("execution_layer")
+	out.ctx, out.cancel = context.WithCancel(context.Background())
+
+	out.nodeRegisteredTopic = crypto.Keccak256Hash([]byte("NodeRegistered(address,uint256)"))
+	out.smoothingPoolStatusChangedTopic = crypto.Keccak256Hash([]byte("NodeSmoothingPoolStateChanged(address,bool)"))
+	out.minipoolLaunchedTopic = crypto.Keccak256Hash([]byte("MinipoolCreated(address,address,uint256)"))
+	out.minipoolDestroyedTopic = crypto.Keccak256Hash([]byte("MinipoolDestroyed(address,address,uint256)"))
+	out.minipoolStatusUpdatedTopic = crypto.Keccak256Hash([]byte("StatusUpdated(uint8,uint256)"))
 
 	return out
 }
 
-func (e *ExecutionLayer) setECShutdownCb(cb func()) {
-	if cb == nil {
-		e.ethclientShutdownCb = nil
-		return
+// clone returns a copy of the nodeInfo which can be safely modified
+func (n *nodeInfo) clone() *nodeInfo {
+	out := *n
+	if n.rplStake != nil {
+		out.rplStake = big.NewInt(0).Set(n.rplStake)
+	}
+	if n.bond != nil {
+		out.bond = big.NewInt(0).Set(n.bond)
+	}
+	return &out
+}
+
+// toMinipoolStatus converts a minipool status read from the chain to the one reported with fee recipients
+func toMinipoolStatus(status rptypes.MinipoolStatus) feerecipient.MinipoolStatus {
+	if status > rptypes.Dissolved {
+		return feerecipient.MinipoolStatusUnknown
 	}
+	return feerecipient.MinipoolStatus(status) + feerecipient.MinipoolStatusInitialized
+}
 
-	e.ethclientShutdownCb = func() {
-		cb()
-		e.logger.Debug("Unsubscribed from EL events")
+// readMinipoolStatus reads a minipool's status, which is an enrichment read, so never fails.
+// If it can't be read, the status is unknown.
+func (e *ExecutionLayer) readMinipoolStatus(minipoolAddr common.Address, opts *bind.CallOpts) feerecipient.MinipoolStatus {
+	status, err := e.chain.minipoolStatus(minipoolAddr, opts)
+	if err != nil {
+		e.m.Counter("enrichment_read_failed").Inc()
+		e.logger.Debug("Couldn't get status for minipool", zap.String("minipool", minipoolAddr.String()), zap.Error(err))
+		return feerecipient.MinipoolStatusUnknown
 	}
+	return toMinipoolStatus(status)
 }
 
 func (e *ExecutionLayer) handleNodeEvent(event types.Log) {
+	e.nodeUpdatedBlocks[common.BytesToAddress(event.Topics[1].Bytes())] = event.BlockNumber
 
 	// Check if it's a node registration
 	if bytes.Equal(event.Topics[0].Bytes(), e.nodeRegisteredTopic.Bytes()) {
@@ -113,18 +420,18 @@ func (e *ExecutionLayer) handleNodeEvent(event types.Log) {
 
 		addr := common.BytesToAddress(event.Topics[1].Bytes())
 		// When we see new nodes register, assume they aren't in the SP and add to index
-		nodeInfo := &nodeInfo{}
-		// Get their fee distributor address
-		nodeInfo.feeDistributor, err = node.GetDistributorAddress(e.rp, addr, nil)
-		if err != nil {
-			e.logger.Warn("Couldn't get fee distributor address for newly registered node", zap.String("node", addr.String()))
-		}
-		err = e.cache.addNodeInfo(addr, nodeInfo)
+		nodeInfo := &nodeInfo{unknownFields: enrichmentFields}
+		err = e.storeNodeInfo(addr, nodeInfo)
 		if err != nil {
 			e.logger.Error("Failed to add nodeInfo to cache", zap.Error(err))
 		}
 
+		// Then get their fee distributor address. Until it's known, their validators' fee recipients can't be checked.
+		e.queueLookup(&chainLookup{kind: feeDistributorLookup, addr: addr, block: event.BlockNumber})
+		e.queueLookup(&chainLookup{kind: enrichmentLookup, addr: addr, block: event.BlockNumber})
+
 		e.m.Counter("node_registration_added").Inc()
+		e.publishNodeEvent(NodeEvent{Type: NodeRegistered, NodeAddress: addr, InSmoothingPool: nodeInfo.inSmoothingPool, Block: event.BlockNumber})
 		e.logger.Debug("New node registered", zap.String("addr", addr.String()))
 		return
 	}
@@ -132,7 +439,8 @@ func (e *ExecutionLayer) handleNodeEvent(event types.Log) {
 	// Otherwise it should be a smoothing pool update
 	if bytes.Equal(event.Topics[0].Bytes(), e.smoothingPoolStatusChangedTopic.Bytes()) {
 		var n *nodeInfo
-		// When we see a SP status change, update the pointer in the index
+		var unknown bool
+		// When we see a SP status change, replace the pointer in the index
 		nodeAddr := common.BytesToAddress(event.Topics[1].Bytes())
 		status := big.NewInt(0).SetBytes(event.Data)
 
@@ -148,32 +456,204 @@ func (e *ExecutionLayer) handleNodeEvent(event types.Log) {
 
 			// Odd that we don't have this node already, but add it and carry on
 			e.logger.Warn("Unknown node updated its smoothing pool status", zap.String("addr", nodeAddr.String()))
-			n = &nodeInfo{}
-			// Get their fee distributor address
-			n.feeDistributor, err = node.GetDistributorAddress(e.rp, nodeAddr, nil)
-			if err != nil {
-				e.logger.Warn("Couldn't compute fee distributor address for unknown node", zap.String("node", nodeAddr.String()))
-			}
+			n = &nodeInfo{unknownFields: enrichmentFields}
+			unknown = true
 
+		} else {
+			// The cached nodeInfo may be read concurrently, so update a copy
+			n = n.clone()
 		}
 
 		e.logger.Debug("Node SP status changed", zap.String("addr", nodeAddr.String()), zap.Bool("in_sp", status.Cmp(big.NewInt(1)) == 0))
 		n.inSmoothingPool = status.Cmp(big.NewInt(1)) == 0
-		err = e.cache.addNodeInfo(nodeAddr, n)
+		err = e.storeNodeInfo(nodeAddr, n)
 		if err != nil {
 			e.logger.Error("Failed to add nodeInfo to cache", zap.Error(err))
 		}
+		if unknown {
+			e.queueLookup(&chainLookup{kind: feeDistributorLookup, addr: nodeAddr, block: event.BlockNumber})
+			e.queueLookup(&chainLookup{kind: enrichmentLookup, addr: nodeAddr, block: event.BlockNumber})
+		}
 
 		e.m.Counter("smoothing_pool_status_changed").Inc()
+		e.publishNodeEvent(NodeEvent{Type: NodeSmoothingPoolStatusChanged, NodeAddress: nodeAddr, InSmoothingPool: n.inSmoothingPool, Block: event.BlockNumber})
 		return
 	}
 
 	e.logger.Warn("Event with unknown topic received", zap.String("string", event.Topics[0].String()))
 }
 
+// revertNodeEvent undoes the index change made by a node event that was reorged out
+func (e *ExecutionLayer) revertNodeEvent(event types.Log) {
+	nodeAddr := common.BytesToAddress(event.Topics[1].Bytes())
+	e.nodeUpdatedBlocks[nodeAddr] = event.BlockNumber
+
+	if bytes.Equal(event.Topics[0].Bytes(), e.nodeRegisteredTopic.Bytes()) {
+		// If the registration is included in the new chain, it will be redelivered
+		e.cancelLookup(feeDistributorLookup, nodeAddr)
+		e.cancelLookup(enrichmentLookup, nodeAddr)
+		err := e.deleteNodeInfo(nodeAddr)
+		if err != nil {
+			e.logger.Error("Failed to remove nodeInfo from cache", zap.Error(err))
+		}
+
+		e.m.Counter("node_registration_reverted").Inc()
+		e.publishNodeEvent(NodeEvent{Type: NodeRegistrationReverted, NodeAddress: nodeAddr, Block: event.BlockNumber})
+		e.logger.Warn("Node registration reorged out", zap.String("addr", nodeAddr.String()))
+		return
+	}
+
+	if bytes.Equal(event.Topics[0].Bytes(), e.smoothingPoolStatusChangedTopic.Bytes()) {
+		n, err := e.cache.getNodeInfo(nodeAddr)
+		if err != nil {
+			// If the node isn't in the index, there's nothing to revert
+			e.logger.Debug("Reorged smoothing pool status change for unknown node", zap.String("addr", nodeAddr.String()), zap.Error(err))
+			return
+		}
+
+		// We can't know which of several status changes were reverted, so re-read the status at head
+		inSP, err := e.chain.smoothingPoolStatus(nodeAddr, nil)
+		if err != nil {
+			e.logger.Error("Couldn't re-read smoothing pool status after reorg", zap.String("addr", nodeAddr.String()), zap.Error(err))
+			return
+		}
+
+		n = n.clone()
+		n.inSmoothingPool = inSP
+		err = e.storeNodeInfo(nodeAddr, n)
+		if err != nil {
+			e.logger.Error("Failed to add nodeInfo to cache", zap.Error(err))
+		}
+
+		e.m.Counter("smoothing_pool_status_reverted").Inc()
+		e.publishNodeEvent(NodeEvent{Type: NodeSmoothingPoolStatusChanged, NodeAddress: nodeAddr, InSmoothingPool: inSP, Block: event.BlockNumber})
+		e.logger.Warn("Node SP status change reorged out", zap.String("addr", nodeAddr.String()), zap.Bool("in_sp", inSP))
+		return
+	}
+
+	e.logger.Warn("Reorged event with unknown topic received", zap.String("string", event.Topics[0].String()))
+}
+
+// revertMinipoolEvent undoes the index change made by a minipool event that was reorged out
+func (e *ExecutionLayer) revertMinipoolEvent(event types.Log) {
+	minipoolAddr := common.BytesToAddress(event.Topics[1].Bytes())
+
+	if bytes.Equal(event.Topics[0].Bytes(), e.minipoolDestroyedTopic.Bytes()) {
+		// The destroy didn't happen after all, so put the minipool back
+		nodeAddr := common.BytesToAddress(event.Topics[2].Bytes())
+		pubkey, err := e.destroyedMinipoolPubkey(minipoolAddr, event.BlockNumber)
+		if err != nil {
+			e.logger.Error("Couldn't determine pubkey of minipool whose destruction was reorged out", zap.String("minipool", minipoolAddr.String()), zap.Error(err))
+			return
+		}
+
+		err = e.cache.addMinipoolNode(pubkey, nodeAddr)
+		if err != nil {
+			e.logger.Error("Error updating minipool cache", zap.Error(err))
+		}
+
+		e.m.Counter("minipool_destroy_reverted").Inc()
+		e.logger.Warn("Minipool destruction reorged out", zap.String("pubkey", pubkey.String()), zap.String("minipool", minipoolAddr.String()))
+		return
+	}
+
+	if !bytes.Equal(event.Topics[0].Bytes(), e.minipoolLaunchedTopic.Bytes()) {
+		e.logger.Warn("Reorged event with unknown topic received", zap.String("string", event.Topics[0].String()))
+		return
+	}
+
+	if e.cancelLookup(minipoolPubkeyLookup, minipoolAddr) {
+		e.m.Counter("minipool_launch_reverted").Inc()
+		e.logger.Warn("Minipool creation reorged out before it was added", zap.String("minipool", minipoolAddr.String()))
+		return
+	}
+
+	// The minipool contract may not exist post-reorg, so prefer the pubkey we saw when it was created
+	recent, ok := e.recentMinipools[minipoolAddr]
+	pubkey := recent.pubkey
+	if ok {
+		delete(e.recentMinipools, minipoolAddr)
+	} else {
+		var err error
+
+		pubkey, err = e.chain.minipoolPubkey(minipoolAddr, nil)
+		if err != nil {
+			e.logger.Error("Couldn't determine pubkey of reorged minipool", zap.String("minipool", minipoolAddr.String()), zap.Error(err))
+			return
+		}
+	}
+
+	err := e.cache.removeMinipoolNode(pubkey)
+	if err != nil {
+		e.logger.Error("Error updating minipool cache", zap.Error(err))
+	}
+
+	e.m.Counter("minipool_launch_reverted").Inc()
+	e.logger.Warn("Minipool creation reorged out", zap.String("pubkey", pubkey.String()), zap.String("minipool", minipoolAddr.String()))
+}
+
+// destroyedMinipoolPubkey finds the pubkey of a minipool destroyed in the given block.
+// Destroying a minipool clears its details, so read them from the block before, and
+// fall back to any pubkey we remember from its creation.
+func (e *ExecutionLayer) destroyedMinipoolPubkey(minipoolAddr common.Address, block uint64) (rptypes.ValidatorPubkey, error) {
+	if recent, ok := e.recentMinipools[minipoolAddr]; ok {
+		return recent.pubkey, nil
+	}
+
+	opts := &bind.CallOpts{}
+	if block > 0 {
+		opts.BlockNumber = new(big.Int).SetUint64(block - 1)
+	}
+
+	return e.chain.minipoolPubkey(minipoolAddr, opts)
+}
+
+// rememberMinipool tracks a new minipool for maxReorgDepthBlocks and forgets older ones
+func (e *ExecutionLayer) rememberMinipool(minipoolAddr common.Address, pubkey rptypes.ValidatorPubkey, block uint64) {
+	for addr, recent := range e.recentMinipools {
+		if recent.block+maxReorgDepthBlocks < block {
+			delete(e.recentMinipools, addr)
+		}
+	}
+
+	e.recentMinipools[minipoolAddr] = recentMinipool{pubkey: pubkey, block: block}
+}
+
+func (e *ExecutionLayer) handleMinipoolDestroyedEvent(event types.Log) {
+	nodeAddr := common.BytesToAddress(event.Topics[2].Bytes())
+	minipoolAddr := common.BytesToAddress(event.Topics[1].Bytes())
+
+	// If it was launched so recently it hasn't been added to the index yet, it never will be
+	if e.cancelLookup(minipoolPubkeyLookup, minipoolAddr) {
+		e.m.Counter("minipool_destroyed_received").Inc()
+		e.logger.Debug("Destroyed minipool before it was added", zap.String("minipool", minipoolAddr.String()), zap.String("node", nodeAddr.String()))
+		return
+	}
+
+	pubkey, err := e.destroyedMinipoolPubkey(minipoolAddr, event.BlockNumber)
+	if err != nil {
+		e.logger.Warn("Error fetching minipool details for destroyed minipool", zap.String("minipool", minipoolAddr.String()), zap.Error(err))
+		return
+	}
+
+	// Remove it from the index, so its validator is no longer treated as a minipool
+	err = e.cache.removeMinipoolNode(pubkey)
+	if err != nil {
+		e.logger.Warn("Error updating minipool cache", zap.Error(err))
+	}
+	delete(e.recentMinipools, minipoolAddr)
+	e.m.Counter("minipool_destroyed_received").Inc()
+	e.logger.Debug("Removed destroyed minipool", zap.String("pubkey", pubkey.String()), zap.String("node", nodeAddr.String()))
+}
+
 func (e *ExecutionLayer) handleMinipoolEvent(event types.Log) {
 
-	// Make sure it's an event for the only topic we subscribed to, minipool launches
+	if bytes.Equal(event.Topics[0].Bytes(), e.minipoolDestroyedTopic.Bytes()) {
+		e.handleMinipoolDestroyedEvent(event)
+		return
+	}
+
+	// Otherwise, make sure it's a minipool launch
 	if !bytes.Equal(event.Topics[0].Bytes(), e.minipoolLaunchedTopic.Bytes()) {
 		e.logger.Warn("Event with unknown topic received", zap.String("string", event.Topics[0].String()))
 		return
@@ -183,23 +663,96 @@ func (e *ExecutionLayer) handleMinipoolEvent(event types.Log) {
 	nodeAddr := common.BytesToAddress(event.Topics[2].Bytes())
 
 	// Grab its minipool (contract) address and use that to find its public key
+	// New minipools are initialized, and any status events which follow in the same transaction update the lookup.
 	minipoolAddr := common.BytesToAddress(event.Topics[1].Bytes())
-	minipoolDetails, err := minipool.GetMinipoolDetails(e.rp, minipoolAddr, nil)
-	if err != nil {
-		e.logger.Warn("Error fetching minipool details for new minipools", zap.String("minipool", minipoolAddr.String()), zap.Error(err))
+	e.queueLookup(&chainLookup{
+		kind:     minipoolPubkeyLookup,
+		addr:     minipoolAddr,
+		nodeAddr: nodeAddr,
+		block:    event.BlockNumber,
+		status:   feerecipient.MinipoolStatusInitialized,
+	})
+}
+
+// isMinipoolStatusEvent returns true if event is a status update, which is assumed to come from a minipool
+// until handleMinipoolStatusEvent finds out otherwise
+func (e *ExecutionLayer) isMinipoolStatusEvent(event types.Log) bool {
+	return len(event.Topics) >= 2 && bytes.Equal(event.Topics[0].Bytes(), e.minipoolStatusUpdatedTopic.Bytes())
+}
+
+// storeMinipoolStatus updates the status of the minipool at minipoolAddr, if it's one we know. Returns false if it isn't.
+func (e *ExecutionLayer) storeMinipoolStatus(minipoolAddr common.Address, status feerecipient.MinipoolStatus) bool {
+	pubkey, err := e.cache.getMinipoolPubkey(minipoolAddr)
+	if err == nil {
+		err = e.cache.setMinipoolStatus(pubkey, minipoolAddr, status)
+		if err != nil {
+			e.logger.Error("Error updating minipool cache", zap.Error(err))
+		}
+		return true
+	}
+	if _, ok := err.(*NotFoundError); !ok {
+		e.logger.Error("Error looking up minipool in cache", zap.String("minipool", minipoolAddr.String()), zap.Error(err))
+		return true
+	}
+
+	// A minipool created so recently its pubkey is still being looked up has its status set once it's added
+	if lookup, ok := e.pendingLookups[lookupKey{minipoolPubkeyLookup, minipoolAddr}]; ok {
+		lookup.status = status
+		return true
+	}
+
+	return false
+}
+
+func (e *ExecutionLayer) handleMinipoolStatusEvent(event types.Log) {
+	minipoolAddr := event.Address
+	status := toMinipoolStatus(rptypes.MinipoolStatus(event.Topics[1].Big().Uint64()))
+
+	if !e.storeMinipoolStatus(minipoolAddr, status) {
+		// Most likely another contract with an event of the same signature
+		e.m.Counter("unknown_contract_event_ignored").Inc()
+		e.logger.Debug("Ignoring status update from unknown minipool", zap.String("minipool", minipoolAddr.String()))
 		return
 	}
 
-	// Finally, update the minipool index
-	err = e.cache.addMinipoolNode(minipoolDetails.Pubkey, nodeAddr)
-	if err != nil {
-		e.logger.Warn("Error updating minipool cache", zap.Error(err))
+	e.m.CounterVec("minipool_status_updated", "status").WithLabelValues(status.String()).Inc()
+	e.logger.Debug("Minipool status updated", zap.String("minipool", minipoolAddr.String()), zap.Stringer("status", status))
+}
+
+// revertMinipoolStatusEvent undoes a minipool status update that was reorged out
+func (e *ExecutionLayer) revertMinipoolStatusEvent(event types.Log) {
+	minipoolAddr := event.Address
+
+	// We can't know which of several updates were reverted, so re-read the status from before the event's block.
+	// If the replacement chain includes the update, it will be redelivered.
+	opts := &bind.CallOpts{}
+	if event.BlockNumber > 0 {
+		opts.BlockNumber = new(big.Int).SetUint64(event.BlockNumber - 1)
+	}
+	status := e.readMinipoolStatus(minipoolAddr, opts)
+
+	if !e.storeMinipoolStatus(minipoolAddr, status) {
+		e.logger.Debug("Ignoring reorged status update from unknown minipool", zap.String("minipool", minipoolAddr.String()))
+		return
 	}
-	e.m.Counter("minipool_launch_received").Inc()
-	e.logger.Debug("Added new minipool", zap.String("pubkey", minipoolDetails.Pubkey.String()), zap.String("node", nodeAddr.String()))
+
+	e.m.Counter("minipool_status_reverted").Inc()
+	e.logger.Warn("Minipool status update reorged out", zap.String("minipool", minipoolAddr.String()), zap.Stringer("status", status))
 }
 
 func (e *ExecutionLayer) handleEvent(event types.Log) {
+	// The EC redelivers logs with Removed set when they are reorged out
+	if event.Removed {
+		e.rewindEventCursor(event)
+		e.handleRemovedEvent(event)
+		return
+	}
+
+	// Logs from blocks we already backfilled can be delivered again by the subscription
+	if !e.advanceEventCursor(event) {
+		return
+	}
+
 	// events from the rocketNodeManager contract
 	e.m.Counter("subscription_event_received").Inc()
 	if bytes.Equal(e.rocketNodeManager.Address[:], event.Address[:]) {
@@ -213,13 +766,39 @@ func (e *ExecutionLayer) handleEvent(event types.Log) {
 		goto out
 	}
 
-	// Shouldn't ever happen, barring a bug in ethclient
+	// events from minipools
+	if e.isMinipoolStatusEvent(event) {
+		e.handleMinipoolStatusEvent(event)
+		goto out
+	}
+
 	e.logger.Warn("Received event for unknown contract", zap.String("address", event.Address.String()))
 out:
 	// We should always update highestBlock when we receive any event
 	e.cache.setHighestBlock(big.NewInt(int64(event.BlockNumber)))
 }
 
+func (e *ExecutionLayer) handleRemovedEvent(event types.Log) {
+	e.m.Counter("subscription_event_removed").Inc()
+
+	if bytes.Equal(e.rocketNodeManager.Address[:], event.Address[:]) {
+		e.revertNodeEvent(event)
+		return
+	}
+
+	if bytes.Equal(e.rocketMinipoolManager.Address[:], event.Address[:]) {
+		e.revertMinipoolEvent(event)
+		return
+	}
+
+	if e.isMinipoolStatusEvent(event) {
+		e.revertMinipoolStatusEvent(event)
+		return
+	}
+
+	e.logger.Warn("Received reorged event for unknown contract", zap.String("address", event.Address.String()))
+}
+
 // Gets the current block and loads any events we missed between highestBlock and the current one
 // If we get disconnected from the EC, we may need to backfill.
 // Additionally, we do some slow work on startup which takes 8-9 blocks, so we do that work,
@@ -227,130 +806,355 @@ out:
 //
 // All of this is only necessary because SubscribeFilterLogs doesn't seem to send old events, no matter
 // what FromBlock is set to.
+//
+// Long gaps are backfilled BackfillChunkSize blocks at a time, since ECs and hosted providers reject
+// FilterLogs calls which span too many blocks or return too many results. highestBlock is advanced
+// after each chunk, so a failed backfill resumes from the last completed chunk.
+//
+// Since the subscription is created first, it may deliver logs the backfill already applied.
+// handleEvent skips those, using the same per-contract cursor for both. See advanceEventCursor.
 func (e *ExecutionLayer) backfillEvents() error {
 	// Since highestBlock was the highest processed block, start one block after
-	start := big.NewInt(0).Add(e.cache.getHighestBlock(), big.NewInt(1))
+	return e.backfillEventsFrom(e.cache.getHighestBlock().Uint64() + 1)
+}
+
+// backfillEventsFrom loads any events between start and the current block.
+func (e *ExecutionLayer) backfillEventsFrom(start uint64) error {
 
 	// Get current block
 	header, err := e.client.HeaderByNumber(context.Background(), nil)
 	if err != nil {
 		return err
 	}
-	stop := header.Number
+	// The current block is actually the last block processed by the EC, so play any events from it as well
+	stop := header.Number.Uint64()
 
 	// Make sure there is actually a gap before backfilling
-	if stop.Cmp(start) < 0 {
+	if stop < start {
 		e.logger.Debug("No blocks to backfill events from")
 		return nil
 	}
 
-	missedEvents, err := e.client.FilterLogs(context.Background(), ethereum.FilterQuery{
-		// We only want events for 2 contracts
-		Addresses: []common.Address{*e.rocketMinipoolManager.Address, *e.rocketNodeManager.Address},
-		FromBlock: start,
-		// The current block is actually the last block processed by the EC, so play any events from it as well
-		// The range is inclusive
-		ToBlock: stop,
-		// And we only care about 3 event types
-		Topics: [][]common.Hash{[]common.Hash{e.nodeRegisteredTopic, e.smoothingPoolStatusChangedTopic, e.minipoolLaunchedTopic}},
-	})
-
-	if err != nil {
-		return err
+	chunkSize := e.BackfillChunkSize
+	if chunkSize == 0 {
+		chunkSize = defaultBackfillChunkSize
 	}
 
-	for _, event := range missedEvents {
-		e.handleEvent(event)
-		e.m.Counter("backfill_events").Inc()
-	}
+	eventCount := 0
+	for chunkStart := start; chunkStart <= stop; chunkStart += chunkSize {
+		// The range is inclusive
+		chunkStop := chunkStart + chunkSize - 1
+		if chunkStop > stop {
+			chunkStop = stop
+		}
+
+		// Use the subscriptions' queries, which have the contracts and event types we care about
+		missedEvents, err := filterLogs(context.Background(), e.client, e.queries(), chunkStart, chunkStop)
+		if err != nil {
+			e.logger.Warn("Error backfilling events",
+				zap.Uint64("start", chunkStart), zap.Uint64("stop", chunkStop), zap.Error(err))
+			return err
+		}
 
-	// Force the highest block to update, as we may not have received any events in it, which would have updated it
-	e.cache.setHighestBlock(stop)
+		for _, event := range missedEvents {
+			e.handleEvent(event)
+			e.m.Counter("backfill_events").Inc()
+		}
+		eventCount += len(missedEvents)
 
-	delta := big.NewInt(0).Sub(stop, start)
+		// Force the highest block to update, as we may not have received any events in it, which would have updated it
+		e.cache.setHighestBlock(big.NewInt(0).SetUint64(chunkStop))
+		e.m.Counter("backfill_blocks").Add(float64(chunkStop - chunkStart + 1))
+		e.m.Counter("backfill_chunks").Inc()
+	}
 
 	// If start == stop we actually fill that one block, so add one to delta
-	delta = delta.Add(delta, big.NewInt(1))
-	e.m.Counter("backfill_blocks").Add(float64(delta.Uint64()))
+	delta := stop - start + 1
 
-	e.logger.Debug("Backfilled events", zap.Int("events", len(missedEvents)),
-		zap.Uint64("blocks", delta.Uint64()),
-		zap.Int64("start", start.Int64()), zap.Int64("stop", stop.Int64()))
+	e.logger.Debug("Backfilled events", zap.Int("events", eventCount),
+		zap.Uint64("blocks", delta),
+		zap.Uint64("start", start), zap.Uint64("stop", stop))
 	return nil
 }
 
-// Will likely attempt to reconnect, and will overwrite the pointers passed with the new subscription objects
-func (e *ExecutionLayer) handleSubscriptionError(err error, logEventSub **ethereum.Subscription, headerSub **ethereum.Subscription) {
-	if e.shutdown {
-		return
-	}
+// backfillWithRetry calls backfill until it succeeds, waiting longer between each attempt.
+// Requests are still served from the existing cache in the meantime. If the backfill hasn't
+// succeeded within BackfillRetryWindow, the cache is reported as stale until it does.
+func (e *ExecutionLayer) backfillWithRetry(ctx context.Context, backfill func() error) {
+	deadline := time.Now().Add(e.BackfillRetryWindow)
 
-	e.m.Counter("subscription_disconnected").Inc()
-	e.logger.Warn("Error received from eth client subscription", zap.Error(err))
-	// Attempt to reconnect `reconnectRetries` times with steadily increasing waits
-	for i := 0; i < reconnectRetries; i++ {
-		e.logger.Warn("Attempting to reconnect", zap.Int("attempt", i+1))
-		e.m.Counter("reconnection_attempt").Inc()
-		s, err := e.client.SubscribeFilterLogs(context.Background(), e.query, e.events)
+	for attempt := 1; ctx.Err() == nil; attempt++ {
+		err := backfill()
 		if err == nil {
-			e.logger.Warn("Reconnected", zap.Int("attempt", i+1))
-
-			// Resubscribe to new headers - no retries
-			h, err := e.client.SubscribeNewHead(context.Background(), e.newHeaders)
-			if err != nil {
-				e.logger.Warn("Couldn't resubscribe to block headers after reconnecting")
-				break
+			if e.backfillFailed.Swap(false) {
+				e.logger.Warn("Backfill succeeded, cache is no longer stale", zap.Int("attempts", attempt))
 			}
+			return
+		}
 
-			e.setECShutdownCb(func() {
-				s.Unsubscribe()
-				h.Unsubscribe()
-			})
-
-			// Now that we've reconnected, we need to backfill
-			err = e.backfillEvents()
-			if err != nil {
-				// Failed to backfill
-				e.logger.Panic("Couldn't backfill blocks after reconnecting to execution client")
-			}
+		if time.Now().After(deadline) && !e.backfillFailed.Swap(true) {
+			e.m.Counter("backfill_window_exceeded").Inc()
+			e.logger.Error("Couldn't backfill events within the retry window, reporting the cache as stale",
+				zap.Duration("window", e.BackfillRetryWindow), zap.Error(err))
+		}
 
-			*logEventSub = &s
-			*headerSub = &h
-			return
+		wait := time.Duration(attempt) * backfillRetryWait
+		if wait > maxBackfillRetryWait {
+			wait = maxBackfillRetryWait
 		}
 
-		e.logger.Warn("Error trying to reconnect to execution client", zap.Error(err))
-		time.Sleep(time.Duration(i) * (5 * time.Second))
+		e.m.Counter("backfill_retry").Inc()
+		e.logger.Warn("Error backfilling events, retrying", zap.Int("attempt", attempt), zap.Duration("wait", wait), zap.Error(err))
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
 	}
+}
+
+// subscriptions is the pair of EC subscriptions which feed the event loop
+type subscriptions struct {
+	logs    ethereum.Subscription
+	headers ethereum.Subscription
 
-	// Failed to reconnect after 10 tries
-	e.logger.Panic("Couldn't re-establish eth client connection")
+	// When polling, logs polls for both events and headers, and headers is idle
+	polling bool
 }
 
-// Registers to receive the events we care about
-func (e *ExecutionLayer) ecEventsConnect(opts *bind.CallOpts) error {
-	var err error
+func (s *subscriptions) unsubscribe() {
+	s.logs.Unsubscribe()
+	s.headers.Unsubscribe()
+}
 
-	e.nodeRegisteredTopic = crypto.Keccak256Hash([]byte("NodeRegistered(address,uint256)"))
-	e.smoothingPoolStatusChangedTopic = crypto.Keccak256Hash([]byte("NodeSmoothingPoolStateChanged(address,bool)"))
-	e.minipoolLaunchedTopic = crypto.Keccak256Hash([]byte("MinipoolCreated(address,address,uint256)"))
-	// Subscribe to events from rocketNodeManager and rocketMinipoolManager
-	e.query = ethereum.FilterQuery{
-		Addresses: []common.Address{*e.rocketMinipoolManager.Address, *e.rocketNodeManager.Address},
-		Topics:    [][]common.Hash{[]common.Hash{e.nodeRegisteredTopic, e.smoothingPoolStatusChangedTopic, e.minipoolLaunchedTopic}},
+// subscribeLogs subscribes to, or starts polling for, the events we care about
+func (e *ExecutionLayer) subscribeLogs(ctx context.Context, polling bool) (ethereum.Subscription, error) {
+	if polling {
+		return e.pollEvents(ctx)
 	}
 
-	// Set highestBlock to the cache's highestBlock, since it was either loaded or warmed up already
-	e.cache.setHighestBlock(opts.BlockNumber)
-
-	e.events = make(chan types.Log, 32)
-	sub, err := e.client.SubscribeFilterLogs(context.Background(), e.query, e.events)
+	queries := e.queries()
+	subs := make([]ethereum.Subscription, 0, len(queries))
+	for _, query := range queries {
+		sub, err := e.client.SubscribeFilterLogs(ctx, query, e.events)
+		if err != nil {
+			for _, sub := range subs {
+				sub.Unsubscribe()
+			}
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return newCombinedSubscription(subs), nil
+}
+
+// subscribe subscribes to the events we care about and to new headers.
+// If the EC can't serve subscriptions, they're polled for instead. See PollMode.
+func (e *ExecutionLayer) subscribe(ctx context.Context) (*subscriptions, error) {
+	polling := e.shouldPoll()
+	logs, err := e.subscribeLogs(ctx, polling)
+	if err != nil && !polling && e.PollMode == PollModeAuto && notificationsUnsupported(err) {
+		e.m.Counter("subscriptions_unsupported").Inc()
+		e.logger.Warn("Execution client doesn't support subscriptions, polling it for events instead", zap.Duration("interval", e.PollInterval))
+		polling = true
+		logs, err = e.subscribeLogs(ctx, polling)
+	}
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if polling {
+		return &subscriptions{logs: logs, headers: &idleSubscription{}, polling: true}, nil
+	}
+
+	headers, err := e.client.SubscribeNewHead(ctx, e.newHeaders)
+	if err != nil {
+		logs.Unsubscribe()
+		return nil, err
+	}
+
+	return &subscriptions{logs: logs, headers: headers}, nil
+}
+
+// handleSubscriptionError tears down the old subscriptions and reconnects, retrying with backoff
+// until it succeeds or ctx is cancelled. Once MaxReconnectAttempts have failed, the cache is reported as stale,
+// so guarded requests fail closed, until a reconnect succeeds. Once reconnected, it backfills any events that
+// were missed and returns the new subscriptions.
+func (e *ExecutionLayer) handleSubscriptionError(ctx context.Context, err error, subs *subscriptions) (*subscriptions, error) {
+	subs.unsubscribe()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	e.m.Counter("subscription_disconnected").Inc()
+	if errors.Is(err, rpc.ErrSubscriptionQueueOverflow) {
+		// ethclient dropped the subscription, and the events it was holding, because the event loop fell too far behind.
+		// They're recovered by the backfill once reconnected.
+		e.m.Counter("subscription_overflow").Inc()
+	}
+	e.logger.Warn("Error received from eth client subscription", zap.Error(err))
+	start := e.endpoint
+	for attempt := 0; ; attempt++ {
+		e.logger.Warn("Attempting to reconnect", zap.Int("attempt", attempt+1), zap.Int("endpoint", e.endpoint))
+		e.m.Counter("reconnection_attempt").Inc()
+		subs, err = e.subscribe(ctx)
+		if err == nil {
+			e.logger.Warn("Reconnected", zap.Int("attempt", attempt+1), zap.Int("endpoint", e.endpoint))
+			e.reconnectFailed.Store(false)
+
+			// Now that we've reconnected, we need to backfill.
+			// A fallback endpoint may be behind the highest block we've processed, in which case there's nothing to do.
+			e.backfillWithRetry(ctx, e.backfillEvents)
+			return subs, nil
+		}
+
+		// Move on to the next endpoint, if there is one. Only back off once every endpoint has been tried.
+		if len(e.ecURLs) > 1 {
+			e.logger.Warn("Error trying to reconnect to execution client endpoint", zap.Int("endpoint", e.endpoint), zap.Error(err))
+			rotateErr := e.rotateEndpoint(ctx)
+			if rotateErr == nil && e.endpoint != start {
+				continue
+			}
+			if rotateErr != nil {
+				err = rotateErr
+			}
+		}
+
+		if e.MaxReconnectAttempts > 0 && attempt+1 >= e.MaxReconnectAttempts && !e.reconnectFailed.Swap(true) {
+			e.m.Counter("reconnect_attempts_exhausted").Inc()
+			e.logger.Error("Couldn't re-establish eth client connection, reporting the cache as stale until it is",
+				zap.Int("attempts", e.MaxReconnectAttempts))
+		}
+
+		wait := reconnectBackoff.Wait(attempt)
+		e.logger.Warn("Error trying to reconnect to execution client", zap.Duration("wait", wait), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// checkContractUpgrades re-resolves the addresses of the contracts we subscribe to.
+// If the protocol has upgraded any of them, it resubscribes to the new contracts and backfills their events
+// since the last check. Returns the subscriptions the event loop should use from now on. If resubscribing fails,
+// it reconnects like handleSubscriptionError, and returns nil if ctx is cancelled before it has.
+func (e *ExecutionLayer) checkContractUpgrades(ctx context.Context, block *big.Int, subs *subscriptions) *subscriptions {
+	opts := &bind.CallOpts{BlockNumber: block}
+
+	rocketNodeManager, err := e.chain.contract("rocketNodeManager", opts)
+	if err != nil {
+		e.logger.Warn("Couldn't check rocketNodeManager for upgrades", zap.Error(err))
+		return subs
+	}
+
+	rocketMinipoolManager, err := e.chain.contract("rocketMinipoolManager", opts)
+	if err != nil {
+		e.logger.Warn("Couldn't check rocketMinipoolManager for upgrades", zap.Error(err))
+		return subs
+	}
+
+	// Events from the new contracts may have been emitted any time since the last check
+	since := e.contractsCheckedBlock + 1
+	e.contractsCheckedBlock = block.Uint64()
+	e.checkAllowlistUpgrades(opts)
+
+	if *rocketNodeManager.Address == *e.rocketNodeManager.Address &&
+		*rocketMinipoolManager.Address == *e.rocketMinipoolManager.Address {
+		return subs
+	}
+
+	e.m.Counter("contract_upgrade_detected").Inc()
+	e.logger.Warn("Contract upgrade detected, resubscribing",
+		zap.String("old rocketNodeManager", e.rocketNodeManager.Address.String()),
+		zap.String("new rocketNodeManager", rocketNodeManager.Address.String()),
+		zap.String("old rocketMinipoolManager", e.rocketMinipoolManager.Address.String()),
+		zap.String("new rocketMinipoolManager", rocketMinipoolManager.Address.String()),
+		zap.Uint64("since", since))
+
+	e.rocketNodeManager = rocketNodeManager
+	e.rocketMinipoolManager = rocketMinipoolManager
+	e.updateQuery()
+
+	// Swap the log subscription for one with the new query
+	subs.logs.Unsubscribe()
+	logs, err := e.subscribeLogs(ctx, subs.polling)
+	if err == nil {
+		subs = &subscriptions{logs: logs, headers: subs.headers, polling: subs.polling}
+	} else {
+		// Reconnect as if the subscriptions had failed, which resubscribes with the new query
+		e.m.Counter("contract_upgrade_resubscribe_failed").Inc()
+		subs, err = e.handleSubscriptionError(ctx, fmt.Errorf("couldn't resubscribe to events after a contract upgrade: %w", err), subs)
+		if err != nil {
+			return nil
+		}
 	}
 
-	e.newHeaders = make(chan *types.Header, 32)
-	newHeadSub, err := e.client.SubscribeNewHead(context.Background(), e.newHeaders)
+	e.backfillWithRetry(ctx, func() error {
+		return e.backfillEventsFrom(since)
+	})
+	return subs
+}
+
+// updateQuery sets the FilterQueries for the events we care about from the current contracts
+func (e *ExecutionLayer) updateQuery() {
+	// Subscribe to events from rocketNodeManager and rocketMinipoolManager
+	e.query = ethereum.FilterQuery{
+		Addresses: []common.Address{*e.rocketMinipoolManager.Address, *e.rocketNodeManager.Address},
+		Topics:    [][]common.Hash{[]common.Hash{e.nodeRegisteredTopic, e.smoothingPoolStatusChangedTopic, e.minipoolLaunchedTopic, e.minipoolDestroyedTopic}},
+	}
+
+	// Minipools emit their own status updates, and are too many to list, so those have a query of their own which
+	// can't filter by address. handleMinipoolStatusEvent ignores other contracts' events with the same signature.
+	e.statusQuery = ethereum.FilterQuery{
+		Topics: [][]common.Hash{[]common.Hash{e.minipoolStatusUpdatedTopic}},
+	}
+}
+
+// queries returns the FilterQueries for the events we care about
+func (e *ExecutionLayer) queries() []ethereum.FilterQuery {
+	return []ethereum.FilterQuery{e.query, e.statusQuery}
+}
+
+// filterLogs returns the logs matching any of queries from the inclusive range of blocks, in the order they were emitted
+func filterLogs(ctx context.Context, client ecClient, queries []ethereum.FilterQuery, from uint64, to uint64) ([]types.Log, error) {
+	var out []types.Log
+	for _, query := range queries {
+		query.FromBlock = big.NewInt(0).SetUint64(from)
+		query.ToBlock = big.NewInt(0).SetUint64(to)
+		logs, err := client.FilterLogs(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, logs...)
+	}
+
+	// Interleave the queries' logs, so a minipool's status updates are applied after the event which created it
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].BlockNumber != out[j].BlockNumber {
+			return out[i].BlockNumber < out[j].BlockNumber
+		}
+		return out[i].Index < out[j].Index
+	})
+	return out, nil
+}
+
+// Registers to receive the events we care about
+func (e *ExecutionLayer) ecEventsConnect(opts *bind.CallOpts) error {
+	var err error
+
+	e.updateQuery()
+
+	// Set highestBlock to the cache's highestBlock, since it was either loaded or warmed up already
+	e.cache.setHighestBlock(opts.BlockNumber)
+
+	bufferSize := e.SubscriptionBufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultSubscriptionBufferSize
+	}
+	e.events = make(chan types.Log, bufferSize)
+	e.newHeaders = make(chan *types.Header, bufferSize)
+	subs, err := e.subscribe(e.ctx)
 	if err != nil {
 		return err
 	}
@@ -361,70 +1165,551 @@ func (e *ExecutionLayer) ecEventsConnect(opts *bind.CallOpts) error {
 	// While we were building the cache from cold, we may have missed some events.
 	err = e.backfillEvents()
 	if err != nil {
+		subs.unsubscribe()
 		return err
 	}
 
-	// Make sure we can unsubscribe on shutdown
-	e.setECShutdownCb(func() {
-		sub.Unsubscribe()
-		newHeadSub.Unsubscribe()
-	})
+	// Fetch the head before returning, so Stale() is accurate as soon as Init() is done
+	e.refreshHeadOnce(e.ctx)
 
 	// Start listening for events in a separate routine
-	go func(logSubscription *ethereum.Subscription, newHeadSubscription *ethereum.Subscription) {
-		var noMoreEvents bool
-		var noMoreHeaders bool
+	e.startEventLoop(subs)
+
+	return nil
+}
+
+// startEventLoop runs the event loop and its background jobs until Deinit() is called
+func (e *ExecutionLayer) startEventLoop(subs *subscriptions) {
+	e.startLookups()
+
+	// Add before starting the goroutine, so Deinit() can't miss it
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.eventLoop(e.ctx, subs)
+	}()
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.cacheMetricsLoop(e.ctx)
+	}()
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.headRefreshLoop(e.ctx)
+	}()
+
+	if e.ReconcileInterval > 0 {
 		e.wg.Add(1)
-		for {
+		go func() {
+			defer e.wg.Done()
+			e.reconcileLoop(e.ctx)
+		}()
+	}
+
+	if e.FeeDistributorCheckInterval > 0 {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			e.feeDistributorCheckLoop(e.ctx)
+		}()
+	}
+}
 
+// eventLoop processes events and headers until ctx is cancelled.
+// It then closes the subscriptions, and processes any events they already delivered.
+//
+// If HeaderTimeout passes without a new header, the subscriptions are assumed to have silently died,
+// and are torn down and reconnected as if they had returned an error.
+func (e *ExecutionLayer) eventLoop(ctx context.Context, subs *subscriptions) {
+	lastHeader := time.Now()
+
+	var heartbeat <-chan time.Time
+	if e.HeaderTimeout > 0 {
+		ticker := time.NewTicker(e.HeaderTimeout / 4)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
+	for {
+		// After a cancelled reconnect there are no subscriptions, and receiving from nil channels blocks forever
+		var logErrs, headerErrs <-chan error
+		if subs != nil {
+			logErrs = subs.logs.Err()
+			headerErrs = subs.headers.Err()
+		}
+
+		select {
+		case <-ctx.Done():
+			// Once Unsubscribe() returns, ethclient won't send on the channels anymore
+			if subs != nil {
+				subs.unsubscribe()
+				e.logger.Debug("Unsubscribed from EL events")
+			}
+
+			e.drainEvents()
+			e.finishLookups()
+			e.logger.Debug("Finished processing events", zap.Int64("height", e.cache.getHighestBlock().Int64()))
+			return
+		case err := <-logErrs:
+			subs, _ = e.handleSubscriptionError(ctx, err, subs)
+			lastHeader = time.Now()
+		case err := <-headerErrs:
+			subs, _ = e.handleSubscriptionError(ctx, err, subs)
+			lastHeader = time.Now()
+		case <-heartbeat:
+			since := time.Since(lastHeader)
+			e.m.Gauge("seconds_since_last_header").Set(since.Seconds())
+			if subs != nil && since >= e.HeaderTimeout {
+				e.m.Counter("header_timeout").Inc()
+				subs, _ = e.handleSubscriptionError(ctx, fmt.Errorf("no new headers received for %v", since), subs)
+				lastHeader = time.Now()
+			}
+		case event := <-e.events:
+			e.m.Gauge("event_queue_depth").Set(float64(len(e.events)))
+			if e.headerBlock > event.BlockNumber {
+				e.m.Gauge("event_lag_blocks").Set(float64(e.headerBlock - event.BlockNumber))
+			} else {
+				e.m.Gauge("event_lag_blocks").Set(0)
+			}
+			e.handleEvent(event)
+		case result := <-e.lookupResults:
+			e.lookupsDone(result)
+		case lookup := <-e.lookupRetries:
+			e.retryLookup(lookup)
+		case <-e.minipoolBatchDue:
+			e.flushMinipoolLookups()
+		case snapshots := <-e.reconcileRequests:
+			snapshot, err := e.takeReconcileSnapshot()
+			if err != nil {
+				e.logger.Warn("Couldn't snapshot the cache for reconciliation", zap.Error(err))
+			}
+			snapshots <- snapshot
+		case result := <-e.reconcileResults:
+			e.applyReconciliation(result)
+		case repair := <-e.feeDistributorRepairs:
+			e.applyFeeDistributorRepair(repair)
+		case newHeader := <-e.newHeaders:
+			e.m.Gauge("header_queue_depth").Set(float64(len(e.newHeaders)))
+			e.handleHeader(newHeader)
+			lastHeader = time.Now()
+
+			if newHeader.Number.Uint64() >= e.lookupsSweptBlock+lookupSweepIntervalBlocks {
+				e.lookupsSweptBlock = newHeader.Number.Uint64()
+				e.sweepLookups()
+			}
+
+			// Periodically make sure we're still subscribed to the current contracts
+			if subs != nil && newHeader.Number.Uint64() >= e.contractsCheckedBlock+contractCheckIntervalBlocks {
+				subs = e.checkContractUpgrades(ctx, newHeader.Number, subs)
+			}
+		}
+	}
+}
+
+// drainEvents processes events and headers that were delivered but not yet processed
+func (e *ExecutionLayer) drainEvents() {
+	for {
+		select {
+		case event := <-e.events:
+			e.handleEvent(event)
+		case newHeader := <-e.newHeaders:
+			e.handleHeader(newHeader)
+		default:
+			return
+		}
+	}
+}
+
+func (e *ExecutionLayer) handleHeader(newHeader *types.Header) {
+	// Just advance highest block
+	e.m.Counter("block_header_received").Inc()
+	e.logger.Debug("New block received",
+		zap.Int64("new height", newHeader.Number.Int64()),
+		zap.Int64("old height", e.cache.getHighestBlock().Int64()))
+	e.cache.setHighestBlock(newHeader.Number)
+	e.headerBlock = newHeader.Number.Uint64()
+}
+
+// preloadedNode holds everything the preload reads about a single node
+type preloadedNode struct {
+	addr      common.Address
+	info      *nodeInfo
+	minipools []nodeMinipool
+	// The withdrawal address and RPL stake. Bonds are read in batches once every node has been, see readBonds.
+	enrichment *nodeEnrichment
+	// The status of each minipool, by index, or nil if they're to be read in batches
+	statuses []feerecipient.MinipoolStatus
+	// How many of the statuses couldn't be read
+	unknownStatuses int
+}
+
+// preloadNode reads a single node's state at opts.BlockNumber.
+// If prefetched is not nil, its smoothing pool status and fee distributor are used instead of reading them.
+func (e *ExecutionLayer) preloadNode(addr common.Address, prefetched *nodeInfo, opts *bind.CallOpts) (*preloadedNode, error) {
+	var err error
+
+	out := &preloadedNode{addr: addr, info: &nodeInfo{}}
+
+	if prefetched != nil {
+		out.info.inSmoothingPool = prefetched.inSmoothingPool
+		out.info.feeDistributor = prefetched.feeDistributor
+	} else {
+		// Determine their smoothing pool status
+		out.info.inSmoothingPool, err = e.chain.smoothingPoolStatus(addr, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		// Get their fee distributor address
+		out.info.feeDistributor, err = e.chain.feeDistributor(addr, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Get the enrichment fields, which never fail
+	out.enrichment = readNodeEnrichment(e.chain, addr, opts)
+
+	// Also grab their minipools
+	out.minipools, err = e.chain.nodeMinipools(addr, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// And their statuses, which are enrichment reads, since fee recipients are enforced without them.
+	// With Multicall3, they're read in batches once every node has been, instead. See preloadMinipoolStatuses.
+	if e.multicall != nil {
+		return out, nil
+	}
+	out.statuses = make([]feerecipient.MinipoolStatus, len(out.minipools))
+	for i, mp := range out.minipools {
+		out.statuses[i] = e.readMinipoolStatus(mp.addr, opts)
+		if out.statuses[i] == feerecipient.MinipoolStatusUnknown {
+			out.unknownStatuses++
+		}
+	}
+
+	return out, nil
+}
+
+// preload warms up the cache from cold with the state at opts.BlockNumber.
+// Only failures of essential reads abort the preload- enrichment fields that can't be read
+// are marked unknown and summarized at the end.
+//
+// Nodes are read by PreloadConcurrency workers, and their results are stored in the cache
+// from the calling goroutine. The first error cancels any outstanding reads.
+func (e *ExecutionLayer) preload(opts *bind.CallOpts) error {
+	start := time.Now()
+
+	// Get all nodes at the given block
+	nodes, err := e.chain.nodeAddresses(opts)
+	if err != nil {
+		return err
+	}
+	e.logger.Debug("Found nodes to preload", zap.Int("count", len(nodes)), zap.Int64("block", opts.BlockNumber.Int64()))
+
+	// Batch the essential reads if we can, falling back to reading them per node
+	prefetched := make(map[common.Address]*nodeInfo, len(nodes))
+	if e.multicall != nil {
+		infos, err := e.multicall.nodeInfos(nodes, opts)
+		if err != nil {
+			e.m.Counter("preload_multicall_failed").Inc()
+			e.logger.Warn("Couldn't batch node reads with multicall, reading nodes individually", zap.Error(err))
+		} else {
+			for i, addr := range nodes {
+				prefetched[addr] = infos[i]
+			}
+		}
+	}
+
+	concurrency := e.PreloadConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultPreloadConcurrency
+	}
+
+	parent := opts.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	g, ctx := errgroup.WithContext(ctx)
+
+	// Workers share a copy of opts, so every read stays pinned to the same block
+	workerOpts := *opts
+	workerOpts.Context = ctx
+
+	work := make(chan common.Address)
+	results := make(chan *preloadedNode, concurrency)
+
+	g.Go(func() error {
+		defer close(work)
+		for _, addr := range nodes {
 			select {
-			case err := <-(*logSubscription).Err():
-				(*newHeadSubscription).Unsubscribe()
-				e.handleSubscriptionError(err, &logSubscription, &newHeadSubscription)
-				break
-			case err := <-(*newHeadSubscription).Err():
-				(*logSubscription).Unsubscribe()
-				e.handleSubscriptionError(err, &logSubscription, &newHeadSubscription)
-				break
-			case event, ok := <-e.events:
-				noMoreEvents = !ok
-				if noMoreEvents {
-					break
+			case work <- addr:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+
+	for i := 0; i < concurrency; i++ {
+		g.Go(func() error {
+			for addr := range work {
+				result, err := e.preloadNode(addr, prefetched[addr], &workerOpts)
+				if err != nil {
+					return err
 				}
-				e.handleEvent(event)
-			case newHeader, ok := <-e.newHeaders:
-				noMoreHeaders = !ok
-				if noMoreHeaders {
-					break
+
+				select {
+				case results <- result:
+				case <-ctx.Done():
+					return ctx.Err()
 				}
+			}
+			return nil
+		})
+	}
 
-				// Just advance highest block
-				e.m.Counter("block_header_received").Inc()
-				e.logger.Debug("New block received",
-					zap.Int64("new height", newHeader.Number.Int64()),
-					zap.Int64("old height", e.cache.getHighestBlock().Int64()))
-				e.cache.setHighestBlock(newHeader.Number)
+	// Close results once the workers are done, so the loop below terminates
+	var workerErr error
+	go func() {
+		workerErr = g.Wait()
+		close(results)
+	}()
 
-				// Continue here to check for new events
-				continue
-			}
+	var storeErr error
+	minipoolCount := 0
+	unknownStatuses := 0
+	// Minipools whose statuses are left to be read in batches
+	var unread []nodeMinipool
+	// Nodes whose bonds are left to be read in batches
+	var preloaded []*preloadedNode
+	for result := range results {
+		if storeErr != nil {
+			// Drain the channel while the workers shut down
+			continue
+		}
+
+		// The enrichment fields are stored with the bonds, once every node's are read below
+		result.info.unknownFields = enrichmentFields
+		preloaded = append(preloaded, result)
 
-			// If we didn't process any events in the select and the channels are closed,
-			// no new events will come, so break the loop
-			if noMoreEvents && noMoreHeaders {
-				e.logger.Debug("Finished processing events", zap.Int64("height", e.cache.getHighestBlock().Int64()))
+		// Store the smoothing pool state / fee distributor in the node index
+		storeErr = e.cache.addNodeInfo(result.addr, result.info)
+		if storeErr != nil {
+			cancel()
+			continue
+		}
+
+		minipoolCount += len(result.minipools)
+		unknownStatuses += result.unknownStatuses
+		if result.statuses == nil {
+			unread = append(unread, result.minipools...)
+		}
+		for i, mp := range result.minipools {
+			storeErr = e.cache.addMinipoolNode(mp.pubkey, result.addr)
+			if storeErr == nil && result.statuses != nil {
+				storeErr = e.cache.setMinipoolStatus(mp.pubkey, mp.addr, result.statuses[i])
+			}
+			if storeErr != nil {
+				cancel()
 				break
 			}
+		}
+	}
+
+	if storeErr != nil {
+		return storeErr
+	}
+	if workerErr != nil {
+		return workerErr
+	}
 
+	for i, status := range e.preloadMinipoolStatuses(unread, opts) {
+		if status == feerecipient.MinipoolStatusUnknown {
+			unknownStatuses++
+		}
+		if err := e.cache.setMinipoolStatus(unread[i].pubkey, unread[i].addr, status); err != nil {
+			return err
 		}
-		e.wg.Done()
-	}(&sub, &newHeadSub)
+	}
 
-	return nil
+	degraded, err := e.preloadBonds(preloaded, opts)
+	if err != nil {
+		return err
+	}
+
+	elapsed := time.Since(start)
+	e.m.Gauge("preload_duration_seconds").Set(elapsed.Seconds())
+	e.logger.Info("Pre-loaded nodes and minipools",
+		zap.Int("nodes", len(nodes)),
+		zap.Int("minipools", minipoolCount),
+		zap.Int("concurrency", concurrency),
+		zap.Duration("duration", elapsed))
+
+	// Summarize the degraded fields, if any
+	fields := make([]zap.Field, 0, len(nodeFieldNames)+1)
+	for _, n := range nodeFieldNames {
+		e.m.Gauge("preload_degraded_" + n.name).Set(float64(degraded[n.field]))
+		if degraded[n.field] > 0 {
+			fields = append(fields, zap.Int(n.name, degraded[n.field]))
+		}
+	}
+	e.m.Gauge("preload_degraded_minipool_status").Set(float64(unknownStatuses))
+	if unknownStatuses > 0 {
+		fields = append(fields, zap.Int("minipool_status", unknownStatuses))
+	}
+	if len(fields) > 0 {
+		e.logger.Warn("Pre-loaded with degraded fields. Fee recipient enforcement is unaffected, but some node data is unknown", fields...)
+	}
+
+	return e.recountNodes()
+}
+
+// preloadBonds reads the preloaded nodes' bonds through Multicall3, stores their enrichment fields, and tallies
+// the fields which couldn't be read. Without Multicall3, bonds are left unknown.
+func (e *ExecutionLayer) preloadBonds(nodes []*preloadedNode, opts *bind.CallOpts) (map[NodeField]int, error) {
+	minipools := make([][]common.Address, 0, len(nodes))
+	for _, node := range nodes {
+		addrs := make([]common.Address, 0, len(node.minipools))
+		for _, mp := range node.minipools {
+			addrs = append(addrs, mp.addr)
+		}
+		minipools = append(minipools, addrs)
+	}
+
+	bonds, bondErr := readBonds(e.multicall, minipools, opts)
+	if errors.Is(bondErr, errBondsNeedMulticall) {
+		e.logger.Info("Multicall3 isn't available, so node bonds are unknown")
+	} else if bondErr != nil {
+		e.m.Counter("preload_multicall_failed").Inc()
+		e.logger.Warn("Couldn't batch bond reads with multicall, node bonds are unknown", zap.Error(bondErr))
+	}
+
+	degraded := make(map[NodeField]int)
+	for i, node := range nodes {
+		node.enrichment.setBond(bonds[i], bondErr)
+
+		// The cached nodeInfo may be read concurrently, so update a copy
+		info := node.info.clone()
+		e.setEnrichment(node.addr, info, node.enrichment)
+		if err := e.cache.addNodeInfo(node.addr, info); err != nil {
+			return nil, err
+		}
+
+		// Tally any enrichment fields that couldn't be read
+		for _, n := range nodeFieldNames {
+			if info.unknownFields&n.field != 0 {
+				degraded[n.field]++
+			}
+		}
+	}
+
+	return degraded, nil
+}
+
+// preloadMinipoolStatuses reads the statuses of minipools through Multicall3, batching them like the preload's
+// node reads. If the multicall fails, they're read individually instead.
+func (e *ExecutionLayer) preloadMinipoolStatuses(minipools []nodeMinipool, opts *bind.CallOpts) []feerecipient.MinipoolStatus {
+	if len(minipools) == 0 {
+		return nil
+	}
+
+	addrs := make([]common.Address, 0, len(minipools))
+	for _, mp := range minipools {
+		addrs = append(addrs, mp.addr)
+	}
+	statuses, err := e.multicall.minipoolStatuses(addrs, opts)
+	if err == nil {
+		return statuses
+	}
+
+	e.m.Counter("preload_multicall_failed").Inc()
+	e.logger.Warn("Couldn't batch minipool status reads with multicall, reading them individually", zap.Error(err))
+	statuses = make([]feerecipient.MinipoolStatus, 0, len(addrs))
+	for _, addr := range addrs {
+		statuses = append(statuses, e.readMinipoolStatus(addr, opts))
+	}
+	return statuses
+}
+
+// setupMulticall enables batched preload reads if Multicall3 is deployed at MulticallAddr
+func (e *ExecutionLayer) setupMulticall(opts *bind.CallOpts) error {
+	if e.MulticallAddr == "" {
+		return nil
+	}
+
+	mc, err := newMulticaller(common.HexToAddress(e.MulticallAddr), e.client)
+	if err != nil {
+		return err
+	}
+
+	deployed, err := mc.deployed(opts)
+	if err != nil {
+		return err
+	}
+
+	if !deployed {
+		e.logger.Warn("Multicall3 is not deployed, nodes will be preloaded individually", zap.String("address", e.MulticallAddr))
+		return nil
+	}
+
+	rocketNodeDistributorFactory, err := e.rp.GetContract("rocketNodeDistributorFactory", opts)
+	if err != nil {
+		return err
+	}
+
+	e.multicall, err = newMulticallNodeReader(mc, e.rocketNodeManager, rocketNodeDistributorFactory)
+	return err
+}
+
+// preloadBlock returns the block to warm up the cache at, given the EC's head
+func (e *ExecutionLayer) preloadBlock(head *big.Int) (*big.Int, error) {
+	if e.PreloadBlock == 0 {
+		return head, nil
+	}
+
+	if e.PreloadBlock > head.Uint64() {
+		return nil, fmt.Errorf("preload block %d is past the execution client's head, %d", e.PreloadBlock, head.Uint64())
+	}
+
+	behind := head.Uint64() - e.PreloadBlock
+	if behind > preloadBlockWarnDistance {
+		e.logger.Warn("Preload block is far behind the head, so backfilling events from it will take a while",
+			zap.Uint64("preload block", e.PreloadBlock),
+			zap.Uint64("head", head.Uint64()),
+			zap.Uint64("blocks", behind))
+	}
+	e.logger.Info("Warming up the cache at a pinned block", zap.Uint64("block", e.PreloadBlock), zap.Uint64("head", head.Uint64()))
+	return new(big.Int).SetUint64(e.PreloadBlock), nil
 }
 
 // Init creates and warms up the ExecutionLayer cache.
+// Until it returns, Warming() is true, and no other methods may be called.
+// Events emitted while the cache warms up are backfilled once it's warm, before the event loop starts.
 func (e *ExecutionLayer) Init() error {
+	e.m.Gauge("warming").Set(1)
+	if err := e.warmUp(); err != nil {
+		return err
+	}
+
+	e.warm.Store(true)
+	e.m.Gauge("warming").Set(0)
+	e.logger.Info("Execution layer cache is warm")
+	return nil
+}
+
+// Warming returns true until Init has warmed up the cache, so fee recipients can't be checked yet
+func (e *ExecutionLayer) Warming() bool {
+	return !e.warm.Load()
+}
+
+func (e *ExecutionLayer) warmUp() error {
 	var err error
 
 	if err := e.cache.init(); err != nil {
@@ -432,12 +1717,12 @@ func (e *ExecutionLayer) Init() error {
 	}
 	cacheBlock := e.cache.getHighestBlock()
 
-	e.client, err = ethclient.Dial(e.ecURL.String())
-	if err != nil {
+	if err := e.connectFirstHealthyEndpoint(context.Background()); err != nil {
 		return err
 	}
-	e.rp, err = rocketpool.NewRocketPool(e.client, common.HexToAddress(e.rocketStorageAddr))
-	if err != nil {
+
+	// Make sure rocketStorage exists before asking it for anything, or the errors are unhelpful
+	if err := e.verifyRocketStorage(context.Background(), e.client); err != nil {
 		return err
 	}
 
@@ -482,60 +1767,49 @@ func (e *ExecutionLayer) Init() error {
 	if err != nil {
 		return err
 	}
+	e.contractsCheckedBlock = header.Number.Uint64()
+	e.logger.Info("Resolved Rocket Pool contracts",
+		zap.String("network", e.Network.Name),
+		zap.String("rocketStorage", common.HexToAddress(e.rocketStorageAddr).String()),
+		zap.String("rocketNodeManager", e.rocketNodeManager.Address.String()),
+		zap.String("rocketMinipoolManager", e.rocketMinipoolManager.Address.String()),
+		zap.String("rocketSmoothingPool", e.smoothingPool.Address.String()))
+
+	allowlist, err := e.resolveAllowlist(opts)
+	if err != nil {
+		return err
+	}
+	e.allowlist.Store(&allowlist)
+	for _, allowed := range allowlist {
+		e.logger.Info("Allowing fee recipient for every minipool", zap.String("name", allowed.Name), zap.String("address", allowed.Address.String()))
+	}
 
 	// If the cache is warm, skip the slow path
 	if cacheBlock.Cmp(big.NewInt(0)) != 0 {
+		if err := e.recountNodes(); err != nil {
+			return err
+		}
+		if e.PreloadBlock != 0 {
+			e.logger.Info("Cache is warm, ignoring the preload block", zap.Uint64("preload block", e.PreloadBlock))
+		}
 		// Update opts to indicate that we need to backfill from after
 		// the cache block instead
 		opts.BlockNumber = cacheBlock
 		return e.ecEventsConnect(opts)
 	}
 	e.logger.Warn("Warming up the cache")
-
-	// Get all nodes at the given block
-	nodes, err := node.GetNodes(e.rp, opts)
+	opts.BlockNumber, err = e.preloadBlock(header.Number)
 	if err != nil {
 		return err
 	}
-	e.logger.Debug("Found nodes to preload", zap.Int("count", len(nodes)), zap.Int64("block", opts.BlockNumber.Int64()))
-
-	minipoolCount := 0
-	for _, n := range nodes {
-		// Allocate a pointer for this node
-		nodeInfo := &nodeInfo{}
-		// Determine their smoothing pool status
-		nodeInfo.inSmoothingPool, err = node.GetSmoothingPoolRegistrationState(e.rp, n.Address, opts)
-		if err != nil {
-			return err
-		}
-
-		// Get their fee distributor address
-		nodeInfo.feeDistributor, err = node.GetDistributorAddress(e.rp, n.Address, opts)
-		if err != nil {
-			return err
-		}
-
-		// Store the smoothing pool state / fee distributor in the node index
-		err = e.cache.addNodeInfo(n.Address, nodeInfo)
-		if err != nil {
-			return err
-		}
-
-		// Also grab their minipools
-		minipools, err := minipool.GetNodeMinipools(e.rp, n.Address, opts)
-		if err != nil {
-			return err
-		}
-
-		minipoolCount += len(minipools)
-		for _, minipool := range minipools {
-			err = e.cache.addMinipoolNode(minipool.Pubkey, n.Address)
-			if err != nil {
-				return err
-			}
-		}
+	err = e.setupMulticall(opts)
+	if err != nil {
+		return err
+	}
+	err = e.preload(opts)
+	if err != nil {
+		return err
 	}
-	e.logger.Debug("Pre-loaded nodes and minipools", zap.Int("nodes", len(nodes)), zap.Int("minipools", minipoolCount))
 
 	// Listen for updates
 	return e.ecEventsConnect(opts)
@@ -543,14 +1817,19 @@ func (e *ExecutionLayer) Init() error {
 
 // Deinit shuts down this ExecutionLayer
 func (e *ExecutionLayer) Deinit() {
-	if e.ethclientShutdownCb == nil {
+	if e.events == nil {
+		// Never connected
 		return
 	}
-	e.shutdown = true
-	e.ethclientShutdownCb()
+
+	// Stop the event loop, and wait for it to unsubscribe and finish processing events.
+	// Only then is it safe to close the channels the subscriptions send on.
+	e.cancel()
+	e.wg.Wait()
 	close(e.events)
 	close(e.newHeaders)
-	e.wg.Wait()
+	e.closeNodeEventSubscribers()
+
 	err := e.cache.deinit()
 	if err != nil {
 		e.logger.Error("error while stopping the cache", zap.Error(err))
@@ -562,9 +1841,80 @@ func (e *ExecutionLayer) ForEachNode(closure ForEachNodeClosure) error {
 	return e.cache.forEachNode(closure)
 }
 
-// ValidatorFeeRecipient returns the expected fee recipient for a validator, or nil if the validator is "unknown"
-// If the queryNodeAddr is not nil and the validator is a minipool but isn't owned by that node, (nil, true) is returned
-func (e *ExecutionLayer) ValidatorFeeRecipient(pubkey rptypes.ValidatorPubkey, queryNodeAddr *common.Address) (*common.Address, bool) {
+// ForEachNodeInfo calls the provided closure with the address, smoothing pool status and fee distributor
+// of every rocket pool node the ExecutionLayer has observed.
+// The addresses are collected first, so the closure isn't called from inside the cache's iterator, and
+// nodes removed in the meantime are skipped. Each node is read from the cache as its closure is called.
+func (e *ExecutionLayer) ForEachNodeInfo(closure ForEachNodeInfoClosure) error {
+	var nodes []common.Address
+	err := e.cache.forEachNode(func(addr common.Address) bool {
+		nodes = append(nodes, addr)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range nodes {
+		n, err := e.cache.getNodeInfo(addr)
+		if err != nil {
+			if _, ok := err.(*NotFoundError); ok {
+				continue
+			}
+			return err
+		}
+
+		// nodeInfo is immutable once cached, so its fields can be read without holding anything
+		if !closure(addr, n.inSmoothingPool, n.feeDistributor) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// InconsistentMinipool is a minipool whose node isn't in the node index, so its fee recipient can't be known
+type InconsistentMinipool struct {
+	Pubkey      rptypes.ValidatorPubkey
+	NodeAddress common.Address
+}
+
+// InconsistentMinipools cross-checks the minipool index against the node index, and returns every minipool whose
+// node is missing, sorted by pubkey. Like ForEachNodeInfo, the minipools are collected first, and their nodes are
+// looked up afterwards, so events applied in the meantime may show up in the result either way.
+func (e *ExecutionLayer) InconsistentMinipools() ([]InconsistentMinipool, error) {
+	var minipools []InconsistentMinipool
+	err := e.cache.forEachMinipool(func(pubkey rptypes.ValidatorPubkey, nodeAddr common.Address) bool {
+		minipools = append(minipools, InconsistentMinipool{Pubkey: pubkey, NodeAddress: nodeAddr})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]InconsistentMinipool, 0)
+	for _, minipool := range minipools {
+		_, err := e.cache.getNodeInfo(minipool.NodeAddress)
+		if err == nil {
+			continue
+		}
+		if _, ok := err.(*NotFoundError); !ok {
+			return nil, err
+		}
+		out = append(out, minipool)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return bytes.Compare(out[i].Pubkey[:], out[j].Pubkey[:]) < 0
+	})
+	return out, nil
+}
+
+// ValidatorFeeRecipient returns the expected fee recipient for a minipool validator, and the node which owns it.
+// If the validator isn't a minipool, feerecipient.ErrNotMinipool is returned.
+// If the queryNodeAddr is not nil and the minipool isn't owned by that node, feerecipient.ErrWrongNode is returned.
+// If the minipool's node isn't in the cache, feerecipient.ErrInconsistentCache is returned, since the expected fee recipient can't be known.
+func (e *ExecutionLayer) ValidatorFeeRecipient(ctx context.Context, pubkey rptypes.ValidatorPubkey, queryNodeAddr *common.Address) (*feerecipient.Info, error) {
 
 	nodeAddr, err := e.cache.getMinipoolNode(pubkey)
 	if err != nil {
@@ -574,14 +1924,16 @@ func (e *ExecutionLayer) ValidatorFeeRecipient(pubkey rptypes.ValidatorPubkey, q
 		}
 
 		// Validator (hopefully) isn't a minipool
+		e.m.CounterVec("minipool_index_lookups", "result").WithLabelValues("miss").Inc()
 		e.m.Counter("non_minipool_detected").Inc()
-		return nil, false
+		return nil, feerecipient.ErrNotMinipool
 	}
+	e.m.CounterVec("minipool_index_lookups", "result").WithLabelValues("hit").Inc()
 
 	if queryNodeAddr != nil && !bytes.Equal(queryNodeAddr.Bytes(), nodeAddr.Bytes()) {
 		// This minipool was owned by someone else
 		e.m.Counter("minipool_unowned_by_node").Inc()
-		return nil, true
+		return nil, feerecipient.ErrWrongNode
 	}
 
 	nodeInfo, err := e.cache.getNodeInfo(nodeAddr)
@@ -599,12 +1951,106 @@ func (e *ExecutionLayer) ValidatorFeeRecipient(pubkey rptypes.ValidatorPubkey, q
 		e.logger.Error("Validator was in the minipool index, but not the node index",
 			zap.String("pubkey", pubkey.String()),
 			zap.String("node", nodeAddr.String()))
-		return nil, false
+		return nil, &feerecipient.MissingNodeError{Pubkey: pubkey, NodeAddress: nodeAddr}
+	}
+
+	// The status is only informational, so a cache which doesn't have it yet reports it as unknown
+	status, err := e.cache.getMinipoolStatus(pubkey)
+	if err != nil {
+		if _, ok := err.(*NotFoundError); !ok {
+			e.logger.Panic("error querying cache for minipool status", zap.String("pubkey", pubkey.String()), zap.Error(err))
+		}
+		status = feerecipient.MinipoolStatusUnknown
 	}
 
 	if nodeInfo.inSmoothingPool {
-		return e.smoothingPool.Address, false
+		return &feerecipient.Info{
+			Expected:    *e.smoothingPool.Address,
+			Source:      feerecipient.SourceSmoothingPool,
+			NodeAddress: nodeAddr,
+			Status:      status,
+		}, nil
+	}
+
+	if nodeInfo.feeDistributor == (common.Address{}) {
+		// The node registered recently, and its fee distributor hasn't been looked up yet
+		e.m.Counter("fee_distributor_unknown").Inc()
+		return nil, fmt.Errorf("%w: the fee distributor address of node %s, which owns minipool %s, isn't known yet",
+			feerecipient.ErrInconsistentCache, nodeAddr.String(), pubkey.String())
+	}
+
+	return &feerecipient.Info{
+		Expected:    nodeInfo.feeDistributor,
+		Source:      feerecipient.SourceFeeDistributor,
+		NodeAddress: nodeAddr,
+		Status:      status,
+	}, nil
+}
+
+// NodeInfo is the cached state of a node, as needed to determine its validators' fee recipients,
+// and the enrichment fields read with it
+type NodeInfo struct {
+	InSmoothingPool bool
+	FeeDistributor  common.Address
+	MinipoolPubkeys []rptypes.ValidatorPubkey
+
+	WithdrawalAddress common.Address
+	// RPLStake and Bond are nil when they couldn't be read
+	RPLStake *big.Int
+	Bond     *big.Int
+	// The enrichment fields which couldn't be read, or haven't been yet, and are left zero
+	UnknownFields NodeField
+}
+
+// GetNodeInfo returns the cached state of a node, and the pubkeys of the minipools it owns.
+// If the node isn't known, a *NotFoundError is returned.
+func (e *ExecutionLayer) GetNodeInfo(nodeAddr common.Address) (*NodeInfo, error) {
+	n, err := e.cache.getNodeInfo(nodeAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	pubkeys, err := e.cache.getNodeMinipools(nodeAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// The cached nodeInfo mustn't be modified, so don't hand out its big.Ints
+	n = n.clone()
+	return &NodeInfo{
+		InSmoothingPool:   n.inSmoothingPool,
+		FeeDistributor:    n.feeDistributor,
+		MinipoolPubkeys:   pubkeys,
+		WithdrawalAddress: n.withdrawalAddress,
+		RPLStake:          n.rplStake,
+		Bond:              n.bond,
+		UnknownFields:     n.unknownFields,
+	}, nil
+}
+
+// NodeMinipools are the pubkeys of the minipools a node owns
+type NodeMinipools struct {
+	Pubkeys []rptypes.ValidatorPubkey
+	// The highest block for which an event was applied before the minipools were read. Minipools created since
+	// may be included too.
+	Block uint64
+}
+
+// GetNodeMinipools returns the pubkeys of the minipools a node owns, from the cache's index of each node's minipools,
+// which is built by Init and kept as minipools are created and destroyed. If the node isn't known, a *NotFoundError is returned.
+func (e *ExecutionLayer) GetNodeMinipools(nodeAddr common.Address) (*NodeMinipools, error) {
+	block := e.cache.getHighestBlock().Uint64()
+	if _, err := e.cache.getNodeInfo(nodeAddr); err != nil {
+		return nil, err
+	}
+
+	pubkeys, err := e.cache.getNodeMinipools(nodeAddr)
+	if err != nil {
+		return nil, err
 	}
 
-	return &nodeInfo.feeDistributor, false
+	return &NodeMinipools{
+		Pubkeys: pubkeys,
+		Block:   block,
+	}, nil
 }
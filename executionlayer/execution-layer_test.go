@@ -0,0 +1,1552 @@
+package executionlayer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipientstest"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rocket-pool/rocketpool-go/rocketpool"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+	"go.uber.org/zap"
+)
+
+// fakeChainReader serves contract reads from memory, and can be told to fail individual calls
+type fakeChainReader struct {
+	nodes     map[common.Address]*nodeInfo
+	minipools map[common.Address][]rptypes.ValidatorPubkey
+	// Minipool statuses by minipool address. Minipools which aren't in it are staking.
+	statuses  map[common.Address]rptypes.MinipoolStatus
+	contracts map[string]common.Address
+	abis      map[string]*abi.ABI
+	failures  map[string]error
+}
+
+func newFakeChainReader() *fakeChainReader {
+	return &fakeChainReader{
+		nodes:     make(map[common.Address]*nodeInfo),
+		minipools: make(map[common.Address][]rptypes.ValidatorPubkey),
+		statuses:  make(map[common.Address]rptypes.MinipoolStatus),
+		contracts: make(map[string]common.Address),
+		abis:      make(map[string]*abi.ABI),
+		failures:  make(map[string]error),
+	}
+}
+
+func (f *fakeChainReader) addNode(addr common.Address, inSP bool, pubkeys ...rptypes.ValidatorPubkey) {
+	f.nodes[addr] = &nodeInfo{
+		inSmoothingPool:   inSP,
+		feeDistributor:    common.BytesToAddress(append([]byte{0xfd}, addr[:19]...)),
+		withdrawalAddress: common.BytesToAddress(append([]byte{0xaa}, addr[:19]...)),
+		rplStake:          big.NewInt(1000),
+		bond:              big.NewInt(0).Mul(big.NewInt(int64(8*len(pubkeys))), big.NewInt(1e18)),
+	}
+	f.minipools[addr] = pubkeys
+}
+
+func (f *fakeChainReader) node(call string, addr common.Address) (*nodeInfo, error) {
+	if err, ok := f.failures[call]; ok {
+		return nil, err
+	}
+
+	n, ok := f.nodes[addr]
+	if !ok {
+		return nil, fmt.Errorf("node %s not found", addr.String())
+	}
+	return n, nil
+}
+
+func (f *fakeChainReader) nodeAddresses(opts *bind.CallOpts) ([]common.Address, error) {
+	if err, ok := f.failures["nodeAddresses"]; ok {
+		return nil, err
+	}
+
+	out := make([]common.Address, 0, len(f.nodes))
+	for addr := range f.nodes {
+		out = append(out, addr)
+	}
+	return out, nil
+}
+
+func (f *fakeChainReader) smoothingPoolStatus(nodeAddr common.Address, opts *bind.CallOpts) (bool, error) {
+	n, err := f.node("smoothingPoolStatus", nodeAddr)
+	if err != nil {
+		return false, err
+	}
+	return n.inSmoothingPool, nil
+}
+
+func (f *fakeChainReader) feeDistributor(nodeAddr common.Address, opts *bind.CallOpts) (common.Address, error) {
+	n, err := f.node("feeDistributor", nodeAddr)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return n.feeDistributor, nil
+}
+
+func (f *fakeChainReader) nodeMinipools(nodeAddr common.Address, opts *bind.CallOpts) ([]nodeMinipool, error) {
+	if err, ok := f.failures["nodeMinipools"]; ok {
+		return nil, err
+	}
+
+	out := make([]nodeMinipool, 0, len(f.minipools[nodeAddr]))
+	for _, pubkey := range f.minipools[nodeAddr] {
+		out = append(out, nodeMinipool{addr: fakeMinipoolAddress(pubkey), pubkey: pubkey})
+	}
+	return out, nil
+}
+
+// fakeMinipoolAddress is the inverse of minipoolPubkey, which derives a minipool's pubkey from its address
+func fakeMinipoolAddress(pubkey rptypes.ValidatorPubkey) common.Address {
+	return common.BytesToAddress(pubkey[:common.AddressLength])
+}
+
+func (f *fakeChainReader) minipoolPubkey(minipoolAddr common.Address, opts *bind.CallOpts) (rptypes.ValidatorPubkey, error) {
+	if err, ok := f.failures["minipoolPubkey"]; ok {
+		return rptypes.ValidatorPubkey{}, err
+	}
+
+	// Derive a pubkey from the minipool address
+	var out rptypes.ValidatorPubkey
+	copy(out[:], minipoolAddr[:])
+	return out, nil
+}
+
+func (f *fakeChainReader) nodeExists(nodeAddr common.Address, opts *bind.CallOpts) (bool, error) {
+	if err, ok := f.failures["nodeExists"]; ok {
+		return false, err
+	}
+	_, ok := f.nodes[nodeAddr]
+	return ok, nil
+}
+
+func (f *fakeChainReader) withdrawalAddress(nodeAddr common.Address, opts *bind.CallOpts) (common.Address, error) {
+	n, err := f.node("withdrawalAddress", nodeAddr)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return n.withdrawalAddress, nil
+}
+
+func (f *fakeChainReader) rplStake(nodeAddr common.Address, opts *bind.CallOpts) (*big.Int, error) {
+	n, err := f.node("rplStake", nodeAddr)
+	if err != nil {
+		return nil, err
+	}
+	return n.rplStake, nil
+}
+
+func (f *fakeChainReader) minipoolStatus(minipoolAddr common.Address, opts *bind.CallOpts) (rptypes.MinipoolStatus, error) {
+	if err, ok := f.failures["minipoolStatus"]; ok {
+		return rptypes.Initialized, err
+	}
+
+	status, ok := f.statuses[minipoolAddr]
+	if !ok {
+		return rptypes.Staking, nil
+	}
+	return status, nil
+}
+
+func (f *fakeChainReader) nodeCount(opts *bind.CallOpts) (uint64, error) {
+	if err, ok := f.failures["nodeCount"]; ok {
+		return 0, err
+	}
+	return uint64(len(f.nodes)), nil
+}
+
+func (f *fakeChainReader) minipoolCount(opts *bind.CallOpts) (uint64, error) {
+	if err, ok := f.failures["minipoolCount"]; ok {
+		return 0, err
+	}
+
+	var out uint64
+	for _, pubkeys := range f.minipools {
+		out += uint64(len(pubkeys))
+	}
+	return out, nil
+}
+
+func (f *fakeChainReader) contract(name string, opts *bind.CallOpts) (*rocketpool.Contract, error) {
+	if err, ok := f.failures["contract"]; ok {
+		return nil, err
+	}
+
+	addr, ok := f.contracts[name]
+	if !ok {
+		return nil, fmt.Errorf("contract %s not found", name)
+	}
+	return &rocketpool.Contract{Address: &addr, ABI: f.abis[name]}, nil
+}
+
+// fakeSubscription is an ethereum.Subscription which only errors when told to.
+// Like ethclient's, Unsubscribe() waits for any goroutine feeding the subscription to exit.
+type fakeSubscription struct {
+	err          chan error
+	unsubscribed atomic.Bool
+
+	quit    chan struct{}
+	once    sync.Once
+	feeding sync.WaitGroup
+}
+
+func newFakeSubscription() *fakeSubscription {
+	return &fakeSubscription{
+		err:  make(chan error, 1),
+		quit: make(chan struct{}),
+	}
+}
+
+// feed calls send in a loop until the subscription is closed
+func (f *fakeSubscription) feed(send func(quit chan struct{}) bool) {
+	f.feeding.Add(1)
+	go func() {
+		defer f.feeding.Done()
+		for send(f.quit) {
+		}
+	}()
+}
+
+func (f *fakeSubscription) Unsubscribe() {
+	f.unsubscribed.Store(true)
+	f.once.Do(func() {
+		close(f.quit)
+	})
+	f.feeding.Wait()
+}
+
+func (f *fakeSubscription) Err() <-chan error {
+	return f.err
+}
+
+// fakeECClient serves headers and logs from memory.
+// It rejects FilterLogs calls spanning more than maxRange blocks, like hosted providers do.
+type fakeECClient struct {
+	head     uint64
+	logs     []types.Log
+	maxRange uint64
+	// If non-zero, the nth FilterLogs call (counting from 1) fails
+	failCall int
+
+	// The ranges and subscriptions of the manager contracts' queries, and of minipools' status updates'
+	calls       [][2]uint64
+	subs        []*fakeSubscription
+	statusCalls [][2]uint64
+	statusSubs  []*fakeSubscription
+
+	// If set, HeaderByNumber fails with it
+	headerErr error
+
+	// If set, subscribing fails with it
+	subscribeErr      error
+	subscribeAttempts atomic.Int32
+	// The first this many attempts to subscribe to the manager contracts' events fail
+	subscribeFailures int32
+
+	// If set, new header subscriptions are fed headers as fast as they're read
+	feedHeaders bool
+
+	// If set, serves calls made through Multicall3
+	multicall *fakeMulticall
+
+	chainID uint64
+	// Contracts deployed, other than Multicall3
+	code map[common.Address][]byte
+}
+
+func (f *fakeECClient) ChainID(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(0).SetUint64(f.chainID), nil
+}
+
+func (f *fakeECClient) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	if f.multicall != nil && contract == f.multicall.address {
+		return []byte{0x00}, nil
+	}
+	return f.code[contract], nil
+}
+
+func (f *fakeECClient) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if f.multicall == nil || *call.To != f.multicall.address {
+		return nil, fmt.Errorf("not supported")
+	}
+	return f.multicall.call(call.Data)
+}
+
+func (f *fakeECClient) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	if f.headerErr != nil {
+		return nil, f.headerErr
+	}
+	return &types.Header{Number: big.NewInt(0).SetUint64(f.head)}, nil
+}
+
+func (f *fakeECClient) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	from := q.FromBlock.Uint64()
+	to := q.ToBlock.Uint64()
+	if len(q.Addresses) == 0 {
+		f.statusCalls = append(f.statusCalls, [2]uint64{from, to})
+	} else {
+		f.calls = append(f.calls, [2]uint64{from, to})
+		if len(f.calls) == f.failCall {
+			return nil, fmt.Errorf("transient error")
+		}
+	}
+
+	if f.maxRange != 0 && to-from+1 > f.maxRange {
+		return nil, fmt.Errorf("query returned more than 10000 results")
+	}
+
+	out := make([]types.Log, 0)
+	for _, l := range f.logs {
+		if l.BlockNumber < from || l.BlockNumber > to {
+			continue
+		}
+
+		// Only filter by address if the query specifies any
+		matched := len(q.Addresses) == 0
+		for _, addr := range q.Addresses {
+			matched = matched || addr == l.Address
+		}
+		topicMatched := len(q.Topics) == 0
+		for i := 0; !topicMatched && i < len(q.Topics[0]); i++ {
+			topicMatched = len(l.Topics) > 0 && q.Topics[0][i] == l.Topics[0]
+		}
+		if matched && topicMatched {
+			out = append(out, l)
+		}
+	}
+	return out, nil
+}
+
+// SubscribeFilterLogs only counts the manager contracts' subscriptions as attempts, since they're made first
+func (f *fakeECClient) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	status := len(q.Addresses) == 0
+	if !status {
+		f.subscribeAttempts.Add(1)
+	}
+	if f.subscribeErr != nil {
+		return nil, f.subscribeErr
+	}
+	if !status && f.subscribeAttempts.Load() <= f.subscribeFailures {
+		return nil, fmt.Errorf("connection refused")
+	}
+
+	sub := newFakeSubscription()
+	if status {
+		f.statusSubs = append(f.statusSubs, sub)
+	} else {
+		f.subs = append(f.subs, sub)
+	}
+	return sub, nil
+}
+
+func (f *fakeECClient) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error) {
+	if f.subscribeErr != nil {
+		return nil, f.subscribeErr
+	}
+
+	sub := newFakeSubscription()
+	if f.feedHeaders {
+		head := f.head
+		sub.feed(func(quit chan struct{}) bool {
+			head++
+			select {
+			case ch <- &types.Header{Number: big.NewInt(0).SetUint64(head)}:
+				return true
+			case <-quit:
+				return false
+			}
+		})
+	}
+	return sub, nil
+}
+
+var (
+	testNode0 = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	testNode1 = common.HexToAddress("0x2222222222222222222222222222222222222222")
+
+	testNodeManager     = common.HexToAddress("0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	testMinipoolManager = common.HexToAddress("0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	testSmoothingPool   = common.HexToAddress("0xcccccccccccccccccccccccccccccccccccccccc")
+)
+
+func spStatusChangedLog(e *ExecutionLayer, nodeAddr common.Address, inSP bool, block uint64) types.Log {
+	data := make([]byte, 32)
+	if inSP {
+		data[31] = 1
+	}
+
+	return types.Log{
+		Address:     testNodeManager,
+		Topics:      []common.Hash{e.smoothingPoolStatusChangedTopic, common.BytesToHash(nodeAddr.Bytes())},
+		Data:        data,
+		BlockNumber: block,
+	}
+}
+
+func nodeRegisteredLog(e *ExecutionLayer, nodeAddr common.Address, block uint64) types.Log {
+	return types.Log{
+		Address:     testNodeManager,
+		Topics:      []common.Hash{e.nodeRegisteredTopic, common.BytesToHash(nodeAddr.Bytes())},
+		Data:        make([]byte, 32),
+		BlockNumber: block,
+	}
+}
+
+func minipoolCreatedLog(e *ExecutionLayer, minipoolAddr common.Address, nodeAddr common.Address, block uint64) types.Log {
+	return types.Log{
+		Address:     testMinipoolManager,
+		Topics:      []common.Hash{e.minipoolLaunchedTopic, common.BytesToHash(minipoolAddr.Bytes()), common.BytesToHash(nodeAddr.Bytes())},
+		Data:        make([]byte, 32),
+		BlockNumber: block,
+	}
+}
+
+func minipoolDestroyedLog(e *ExecutionLayer, minipoolAddr common.Address, nodeAddr common.Address, block uint64) types.Log {
+	out := minipoolCreatedLog(e, minipoolAddr, nodeAddr, block)
+	out.Topics[0] = e.minipoolDestroyedTopic
+	return out
+}
+
+func removed(event types.Log) types.Log {
+	event.Removed = true
+	return event
+}
+
+func testPubkey(b byte) rptypes.ValidatorPubkey {
+	var out rptypes.ValidatorPubkey
+	for i := range out {
+		out[i] = b
+	}
+	return out
+}
+
+// metricsNamespace returns a unique, valid metrics namespace for a (sub)test
+func metricsNamespace(t *testing.T) string {
+	return "execution_layer_test_" + strings.ReplaceAll(t.Name(), "/", "_")
+}
+
+func setup(t *testing.T) (*ExecutionLayer, *fakeChainReader, func()) {
+	_, err := metrics.Init(metricsNamespace(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain := newFakeChainReader()
+	chain.addNode(testNode0, true, testPubkey(0x01), testPubkey(0x02))
+	chain.addNode(testNode1, false, testPubkey(0x03))
+
+	chain.contracts["rocketNodeManager"] = testNodeManager
+	chain.contracts["rocketMinipoolManager"] = testMinipoolManager
+
+	e := NewExecutionLayer(nil, "", &MapsCache{}, zap.NewNop())
+	e.chain = chain
+	e.rocketNodeManager = &rocketpool.Contract{Address: &testNodeManager}
+	e.rocketMinipoolManager = &rocketpool.Contract{Address: &testMinipoolManager}
+	e.smoothingPool = &rocketpool.Contract{Address: &testSmoothingPool}
+	e.updateQuery()
+	if err := e.cache.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	return e, chain, func() {
+		metrics.Deinit()
+	}
+}
+
+func TestPreload(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	for addr, expected := range chain.nodes {
+		n, err := e.cache.getNodeInfo(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n.inSmoothingPool != expected.inSmoothingPool || n.feeDistributor != expected.feeDistributor {
+			t.Errorf("unexpected node info for %s: %+v", addr.String(), n)
+		}
+
+		// Without Multicall3, bonds are only known for nodes without minipools
+		var unknown NodeField
+		if len(chain.minipools[addr]) > 0 {
+			unknown = BondField
+		}
+		if n.unknownFields != unknown {
+			t.Errorf("expected unknown fields %v for %s, got %v", unknown.Names(), addr.String(), n.unknownFields.Names())
+		}
+
+		for _, pubkey := range chain.minipools[addr] {
+			owner, err := e.cache.getMinipoolNode(pubkey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if owner != addr {
+				t.Errorf("expected minipool %s to be owned by %s, got %s", pubkey.String(), addr.String(), owner.String())
+			}
+		}
+	}
+}
+
+func TestPreloadConcurrency(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	// More nodes than workers
+	for i := 0; i < 100; i++ {
+		chain.addNode(common.BigToAddress(big.NewInt(int64(0x1000+i))), i%2 == 0, testPubkey(byte(0x10+i)))
+	}
+	e.PreloadConcurrency = 8
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	err := e.ForEachNode(func(addr common.Address) bool {
+		count++
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(chain.nodes) {
+		t.Fatalf("expected %d nodes, got %d", len(chain.nodes), count)
+	}
+
+	for addr, pubkeys := range chain.minipools {
+		for _, pubkey := range pubkeys {
+			owner, err := e.cache.getMinipoolNode(pubkey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if owner != addr {
+				t.Errorf("expected minipool %s to be owned by %s, got %s", pubkey.String(), addr.String(), owner.String())
+			}
+		}
+	}
+}
+
+func TestForEachNodeInfo(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[common.Address]bool)
+	err := e.ForEachNodeInfo(func(addr common.Address, inSmoothingPool bool, feeDistributor common.Address) bool {
+		expected, ok := chain.nodes[addr]
+		if !ok {
+			t.Errorf("unexpected node %s", addr.String())
+			return true
+		}
+		if inSmoothingPool != expected.inSmoothingPool || feeDistributor != expected.feeDistributor {
+			t.Errorf("unexpected details for node %s: %v %s", addr.String(), inSmoothingPool, feeDistributor.String())
+		}
+
+		// The cache can be read from the closure
+		if _, err := e.GetNodeInfo(addr); err != nil {
+			t.Error(err)
+		}
+		seen[addr] = true
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != len(chain.nodes) {
+		t.Fatalf("expected %d nodes, got %d", len(chain.nodes), len(seen))
+	}
+
+	// Returning false stops the iteration
+	count := 0
+	err = e.ForEachNodeInfo(func(common.Address, bool, common.Address) bool {
+		count++
+		return false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected the iteration to stop after 1 node, got %d", count)
+	}
+}
+
+func TestPreloadEnrichmentFailures(t *testing.T) {
+	for _, tc := range []struct {
+		call     string
+		expected NodeField
+	}{
+		{"withdrawalAddress", WithdrawalAddressField},
+		{"rplStake", RPLStakeField},
+		{"minipoolNodeDeposit", BondField},
+	} {
+		t.Run(tc.call, func(t *testing.T) {
+			e, chain, teardown := setup(t)
+			defer teardown()
+			// Bonds are only read through Multicall3
+			setupMulticall(t, e, chain)
+
+			chain.failures[tc.call] = fmt.Errorf("abi mismatch")
+
+			// The preload should still complete
+			if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+				t.Fatal(err)
+			}
+
+			// And every node should have the degraded marker set
+			for addr := range chain.nodes {
+				n, err := e.GetNodeInfo(addr)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if n.UnknownFields != tc.expected {
+					t.Errorf("expected unknown fields %v, got %v", tc.expected.Names(), n.UnknownFields.Names())
+				}
+				// The fields which were read are still reported
+				if (tc.expected == RPLStakeField) != (n.RPLStake == nil) || (tc.expected == BondField) != (n.Bond == nil) {
+					t.Errorf("expected only the unknown field to be missing, got stake %v and bond %v", n.RPLStake, n.Bond)
+				}
+			}
+
+			// Fee recipients are still enforced
+			feeRecipient, _ := e.ValidatorFeeRecipient(context.Background(), testPubkey(0x03), &testNode1)
+			if feeRecipient == nil || feeRecipient.Expected != chain.nodes[testNode1].feeDistributor {
+				t.Errorf("unexpected fee recipient %v", feeRecipient)
+			}
+		})
+	}
+}
+
+func TestPreloadEssentialFailures(t *testing.T) {
+	for _, call := range []string{"nodeAddresses", "smoothingPoolStatus", "feeDistributor", "nodeMinipools"} {
+		t.Run(call, func(t *testing.T) {
+			e, chain, teardown := setup(t)
+			defer teardown()
+
+			chain.failures[call] = fmt.Errorf("abi mismatch")
+
+			if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err == nil {
+				t.Fatal("expected the preload to fail")
+			}
+		})
+	}
+}
+
+func TestSqliteCacheUnknownFields(t *testing.T) {
+	_, err := metrics.Init(metricsNamespace(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer metrics.Deinit()
+
+	cache := &SqliteCache{Path: t.TempDir()}
+	if err := cache.init(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := cache.deinit(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	in := &nodeInfo{
+		inSmoothingPool: true,
+		feeDistributor:  testNode1,
+		bond:            big.NewInt(16),
+		unknownFields:   RPLStakeField,
+	}
+	if err := cache.addNodeInfo(testNode0, in); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := cache.getNodeInfo(testNode0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !out.inSmoothingPool || out.feeDistributor != testNode1 || out.unknownFields != RPLStakeField || out.rplStake != nil || out.bond.Cmp(in.bond) != 0 {
+		t.Errorf("unexpected node info %+v", out)
+	}
+}
+
+func TestReorgMinipoolCreated(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	minipoolAddr := common.HexToAddress("0x0909090909090909090909090909090909090909")
+	event := minipoolCreatedLog(e, minipoolAddr, testNode1, 101)
+	pubkey, _ := e.chain.minipoolPubkey(minipoolAddr, nil)
+
+	e.handleEvent(event)
+	if feeRecipient, _ := e.ValidatorFeeRecipient(context.Background(), pubkey, &testNode1); feeRecipient == nil {
+		t.Fatal("expected the new minipool to be indexed")
+	}
+
+	// Now reorg it out
+	e.handleEvent(removed(event))
+	if _, err := e.ValidatorFeeRecipient(context.Background(), pubkey, &testNode1); !errors.Is(err, feerecipient.ErrNotMinipool) {
+		t.Fatal("expected the reorged minipool to be removed from the index")
+	}
+}
+
+func TestMinipoolDestroyed(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	minipoolAddr := common.HexToAddress("0x0909090909090909090909090909090909090909")
+	pubkey, _ := e.chain.minipoolPubkey(minipoolAddr, nil)
+	e.handleEvent(minipoolCreatedLog(e, minipoolAddr, testNode1, 101))
+
+	event := minipoolDestroyedLog(e, minipoolAddr, testNode1, 102)
+	e.handleEvent(event)
+	if _, err := e.ValidatorFeeRecipient(context.Background(), pubkey, &testNode1); !errors.Is(err, feerecipient.ErrNotMinipool) {
+		t.Fatal("expected the destroyed minipool to be removed from the index")
+	}
+
+	// Reorging the destruction out should restore it
+	e.handleEvent(removed(event))
+	if feeRecipient, _ := e.ValidatorFeeRecipient(context.Background(), pubkey, &testNode1); feeRecipient == nil {
+		t.Fatal("expected the minipool to be indexed again")
+	}
+}
+
+func TestValidatorFeeRecipientMissingNode(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The minipool index still points at a node which is gone from the node index
+	if err := e.cache.removeNodeInfo(testNode1); err != nil {
+		t.Fatal(err)
+	}
+	feeRecipient, err := e.ValidatorFeeRecipient(context.Background(), testPubkey(0x03), &testNode1)
+	if !errors.Is(err, feerecipient.ErrInconsistentCache) || feeRecipient != nil {
+		t.Fatalf("expected an inconsistent cache error for a minipool without a node, got %+v, %v", feeRecipient, err)
+	}
+	var missing *feerecipient.MissingNodeError
+	if !errors.As(err, &missing) || missing.Pubkey != testPubkey(0x03) || missing.NodeAddress != testNode1 {
+		t.Fatalf("expected the error to name the minipool and its node, got %v", err)
+	}
+
+	// The admin API lists every minipool in that state
+	inconsistent, err := e.InconsistentMinipools()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for i, minipool := range inconsistent {
+		if minipool.NodeAddress != testNode1 {
+			t.Fatalf("expected only minipools of %s, got %+v", testNode1, minipool)
+		}
+		if i > 0 && bytes.Compare(inconsistent[i-1].Pubkey[:], minipool.Pubkey[:]) >= 0 {
+			t.Fatal("expected inconsistent minipools to be sorted by pubkey")
+		}
+		found = found || minipool.Pubkey == testPubkey(0x03)
+	}
+	if !found {
+		t.Fatalf("expected %s to be inconsistent, got %+v", testPubkey(0x03), inconsistent)
+	}
+
+	// Validators which aren't minipools aren't errors
+	if _, err := e.ValidatorFeeRecipient(context.Background(), testPubkey(0xff), &testNode1); !errors.Is(err, feerecipient.ErrNotMinipool) {
+		t.Fatalf("expected %v, got %v", feerecipient.ErrNotMinipool, err)
+	}
+}
+
+func TestValidatorFeeRecipient(t *testing.T) {
+	otherNode := common.HexToAddress("0x0e0e0e0e0e0e0e0e0e0e0e0e0e0e0e0e0e0e0e0e")
+	removeNode1 := func(e *ExecutionLayer) error { return e.cache.removeNodeInfo(testNode1) }
+	// What the fake chain says testNode1's fee distributor is
+	feeDistributor := newFakeChainReader()
+	feeDistributor.addNode(testNode1, false)
+	feeDistributor1 := feeDistributor.nodes[testNode1].feeDistributor
+
+	for _, tc := range []struct {
+		name     string
+		pubkey   rptypes.ValidatorPubkey
+		nodeAddr *common.Address
+		// Run after the preload, to break the cache
+		prepare func(e *ExecutionLayer) error
+		// Either expected or err is set
+		expected *feerecipient.Info
+		err      error
+	}{
+		{"smoothing pool", testPubkey(0x01), &testNode0, nil,
+			&feerecipient.Info{Expected: testSmoothingPool, Source: feerecipient.SourceSmoothingPool, NodeAddress: testNode0,
+				Status: feerecipient.MinipoolStatusStaking}, nil},
+		{"fee distributor", testPubkey(0x03), &testNode1, nil,
+			&feerecipient.Info{Expected: feeDistributor1, Source: feerecipient.SourceFeeDistributor, NodeAddress: testNode1,
+				Status: feerecipient.MinipoolStatusStaking}, nil},
+		{"no query node", testPubkey(0x03), nil, nil,
+			&feerecipient.Info{Expected: feeDistributor1, Source: feerecipient.SourceFeeDistributor, NodeAddress: testNode1,
+				Status: feerecipient.MinipoolStatusStaking}, nil},
+		{"not a minipool", testPubkey(0xff), &testNode1, nil, nil, feerecipient.ErrNotMinipool},
+		{"not a minipool without a query node", testPubkey(0xff), nil, nil, nil, feerecipient.ErrNotMinipool},
+		{"wrong node", testPubkey(0x03), &otherNode, nil, nil, feerecipient.ErrWrongNode},
+		{"wrong node with an inconsistent cache", testPubkey(0x03), &testNode0, removeNode1, nil, feerecipient.ErrWrongNode},
+		{"inconsistent cache", testPubkey(0x03), &testNode1, removeNode1, nil, feerecipient.ErrInconsistentCache},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e, _, teardown := setup(t)
+			defer teardown()
+
+			if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+				t.Fatal(err)
+			}
+			if tc.prepare != nil {
+				if err := tc.prepare(e); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			info, err := e.ValidatorFeeRecipient(context.Background(), tc.pubkey, tc.nodeAddr)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) || info != nil {
+					t.Fatalf("expected %v, got %+v %v", tc.err, info, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *info != *tc.expected {
+				t.Fatalf("expected %+v, got %+v", tc.expected, info)
+			}
+		})
+	}
+}
+
+func TestValidatorFeeRecipientContract(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// testNode0 is in the smoothing pool
+	feerecipientstest.TestFeeRecipientSource(t, e, feerecipientstest.Fixture{
+		Known:        testPubkey(0x01),
+		FeeRecipient: testSmoothingPool,
+		Owner:        testNode0,
+		Unknown:      testPubkey(0xff),
+	})
+}
+
+func TestReorgSmoothingPoolStatus(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// testNode1 opts in, in a block which is later reorged out
+	event := spStatusChangedLog(e, testNode1, true, 101)
+	e.handleEvent(event)
+	feeRecipient, _ := e.ValidatorFeeRecipient(context.Background(), testPubkey(0x03), &testNode1)
+	if feeRecipient == nil || feeRecipient.Expected != testSmoothingPool {
+		t.Fatalf("expected smoothing pool fee recipient, got %v", feeRecipient)
+	}
+
+	// The chain at head still has the node opted out
+	e.handleEvent(removed(event))
+	feeRecipient, _ = e.ValidatorFeeRecipient(context.Background(), testPubkey(0x03), &testNode1)
+	if feeRecipient == nil || feeRecipient.Expected != chain.nodes[testNode1].feeDistributor {
+		t.Fatalf("expected fee distributor fee recipient after the reorg, got %v", feeRecipient)
+	}
+}
+
+func TestReorgNodeRegistered(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	newNode := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	chain.addNode(newNode, false)
+
+	event := nodeRegisteredLog(e, newNode, 101)
+	e.handleEvent(event)
+	if _, err := e.cache.getNodeInfo(newNode); err != nil {
+		t.Fatal(err)
+	}
+
+	e.handleEvent(removed(event))
+	if _, err := e.cache.getNodeInfo(newNode); err == nil {
+		t.Fatal("expected the reorged node registration to be removed from the index")
+	}
+}
+
+func TestHighestBlockConcurrency(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	const blocks = 1000
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	// Events
+	go func() {
+		defer wg.Done()
+		for i := uint64(0); i < blocks; i++ {
+			e.handleEvent(nodeRegisteredLog(e, testNode0, 100+i))
+		}
+	}()
+
+	// Headers
+	go func() {
+		defer wg.Done()
+		for i := uint64(0); i < blocks; i++ {
+			e.cache.setHighestBlock(big.NewInt(int64(100 + blocks - i)))
+		}
+	}()
+
+	// Readers
+	go func() {
+		defer wg.Done()
+		last := uint64(0)
+		for i := 0; i < blocks; i++ {
+			current := e.cache.getHighestBlock().Uint64()
+			if current < last {
+				t.Errorf("highest block went backwards from %d to %d", last, current)
+			}
+			last = current
+		}
+	}()
+
+	wg.Wait()
+
+	if e.cache.getHighestBlock().Uint64() != 100+blocks {
+		t.Fatalf("expected highest block %d, got %d", 100+blocks, e.cache.getHighestBlock().Uint64())
+	}
+}
+
+func TestSmoothingPoolStatusConcurrency(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	const updates = 1000
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// Flip testNode1 in and out of the smoothing pool
+	go func() {
+		defer wg.Done()
+		for i := uint64(0); i < updates; i++ {
+			e.handleEvent(spStatusChangedLog(e, testNode1, i%2 == 0, 101+i))
+		}
+	}()
+
+	// Query its fee recipient at the same time
+	go func() {
+		defer wg.Done()
+		for i := 0; i < updates; i++ {
+			feeRecipient, _ := e.ValidatorFeeRecipient(context.Background(), testPubkey(0x03), &testNode1)
+			if feeRecipient == nil {
+				t.Error("expected a fee recipient")
+				return
+			}
+
+			if feeRecipient.Expected != testSmoothingPool && feeRecipient.Expected != chain.nodes[testNode1].feeDistributor {
+				t.Errorf("unexpected fee recipient %s", feeRecipient.Expected.String())
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	// The last update opted out
+	feeRecipient, _ := e.ValidatorFeeRecipient(context.Background(), testPubkey(0x03), &testNode1)
+	if feeRecipient == nil || feeRecipient.Expected != chain.nodes[testNode1].feeDistributor {
+		t.Fatalf("unexpected fee recipient %v", feeRecipient)
+	}
+}
+
+func TestBackfillChunking(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+	e.cache.setHighestBlock(big.NewInt(100))
+
+	// A node opts in and back out a few thousand blocks apart
+	client := &fakeECClient{
+		head:     3500,
+		maxRange: 1000,
+		logs: []types.Log{
+			spStatusChangedLog(e, testNode1, true, 150),
+			spStatusChangedLog(e, testNode1, false, 2200),
+			spStatusChangedLog(e, testNode1, true, 3450),
+		},
+	}
+	e.client = client
+	e.BackfillChunkSize = 1000
+
+	if err := e.backfillEvents(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][2]uint64{{101, 1100}, {1101, 2100}, {2101, 3100}, {3101, 3500}}
+	if fmt.Sprint(client.calls) != fmt.Sprint(expected) {
+		t.Fatalf("expected FilterLogs calls %v, got %v", expected, client.calls)
+	}
+	// Status updates are backfilled over the same chunks
+	if fmt.Sprint(client.statusCalls) != fmt.Sprint(expected) {
+		t.Fatalf("expected status FilterLogs calls %v, got %v", expected, client.statusCalls)
+	}
+
+	if e.cache.getHighestBlock().Uint64() != 3500 {
+		t.Fatalf("expected highest block 3500, got %d", e.cache.getHighestBlock().Uint64())
+	}
+
+	// The events were applied in order
+	feeRecipient, _ := e.ValidatorFeeRecipient(context.Background(), testPubkey(0x03), &testNode1)
+	if feeRecipient == nil || feeRecipient.Expected != testSmoothingPool {
+		t.Fatalf("expected smoothing pool fee recipient, got %v", feeRecipient)
+	}
+}
+
+func TestBackfillResume(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+	e.cache.setHighestBlock(big.NewInt(100))
+
+	client := &fakeECClient{
+		head:     3500,
+		maxRange: 1000,
+		failCall: 3,
+	}
+	e.client = client
+	e.BackfillChunkSize = 1000
+
+	if err := e.backfillEvents(); err == nil {
+		t.Fatal("expected the backfill to fail")
+	}
+
+	// The first two chunks completed
+	if e.cache.getHighestBlock().Uint64() != 2100 {
+		t.Fatalf("expected highest block 2100, got %d", e.cache.getHighestBlock().Uint64())
+	}
+
+	// Try again, which should resume after the last completed chunk
+	client.calls = nil
+	client.failCall = 0
+	if err := e.backfillEvents(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][2]uint64{{2101, 3100}, {3101, 3500}}
+	if fmt.Sprint(client.calls) != fmt.Sprint(expected) {
+		t.Fatalf("expected FilterLogs calls %v, got %v", expected, client.calls)
+	}
+}
+
+func TestContractUpgrade(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+	e.cache.setHighestBlock(big.NewInt(100))
+	e.contractsCheckedBlock = 100
+	e.updateQuery()
+
+	client := &fakeECClient{head: 164}
+	e.client = client
+	oldLogs := newFakeSubscription()
+	subs := &subscriptions{logs: oldLogs, headers: newFakeSubscription()}
+
+	// Nothing changed, so nothing should happen
+	subs = e.checkContractUpgrades(context.Background(), big.NewInt(132), subs)
+	if len(client.subs) != 0 || len(client.calls) != 0 {
+		t.Fatal("expected no resubscription without a contract upgrade")
+	}
+	if e.contractsCheckedBlock != 132 {
+		t.Fatalf("expected contracts to be checked at block 132, got %d", e.contractsCheckedBlock)
+	}
+
+	// The minipool manager is upgraded, and the new one emits an event before we notice
+	newMinipoolManager := common.HexToAddress("0xdddddddddddddddddddddddddddddddddddddddd")
+	chain.contracts["rocketMinipoolManager"] = newMinipoolManager
+	minipoolAddr := common.HexToAddress("0x0909090909090909090909090909090909090909")
+	pubkey, _ := e.chain.minipoolPubkey(minipoolAddr, nil)
+	event := minipoolCreatedLog(e, minipoolAddr, testNode1, 140)
+	event.Address = newMinipoolManager
+	client.logs = []types.Log{event}
+
+	subs = e.checkContractUpgrades(context.Background(), big.NewInt(164), subs)
+	if !oldLogs.unsubscribed.Load() {
+		t.Fatal("expected the old subscription to be closed")
+	}
+	if len(client.subs) != 1 || len(client.statusSubs) != 1 || client.subs[0].unsubscribed.Load() {
+		t.Fatal("expected new subscriptions")
+	}
+	if *e.rocketMinipoolManager.Address != newMinipoolManager {
+		t.Fatalf("expected the new minipool manager, got %s", e.rocketMinipoolManager.Address.String())
+	}
+
+	// Events since the last check were backfilled
+	expected := [][2]uint64{{133, 164}}
+	if fmt.Sprint(client.calls) != fmt.Sprint(expected) {
+		t.Fatalf("expected FilterLogs calls %v, got %v", expected, client.calls)
+	}
+	if feeRecipient, _ := e.ValidatorFeeRecipient(context.Background(), pubkey, &testNode1); feeRecipient == nil {
+		t.Fatal("expected the minipool from the new contract to be indexed")
+	}
+}
+
+func TestContractUpgradeResubscribeFailed(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+	e.cache.setHighestBlock(big.NewInt(100))
+	e.contractsCheckedBlock = 100
+	e.updateQuery()
+
+	// Subscribing with the new query fails once
+	client := &fakeECClient{head: 164, subscribeFailures: 1}
+	e.client = client
+	e.events = make(chan types.Log, 32)
+	e.newHeaders = make(chan *types.Header, 32)
+	oldHeaders := newFakeSubscription()
+	subs := &subscriptions{logs: newFakeSubscription(), headers: oldHeaders}
+
+	newMinipoolManager := common.HexToAddress("0xdddddddddddddddddddddddddddddddddddddddd")
+	chain.contracts["rocketMinipoolManager"] = newMinipoolManager
+	minipoolAddr := common.HexToAddress("0x0909090909090909090909090909090909090909")
+	pubkey, _ := e.chain.minipoolPubkey(minipoolAddr, nil)
+	event := minipoolCreatedLog(e, minipoolAddr, testNode1, 140)
+	event.Address = newMinipoolManager
+	client.logs = []types.Log{event}
+
+	// Rather than giving up, the connection is re-established, and the new contract's events are still backfilled
+	subs = e.checkContractUpgrades(context.Background(), big.NewInt(164), subs)
+	if subs == nil {
+		t.Fatal("expected new subscriptions")
+	}
+	defer subs.unsubscribe()
+	if !oldHeaders.unsubscribed.Load() {
+		t.Fatal("expected the old subscriptions to be closed")
+	}
+	if client.subscribeAttempts.Load() != 2 || len(client.subs) != 1 {
+		t.Fatalf("expected to resubscribe on the second attempt, got %d attempts", client.subscribeAttempts.Load())
+	}
+	if got := testutil.ToFloat64(e.m.Counter("contract_upgrade_resubscribe_failed")); got != 1 {
+		t.Fatalf("expected 1 failed resubscription, got %v", got)
+	}
+	if feeRecipient, _ := e.ValidatorFeeRecipient(context.Background(), pubkey, &testNode1); feeRecipient == nil {
+		t.Fatal("expected the minipool from the new contract to be indexed")
+	}
+}
+
+func TestBackfillRetry(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	oldWait := backfillRetryWait
+	backfillRetryWait = time.Millisecond
+	defer func() {
+		backfillRetryWait = oldWait
+	}()
+
+	e.cache.setHighestBlock(big.NewInt(100))
+	client := &fakeECClient{head: 3500, failCall: 2}
+	e.client = client
+	e.BackfillChunkSize = 1000
+
+	// The retry window has already elapsed, so the first failure marks the cache stale
+	e.BackfillRetryWindow = 0
+	attempts := 0
+	staleDuringRetry := false
+	e.backfillWithRetry(context.Background(), func() error {
+		attempts++
+		if attempts == 2 {
+			staleDuringRetry = e.isStale(3500, 3500)
+		}
+		return e.backfillEvents()
+	})
+
+	if e.cache.getHighestBlock().Uint64() != 3500 {
+		t.Fatalf("expected highest block 3500, got %d", e.cache.getHighestBlock().Uint64())
+	}
+
+	// The retry resumed where the failed attempt left off
+	expected := [][2]uint64{{101, 1100}, {1101, 2100}, {1101, 2100}, {2101, 3100}, {3101, 3500}}
+	if fmt.Sprint(client.calls) != fmt.Sprint(expected) {
+		t.Fatalf("expected FilterLogs calls %v, got %v", expected, client.calls)
+	}
+
+	if !staleDuringRetry {
+		t.Fatal("expected the cache to be stale while retrying")
+	}
+	if e.backfillFailed.Load() || e.isStale(3500, 3500) {
+		t.Fatal("expected the cache to be current after a successful backfill")
+	}
+}
+
+func TestReconnectCancel(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	// Make sure the loop is stuck waiting between attempts when it's cancelled
+	oldBackoff := reconnectBackoff
+	reconnectBackoff.Initial = time.Hour
+	defer func() {
+		reconnectBackoff = oldBackoff
+	}()
+
+	client := &fakeECClient{subscribeErr: fmt.Errorf("connection refused")}
+	e.client = client
+	e.events = make(chan types.Log, 32)
+	e.newHeaders = make(chan *types.Header, 32)
+
+	logs := newFakeSubscription()
+	subs := &subscriptions{logs: logs, headers: newFakeSubscription()}
+	e.startEventLoop(subs)
+
+	// Drop the connection, and wait for the first reconnect attempt to fail
+	logs.err <- fmt.Errorf("websocket: close 1006 (abnormal closure)")
+	for client.subscribeAttempts.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		e.Deinit()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the event loop to exit")
+	}
+}
+
+func TestReconnectAttemptsExhausted(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	oldBackoff := reconnectBackoff
+	reconnectBackoff.Initial = time.Millisecond
+	defer func() {
+		reconnectBackoff = oldBackoff
+	}()
+
+	e.MaxReconnectAttempts = 2
+	e.events = make(chan types.Log, 32)
+	e.newHeaders = make(chan *types.Header, 32)
+	e.cache.setHighestBlock(big.NewInt(100))
+	client := &fakeECClient{head: 100, subscribeErr: fmt.Errorf("connection refused")}
+	e.client = client
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		subs := &subscriptions{logs: newFakeSubscription(), headers: newFakeSubscription()}
+		_, err := e.handleSubscriptionError(ctx, fmt.Errorf("websocket: close 1006 (abnormal closure)"), subs)
+		done <- err
+	}()
+
+	// Reconnecting goes on past MaxReconnectAttempts, with the cache reported as stale in the meantime
+	for client.subscribeAttempts.Load() < 4 {
+		time.Sleep(time.Millisecond)
+	}
+	if !e.isStale(100, 100) {
+		t.Fatal("expected the cache to be stale once the reconnect attempts are exhausted")
+	}
+	if got := testutil.ToFloat64(e.m.Counter("reconnect_attempts_exhausted")); got != 1 {
+		t.Fatalf("expected the attempts to be reported exhausted once, got %v", got)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("expected the reconnect to be cancelled, got %v", err)
+	}
+
+	// Once a reconnect succeeds, the cache is current again
+	e.client = &fakeECClient{head: 100}
+	subs, err := e.handleSubscriptionError(context.Background(), fmt.Errorf("websocket: close 1006 (abnormal closure)"),
+		&subscriptions{logs: newFakeSubscription(), headers: newFakeSubscription()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subs.unsubscribe()
+	if e.isStale(100, 100) {
+		t.Fatal("expected the cache to be current after reconnecting")
+	}
+}
+
+func TestShutdownStress(t *testing.T) {
+	first, chain, teardown := setup(t)
+	defer teardown()
+
+	for i := 0; i < 200; i++ {
+		e := NewExecutionLayer(nil, "", &MapsCache{}, zap.NewNop())
+		// Metrics can only be registered once
+		e.m = first.m
+		e.chain = chain
+		e.rocketNodeManager = first.rocketNodeManager
+		e.rocketMinipoolManager = first.rocketMinipoolManager
+		e.smoothingPool = first.smoothingPool
+		e.client = &fakeECClient{head: 100, feedHeaders: true}
+		if err := e.cache.init(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := e.ecEventsConnect(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Shut down immediately, while headers are still being delivered
+		e.Deinit()
+	}
+}
+
+func TestHeaderTimeout(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	client := &fakeECClient{head: 100}
+	e.client = client
+	e.events = make(chan types.Log, 32)
+	e.newHeaders = make(chan *types.Header, 32)
+	e.HeaderTimeout = 20 * time.Millisecond
+
+	// Neither subscription ever delivers anything, or errors
+	subs := &subscriptions{logs: newFakeSubscription(), headers: newFakeSubscription()}
+	e.startEventLoop(subs)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for client.subscribeAttempts.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the watchdog to reconnect")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	e.Deinit()
+	if !subs.logs.(*fakeSubscription).unsubscribed.Load() || !subs.headers.(*fakeSubscription).unsubscribed.Load() {
+		t.Fatal("expected the dead subscriptions to be closed")
+	}
+}
+
+func TestGetNodeInfo(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := e.GetNodeInfo(testNode1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.InSmoothingPool || info.FeeDistributor != chain.nodes[testNode1].feeDistributor {
+		t.Fatalf("unexpected node info %+v", info)
+	}
+	if len(info.MinipoolPubkeys) != 1 || info.MinipoolPubkeys[0] != testPubkey(0x03) {
+		t.Fatalf("expected the preloaded minipool, got %v", info.MinipoolPubkeys)
+	}
+
+	// New minipools are added to the reverse index, and destroyed ones removed
+	minipoolAddr := common.HexToAddress("0x0909090909090909090909090909090909090909")
+	pubkey, _ := e.chain.minipoolPubkey(minipoolAddr, nil)
+	e.handleEvent(minipoolCreatedLog(e, minipoolAddr, testNode1, 101))
+
+	info, err = e.GetNodeInfo(testNode1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.MinipoolPubkeys) != 2 || info.MinipoolPubkeys[1] != pubkey {
+		t.Fatalf("expected the new minipool to be added, got %v", info.MinipoolPubkeys)
+	}
+
+	e.handleEvent(minipoolDestroyedLog(e, minipoolAddr, testNode1, 102))
+	info, err = e.GetNodeInfo(testNode1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.MinipoolPubkeys) != 1 || info.MinipoolPubkeys[0] != testPubkey(0x03) {
+		t.Fatalf("expected the destroyed minipool to be removed, got %v", info.MinipoolPubkeys)
+	}
+
+	if _, err := e.GetNodeInfo(common.HexToAddress("0x3333333333333333333333333333333333333333")); err == nil {
+		t.Fatal("expected an unknown node not to be found")
+	} else if _, ok := err.(*NotFoundError); !ok {
+		t.Fatalf("expected a NotFoundError, got %v", err)
+	}
+}
+
+func TestGetNodeMinipools(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+	e.cache.setHighestBlock(big.NewInt(100))
+
+	minipools, err := e.GetNodeMinipools(testNode1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(minipools.Pubkeys) != 1 || minipools.Pubkeys[0] != testPubkey(0x03) || minipools.Block != 100 {
+		t.Fatalf("expected the preloaded minipool as of block 100, got %+v", minipools)
+	}
+
+	minipoolAddr := common.HexToAddress("0x0909090909090909090909090909090909090909")
+	pubkey, _ := e.chain.minipoolPubkey(minipoolAddr, nil)
+	e.handleEvent(minipoolCreatedLog(e, minipoolAddr, testNode1, 101))
+	minipools, err = e.GetNodeMinipools(testNode1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(minipools.Pubkeys) != 2 || minipools.Pubkeys[1] != pubkey || minipools.Block != 101 {
+		t.Fatalf("expected the new minipool as of block 101, got %+v", minipools)
+	}
+
+	// Known nodes without minipools have none, rather than not being found
+	newNode := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	if _, err := e.GetNodeMinipools(newNode); err == nil {
+		t.Fatal("expected an unknown node not to be found")
+	} else if _, ok := err.(*NotFoundError); !ok {
+		t.Fatalf("expected a NotFoundError, got %v", err)
+	}
+	chain.addNode(newNode, false)
+	e.handleEvent(nodeRegisteredLog(e, newNode, 102))
+	minipools, err = e.GetNodeMinipools(newNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(minipools.Pubkeys) != 0 || minipools.Block != 102 {
+		t.Fatalf("expected no minipools as of block 102, got %+v", minipools)
+	}
+}
+
+func TestSqliteCacheNodeMinipools(t *testing.T) {
+	_, err := metrics.Init(metricsNamespace(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer metrics.Deinit()
+
+	cache := &SqliteCache{Path: t.TempDir()}
+	if err := cache.init(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := cache.deinit(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	for _, pubkey := range []rptypes.ValidatorPubkey{testPubkey(0x01), testPubkey(0x02)} {
+		if err := cache.addMinipoolNode(pubkey, testNode0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cache.addMinipoolNode(testPubkey(0x03), testNode1); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.removeMinipoolNode(testPubkey(0x01)); err != nil {
+		t.Fatal(err)
+	}
+
+	pubkeys, err := cache.getNodeMinipools(testNode0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pubkeys) != 1 || pubkeys[0] != testPubkey(0x02) {
+		t.Fatalf("unexpected minipools %v", pubkeys)
+	}
+
+	minipools := make(map[rptypes.ValidatorPubkey]common.Address)
+	if err := cache.forEachMinipool(func(pubkey rptypes.ValidatorPubkey, nodeAddr common.Address) bool {
+		minipools[pubkey] = nodeAddr
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(minipools) != 2 || minipools[testPubkey(0x02)] != testNode0 || minipools[testPubkey(0x03)] != testNode1 {
+		t.Fatalf("unexpected minipools %v", minipools)
+	}
+}
+
+func TestMinipoolIndexLookups(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone else's minipool is still a hit
+	e.ValidatorFeeRecipient(context.Background(), testPubkey(0x01), &testNode0)
+	e.ValidatorFeeRecipient(context.Background(), testPubkey(0x03), &testNode0)
+	e.ValidatorFeeRecipient(context.Background(), testPubkey(0xff), &testNode0)
+
+	lookups := e.m.CounterVec("minipool_index_lookups", "result")
+	if got := testutil.ToFloat64(lookups.WithLabelValues("hit")); got != 2 {
+		t.Errorf("expected 2 hits, got %v", got)
+	}
+	if got := testutil.ToFloat64(lookups.WithLabelValues("miss")); got != 1 {
+		t.Errorf("expected 1 miss, got %v", got)
+	}
+}
@@ -0,0 +1,81 @@
+package executionlayer
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+// CacheExport is a copy of the cache's node and minipool indexes, for debugging reports of inconsistencies
+type CacheExport struct {
+	// The version of the proxy which exported the cache
+	Version string `json:"version"`
+	// The highest block for which an event was applied when the export started
+	HighestBlock uint64                        `json:"highest_block"`
+	Nodes        map[common.Address]ExportNode `json:"nodes"`
+	// Each minipool's pubkey and the node which owns it
+	Minipools map[string]common.Address `json:"minipools"`
+}
+
+// ExportNode is a node's entry in the node index
+type ExportNode struct {
+	InSmoothingPool   bool           `json:"smoothing_pool"`
+	FeeDistributor    common.Address `json:"fee_distributor"`
+	WithdrawalAddress common.Address `json:"withdrawal_address"`
+	RPLStake          string         `json:"rpl_stake,omitempty"`
+	Bond              string         `json:"bond,omitempty"`
+	UnknownFields     []string       `json:"unknown_fields,omitempty"`
+}
+
+// ExportCache copies the node and minipool indexes. Like ForEachNodeInfo, nothing is locked for longer than
+// reading one entry, or one shard of the minipool index, so the event loop keeps applying events meanwhile,
+// and those may show up in the export either way. The copy can be serialized at leisure.
+func (e *ExecutionLayer) ExportCache() (*CacheExport, error) {
+	out := &CacheExport{
+		HighestBlock: e.cache.getHighestBlock().Uint64(),
+		Nodes:        make(map[common.Address]ExportNode),
+		Minipools:    make(map[string]common.Address),
+	}
+
+	var nodes []common.Address
+	err := e.cache.forEachNode(func(addr common.Address) bool {
+		nodes = append(nodes, addr)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range nodes {
+		n, err := e.cache.getNodeInfo(addr)
+		if err != nil {
+			if _, ok := err.(*NotFoundError); ok {
+				continue
+			}
+			return nil, err
+		}
+
+		node := ExportNode{
+			InSmoothingPool:   n.inSmoothingPool,
+			FeeDistributor:    n.feeDistributor,
+			WithdrawalAddress: n.withdrawalAddress,
+			UnknownFields:     n.unknownFields.Names(),
+		}
+		if n.rplStake != nil {
+			node.RPLStake = n.rplStake.String()
+		}
+		if n.bond != nil {
+			node.Bond = n.bond.String()
+		}
+		out.Nodes[addr] = node
+	}
+
+	err = e.cache.forEachMinipool(func(pubkey rptypes.ValidatorPubkey, nodeAddr common.Address) bool {
+		out.Minipools[pubkey.String()] = nodeAddr
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
@@ -0,0 +1,62 @@
+package executionlayer
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+)
+
+func TestExportCache(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+	e.cache.setHighestBlock(big.NewInt(100))
+
+	export, err := e.ExportCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if export.HighestBlock != 100 {
+		t.Fatalf("expected highest block 100, got %d", export.HighestBlock)
+	}
+	if len(export.Nodes) != len(chain.nodes) {
+		t.Fatalf("expected %d nodes, got %d", len(chain.nodes), len(export.Nodes))
+	}
+	if !export.Nodes[testNode0].InSmoothingPool || export.Nodes[testNode1].InSmoothingPool {
+		t.Fatalf("unexpected smoothing pool statuses %+v", export.Nodes)
+	}
+	for _, pubkey := range chain.minipools[testNode1] {
+		if export.Minipools[pubkey.String()] != testNode1 {
+			t.Fatalf("expected minipool %s of %s, got %+v", pubkey, testNode1, export.Minipools)
+		}
+	}
+	if len(export.Minipools) != 3 {
+		t.Fatalf("expected 3 minipools, got %d", len(export.Minipools))
+	}
+
+	// The export is a copy, so later events don't change it
+	if err := e.cache.removeNodeInfo(testNode1); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := export.Nodes[testNode1]; !ok {
+		t.Fatal("expected the export not to change with the cache")
+	}
+
+	// Node addresses survive a round trip through json
+	encoded, err := json.Marshal(export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &CacheExport{}
+	if err := json.Unmarshal(encoded, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Nodes[testNode0].FeeDistributor != export.Nodes[testNode0].FeeDistributor || decoded.Minipools[testPubkey(0x01).String()] != testNode0 {
+		t.Fatalf("expected the export to round trip, got %+v", decoded)
+	}
+}
@@ -0,0 +1,269 @@
+package executionlayer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+const defaultFeeDistributorCheckInterval = 24 * time.Hour
+const defaultFeeDistributorCheckSampleSize = 500
+
+// FeeDistributorCheck summarizes a comparison of cached fee distributor addresses against the chain
+type FeeDistributorCheck struct {
+	// The block the chain was read at
+	Block uint64 `json:"block"`
+	// The number of nodes compared
+	Checked int `json:"checked"`
+	// The number of nodes whose cached address didn't match the chain
+	Mismatched int `json:"mismatched"`
+	// The number of mismatched nodes the event loop updated
+	Repaired int `json:"repaired"`
+}
+
+// feeDistributorRepair holds the on-chain fee distributor addresses of mismatched nodes, for the event loop to store
+type feeDistributorRepair struct {
+	block           uint64
+	feeDistributors map[common.Address]common.Address
+	// Receives the number of nodes updated. Buffered, so the event loop never waits on it.
+	repaired chan int
+}
+
+// feeDistributorCheckLoop compares a rolling sample of cached fee distributor addresses
+// against the chain every FeeDistributorCheckInterval until ctx is cancelled
+func (e *ExecutionLayer) feeDistributorCheckLoop(ctx context.Context) {
+	ticker := time.NewTicker(e.FeeDistributorCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := e.checkFeeDistributors(ctx, e.FeeDistributorCheckSampleSize); err != nil && ctx.Err() == nil {
+				e.m.Counter("fee_distributor_check_failed").Inc()
+				e.logger.Warn("Couldn't check cached fee distributors against the chain", zap.Error(err))
+			}
+		}
+	}
+}
+
+// RefetchFeeDistributors reads every cached node's fee distributor address from the chain,
+// and updates any which don't match, eg, after a protocol upgrade changed how they're derived
+func (e *ExecutionLayer) RefetchFeeDistributors(ctx context.Context) (*FeeDistributorCheck, error) {
+	return e.checkFeeDistributors(ctx, 0)
+}
+
+// checkFeeDistributors compares up to sampleSize cached nodes' fee distributor addresses against the chain, or all
+// of them if sampleSize is 0, and has the event loop update any which don't match.
+// Each sample starts after the last node the previous one checked, so over enough runs every node is checked.
+func (e *ExecutionLayer) checkFeeDistributors(ctx context.Context, sampleSize int) (*FeeDistributorCheck, error) {
+	e.feeDistributorCheckLock.Lock()
+	defer e.feeDistributorCheckLock.Unlock()
+
+	out := &FeeDistributorCheck{
+		Block: e.cache.getHighestBlock().Uint64(),
+	}
+
+	var nodes []common.Address
+	err := e.cache.forEachNode(func(addr common.Address) bool {
+		nodes = append(nodes, addr)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return bytes.Compare(nodes[i][:], nodes[j][:]) < 0
+	})
+
+	sample := nodes
+	if sampleSize > 0 && sampleSize < len(nodes) {
+		start := sort.Search(len(nodes), func(i int) bool {
+			return bytes.Compare(nodes[i][:], e.feeDistributorCursor[:]) > 0
+		})
+
+		sample = make([]common.Address, 0, sampleSize)
+		for i := 0; i < sampleSize; i++ {
+			sample = append(sample, nodes[(start+i)%len(nodes)])
+		}
+	}
+	if len(sample) == 0 {
+		return out, nil
+	}
+
+	// Every read is pinned to the cache's block, so events received meanwhile don't look like mismatches
+	opts := &bind.CallOpts{BlockNumber: big.NewInt(0).SetUint64(out.Block), Context: ctx}
+	onChain, err := e.fetchFeeDistributors(sample, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	repair := &feeDistributorRepair{
+		block:           out.Block,
+		feeDistributors: make(map[common.Address]common.Address),
+		repaired:        make(chan int, 1),
+	}
+	for i, addr := range sample {
+		cached, err := e.cache.getNodeInfo(addr)
+		if err != nil {
+			// The node's registration may have been reorged out since it was listed
+			e.logger.Debug("Checked node is no longer cached", zap.String("node", addr.String()), zap.Error(err))
+			continue
+		}
+		out.Checked++
+
+		if cached.feeDistributor == onChain[i] {
+			continue
+		}
+
+		out.Mismatched++
+		repair.feeDistributors[addr] = onChain[i]
+		e.m.Counter("fee_distributor_mismatch").Inc()
+		e.logger.Error("Cached fee distributor doesn't match the chain, its validators' fee recipients may have been checked against the wrong address",
+			zap.String("node", addr.String()),
+			zap.Uint64("block", out.Block),
+			zap.String("cached_fee_distributor", cached.feeDistributor.String()),
+			zap.String("fee_distributor", onChain[i].String()))
+	}
+	e.m.Gauge("fee_distributor_mismatches").Set(float64(out.Mismatched))
+
+	if out.Mismatched > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-e.ctx.Done():
+			return nil, e.ctx.Err()
+		case e.feeDistributorRepairs <- repair:
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-e.ctx.Done():
+			return nil, e.ctx.Err()
+		case out.Repaired = <-repair.repaired:
+		}
+	}
+
+	// Only advance once the sample's been checked, so a failed run is retried from the same place
+	if sampleSize > 0 {
+		e.feeDistributorCursor = sample[len(sample)-1]
+	}
+
+	e.m.Counter("fee_distributor_check_run").Inc()
+	e.logger.Info("Checked cached fee distributors against the chain",
+		zap.Uint64("block", out.Block),
+		zap.Int("checked", out.Checked),
+		zap.Int("mismatched", out.Mismatched),
+		zap.Int("repaired", out.Repaired))
+	return out, nil
+}
+
+// fetchFeeDistributors reads the fee distributor address of each node from the chain, in the same order as nodes.
+// rocketNodeDistributorFactory is re-resolved first, so an upgraded factory is always used.
+func (e *ExecutionLayer) fetchFeeDistributors(nodes []common.Address, opts *bind.CallOpts) ([]common.Address, error) {
+	_, chain, multicall := e.currentConnection()
+
+	out := make([]common.Address, len(nodes))
+	if multicall != nil {
+		factory, err := chain.contract("rocketNodeDistributorFactory", opts)
+		if err != nil {
+			return nil, err
+		}
+
+		if *factory.Address != *multicall.rocketNodeDistributorFactory.Address {
+			e.m.Counter("fee_distributor_factory_changed").Inc()
+			e.logger.Error("rocketNodeDistributorFactory has been upgraded since the cache was preloaded",
+				zap.String("old", multicall.rocketNodeDistributorFactory.Address.String()),
+				zap.String("new", factory.Address.String()))
+
+			// The multicaller is shared, so batch with a copy pointed at the new factory
+			upgraded := *multicall
+			upgraded.rocketNodeDistributorFactory = factory
+			multicall = &upgraded
+		}
+
+		infos, err := multicall.nodeInfos(nodes, opts)
+		if err == nil {
+			for i, n := range infos {
+				out[i] = n.feeDistributor
+			}
+			return out, nil
+		}
+		e.m.Counter("fee_distributor_check_multicall_failed").Inc()
+		e.logger.Debug("Multicall failed while checking fee distributors, reading nodes individually", zap.Error(err))
+	}
+
+	concurrency := e.PreloadConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultPreloadConcurrency
+	}
+
+	var g errgroup.Group
+	g.SetLimit(concurrency)
+	for i, addr := range nodes {
+		i, addr := i, addr
+		g.Go(func() error {
+			if err := callContext(opts).Err(); err != nil {
+				return err
+			}
+
+			feeDistributor, err := chain.feeDistributor(addr, opts)
+			if err != nil {
+				return fmt.Errorf("could not get node %s distributor address: %w", addr.String(), err)
+			}
+			out[i] = feeDistributor
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// applyFeeDistributorRepair stores the on-chain fee distributor addresses of mismatched nodes.
+// Must be called from the event loop.
+func (e *ExecutionLayer) applyFeeDistributorRepair(repair *feeDistributorRepair) {
+	repaired := 0
+
+	for addr, feeDistributor := range repair.feeDistributors {
+		// Events at or after the block the chain was read at may have updated the node since
+		if e.nodeUpdatedBlocks[addr] >= repair.block {
+			e.m.Counter("fee_distributor_repair_skipped").Inc()
+			continue
+		}
+
+		cached, err := e.cache.getNodeInfo(addr)
+		if err != nil {
+			e.logger.Debug("Mismatched node is no longer cached", zap.String("node", addr.String()), zap.Error(err))
+			continue
+		}
+		if cached.feeDistributor == feeDistributor {
+			continue
+		}
+
+		n := cached.clone()
+		n.feeDistributor = feeDistributor
+		err = e.storeNodeInfo(addr, n)
+		if err != nil {
+			e.logger.Error("Failed to add nodeInfo to cache", zap.Error(err))
+			continue
+		}
+		repaired++
+	}
+
+	e.m.Counter("fee_distributor_repaired").Add(float64(repaired))
+	repair.repaired <- repaired
+}
@@ -0,0 +1,123 @@
+package executionlayer
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestFeeDistributorCheck(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+	e.cache.setHighestBlock(big.NewInt(100))
+
+	// An upgrade changed testNode1's distributor without an event
+	upgraded := common.HexToAddress("0xfdfdfdfdfdfdfdfdfdfdfdfdfdfdfdfdfdfdfdfd")
+	chain.nodes[testNode1].feeDistributor = upgraded
+
+	e.FeeDistributorCheckInterval = 0
+	e.startEventLoop(nil)
+	defer e.Deinit()
+
+	// The rolling sample checks one node per run, in address order
+	check, err := e.checkFeeDistributors(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Checked != 1 || check.Mismatched != 0 {
+		t.Fatalf("expected testNode0 to match, got %+v", check)
+	}
+
+	check, err = e.checkFeeDistributors(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Block != 100 || check.Checked != 1 || check.Mismatched != 1 || check.Repaired != 1 {
+		t.Fatalf("expected testNode1 to be repaired, got %+v", check)
+	}
+	if got := testutil.ToFloat64(e.m.Counter("fee_distributor_mismatch")); got != 1 {
+		t.Fatalf("expected 1 mismatch, got %v", got)
+	}
+
+	n, err := e.cache.getNodeInfo(testNode1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.feeDistributor != upgraded {
+		t.Fatalf("expected the repaired distributor, got %s", n.feeDistributor.String())
+	}
+
+	// A full refetch finds nothing left to repair
+	check, err = e.RefetchFeeDistributors(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Checked != 2 || check.Mismatched != 0 {
+		t.Fatalf("expected every node to match, got %+v", check)
+	}
+	if got := testutil.ToFloat64(e.m.Gauge("fee_distributor_mismatches")); got != 0 {
+		t.Fatalf("expected no mismatches left, got %v", got)
+	}
+}
+
+func TestFeeDistributorCheckWraps(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing mismatches, so the event loop isn't needed
+	e.feeDistributorCursor = testNode1
+	check, err := e.checkFeeDistributors(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Checked != 1 || e.feeDistributorCursor != testNode0 {
+		t.Fatalf("expected the sample to wrap around to testNode0, got %+v at %s", check, e.feeDistributorCursor.String())
+	}
+}
+
+func TestFeeDistributorCheckFactoryUpgrade(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	mc := setupMulticall(t, e, chain)
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The factory moved, and the old one no longer answers
+	newFactory := common.HexToAddress("0xefefefefefefefefefefefefefefefefefefefef")
+	mc.targets[newFactory] = mc.targets[testDistributorFactory]
+	delete(mc.targets, testDistributorFactory)
+	chain.contracts["rocketNodeDistributorFactory"] = newFactory
+	chain.abis["rocketNodeDistributorFactory"] = e.multicall.rocketNodeDistributorFactory.ABI
+	chain.failures["feeDistributor"] = fmt.Errorf("unexpected per-node read")
+
+	check, err := e.RefetchFeeDistributors(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Checked != 2 || check.Mismatched != 0 {
+		t.Fatalf("expected every node to be read through the new factory, got %+v", check)
+	}
+	if got := testutil.ToFloat64(e.m.Counter("fee_distributor_factory_changed")); got != 1 {
+		t.Fatalf("expected the factory change to be counted, got %v", got)
+	}
+
+	// The shared multicaller keeps the factory it was set up with
+	if *e.multicall.rocketNodeDistributorFactory.Address != testDistributorFactory {
+		t.Fatal("expected the shared multicaller not to be modified")
+	}
+}
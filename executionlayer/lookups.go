@@ -0,0 +1,440 @@
+package executionlayer
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/rocket-pool/rocketpool-go/rocketpool"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+	"go.uber.org/zap"
+)
+
+const defaultSubscriptionBufferSize = 32
+const defaultLookupWorkers = 4
+const defaultLookupQueueSize = 256
+
+// How long new minipools from the same block wait for the rest of it before they're looked up together
+const minipoolBatchWindow = 50 * time.Millisecond
+
+// The number of times to try a lookup, doubling the wait in between from lookupRetryWait,
+// before leaving it to the catch-up sweep every lookupSweepIntervalBlocks
+const maxLookupAttempts = 8
+const maxLookupRetryWait = 5 * time.Minute
+const lookupSweepIntervalBlocks = 300
+
+// Overridden by tests
+var lookupRetryWait = time.Second
+
+// lookupKind is what a chainLookup reads
+type lookupKind uint8
+
+const (
+	// A new minipool's pubkey
+	minipoolPubkeyLookup lookupKind = iota
+	// A newly registered node's fee distributor address
+	feeDistributorLookup
+	// A newly registered node's enrichment fields
+	enrichmentLookup
+)
+
+// String returns the prefix of the kind's metrics
+func (k lookupKind) String() string {
+	switch k {
+	case feeDistributorLookup:
+		return "fee_distributor"
+	case enrichmentLookup:
+		return "enrichment"
+	}
+	return "minipool"
+}
+
+// description is what the kind reads, for logging
+func (k lookupKind) description() string {
+	switch k {
+	case feeDistributorLookup:
+		return "fee distributor address for newly registered node"
+	case enrichmentLookup:
+		return "withdrawal address, RPL stake and bond for newly registered node"
+	}
+	return "minipool details for new minipool"
+}
+
+type lookupKey struct {
+	kind lookupKind
+	addr common.Address
+}
+
+// chainLookup is something the cache needs to know about a new minipool or node, which is being read from the chain
+type chainLookup struct {
+	kind lookupKind
+	// The minipool or node to read
+	addr common.Address
+	// The minipool's node
+	nodeAddr common.Address
+	block    uint64
+
+	// Set by the event loop if the event is reorged out, or the minipool destroyed, before the lookup completes
+	cancelled bool
+	// The minipool's status, updated by the event loop from status events emitted before the lookup completes
+	status feerecipient.MinipoolStatus
+
+	// The number of failed attempts, and whether they ran out, leaving the lookup to the catch-up sweep
+	attempts int
+	failed   bool
+
+	// Set by the worker
+	pubkey         rptypes.ValidatorPubkey
+	feeDistributor common.Address
+	// Enrichment reads never fail the lookup, the fields which couldn't be read are marked unknown instead
+	enrichment *nodeEnrichment
+	err        error
+}
+
+func (l *chainLookup) key() lookupKey {
+	return lookupKey{kind: l.kind, addr: l.addr}
+}
+
+// lookupBatch is one or more lookups which a worker reads together
+type lookupBatch struct {
+	lookups []*chainLookup
+	// Set if the lookups are minipools to be read in a single multicall through this rocketMinipoolManager
+	minipoolManager *rocketpool.Contract
+}
+
+// run reads the batch's lookups. Minipools batched for a multicall are read one by one if it fails.
+func (b *lookupBatch) run(chain chainReader, multicall *multicallNodeReader, m *metrics.MetricsRegistry) {
+	if b.minipoolManager != nil && multicall != nil {
+		addrs := make([]common.Address, 0, len(b.lookups))
+		for _, lookup := range b.lookups {
+			addrs = append(addrs, lookup.addr)
+		}
+
+		pubkeys, err := multicall.minipoolPubkeys(b.minipoolManager, addrs, nil)
+		if err == nil {
+			for i, lookup := range b.lookups {
+				lookup.pubkey = pubkeys[i]
+			}
+			return
+		}
+		m.Counter("minipool_lookup_multicall_failed").Inc()
+	}
+
+	for _, lookup := range b.lookups {
+		lookup.run(chain, multicall)
+	}
+}
+
+func (l *chainLookup) run(chain chainReader, multicall *multicallNodeReader) {
+	switch l.kind {
+	case minipoolPubkeyLookup:
+		l.pubkey, l.err = chain.minipoolPubkey(l.addr, nil)
+	case feeDistributorLookup:
+		l.feeDistributor, l.err = chain.feeDistributor(l.addr, nil)
+	case enrichmentLookup:
+		l.runEnrichment(chain, multicall)
+	}
+}
+
+// runEnrichment reads a node's enrichment fields at the block of the event which added it
+func (l *chainLookup) runEnrichment(chain chainReader, multicall *multicallNodeReader) {
+	opts := &bind.CallOpts{BlockNumber: new(big.Int).SetUint64(l.block)}
+	l.enrichment = readNodeEnrichment(chain, l.addr, opts)
+
+	minipools, err := chain.nodeMinipools(l.addr, opts)
+	if err != nil {
+		l.enrichment.setBond(nil, err)
+		return
+	}
+	addrs := make([]common.Address, 0, len(minipools))
+	for _, mp := range minipools {
+		addrs = append(addrs, mp.addr)
+	}
+	bonds, err := readBonds(multicall, [][]common.Address{addrs}, opts)
+	l.enrichment.setBond(bonds[0], err)
+}
+
+// startLookups starts the workers which read what new minipools and nodes need from the chain, so the event loop never waits on the EC for them.
+// Until it's called, eg, while the cold cache's missed events are backfilled, lookups are made inline.
+func (e *ExecutionLayer) startLookups() {
+	workers := e.LookupWorkers
+	if workers <= 0 {
+		workers = defaultLookupWorkers
+	}
+	queueSize := e.LookupQueueSize
+	if queueSize <= 0 {
+		queueSize = defaultLookupQueueSize
+	}
+
+	e.lookups = make(chan *lookupBatch, queueSize)
+	e.lookupResults = make(chan *lookupBatch)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for batch := range e.lookups {
+				_, chain, multicall := e.currentConnection()
+				batch.run(chain, multicall, e.m)
+				e.lookupResults <- batch
+			}
+		}()
+	}
+}
+
+// queueLookup applies lookup to the cache once it's been read. Must be called from the event loop.
+func (e *ExecutionLayer) queueLookup(lookup *chainLookup) {
+	// A later event for the same minipool or node, eg, after a reorg, supersedes any earlier one
+	if previous, ok := e.pendingLookups[lookup.key()]; ok && previous != lookup {
+		previous.cancelled = true
+	}
+	e.pendingLookups[lookup.key()] = lookup
+
+	if e.lookups == nil {
+		lookup.run(e.chain, e.multicall)
+		e.applyLookup(lookup)
+		return
+	}
+
+	// With Multicall3, new minipools are read a block at a time, so bursts of them cost a handful of calls
+	if lookup.kind == minipoolPubkeyLookup && e.multicall != nil {
+		e.batchMinipoolLookup(lookup)
+		return
+	}
+
+	e.sendLookups(&lookupBatch{lookups: []*chainLookup{lookup}})
+}
+
+// batchMinipoolLookup holds lookup back until the rest of its block's minipools have been seen, or minipoolBatchWindow
+// passes, then looks them all up together. Must be called from the event loop.
+func (e *ExecutionLayer) batchMinipoolLookup(lookup *chainLookup) {
+	if len(e.minipoolBatch) > 0 && e.minipoolBatch[0].block != lookup.block {
+		e.flushMinipoolLookups()
+	}
+
+	e.minipoolBatch = append(e.minipoolBatch, lookup)
+	if len(e.minipoolBatch) == 1 {
+		e.minipoolBatchDue = time.After(minipoolBatchWindow)
+	}
+	if len(e.minipoolBatch) >= multicallBatchSize {
+		e.flushMinipoolLookups()
+	}
+}
+
+// flushMinipoolLookups sends the batched minipools to the workers. Must be called from the event loop.
+func (e *ExecutionLayer) flushMinipoolLookups() {
+	batch := &lookupBatch{minipoolManager: e.rocketMinipoolManager}
+	for _, lookup := range e.minipoolBatch {
+		// Destroyed or reorged out while it waited
+		if lookup.cancelled {
+			e.m.Counter(lookup.kind.String() + "_lookup_cancelled").Inc()
+			continue
+		}
+		batch.lookups = append(batch.lookups, lookup)
+	}
+	e.minipoolBatch = nil
+	e.minipoolBatchDue = nil
+
+	if len(batch.lookups) == 0 {
+		return
+	}
+	e.m.Counter("minipool_lookup_batches").Inc()
+	e.sendLookups(batch)
+}
+
+// sendLookups queues batch for the workers. Must be called from the event loop.
+func (e *ExecutionLayer) sendLookups(batch *lookupBatch) {
+	select {
+	case e.lookups <- batch:
+	default:
+		// The queue is full, so keep applying the workers' results until there's room
+		e.m.Counter("lookup_queue_full").Inc()
+		for queued := false; !queued; {
+			select {
+			case e.lookups <- batch:
+				queued = true
+			case result := <-e.lookupResults:
+				e.lookupsDone(result)
+			}
+		}
+	}
+
+	e.lookupsInFlight += len(batch.lookups)
+	e.m.Gauge("lookup_queue_depth").Set(float64(e.lookupsInFlight))
+}
+
+// lookupsDone applies a batch of results from the workers. Must be called from the event loop.
+func (e *ExecutionLayer) lookupsDone(batch *lookupBatch) {
+	for _, lookup := range batch.lookups {
+		e.lookupDone(lookup)
+	}
+}
+
+// lookupDone applies a result from the workers. Must be called from the event loop.
+func (e *ExecutionLayer) lookupDone(lookup *chainLookup) {
+	e.lookupsInFlight--
+	e.m.Gauge("lookup_queue_depth").Set(float64(e.lookupsInFlight))
+
+	// Retries are delayed on purpose, so only first attempts show how far behind the workers are
+	if lookup.attempts == 0 {
+		if e.headerBlock > lookup.block {
+			e.m.Gauge("lookup_lag_blocks").Set(float64(e.headerBlock - lookup.block))
+		} else {
+			e.m.Gauge("lookup_lag_blocks").Set(0)
+		}
+	}
+
+	e.applyLookup(lookup)
+}
+
+// cancelLookup stops a lookup from being applied, if it hasn't succeeded yet.
+// Returns whether there was one to cancel. Must be called from the event loop.
+func (e *ExecutionLayer) cancelLookup(kind lookupKind, addr common.Address) bool {
+	key := lookupKey{kind: kind, addr: addr}
+	lookup, ok := e.pendingLookups[key]
+	if !ok {
+		return false
+	}
+
+	lookup.cancelled = true
+	delete(e.pendingLookups, key)
+	return true
+}
+
+// retryLookup tries a lookup again once it's waited long enough after its last failed attempt.
+// Must be called from the event loop.
+func (e *ExecutionLayer) retryLookup(lookup *chainLookup) {
+	if lookup.cancelled {
+		e.m.Counter(lookup.kind.String() + "_lookup_cancelled").Inc()
+		return
+	}
+
+	e.m.Counter(lookup.kind.String() + "_lookup_retry").Inc()
+	e.queueLookup(lookup)
+}
+
+// scheduleLookupRetry sends lookup back to the event loop after a backoff which doubles with each failed attempt
+func (e *ExecutionLayer) scheduleLookupRetry(lookup *chainLookup) time.Duration {
+	wait := maxLookupRetryWait
+	if lookup.attempts < 32 && lookupRetryWait<<(lookup.attempts-1) < maxLookupRetryWait {
+		wait = lookupRetryWait << (lookup.attempts - 1)
+	}
+
+	time.AfterFunc(wait, func() {
+		select {
+		case e.lookupRetries <- lookup:
+		case <-e.ctx.Done():
+		}
+	})
+	return wait
+}
+
+// sweepLookups tries each lookup whose retries ran out once more. Must be called from the event loop.
+func (e *ExecutionLayer) sweepLookups() {
+	var failed []*chainLookup
+	for _, lookup := range e.pendingLookups {
+		if lookup.failed {
+			failed = append(failed, lookup)
+		}
+	}
+
+	for _, lookup := range failed {
+		e.m.Counter(lookup.kind.String() + "_lookup_swept").Inc()
+		e.queueLookup(lookup)
+	}
+}
+
+// applyLookup updates the cache with a lookup's result, or retries it if it failed
+func (e *ExecutionLayer) applyLookup(lookup *chainLookup) {
+	if lookup.cancelled {
+		e.m.Counter(lookup.kind.String() + "_lookup_cancelled").Inc()
+		return
+	}
+
+	if lookup.err != nil {
+		lookup.attempts++
+		if lookup.failed {
+			// The sweep will try again
+			return
+		}
+
+		if lookup.attempts < maxLookupAttempts {
+			wait := e.scheduleLookupRetry(lookup)
+			e.logger.Warn("Error fetching "+lookup.kind.description()+", retrying", zap.String("addr", lookup.addr.String()),
+				zap.Int("attempt", lookup.attempts), zap.Duration("wait", wait), zap.Error(lookup.err))
+			return
+		}
+
+		lookup.failed = true
+		e.m.Counter(lookup.kind.String() + "_lookup_failed").Inc()
+		e.logger.Error("Couldn't fetch "+lookup.kind.description()+", its validators' fee recipients can't be checked until it can be",
+			zap.String("addr", lookup.addr.String()), zap.Int("attempts", lookup.attempts), zap.Error(lookup.err))
+		return
+	}
+
+	delete(e.pendingLookups, lookup.key())
+	if lookup.failed {
+		e.m.Counter(lookup.kind.String() + "_lookup_recovered").Inc()
+		e.logger.Warn("Fetched "+lookup.kind.description()+" after its retries ran out", zap.String("addr", lookup.addr.String()))
+	}
+
+	switch lookup.kind {
+	case minipoolPubkeyLookup:
+		e.applyMinipoolPubkey(lookup)
+	case feeDistributorLookup:
+		e.applyFeeDistributor(lookup)
+	case enrichmentLookup:
+		e.applyEnrichment(lookup)
+	}
+}
+
+// applyMinipoolPubkey adds a new minipool to the index
+func (e *ExecutionLayer) applyMinipoolPubkey(lookup *chainLookup) {
+	err := e.cache.addMinipoolNode(lookup.pubkey, lookup.nodeAddr)
+	if err == nil {
+		err = e.cache.setMinipoolStatus(lookup.pubkey, lookup.addr, lookup.status)
+	}
+	if err != nil {
+		e.logger.Warn("Error updating minipool cache", zap.Error(err))
+	}
+	e.rememberMinipool(lookup.addr, lookup.pubkey, lookup.block)
+	e.m.Counter("minipool_launch_received").Inc()
+	e.logger.Debug("Added new minipool", zap.String("pubkey", lookup.pubkey.String()), zap.String("node", lookup.nodeAddr.String()))
+}
+
+// applyFeeDistributor sets a node's fee distributor address
+func (e *ExecutionLayer) applyFeeDistributor(lookup *chainLookup) {
+	n, err := e.cache.getNodeInfo(lookup.addr)
+	if err != nil {
+		e.logger.Warn("Fetched fee distributor address for node which isn't in the cache", zap.String("node", lookup.addr.String()), zap.Error(err))
+		return
+	}
+
+	// The cached nodeInfo may be read concurrently, so update a copy
+	n = n.clone()
+	n.feeDistributor = lookup.feeDistributor
+	err = e.storeNodeInfo(lookup.addr, n)
+	if err != nil {
+		e.logger.Error("Failed to add nodeInfo to cache", zap.Error(err))
+	}
+	e.logger.Debug("Added fee distributor address for node", zap.String("node", lookup.addr.String()), zap.String("fee_distributor", lookup.feeDistributor.String()))
+}
+
+// finishLookups waits for the workers to complete every lookup already queued, applies them, and stops the workers.
+// Must be called from the event loop, once it's stopped handling events.
+func (e *ExecutionLayer) finishLookups() {
+	if e.lookups == nil {
+		return
+	}
+
+	e.flushMinipoolLookups()
+	close(e.lookups)
+	for e.lookupsInFlight > 0 {
+		e.lookupsDone(<-e.lookupResults)
+	}
+
+	if len(e.pendingLookups) > 0 {
+		e.logger.Warn("Shutting down with minipools or nodes which couldn't be looked up", zap.Int("lookups", len(e.pendingLookups)))
+	}
+}
@@ -0,0 +1,491 @@
+package executionlayer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+// slowChainReader is a fakeChainReader whose minipool lookups hang, like a struggling EC's, until released
+type slowChainReader struct {
+	*fakeChainReader
+	release chan struct{}
+}
+
+func (s *slowChainReader) minipoolPubkey(minipoolAddr common.Address, opts *bind.CallOpts) (rptypes.ValidatorPubkey, error) {
+	<-s.release
+	return s.fakeChainReader.minipoolPubkey(minipoolAddr, opts)
+}
+
+// setupSlowLookups runs the event loop against a chain whose minipool lookups hang until release is closed
+func setupSlowLookups(t *testing.T) (*ExecutionLayer, chan struct{}, func()) {
+	e, chain, teardown := setup(t)
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	e.chain = &slowChainReader{fakeChainReader: chain, release: release}
+	e.client = &fakeECClient{head: 100}
+	e.events = make(chan types.Log, 32)
+	e.newHeaders = make(chan *types.Header, 32)
+	e.LookupWorkers = 1
+	e.startEventLoop(&subscriptions{logs: newFakeSubscription(), headers: newFakeSubscription()})
+
+	return e, release, teardown
+}
+
+// waitForMinipool waits for a minipool's pubkey to be indexed
+func waitForMinipool(t *testing.T, e *ExecutionLayer, pubkey rptypes.ValidatorPubkey) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := e.cache.getMinipoolNode(pubkey); err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s to be indexed", pubkey.String())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSlowMinipoolLookups(t *testing.T) {
+	e, release, teardown := setupSlowLookups(t)
+	defer teardown()
+
+	minipoolAddr := common.HexToAddress("0x0909090909090909090909090909090909090909")
+	pubkey, _ := e.chain.(*slowChainReader).fakeChainReader.minipoolPubkey(minipoolAddr, nil)
+	e.events <- minipoolCreatedLog(e, minipoolAddr, testNode1, 101)
+
+	// Many more events than the subscription buffers are still consumed while the lookup hangs
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for i := 0; i < 10*cap(e.events); i++ {
+			e.events <- spStatusChangedLog(e, testNode1, i%2 == 0, uint64(102+i))
+			e.newHeaders <- &types.Header{Number: big.NewInt(int64(102 + i))}
+		}
+	}()
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected events to be consumed while the minipool lookup is in progress")
+	}
+
+	if _, err := e.cache.getMinipoolNode(pubkey); err == nil {
+		t.Fatal("expected the minipool not to be indexed until it's been looked up")
+	}
+
+	close(release)
+	waitForMinipool(t, e, pubkey)
+	e.Deinit()
+
+	if depth := testutil.ToFloat64(e.m.Gauge("lookup_queue_depth")); depth != 0 {
+		t.Fatalf("expected the lookup queue to be empty, got %v", depth)
+	}
+}
+
+func TestMinipoolLookupCancelled(t *testing.T) {
+	e, release, teardown := setupSlowLookups(t)
+	defer teardown()
+
+	destroyed := common.HexToAddress("0x0909090909090909090909090909090909090909")
+	destroyedPubkey, _ := e.chain.(*slowChainReader).fakeChainReader.minipoolPubkey(destroyed, nil)
+	launched := common.HexToAddress("0x0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a")
+	launchedPubkey, _ := e.chain.(*slowChainReader).fakeChainReader.minipoolPubkey(launched, nil)
+
+	// The first minipool is destroyed before it's looked up, so it's never indexed
+	e.events <- minipoolCreatedLog(e, destroyed, testNode1, 101)
+	e.events <- minipoolDestroyedLog(e, destroyed, testNode1, 102)
+	e.events <- minipoolCreatedLog(e, launched, testNode1, 103)
+
+	// Both lookups are queued or in progress once the second launch is handled
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(e.m.Gauge("lookup_queue_depth")) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected both minipools to be looked up")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	close(release)
+	waitForMinipool(t, e, launchedPubkey)
+	e.Deinit()
+
+	if _, err := e.cache.getMinipoolNode(destroyedPubkey); err == nil {
+		t.Fatal("expected the destroyed minipool not to be indexed")
+	}
+	if cancelled := testutil.ToFloat64(e.m.Counter("minipool_lookup_cancelled")); cancelled != 1 {
+		t.Fatalf("expected 1 lookup to be cancelled, got %v", cancelled)
+	}
+}
+
+func TestMinipoolLookupsFinishOnShutdown(t *testing.T) {
+	e, release, teardown := setupSlowLookups(t)
+	defer teardown()
+
+	// More minipools than there are workers are still being looked up when the proxy shuts down
+	var pubkeys []rptypes.ValidatorPubkey
+	for i := byte(1); i <= 4; i++ {
+		minipoolAddr := common.BytesToAddress([]byte{0x09, i})
+		pubkey, _ := e.chain.(*slowChainReader).fakeChainReader.minipoolPubkey(minipoolAddr, nil)
+		pubkeys = append(pubkeys, pubkey)
+		e.events <- minipoolCreatedLog(e, minipoolAddr, testNode1, 100+uint64(i))
+	}
+
+	time.AfterFunc(10*time.Millisecond, func() {
+		close(release)
+	})
+	e.Deinit()
+
+	for _, pubkey := range pubkeys {
+		if _, err := e.cache.getMinipoolNode(pubkey); err != nil {
+			t.Fatalf("expected %s to be indexed before shutting down, got %v", pubkey.String(), err)
+		}
+	}
+}
+
+// registeredNode is on the chain used by setupFlakyLookups, but only registers once the event loop is running
+var registeredNode = common.HexToAddress("0x3333333333333333333333333333333333333333")
+
+// flakyChainReader is a fakeChainReader whose minipool lookups fail while failures is positive, counting it down each time.
+// Fee distributor lookups do the same with distributorFailures.
+type flakyChainReader struct {
+	*fakeChainReader
+	failures            atomic.Int32
+	distributorFailures atomic.Int32
+}
+
+func (f *flakyChainReader) feeDistributor(nodeAddr common.Address, opts *bind.CallOpts) (common.Address, error) {
+	if f.distributorFailures.Add(-1) >= 0 {
+		return common.Address{}, fmt.Errorf("transient error")
+	}
+	return f.fakeChainReader.feeDistributor(nodeAddr, opts)
+}
+
+func (f *flakyChainReader) minipoolPubkey(minipoolAddr common.Address, opts *bind.CallOpts) (rptypes.ValidatorPubkey, error) {
+	if f.failures.Add(-1) >= 0 {
+		return rptypes.ValidatorPubkey{}, fmt.Errorf("transient error")
+	}
+	return f.fakeChainReader.minipoolPubkey(minipoolAddr, opts)
+}
+
+// setupFlakyLookups runs the event loop against a chain whose first failures minipool lookups fail
+func setupFlakyLookups(t *testing.T, failures int32) (*ExecutionLayer, *flakyChainReader, func()) {
+	e, chain, teardown := setup(t)
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	wait := lookupRetryWait
+	lookupRetryWait = time.Millisecond
+
+	chain.addNode(registeredNode, false)
+	flaky := &flakyChainReader{fakeChainReader: chain}
+	flaky.failures.Store(failures)
+	e.chain = flaky
+	e.client = &fakeECClient{head: 100}
+	e.events = make(chan types.Log, 32)
+	e.newHeaders = make(chan *types.Header, 32)
+	e.startEventLoop(&subscriptions{logs: newFakeSubscription(), headers: newFakeSubscription()})
+
+	return e, flaky, func() {
+		lookupRetryWait = wait
+		teardown()
+	}
+}
+
+func TestMinipoolLookupRetry(t *testing.T) {
+	e, flaky, teardown := setupFlakyLookups(t, 3)
+	defer teardown()
+	defer e.Deinit()
+
+	minipoolAddr := common.HexToAddress("0x0909090909090909090909090909090909090909")
+	pubkey, _ := flaky.fakeChainReader.minipoolPubkey(minipoolAddr, nil)
+	e.events <- minipoolCreatedLog(e, minipoolAddr, testNode1, 101)
+
+	waitForMinipool(t, e, pubkey)
+	if retries := testutil.ToFloat64(e.m.Counter("minipool_lookup_retry")); retries != 3 {
+		t.Fatalf("expected 3 retries, got %v", retries)
+	}
+	if failed := testutil.ToFloat64(e.m.Counter("minipool_lookup_failed")); failed != 0 {
+		t.Fatalf("expected no lookups to fail permanently, got %v", failed)
+	}
+}
+
+func TestMinipoolLookupSweep(t *testing.T) {
+	e, flaky, teardown := setupFlakyLookups(t, maxLookupAttempts)
+	defer teardown()
+	defer e.Deinit()
+
+	minipoolAddr := common.HexToAddress("0x0909090909090909090909090909090909090909")
+	pubkey, _ := flaky.fakeChainReader.minipoolPubkey(minipoolAddr, nil)
+	e.events <- minipoolCreatedLog(e, minipoolAddr, testNode1, 101)
+
+	// Every attempt fails, so the minipool is left for the sweep
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(e.m.Counter("minipool_lookup_failed")) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the lookup to fail permanently")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := e.cache.getMinipoolNode(pubkey); err == nil {
+		t.Fatal("expected the minipool not to be indexed")
+	}
+
+	// Which finds it once the EC recovers
+	e.newHeaders <- &types.Header{Number: big.NewInt(101 + lookupSweepIntervalBlocks)}
+	waitForMinipool(t, e, pubkey)
+	if recovered := testutil.ToFloat64(e.m.Counter("minipool_lookup_recovered")); recovered != 1 {
+		t.Fatalf("expected 1 lookup to be recovered, got %v", recovered)
+	}
+	if failed := testutil.ToFloat64(e.m.Counter("minipool_lookup_failed")); failed != 1 {
+		t.Fatalf("expected the permanently failed count to stay at 1, got %v", failed)
+	}
+}
+
+func TestMinipoolLookupRetryCancelled(t *testing.T) {
+	e, flaky, teardown := setupFlakyLookups(t, maxLookupAttempts)
+	defer teardown()
+
+	minipoolAddr := common.HexToAddress("0x0909090909090909090909090909090909090909")
+	pubkey, _ := flaky.fakeChainReader.minipoolPubkey(minipoolAddr, nil)
+	event := minipoolCreatedLog(e, minipoolAddr, testNode1, 101)
+	e.events <- event
+
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(e.m.Counter("minipool_lookup_failed")) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the lookup to fail permanently")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Once the launch is reorged out, the sweep has nothing to look up
+	e.events <- removed(event)
+	for testutil.ToFloat64(e.m.Counter("minipool_launch_reverted")) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the launch to be reorged out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	e.newHeaders <- &types.Header{Number: big.NewInt(101 + lookupSweepIntervalBlocks)}
+	e.Deinit()
+
+	if _, err := e.cache.getMinipoolNode(pubkey); err == nil {
+		t.Fatal("expected the reorged minipool not to be indexed")
+	}
+	if swept := testutil.ToFloat64(e.m.Counter("minipool_lookup_swept")); swept != 0 {
+		t.Fatalf("expected nothing to be swept, got %v", swept)
+	}
+}
+
+func TestNodeRegisteredFeeDistributor(t *testing.T) {
+	e, flaky, teardown := setupFlakyLookups(t, 0)
+	defer teardown()
+	defer e.Deinit()
+
+	// The fee distributor can't be read when the node registers, or for some time after
+	flaky.distributorFailures.Store(maxLookupAttempts)
+	minipoolAddr := common.HexToAddress("0x0909090909090909090909090909090909090909")
+	pubkey, _ := flaky.fakeChainReader.minipoolPubkey(minipoolAddr, nil)
+	e.events <- nodeRegisteredLog(e, registeredNode, 101)
+	e.events <- minipoolCreatedLog(e, minipoolAddr, registeredNode, 102)
+	waitForMinipool(t, e, pubkey)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(e.m.Counter("fee_distributor_lookup_failed")) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the fee distributor lookup to fail permanently")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The zero address mustn't be expected in the meantime, or every correct request would be rejected
+	info, err := e.ValidatorFeeRecipient(context.Background(), pubkey, &registeredNode)
+	if !errors.Is(err, feerecipient.ErrInconsistentCache) {
+		t.Fatalf("expected ErrInconsistentCache while the fee distributor is unknown, got %+v, %v", info, err)
+	}
+
+	// Once it can be read, the sweep fills it in
+	e.newHeaders <- &types.Header{Number: big.NewInt(101 + lookupSweepIntervalBlocks)}
+	expected := flaky.fakeChainReader.nodes[registeredNode].feeDistributor
+	for {
+		info, err = e.ValidatorFeeRecipient(context.Background(), pubkey, &registeredNode)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the fee distributor to be looked up, got %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if info.Expected != expected || info.Source != feerecipient.SourceFeeDistributor {
+		t.Fatalf("expected fee distributor %s, got %+v", expected.String(), info)
+	}
+}
+
+// enrichmentChainReader is a fakeChainReader whose withdrawal address reads hang until released, and record their block
+type enrichmentChainReader struct {
+	*fakeChainReader
+	release chan struct{}
+	block   atomic.Int64
+}
+
+func (c *enrichmentChainReader) withdrawalAddress(nodeAddr common.Address, opts *bind.CallOpts) (common.Address, error) {
+	<-c.release
+	c.block.Store(opts.BlockNumber.Int64())
+	return c.fakeChainReader.withdrawalAddress(nodeAddr, opts)
+}
+
+func TestNodeRegisteredEnrichment(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	chain.addNode(registeredNode, false, testPubkey(0x09))
+	setupMulticall(t, e, chain)
+	e.client.(*fakeECClient).head = 100
+	enrichment := &enrichmentChainReader{fakeChainReader: chain, release: make(chan struct{})}
+	e.chain = enrichment
+	e.events = make(chan types.Log, 32)
+	e.newHeaders = make(chan *types.Header, 32)
+	e.startEventLoop(&subscriptions{logs: newFakeSubscription(), headers: newFakeSubscription()})
+	defer e.Deinit()
+
+	// The node is indexed without waiting for its enrichment fields, which are unknown until they're read
+	e.events <- nodeRegisteredLog(e, registeredNode, 101)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		n, err := e.GetNodeInfo(registeredNode)
+		if err == nil {
+			if n.UnknownFields != enrichmentFields {
+				t.Fatalf("expected every enrichment field to be unknown, got %v", n.UnknownFields.Names())
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the node to be indexed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	close(enrichment.release)
+	expected := chain.nodes[registeredNode]
+	for {
+		n, err := e.GetNodeInfo(registeredNode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.UnknownFields == 0 {
+			if n.WithdrawalAddress != expected.withdrawalAddress || n.RPLStake.Cmp(expected.rplStake) != 0 || n.Bond.Cmp(expected.bond) != 0 {
+				t.Fatalf("unexpected enrichment fields %+v", n)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the enrichment fields to be looked up, still missing %v", n.UnknownFields.Names())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// They're read at the block the node registered in
+	if block := enrichment.block.Load(); block != 101 {
+		t.Fatalf("expected the enrichment fields to be read at block 101, got %d", block)
+	}
+}
+
+// countingChainReader is a fakeChainReader which counts its minipool lookups
+type countingChainReader struct {
+	*fakeChainReader
+	minipoolLookups atomic.Int32
+}
+
+func (c *countingChainReader) minipoolPubkey(minipoolAddr common.Address, opts *bind.CallOpts) (rptypes.ValidatorPubkey, error) {
+	c.minipoolLookups.Add(1)
+	return c.fakeChainReader.minipoolPubkey(minipoolAddr, opts)
+}
+
+// minipoolBurst queues 50 minipool launches in a single block, as a deposit pool assignment can, then starts the
+// event loop with Multicall3 available, and waits for all of them to be indexed
+func minipoolBurst(t *testing.T, failMulticall bool) (*ExecutionLayer, *countingChainReader, *fakeMulticall) {
+	e, chain, teardown := setup(t)
+	t.Cleanup(teardown)
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	mc := setupMulticall(t, e, chain)
+	mc.fail = failMulticall
+	e.client.(*fakeECClient).head = 100
+	minipoolManagerABI := mc.targets[testMinipoolManager]
+	e.rocketMinipoolManager.ABI = &minipoolManagerABI
+	counting := &countingChainReader{fakeChainReader: chain}
+	e.chain = counting
+
+	e.events = make(chan types.Log, 64)
+	e.newHeaders = make(chan *types.Header, 32)
+	var pubkeys []rptypes.ValidatorPubkey
+	for i := 0; i < 50; i++ {
+		minipoolAddr := common.BytesToAddress([]byte{0x09, byte(i)})
+		pubkey, _ := chain.minipoolPubkey(minipoolAddr, nil)
+		pubkeys = append(pubkeys, pubkey)
+		created := minipoolCreatedLog(e, minipoolAddr, testNode1, 101)
+		created.Index = uint(i)
+		e.events <- created
+	}
+	e.startEventLoop(&subscriptions{logs: newFakeSubscription(), headers: newFakeSubscription()})
+
+	for _, pubkey := range pubkeys {
+		waitForMinipool(t, e, pubkey)
+	}
+	e.Deinit()
+	return e, counting, mc
+}
+
+func TestMinipoolLookupBurst(t *testing.T) {
+	e, counting, mc := minipoolBurst(t, false)
+
+	if mc.aggregates != 1 {
+		t.Fatalf("expected the burst to be read in a single multicall, got %d", mc.aggregates)
+	}
+	if lookups := counting.minipoolLookups.Load(); lookups != 0 {
+		t.Fatalf("expected no minipools to be read individually, got %d", lookups)
+	}
+	if batches := testutil.ToFloat64(e.m.Counter("minipool_lookup_batches")); batches != 1 {
+		t.Fatalf("expected 1 batch, got %v", batches)
+	}
+}
+
+func TestMinipoolLookupBurstMulticallFailed(t *testing.T) {
+	e, counting, mc := minipoolBurst(t, true)
+
+	// The batch falls back to reading each minipool once, on a single worker
+	if mc.aggregates != 1 {
+		t.Fatalf("expected a single multicall attempt, got %d", mc.aggregates)
+	}
+	if lookups := counting.minipoolLookups.Load(); lookups != 50 {
+		t.Fatalf("expected each minipool to be read once, got %d", lookups)
+	}
+	if failed := testutil.ToFloat64(e.m.Counter("minipool_lookup_multicall_failed")); failed != 1 {
+		t.Fatalf("expected 1 failed multicall, got %v", failed)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"math/big"
 	"sync"
+	"sync/atomic"
 
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
 	"github.com/ethereum/go-ethereum/common"
 	rptypes "github.com/rocket-pool/rocketpool-go/types"
 )
@@ -16,51 +18,125 @@ type MapsCache struct {
 	// the smoothing pool, no further validation is needed, so we can exit early based
 	// on membership in this map.
 	//
-	// Since this index is expected to strictly grow, we can use sync.Map to deal with
-	// concurrent access. Elements are only inserted, never deleted.
-	minipoolIndex *sync.Map
+	// There are tens of thousands of minipools, so rather than a sync.Map, this is a sharded map
+	// which stores pubkeys and addresses inline. Elements are only deleted when the event that
+	// added them is reorged out.
+	minipoolIndex *minipoolIndex
+
+	// The reverse of minipoolIndex, node address->[]pubkey.
+	// Like nodeIndex, the slices are never modified after they're stored.
+	// Writes come only from the preload and the event loop, which never run concurrently.
+	nodeMinipoolIndex *sync.Map
 
 	// We need to store each node's smoothing pool status and fee recipient address.
 	// We will subscribe to rocketNodeManager's events stream, which will notify us of
 	// changes- to keep map contention down, we will use pointers as elements.
 	// Ergo, this is a map of node address -> *Node
+	//
+	// The pointed-to nodeInfo is never modified after it's stored. Updates store a new pointer.
 	nodeIndex *sync.Map
 
+	// An index of pubkey->minipool status, and of the minipool contract address->pubkey, since status
+	// events are emitted by the minipools themselves. Elements are kept after their minipool is destroyed,
+	// which is harmless, since the status of a pubkey which isn't in minipoolIndex is never asked for.
+	minipoolStatusIndex  *sync.Map
+	minipoolAddressIndex *sync.Map
+
+	// An index of pubkey->withdrawal address for validators with 0x01 withdrawal credentials.
+	// Those credentials can't be changed, so elements are never updated.
+	withdrawalIndex *sync.Map
+
 	// We need to detect gaps in the event stream when there are connection issues, and
 	// backfill missing data, so we keep track of the highest block for which we received
-	// an event here.
-	highestBlock *big.Int
+	// an event here. It's read and written from several goroutines, so it's atomic.
+	highestBlock atomic.Uint64
 }
 
 func (m *MapsCache) init() error {
 
-	m.minipoolIndex = &sync.Map{}
+	m.minipoolIndex = newMinipoolIndex()
+	m.nodeMinipoolIndex = &sync.Map{}
 	m.nodeIndex = &sync.Map{}
-	m.highestBlock = big.NewInt(0)
+	m.minipoolStatusIndex = &sync.Map{}
+	m.minipoolAddressIndex = &sync.Map{}
+	m.withdrawalIndex = &sync.Map{}
+	m.highestBlock.Store(0)
 	return nil
 }
 
 func (m *MapsCache) getMinipoolNode(pubkey rptypes.ValidatorPubkey) (common.Address, error) {
 
-	void, ok := m.minipoolIndex.Load(pubkey)
+	nodeAddr, ok := m.minipoolIndex.load(pubkey)
 	if !ok {
 		return common.Address{}, &NotFoundError{}
 	}
 
-	nodeAddr, ok := void.(common.Address)
-	if !ok {
-		return common.Address{}, fmt.Errorf("could not convert cache result into common.address")
-	}
-
 	return nodeAddr, nil
 }
 
 func (m *MapsCache) addMinipoolNode(pubkey rptypes.ValidatorPubkey, nodeAddr common.Address) error {
 
-	m.minipoolIndex.Store(pubkey, nodeAddr)
+	previous, loaded := m.minipoolIndex.store(pubkey, nodeAddr)
+	if loaded && previous == nodeAddr {
+		return nil
+	}
+
+	if loaded {
+		m.removeNodeMinipool(previous, pubkey)
+	}
+
+	var pubkeys []rptypes.ValidatorPubkey
+	if void, ok := m.nodeMinipoolIndex.Load(nodeAddr); ok {
+		pubkeys = void.([]rptypes.ValidatorPubkey)
+	}
+
+	// Copy, since readers may hold the stored slice
+	updated := make([]rptypes.ValidatorPubkey, len(pubkeys), len(pubkeys)+1)
+	copy(updated, pubkeys)
+	m.nodeMinipoolIndex.Store(nodeAddr, append(updated, pubkey))
+	return nil
+}
+
+func (m *MapsCache) removeMinipoolNode(pubkey rptypes.ValidatorPubkey) error {
+
+	nodeAddr, loaded := m.minipoolIndex.loadAndDelete(pubkey)
+	if loaded {
+		m.removeNodeMinipool(nodeAddr, pubkey)
+	}
 	return nil
 }
 
+func (m *MapsCache) removeNodeMinipool(nodeAddr common.Address, pubkey rptypes.ValidatorPubkey) {
+	void, ok := m.nodeMinipoolIndex.Load(nodeAddr)
+	if !ok {
+		return
+	}
+
+	pubkeys := void.([]rptypes.ValidatorPubkey)
+	updated := make([]rptypes.ValidatorPubkey, 0, len(pubkeys))
+	for _, p := range pubkeys {
+		if p != pubkey {
+			updated = append(updated, p)
+		}
+	}
+
+	if len(updated) == 0 {
+		m.nodeMinipoolIndex.Delete(nodeAddr)
+		return
+	}
+	m.nodeMinipoolIndex.Store(nodeAddr, updated)
+}
+
+func (m *MapsCache) getNodeMinipools(nodeAddr common.Address) ([]rptypes.ValidatorPubkey, error) {
+
+	void, ok := m.nodeMinipoolIndex.Load(nodeAddr)
+	if !ok {
+		return nil, nil
+	}
+
+	return void.([]rptypes.ValidatorPubkey), nil
+}
+
 func (m *MapsCache) getNodeInfo(nodeAddr common.Address) (*nodeInfo, error) {
 
 	void, ok := m.nodeIndex.Load(nodeAddr)
@@ -82,6 +158,12 @@ func (m *MapsCache) addNodeInfo(nodeAddr common.Address, node *nodeInfo) error {
 	return nil
 }
 
+func (m *MapsCache) removeNodeInfo(nodeAddr common.Address) error {
+
+	m.nodeIndex.Delete(nodeAddr)
+	return nil
+}
+
 func (m *MapsCache) forEachNode(closure ForEachNodeClosure) error {
 	m.nodeIndex.Range(func(k any, value any) bool {
 		return closure(k.(common.Address))
@@ -90,19 +172,84 @@ func (m *MapsCache) forEachNode(closure ForEachNodeClosure) error {
 	return nil
 }
 
-func (m *MapsCache) setHighestBlock(block *big.Int) {
-	if m.highestBlock.Cmp(block) >= 0 {
-		return
+func (m *MapsCache) forEachMinipool(closure ForEachMinipoolClosure) error {
+	m.minipoolIndex.forEach(closure)
+	return nil
+}
+
+func (m *MapsCache) getMinipoolStatus(pubkey rptypes.ValidatorPubkey) (feerecipient.MinipoolStatus, error) {
+
+	void, ok := m.minipoolStatusIndex.Load(pubkey)
+	if !ok {
+		return feerecipient.MinipoolStatusUnknown, &NotFoundError{}
+	}
+
+	return void.(feerecipient.MinipoolStatus), nil
+}
+
+func (m *MapsCache) getMinipoolPubkey(minipoolAddr common.Address) (rptypes.ValidatorPubkey, error) {
+
+	void, ok := m.minipoolAddressIndex.Load(minipoolAddr)
+	if !ok {
+		return rptypes.ValidatorPubkey{}, &NotFoundError{}
+	}
+
+	return void.(rptypes.ValidatorPubkey), nil
+}
+
+func (m *MapsCache) setMinipoolStatus(pubkey rptypes.ValidatorPubkey, minipoolAddr common.Address, status feerecipient.MinipoolStatus) error {
+
+	m.minipoolAddressIndex.Store(minipoolAddr, pubkey)
+	m.minipoolStatusIndex.Store(pubkey, status)
+	return nil
+}
+
+func (m *MapsCache) getWithdrawalAddress(pubkey rptypes.ValidatorPubkey) (common.Address, error) {
+
+	void, ok := m.withdrawalIndex.Load(pubkey)
+	if !ok {
+		return common.Address{}, &NotFoundError{}
+	}
+
+	addr, ok := void.(common.Address)
+	if !ok {
+		return common.Address{}, fmt.Errorf("could not convert cache result into common.address")
 	}
 
-	// Someone else owns this pointer, so make a new one
-	m.highestBlock = big.NewInt(0)
-	m.highestBlock.Add(block, m.highestBlock)
+	return addr, nil
+}
+
+func (m *MapsCache) addWithdrawalAddress(pubkey rptypes.ValidatorPubkey, addr common.Address) error {
+
+	m.withdrawalIndex.Store(pubkey, addr)
+	return nil
+}
+
+func (m *MapsCache) countNodes() (int, error) {
+	return countSyncMap(m.nodeIndex), nil
+}
+
+func (m *MapsCache) countMinipools() (int, error) {
+	return m.minipoolIndex.len(), nil
+}
+
+// sync.Map doesn't track its length, so count by ranging over it
+func countSyncMap(sm *sync.Map) int {
+	count := 0
+	sm.Range(func(k any, value any) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+func (m *MapsCache) setHighestBlock(block *big.Int) {
+	storeHighestBlock(&m.highestBlock, block)
 }
 
 func (m *MapsCache) getHighestBlock() *big.Int {
 
-	return m.highestBlock
+	return big.NewInt(0).SetUint64(m.highestBlock.Load())
 }
 
 func (m *MapsCache) deinit() error {
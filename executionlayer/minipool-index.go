@@ -0,0 +1,101 @@
+package executionlayer
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+const minipoolIndexShards = 256
+
+type minipoolIndexShard struct {
+	sync.RWMutex
+	nodes map[rptypes.ValidatorPubkey]common.Address
+}
+
+// minipoolIndex maps minipool pubkeys to node addresses. Keys and values are stored inline,
+// rather than boxed in interfaces like a sync.Map's, and lookups only contend with writes to the
+// same shard, which are rare since the index almost strictly grows.
+//
+// Shards are picked by a pubkey's last byte. The first carries the BLS compression flags,
+// so only a quarter of its values ever occur.
+type minipoolIndex struct {
+	shards [minipoolIndexShards]minipoolIndexShard
+}
+
+func newMinipoolIndex() *minipoolIndex {
+	out := &minipoolIndex{}
+	for i := range out.shards {
+		out.shards[i].nodes = make(map[rptypes.ValidatorPubkey]common.Address)
+	}
+	return out
+}
+
+func (i *minipoolIndex) shard(pubkey rptypes.ValidatorPubkey) *minipoolIndexShard {
+	return &i.shards[pubkey[len(pubkey)-1]]
+}
+
+// load returns the node address stored for pubkey, if there is one
+func (i *minipoolIndex) load(pubkey rptypes.ValidatorPubkey) (common.Address, bool) {
+	shard := i.shard(pubkey)
+	shard.RLock()
+	defer shard.RUnlock()
+
+	nodeAddr, ok := shard.nodes[pubkey]
+	return nodeAddr, ok
+}
+
+// store sets pubkey's node address, and returns the one it replaced, if there was one
+func (i *minipoolIndex) store(pubkey rptypes.ValidatorPubkey, nodeAddr common.Address) (common.Address, bool) {
+	shard := i.shard(pubkey)
+	shard.Lock()
+	defer shard.Unlock()
+
+	previous, loaded := shard.nodes[pubkey]
+	shard.nodes[pubkey] = nodeAddr
+	return previous, loaded
+}
+
+// loadAndDelete removes pubkey, and returns the node address it had, if it was there
+func (i *minipoolIndex) loadAndDelete(pubkey rptypes.ValidatorPubkey) (common.Address, bool) {
+	shard := i.shard(pubkey)
+	shard.Lock()
+	defer shard.Unlock()
+
+	nodeAddr, loaded := shard.nodes[pubkey]
+	delete(shard.nodes, pubkey)
+	return nodeAddr, loaded
+}
+
+// len returns the number of pubkeys in the index. It isn't a snapshot, since each shard is counted in turn.
+func (i *minipoolIndex) len() int {
+	count := 0
+	for s := range i.shards {
+		shard := &i.shards[s]
+		shard.RLock()
+		count += len(shard.nodes)
+		shard.RUnlock()
+	}
+	return count
+}
+
+// forEach calls closure with each pubkey and its node address, until it returns false. Each shard is
+// copied before closure sees it, so closure may use the index, and writes aren't held up meanwhile.
+func (i *minipoolIndex) forEach(closure ForEachMinipoolClosure) {
+	for s := range i.shards {
+		shard := &i.shards[s]
+		shard.RLock()
+		nodes := make(map[rptypes.ValidatorPubkey]common.Address, len(shard.nodes))
+		for pubkey, nodeAddr := range shard.nodes {
+			nodes[pubkey] = nodeAddr
+		}
+		shard.RUnlock()
+
+		for pubkey, nodeAddr := range nodes {
+			if !closure(pubkey, nodeAddr) {
+				return
+			}
+		}
+	}
+}
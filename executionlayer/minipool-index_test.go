@@ -0,0 +1,223 @@
+package executionlayer
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"math/rand"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/rocket-pool/rocketpool-go/rocketpool"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+	"go.uber.org/zap"
+)
+
+// About as many minipools as mainnet has
+const benchmarkMinipools = 40000
+
+// randomPubkeys returns n distinct pubkeys, which like real ones have the BLS compression flag set
+func randomPubkeys(n int) []rptypes.ValidatorPubkey {
+	r := rand.New(rand.NewSource(1))
+	out := make([]rptypes.ValidatorPubkey, n)
+	for i := range out {
+		r.Read(out[i][:])
+		out[i][0] = 0x80 | out[i][0]&0x3f
+	}
+	return out
+}
+
+// randomNode returns the node the ith of randomPubkeys belongs to. Each has two minipools.
+func randomNode(i int) common.Address {
+	return common.BigToAddress(big.NewInt(int64(i/2 + 1)))
+}
+
+func TestMinipoolIndex(t *testing.T) {
+	index := newMinipoolIndex()
+	pubkeys := randomPubkeys(10000)
+	for i, pubkey := range pubkeys {
+		if _, loaded := index.store(pubkey, randomNode(i)); loaded {
+			t.Fatalf("expected %s not to be in the index yet", pubkey.String())
+		}
+	}
+	if index.len() != len(pubkeys) {
+		t.Fatalf("expected %d pubkeys, got %d", len(pubkeys), index.len())
+	}
+
+	for i, pubkey := range pubkeys {
+		if nodeAddr, ok := index.load(pubkey); !ok || nodeAddr != randomNode(i) {
+			t.Fatalf("unexpected node %s for %s", nodeAddr.String(), pubkey.String())
+		}
+	}
+	if _, ok := index.load(testPubkey(0x01)); ok {
+		t.Fatal("expected an unknown pubkey not to be found")
+	}
+
+	// Storing again replaces the node
+	if previous, loaded := index.store(pubkeys[0], testNode1); !loaded || previous != randomNode(0) {
+		t.Fatalf("expected the previous node to be returned, got %s", previous.String())
+	}
+	if nodeAddr, _ := index.load(pubkeys[0]); nodeAddr != testNode1 {
+		t.Fatalf("expected the node to be replaced, got %s", nodeAddr.String())
+	}
+
+	if nodeAddr, loaded := index.loadAndDelete(pubkeys[0]); !loaded || nodeAddr != testNode1 {
+		t.Fatalf("expected the deleted node to be returned, got %s", nodeAddr.String())
+	}
+	if _, loaded := index.loadAndDelete(pubkeys[0]); loaded {
+		t.Fatal("expected a deleted pubkey to be gone")
+	}
+	if index.len() != len(pubkeys)-1 {
+		t.Fatalf("expected %d pubkeys, got %d", len(pubkeys)-1, index.len())
+	}
+
+	// Real pubkeys are spread across every shard
+	for s := range index.shards {
+		if len(index.shards[s].nodes) == 0 {
+			t.Fatalf("expected shard %d to be used", s)
+		}
+	}
+}
+
+func TestMinipoolIndexConcurrency(t *testing.T) {
+	index := newMinipoolIndex()
+	pubkeys := randomPubkeys(1000)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i, pubkey := range pubkeys {
+				if i%4 == w {
+					index.store(pubkey, randomNode(i))
+				}
+				index.load(pubkeys[len(pubkeys)-1-i])
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if index.len() != len(pubkeys) {
+		t.Fatalf("expected %d pubkeys, got %d", len(pubkeys), index.len())
+	}
+}
+
+// heapBytes returns how much is allocated on the heap, once garbage has been collected
+func heapBytes() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// benchmarkIndex reports how much memory a benchmarkMinipools-sized index takes, and how long lookups in it take.
+// minipoolIndex is compared against the sync.Map it replaced.
+func benchmarkIndex(b *testing.B, build func([]rptypes.ValidatorPubkey) func(rptypes.ValidatorPubkey) (common.Address, bool)) {
+	pubkeys := randomPubkeys(benchmarkMinipools)
+
+	before := heapBytes()
+	load := build(pubkeys)
+	after := heapBytes()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, ok := load(pubkeys[i%len(pubkeys)]); !ok {
+				b.Fatal("expected the pubkey to be found")
+			}
+			i++
+		}
+	})
+	b.StopTimer()
+	runtime.KeepAlive(load)
+
+	// ResetTimer discards metrics, so this is reported last
+	b.ReportMetric(float64(after-before)/benchmarkMinipools, "B/minipool")
+}
+
+func BenchmarkMinipoolIndex(b *testing.B) {
+	b.Run("sharded", func(b *testing.B) {
+		benchmarkIndex(b, func(pubkeys []rptypes.ValidatorPubkey) func(rptypes.ValidatorPubkey) (common.Address, bool) {
+			index := newMinipoolIndex()
+			for i, pubkey := range pubkeys {
+				index.store(pubkey, randomNode(i))
+			}
+			return index.load
+		})
+	})
+
+	b.Run("sync.Map", func(b *testing.B) {
+		benchmarkIndex(b, func(pubkeys []rptypes.ValidatorPubkey) func(rptypes.ValidatorPubkey) (common.Address, bool) {
+			index := &sync.Map{}
+			for i, pubkey := range pubkeys {
+				index.Store(pubkey, randomNode(i))
+			}
+			return func(pubkey rptypes.ValidatorPubkey) (common.Address, bool) {
+				void, ok := index.Load(pubkey)
+				if !ok {
+					return common.Address{}, false
+				}
+				return void.(common.Address), true
+			}
+		})
+	})
+}
+
+// BenchmarkValidatorFeeRecipient measures the hot path of every guarded request, against an index of mainnet's size
+func BenchmarkValidatorFeeRecipient(b *testing.B) {
+	_, err := metrics.Init("execution_layer_test_" + strings.ReplaceAll(b.Name(), "/", "_"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(metrics.Deinit)
+
+	e := NewExecutionLayer(nil, "", &MapsCache{}, zap.NewNop())
+	e.smoothingPool = &rocketpool.Contract{Address: &testSmoothingPool}
+	if err := e.cache.init(); err != nil {
+		b.Fatal(err)
+	}
+	pubkeys := randomPubkeys(benchmarkMinipools)
+	for i, pubkey := range pubkeys {
+		node := randomNode(i)
+		if err := e.cache.addNodeInfo(node, &nodeInfo{inSmoothingPool: i%4 == 0, feeDistributor: node}); err != nil {
+			b.Fatal(err)
+		}
+		if err := e.cache.addMinipoolNode(pubkey, node); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	// Subbenchmarks, unlike the benchmark itself, are run repeatedly, so metrics are only registered once
+	b.Run("minipool", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				if _, err := e.ValidatorFeeRecipient(context.Background(), pubkeys[i%len(pubkeys)], nil); err != nil {
+					b.Fatal(err)
+				}
+				i++
+			}
+		})
+	})
+
+	b.Run("not a minipool", func(b *testing.B) {
+		unknown := testPubkey(0x01)
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := e.ValidatorFeeRecipient(context.Background(), unknown, nil); !errors.Is(err, feerecipient.ErrNotMinipool) {
+					b.Fatalf("expected ErrNotMinipool, got %v", err)
+				}
+			}
+		})
+	})
+}
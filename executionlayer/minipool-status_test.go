@@ -0,0 +1,247 @@
+package executionlayer
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+func minipoolStatusLog(e *ExecutionLayer, minipoolAddr common.Address, status rptypes.MinipoolStatus, block uint64) types.Log {
+	return types.Log{
+		Address:     minipoolAddr,
+		Topics:      []common.Hash{e.minipoolStatusUpdatedTopic, common.BigToHash(big.NewInt(int64(status)))},
+		Data:        make([]byte, 32),
+		BlockNumber: block,
+	}
+}
+
+// expectMinipoolStatus checks the status ValidatorFeeRecipient reports for a minipool
+func expectMinipoolStatus(t *testing.T, e *ExecutionLayer, pubkey rptypes.ValidatorPubkey, status feerecipient.MinipoolStatus) {
+	t.Helper()
+	info, err := e.ValidatorFeeRecipient(context.Background(), pubkey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Status != status {
+		t.Fatalf("expected %s to be %s, got %s", pubkey.String(), status, info.Status)
+	}
+}
+
+func TestMinipoolStatusPreload(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	chain.statuses[fakeMinipoolAddress(testPubkey(0x02))] = rptypes.Dissolved
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	expectMinipoolStatus(t, e, testPubkey(0x01), feerecipient.MinipoolStatusStaking)
+	expectMinipoolStatus(t, e, testPubkey(0x02), feerecipient.MinipoolStatusDissolved)
+	if degraded := testutil.ToFloat64(e.m.Gauge("preload_degraded_minipool_status")); degraded != 0 {
+		t.Fatalf("expected no degraded statuses, got %v", degraded)
+	}
+}
+
+func TestMinipoolStatusPreloadDegraded(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	// Statuses are enrichment reads, so failing them doesn't fail the preload
+	chain.failures["minipoolStatus"] = fmt.Errorf("execution reverted")
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	expectMinipoolStatus(t, e, testPubkey(0x01), feerecipient.MinipoolStatusUnknown)
+	if degraded := testutil.ToFloat64(e.m.Gauge("preload_degraded_minipool_status")); degraded != 3 {
+		t.Fatalf("expected 3 degraded statuses, got %v", degraded)
+	}
+}
+
+func TestMinipoolStatusEvents(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Minipools emit their own status updates
+	minipoolAddr := fakeMinipoolAddress(testPubkey(0x03))
+	withdrawable := minipoolStatusLog(e, minipoolAddr, rptypes.Withdrawable, 101)
+	e.handleEvent(withdrawable)
+	expectMinipoolStatus(t, e, testPubkey(0x03), feerecipient.MinipoolStatusWithdrawable)
+	updated := e.m.CounterVec("minipool_status_updated", "status").WithLabelValues("withdrawable")
+	if count := testutil.ToFloat64(updated); count != 1 {
+		t.Fatalf("expected 1 status update, got %v", count)
+	}
+
+	// Every minipool shares a cursor, so a replayed update is skipped even after another minipool's
+	other := minipoolStatusLog(e, fakeMinipoolAddress(testPubkey(0x01)), rptypes.Dissolved, 102)
+	e.handleEvent(other)
+	e.handleEvent(withdrawable)
+	if skipped := testutil.ToFloat64(e.m.Counter("duplicate_event_skipped")); skipped != 1 {
+		t.Fatalf("expected the replayed update to be skipped, got %v skipped", skipped)
+	}
+	expectMinipoolStatus(t, e, testPubkey(0x01), feerecipient.MinipoolStatusDissolved)
+
+	// Other contracts' events with the same signature are ignored
+	e.handleEvent(minipoolStatusLog(e, common.HexToAddress("0x7777777777777777777777777777777777777777"), rptypes.Dissolved, 103))
+	if ignored := testutil.ToFloat64(e.m.Counter("unknown_contract_event_ignored")); ignored != 1 {
+		t.Fatalf("expected 1 ignored event, got %v", ignored)
+	}
+
+	// A reorged out update is reverted to the status before its block
+	e.handleEvent(removed(withdrawable))
+	expectMinipoolStatus(t, e, testPubkey(0x03), feerecipient.MinipoolStatusStaking)
+	if reverted := testutil.ToFloat64(e.m.Counter("minipool_status_reverted")); reverted != 1 {
+		t.Fatalf("expected 1 reverted update, got %v", reverted)
+	}
+}
+
+func TestMinipoolStatusBeforeLookup(t *testing.T) {
+	e, release, teardown := setupSlowLookups(t)
+	defer teardown()
+
+	minipoolAddr := common.HexToAddress("0x0909090909090909090909090909090909090909")
+	pubkey, _ := e.chain.(*slowChainReader).fakeChainReader.minipoolPubkey(minipoolAddr, nil)
+	e.events <- minipoolCreatedLog(e, minipoolAddr, testNode1, 101)
+	prelaunch := minipoolStatusLog(e, minipoolAddr, rptypes.Prelaunch, 101)
+	prelaunch.Index = 1
+	e.events <- prelaunch
+
+	// The minipool's status changes while its pubkey is still being looked up
+	updated := e.m.CounterVec("minipool_status_updated", "status").WithLabelValues("prelaunch")
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(updated) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the status update to be applied to the pending lookup")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// So it's indexed with the status it changed to
+	close(release)
+	waitForMinipool(t, e, pubkey)
+	e.Deinit()
+	expectMinipoolStatus(t, e, pubkey, feerecipient.MinipoolStatusPrelaunch)
+}
+
+func TestMinipoolStatusBackfill(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+	e.cache.setHighestBlock(big.NewInt(100))
+
+	// A minipool is created and updated in the same block. Its status update is
+	// fetched by a query of its own, but applied after the event which created it.
+	minipoolAddr := common.HexToAddress("0x0909090909090909090909090909090909090909")
+	pubkey, _ := e.chain.minipoolPubkey(minipoolAddr, nil)
+	prelaunch := minipoolStatusLog(e, minipoolAddr, rptypes.Prelaunch, 101)
+	prelaunch.Index = 1
+	client := &fakeECClient{
+		head: 101,
+		logs: []types.Log{prelaunch, minipoolCreatedLog(e, minipoolAddr, testNode1, 101)},
+	}
+	e.client = client
+
+	if err := e.backfillEvents(); err != nil {
+		t.Fatal(err)
+	}
+	expectMinipoolStatus(t, e, pubkey, feerecipient.MinipoolStatusPrelaunch)
+
+	// Only the status updates' query spans every contract
+	if len(client.calls) != 1 || len(client.statusCalls) != 1 {
+		t.Fatalf("expected one query of each kind, got %v and %v", client.calls, client.statusCalls)
+	}
+	if len(e.query.Addresses) != 2 || len(e.statusQuery.Addresses) != 0 {
+		t.Fatalf("expected the manager query to filter by address, got %v", e.query.Addresses)
+	}
+
+	// The queries' logs are interleaved in the order they were emitted
+	client.logs = append(client.logs, spStatusChangedLog(e, testNode1, true, 102))
+	logs, err := filterLogs(context.Background(), client, e.queries(), 101, 102)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 3 || logs[0].Index != 0 || logs[1].Index != prelaunch.Index || logs[2].BlockNumber != 102 {
+		t.Fatalf("expected the logs in order, got %v", logs)
+	}
+}
+
+func TestCombinedSubscription(t *testing.T) {
+	managers := newFakeSubscription()
+	statuses := newFakeSubscription()
+	sub := newCombinedSubscription([]ethereum.Subscription{managers, statuses})
+
+	// Either subscription failing fails the combination
+	statuses.err <- fmt.Errorf("websocket: close 1006 (abnormal closure)")
+	select {
+	case err := <-sub.Err():
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the error")
+	}
+
+	sub.Unsubscribe()
+	if !managers.unsubscribed.Load() || !statuses.unsubscribed.Load() {
+		t.Fatal("expected both subscriptions to be closed")
+	}
+}
+
+func TestSqliteCacheMinipoolStatus(t *testing.T) {
+	_, err := metrics.Init(metricsNamespace(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer metrics.Deinit()
+
+	cache := &SqliteCache{Path: t.TempDir()}
+	if err := cache.init(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := cache.deinit(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	minipoolAddr := fakeMinipoolAddress(testPubkey(0x01))
+	if _, err := cache.getMinipoolStatus(testPubkey(0x01)); err == nil {
+		t.Fatal("expected the status not to be found")
+	}
+	for _, status := range []feerecipient.MinipoolStatus{feerecipient.MinipoolStatusPrelaunch, feerecipient.MinipoolStatusDissolved} {
+		if err := cache.setMinipoolStatus(testPubkey(0x01), minipoolAddr, status); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status, err := cache.getMinipoolStatus(testPubkey(0x01))
+	if err != nil || status != feerecipient.MinipoolStatusDissolved {
+		t.Fatalf("expected dissolved, got %s %v", status, err)
+	}
+	pubkey, err := cache.getMinipoolPubkey(minipoolAddr)
+	if err != nil || pubkey != testPubkey(0x01) {
+		t.Fatalf("expected %s, got %s %v", testPubkey(0x01).String(), pubkey.String(), err)
+	}
+	if _, err := cache.getMinipoolPubkey(testNode0); err == nil {
+		t.Fatal("expected an unknown minipool not to be found")
+	}
+}
@@ -0,0 +1,298 @@
+package executionlayer
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/rocket-pool/rocketpool-go/rocketpool"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+// DefaultMulticallAddr is the address Multicall3 is deployed at on mainnet and most testnets
+const DefaultMulticallAddr = "0xcA11bde05977b3631167028862bE2a173976CA11"
+
+// How many nodes to read in a single multicall. Each node takes two calls.
+const multicallBatchSize = 500
+
+// Only the aggregate3 method of Multicall3 is needed
+const multicall3ABI = `[{"inputs":[{"components":[{"internalType":"address","name":"target","type":"address"},{"internalType":"bool","name":"allowFailure","type":"bool"},{"internalType":"bytes","name":"callData","type":"bytes"}],"internalType":"struct Multicall3.Call3[]","name":"calls","type":"tuple[]"}],"name":"aggregate3","outputs":[{"components":[{"internalType":"bool","name":"success","type":"bool"},{"internalType":"bytes","name":"returnData","type":"bytes"}],"internalType":"struct Multicall3.Result[]","name":"returnData","type":"tuple[]"}],"stateMutability":"payable","type":"function"}]`
+
+// Only the getStatus and getNodeDepositBalance methods of minipools are needed
+const minipoolABI = `[{"inputs":[],"name":"getStatus","outputs":[{"internalType":"enum MinipoolStatus","name":"","type":"uint8"}],"stateMutability":"view","type":"function"},{"inputs":[],"name":"getNodeDepositBalance","outputs":[{"internalType":"uint256","name":"","type":"uint256"}],"stateMutability":"view","type":"function"}]`
+
+// multicallCall mirrors Multicall3.Call3
+type multicallCall struct {
+	Target       common.Address
+	AllowFailure bool
+	CallData     []byte
+}
+
+// multicallResult mirrors Multicall3.Result
+type multicallResult struct {
+	Success    bool
+	ReturnData []byte
+}
+
+// multicaller batches contract reads into single eth_calls to a Multicall3 contract
+type multicaller struct {
+	address common.Address
+	abi     abi.ABI
+	caller  bind.ContractCaller
+}
+
+func newMulticaller(address common.Address, caller bind.ContractCaller) (*multicaller, error) {
+	parsed, err := abi.JSON(strings.NewReader(multicall3ABI))
+	if err != nil {
+		return nil, err
+	}
+
+	return &multicaller{
+		address: address,
+		abi:     parsed,
+		caller:  caller,
+	}, nil
+}
+
+// deployed checks whether the Multicall3 contract exists at opts.BlockNumber
+func (m *multicaller) deployed(opts *bind.CallOpts) (bool, error) {
+	code, err := m.caller.CodeAt(callContext(opts), m.address, opts.BlockNumber)
+	if err != nil {
+		return false, err
+	}
+
+	return len(code) > 0, nil
+}
+
+// aggregate makes all the calls in a single eth_call.
+// Calls which revert fail the whole batch, unless they allow failure, in which case only their result fails.
+func (m *multicaller) aggregate(calls []multicallCall, opts *bind.CallOpts) ([]multicallResult, error) {
+	input, err := m.abi.Pack("aggregate3", calls)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := m.caller.CallContract(callContext(opts), ethereum.CallMsg{To: &m.address, Data: input}, opts.BlockNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	unpacked, err := m.abi.Unpack("aggregate3", output)
+	if err != nil {
+		return nil, err
+	}
+
+	results := *abi.ConvertType(unpacked[0], new([]multicallResult)).(*[]multicallResult)
+	if len(results) != len(calls) {
+		return nil, fmt.Errorf("multicall returned %d results for %d calls", len(results), len(calls))
+	}
+	return results, nil
+}
+
+func callContext(opts *bind.CallOpts) context.Context {
+	if opts.Context == nil {
+		return context.Background()
+	}
+	return opts.Context
+}
+
+// multicallNodeReader reads the essential fields of many nodes, and the statuses of many minipools, at once
+type multicallNodeReader struct {
+	mc                           *multicaller
+	rocketNodeManager            *rocketpool.Contract
+	rocketNodeDistributorFactory *rocketpool.Contract
+	minipool                     abi.ABI
+}
+
+func newMulticallNodeReader(mc *multicaller, rocketNodeManager *rocketpool.Contract, rocketNodeDistributorFactory *rocketpool.Contract) (*multicallNodeReader, error) {
+	parsed, err := abi.JSON(strings.NewReader(minipoolABI))
+	if err != nil {
+		return nil, err
+	}
+
+	return &multicallNodeReader{
+		mc:                           mc,
+		rocketNodeManager:            rocketNodeManager,
+		rocketNodeDistributorFactory: rocketNodeDistributorFactory,
+		minipool:                     parsed,
+	}, nil
+}
+
+// nodeInfos returns nodeInfos with the smoothing pool status and fee distributor set
+// for each node, in the same order as nodeAddrs
+func (r *multicallNodeReader) nodeInfos(nodeAddrs []common.Address, opts *bind.CallOpts) ([]*nodeInfo, error) {
+	out := make([]*nodeInfo, 0, len(nodeAddrs))
+
+	for start := 0; start < len(nodeAddrs); start += multicallBatchSize {
+		end := start + multicallBatchSize
+		if end > len(nodeAddrs) {
+			end = len(nodeAddrs)
+		}
+		batch := nodeAddrs[start:end]
+
+		// Two calls per node, smoothing pool status followed by fee distributor
+		calls := make([]multicallCall, 0, 2*len(batch))
+		for _, addr := range batch {
+			spCall, err := r.rocketNodeManager.ABI.Pack("getSmoothingPoolRegistrationState", addr)
+			if err != nil {
+				return nil, err
+			}
+
+			distributorCall, err := r.rocketNodeDistributorFactory.ABI.Pack("getProxyAddress", addr)
+			if err != nil {
+				return nil, err
+			}
+
+			calls = append(calls,
+				multicallCall{Target: *r.rocketNodeManager.Address, CallData: spCall},
+				multicallCall{Target: *r.rocketNodeDistributorFactory.Address, CallData: distributorCall})
+		}
+
+		results, err := r.mc.aggregate(calls, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, addr := range batch {
+			n := &nodeInfo{}
+
+			err = r.rocketNodeManager.ABI.UnpackIntoInterface(&n.inSmoothingPool, "getSmoothingPoolRegistrationState", results[2*i].ReturnData)
+			if err != nil {
+				return nil, fmt.Errorf("could not get node %s smoothing pool registration status: %w", addr.String(), err)
+			}
+
+			err = r.rocketNodeDistributorFactory.ABI.UnpackIntoInterface(&n.feeDistributor, "getProxyAddress", results[2*i+1].ReturnData)
+			if err != nil {
+				return nil, fmt.Errorf("could not get node %s distributor address: %w", addr.String(), err)
+			}
+
+			out = append(out, n)
+		}
+	}
+
+	return out, nil
+}
+
+// minipoolPubkeys returns the validator pubkeys of minipoolAddrs, in the same order, read through rocketMinipoolManager
+func (r *multicallNodeReader) minipoolPubkeys(rocketMinipoolManager *rocketpool.Contract, minipoolAddrs []common.Address, opts *bind.CallOpts) ([]rptypes.ValidatorPubkey, error) {
+	if opts == nil {
+		opts = &bind.CallOpts{}
+	}
+	out := make([]rptypes.ValidatorPubkey, 0, len(minipoolAddrs))
+
+	for start := 0; start < len(minipoolAddrs); start += multicallBatchSize {
+		end := start + multicallBatchSize
+		if end > len(minipoolAddrs) {
+			end = len(minipoolAddrs)
+		}
+		batch := minipoolAddrs[start:end]
+
+		calls := make([]multicallCall, 0, len(batch))
+		for _, addr := range batch {
+			call, err := rocketMinipoolManager.ABI.Pack("getMinipoolPubkey", addr)
+			if err != nil {
+				return nil, err
+			}
+			calls = append(calls, multicallCall{Target: *rocketMinipoolManager.Address, CallData: call})
+		}
+
+		results, err := r.mc.aggregate(calls, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, addr := range batch {
+			var pubkey []byte
+			err = rocketMinipoolManager.ABI.UnpackIntoInterface(&pubkey, "getMinipoolPubkey", results[i].ReturnData)
+			if err != nil {
+				return nil, fmt.Errorf("could not get minipool %s pubkey: %w", addr.String(), err)
+			}
+			if len(pubkey) != rptypes.ValidatorPubkeyLength {
+				return nil, fmt.Errorf("minipool %s has a %d byte pubkey", addr.String(), len(pubkey))
+			}
+
+			out = append(out, rptypes.BytesToValidatorPubkey(pubkey))
+		}
+	}
+
+	return out, nil
+}
+
+// minipoolCalls calls method on each of minipoolAddrs, and returns the results in the same order.
+// Minipool reads are enrichment reads, so each call may fail on its own without failing the rest of its batch.
+func (r *multicallNodeReader) minipoolCalls(method string, minipoolAddrs []common.Address, opts *bind.CallOpts) ([]multicallResult, error) {
+	call, err := r.minipool.Pack(method)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]multicallResult, 0, len(minipoolAddrs))
+
+	for start := 0; start < len(minipoolAddrs); start += multicallBatchSize {
+		end := start + multicallBatchSize
+		if end > len(minipoolAddrs) {
+			end = len(minipoolAddrs)
+		}
+		batch := minipoolAddrs[start:end]
+
+		calls := make([]multicallCall, 0, len(batch))
+		for _, addr := range batch {
+			calls = append(calls, multicallCall{Target: addr, AllowFailure: true, CallData: call})
+		}
+
+		results, err := r.mc.aggregate(calls, opts)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, results...)
+	}
+
+	return out, nil
+}
+
+// minipoolStatuses returns the statuses of minipoolAddrs, in the same order. A minipool whose call fails
+// has an unknown status.
+func (r *multicallNodeReader) minipoolStatuses(minipoolAddrs []common.Address, opts *bind.CallOpts) ([]feerecipient.MinipoolStatus, error) {
+	results, err := r.minipoolCalls("getStatus", minipoolAddrs, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]feerecipient.MinipoolStatus, 0, len(results))
+	for _, result := range results {
+		var status uint8
+		if !result.Success || r.minipool.UnpackIntoInterface(&status, "getStatus", result.ReturnData) != nil {
+			out = append(out, feerecipient.MinipoolStatusUnknown)
+			continue
+		}
+		out = append(out, toMinipoolStatus(rptypes.MinipoolStatus(status)))
+	}
+
+	return out, nil
+}
+
+// minipoolNodeDeposits returns the ETH each of minipoolAddrs' nodes deposited for it, in the same order.
+// A minipool whose call fails has a nil deposit.
+func (r *multicallNodeReader) minipoolNodeDeposits(minipoolAddrs []common.Address, opts *bind.CallOpts) ([]*big.Int, error) {
+	results, err := r.minipoolCalls("getNodeDepositBalance", minipoolAddrs, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*big.Int, 0, len(results))
+	for _, result := range results {
+		deposit := new(big.Int)
+		if !result.Success || r.minipool.UnpackIntoInterface(&deposit, "getNodeDepositBalance", result.ReturnData) != nil {
+			out = append(out, nil)
+			continue
+		}
+		out = append(out, deposit)
+	}
+
+	return out, nil
+}
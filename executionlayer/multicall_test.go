@@ -0,0 +1,272 @@
+package executionlayer
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rocket-pool/rocketpool-go/rocketpool"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+const testNodeManagerABI = `[{"inputs":[{"name":"_nodeAddress","type":"address"}],"name":"getSmoothingPoolRegistrationState","outputs":[{"name":"","type":"bool"}],"stateMutability":"view","type":"function"}]`
+const testDistributorFactoryABI = `[{"inputs":[{"name":"_nodeAddress","type":"address"}],"name":"getProxyAddress","outputs":[{"name":"","type":"address"}],"stateMutability":"view","type":"function"}]`
+const testMinipoolManagerABI = `[{"inputs":[{"name":"_minipoolAddress","type":"address"}],"name":"getMinipoolPubkey","outputs":[{"name":"","type":"bytes"}],"stateMutability":"view","type":"function"}]`
+
+var (
+	testMulticall          = common.HexToAddress(DefaultMulticallAddr)
+	testDistributorFactory = common.HexToAddress("0xeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee")
+)
+
+// fakeMulticall decodes aggregate3 calls and serves the inner calls from a fakeChainReader
+type fakeMulticall struct {
+	address common.Address
+	abi     abi.ABI
+	targets map[common.Address]abi.ABI
+	// Any other target is taken for a minipool
+	minipool abi.ABI
+	chain    *fakeChainReader
+	fail     bool
+	// Targets whose calls revert on their own
+	reverts map[common.Address]bool
+
+	aggregates int
+}
+
+func mustParseABI(t *testing.T, s string) abi.ABI {
+	out, err := abi.JSON(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func newFakeMulticall(t *testing.T, chain *fakeChainReader) *fakeMulticall {
+	return &fakeMulticall{
+		address: testMulticall,
+		abi:     mustParseABI(t, multicall3ABI),
+		targets: map[common.Address]abi.ABI{
+			testNodeManager:        mustParseABI(t, testNodeManagerABI),
+			testDistributorFactory: mustParseABI(t, testDistributorFactoryABI),
+			testMinipoolManager:    mustParseABI(t, testMinipoolManagerABI),
+		},
+		minipool: mustParseABI(t, minipoolABI),
+		chain:    chain,
+		reverts:  make(map[common.Address]bool),
+	}
+}
+
+func (f *fakeMulticall) call(data []byte) ([]byte, error) {
+	f.aggregates++
+	if f.fail {
+		return nil, fmt.Errorf("execution reverted")
+	}
+
+	method := f.abi.Methods["aggregate3"]
+	args, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, err
+	}
+	calls := *abi.ConvertType(args[0], new([]multicallCall)).(*[]multicallCall)
+
+	results := make([]multicallResult, 0, len(calls))
+	for _, c := range calls {
+		if f.reverts[c.Target] {
+			if !c.AllowFailure {
+				return nil, fmt.Errorf("execution reverted")
+			}
+			results = append(results, multicallResult{Success: false})
+			continue
+		}
+
+		target, ok := f.targets[c.Target]
+		if !ok {
+			target = f.minipool
+		}
+
+		inner, err := target.MethodById(c.CallData[:4])
+		if err != nil {
+			return nil, err
+		}
+
+		innerArgs, err := inner.Inputs.Unpack(c.CallData[4:])
+		if err != nil {
+			return nil, err
+		}
+
+		var value interface{}
+		if inner.Name == "getNodeDepositBalance" {
+			if _, ok := f.chain.failures["minipoolNodeDeposit"]; ok {
+				if !c.AllowFailure {
+					return nil, fmt.Errorf("execution reverted")
+				}
+				results = append(results, multicallResult{Success: false})
+				continue
+			}
+			// Every fake minipool is an 8 ETH one
+			value = big.NewInt(0).Mul(big.NewInt(8), big.NewInt(1e18))
+		} else if inner.Name == "getStatus" {
+			status, err := f.chain.minipoolStatus(c.Target, nil)
+			if err != nil {
+				return nil, err
+			}
+			value = uint8(status)
+		} else if addr := innerArgs[0].(common.Address); inner.Name == "getMinipoolPubkey" {
+			pubkey, err := f.chain.minipoolPubkey(addr, nil)
+			if err != nil {
+				return nil, err
+			}
+			value = pubkey[:]
+		} else {
+			n, ok := f.chain.nodes[addr]
+			if !ok {
+				return nil, fmt.Errorf("execution reverted")
+			}
+
+			switch inner.Name {
+			case "getSmoothingPoolRegistrationState":
+				value = n.inSmoothingPool
+			case "getProxyAddress":
+				value = n.feeDistributor
+			}
+		}
+
+		out, err := inner.Outputs.Pack(value)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, multicallResult{Success: true, ReturnData: out})
+	}
+
+	return method.Outputs.Pack(results)
+}
+
+func setupMulticall(t *testing.T, e *ExecutionLayer, chain *fakeChainReader) *fakeMulticall {
+	mc := newFakeMulticall(t, chain)
+	client := &fakeECClient{multicall: mc}
+	e.client = client
+
+	caller, err := newMulticaller(testMulticall, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodeManagerABI := mc.targets[testNodeManager]
+	distributorFactoryABI := mc.targets[testDistributorFactory]
+	e.multicall, err = newMulticallNodeReader(
+		caller,
+		&rocketpool.Contract{Address: &testNodeManager, ABI: &nodeManagerABI},
+		&rocketpool.Contract{Address: &testDistributorFactory, ABI: &distributorFactoryABI},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mc
+}
+
+func TestMulticallPreload(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	// Enough nodes for three batches
+	for i := 0; i < 2*multicallBatchSize; i++ {
+		chain.addNode(common.BigToAddress(big.NewInt(int64(0x1000+i))), i%2 == 0)
+	}
+	mc := setupMulticall(t, e, chain)
+
+	// The per-node reads must not be used
+	chain.failures["smoothingPoolStatus"] = fmt.Errorf("unexpected per-node read")
+	chain.failures["feeDistributor"] = fmt.Errorf("unexpected per-node read")
+	chain.statuses[fakeMinipoolAddress(testPubkey(0x02))] = rptypes.Dissolved
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Three batches of nodes, and one each of minipool statuses and deposits
+	if mc.aggregates != 5 {
+		t.Fatalf("expected 5 multicalls, got %d", mc.aggregates)
+	}
+	expectMinipoolStatus(t, e, testPubkey(0x01), feerecipient.MinipoolStatusStaking)
+	expectMinipoolStatus(t, e, testPubkey(0x02), feerecipient.MinipoolStatusDissolved)
+
+	for addr, expected := range chain.nodes {
+		n, err := e.cache.getNodeInfo(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n.inSmoothingPool != expected.inSmoothingPool || n.feeDistributor != expected.feeDistributor {
+			t.Errorf("unexpected node info for %s: %+v", addr.String(), n)
+		}
+		if n.unknownFields != 0 || n.bond.Cmp(expected.bond) != 0 {
+			t.Errorf("expected bond %v for %s, got %v with unknown fields %v", expected.bond, addr.String(), n.bond, n.unknownFields.Names())
+		}
+	}
+
+	feeRecipient, _ := e.ValidatorFeeRecipient(context.Background(), testPubkey(0x03), &testNode1)
+	if feeRecipient == nil || feeRecipient.Expected != chain.nodes[testNode1].feeDistributor {
+		t.Fatalf("unexpected fee recipient %v", feeRecipient)
+	}
+}
+
+func TestMulticallFallback(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	mc := setupMulticall(t, e, chain)
+	mc.fail = true
+
+	// A failed multicall should fall back to per-node reads
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	feeRecipient, _ := e.ValidatorFeeRecipient(context.Background(), testPubkey(0x01), &testNode0)
+	if feeRecipient == nil || feeRecipient.Expected != testSmoothingPool {
+		t.Fatalf("unexpected fee recipient %v", feeRecipient)
+	}
+}
+
+func TestMulticallMinipoolStatusReverts(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	mc := setupMulticall(t, e, chain)
+	mc.reverts[fakeMinipoolAddress(testPubkey(0x01))] = true
+
+	// A reverted status read should leave only its own minipool's status unknown
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	expectMinipoolStatus(t, e, testPubkey(0x01), feerecipient.MinipoolStatusUnknown)
+	expectMinipoolStatus(t, e, testPubkey(0x02), feerecipient.MinipoolStatusStaking)
+	if degraded := testutil.ToFloat64(e.m.Gauge("preload_degraded_minipool_status")); degraded != 1 {
+		t.Fatalf("expected 1 degraded status, got %v", degraded)
+	}
+}
+
+func TestMulticallNotDeployed(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	// No contract at the multicall address
+	e.client = &fakeECClient{}
+
+	if err := e.setupMulticall(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.multicall != nil {
+		t.Fatal("expected multicall to be disabled")
+	}
+}
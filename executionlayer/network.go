@@ -0,0 +1,146 @@
+package executionlayer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Network describes the chain the proxy is expected to run against
+type Network struct {
+	Name string
+	// 0 if any chain is acceptable
+	ChainID uint64
+	// Blank if there's no default
+	RocketStorageAddr string
+	// Whether RocketStorageAddr is the only rocketStorage on the network, so a different one is a mistake
+	PinnedRocketStorage bool
+	// No block before this one has a smoothing pool, so a cache warmed up before it would have every node opted out.
+	// 0 if unknown.
+	SmoothingPoolBlock uint64
+	// The default number of blocks to request events for at once while backfilling
+	BackfillChunkSize uint64
+}
+
+var networks = map[string]Network{
+	"mainnet": {
+		Name:                "mainnet",
+		ChainID:             1,
+		RocketStorageAddr:   "0x1d8f8f00cfa6758d7bE78336684788Fb0ee0Fa46",
+		PinnedRocketStorage: true,
+		// The merge, before which validators earned no execution layer rewards for a smoothing pool to hold
+		SmoothingPoolBlock: 15537394,
+		BackfillChunkSize:  defaultBackfillChunkSize,
+	},
+	"holesky": {
+		Name:                "holesky",
+		ChainID:             17000,
+		RocketStorageAddr:   "0x594Fb75D3dc2DFa0150Ad03F99F97817747dd4E1",
+		PinnedRocketStorage: true,
+		// Rocket Pool's events are sparse on holesky, so larger ranges are still small responses
+		BackfillChunkSize: 5000,
+	},
+	// Rocket Pool's development deployments are on holesky, and are redeployed often, so have no default rocketStorage
+	"devnet": {
+		Name:              "devnet",
+		ChainID:           17000,
+		BackfillChunkSize: 10000,
+	},
+	// Private test networks, on any chain
+	"custom": {
+		Name:              "custom",
+		BackfillChunkSize: defaultBackfillChunkSize,
+	},
+}
+
+// NetworkOverrides are explicitly configured settings which take the place of a Network's defaults.
+// Zero values keep the defaults.
+type NetworkOverrides struct {
+	ChainID           uint64
+	RocketStorageAddr string
+	BackfillChunkSize uint64
+}
+
+// LookupNetwork returns the Network with the given name.
+// "custom" matches any chain, and has no default rocketStorage address.
+func LookupNetwork(name string) (Network, error) {
+	network, ok := networks[name]
+	if !ok {
+		return Network{}, fmt.Errorf("unknown network %q, expected mainnet, holesky, devnet or custom", name)
+	}
+	return network, nil
+}
+
+// Override returns n with the overrides applied, or an error if they contradict it, eg, a chain ID other than its own
+func (n Network) Override(overrides NetworkOverrides) (Network, error) {
+	if overrides.ChainID != 0 {
+		if n.ChainID != 0 && overrides.ChainID != n.ChainID {
+			return Network{}, fmt.Errorf("chain ID %d contradicts network %s, which has chain ID %d. Use -network custom for other chains",
+				overrides.ChainID, n.Name, n.ChainID)
+		}
+		n.ChainID = overrides.ChainID
+	}
+
+	if overrides.RocketStorageAddr != "" {
+		if !common.IsHexAddress(overrides.RocketStorageAddr) {
+			return Network{}, fmt.Errorf("invalid rocketStorage address %q", overrides.RocketStorageAddr)
+		}
+		if n.PinnedRocketStorage && common.HexToAddress(overrides.RocketStorageAddr) != common.HexToAddress(n.RocketStorageAddr) {
+			return Network{}, fmt.Errorf("rocketStorage address %s contradicts network %s, whose rocketStorage is %s",
+				overrides.RocketStorageAddr, n.Name, n.RocketStorageAddr)
+		}
+		n.RocketStorageAddr = overrides.RocketStorageAddr
+	}
+	if !common.IsHexAddress(n.RocketStorageAddr) {
+		return Network{}, fmt.Errorf("network %s has no default rocketStorage address, so one is required", n.Name)
+	}
+
+	if overrides.BackfillChunkSize != 0 {
+		n.BackfillChunkSize = overrides.BackfillChunkSize
+	}
+	return n, nil
+}
+
+// CheckPreloadBlock returns an error if the cache can't be warmed up at block on the network
+func (n Network) CheckPreloadBlock(block uint64) error {
+	// 0 means the head
+	if block != 0 && block < n.SmoothingPoolBlock {
+		return fmt.Errorf("block %d is before network %s has a smoothing pool, at block %d", block, n.Name, n.SmoothingPoolBlock)
+	}
+	return nil
+}
+
+// verifyChainID makes sure client is on the expected chain, so a misconfigured endpoint is never used
+func (e *ExecutionLayer) verifyChainID(ctx context.Context, client ecClient) error {
+	if e.Network.ChainID == 0 {
+		return nil
+	}
+
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't get the execution client's chain ID: %w", err)
+	}
+
+	if !chainID.IsUint64() || chainID.Uint64() != e.Network.ChainID {
+		return fmt.Errorf("execution client is on chain ID %s, but network %s has chain ID %d",
+			chainID.String(), e.Network.Name, e.Network.ChainID)
+	}
+
+	return nil
+}
+
+// verifyRocketStorage makes sure there's a contract deployed at the rocketStorage address
+func (e *ExecutionLayer) verifyRocketStorage(ctx context.Context, client ecClient) error {
+	addr := common.HexToAddress(e.rocketStorageAddr)
+	code, err := client.CodeAt(ctx, addr, nil)
+	if err != nil {
+		return fmt.Errorf("couldn't get the code at rocketStorage address %s: %w", addr.String(), err)
+	}
+
+	if len(code) == 0 {
+		return fmt.Errorf("no contract is deployed at rocketStorage address %s", addr.String())
+	}
+
+	return nil
+}
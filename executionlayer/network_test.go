@@ -0,0 +1,80 @@
+package executionlayer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNetworkProfiles(t *testing.T) {
+	for _, name := range []string{"mainnet", "holesky", "devnet", "custom"} {
+		network, err := LookupNetwork(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if network.Name != name || network.BackfillChunkSize == 0 {
+			t.Fatalf("unexpected profile for %s: %+v", name, network)
+		}
+	}
+
+	if _, err := LookupNetwork("prater"); err == nil {
+		t.Fatal("expected an unknown network to be refused")
+	}
+}
+
+func TestNetworkOverride(t *testing.T) {
+	mainnet, _ := LookupNetwork("mainnet")
+	devnet, _ := LookupNetwork("devnet")
+	custom, _ := LookupNetwork("custom")
+	const devnetStorage = "0x4444444444444444444444444444444444444444"
+
+	for _, tc := range []struct {
+		name      string
+		network   Network
+		overrides NetworkOverrides
+		expected  Network
+		err       string
+	}{
+		{"profile defaults", mainnet, NetworkOverrides{}, mainnet, ""},
+		{"same chain ID", mainnet, NetworkOverrides{ChainID: 1}, mainnet, ""},
+		{"wrong chain ID", mainnet, NetworkOverrides{ChainID: 17000}, Network{}, "chain ID 17000 contradicts network mainnet"},
+		// Addresses are compared regardless of their checksum case
+		{"same rocketStorage", mainnet, NetworkOverrides{RocketStorageAddr: strings.ToLower(mainnet.RocketStorageAddr)}, Network{}, ""},
+		{"other rocketStorage", mainnet, NetworkOverrides{RocketStorageAddr: devnetStorage}, Network{}, "contradicts network mainnet"},
+		{"backfill chunk size", mainnet, NetworkOverrides{BackfillChunkSize: 50}, Network{}, ""},
+		{"devnet without rocketStorage", devnet, NetworkOverrides{}, Network{}, "one is required"},
+		{"devnet on another chain", devnet, NetworkOverrides{ChainID: 1, RocketStorageAddr: devnetStorage}, Network{}, "contradicts network devnet"},
+		{"custom chain", custom, NetworkOverrides{ChainID: 1337, RocketStorageAddr: devnetStorage}, Network{
+			Name: "custom", ChainID: 1337, RocketStorageAddr: devnetStorage, BackfillChunkSize: defaultBackfillChunkSize,
+		}, ""},
+		{"invalid rocketStorage", custom, NetworkOverrides{RocketStorageAddr: "0x1234"}, Network{}, "invalid rocketStorage address"},
+	} {
+		got, err := tc.network.Override(tc.overrides)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("%s: expected an error containing %q, got %v", tc.name, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if tc.expected.Name != "" && got != tc.expected {
+			t.Fatalf("%s: expected %+v, got %+v", tc.name, tc.expected, got)
+		}
+		if tc.overrides.BackfillChunkSize != 0 && got.BackfillChunkSize != tc.overrides.BackfillChunkSize {
+			t.Fatalf("%s: expected the backfill chunk size to be overridden, got %d", tc.name, got.BackfillChunkSize)
+		}
+	}
+}
+
+func TestNetworkPreloadBlock(t *testing.T) {
+	mainnet, _ := LookupNetwork("mainnet")
+	if err := mainnet.CheckPreloadBlock(mainnet.SmoothingPoolBlock - 1); err == nil {
+		t.Fatal("expected a preload block before the smoothing pool to be refused")
+	}
+	for _, block := range []uint64{0, mainnet.SmoothingPoolBlock, mainnet.SmoothingPoolBlock + 1} {
+		if err := mainnet.CheckPreloadBlock(block); err != nil {
+			t.Fatalf("expected block %d to be accepted, got %v", block, err)
+		}
+	}
+}
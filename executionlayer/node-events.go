@@ -0,0 +1,106 @@
+package executionlayer
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// How many events a subscriber may fall behind by before it's dropped
+const nodeEventBufferSize = 256
+
+// NodeEventType identifies what happened to a node
+type NodeEventType int
+
+const (
+	// NodeRegistered is emitted when a node registers
+	NodeRegistered NodeEventType = iota
+	// NodeSmoothingPoolStatusChanged is emitted when a node opts in or out of the smoothing pool,
+	// or a change is reorged out
+	NodeSmoothingPoolStatusChanged
+	// NodeRegistrationReverted is emitted when a node's registration is reorged out
+	NodeRegistrationReverted
+)
+
+// NodeEvent describes a change to a node observed by the ExecutionLayer
+type NodeEvent struct {
+	Type            NodeEventType
+	NodeAddress     common.Address
+	InSmoothingPool bool
+	Block           uint64
+}
+
+type nodeEventSubscriber struct {
+	events chan NodeEvent
+	closed bool
+}
+
+// nodeEventSubscribers fans node events out from the event loop to any number of consumers
+type nodeEventSubscribers struct {
+	sync.Mutex
+	subscribers map[*nodeEventSubscriber]struct{}
+}
+
+// SubscribeNodeEvents returns a channel which receives every node event observed from now on,
+// and a function to unsubscribe with.
+//
+// Events are never allowed to block the event loop. If the consumer falls behind by more than
+// nodeEventBufferSize events, it's unsubscribed and the channel is closed, so it can resync with
+// ForEachNode and subscribe again. The channel is also closed when the ExecutionLayer shuts down.
+func (e *ExecutionLayer) SubscribeNodeEvents() (<-chan NodeEvent, func()) {
+	sub := &nodeEventSubscriber{
+		events: make(chan NodeEvent, nodeEventBufferSize),
+	}
+
+	e.nodeEvents.Lock()
+	defer e.nodeEvents.Unlock()
+	if e.nodeEvents.subscribers == nil {
+		e.nodeEvents.subscribers = make(map[*nodeEventSubscriber]struct{})
+	}
+	e.nodeEvents.subscribers[sub] = struct{}{}
+	e.m.Gauge("node_event_subscribers").Set(float64(len(e.nodeEvents.subscribers)))
+
+	return sub.events, func() {
+		e.nodeEvents.Lock()
+		defer e.nodeEvents.Unlock()
+		e.nodeEvents.remove(sub)
+		e.m.Gauge("node_event_subscribers").Set(float64(len(e.nodeEvents.subscribers)))
+	}
+}
+
+// remove unsubscribes and closes a subscriber. The lock must be held.
+func (n *nodeEventSubscribers) remove(sub *nodeEventSubscriber) {
+	if sub.closed {
+		return
+	}
+
+	delete(n.subscribers, sub)
+	close(sub.events)
+	sub.closed = true
+}
+
+// publishNodeEvent sends an event to every subscriber without blocking
+func (e *ExecutionLayer) publishNodeEvent(event NodeEvent) {
+	e.nodeEvents.Lock()
+	defer e.nodeEvents.Unlock()
+
+	for sub := range e.nodeEvents.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			e.m.Counter("node_event_subscriber_dropped").Inc()
+			e.nodeEvents.remove(sub)
+		}
+	}
+	e.m.Gauge("node_event_subscribers").Set(float64(len(e.nodeEvents.subscribers)))
+}
+
+// closeNodeEventSubscribers unsubscribes everyone, so consumers know no more events are coming
+func (e *ExecutionLayer) closeNodeEventSubscribers() {
+	e.nodeEvents.Lock()
+	defer e.nodeEvents.Unlock()
+
+	for sub := range e.nodeEvents.subscribers {
+		e.nodeEvents.remove(sub)
+	}
+}
@@ -0,0 +1,79 @@
+package executionlayer
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestNodeEvents(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	events, unsubscribe := e.SubscribeNodeEvents()
+	defer unsubscribe()
+
+	newNode := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	chain.addNode(newNode, false)
+	e.handleEvent(nodeRegisteredLog(e, newNode, 101))
+	e.handleEvent(spStatusChangedLog(e, newNode, true, 102))
+
+	expected := []NodeEvent{
+		{Type: NodeRegistered, NodeAddress: newNode, InSmoothingPool: false, Block: 101},
+		{Type: NodeSmoothingPoolStatusChanged, NodeAddress: newNode, InSmoothingPool: true, Block: 102},
+	}
+	for _, exp := range expected {
+		event := <-events
+		if event != exp {
+			t.Fatalf("expected event %+v, got %+v", exp, event)
+		}
+	}
+
+	// Unsubscribing closes the channel, and may be repeated
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Fatal("expected the channel to be closed")
+	}
+}
+
+func TestNodeEventsSlowConsumer(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	slow, unsubscribeSlow := e.SubscribeNodeEvents()
+	defer unsubscribeSlow()
+	fast, unsubscribeFast := e.SubscribeNodeEvents()
+	defer unsubscribeFast()
+
+	// The slow consumer never reads, which mustn't block the event loop or the other consumer
+	for i := 0; i <= nodeEventBufferSize; i++ {
+		e.handleEvent(spStatusChangedLog(e, testNode1, i%2 == 0, uint64(101+i)))
+		if event := <-fast; event.Block != uint64(101+i) {
+			t.Fatalf("expected an event for block %d, got %+v", 101+i, event)
+		}
+	}
+
+	// The slow consumer gets the events that fit in its buffer, then finds the channel closed
+	received := 0
+	for range slow {
+		received++
+	}
+	if received != nodeEventBufferSize {
+		t.Fatalf("expected %d events before the slow consumer was dropped, got %d", nodeEventBufferSize, received)
+	}
+
+	e.closeNodeEventSubscribers()
+	if _, ok := <-fast; ok {
+		t.Fatal("expected the channel to be closed on shutdown")
+	}
+}
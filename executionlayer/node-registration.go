@@ -0,0 +1,56 @@
+package executionlayer
+
+import (
+	"context"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+)
+
+// A node which registers after it was looked up can't use the proxy until this passes, so it's kept short
+const unregisteredNodeRecheckInterval = time.Minute
+
+func (e *ExecutionLayer) unregisteredNodeTTL() time.Duration {
+	if e.UnregisteredNodeTTL > 0 {
+		return e.UnregisteredNodeTTL
+	}
+
+	return unregisteredNodeRecheckInterval
+}
+
+// NodeRegistered reports whether nodeAddr is registered with Rocket Pool. Nodes which aren't cached are looked up
+// on chain, since one which registered in the last few blocks may not have been indexed yet, and the chain's answer is
+// remembered for UnregisteredNodeTTL if it's no. A node which is registered will soon be cached, so yeses aren't.
+func (e *ExecutionLayer) NodeRegistered(ctx context.Context, nodeAddr common.Address) (bool, error) {
+	_, err := e.cache.getNodeInfo(nodeAddr)
+	if err == nil {
+		return true, nil
+	}
+	if _, ok := err.(*NotFoundError); !ok {
+		return false, err
+	}
+
+	if checked, ok := e.unregisteredNodes.Load(nodeAddr); ok && time.Since(checked.(time.Time)) < e.unregisteredNodeTTL() {
+		e.m.Counter("unregistered_node_cache_hit").Inc()
+		return false, nil
+	}
+
+	_, chain, _ := e.currentConnection()
+	exists, err := chain.nodeExists(nodeAddr, &bind.CallOpts{Context: ctx})
+	if err != nil {
+		e.m.Counter("node_exists_lookup_failed").Inc()
+		return false, err
+	}
+	e.m.Counter("node_exists_lookups").Inc()
+
+	if !exists {
+		e.unregisteredNodes.Store(nodeAddr, time.Now())
+		return false, nil
+	}
+
+	e.logger.Debug("Node is registered, but hasn't been indexed yet", zap.String("node", nodeAddr.String()))
+	e.unregisteredNodes.Delete(nodeAddr)
+	return true, nil
+}
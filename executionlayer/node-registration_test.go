@@ -0,0 +1,77 @@
+package executionlayer
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNodeRegistered(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	registered := func(nodeAddr common.Address) bool {
+		t.Helper()
+		ok, err := e.NodeRegistered(context.Background(), nodeAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	lookups := func() float64 {
+		return testutil.ToFloat64(e.m.Counter("node_exists_lookups"))
+	}
+
+	// Cached nodes are answered without asking the chain
+	if !registered(testNode0) || lookups() != 0 {
+		t.Fatalf("expected the cached node to be registered without a lookup, got %v lookups", lookups())
+	}
+
+	// A node which registered since the cache was warmed is found on chain
+	newNode := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	chain.addNode(newNode, false)
+	if !registered(newNode) || lookups() != 1 {
+		t.Fatalf("expected the new node to be found on chain, got %v lookups", lookups())
+	}
+
+	// An unknown node is looked up once, then remembered as unregistered
+	unknown := common.HexToAddress("0x4444444444444444444444444444444444444444")
+	for i := 0; i < 3; i++ {
+		if registered(unknown) {
+			t.Fatal("expected the unknown node not to be registered")
+		}
+	}
+	if lookups() != 2 {
+		t.Fatalf("expected the unknown node to be looked up once, got %v lookups", lookups()-1)
+	}
+	if hits := testutil.ToFloat64(e.m.Counter("unregistered_node_cache_hit")); hits != 2 {
+		t.Fatalf("expected 2 cache hits, got %v", hits)
+	}
+
+	// Until the TTL passes, after which it's looked up again, and found if it's registered since
+	chain.addNode(unknown, false)
+	e.unregisteredNodes.Store(unknown, time.Now().Add(-unregisteredNodeRecheckInterval))
+	if !registered(unknown) || lookups() != 3 {
+		t.Fatalf("expected the node to be looked up again once its entry expired, got %v lookups", lookups())
+	}
+
+	// Failed lookups aren't remembered
+	chain.failures["nodeExists"] = fmt.Errorf("EC unavailable")
+	other := common.HexToAddress("0x5555555555555555555555555555555555555555")
+	if _, err := e.NodeRegistered(context.Background(), other); err == nil {
+		t.Fatal("expected the failed lookup to return an error")
+	}
+	if _, ok := e.unregisteredNodes.Load(other); ok {
+		t.Fatal("expected the failed lookup not to be cached")
+	}
+}
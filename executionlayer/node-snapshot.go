@@ -0,0 +1,35 @@
+package executionlayer
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SnapshotNode is a node's entry in a NodeSnapshot
+type SnapshotNode struct {
+	Address         common.Address
+	InSmoothingPool bool
+	FeeDistributor  common.Address
+}
+
+// NodeSnapshot is every node the cache had at one moment
+type NodeSnapshot struct {
+	// In the order they were added to the cache
+	Nodes []SnapshotNode
+	// The highest block for which an event was applied when the snapshot was taken
+	Block uint64
+}
+
+// SnapshotNodes returns every node the cache has, and the highest block for which an event was applied.
+// Unlike ForEachNode, which ranges over the node index while events change it, the list is copied at once,
+// so it doesn't include a node registered after one it misses.
+func (e *ExecutionLayer) SnapshotNodes() *NodeSnapshot {
+	e.nodeCounts.Lock()
+	defer e.nodeCounts.Unlock()
+
+	out := &NodeSnapshot{
+		Nodes: make([]SnapshotNode, len(e.nodeCounts.list)),
+		Block: e.cache.getHighestBlock().Uint64(),
+	}
+	copy(out.Nodes, e.nodeCounts.list)
+	return out
+}
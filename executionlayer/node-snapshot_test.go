@@ -0,0 +1,101 @@
+package executionlayer
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func snapshotAddresses(s *NodeSnapshot) []common.Address {
+	out := make([]common.Address, 0, len(s.Nodes))
+	for _, node := range s.Nodes {
+		out = append(out, node.Address)
+	}
+	return out
+}
+
+func TestSnapshotNodes(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+	e.cache.setHighestBlock(big.NewInt(100))
+	snapshot := e.SnapshotNodes()
+	if len(snapshot.Nodes) != 2 || snapshot.Block != 100 {
+		t.Fatalf("expected the 2 preloaded nodes as of block 100, got %+v", snapshot)
+	}
+
+	newNode := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	chain.addNode(newNode, false)
+	registered := nodeRegisteredLog(e, newNode, 103)
+	e.handleEvent(registered)
+	e.handleEvent(spStatusChangedLog(e, testNode1, true, 104))
+
+	// Registrations are added to the end, and other changes are made in place
+	snapshot = e.SnapshotNodes()
+	addrs := snapshotAddresses(snapshot)
+	if len(addrs) != 3 || addrs[2] != newNode || snapshot.Block != 104 {
+		t.Fatalf("expected %s to be listed last as of block 104, got %+v", newNode.String(), snapshot)
+	}
+	for _, node := range snapshot.Nodes {
+		if node.Address == testNode1 && !node.InSmoothingPool {
+			t.Fatalf("expected %s to have opted in, got %+v", testNode1.String(), node)
+		}
+	}
+
+	// Reorged out registrations are removed
+	e.handleEvent(removed(registered))
+	if addrs := snapshotAddresses(e.SnapshotNodes()); len(addrs) != 2 || addrs[0] != snapshot.Nodes[0].Address || addrs[1] != snapshot.Nodes[1].Address {
+		t.Fatalf("expected %s to be removed, got %v", newNode.String(), addrs)
+	}
+
+	// Earlier snapshots are copies, so they don't change with the cache
+	if len(snapshot.Nodes) != 3 || snapshot.Nodes[2].Address != newNode {
+		t.Fatalf("expected the earlier snapshot to be unchanged, got %+v", snapshot)
+	}
+}
+
+func TestSnapshotNodesConcurrent(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+	preloaded := len(e.SnapshotNodes().Nodes)
+
+	var registered []common.Address
+	for i := 0; i < 500; i++ {
+		registered = append(registered, common.HexToAddress(fmt.Sprintf("0x%040x", 0x10000+i)))
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, addr := range registered {
+			if err := e.storeNodeInfo(addr, &nodeInfo{}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	// Every snapshot taken meanwhile lists a prefix of the registrations: none is missing one registered before one it has
+	for done := false; !done; {
+		addrs := snapshotAddresses(e.SnapshotNodes())[preloaded:]
+		for i, addr := range addrs {
+			if addr != registered[i] {
+				t.Fatalf("expected registration %d to be %s, got %s", i, registered[i].String(), addr.String())
+			}
+		}
+		done = len(addrs) == len(registered)
+	}
+	wg.Wait()
+}
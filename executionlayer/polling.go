@@ -0,0 +1,238 @@
+package executionlayer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const defaultPollInterval = 4 * time.Second
+
+// PollMode controls whether the ExecutionLayer subscribes to the EC or polls it for new events
+type PollMode string
+
+const (
+	// PollModeAuto polls http(s) endpoints, and endpoints which don't support subscriptions
+	PollModeAuto PollMode = "auto"
+	// PollModeAlways polls every endpoint
+	PollModeAlways PollMode = "always"
+	// PollModeNever subscribes to every endpoint
+	PollModeNever PollMode = "never"
+)
+
+// ParsePollMode converts a flag value to a PollMode
+func ParsePollMode(s string) (PollMode, error) {
+	switch mode := PollMode(s); mode {
+	case PollModeAuto, PollModeAlways, PollModeNever:
+		return mode, nil
+	}
+
+	return "", fmt.Errorf("unknown poll mode %q, expected auto, always or never", s)
+}
+
+// pollingSubscription is an ethereum.Subscription which calls poll on an interval until it fails or is unsubscribed.
+// Like ethclient's subscriptions, once Unsubscribe() returns nothing more is sent on the subscription's channel.
+type pollingSubscription struct {
+	err  chan error
+	once sync.Once
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newPollingSubscription(interval time.Duration, poll func(ctx context.Context) error) *pollingSubscription {
+	ctx, cancel := context.WithCancel(context.Background())
+	out := &pollingSubscription{
+		err:    make(chan error, 1),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(out.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if err := poll(ctx); err != nil {
+				if ctx.Err() == nil {
+					out.err <- err
+				}
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+func (p *pollingSubscription) Unsubscribe() {
+	p.once.Do(p.cancel)
+	<-p.done
+}
+
+func (p *pollingSubscription) Err() <-chan error {
+	return p.err
+}
+
+// notificationsUnsupported returns true if err means the EC can't serve subscriptions
+func notificationsUnsupported(err error) bool {
+	return errors.Is(err, rpc.ErrNotificationsUnsupported) || strings.Contains(err.Error(), rpc.ErrNotificationsUnsupported.Error())
+}
+
+// shouldPoll returns true if the current endpoint should be polled without trying to subscribe first
+func (e *ExecutionLayer) shouldPoll() bool {
+	switch e.PollMode {
+	case PollModeAlways:
+		return true
+	case PollModeNever:
+		return false
+	}
+
+	if len(e.ecURLs) == 0 {
+		return false
+	}
+	scheme := e.ecURLs[e.endpoint].Scheme
+	return scheme == "http" || scheme == "https"
+}
+
+// idleSubscription stands in for the header subscription while polling, since headers are polled along with events
+type idleSubscription struct {
+	err chan error
+}
+
+func (i *idleSubscription) Unsubscribe() {}
+
+func (i *idleSubscription) Err() <-chan error {
+	return i.err
+}
+
+// pollEvents sends the events matching the current queries on e.events as new blocks arrive, followed
+// by the new head on e.newHeaders. Since the head is only sent once every event up to it has been,
+// a failed poll can't advance highestBlock past events which were never delivered.
+//
+// Only blocks after the current head are polled, like a subscription, and ranges longer
+// than BackfillChunkSize are requested in chunks, like a backfill.
+func (e *ExecutionLayer) pollEvents(ctx context.Context) (ethereum.Subscription, error) {
+	client := e.client
+	queries := e.queries()
+	events := e.events
+	newHeaders := e.newHeaders
+	chunkSize := e.BackfillChunkSize
+	if chunkSize == 0 {
+		chunkSize = defaultBackfillChunkSize
+	}
+
+	head, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	next := head.Number.Uint64() + 1
+
+	return newPollingSubscription(e.PollInterval, func(ctx context.Context) error {
+		head, err := client.HeaderByNumber(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		stop := head.Number.Uint64()
+		if stop < next {
+			// Nothing new, or a lagging endpoint
+			return nil
+		}
+
+		for next <= stop {
+			chunkStop := next + chunkSize - 1
+			if chunkStop > stop {
+				chunkStop = stop
+			}
+
+			logs, err := filterLogs(ctx, client, queries, next, chunkStop)
+			if err != nil {
+				return err
+			}
+
+			e.m.Counter("poll_events").Add(float64(len(logs)))
+			for _, log := range logs {
+				select {
+				case events <- log:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+			next = chunkStop + 1
+		}
+
+		select {
+		case newHeaders <- head:
+		case <-ctx.Done():
+		}
+		return nil
+	}), nil
+}
+
+// combinedSubscription is an ethereum.Subscription made of several, like the subscriptions to each of
+// the queries for the events we care about. It fails as soon as any of them does.
+type combinedSubscription struct {
+	subs []ethereum.Subscription
+	err  chan error
+	once sync.Once
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newCombinedSubscription(subs []ethereum.Subscription) *combinedSubscription {
+	out := &combinedSubscription{
+		subs: subs,
+		err:  make(chan error, 1),
+		quit: make(chan struct{}),
+	}
+
+	for _, sub := range subs {
+		sub := sub
+		out.wg.Add(1)
+		go func() {
+			defer out.wg.Done()
+			select {
+			case err, ok := <-sub.Err():
+				if !ok {
+					return
+				}
+				// Only the first error is reported, since the caller tears every subscription down on it
+				select {
+				case out.err <- err:
+				default:
+				}
+			case <-out.quit:
+			}
+		}()
+	}
+	return out
+}
+
+func (c *combinedSubscription) Unsubscribe() {
+	c.once.Do(func() {
+		close(c.quit)
+		for _, sub := range c.subs {
+			sub.Unsubscribe()
+		}
+		c.wg.Wait()
+	})
+}
+
+func (c *combinedSubscription) Err() <-chan error {
+	return c.err
+}
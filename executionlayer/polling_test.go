@@ -0,0 +1,168 @@
+package executionlayer
+
+import (
+	"context"
+	"math/big"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// lockedECClient lets a test change a fakeECClient while it's being polled
+type lockedECClient struct {
+	*fakeECClient
+	sync.Mutex
+}
+
+func (l *lockedECClient) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	l.Lock()
+	defer l.Unlock()
+	return l.fakeECClient.HeaderByNumber(ctx, number)
+}
+
+func (l *lockedECClient) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	l.Lock()
+	defer l.Unlock()
+	return l.fakeECClient.FilterLogs(ctx, q)
+}
+
+func (l *lockedECClient) advance(head uint64, logs ...types.Log) {
+	l.Lock()
+	defer l.Unlock()
+	l.head = head
+	l.logs = append(l.logs, logs...)
+}
+
+func (l *lockedECClient) getCalls() [][2]uint64 {
+	l.Lock()
+	defer l.Unlock()
+	return append([][2]uint64(nil), l.calls...)
+}
+
+func setEndpoint(t *testing.T, e *ExecutionLayer, endpoint string) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.ecURLs = []*url.URL{u}
+	e.endpoint = 0
+}
+
+func TestPollHTTPEndpoints(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	client := &fakeECClient{head: 100}
+	e.client = client
+	setEndpoint(t, e, "http://ec:8545")
+
+	subs, err := e.subscribe(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subs.unsubscribe()
+
+	if !subs.polling {
+		t.Fatal("expected an http endpoint to be polled")
+	}
+	if client.subscribeAttempts.Load() != 0 {
+		t.Fatalf("expected no subscription attempts, got %d", client.subscribeAttempts.Load())
+	}
+}
+
+func TestPollWhenNotificationsUnsupported(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	client := &fakeECClient{head: 100, subscribeErr: rpc.ErrNotificationsUnsupported}
+	e.client = client
+	setEndpoint(t, e, "ws://ec:8546")
+
+	subs, err := e.subscribe(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs.unsubscribe()
+
+	if !subs.polling {
+		t.Fatal("expected to fall back to polling")
+	}
+	if got := testutil.ToFloat64(e.m.Counter("subscriptions_unsupported")); got != 1 {
+		t.Fatalf("expected the fallback to be counted once, got %v", got)
+	}
+
+	e.PollMode = PollModeNever
+	if _, err := e.subscribe(context.Background()); err == nil {
+		t.Fatal("expected an error when polling is disabled")
+	}
+}
+
+func TestPollEvents(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	client := &lockedECClient{fakeECClient: &fakeECClient{head: 100}}
+	e.client = client
+	e.PollMode = PollModeAlways
+	e.PollInterval = 10 * time.Millisecond
+	e.BackfillChunkSize = 1000
+	e.events = make(chan types.Log, 32)
+	e.newHeaders = make(chan *types.Header, 32)
+
+	subs, err := e.subscribe(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subs.unsubscribe()
+
+	// Events are sent before the head they're in
+	client.advance(110, spStatusChangedLog(e, testNode1, true, 105))
+	select {
+	case l := <-e.events:
+		if l.BlockNumber != 105 {
+			t.Fatalf("expected the event from block 105, got block %d", l.BlockNumber)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	select {
+	case h := <-e.newHeaders:
+		if h.Number.Uint64() != 110 {
+			t.Fatalf("expected header 110, got %d", h.Number.Uint64())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a header")
+	}
+
+	// Long gaps are polled in chunks, without skipping any blocks
+	client.advance(3000)
+	for {
+		select {
+		case h := <-e.newHeaders:
+			if h.Number.Uint64() != 3000 {
+				continue
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a header")
+		}
+		break
+	}
+	subs.unsubscribe()
+
+	expected := [][2]uint64{{101, 110}, {111, 1110}, {1111, 2110}, {2111, 3000}}
+	calls := client.getCalls()
+	if len(calls) != len(expected) {
+		t.Fatalf("expected calls %v, got %v", expected, calls)
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Fatalf("expected calls %v, got %v", expected, calls)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package executionlayer
+
+import (
+	"context"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+// RPInfoProvider is what the rest of the proxy knows about Rocket Pool, and how current that knowledge is.
+// *ExecutionLayer provides it from an EC. Consumers should depend on RPInfoProvider rather than *ExecutionLayer,
+// so they can be tested with mocks.MockExecutionLayer instead.
+type RPInfoProvider interface {
+	Init() error
+	Deinit()
+
+	ForEachNode(closure ForEachNodeClosure) error
+	ForEachNodeInfo(closure ForEachNodeInfoClosure) error
+	SnapshotNodes() *NodeSnapshot
+	GetNodeInfo(nodeAddr common.Address) (*NodeInfo, error)
+	GetNodeMinipools(nodeAddr common.Address) (*NodeMinipools, error)
+	NodeRegistered(ctx context.Context, nodeAddr common.Address) (bool, error)
+	SubscribeNodeEvents() (<-chan NodeEvent, func())
+	SmoothingPoolInfo() (*SmoothingPoolInfo, error)
+
+	ValidatorFeeRecipient(ctx context.Context, pubkey rptypes.ValidatorPubkey, queryNodeAddr *common.Address) (*feerecipient.Info, error)
+	ValidatorWithdrawalAddresses(ctx context.Context, pubkeys []rptypes.ValidatorPubkey) (map[rptypes.ValidatorPubkey]*common.Address, map[rptypes.ValidatorPubkey]error)
+	SoloValidatorFeeRecipient(ctx context.Context, pubkey rptypes.ValidatorPubkey, feeRecipient common.Address) (bool, error)
+	AllowedFeeRecipients() []AllowedFeeRecipient
+
+	Status(ctx context.Context) (*Status, error)
+	CachedStatus() (*Status, error)
+	Stale() bool
+	Warming() bool
+}
+
+var _ RPInfoProvider = (*ExecutionLayer)(nil)
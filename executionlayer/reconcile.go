@@ -0,0 +1,236 @@
+package executionlayer
+
+import (
+	"context"
+	"math/big"
+	"math/rand"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+)
+
+const defaultReconcileInterval = 6 * time.Hour
+const defaultReconcileSampleSize = 100
+
+// reconcileSnapshot is the state of the cache at the block a reconciliation is pinned to.
+// It's taken by the event loop, so no events are applied while it's being taken.
+type reconcileSnapshot struct {
+	block     uint64
+	nodes     int
+	minipools int
+	sample    []common.Address
+}
+
+// reconcileResult holds the on-chain state of the sampled nodes at the snapshot block
+type reconcileResult struct {
+	block uint64
+	nodes map[common.Address]*nodeInfo
+}
+
+// reconcileLoop periodically compares the cache against the chain until ctx is cancelled
+func (e *ExecutionLayer) reconcileLoop(ctx context.Context) {
+	ticker := time.NewTicker(e.ReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.reconcile(ctx); err != nil && ctx.Err() == nil {
+				e.m.Counter("reconcile_failed").Inc()
+				e.logger.Warn("Couldn't reconcile the cache against the chain", zap.Error(err))
+			}
+		}
+	}
+}
+
+// takeReconcileSnapshot counts the cache and picks up to ReconcileSampleSize nodes at random to compare.
+// Must be called from the event loop.
+func (e *ExecutionLayer) takeReconcileSnapshot() (*reconcileSnapshot, error) {
+	var err error
+
+	out := &reconcileSnapshot{
+		block: e.cache.getHighestBlock().Uint64(),
+	}
+
+	out.nodes, err = e.cache.countNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	out.minipools, err = e.cache.countMinipools()
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.cache.forEachNode(func(addr common.Address) bool {
+		out.sample = append(out.sample, addr)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rand.Shuffle(len(out.sample), func(i, j int) {
+		out.sample[i], out.sample[j] = out.sample[j], out.sample[i]
+	})
+	if len(out.sample) > e.ReconcileSampleSize {
+		out.sample = out.sample[:e.ReconcileSampleSize]
+	}
+
+	return out, nil
+}
+
+// reconcile reads the chain at the block the cache was last updated to, and has the
+// event loop repair any sampled nodes which don't match.
+func (e *ExecutionLayer) reconcile(ctx context.Context) error {
+	// Ask the event loop for a snapshot, so the cache isn't changing underneath it
+	snapshots := make(chan *reconcileSnapshot, 1)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case e.reconcileRequests <- snapshots:
+	}
+
+	var snapshot *reconcileSnapshot
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case snapshot = <-snapshots:
+	}
+	if snapshot == nil {
+		// The event loop already logged why
+		return nil
+	}
+
+	// Every read is pinned to the snapshot block, so events received meanwhile don't skew the comparison
+	opts := &bind.CallOpts{BlockNumber: big.NewInt(0).SetUint64(snapshot.block), Context: ctx}
+	_, chain, multicall := e.currentConnection()
+
+	nodeCount, err := chain.nodeCount(opts)
+	if err != nil {
+		return err
+	}
+
+	minipoolCount, err := chain.minipoolCount(opts)
+	if err != nil {
+		return err
+	}
+
+	// If the snapshot was taken partway through a block's events, the counts may be off by
+	// that block's registrations, so only drift which persists across runs is meaningful.
+	nodeDrift := int64(snapshot.nodes) - int64(nodeCount)
+	minipoolDrift := int64(snapshot.minipools) - int64(minipoolCount)
+	e.m.Gauge("reconcile_node_count_drift").Set(float64(nodeDrift))
+	e.m.Gauge("reconcile_minipool_count_drift").Set(float64(minipoolDrift))
+	if nodeDrift != 0 || minipoolDrift != 0 {
+		e.m.Counter("reconcile_count_drift").Inc()
+		e.logger.Warn("Cache counts don't match the chain",
+			zap.Uint64("block", snapshot.block),
+			zap.Int("cached_nodes", snapshot.nodes),
+			zap.Uint64("nodes", nodeCount),
+			zap.Int("cached_minipools", snapshot.minipools),
+			zap.Uint64("minipools", minipoolCount))
+	}
+
+	result := &reconcileResult{
+		block: snapshot.block,
+		nodes: make(map[common.Address]*nodeInfo, len(snapshot.sample)),
+	}
+
+	var infos []*nodeInfo
+	if multicall != nil {
+		infos, err = multicall.nodeInfos(snapshot.sample, opts)
+		if err != nil {
+			e.m.Counter("reconcile_multicall_failed").Inc()
+			e.logger.Debug("Multicall failed while reconciling, reading nodes individually", zap.Error(err))
+			infos = nil
+		}
+	}
+
+	for i, addr := range snapshot.sample {
+		if infos != nil {
+			result.nodes[addr] = infos[i]
+			continue
+		}
+
+		n := &nodeInfo{}
+		n.inSmoothingPool, err = chain.smoothingPoolStatus(addr, opts)
+		if err != nil {
+			return err
+		}
+
+		n.feeDistributor, err = chain.feeDistributor(addr, opts)
+		if err != nil {
+			return err
+		}
+		result.nodes[addr] = n
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case e.reconcileResults <- result:
+	}
+
+	e.m.Counter("reconcile_run").Inc()
+	return nil
+}
+
+// applyReconciliation repairs any sampled nodes whose cached state doesn't match the chain.
+// Must be called from the event loop.
+func (e *ExecutionLayer) applyReconciliation(result *reconcileResult) {
+	repaired := 0
+
+	for addr, onChain := range result.nodes {
+		// Events at or after the snapshot block may have updated the node since it was read
+		if e.nodeUpdatedBlocks[addr] >= result.block {
+			e.m.Counter("reconcile_node_skipped").Inc()
+			continue
+		}
+
+		cached, err := e.cache.getNodeInfo(addr)
+		if err != nil {
+			// The node's registration may have been reorged out since the snapshot
+			e.logger.Debug("Sampled node is no longer cached", zap.String("node", addr.String()), zap.Error(err))
+			continue
+		}
+
+		if cached.inSmoothingPool == onChain.inSmoothingPool && cached.feeDistributor == onChain.feeDistributor {
+			continue
+		}
+
+		if cached.inSmoothingPool != onChain.inSmoothingPool {
+			e.m.Counter("reconcile_smoothing_pool_drift").Inc()
+		}
+		if cached.feeDistributor != onChain.feeDistributor {
+			e.m.Counter("reconcile_fee_distributor_drift").Inc()
+		}
+		e.logger.Warn("Cached node doesn't match the chain, repairing",
+			zap.String("node", addr.String()),
+			zap.Uint64("block", result.block),
+			zap.Bool("cached_in_sp", cached.inSmoothingPool),
+			zap.Bool("in_sp", onChain.inSmoothingPool),
+			zap.String("cached_fee_distributor", cached.feeDistributor.String()),
+			zap.String("fee_distributor", onChain.feeDistributor.String()))
+
+		n := cached.clone()
+		n.inSmoothingPool = onChain.inSmoothingPool
+		n.feeDistributor = onChain.feeDistributor
+		err = e.storeNodeInfo(addr, n)
+		if err != nil {
+			e.logger.Error("Failed to add nodeInfo to cache", zap.Error(err))
+			continue
+		}
+		repaired++
+	}
+
+	e.m.Counter("reconcile_node_repaired").Add(float64(repaired))
+	e.logger.Info("Reconciled the cache against the chain",
+		zap.Uint64("block", result.block),
+		zap.Int("sampled", len(result.nodes)),
+		zap.Int("repaired", repaired))
+}
@@ -0,0 +1,90 @@
+package executionlayer
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestReconcile(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+	e.cache.setHighestBlock(big.NewInt(100))
+
+	// testNode1 opted in, but the event was missed
+	chain.nodes[testNode1].inSmoothingPool = true
+
+	e.startEventLoop(nil)
+	if err := e.reconcile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the event loop exits, it has applied the result
+	e.cancel()
+	e.wg.Wait()
+
+	n, err := e.cache.getNodeInfo(testNode1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !n.inSmoothingPool {
+		t.Fatal("expected the drifted node to be repaired")
+	}
+}
+
+func TestReconcileSample(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	for i := 0; i < 10; i++ {
+		chain.addNode(common.BigToAddress(big.NewInt(int64(0x1000+i))), false)
+	}
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	e.ReconcileSampleSize = 5
+	snapshot, err := e.takeReconcileSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.sample) != 5 {
+		t.Fatalf("expected 5 sampled nodes, got %d", len(snapshot.sample))
+	}
+	if snapshot.nodes != 12 {
+		t.Fatalf("expected 12 cached nodes, got %d", snapshot.nodes)
+	}
+}
+
+func TestReconcileNewerEvents(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// testNode0 opts out after the block the reconciler read the chain at
+	e.handleEvent(spStatusChangedLog(e, testNode0, false, 101))
+	e.applyReconciliation(&reconcileResult{
+		block: 100,
+		nodes: map[common.Address]*nodeInfo{
+			testNode0: {inSmoothingPool: true},
+		},
+	})
+
+	n, err := e.cache.getNodeInfo(testNode0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.inSmoothingPool {
+		t.Fatal("expected the newer event to take precedence over the reconciled state")
+	}
+}
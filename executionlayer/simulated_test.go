@@ -0,0 +1,680 @@
+package executionlayer
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rocket-pool/rocketpool-go/rocketpool"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// The stand-in contracts emit a log for every call, taking its topics from the first 32-byte words of the calldata
+// and its data from the rest. Deploying the real rocketNodeManager and rocketMinipoolManager would drag in the entire
+// protocol, but the event loop only cares about the address, topics and data of the logs.
+var (
+	// LOG2(topic0, topic1), like NodeRegistered and NodeSmoothingPoolStateChanged
+	nodeManagerRuntime = []byte{
+		0x60, 0x20, 0x35, // PUSH1 0x20 CALLDATALOAD
+		0x60, 0x00, 0x35, // PUSH1 0x00 CALLDATALOAD
+		0x60, 0x40, 0x36, 0x03, // PUSH1 0x40 CALLDATASIZE SUB
+		0x80,                         // DUP1
+		0x60, 0x40, 0x60, 0x00, 0x37, // PUSH1 0x40 PUSH1 0x00 CALLDATACOPY
+		0x60, 0x00, 0xa2, // PUSH1 0x00 LOG2
+		0x00, // STOP
+	}
+	// LOG3(topic0, topic1, topic2), like MinipoolCreated and MinipoolDestroyed
+	minipoolManagerRuntime = []byte{
+		0x60, 0x40, 0x35, // PUSH1 0x40 CALLDATALOAD
+		0x60, 0x20, 0x35, // PUSH1 0x20 CALLDATALOAD
+		0x60, 0x00, 0x35, // PUSH1 0x00 CALLDATALOAD
+		0x60, 0x60, 0x36, 0x03, // PUSH1 0x60 CALLDATASIZE SUB
+		0x80,                         // DUP1
+		0x60, 0x60, 0x60, 0x00, 0x37, // PUSH1 0x60 PUSH1 0x00 CALLDATACOPY
+		0x60, 0x00, 0xa3, // PUSH1 0x00 LOG3
+		0x00, // STOP
+	}
+)
+
+// initCode returns contract creation code which deploys runtime
+func initCode(runtime []byte) []byte {
+	hi, lo := byte(len(runtime)>>8), byte(len(runtime))
+	return append([]byte{
+		0x61, hi, lo, 0x60, 0x0e, 0x60, 0x00, 0x39, // PUSH2 size PUSH1 0x0e PUSH1 0x00 CODECOPY
+		0x61, hi, lo, 0x60, 0x00, 0xf3, // PUSH2 size PUSH1 0x00 RETURN
+	}, runtime...)
+}
+
+// constantRuntime returns runtime code which returns word from any call, like a view with a fixed result
+func constantRuntime(word common.Hash) []byte {
+	return append(append([]byte{0x7f}, word.Bytes()...), // PUSH32 word
+		0x60, 0x00, 0x52, // PUSH1 0x00 MSTORE
+		0x60, 0x20, 0x60, 0x00, 0xf3, // PUSH1 0x20 PUSH1 0x00 RETURN
+	)
+}
+
+// revertingRuntime reverts any call
+var revertingRuntime = []byte{0x60, 0x00, 0x80, 0xfd} // PUSH1 0x00 DUP1 REVERT
+
+// assembler builds runtime code with jumps, resolving labels once the code is done
+type assembler struct {
+	code   []byte
+	labels map[string]int
+	jumps  map[int]string
+}
+
+func (a *assembler) op(ops ...byte) *assembler {
+	a.code = append(a.code, ops...)
+	return a
+}
+
+func (a *assembler) push(v byte) *assembler {
+	return a.op(0x60, v) // PUSH1 v
+}
+
+// label marks a jump destination
+func (a *assembler) label(name string) *assembler {
+	if a.labels == nil {
+		a.labels = map[string]int{}
+	}
+	a.labels[name] = len(a.code)
+	return a.op(0x5b) // JUMPDEST
+}
+
+// pushLabel pushes the location of a label, which may not be marked yet
+func (a *assembler) pushLabel(name string) *assembler {
+	if a.jumps == nil {
+		a.jumps = map[int]string{}
+	}
+	a.jumps[len(a.code)+1] = name
+	return a.op(0x61, 0x00, 0x00) // PUSH2 label
+}
+
+func (a *assembler) assemble() []byte {
+	for at, name := range a.jumps {
+		dest := a.labels[name]
+		a.code[at], a.code[at+1] = byte(dest>>8), byte(dest)
+	}
+	return a.code
+}
+
+// Opcodes for the multicall stand-in
+const (
+	opAdd            = 0x01
+	opMul            = 0x02
+	opSub            = 0x03
+	opLt             = 0x10
+	opIsZero         = 0x15
+	opAnd            = 0x16
+	opOr             = 0x17
+	opNot            = 0x19
+	opCalldataLoad   = 0x35
+	opCalldataCopy   = 0x37
+	opReturndataSize = 0x3d
+	opReturndataCopy = 0x3e
+	opPop            = 0x50
+	opMload          = 0x51
+	opMstore         = 0x52
+	opJump           = 0x56
+	opJumpI          = 0x57
+	opGas            = 0x5a
+	opDup1           = 0x80
+	opDup2           = 0x81
+	opDup3           = 0x82
+	opDup5           = 0x84
+	opDup8           = 0x87
+	opCall           = 0xf1
+	opReturn         = 0xf3
+	opRevert         = 0xfd
+)
+
+// multicallRuntime implements Multicall3's aggregate3, and nothing else: it ignores the selector, makes each call
+// in turn, reverts if one which doesn't allow failure does, and returns the abi-encoded results.
+// Memory holds the loop counter at 0x00, the write pointer at 0x20, the number of calls at 0x40, and the start of
+// the calls' heads in the calldata at 0x60. The results are written from 0x80.
+func multicallRuntime() []byte {
+	const (
+		counter = 0x00
+		ptr     = 0x20
+		count   = 0x40
+		heads   = 0x60
+		out     = 0x80
+		// The results' heads follow their offset and length
+		outHeads = out + 0x40
+	)
+
+	a := &assembler{}
+	// The calls array is the only argument
+	a.push(0x04).op(opCalldataLoad).push(0x04).op(opAdd)
+	a.op(opDup1, opCalldataLoad).push(count).op(opMstore)
+	a.push(0x20).op(opAdd).push(heads).op(opMstore)
+	// The results array's offset and length, then the write pointer past its heads
+	a.push(0x20).push(out).op(opMstore)
+	a.push(count).op(opMload).push(out + 0x20).op(opMstore)
+	a.push(count).op(opMload).push(0x20).op(opMul).push(outHeads).op(opAdd).push(ptr).op(opMstore)
+
+	a.label("loop")
+	a.push(count).op(opMload).push(counter).op(opMload).op(opLt, opIsZero).pushLabel("done").op(opJumpI)
+	// The result's head is its offset from the heads
+	a.push(outHeads).push(ptr).op(opMload, opSub)
+	a.push(counter).op(opMload).push(0x20).op(opMul).push(outHeads).op(opAdd, opMstore)
+	// Stack: call, callData
+	a.push(counter).op(opMload).push(0x20).op(opMul).push(heads).op(opMload, opAdd, opCalldataLoad).push(heads).op(opMload, opAdd)
+	a.op(opDup1).push(0x40).op(opAdd, opCalldataLoad, opDup2, opAdd)
+	// Stack: call, callData, len(callData). The callData is copied where the result's data will go.
+	a.op(opDup1, opCalldataLoad, opDup1, opDup3).push(0x20).op(opAdd).push(0x60).push(ptr).op(opMload, opAdd, opCalldataCopy)
+	a.push(0x00).push(0x00).op(opDup3).push(0x60).push(ptr).op(opMload, opAdd).push(0x00).op(opDup8, opCalldataLoad, opGas, opCall)
+	// Stack: call, callData, len(callData), success
+	a.op(opDup1, opDup5).push(0x20).op(opAdd, opCalldataLoad, opOr).pushLabel("ok").op(opJumpI)
+	a.push(0x00).op(opDup1, opRevert)
+	a.label("ok")
+	a.push(ptr).op(opMload, opMstore)
+	a.push(0x40).push(ptr).op(opMload).push(0x20).op(opAdd, opMstore)
+	a.op(opReturndataSize).push(ptr).op(opMload).push(0x40).op(opAdd, opMstore)
+	a.op(opReturndataSize).push(0x00).push(ptr).op(opMload).push(0x60).op(opAdd, opReturndataCopy)
+	// Clear what's left of the callData in the padding
+	a.push(0x00).op(opReturndataSize).push(ptr).op(opMload).push(0x60).op(opAdd, opAdd, opMstore)
+	a.push(0x1f).op(opReturndataSize, opAdd).push(0x1f).op(opNot, opAnd).push(0x60).op(opAdd).push(ptr).op(opMload, opAdd).push(ptr).op(opMstore)
+	a.push(counter).op(opMload).push(0x01).op(opAdd).push(counter).op(opMstore)
+	a.op(opPop, opPop, opPop).pushLabel("loop").op(opJump)
+
+	a.label("done")
+	a.push(out).push(ptr).op(opMload, opSub).push(out).op(opReturn)
+	return a.assemble()
+}
+
+// simulatedClient adds ChainID to the simulated backend, so it can stand in for an ethclient
+type simulatedClient struct {
+	*backends.SimulatedBackend
+}
+
+func (s *simulatedClient) ChainID(ctx context.Context) (*big.Int, error) {
+	return s.Blockchain().Config().ChainID, nil
+}
+
+// simulatedChain is a simulated EC with the stand-in contracts deployed
+type simulatedChain struct {
+	t       *testing.T
+	backend *backends.SimulatedBackend
+	opts    *bind.TransactOpts
+
+	nodeManager     *bind.BoundContract
+	minipoolManager *bind.BoundContract
+}
+
+func newSimulatedChain(t *testing.T) (*simulatedChain, common.Address, common.Address) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	funds, _ := big.NewInt(0).SetString("1000000000000000000000", 10)
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{
+		crypto.PubkeyToAddress(key.PublicKey): {Balance: funds},
+	}, 8000000)
+	t.Cleanup(func() {
+		backend.Close()
+	})
+
+	opts, err := bind.NewKeyedTransactorWithChainID(key, backend.Blockchain().Config().ChainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &simulatedChain{t: t, backend: backend, opts: opts}
+	nodeManager := s.deploy(nodeManagerRuntime)
+	minipoolManager := s.deploy(minipoolManagerRuntime)
+	s.nodeManager = bind.NewBoundContract(nodeManager, abi.ABI{}, backend, backend, backend)
+	s.minipoolManager = bind.NewBoundContract(minipoolManager, abi.ABI{}, backend, backend, backend)
+	s.backend.Commit()
+
+	return s, nodeManager, minipoolManager
+}
+
+func (s *simulatedChain) deploy(runtime []byte) common.Address {
+	addr, _, _, err := bind.DeployContract(s.opts, abi.ABI{}, initCode(runtime), s.backend)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return addr
+}
+
+func (s *simulatedChain) emit(contract *bind.BoundContract, words ...common.Hash) {
+	calldata := make([]byte, 0, len(words)*common.HashLength)
+	for _, word := range words {
+		calldata = append(calldata, word.Bytes()...)
+	}
+
+	if _, err := contract.RawTransact(s.opts, calldata); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+func boolWord(b bool) common.Hash {
+	if b {
+		return common.BigToHash(big.NewInt(1))
+	}
+	return common.Hash{}
+}
+
+func (s *simulatedChain) nodeRegistered(e *ExecutionLayer, nodeAddr common.Address) {
+	s.emit(s.nodeManager, e.nodeRegisteredTopic, common.BytesToHash(nodeAddr.Bytes()), common.Hash{})
+}
+
+func (s *simulatedChain) smoothingPoolStatusChanged(e *ExecutionLayer, nodeAddr common.Address, inSP bool) {
+	s.emit(s.nodeManager, e.smoothingPoolStatusChangedTopic, common.BytesToHash(nodeAddr.Bytes()), boolWord(inSP))
+}
+
+func (s *simulatedChain) minipoolCreated(e *ExecutionLayer, minipoolAddr common.Address, nodeAddr common.Address) {
+	s.emit(s.minipoolManager, e.minipoolLaunchedTopic, common.BytesToHash(minipoolAddr.Bytes()), common.BytesToHash(nodeAddr.Bytes()), common.Hash{})
+}
+
+func (s *simulatedChain) minipoolDestroyed(e *ExecutionLayer, minipoolAddr common.Address, nodeAddr common.Address) {
+	s.emit(s.minipoolManager, e.minipoolDestroyedTopic, common.BytesToHash(minipoolAddr.Bytes()), common.BytesToHash(nodeAddr.Bytes()), common.Hash{})
+}
+
+// commit mines the pending transactions, and returns the new block number
+func (s *simulatedChain) commit() uint64 {
+	s.backend.Commit()
+	return s.head()
+}
+
+func (s *simulatedChain) head() uint64 {
+	return s.backend.Blockchain().CurrentBlock().NumberU64()
+}
+
+// simulatedSetup returns an ExecutionLayer which reads events from a simulated EC.
+// Contract reads are still served by the fakeChainReader, which knows testNode0 and testNode1.
+func simulatedSetup(t *testing.T) (*ExecutionLayer, *fakeChainReader, *simulatedChain, *observer.ObservedLogs) {
+	e, chain, teardown := setup(t)
+	t.Cleanup(teardown)
+
+	sim, nodeManager, minipoolManager := newSimulatedChain(t)
+	e.client = &simulatedClient{sim.backend}
+	e.rocketNodeManager = &rocketpool.Contract{Address: &nodeManager}
+	e.rocketMinipoolManager = &rocketpool.Contract{Address: &minipoolManager}
+	e.updateQuery()
+
+	observed, logs := observer.New(zapcore.DebugLevel)
+	e.logger = zap.New(observed)
+
+	return e, chain, sim, logs
+}
+
+// waitFor polls condition until it's true, or fails the test
+func waitFor(t *testing.T, what string, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSimulatedEventOrdering(t *testing.T) {
+	e, chain, sim, _ := simulatedSetup(t)
+	start := sim.head()
+
+	node := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	minipool := common.HexToAddress("0x4444444444444444444444444444444444444444")
+	destroyed := common.HexToAddress("0x5555555555555555555555555555555555555555")
+	chain.addNode(node, false)
+
+	sim.nodeRegistered(e, node)
+	sim.commit()
+	sim.smoothingPoolStatusChanged(e, node, true)
+	sim.minipoolCreated(e, minipool, node)
+	sim.minipoolCreated(e, destroyed, node)
+	sim.commit()
+	sim.minipoolDestroyed(e, destroyed, node)
+	sim.commit()
+	// Within a block, the later event wins
+	sim.smoothingPoolStatusChanged(e, node, false)
+	sim.smoothingPoolStatusChanged(e, node, true)
+	sim.commit()
+	sim.smoothingPoolStatusChanged(e, node, false)
+	head := sim.commit()
+
+	if err := e.ecEventsConnect(&bind.CallOpts{BlockNumber: big.NewInt(0).SetUint64(start)}); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Deinit()
+
+	if e.cache.getHighestBlock().Uint64() != head {
+		t.Fatalf("expected highest block %d, got %d", head, e.cache.getHighestBlock())
+	}
+
+	pubkey, _ := chain.minipoolPubkey(minipool, nil)
+	info, err := e.ValidatorFeeRecipient(context.Background(), pubkey, &node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Expected != chain.nodes[node].feeDistributor {
+		t.Fatalf("expected the last smoothing pool status change to apply, got fee recipient %s", info.Expected.String())
+	}
+	pubkey, _ = chain.minipoolPubkey(destroyed, nil)
+	if _, err := e.ValidatorFeeRecipient(context.Background(), pubkey, nil); !errors.Is(err, feerecipient.ErrNotMinipool) {
+		t.Fatalf("expected the destroyed minipool to be removed, got %v", err)
+	}
+
+	// Events mined after connecting arrive through the subscription, in order
+	sim.smoothingPoolStatusChanged(e, node, true)
+	sim.commit()
+	sim.smoothingPoolStatusChanged(e, node, false)
+	sim.smoothingPoolStatusChanged(e, node, true)
+	head = sim.commit()
+
+	waitFor(t, "the subscribed events", func() bool {
+		n, err := e.GetNodeInfo(node)
+		return err == nil && n.InSmoothingPool && e.cache.getHighestBlock().Uint64() == head
+	})
+}
+
+func TestSimulatedBackfillGap(t *testing.T) {
+	e, chain, sim, _ := simulatedSetup(t)
+	e.BackfillChunkSize = 2
+	e.cache.setHighestBlock(big.NewInt(0).SetUint64(sim.head()))
+
+	node := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	chain.addNode(node, false)
+
+	// Events spread across a gap of several chunks, some of them empty
+	sim.nodeRegistered(e, node)
+	sim.commit()
+	for i := 0; i < 4; i++ {
+		sim.commit()
+	}
+	sim.smoothingPoolStatusChanged(e, node, true)
+	sim.commit()
+	sim.commit()
+	sim.minipoolCreated(e, common.HexToAddress("0x4444444444444444444444444444444444444444"), node)
+	head := sim.commit()
+
+	if err := e.backfillEvents(); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.cache.getHighestBlock().Uint64() != head {
+		t.Fatalf("expected highest block %d, got %d", head, e.cache.getHighestBlock())
+	}
+	n, err := e.GetNodeInfo(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !n.InSmoothingPool || len(n.MinipoolPubkeys) != 1 {
+		t.Fatalf("unexpected node after backfill %+v", n)
+	}
+
+	// A second backfill with no gap is a no-op
+	if err := e.backfillEvents(); err != nil {
+		t.Fatal(err)
+	}
+	if e.cache.getHighestBlock().Uint64() != head {
+		t.Fatalf("expected highest block %d, got %d", head, e.cache.getHighestBlock())
+	}
+}
+
+func TestSimulatedUnknownNode(t *testing.T) {
+	e, _, sim, logs := simulatedSetup(t)
+	e.cache.setHighestBlock(big.NewInt(0).SetUint64(sim.head()))
+
+	// testNode1 is known to the chain, but its registration was never seen
+	sim.smoothingPoolStatusChanged(e, testNode1, true)
+	sim.commit()
+
+	if err := e.backfillEvents(); err != nil {
+		t.Fatal(err)
+	}
+
+	warnings := logs.FilterMessage("Unknown node updated its smoothing pool status").All()
+	if len(warnings) != 1 || warnings[0].Level != zapcore.WarnLevel {
+		t.Fatalf("expected one warning about the unknown node, got %v", warnings)
+	}
+	if warnings[0].ContextMap()["addr"] != testNode1.String() {
+		t.Fatalf("expected the warning to name %s, got %v", testNode1.String(), warnings[0].ContextMap())
+	}
+
+	// The node is added anyway
+	n, err := e.GetNodeInfo(testNode1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !n.InSmoothingPool {
+		t.Fatal("expected the unknown node to be added in the smoothing pool")
+	}
+}
+
+func TestSimulatedHeaders(t *testing.T) {
+	e, _, sim, _ := simulatedSetup(t)
+
+	if err := e.ecEventsConnect(&bind.CallOpts{BlockNumber: big.NewInt(0).SetUint64(sim.head())}); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Deinit()
+
+	// Blocks without events still advance the highest block
+	for i := 0; i < 3; i++ {
+		head := sim.commit()
+		waitFor(t, "the new header", func() bool {
+			return e.cache.getHighestBlock().Uint64() == head
+		})
+	}
+}
+
+func TestSimulatedPreloadBlock(t *testing.T) {
+	e, chain, sim, logs := simulatedSetup(t)
+
+	// The cache is warmed up at a block before a node registered, and catches up to the head afterwards
+	e.PreloadBlock = sim.commit()
+	node := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	sim.nodeRegistered(e, node)
+	sim.commit()
+	sim.minipoolCreated(e, common.HexToAddress("0x4444444444444444444444444444444444444444"), node)
+	head := sim.commit()
+
+	block, err := e.preloadBlock(big.NewInt(0).SetUint64(head))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Uint64() != e.PreloadBlock {
+		t.Fatalf("expected to preload at block %d, got %d", e.PreloadBlock, block)
+	}
+	if logs.FilterMessage("Preload block is far behind the head, so backfilling events from it will take a while").Len() != 0 {
+		t.Fatal("didn't expect a warning about a nearby preload block")
+	}
+
+	// The fake chain reader answers the same at every block, so only learns of the node after the preload
+	opts := &bind.CallOpts{BlockNumber: block}
+	if err := e.preload(opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.GetNodeInfo(node); err == nil {
+		t.Fatal("expected the node not to be preloaded")
+	}
+	chain.addNode(node, false)
+
+	if err := e.ecEventsConnect(opts); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Deinit()
+
+	if e.cache.getHighestBlock().Uint64() != head {
+		t.Fatalf("expected highest block %d, got %d", head, e.cache.getHighestBlock())
+	}
+	n, err := e.GetNodeInfo(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(n.MinipoolPubkeys) != 1 {
+		t.Fatalf("expected the backfilled minipool, got %+v", n)
+	}
+}
+
+func TestPreloadBlockBounds(t *testing.T) {
+	e, _, _, logs := simulatedSetup(t)
+
+	// 0 means the head
+	if block, err := e.preloadBlock(big.NewInt(100)); err != nil || block.Uint64() != 100 {
+		t.Fatalf("expected the head, got %v %v", block, err)
+	}
+
+	e.PreloadBlock = 101
+	if _, err := e.preloadBlock(big.NewInt(100)); err == nil {
+		t.Fatal("expected a preload block past the head to be an error")
+	}
+
+	e.PreloadBlock = 1
+	if block, err := e.preloadBlock(big.NewInt(preloadBlockWarnDistance + 2)); err != nil || block.Uint64() != 1 {
+		t.Fatalf("expected block 1, got %v %v", block, err)
+	}
+	if logs.FilterMessage("Preload block is far behind the head, so backfilling events from it will take a while").Len() != 1 {
+		t.Fatal("expected a warning about the distant preload block")
+	}
+}
+
+func TestSimulatedMulticall(t *testing.T) {
+	sim, _, _ := newSimulatedChain(t)
+	distributor := common.HexToAddress("0x6666666666666666666666666666666666666666")
+	multicallAddr := sim.deploy(multicallRuntime())
+	nodeManager := sim.deploy(constantRuntime(boolWord(true)))
+	distributorFactory := sim.deploy(constantRuntime(common.BytesToHash(distributor.Bytes())))
+	staking := sim.deploy(constantRuntime(common.BigToHash(big.NewInt(int64(rptypes.Staking)))))
+	dissolved := sim.deploy(constantRuntime(common.BigToHash(big.NewInt(int64(rptypes.Dissolved)))))
+	reverting := sim.deploy(revertingRuntime)
+	sim.commit()
+
+	mc, err := newMulticaller(multicallAddr, sim.backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &bind.CallOpts{}
+	if deployed, err := mc.deployed(opts); err != nil || !deployed {
+		t.Fatalf("expected multicall to be deployed, got %v, %v", deployed, err)
+	}
+	absent, err := newMulticaller(common.HexToAddress(DefaultMulticallAddr), sim.backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deployed, err := absent.deployed(opts); err != nil || deployed {
+		t.Fatalf("expected multicall not to be deployed at the default address, got %v, %v", deployed, err)
+	}
+
+	nodeManagerABI := mustParseABI(t, testNodeManagerABI)
+	distributorFactoryABI := mustParseABI(t, testDistributorFactoryABI)
+	reader, err := newMulticallNodeReader(mc,
+		&rocketpool.Contract{Address: &nodeManager, ABI: &nodeManagerABI},
+		&rocketpool.Contract{Address: &distributorFactory, ABI: &distributorFactoryABI})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := reader.nodeInfos([]common.Address{testNode0, testNode1}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(infos))
+	}
+	for i, info := range infos {
+		if !info.inSmoothingPool || info.feeDistributor != distributor {
+			t.Fatalf("node %d read wrong: in smoothing pool %v, distributor %s", i, info.inSmoothingPool, info.feeDistributor)
+		}
+	}
+
+	// Essential reads don't allow failure, so one failed call fails its batch
+	reader.rocketNodeDistributorFactory.Address = &reverting
+	if _, err := reader.nodeInfos([]common.Address{testNode0, testNode1}, opts); err == nil {
+		t.Fatal("expected a failed call to fail the batch")
+	}
+
+	// Status reads do, leaving only the failed calls' statuses unknown. A minipool without code returns nothing.
+	noCode := common.HexToAddress("0x7777777777777777777777777777777777777777")
+	statuses, err := reader.minipoolStatuses([]common.Address{staking, reverting, dissolved, noCode}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []feerecipient.MinipoolStatus{
+		feerecipient.MinipoolStatusStaking,
+		feerecipient.MinipoolStatusUnknown,
+		feerecipient.MinipoolStatusDissolved,
+		feerecipient.MinipoolStatusUnknown,
+	}
+	if len(statuses) != len(expected) {
+		t.Fatalf("expected %d statuses, got %d", len(expected), len(statuses))
+	}
+	for i := range expected {
+		if statuses[i] != expected[i] {
+			t.Fatalf("expected status %d to be %v, got %v", i, expected[i], statuses[i])
+		}
+	}
+
+	// No calls, no results
+	statuses, err = reader.minipoolStatuses(nil, opts)
+	if err != nil || len(statuses) != 0 {
+		t.Fatalf("expected no statuses, got %v, %v", statuses, err)
+	}
+}
+
+func TestSimulatedReorg(t *testing.T) {
+	e, chain, sim, _ := simulatedSetup(t)
+
+	node := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	minipool := common.HexToAddress("0x4444444444444444444444444444444444444444")
+	chain.addNode(node, false)
+	pubkey, _ := chain.minipoolPubkey(minipool, nil)
+
+	start := sim.head()
+	sim.nodeRegistered(e, node)
+	forkPoint := sim.commit()
+	if err := e.ecEventsConnect(&bind.CallOpts{BlockNumber: big.NewInt(0).SetUint64(start)}); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Deinit()
+
+	// The node opts in and launches a minipool, in a block which is later reorged out
+	sim.smoothingPoolStatusChanged(e, node, true)
+	sim.minipoolCreated(e, minipool, node)
+	sim.commit()
+	waitFor(t, "the events before the reorg", func() bool {
+		n, err := e.GetNodeInfo(node)
+		return err == nil && n.InSmoothingPool && len(n.MinipoolPubkeys) == 1
+	})
+
+	// Re-mine a longer chain without them from the block before
+	if err := sim.backend.Fork(context.Background(), sim.backend.Blockchain().GetBlockByNumber(forkPoint).Hash()); err != nil {
+		t.Fatal(err)
+	}
+	sim.backend.Commit()
+	head := sim.commit()
+	if head != forkPoint+2 {
+		t.Fatalf("expected the fork to become the canonical chain at block %d, got head %d", forkPoint+2, head)
+	}
+
+	waitFor(t, "the reorg", func() bool {
+		n, err := e.GetNodeInfo(node)
+		return err == nil && !n.InSmoothingPool && len(n.MinipoolPubkeys) == 0 && e.cache.getHighestBlock().Uint64() == head
+	})
+	if _, err := e.ValidatorFeeRecipient(context.Background(), pubkey, nil); !errors.Is(err, feerecipient.ErrNotMinipool) {
+		t.Fatalf("expected the reorged minipool to be removed, got %v", err)
+	}
+	if removed := testutil.ToFloat64(e.m.Counter("subscription_event_removed")); removed != 2 {
+		t.Fatalf("expected the EC to redeliver both events as removed, got %v", removed)
+	}
+	// The registration before the fork point stands
+	if _, err := e.cache.getNodeInfo(node); err != nil {
+		t.Fatal(err)
+	}
+}
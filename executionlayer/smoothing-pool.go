@@ -0,0 +1,157 @@
+package executionlayer
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SmoothingPoolInfo describes the smoothing pool, and how many of the cache's nodes are opted in to it
+type SmoothingPoolInfo struct {
+	Address      common.Address
+	OptedInNodes int
+	Nodes        int
+	// The highest block for which an event was applied, which the counts are current as of
+	Block uint64
+}
+
+// nodeCounts tracks which nodes the cache has, in the order they were added, and how many of them are in the
+// smoothing pool, so they can be read without ranging over the node index
+type nodeCounts struct {
+	// Held while a node's previous nodeInfo is read and its new one stored, so concurrent writers can't miscount
+	sync.Mutex
+	// Nodes are only appended, except when their registration is reorged out, so a copy of the list is every node
+	// the cache had at one moment
+	list            []SnapshotNode
+	positions       map[common.Address]int
+	inSmoothingPool int
+}
+
+func (c *nodeCounts) add(n *nodeInfo, delta int) {
+	if n.inSmoothingPool {
+		c.inSmoothingPool += delta
+	}
+}
+
+// store adds a node to the end of the list, or updates it where it already is
+func (c *nodeCounts) store(nodeAddr common.Address, n *nodeInfo) {
+	node := SnapshotNode{
+		Address:         nodeAddr,
+		InSmoothingPool: n.inSmoothingPool,
+		FeeDistributor:  n.feeDistributor,
+	}
+	if i, ok := c.positions[nodeAddr]; ok {
+		c.list[i] = node
+		return
+	}
+
+	if c.positions == nil {
+		c.positions = make(map[common.Address]int)
+	}
+	c.positions[nodeAddr] = len(c.list)
+	c.list = append(c.list, node)
+}
+
+// remove takes a node out of the list. It's only needed when a registration is reorged out, so it's allowed to be slow.
+func (c *nodeCounts) remove(nodeAddr common.Address) {
+	i, ok := c.positions[nodeAddr]
+	if !ok {
+		return
+	}
+
+	c.list = append(c.list[:i], c.list[i+1:]...)
+	delete(c.positions, nodeAddr)
+	for j := i; j < len(c.list); j++ {
+		c.positions[c.list[j].Address] = j
+	}
+}
+
+// storeNodeInfo adds or replaces a node in the cache, and updates the node counts to match
+func (e *ExecutionLayer) storeNodeInfo(nodeAddr common.Address, n *nodeInfo) error {
+	e.nodeCounts.Lock()
+	defer e.nodeCounts.Unlock()
+
+	previous, err := e.cache.getNodeInfo(nodeAddr)
+	if _, ok := err.(*NotFoundError); err != nil && !ok {
+		return err
+	}
+
+	if err := e.cache.addNodeInfo(nodeAddr, n); err != nil {
+		return err
+	}
+
+	if previous != nil {
+		e.nodeCounts.add(previous, -1)
+	}
+	e.nodeCounts.add(n, 1)
+	e.nodeCounts.store(nodeAddr, n)
+	return nil
+}
+
+// deleteNodeInfo removes a node from the cache, and updates the node counts to match
+func (e *ExecutionLayer) deleteNodeInfo(nodeAddr common.Address) error {
+	e.nodeCounts.Lock()
+	defer e.nodeCounts.Unlock()
+
+	previous, err := e.cache.getNodeInfo(nodeAddr)
+	if _, ok := err.(*NotFoundError); err != nil && !ok {
+		return err
+	}
+
+	if err := e.cache.removeNodeInfo(nodeAddr); err != nil {
+		return err
+	}
+
+	if previous != nil {
+		e.nodeCounts.add(previous, -1)
+	}
+	e.nodeCounts.remove(nodeAddr)
+	return nil
+}
+
+// recountNodes counts the cache's nodes from scratch. It's needed once the cache has been loaded or preloaded,
+// after which storeNodeInfo and deleteNodeInfo keep the counts current.
+func (e *ExecutionLayer) recountNodes() error {
+	e.nodeCounts.Lock()
+	defer e.nodeCounts.Unlock()
+
+	var nodes []common.Address
+	err := e.cache.forEachNode(func(addr common.Address) bool {
+		nodes = append(nodes, addr)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	e.nodeCounts.list, e.nodeCounts.positions, e.nodeCounts.inSmoothingPool = nil, nil, 0
+	for _, addr := range nodes {
+		n, err := e.cache.getNodeInfo(addr)
+		if err != nil {
+			if _, ok := err.(*NotFoundError); ok {
+				continue
+			}
+			return err
+		}
+		e.nodeCounts.add(n, 1)
+		e.nodeCounts.store(addr, n)
+	}
+	return nil
+}
+
+// SmoothingPoolInfo returns the smoothing pool's address, and how many of the cache's nodes are opted in to it.
+// The counts are kept as nodes change, so it's cheap enough to call for every request.
+func (e *ExecutionLayer) SmoothingPoolInfo() (*SmoothingPoolInfo, error) {
+	if e.smoothingPool == nil || e.smoothingPool.Address == nil {
+		return nil, &NotFoundError{}
+	}
+
+	e.nodeCounts.Lock()
+	defer e.nodeCounts.Unlock()
+	return &SmoothingPoolInfo{
+		Address:      *e.smoothingPool.Address,
+		OptedInNodes: e.nodeCounts.inSmoothingPool,
+		Nodes:        len(e.nodeCounts.list),
+		Block:        e.cache.getHighestBlock().Uint64(),
+	}, nil
+}
@@ -0,0 +1,53 @@
+package executionlayer
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestSmoothingPoolInfo(t *testing.T) {
+	e, chain, teardown := setup(t)
+	defer teardown()
+
+	expect := func(optedIn int, nodes int) {
+		t.Helper()
+		info, err := e.SmoothingPoolInfo()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Address != testSmoothingPool || info.OptedInNodes != optedIn || info.Nodes != nodes {
+			t.Fatalf("expected %d of %d nodes in smoothing pool %s, got %+v", optedIn, nodes, testSmoothingPool, info)
+		}
+	}
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+	expect(1, 2)
+
+	// The counts follow events, and their reorgs
+	optIn := spStatusChangedLog(e, testNode1, true, 101)
+	e.handleEvent(optIn)
+	expect(2, 2)
+	e.handleEvent(spStatusChangedLog(e, testNode1, true, 102))
+	expect(2, 2)
+	e.handleEvent(removed(optIn))
+	expect(1, 2)
+
+	newNode := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	chain.addNode(newNode, false)
+	registered := nodeRegisteredLog(e, newNode, 103)
+	e.handleEvent(registered)
+	expect(1, 3)
+	e.handleEvent(removed(registered))
+	expect(1, 2)
+
+	// Recounting from scratch agrees
+	if err := e.recountNodes(); err != nil {
+		t.Fatal(err)
+	}
+	expect(1, 2)
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"math/big"
 	"os"
+	"sync/atomic"
 
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
 	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
 	"github.com/ethereum/go-ethereum/common"
 	driver "github.com/mattn/go-sqlite3"
@@ -22,16 +24,31 @@ type SqliteCache struct {
 	setMinipoolStmt     *sql.Stmt
 	setNodeStmt         *sql.Stmt
 	setHighestBlockStmt *sql.Stmt
+	deleteMinipoolStmt  *sql.Stmt
+	deleteNodeStmt      *sql.Stmt
 	forEachNodeStmt     *sql.Stmt
+	forEachMinipoolStmt *sql.Stmt
+	nodeMinipoolsStmt   *sql.Stmt
+
+	getMinipoolStatusStmt *sql.Stmt
+	getMinipoolPubkeyStmt *sql.Stmt
+	setMinipoolStatusStmt *sql.Stmt
+
+	getWithdrawalAddressStmt *sql.Stmt
+	setWithdrawalAddressStmt *sql.Stmt
 
 	// Track the highest block in memory and save to db before serializing
-	highestBlock *big.Int
+	highestBlock atomic.Uint64
 
 	m *metrics.MetricsRegistry
 }
 
 const snapshotFileName = "rescue-proxy-cache.sql"
 
+// schemaVersion is stored in the db's user_version pragma.
+// Bump it whenever the tables change, and snapshots from older versions will be discarded.
+const schemaVersion = 5
+
 func (s *SqliteCache) prepareStatements() error {
 	var err error
 
@@ -39,7 +56,7 @@ func (s *SqliteCache) prepareStatements() error {
 	if err != nil {
 		return err
 	}
-	s.getNodeStmt, err = s.db.Prepare("SELECT smoothing_pool_status, fee_distributor FROM nodes WHERE address = ?;")
+	s.getNodeStmt, err = s.db.Prepare("SELECT smoothing_pool_status, fee_distributor, withdrawal_address, rpl_stake, bond, unknown_fields FROM nodes WHERE address = ?;")
 	if err != nil {
 		return err
 	}
@@ -52,7 +69,7 @@ func (s *SqliteCache) prepareStatements() error {
 	if err != nil {
 		return err
 	}
-	s.setNodeStmt, err = s.db.Prepare("INSERT OR REPLACE INTO nodes(address, smoothing_pool_status, fee_distributor) VALUES( ?, ?, ?);")
+	s.setNodeStmt, err = s.db.Prepare("INSERT OR REPLACE INTO nodes(address, smoothing_pool_status, fee_distributor, withdrawal_address, rpl_stake, bond, unknown_fields) VALUES( ?, ?, ?, ?, ?, ?, ?);")
 	if err != nil {
 		return err
 	}
@@ -61,11 +78,52 @@ func (s *SqliteCache) prepareStatements() error {
 		return err
 	}
 
+	s.deleteMinipoolStmt, err = s.db.Prepare("DELETE FROM minipools WHERE pubkey = ?;")
+	if err != nil {
+		return err
+	}
+	s.deleteNodeStmt, err = s.db.Prepare("DELETE FROM nodes WHERE address = ?;")
+	if err != nil {
+		return err
+	}
+
 	s.forEachNodeStmt, err = s.db.Prepare("SELECT address FROM nodes;")
 	if err != nil {
 		return err
 	}
 
+	s.forEachMinipoolStmt, err = s.db.Prepare("SELECT pubkey, node_address FROM minipools;")
+	if err != nil {
+		return err
+	}
+
+	s.nodeMinipoolsStmt, err = s.db.Prepare("SELECT pubkey FROM minipools WHERE node_address = ?;")
+	if err != nil {
+		return err
+	}
+
+	s.getMinipoolStatusStmt, err = s.db.Prepare("SELECT status FROM minipool_statuses WHERE pubkey = ?;")
+	if err != nil {
+		return err
+	}
+	s.getMinipoolPubkeyStmt, err = s.db.Prepare("SELECT pubkey FROM minipool_statuses WHERE minipool_address = ?;")
+	if err != nil {
+		return err
+	}
+	s.setMinipoolStatusStmt, err = s.db.Prepare("INSERT OR REPLACE INTO minipool_statuses(pubkey, minipool_address, status) VALUES( ?, ?, ?);")
+	if err != nil {
+		return err
+	}
+
+	s.getWithdrawalAddressStmt, err = s.db.Prepare("SELECT address FROM withdrawal_addresses WHERE pubkey = ?;")
+	if err != nil {
+		return err
+	}
+	s.setWithdrawalAddressStmt, err = s.db.Prepare("INSERT OR REPLACE INTO withdrawal_addresses(pubkey, address) VALUES( ?, ?);")
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -74,13 +132,32 @@ func (s *SqliteCache) createTables() error {
 		CREATE TABLE IF NOT EXISTS nodes (
 			address BLOB PRIMARY KEY,
 			smoothing_pool_status TINYINT,
-			fee_distributor BLOB
+			fee_distributor BLOB,
+			withdrawal_address BLOB,
+			rpl_stake BLOB,
+			bond BLOB,
+			unknown_fields TINYINT
 		);`
 
 	const minipools string = `
 		CREATE TABLE IF NOT EXISTS minipools (
 			pubkey BLOB PRIMARY KEY,
 			node_address BLOB
+		);
+		CREATE INDEX IF NOT EXISTS minipools_node_address ON minipools(node_address);`
+
+	const minipoolStatuses string = `
+		CREATE TABLE IF NOT EXISTS minipool_statuses (
+			pubkey BLOB PRIMARY KEY,
+			minipool_address BLOB,
+			status TINYINT
+		);
+		CREATE INDEX IF NOT EXISTS minipool_statuses_minipool_address ON minipool_statuses(minipool_address);`
+
+	const withdrawalAddresses string = `
+		CREATE TABLE IF NOT EXISTS withdrawal_addresses (
+			pubkey BLOB PRIMARY KEY,
+			address BLOB
 		);`
 
 	const highestBlock string = `
@@ -97,14 +174,48 @@ func (s *SqliteCache) createTables() error {
 		return err
 	}
 
+	if _, err := s.db.Exec(minipoolStatuses); err != nil {
+		return err
+	}
+
+	if _, err := s.db.Exec(withdrawalAddresses); err != nil {
+		return err
+	}
+
 	if _, err := s.db.Exec(highestBlock); err != nil {
 		return err
 	}
 
+	if _, err := s.db.Exec(fmt.Sprintf("PRAGMA user_version = %d;", schemaVersion)); err != nil {
+		return err
+	}
+
 	return nil
 
 }
 
+// dropStaleTables drops all tables if the snapshot was created with an older schema
+func (s *SqliteCache) dropStaleTables() error {
+	var version int
+
+	if err := s.db.QueryRow("PRAGMA user_version;").Scan(&version); err != nil {
+		return err
+	}
+
+	if version == schemaVersion {
+		return nil
+	}
+
+	for _, table := range []string{"nodes", "minipools", "minipool_statuses", "withdrawal_addresses", "highest_block"} {
+		if _, err := s.db.Exec("DROP TABLE IF EXISTS " + table + ";"); err != nil {
+			return err
+		}
+	}
+
+	s.m.Counter("stale_schema_dropped").Inc()
+	return nil
+}
+
 func rollback(tx *sql.Tx) {
 	_ = tx.Rollback()
 }
@@ -160,7 +271,7 @@ func (s *SqliteCache) init() error {
 	s.m = metrics.NewMetricsRegistry("sqlite_cache")
 
 	// Set highestBlock to 0. We can load it from the snapshot later
-	s.highestBlock = big.NewInt(0)
+	s.highestBlock.Store(0)
 
 	s.db, err = sql.Open("sqlite3", "file::memory:?cache=shared")
 	if err != nil {
@@ -201,6 +312,12 @@ func (s *SqliteCache) init() error {
 		if err != nil {
 			return err
 		}
+
+		// Discard the snapshot if it's from an older version
+		err = s.dropStaleTables()
+		if err != nil {
+			return err
+		}
 	}
 cont:
 	err = s.createTables()
@@ -324,9 +441,56 @@ func (s *SqliteCache) addMinipoolNode(pubkey rptypes.ValidatorPubkey, nodeAddr c
 	return tx.Commit()
 }
 
+func (s *SqliteCache) removeMinipoolNode(pubkey rptypes.ValidatorPubkey) error {
+
+	tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: false, Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	_, err = tx.Stmt(s.deleteMinipoolStmt).Exec(pubkey[:])
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SqliteCache) getNodeMinipools(nodeAddr common.Address) ([]rptypes.ValidatorPubkey, error) {
+	var pubkey []byte
+
+	tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, err
+	}
+	defer rollback(tx)
+
+	rows, err := tx.Stmt(s.nodeMinipoolsStmt).Query(nodeAddr.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	var out []rptypes.ValidatorPubkey
+	for rows.Next() {
+		err = rows.Scan(&pubkey)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, rptypes.BytesToValidatorPubkey(pubkey))
+	}
+
+	return out, tx.Commit()
+}
+
 func (s *SqliteCache) getNodeInfo(nodeAddr common.Address) (*nodeInfo, error) {
 	var dbSPStatus int
 	var dbFeeDistributor []byte
+	var dbWithdrawalAddress []byte
+	var dbRPLStake []byte
+	var dbBond []byte
+	var dbUnknownFields int
 
 	tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelReadCommitted})
 	if err != nil {
@@ -346,7 +510,7 @@ func (s *SqliteCache) getNodeInfo(nodeAddr common.Address) (*nodeInfo, error) {
 		return nil, &NotFoundError{}
 	}
 
-	err = rows.Scan(&dbSPStatus, &dbFeeDistributor)
+	err = rows.Scan(&dbSPStatus, &dbFeeDistributor, &dbWithdrawalAddress, &dbRPLStake, &dbBond, &dbUnknownFields)
 	if err != nil {
 		return nil, err
 	}
@@ -355,10 +519,21 @@ func (s *SqliteCache) getNodeInfo(nodeAddr common.Address) (*nodeInfo, error) {
 		return nil, fmt.Errorf("retrieved more than one row for a minipool point query")
 	}
 
-	return &nodeInfo{
-		inSmoothingPool: dbSPStatus > 0,
-		feeDistributor:  common.BytesToAddress(dbFeeDistributor),
-	}, tx.Commit()
+	out := &nodeInfo{
+		inSmoothingPool:   dbSPStatus > 0,
+		feeDistributor:    common.BytesToAddress(dbFeeDistributor),
+		withdrawalAddress: common.BytesToAddress(dbWithdrawalAddress),
+		unknownFields:     NodeField(dbUnknownFields),
+	}
+
+	if out.unknownFields&RPLStakeField == 0 {
+		out.rplStake = big.NewInt(0).SetBytes(dbRPLStake)
+	}
+	if out.unknownFields&BondField == 0 {
+		out.bond = big.NewInt(0).SetBytes(dbBond)
+	}
+
+	return out, tx.Commit()
 }
 
 func (s *SqliteCache) addNodeInfo(nodeAddr common.Address, node *nodeInfo) error {
@@ -374,7 +549,38 @@ func (s *SqliteCache) addNodeInfo(nodeAddr common.Address, node *nodeInfo) error
 	}
 	defer rollback(tx)
 
-	_, err = tx.Stmt(s.setNodeStmt).Exec(nodeAddr.Bytes(), inSP, node.feeDistributor.Bytes())
+	var rplStake []byte
+	if node.rplStake != nil {
+		rplStake = node.rplStake.Bytes()
+	}
+	var bond []byte
+	if node.bond != nil {
+		bond = node.bond.Bytes()
+	}
+
+	_, err = tx.Stmt(s.setNodeStmt).Exec(nodeAddr.Bytes(),
+		inSP,
+		node.feeDistributor.Bytes(),
+		node.withdrawalAddress.Bytes(),
+		rplStake,
+		bond,
+		int(node.unknownFields))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SqliteCache) removeNodeInfo(nodeAddr common.Address) error {
+
+	tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: false, Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	_, err = tx.Stmt(s.deleteNodeStmt).Exec(nodeAddr.Bytes())
 	if err != nil {
 		return err
 	}
@@ -410,20 +616,162 @@ func (s *SqliteCache) forEachNode(closure ForEachNodeClosure) error {
 	return tx.Commit()
 }
 
-func (s *SqliteCache) setHighestBlock(block *big.Int) {
-	if s.highestBlock.Cmp(block) >= 0 {
-		return
+func (s *SqliteCache) forEachMinipool(closure ForEachMinipoolClosure) error {
+	var pubkey, address []byte
+
+	tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	rows, err := tx.Stmt(s.forEachMinipoolStmt).Query()
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		err = rows.Scan(&pubkey, &address)
+		if err != nil {
+			return err
+		}
+
+		if !closure(rptypes.BytesToValidatorPubkey(pubkey), common.BytesToAddress(address)) {
+			break
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SqliteCache) getMinipoolStatus(pubkey rptypes.ValidatorPubkey) (feerecipient.MinipoolStatus, error) {
+	var status feerecipient.MinipoolStatus
+
+	tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return feerecipient.MinipoolStatusUnknown, err
+	}
+	defer rollback(tx)
+
+	err = tx.Stmt(s.getMinipoolStatusStmt).QueryRow(pubkey[:]).Scan(&status)
+	if err == sql.ErrNoRows {
+		return feerecipient.MinipoolStatusUnknown, &NotFoundError{}
+	}
+	if err != nil {
+		return feerecipient.MinipoolStatusUnknown, err
+	}
+
+	return status, tx.Commit()
+}
+
+func (s *SqliteCache) getMinipoolPubkey(minipoolAddr common.Address) (rptypes.ValidatorPubkey, error) {
+	var pubkey []byte
+
+	tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return rptypes.ValidatorPubkey{}, err
+	}
+	defer rollback(tx)
+
+	err = tx.Stmt(s.getMinipoolPubkeyStmt).QueryRow(minipoolAddr.Bytes()).Scan(&pubkey)
+	if err == sql.ErrNoRows {
+		return rptypes.ValidatorPubkey{}, &NotFoundError{}
+	}
+	if err != nil {
+		return rptypes.ValidatorPubkey{}, err
+	}
+
+	return rptypes.BytesToValidatorPubkey(pubkey), tx.Commit()
+}
+
+func (s *SqliteCache) setMinipoolStatus(pubkey rptypes.ValidatorPubkey, minipoolAddr common.Address, status feerecipient.MinipoolStatus) error {
+
+	tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: false, Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	_, err = tx.Stmt(s.setMinipoolStatusStmt).Exec(pubkey[:], minipoolAddr.Bytes(), status)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SqliteCache) getWithdrawalAddress(pubkey rptypes.ValidatorPubkey) (common.Address, error) {
+	var addr []byte
+
+	tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return common.Address{}, err
+	}
+	defer rollback(tx)
+
+	rows, err := tx.Stmt(s.getWithdrawalAddressStmt).Query(pubkey[:])
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	if !rows.Next() {
+		if err := tx.Commit(); err != nil {
+			return common.Address{}, err
+		}
+		return common.Address{}, &NotFoundError{}
+	}
+
+	err = rows.Scan(&addr)
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	if rows.Next() {
+		return common.Address{}, fmt.Errorf("retrieved more than one row for a withdrawal address point query")
+	}
+
+	return common.BytesToAddress(addr), tx.Commit()
+}
+
+func (s *SqliteCache) addWithdrawalAddress(pubkey rptypes.ValidatorPubkey, addr common.Address) error {
+
+	tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: false, Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return err
 	}
+	defer rollback(tx)
+
+	_, err = tx.Stmt(s.setWithdrawalAddressStmt).Exec(pubkey[:], addr.Bytes())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SqliteCache) countNodes() (int, error) {
+	var count int
 
-	// Someone else owns this pointer, so make a new one
-	s.m.Gauge("highest_block").Set(float64(block.Uint64()))
-	s.highestBlock = big.NewInt(0)
-	s.highestBlock.Add(block, s.highestBlock)
+	err := s.db.QueryRow("SELECT COUNT(*) FROM nodes;").Scan(&count)
+	return count, err
+}
+
+func (s *SqliteCache) countMinipools() (int, error) {
+	var count int
+
+	err := s.db.QueryRow("SELECT COUNT(*) FROM minipools;").Scan(&count)
+	return count, err
+}
+
+func (s *SqliteCache) setHighestBlock(block *big.Int) {
+	if storeHighestBlock(&s.highestBlock, block) {
+		s.m.Gauge("highest_block").Set(float64(block.Uint64()))
+	}
 }
 
 func (s *SqliteCache) getHighestBlock() *big.Int {
 
-	return s.highestBlock
+	return big.NewInt(0).SetUint64(s.highestBlock.Load())
 }
 
 func (s *SqliteCache) reset() error {
@@ -438,10 +786,21 @@ func (s *SqliteCache) reset() error {
 		return err
 	}
 
+	_, err = s.db.Exec("DELETE FROM minipool_statuses;")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("DELETE FROM withdrawal_addresses;")
+	if err != nil {
+		return err
+	}
+
 	_, err = s.db.Exec("DELETE FROM highest_block;")
 	if err != nil {
 		return err
 	}
+	s.highestBlock.Store(0)
 
 	s.m.Counter("reset").Inc()
 	return nil
@@ -449,7 +808,7 @@ func (s *SqliteCache) reset() error {
 
 func (s *SqliteCache) deinit() error {
 	// Write the highest block into the db
-	block := s.highestBlock.Int64()
+	block := int64(s.highestBlock.Load())
 
 	tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: false, Isolation: sql.LevelReadCommitted})
 	if err != nil {
@@ -479,7 +838,16 @@ func (s *SqliteCache) deinit() error {
 	s.setMinipoolStmt.Close()
 	s.setNodeStmt.Close()
 	s.setHighestBlockStmt.Close()
+	s.deleteMinipoolStmt.Close()
+	s.deleteNodeStmt.Close()
 	s.forEachNodeStmt.Close()
+	s.forEachMinipoolStmt.Close()
+	s.nodeMinipoolsStmt.Close()
+	s.getMinipoolStatusStmt.Close()
+	s.getMinipoolPubkeyStmt.Close()
+	s.setMinipoolStatusStmt.Close()
+	s.getWithdrawalAddressStmt.Close()
+	s.setWithdrawalAddressStmt.Close()
 	s.db.Close()
 	return nil
 }
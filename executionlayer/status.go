@@ -0,0 +1,127 @@
+package executionlayer
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// How often the event loop refreshes the EC head Stale() compares the cache to
+	headRefreshInterval = 12 * time.Second
+	// How long a refresh may take. Stale() never waits on one.
+	headRefreshTimeout = 5 * time.Second
+	// How old the last successful refresh may be before the head is treated as unknown
+	headMaxAge = 3 * headRefreshInterval
+)
+
+// Status describes how current the ExecutionLayer's cache is
+type Status struct {
+	HighestBlock uint64 `json:"highest_block"`
+	Head         uint64 `json:"head"`
+	Nodes        int    `json:"nodes"`
+	Minipools    int    `json:"minipools"`
+	Stale        bool   `json:"stale"`
+}
+
+// refreshHead fetches the EC's current head and remembers it for Stale()
+func (e *ExecutionLayer) refreshHead(ctx context.Context) (uint64, error) {
+	client, _, _ := e.currentConnection()
+	header, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		e.headUnknown.Store(true)
+		return 0, err
+	}
+
+	head := header.Number.Uint64()
+	e.head.Store(head)
+	e.headRefreshed.Store(time.Now().UnixNano())
+	e.headUnknown.Store(false)
+	return head, nil
+}
+
+// refreshHeadOnce refreshes the head with headRefreshTimeout, logging failures
+func (e *ExecutionLayer) refreshHeadOnce(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, headRefreshTimeout)
+	defer cancel()
+
+	if _, err := e.refreshHead(ctx); err != nil && ctx.Err() != context.Canceled {
+		e.logger.Warn("Couldn't get the EC's head to check the cache for staleness", zap.Error(err))
+	}
+}
+
+// headRefreshLoop refreshes the head every headRefreshInterval until ctx is cancelled
+func (e *ExecutionLayer) headRefreshLoop(ctx context.Context) {
+	ticker := time.NewTicker(headRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.refreshHeadOnce(ctx)
+		}
+	}
+}
+
+func (e *ExecutionLayer) isStale(highestBlock uint64, head uint64) bool {
+	// After a failed backfill, highestBlock may be current while events are missing
+	if e.backfillFailed.Load() {
+		return true
+	}
+
+	// While disconnected, there's no telling what's been missed
+	if e.reconnectFailed.Load() {
+		return true
+	}
+
+	return head > highestBlock && head-highestBlock > e.StaleBlocks
+}
+
+// Status queries the EC for its current head and reports it alongside the state of the cache
+func (e *ExecutionLayer) Status(ctx context.Context) (*Status, error) {
+	if _, err := e.refreshHead(ctx); err != nil {
+		return nil, err
+	}
+
+	return e.CachedStatus()
+}
+
+// CachedStatus reports the state of the cache alongside the head headRefreshLoop last fetched, and whether it's
+// Stale(). It never waits on the EC, so it's cheap enough for health checks.
+func (e *ExecutionLayer) CachedStatus() (*Status, error) {
+	nodes, err := e.cache.countNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	minipools, err := e.cache.countMinipools()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Status{
+		HighestBlock: e.cache.getHighestBlock().Uint64(),
+		Head:         e.head.Load(),
+		Nodes:        nodes,
+		Minipools:    minipools,
+		Stale:        e.Stale(),
+	}, nil
+}
+
+// Stale returns true if the cache lags the EC's head by more than StaleBlocks, or if the head is unknown.
+// It only reads the head headRefreshLoop last fetched, so it's cheap enough to call for every request.
+func (e *ExecutionLayer) Stale() bool {
+	if e.headUnknown.Load() {
+		return true
+	}
+
+	// If refreshes have been failing or hanging, the head may be far behind the EC's
+	if time.Now().UnixNano()-e.headRefreshed.Load() > int64(headMaxAge) {
+		return true
+	}
+
+	return e.isStale(e.cache.getHighestBlock().Uint64(), e.head.Load())
+}
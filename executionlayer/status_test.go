@@ -0,0 +1,137 @@
+package executionlayer
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+)
+
+func TestStatus(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+	e.cache.setHighestBlock(big.NewInt(100))
+	e.client = &fakeECClient{head: 110}
+
+	status, err := e.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Status{HighestBlock: 100, Head: 110, Nodes: 2, Minipools: 3, Stale: false}
+	if *status != expected {
+		t.Fatalf("expected status %+v, got %+v", expected, *status)
+	}
+
+	// Fall further behind than StaleBlocks
+	e.client = &fakeECClient{head: 100 + e.StaleBlocks + 1}
+	status, err = e.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Stale {
+		t.Fatalf("expected the cache to be stale, got %+v", *status)
+	}
+}
+
+func TestCachedStatus(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	if err := e.preload(&bind.CallOpts{BlockNumber: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+	e.cache.setHighestBlock(big.NewInt(100))
+	client := &fakeECClient{head: 110}
+	e.client = client
+	e.refreshHeadOnce(context.Background())
+
+	// The EC isn't asked again, so its head moving on, or it failing, doesn't change the status
+	client.head = 200
+	client.headerErr = fmt.Errorf("connection refused")
+	status, err := e.CachedStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Status{HighestBlock: 100, Head: 110, Nodes: 2, Minipools: 3, Stale: false}
+	if *status != expected {
+		t.Fatalf("expected status %+v, got %+v", expected, *status)
+	}
+
+	// Until a refresh fails, and the head is unknown
+	e.refreshHeadOnce(context.Background())
+	status, err = e.CachedStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Stale {
+		t.Fatalf("expected the cache to be stale, got %+v", *status)
+	}
+}
+
+func TestStale(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	e.cache.setHighestBlock(big.NewInt(100))
+	client := &fakeECClient{head: 110}
+	e.client = client
+
+	// Until the head has been fetched, it's unknown
+	if !e.Stale() {
+		t.Fatal("expected the cache to be stale before the head is fetched")
+	}
+
+	e.refreshHeadOnce(context.Background())
+	if e.Stale() {
+		t.Fatal("expected the cache to be current")
+	}
+
+	// The head is cached between refreshes
+	client.head = 200
+	if e.Stale() {
+		t.Fatal("expected the cached head to be used")
+	}
+
+	e.refreshHeadOnce(context.Background())
+	if !e.Stale() {
+		t.Fatal("expected the cache to be stale")
+	}
+
+	// Catching up makes it current again
+	e.cache.setHighestBlock(big.NewInt(200))
+	if e.Stale() {
+		t.Fatal("expected the cache to be current")
+	}
+
+	// If the head can't be determined, the cache is stale
+	client.headerErr = fmt.Errorf("connection refused")
+	e.refreshHeadOnce(context.Background())
+	if !e.Stale() {
+		t.Fatal("expected the cache to be stale when the head is unknown")
+	}
+
+	// And it stays stale until a refresh succeeds
+	client.headerErr = nil
+	if !e.Stale() {
+		t.Fatal("expected the cache to be stale until the head is refreshed")
+	}
+	e.refreshHeadOnce(context.Background())
+	if e.Stale() {
+		t.Fatal("expected the cache to be current")
+	}
+
+	// If refreshes stop, the last head is too old to trust
+	e.headRefreshed.Store(time.Now().Add(-headMaxAge - time.Second).UnixNano())
+	if !e.Stale() {
+		t.Fatal("expected the cache to be stale when the head is too old")
+	}
+}
@@ -0,0 +1,169 @@
+package executionlayer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/internal/ttlcache"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+// The prefixes of withdrawal credentials: BLS ones, and those which commit to an execution layer address,
+// with or without compounding
+const (
+	blsWithdrawalPrefix         = 0x00
+	eth1WithdrawalPrefix        = 0x01
+	compoundingWithdrawalPrefix = 0x02
+)
+
+const withdrawalCredentialsBytes = 32
+
+// A BLS to execution change can be processed in any epoch, so by default validators with BLS
+// withdrawal credentials are checked again after an epoch has passed.
+const blsCredentialsRecheckInterval = 384 * time.Second
+
+func (e *ExecutionLayer) blsCredentialsTTL() time.Duration {
+	if e.BLSCredentialsTTL > 0 {
+		return e.BLSCredentialsTTL
+	}
+
+	return blsCredentialsRecheckInterval
+}
+
+// blsCredentialsCache returns the cache of validators known to have BLS withdrawal credentials, creating it
+// on first use, once BLSCredentialsTTL has been set
+func (e *ExecutionLayer) blsCredentialsCache() *ttlcache.Cache[struct{}] {
+	e.blsCredentialsOnce.Do(func() {
+		e.blsCredentials = ttlcache.New[struct{}](e.blsCredentialsTTL())
+	})
+	return e.blsCredentials
+}
+
+// WithdrawalCredentialsProvider looks up validators' current withdrawal credentials, which only the consensus layer knows.
+// Every pubkey is expected in one of the returned maps, so one unknown validator doesn't fail the rest.
+type WithdrawalCredentialsProvider interface {
+	GetValidatorsWithdrawalCredentials(ctx context.Context, pubkeys []rptypes.ValidatorPubkey) (map[rptypes.ValidatorPubkey][]byte, map[rptypes.ValidatorPubkey]error)
+}
+
+// ValidatorWithdrawalAddress returns the withdrawal address of a validator with 0x01 or 0x02 withdrawal credentials,
+// or nil if it has BLS withdrawal credentials.
+func (e *ExecutionLayer) ValidatorWithdrawalAddress(ctx context.Context, pubkey rptypes.ValidatorPubkey) (*common.Address, error) {
+	addrs, errs := e.ValidatorWithdrawalAddresses(ctx, []rptypes.ValidatorPubkey{pubkey})
+	if err, ok := errs[pubkey]; ok {
+		return nil, err
+	}
+
+	return addrs[pubkey], nil
+}
+
+// ValidatorWithdrawalAddresses is ValidatorWithdrawalAddress for many validators at once.
+// Those which aren't cached are looked up together. Each pubkey is either in the first map, with a nil address
+// if it has BLS withdrawal credentials, or in the second with the reason it couldn't be looked up.
+// Lookups still running when ctx is done, eg, because the request was abandoned, fail with its error.
+func (e *ExecutionLayer) ValidatorWithdrawalAddresses(ctx context.Context, pubkeys []rptypes.ValidatorPubkey) (map[rptypes.ValidatorPubkey]*common.Address, map[rptypes.ValidatorPubkey]error) {
+	addrs := make(map[rptypes.ValidatorPubkey]*common.Address, len(pubkeys))
+	errs := make(map[rptypes.ValidatorPubkey]error)
+	missing := make([]rptypes.ValidatorPubkey, 0, len(pubkeys))
+	seen := make(map[rptypes.ValidatorPubkey]bool, len(pubkeys))
+
+	for _, pubkey := range pubkeys {
+		if seen[pubkey] {
+			continue
+		}
+		seen[pubkey] = true
+
+		// Execution layer addresses can't be changed, so once cached they're never invalidated
+		addr, err := e.cache.getWithdrawalAddress(pubkey)
+		if err == nil {
+			e.m.Counter("withdrawal_address_cache_hit").Inc()
+			addrs[pubkey] = &addr
+			continue
+		}
+		if _, ok := err.(*NotFoundError); !ok {
+			errs[pubkey] = err
+			continue
+		}
+
+		// BLS credentials may be updated on the CL, so they're only trusted for BLSCredentialsTTL
+		if _, ok := e.blsCredentialsCache().Get(string(pubkey[:])); ok {
+			e.m.Counter("bls_credentials_cache_hit").Inc()
+			addrs[pubkey] = nil
+			continue
+		}
+
+		missing = append(missing, pubkey)
+	}
+
+	if len(missing) == 0 {
+		return addrs, errs
+	}
+
+	if e.WithdrawalCredentials == nil {
+		for _, pubkey := range missing {
+			errs[pubkey] = fmt.Errorf("no withdrawal credentials provider is configured")
+		}
+		return addrs, errs
+	}
+
+	credentials, lookupErrs := e.WithdrawalCredentials.GetValidatorsWithdrawalCredentials(ctx, missing)
+	for _, pubkey := range missing {
+		if err, ok := lookupErrs[pubkey]; ok {
+			errs[pubkey] = err
+			continue
+		}
+
+		addr, err := e.addWithdrawalCredentials(pubkey, credentials[pubkey])
+		if err != nil {
+			errs[pubkey] = err
+			continue
+		}
+		addrs[pubkey] = addr
+	}
+
+	return addrs, errs
+}
+
+// addWithdrawalCredentials caches a validator's withdrawal credentials fetched from the CL, and returns its withdrawal address
+func (e *ExecutionLayer) addWithdrawalCredentials(pubkey rptypes.ValidatorPubkey, credentials []byte) (*common.Address, error) {
+	if len(credentials) != withdrawalCredentialsBytes {
+		return nil, fmt.Errorf("invalid withdrawal credentials for validator %s: %x", pubkey.String(), credentials)
+	}
+
+	switch credentials[0] {
+	case blsWithdrawalPrefix:
+		e.blsCredentialsCache().Put(string(pubkey[:]), struct{}{})
+		return nil, nil
+	case eth1WithdrawalPrefix, compoundingWithdrawalPrefix:
+	default:
+		return nil, fmt.Errorf("unknown withdrawal credentials prefix for validator %s: %#x", pubkey.String(), credentials[0])
+	}
+
+	// The credentials were changed since they were last checked, if they were checked at all
+	e.blsCredentialsCache().Delete(string(pubkey[:]))
+
+	addr := common.BytesToAddress(credentials[12:])
+	err := e.cache.addWithdrawalAddress(pubkey, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	e.m.Counter("withdrawal_address_added").Inc()
+	return &addr, nil
+}
+
+// SoloValidatorFeeRecipient returns true if feeRecipient is the withdrawal address of the validator with the given pubkey.
+// Validators with BLS withdrawal credentials have no withdrawal address, so false is returned for them.
+func (e *ExecutionLayer) SoloValidatorFeeRecipient(ctx context.Context, pubkey rptypes.ValidatorPubkey, feeRecipient common.Address) (bool, error) {
+	addr, err := e.ValidatorWithdrawalAddress(ctx, pubkey)
+	if err != nil {
+		return false, err
+	}
+
+	if addr == nil {
+		return false, nil
+	}
+
+	return *addr == feeRecipient, nil
+}
@@ -0,0 +1,278 @@
+package executionlayer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+// fakeWithdrawalCredentials serves withdrawal credentials from memory and counts the pubkeys looked up
+type fakeWithdrawalCredentials struct {
+	credentials map[rptypes.ValidatorPubkey][]byte
+	lookups     int
+	batches     int
+}
+
+func (f *fakeWithdrawalCredentials) GetValidatorsWithdrawalCredentials(ctx context.Context, pubkeys []rptypes.ValidatorPubkey) (map[rptypes.ValidatorPubkey][]byte, map[rptypes.ValidatorPubkey]error) {
+	f.batches++
+	credentials := make(map[rptypes.ValidatorPubkey][]byte)
+	errs := make(map[rptypes.ValidatorPubkey]error)
+	for _, pubkey := range pubkeys {
+		f.lookups++
+		if c, ok := f.credentials[pubkey]; ok {
+			credentials[pubkey] = c
+		} else {
+			errs[pubkey] = fmt.Errorf("validator %s not found", pubkey.String())
+		}
+	}
+	return credentials, errs
+}
+
+func eth1Credentials(addr common.Address) []byte {
+	out := make([]byte, withdrawalCredentialsBytes)
+	out[0] = eth1WithdrawalPrefix
+	copy(out[12:], addr[:])
+	return out
+}
+
+func blsCredentials() []byte {
+	return make([]byte, withdrawalCredentialsBytes)
+}
+
+// advanceBLSCredentialsClock makes the BLS credentials cached so far d older
+func advanceBLSCredentialsClock(e *ExecutionLayer, d time.Duration) {
+	cache := e.blsCredentialsCache()
+	now := cache.Now
+	cache.Now = func() time.Time { return now().Add(d) }
+}
+
+func TestSoloValidatorFeeRecipient(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	withdrawalAddr := common.HexToAddress("0x4444444444444444444444444444444444444444")
+	provider := &fakeWithdrawalCredentials{
+		credentials: map[rptypes.ValidatorPubkey][]byte{
+			testPubkey(0x10): eth1Credentials(withdrawalAddr),
+		},
+	}
+	e.WithdrawalCredentials = provider
+
+	ok, err := e.SoloValidatorFeeRecipient(context.Background(), testPubkey(0x10), withdrawalAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected the withdrawal address to be a valid fee recipient")
+	}
+
+	ok, err = e.SoloValidatorFeeRecipient(context.Background(), testPubkey(0x10), testNode0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("expected any other address to be an invalid fee recipient")
+	}
+
+	// 0x01 credentials are only fetched once
+	if provider.lookups != 1 {
+		t.Fatalf("expected 1 lookup, got %d", provider.lookups)
+	}
+}
+
+func TestSoloValidatorCompoundingCredentials(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	withdrawalAddr := common.HexToAddress("0x4444444444444444444444444444444444444444")
+	compounding := eth1Credentials(withdrawalAddr)
+	compounding[0] = compoundingWithdrawalPrefix
+	unknown := eth1Credentials(withdrawalAddr)
+	unknown[0] = 0x03
+	e.WithdrawalCredentials = &fakeWithdrawalCredentials{
+		credentials: map[rptypes.ValidatorPubkey][]byte{
+			testPubkey(0x10): compounding,
+			testPubkey(0x11): unknown,
+		},
+	}
+
+	// 0x02 credentials commit to a withdrawal address just like 0x01 ones
+	ok, err := e.SoloValidatorFeeRecipient(context.Background(), testPubkey(0x10), withdrawalAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected the withdrawal address to be a valid fee recipient")
+	}
+
+	// Credentials with any other prefix aren't taken for BLS ones
+	if _, err := e.SoloValidatorFeeRecipient(context.Background(), testPubkey(0x11), withdrawalAddr); err == nil {
+		t.Fatal("expected an unknown prefix to be an error")
+	}
+	if _, ok := e.blsCredentialsCache().Get(string(testPubkey(0x11).Bytes())); ok {
+		t.Fatal("expected credentials with an unknown prefix not to be cached as BLS")
+	}
+}
+
+func TestSoloValidatorBLSCredentials(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	withdrawalAddr := common.HexToAddress("0x4444444444444444444444444444444444444444")
+	provider := &fakeWithdrawalCredentials{
+		credentials: map[rptypes.ValidatorPubkey][]byte{
+			testPubkey(0x10): blsCredentials(),
+		},
+	}
+	e.WithdrawalCredentials = provider
+
+	ok, err := e.SoloValidatorFeeRecipient(context.Background(), testPubkey(0x10), withdrawalAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("expected validators with BLS credentials to have no valid fee recipient")
+	}
+
+	// The validator changes its credentials, but they're still trusted for an epoch
+	provider.credentials[testPubkey(0x10)] = eth1Credentials(withdrawalAddr)
+	if ok, _ := e.SoloValidatorFeeRecipient(context.Background(), testPubkey(0x10), withdrawalAddr); ok {
+		t.Fatal("expected the BLS credentials to be cached")
+	}
+
+	// Once they're old enough, the CL is checked again
+	advanceBLSCredentialsClock(e, blsCredentialsRecheckInterval)
+	ok, err = e.SoloValidatorFeeRecipient(context.Background(), testPubkey(0x10), withdrawalAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected the changed credentials to be picked up")
+	}
+
+	if provider.lookups != 2 {
+		t.Fatalf("expected 2 lookups, got %d", provider.lookups)
+	}
+}
+
+func TestSoloValidatorBLSCredentialsTTL(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	withdrawalAddr := common.HexToAddress("0x4444444444444444444444444444444444444444")
+	provider := &fakeWithdrawalCredentials{
+		credentials: map[rptypes.ValidatorPubkey][]byte{
+			testPubkey(0x10): blsCredentials(),
+		},
+	}
+	e.WithdrawalCredentials = provider
+	e.BLSCredentialsTTL = time.Hour
+
+	if addr, err := e.ValidatorWithdrawalAddress(context.Background(), testPubkey(0x10)); err != nil || addr != nil {
+		t.Fatalf("expected no withdrawal address, got %v %v", addr, err)
+	}
+
+	// An epoch isn't long enough to recheck the credentials with a longer TTL
+	provider.credentials[testPubkey(0x10)] = eth1Credentials(withdrawalAddr)
+	advanceBLSCredentialsClock(e, blsCredentialsRecheckInterval)
+	if addr, err := e.ValidatorWithdrawalAddress(context.Background(), testPubkey(0x10)); err != nil || addr != nil {
+		t.Fatalf("expected the BLS credentials to be cached, got %v %v", addr, err)
+	}
+
+	advanceBLSCredentialsClock(e, time.Hour)
+	addr, err := e.ValidatorWithdrawalAddress(context.Background(), testPubkey(0x10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr == nil || *addr != withdrawalAddr {
+		t.Fatalf("expected withdrawal address %s, got %v", withdrawalAddr, addr)
+	}
+	if provider.lookups != 2 {
+		t.Fatalf("expected 2 lookups, got %d", provider.lookups)
+	}
+}
+
+func TestValidatorWithdrawalAddresses(t *testing.T) {
+	e, _, teardown := setup(t)
+	defer teardown()
+
+	withdrawalAddr := common.HexToAddress("0x4444444444444444444444444444444444444444")
+	provider := &fakeWithdrawalCredentials{
+		credentials: map[rptypes.ValidatorPubkey][]byte{
+			testPubkey(0x10): eth1Credentials(withdrawalAddr),
+			testPubkey(0x11): blsCredentials(),
+		},
+	}
+	e.WithdrawalCredentials = provider
+
+	// Everything is looked up together, and the unknown validator doesn't fail the others
+	pubkeys := []rptypes.ValidatorPubkey{testPubkey(0x10), testPubkey(0x11), testPubkey(0x12), testPubkey(0x10)}
+	addrs, errs := e.ValidatorWithdrawalAddresses(context.Background(), pubkeys)
+	if provider.batches != 1 || provider.lookups != 3 {
+		t.Fatalf("expected 3 pubkeys in 1 batch, got %d in %d", provider.lookups, provider.batches)
+	}
+	if addr := addrs[testPubkey(0x10)]; addr == nil || *addr != withdrawalAddr {
+		t.Fatalf("expected withdrawal address %s, got %v", withdrawalAddr, addr)
+	}
+	if addr, ok := addrs[testPubkey(0x11)]; !ok || addr != nil {
+		t.Fatalf("expected no withdrawal address for BLS credentials, got %v", addr)
+	}
+	if _, ok := errs[testPubkey(0x12)]; !ok || len(errs) != 1 {
+		t.Fatalf("expected only the unknown validator to fail, got %v", errs)
+	}
+
+	// Only the unknown validator is looked up again
+	_, errs = e.ValidatorWithdrawalAddresses(context.Background(), pubkeys)
+	if provider.batches != 2 || provider.lookups != 4 || len(errs) != 1 {
+		t.Fatalf("expected only the unknown validator to be looked up again, got %d pubkeys in %d batches", provider.lookups, provider.batches)
+	}
+}
+
+func TestSqliteCacheWithdrawalAddresses(t *testing.T) {
+	_, err := metrics.Init(metricsNamespace(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer metrics.Deinit()
+
+	dir := t.TempDir()
+	cache := &SqliteCache{Path: dir}
+	if err := cache.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.addWithdrawalAddress(testPubkey(0x10), testNode0); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.deinit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Withdrawal addresses survive a restart
+	cache = &SqliteCache{Path: dir}
+	if err := cache.init(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := cache.deinit(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	addr, err := cache.getWithdrawalAddress(testPubkey(0x10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != testNode0 {
+		t.Fatalf("expected withdrawal address %s, got %s", testNode0.String(), addr.String())
+	}
+
+	if _, err := cache.getWithdrawalAddress(testPubkey(0x11)); err == nil {
+		t.Fatal("expected an unknown validator not to be found")
+	}
+}
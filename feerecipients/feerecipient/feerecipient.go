@@ -0,0 +1,123 @@
+// Package feerecipient describes what a fee recipient source knows about a validator.
+// It's separate from feerecipients so that feerecipientstest can check sources' answers
+// without an import cycle through feerecipients' own tests.
+package feerecipient
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+// Source is where a validator's expected fee recipient comes from
+type Source int
+
+const (
+	// SourceUnknown means the source doesn't say, eg, the fee recipient was configured in a file
+	SourceUnknown Source = iota
+	// SourceSmoothingPool means the validator's node is in the smoothing pool
+	SourceSmoothingPool
+	// SourceFeeDistributor means the validator's node isn't in the smoothing pool, so must use its fee distributor
+	SourceFeeDistributor
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceSmoothingPool:
+		return "smoothing_pool"
+	case SourceFeeDistributor:
+		return "fee_distributor"
+	}
+
+	return "unknown"
+}
+
+// MinipoolStatus is where a minipool is in its lifecycle, in the order minipools move through them
+type MinipoolStatus int
+
+const (
+	// MinipoolStatusUnknown means the validator isn't a minipool, or the source doesn't know its status
+	MinipoolStatusUnknown MinipoolStatus = iota
+	MinipoolStatusInitialized
+	MinipoolStatusPrelaunch
+	MinipoolStatusStaking
+	MinipoolStatusWithdrawable
+	MinipoolStatusDissolved
+)
+
+var minipoolStatusNames = []string{"unknown", "initialized", "prelaunch", "staking", "withdrawable", "dissolved"}
+
+func (s MinipoolStatus) String() string {
+	if s < 0 || int(s) >= len(minipoolStatusNames) {
+		return "unknown"
+	}
+
+	return minipoolStatusNames[s]
+}
+
+// ParseMinipoolStatus converts the name String() returns back to a MinipoolStatus
+func ParseMinipoolStatus(name string) (MinipoolStatus, error) {
+	for status, n := range minipoolStatusNames {
+		if n == name {
+			return MinipoolStatus(status), nil
+		}
+	}
+
+	return MinipoolStatusUnknown, fmt.Errorf("unknown minipool status %q, expected one of %s", name, strings.Join(minipoolStatusNames[1:], ", "))
+}
+
+// Info is the fee recipient a validator must use
+type Info struct {
+	Expected common.Address
+	Source   Source
+	// The node which owns the validator, or the zero address if the source doesn't know
+	NodeAddress common.Address
+	// The status of the validator's minipool, so callers can decide whether its fee recipient is worth enforcing
+	Status MinipoolStatus
+}
+
+var (
+	// ErrNotMinipool means the source doesn't know the validator, so another source may
+	ErrNotMinipool = errors.New("validator is not known to the fee recipient source")
+	// ErrWrongNode means the validator belongs to a node other than the one asking about it
+	ErrWrongNode = errors.New("validator belongs to another node")
+	// ErrInconsistentCache means the source knows of the validator, but is missing what it needs to know its fee recipient
+	ErrInconsistentCache = errors.New("fee recipient source is inconsistent")
+)
+
+// MissingNodeError means the source knows a validator is a minipool of NodeAddress, but has no record of the node.
+// Unlike a fee distributor which hasn't been looked up yet, that's corruption. It wraps ErrInconsistentCache.
+type MissingNodeError struct {
+	Pubkey      rptypes.ValidatorPubkey
+	NodeAddress common.Address
+}
+
+func (e *MissingNodeError) Error() string {
+	return fmt.Sprintf("%v: minipool %s is owned by node %s, which isn't in the node index",
+		ErrInconsistentCache, e.Pubkey.String(), e.NodeAddress.String())
+}
+
+func (e *MissingNodeError) Unwrap() error {
+	return ErrInconsistentCache
+}
+
+// Legacy converts the result of a ValidatorFeeRecipient lookup to the (fee recipient, unowned, error)
+// convention lookups used to return, where an unknown validator is (nil, false, nil) and one which
+// belongs to another node is (nil, true, nil).
+//
+// Deprecated: check the error for ErrNotMinipool and ErrWrongNode instead. Legacy will be removed in the next release.
+func Legacy(info *Info, err error) (*common.Address, bool, error) {
+	switch {
+	case errors.Is(err, ErrNotMinipool):
+		return nil, false, nil
+	case errors.Is(err, ErrWrongNode):
+		return nil, true, nil
+	case err != nil:
+		return nil, false, err
+	}
+
+	return &info.Expected, false, nil
+}
@@ -0,0 +1,71 @@
+package feerecipient
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestLegacy(t *testing.T) {
+	info := &Info{Expected: common.HexToAddress("0x1111111111111111111111111111111111111111"), Source: SourceSmoothingPool}
+	broken := fmt.Errorf("%w: node is missing", ErrInconsistentCache)
+
+	for _, tc := range []struct {
+		name         string
+		info         *Info
+		err          error
+		feeRecipient *common.Address
+		unowned      bool
+		expectedErr  error
+	}{
+		{"minipool", info, nil, &info.Expected, false, nil},
+		{"not a minipool", nil, ErrNotMinipool, nil, false, nil},
+		{"wrong node", nil, ErrWrongNode, nil, true, nil},
+		{"wrapped wrong node", nil, fmt.Errorf("http source: %w", ErrWrongNode), nil, true, nil},
+		{"inconsistent cache", nil, broken, nil, false, broken},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			feeRecipient, unowned, err := Legacy(tc.info, tc.err)
+			if !errors.Is(err, tc.expectedErr) || (err == nil) != (tc.expectedErr == nil) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+			if unowned != tc.unowned {
+				t.Fatalf("expected unowned to be %v", tc.unowned)
+			}
+			if (feeRecipient == nil) != (tc.feeRecipient == nil) || (feeRecipient != nil && *feeRecipient != *tc.feeRecipient) {
+				t.Fatalf("expected fee recipient %v, got %v", tc.feeRecipient, feeRecipient)
+			}
+		})
+	}
+}
+
+func TestSourceString(t *testing.T) {
+	for source, expected := range map[Source]string{
+		SourceUnknown:        "unknown",
+		SourceSmoothingPool:  "smoothing_pool",
+		SourceFeeDistributor: "fee_distributor",
+		Source(42):           "unknown",
+	} {
+		if source.String() != expected {
+			t.Errorf("expected %s, got %s", expected, source.String())
+		}
+	}
+}
+
+func TestMinipoolStatus(t *testing.T) {
+	for status := MinipoolStatusUnknown; status <= MinipoolStatusDissolved; status++ {
+		parsed, err := ParseMinipoolStatus(status.String())
+		if err != nil || parsed != status {
+			t.Errorf("expected %s to parse as itself, got %v, %v", status, parsed, err)
+		}
+	}
+
+	if MinipoolStatus(42).String() != "unknown" {
+		t.Errorf("expected statuses out of range to be unknown, got %s", MinipoolStatus(42))
+	}
+	if _, err := ParseMinipoolStatus("exited"); err == nil {
+		t.Error("expected an error for a status which doesn't exist")
+	}
+}
@@ -0,0 +1,85 @@
+// Package feerecipientstest checks that implementations of feerecipients.FeeRecipientSource follow its contract
+package feerecipientstest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+// FeeRecipientSource is feerecipients.FeeRecipientSource, which can't be imported here
+// without stopping that package's own tests from using the contract
+type FeeRecipientSource interface {
+	ValidatorFeeRecipient(ctx context.Context, pubkey rptypes.ValidatorPubkey, nodeAddr *common.Address) (*feerecipient.Info, error)
+}
+
+// Fixture describes what the source under test knows
+type Fixture struct {
+	// A validator the source knows, the fee recipient it must use, and the node it belongs to
+	Known        rptypes.ValidatorPubkey
+	FeeRecipient common.Address
+	Owner        common.Address
+
+	// A validator the source doesn't know
+	Unknown rptypes.ValidatorPubkey
+}
+
+// A node which owns neither validator in a Fixture
+var otherNode = common.HexToAddress("0x0e0e0e0e0e0e0e0e0e0e0e0e0e0e0e0e0e0e0e0e")
+
+// TestFeeRecipientSource checks that source answers for the validators in fixture the way every FeeRecipientSource must
+func TestFeeRecipientSource(t *testing.T, source FeeRecipientSource, fixture Fixture) {
+	t.Helper()
+
+	owner := fixture.Owner
+	for _, tc := range []struct {
+		name         string
+		pubkey       rptypes.ValidatorPubkey
+		nodeAddr     *common.Address
+		feeRecipient *common.Address
+		err          error
+	}{
+		{"known validator", fixture.Known, nil, &fixture.FeeRecipient, nil},
+		{"known validator, owner", fixture.Known, &owner, &fixture.FeeRecipient, nil},
+		{"known validator, other node", fixture.Known, &otherNode, nil, feerecipient.ErrWrongNode},
+		{"unknown validator", fixture.Unknown, nil, nil, feerecipient.ErrNotMinipool},
+		{"unknown validator, owner", fixture.Unknown, &owner, nil, feerecipient.ErrNotMinipool},
+	} {
+		info, err := source.ValidatorFeeRecipient(context.Background(), tc.pubkey, tc.nodeAddr)
+		if tc.err != nil {
+			if !errors.Is(err, tc.err) {
+				t.Errorf("%s: expected %v, got %v", tc.name, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if info == nil || info.Expected != *tc.feeRecipient {
+			t.Errorf("%s: expected fee recipient %v, got %+v", tc.name, tc.feeRecipient, info)
+			continue
+		}
+		if info.NodeAddress != (common.Address{}) && info.NodeAddress != owner {
+			t.Errorf("%s: expected node %v, got %v", tc.name, owner, info.NodeAddress)
+		}
+	}
+
+	// Sources are queried concurrently by the proxy
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if info, err := source.ValidatorFeeRecipient(context.Background(), fixture.Known, &owner); err != nil || info == nil {
+				t.Errorf("expected concurrent lookups to succeed, got %+v %v", info, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
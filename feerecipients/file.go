@@ -0,0 +1,79 @@
+package feerecipients
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+// FileSource reads fee recipients from a json file, mapping validator pubkeys to an object with the fee recipient
+// they must use, and optionally the only node which may use them:
+//
+//	{"0xa1b2...": {"fee_recipient": "0x1234...", "node_address": "0x5678..."}}
+type FileSource struct {
+	sync.RWMutex
+	path       string
+	validators map[rptypes.ValidatorPubkey]*expectation
+}
+
+// LoadFileSource reads the fee recipients in path
+func LoadFileSource(path string) (*FileSource, error) {
+	out := &FileSource{path: path}
+	if err := out.Reload(); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// Reload re-reads the file. If it can't be read, the fee recipients already loaded are kept.
+func (f *FileSource) Reload() error {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	var raw map[string]*expectation
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("error parsing %s: %w", f.path, err)
+	}
+
+	validators := make(map[rptypes.ValidatorPubkey]*expectation, len(raw))
+	for key, e := range raw {
+		pubkey, err := rptypes.HexToValidatorPubkey(strings.TrimPrefix(key, "0x"))
+		if err != nil {
+			return fmt.Errorf("error parsing %s: %w", f.path, err)
+		}
+		if e == nil {
+			return fmt.Errorf("error parsing %s: validator %s has no fee recipient", f.path, key)
+		}
+		if err := e.validate(); err != nil {
+			return fmt.Errorf("error parsing %s: validator %s: %w", f.path, key, err)
+		}
+		validators[pubkey] = e
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	f.validators = validators
+	return nil
+}
+
+func (f *FileSource) ValidatorFeeRecipient(ctx context.Context, pubkey rptypes.ValidatorPubkey, nodeAddr *common.Address) (*feerecipient.Info, error) {
+	f.RLock()
+	defer f.RUnlock()
+
+	e, ok := f.validators[pubkey]
+	if !ok {
+		return nil, feerecipient.ErrNotMinipool
+	}
+
+	return e.feeRecipient(nodeAddr)
+}
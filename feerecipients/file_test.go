@@ -0,0 +1,99 @@
+package feerecipients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipientstest"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+var (
+	testOwner        = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	testFeeRecipient = common.HexToAddress("0x2222222222222222222222222222222222222222")
+)
+
+func testPubkey(b byte) rptypes.ValidatorPubkey {
+	var out rptypes.ValidatorPubkey
+	for i := range out {
+		out[i] = b
+	}
+	return out
+}
+
+func testFixture() feerecipientstest.Fixture {
+	return feerecipientstest.Fixture{
+		Known:        testPubkey(0x01),
+		FeeRecipient: testFeeRecipient,
+		Owner:        testOwner,
+		Unknown:      testPubkey(0x02),
+	}
+}
+
+func writeFileSource(t *testing.T, path string, contents string) {
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fee-recipients.json")
+	writeFileSource(t, path, fmt.Sprintf(`{"0x%s": {"fee_recipient": "%s", "node_address": "%s"}}`,
+		testPubkey(0x01).Hex(), testFeeRecipient, testOwner))
+
+	source, err := LoadFileSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feerecipientstest.TestFeeRecipientSource(t, source, testFixture())
+}
+
+func TestFileSourceReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fee-recipients.json")
+	writeFileSource(t, path, "{}")
+
+	source, err := LoadFileSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := source.ValidatorFeeRecipient(context.Background(), testPubkey(0x01), nil); !errors.Is(err, feerecipient.ErrNotMinipool) {
+		t.Fatalf("expected an unknown validator, got %+v %v", info, err)
+	}
+
+	// Validators without a node_address may be used by any node
+	writeFileSource(t, path, fmt.Sprintf(`{"%s": {"fee_recipient": "%s"}}`, testPubkey(0x01).Hex(), testFeeRecipient))
+	if err := source.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := source.ValidatorFeeRecipient(context.Background(), testPubkey(0x01), &testOwner)
+	if err != nil || info == nil || info.Expected != testFeeRecipient {
+		t.Fatalf("expected fee recipient %s, got %+v %v", testFeeRecipient, info, err)
+	}
+
+	// Broken files are refused, leaving the fee recipients in place
+	for _, contents := range []string{
+		"[]",
+		`{"0x1234": {"fee_recipient": "` + testFeeRecipient.String() + `"}}`,
+		`{"` + testPubkey(0x01).Hex() + `": {}}`,
+		`{"` + testPubkey(0x01).Hex() + `": null}`,
+	} {
+		writeFileSource(t, path, contents)
+		if err := source.Reload(); err == nil {
+			t.Fatalf("expected %s to be refused", contents)
+		}
+	}
+	if info, _ := source.ValidatorFeeRecipient(context.Background(), testPubkey(0x01), nil); info == nil {
+		t.Fatal("expected the fee recipients to be kept")
+	}
+
+	if _, err := LoadFileSource(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Fatal("expected a missing file to be refused")
+	}
+}
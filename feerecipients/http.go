@@ -0,0 +1,115 @@
+package feerecipients
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/internal/ttlcache"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+// The largest response an HTTPSource will read
+const maxHTTPResponseBytes = 64 * 1024
+
+// HTTPSource asks an HTTP endpoint for fee recipients. For each validator, it requests GET <endpoint>/0x<pubkey>,
+// which must respond with 404 if it doesn't know the validator, or a json object with the fee recipient it must
+// use, and optionally the only node which may use it:
+//
+//	{"fee_recipient": "0x1234...", "node_address": "0x5678..."}
+//
+// Answers, including 404s, are cached, and only so many requests are made at once, so a burst of lookups
+// doesn't flood the endpoint.
+type HTTPSource struct {
+	endpoint string
+	client   *http.Client
+	// What the endpoint said about each validator, or nil if it doesn't know it
+	cache *ttlcache.Cache[*expectation]
+	// Holds a token for each request in flight
+	inFlight chan struct{}
+}
+
+// NewHTTPSource creates an HTTPSource which gives endpoint up to timeout to answer each request, and makes at most
+// maxConns requests at once. Answers are remembered for cacheTTL, or not at all if it's 0.
+func NewHTTPSource(endpoint string, timeout time.Duration, cacheTTL time.Duration, maxConns int) (*HTTPSource, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("expected an http or https url, got %s", endpoint)
+	}
+	if maxConns < 1 {
+		return nil, fmt.Errorf("expected at least 1 connection, got %d", maxConns)
+	}
+
+	return &HTTPSource{
+		endpoint: strings.TrimSuffix(u.String(), "/"),
+		client:   &http.Client{Timeout: timeout},
+		cache:    ttlcache.New[*expectation](cacheTTL),
+		inFlight: make(chan struct{}, maxConns),
+	}, nil
+}
+
+func (h *HTTPSource) ValidatorFeeRecipient(ctx context.Context, pubkey rptypes.ValidatorPubkey, nodeAddr *common.Address) (*feerecipient.Info, error) {
+	key := pubkey.Hex()
+	e, ok := h.cache.Get(key)
+	if !ok {
+		var err error
+		e, err = h.fetch(ctx, pubkey)
+		if err != nil {
+			return nil, err
+		}
+		h.cache.Put(key, e)
+	}
+
+	if e == nil {
+		return nil, feerecipient.ErrNotMinipool
+	}
+	return e.feeRecipient(nodeAddr)
+}
+
+// fetch asks the endpoint about a validator, once a request slot is free. It returns nil if the endpoint doesn't know it.
+func (h *HTTPSource) fetch(ctx context.Context, pubkey rptypes.ValidatorPubkey) (*expectation, error) {
+	select {
+	case h.inFlight <- struct{}{}:
+		defer func() { <-h.inFlight }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.endpoint+"/0x"+pubkey.Hex(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("fee recipient endpoint responded to validator %s with %s", pubkey.Hex(), resp.Status)
+	}
+
+	var e expectation
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxHTTPResponseBytes)).Decode(&e); err != nil {
+		return nil, fmt.Errorf("error parsing the fee recipient endpoint's response for validator %s: %w", pubkey.Hex(), err)
+	}
+	if err := e.validate(); err != nil {
+		return nil, fmt.Errorf("fee recipient endpoint's response for validator %s: %w", pubkey.Hex(), err)
+	}
+
+	return &e, nil
+}
@@ -0,0 +1,156 @@
+package feerecipients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipientstest"
+)
+
+func TestHTTPSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/fee-recipients/0x"+testPubkey(0x01).Hex() {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"fee_recipient": "%s", "node_address": "%s"}`, testFeeRecipient, testOwner)
+	}))
+	defer server.Close()
+
+	source, err := NewHTTPSource(server.URL+"/fee-recipients/", time.Second, time.Minute, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feerecipientstest.TestFeeRecipientSource(t, source, testFixture())
+}
+
+func TestHTTPSourceErrors(t *testing.T) {
+	for name, response := range map[string]func(w http.ResponseWriter){
+		"server error": func(w http.ResponseWriter) { w.WriteHeader(http.StatusInternalServerError) },
+		"malformed":    func(w http.ResponseWriter) { fmt.Fprint(w, "{") },
+		"no recipient": func(w http.ResponseWriter) { fmt.Fprint(w, `{"node_address": "`+testOwner.String()+`"}`) },
+		"timeout":      func(w http.ResponseWriter) { time.Sleep(200 * time.Millisecond) },
+	} {
+		// Each case gets its own server, and Close waits for a handler which outlived its request
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			response(w)
+		}))
+		source, err := NewHTTPSource(server.URL, 100*time.Millisecond, 0, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info, err := source.ValidatorFeeRecipient(context.Background(), testPubkey(0x01), nil); err == nil || errors.Is(err, feerecipient.ErrNotMinipool) {
+			t.Errorf("%s: expected an error, got %+v %v", name, info, err)
+		}
+		server.Close()
+	}
+
+	if _, err := NewHTTPSource("ftp://example.com", time.Second, 0, 1); err == nil {
+		t.Fatal("expected a non-http url to be refused")
+	}
+	if _, err := NewHTTPSource("http://127.0.0.1:1", time.Second, 0, 0); err == nil {
+		t.Fatal("expected 0 connections to be refused")
+	}
+}
+
+func TestHTTPSourceCache(t *testing.T) {
+	var requests atomic.Int64
+	var fail atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Path != "/0x"+testPubkey(0x01).Hex() {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"fee_recipient": "%s"}`, testFeeRecipient)
+	}))
+	defer server.Close()
+
+	source, err := NewHTTPSource(server.URL, time.Second, time.Minute, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Known and unknown validators are both remembered
+	for i := 0; i < 3; i++ {
+		if info, err := source.ValidatorFeeRecipient(context.Background(), testPubkey(0x01), nil); err != nil || info.Expected != testFeeRecipient {
+			t.Fatalf("unexpected fee recipient %+v %v", info, err)
+		}
+		if _, err := source.ValidatorFeeRecipient(context.Background(), testPubkey(0x02), nil); !errors.Is(err, feerecipient.ErrNotMinipool) {
+			t.Fatalf("expected ErrNotMinipool, got %v", err)
+		}
+	}
+	if requests.Load() != 2 {
+		t.Fatalf("expected 2 requests, got %d", requests.Load())
+	}
+
+	// Errors aren't
+	fail.Store(true)
+	for i := 0; i < 2; i++ {
+		if _, err := source.ValidatorFeeRecipient(context.Background(), testPubkey(0x03), nil); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+	if requests.Load() != 4 {
+		t.Fatalf("expected 4 requests, got %d", requests.Load())
+	}
+}
+
+func TestHTTPSourceConcurrency(t *testing.T) {
+	var inFlight, most atomic.Int64
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := most.Load()
+			if n <= m || most.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		<-release
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	source, err := NewHTTPSource(server.URL, 5*time.Second, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _ = source.ValidatorFeeRecipient(context.Background(), testPubkey(byte(i)), nil)
+		}(i)
+	}
+
+	// A lookup waiting for a request slot gives up when its context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := source.ValidatorFeeRecipient(ctx, testPubkey(0x10), nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the lookup to be abandoned, got %v", err)
+	}
+
+	close(release)
+	wg.Wait()
+	if most.Load() != 2 {
+		t.Fatalf("expected at most 2 requests at once, got %d", most.Load())
+	}
+}
@@ -0,0 +1,68 @@
+package feerecipients
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+// FeeRecipientSource knows the fee recipients some validators must use.
+//
+// ValidatorFeeRecipient returns the expected fee recipient for a validator. If the source doesn't know the
+// validator, feerecipient.ErrNotMinipool is returned, and if nodeAddr isn't nil and the validator belongs
+// to another node, feerecipient.ErrWrongNode is. Any other error means the source knows of the validator,
+// but can't say what its fee recipient should be. Sources which make requests to answer must abandon them once
+// ctx is done, so lookups for clients which have gone away don't hold up others.
+//
+// *executionlayer.ExecutionLayer is the source for Rocket Pool minipools.
+type FeeRecipientSource interface {
+	ValidatorFeeRecipient(ctx context.Context, pubkey rptypes.ValidatorPubkey, nodeAddr *common.Address) (*feerecipient.Info, error)
+}
+
+// Chain asks each of its sources in turn, and returns the answer of the first one which recognizes the validator,
+// ie, returns either a fee recipient, or that the validator belongs to another node.
+type Chain []FeeRecipientSource
+
+func (c Chain) ValidatorFeeRecipient(ctx context.Context, pubkey rptypes.ValidatorPubkey, nodeAddr *common.Address) (*feerecipient.Info, error) {
+	for _, source := range c {
+		info, err := source.ValidatorFeeRecipient(ctx, pubkey, nodeAddr)
+		if errors.Is(err, feerecipient.ErrNotMinipool) {
+			continue
+		}
+
+		// Errors are returned too, since a later source can't be trusted to answer for a validator this one couldn't
+		return info, err
+	}
+
+	return nil, feerecipient.ErrNotMinipool
+}
+
+// expectation is what a FileSource or HTTPSource is told about a validator
+type expectation struct {
+	FeeRecipient *common.Address `json:"fee_recipient"`
+	// Optional. If set, only this node may use the validator.
+	NodeAddress *common.Address `json:"node_address,omitempty"`
+}
+
+func (e *expectation) validate() error {
+	if e.FeeRecipient == nil {
+		return errors.New("fee_recipient is missing")
+	}
+
+	return nil
+}
+
+func (e *expectation) feeRecipient(nodeAddr *common.Address) (*feerecipient.Info, error) {
+	if e.NodeAddress != nil && nodeAddr != nil && *e.NodeAddress != *nodeAddr {
+		return nil, feerecipient.ErrWrongNode
+	}
+
+	out := &feerecipient.Info{Expected: *e.FeeRecipient}
+	if e.NodeAddress != nil {
+		out.NodeAddress = *e.NodeAddress
+	}
+	return out, nil
+}
@@ -0,0 +1,88 @@
+package feerecipients
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipientstest"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+// mapSource knows a fixed set of validators, or fails every lookup with err
+type mapSource struct {
+	validators map[rptypes.ValidatorPubkey]*expectation
+	err        error
+	lookups    atomic.Int64
+}
+
+func (m *mapSource) ValidatorFeeRecipient(ctx context.Context, pubkey rptypes.ValidatorPubkey, nodeAddr *common.Address) (*feerecipient.Info, error) {
+	m.lookups.Add(1)
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	e, ok := m.validators[pubkey]
+	if !ok {
+		return nil, feerecipient.ErrNotMinipool
+	}
+
+	return e.feeRecipient(nodeAddr)
+}
+
+func TestChain(t *testing.T) {
+	feeRecipient, owner := testFeeRecipient, testOwner
+	source := Chain{
+		&mapSource{},
+		&mapSource{validators: map[rptypes.ValidatorPubkey]*expectation{
+			testPubkey(0x01): {FeeRecipient: &feeRecipient, NodeAddress: &owner},
+		}},
+	}
+
+	feerecipientstest.TestFeeRecipientSource(t, source, testFixture())
+}
+
+func TestChainOrder(t *testing.T) {
+	first, second := testFeeRecipient, common.HexToAddress("0x3333333333333333333333333333333333333333")
+	owner := testOwner
+	later := &mapSource{validators: map[rptypes.ValidatorPubkey]*expectation{
+		testPubkey(0x01): {FeeRecipient: &second},
+		testPubkey(0x02): {FeeRecipient: &second},
+	}}
+	chain := Chain{
+		&mapSource{validators: map[rptypes.ValidatorPubkey]*expectation{
+			testPubkey(0x01): {FeeRecipient: &first},
+			testPubkey(0x02): {FeeRecipient: &first, NodeAddress: &owner},
+		}},
+		later,
+	}
+
+	// The first source to recognize the validator wins
+	info, err := chain.ValidatorFeeRecipient(context.Background(), testPubkey(0x01), nil)
+	if err != nil || info == nil || info.Expected != first {
+		t.Fatalf("expected fee recipient %s, got %+v %v", first, info, err)
+	}
+
+	// Including when it says the validator is someone else's
+	other := common.HexToAddress("0x4444444444444444444444444444444444444444")
+	info, err = chain.ValidatorFeeRecipient(context.Background(), testPubkey(0x02), &other)
+	if !errors.Is(err, feerecipient.ErrWrongNode) || info != nil {
+		t.Fatalf("expected the validator to belong to another node, got %+v %v", info, err)
+	}
+	if later.lookups.Load() != 0 {
+		t.Fatalf("expected later sources not to be asked, got %d lookups", later.lookups.Load())
+	}
+
+	// Errors stop the search
+	broken := errors.New("broken")
+	chain = Chain{&mapSource{err: broken}, later}
+	if _, err := chain.ValidatorFeeRecipient(context.Background(), testPubkey(0x01), nil); !errors.Is(err, broken) {
+		t.Fatalf("expected %v, got %v", broken, err)
+	}
+	if later.lookups.Load() != 0 {
+		t.Fatalf("expected later sources not to be asked, got %d lookups", later.lookups.Load())
+	}
+}
@@ -0,0 +1,89 @@
+// Package guarded publishes what the proxy decided about each authenticated request to a guarded endpoint,
+// so the rescue node's operators can see who's using it as it happens.
+package guarded
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// How many requests a subscriber may fall behind by before requests are dropped for it
+const subscriberBufferSize = 256
+
+// Request describes an authenticated request to a guarded endpoint, and whether it was proxied
+type Request struct {
+	Time        time.Time
+	NodeAddress common.Address
+	// The HTTP path or gRPC method
+	Endpoint   string
+	Validators int
+	Accepted   bool
+	// Why the request was rejected, if it was. Requests proxied in shadow mode are Accepted, with the reason they
+	// would have been rejected for.
+	Reason string
+}
+
+// Feed fans guarded requests out from the request path to any number of subscribers.
+// It's best-effort: publishing never blocks, so requests nobody's ready to receive are dropped and counted.
+type Feed struct {
+	sync.Mutex
+	subscribers map[chan Request]struct{}
+	m           *metrics.MetricsRegistry
+}
+
+// NewFeed creates a Feed with no subscribers
+func NewFeed() *Feed {
+	return &Feed{
+		subscribers: make(map[chan Request]struct{}),
+		m:           metrics.NewMetricsRegistry("guarded_requests"),
+	}
+}
+
+// Subscribe returns a channel which receives every guarded request from now on, unless it falls behind,
+// and a function to unsubscribe with, which closes the channel.
+func (f *Feed) Subscribe() (<-chan Request, func()) {
+	requests := make(chan Request, subscriberBufferSize)
+
+	f.Lock()
+	defer f.Unlock()
+	f.subscribers[requests] = struct{}{}
+	f.m.Gauge("subscribers").Set(float64(len(f.subscribers)))
+
+	var once sync.Once
+	return requests, func() {
+		once.Do(func() {
+			f.Lock()
+			defer f.Unlock()
+			delete(f.subscribers, requests)
+			close(requests)
+			f.m.Gauge("subscribers").Set(float64(len(f.subscribers)))
+		})
+	}
+}
+
+// Publish sends a request to every subscriber which has room for it. It's safe to call on a nil Feed.
+func (f *Feed) Publish(request Request) {
+	if f == nil {
+		return
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	f.m.Counter("published").Inc()
+	if len(f.subscribers) == 0 {
+		f.m.CounterVec("dropped", "reason").WithLabelValues("no_subscribers").Inc()
+		return
+	}
+
+	for requests := range f.subscribers {
+		select {
+		case requests <- request:
+		default:
+			f.m.CounterVec("dropped", "reason").WithLabelValues("slow_subscriber").Inc()
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package guarded
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func setup(t *testing.T) *Feed {
+	_, err := metrics.Init("guarded_test_" + t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(metrics.Deinit)
+
+	return NewFeed()
+}
+
+func testRequest(validators int) Request {
+	return Request{
+		Time:        time.Now(),
+		NodeAddress: common.HexToAddress("0x1111111111111111111111111111111111111111"),
+		Endpoint:    "/eth/v1/validator/register_validator",
+		Validators:  validators,
+		Accepted:    true,
+	}
+}
+
+func TestFeed(t *testing.T) {
+	f := setup(t)
+
+	// Nobody's listening, so the request is dropped
+	f.Publish(testRequest(1))
+	if got := testutil.ToFloat64(f.m.CounterVec("dropped", "reason").WithLabelValues("no_subscribers")); got != 1 {
+		t.Fatalf("expected 1 dropped request, got %v", got)
+	}
+
+	first, unsubscribeFirst := f.Subscribe()
+	second, unsubscribeSecond := f.Subscribe()
+	defer unsubscribeSecond()
+	f.Publish(testRequest(2))
+	for _, requests := range []<-chan Request{first, second} {
+		if request := <-requests; request.Validators != 2 {
+			t.Fatalf("unexpected request %+v", request)
+		}
+	}
+
+	// Unsubscribing closes the channel, and may be done more than once
+	unsubscribeFirst()
+	unsubscribeFirst()
+	if _, ok := <-first; ok {
+		t.Fatal("expected the channel to be closed")
+	}
+	if got := testutil.ToFloat64(f.m.Gauge("subscribers")); got != 1 {
+		t.Fatalf("expected 1 subscriber, got %v", got)
+	}
+}
+
+func TestFeedSlowSubscriber(t *testing.T) {
+	f := setup(t)
+	requests, unsubscribe := f.Subscribe()
+	defer unsubscribe()
+
+	// Publishing never blocks, so the requests which don't fit are dropped, and the subscriber keeps the rest
+	for i := 0; i < subscriberBufferSize+10; i++ {
+		f.Publish(testRequest(i))
+	}
+	if got := testutil.ToFloat64(f.m.CounterVec("dropped", "reason").WithLabelValues("slow_subscriber")); got != 10 {
+		t.Fatalf("expected 10 dropped requests, got %v", got)
+	}
+	if request := <-requests; request.Validators != 0 {
+		t.Fatalf("expected the oldest request first, got %+v", request)
+	}
+
+	// Once it catches up, it gets requests again
+	for len(requests) > 0 {
+		<-requests
+	}
+	f.Publish(testRequest(1000))
+	if request := <-requests; request.Validators != 1000 {
+		t.Fatalf("unexpected request %+v", request)
+	}
+}
@@ -0,0 +1,25 @@
+// Package backoff computes the waits between attempts to reconnect to the proxy's upstreams
+package backoff
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff waits Initial after the first failed attempt, doubling with each attempt up to Max
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// Wait returns how long to wait after the given failed attempt, counting from 0.
+// It's jittered so many proxies don't reconnect in lockstep.
+func (b Backoff) Wait(attempt int) time.Duration {
+	wait := b.Max
+	if attempt < 32 && b.Initial<<attempt < b.Max {
+		wait = b.Initial << attempt
+	}
+
+	// Wait somewhere between half and all of the backoff
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
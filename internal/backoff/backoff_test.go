@@ -0,0 +1,23 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWait(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: time.Minute}
+	for attempt := 0; attempt < 64; attempt++ {
+		wait := b.Wait(attempt)
+		if wait <= 0 || wait > b.Max {
+			t.Fatalf("attempt %d: unexpected wait %v", attempt, wait)
+		}
+	}
+
+	if b.Wait(0) > b.Initial {
+		t.Fatalf("expected the first wait to be at most %v", b.Initial)
+	}
+	if b.Wait(63) < b.Max/2 {
+		t.Fatalf("expected later waits to be at least %v", b.Max/2)
+	}
+}
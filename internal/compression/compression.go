@@ -0,0 +1,106 @@
+// Package compression registers the compressors the API may use with grpc, so importing it is enough for the
+// server to decompress calls compressed with them, and to compress its responses to those calls the same way.
+package compression
+
+import (
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/gzip"
+)
+
+const (
+	// Zstd is listed first by Names. Clients only compress when asked to, since the API's responses are
+	// mostly random addresses, which barely compress.
+	Zstd = "zstd"
+	// Gzip is registered too, for clients in languages without a zstd compressor for grpc
+	Gzip = gzip.Name
+)
+
+// Larger than any message the API sends, the biggest of which are GetRocketPoolNodes' few megabytes
+const maxDecoderMemory = 64 << 20
+
+func init() {
+	encoding.RegisterCompressor(&zstdCompressor{})
+}
+
+// Names returns the compressors registered, in order of preference
+func Names() []string {
+	return []string{Zstd, Gzip}
+}
+
+// zstdCompressor pools its encoders and decoders, since each allocates several megabytes of windows and tables
+type zstdCompressor struct {
+	encoders sync.Pool
+	decoders sync.Pool
+}
+
+type zstdWriter struct {
+	*zstd.Encoder
+	c *zstdCompressor
+}
+
+// Close flushes the frame, then returns the encoder to the pool
+func (w *zstdWriter) Close() error {
+	err := w.Encoder.Close()
+	w.c.encoders.Put(w)
+	return err
+}
+
+// zstdReader wraps a pooled decoder for a single message
+type zstdReader struct {
+	d *zstd.Decoder
+	c *zstdCompressor
+	// Set once the decoder is back in the pool, after which it may be decoding another message
+	released bool
+}
+
+// Read returns the decoder to the pool once the message is read, since grpc doesn't close it
+func (r *zstdReader) Read(p []byte) (int, error) {
+	if r.released {
+		return 0, io.EOF
+	}
+
+	n, err := r.d.Read(p)
+	if err == io.EOF {
+		r.released = true
+		r.c.decoders.Put(r.d)
+	}
+	return n, err
+}
+
+func (c *zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	if z, ok := c.encoders.Get().(*zstdWriter); ok {
+		z.Encoder.Reset(w)
+		return z, nil
+	}
+
+	// Without concurrency, the encoder doesn't start goroutines, so those dropped by the pool needn't be closed
+	e, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdWriter{Encoder: e, c: c}, nil
+}
+
+func (c *zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	if d, ok := c.decoders.Get().(*zstd.Decoder); ok {
+		if err := d.Reset(r); err != nil {
+			return nil, err
+		}
+		return &zstdReader{d: d, c: c}, nil
+	}
+
+	// grpc limits how much of a message it reads, but not the window a frame may claim, so cap the memory that takes
+	d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxDecoderMemory))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdReader{d: d, c: c}, nil
+}
+
+func (c *zstdCompressor) Name() string {
+	return Zstd
+}
@@ -0,0 +1,104 @@
+package compression
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"google.golang.org/grpc/encoding"
+)
+
+func roundTrip(t *testing.T, c encoding.Compressor, message []byte) {
+	t.Helper()
+	r, err := c.Decompress(bytes.NewReader(compress(t, c, message)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decompressed, message) {
+		t.Fatalf("expected %d bytes back, got %d different ones", len(message), len(decompressed))
+	}
+}
+
+func TestCompressors(t *testing.T) {
+	random := make([]byte, 4096)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	messages := [][]byte{{}, bytes.Repeat([]byte("rocket pool"), 1000), random}
+
+	for _, name := range Names() {
+		c := encoding.GetCompressor(name)
+		if c == nil {
+			t.Fatalf("expected %s to be registered", name)
+		}
+		// The second pass reuses the encoders and decoders pooled by the first
+		for i := 0; i < 2; i++ {
+			for _, message := range messages {
+				roundTrip(t, c, message)
+			}
+		}
+	}
+}
+
+func compress(t *testing.T, c encoding.Compressor, message []byte) []byte {
+	t.Helper()
+	var compressed bytes.Buffer
+	w, err := c.Compress(&compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(message); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return compressed.Bytes()
+}
+
+func TestZstdReadAfterEOF(t *testing.T) {
+	c := encoding.GetCompressor(Zstd)
+	first := bytes.Repeat([]byte("rocket pool"), 1000)
+	second := bytes.Repeat([]byte("rescue node"), 1000)
+
+	r, err := c.Decompress(bytes.NewReader(compress(t, c, first)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	// Reading past the end again doesn't return the decoder to the pool a second time
+	for i := 0; i < 2; i++ {
+		if n, err := r.Read(make([]byte, 16)); n != 0 || err != io.EOF {
+			t.Fatalf("expected EOF after the message, got %d bytes and %v", n, err)
+		}
+	}
+
+	// Were the decoder pooled twice, both these messages would be decoded by it at once
+	r1, err := c.Decompress(bytes.NewReader(compress(t, c, first)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := c.Decompress(bytes.NewReader(compress(t, c, second)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		r        io.Reader
+		expected []byte
+	}{{r1, first}, {r2, second}} {
+		decompressed, err := io.ReadAll(tc.r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decompressed, tc.expected) {
+			t.Fatal("expected each message to be decoded on its own")
+		}
+	}
+}
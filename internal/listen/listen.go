@@ -0,0 +1,90 @@
+// Package listen opens the proxy's listeners, on either tcp addresses or unix sockets
+package listen
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// UnixPrefix marks an address as a unix socket path, eg, unix:///var/run/rescue-proxy.sock
+const UnixPrefix = "unix://"
+
+// DefaultSocketMode lets the socket's owner and group connect to it
+const DefaultSocketMode os.FileMode = 0o660
+
+// How long to wait for anything still listening on a socket to answer, before deciding it's stale
+const staleDialTimeout = time.Second
+
+// SocketPath returns the path of the unix socket addr refers to, and whether it refers to one at all
+func SocketPath(addr string) (string, bool) {
+	if !strings.HasPrefix(addr, UnixPrefix) {
+		return "", false
+	}
+
+	return addr[len(UnixPrefix):], true
+}
+
+// removeStale removes a socket left behind at path by a process which didn't shut down cleanly.
+// Sockets something is still listening on are left alone, as is anything which isn't a socket.
+func removeStale(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s already exists, and isn't a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, staleDialTimeout)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is already in use", path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// Listen listens on addr, which is either a tcp host:port, or a unix socket path prefixed with unix://.
+// Sockets are created with mode, once any stale socket at their path is removed, and their files are
+// removed again when the listener is closed. A mode of 0 means DefaultSocketMode.
+func Listen(addr string, mode os.FileMode) (net.Listener, error) {
+	path, ok := SocketPath(addr)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	if path == "" {
+		return nil, fmt.Errorf("%s has no socket path", addr)
+	}
+
+	if err := removeStale(path); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode == 0 {
+		mode = DefaultSocketMode
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
@@ -0,0 +1,140 @@
+package listen
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// unixClient is an http client which connects to the socket at path, whatever the request's host
+func unixClient(path string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+func TestListenTCP(t *testing.T) {
+	l, err := Listen("127.0.0.1:0", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if l.Addr().Network() != "tcp" {
+		t.Fatalf("expected a tcp listener, got %s", l.Addr().Network())
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.sock")
+	l, err := Listen(UnixPrefix+path, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0o600 {
+		t.Fatalf("unexpected socket mode %v", info.Mode())
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("OK"))
+	})}
+	go func() {
+		_ = server.Serve(l)
+	}()
+
+	resp, err := unixClient(path).Get("http://rescue-proxy/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "OK" {
+		t.Fatalf("unexpected response %q %v", body, err)
+	}
+
+	// Shutting down removes the socket
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the socket to be removed, got %v", err)
+	}
+}
+
+func TestListenDefaultMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.sock")
+	l, err := Listen(UnixPrefix+path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != DefaultSocketMode {
+		t.Fatalf("expected mode %v, got %v", DefaultSocketMode, info.Mode().Perm())
+	}
+}
+
+func TestListenStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.sock")
+
+	// A process which was killed leaves its socket behind
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	if _, err := os.Lstat(path); err != nil {
+		t.Fatalf("expected the stale socket to be left behind, got %v", err)
+	}
+
+	l, err := Listen(UnixPrefix+path, 0)
+	if err != nil {
+		t.Fatalf("expected the stale socket to be replaced, got %v", err)
+	}
+	defer l.Close()
+
+	// A socket which is still being listened on is not
+	if _, err := Listen(UnixPrefix+path, 0); err == nil {
+		t.Fatal("expected a socket in use to be refused")
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("expected the socket in use to be left alone, got %v", err)
+	}
+	conn.Close()
+}
+
+func TestListenNotASocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.sock")
+	if err := os.WriteFile(path, []byte("important"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Listen(UnixPrefix+path, 0); err == nil {
+		t.Fatal("expected a file which isn't a socket to be refused")
+	}
+	if contents, err := os.ReadFile(path); err != nil || string(contents) != "important" {
+		t.Fatalf("expected the file to be left alone, got %q %v", contents, err)
+	}
+
+	if _, err := Listen(UnixPrefix, 0); err == nil {
+		t.Fatal("expected an empty socket path to be refused")
+	}
+}
@@ -0,0 +1,425 @@
+// Package mocks has in-memory stand-ins for the services the proxy depends on, so their consumers can be tested without them
+package mocks
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/executionlayer"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+// How many node events a subscriber may fall behind by before it's dropped
+const nodeEventBufferSize = 64
+
+type mockNode struct {
+	inSmoothingPool bool
+	feeDistributor  common.Address
+}
+
+// MockExecutionLayer is an executionlayer.RPInfoProvider which answers from whatever it's seeded with,
+// instead of an EC. It's safe for concurrent use, so it can be seeded while requests are being served.
+type MockExecutionLayer struct {
+	sync.RWMutex
+
+	// The fee recipient of validators whose node is in the smoothing pool
+	SmoothingPool common.Address
+	// Returned by Init, if set
+	InitErr error
+	// Returned by Status, if set. CachedStatus never reads the EC, so ignores it
+	StatusErr error
+
+	nodes     map[common.Address]*mockNode
+	minipools map[rptypes.ValidatorPubkey]common.Address
+	// Minipools which aren't in it are staking
+	statuses map[rptypes.ValidatorPubkey]feerecipient.MinipoolStatus
+	// nil for validators with BLS withdrawal credentials
+	withdrawalAddresses map[rptypes.ValidatorPubkey]*common.Address
+	allowlist           []executionlayer.AllowedFeeRecipient
+	stale               bool
+	warming             bool
+	highestBlock        uint64
+	head                uint64
+
+	subscribers []chan executionlayer.NodeEvent
+}
+
+var _ executionlayer.RPInfoProvider = (*MockExecutionLayer)(nil)
+
+// NewMockExecutionLayer returns a MockExecutionLayer which knows no nodes or validators
+func NewMockExecutionLayer(smoothingPool common.Address) *MockExecutionLayer {
+	return &MockExecutionLayer{
+		SmoothingPool:       smoothingPool,
+		nodes:               make(map[common.Address]*mockNode),
+		minipools:           make(map[rptypes.ValidatorPubkey]common.Address),
+		statuses:            make(map[rptypes.ValidatorPubkey]feerecipient.MinipoolStatus),
+		withdrawalAddresses: make(map[rptypes.ValidatorPubkey]*common.Address),
+	}
+}
+
+// AddNode seeds a node, replacing it if it's already known, and publishes its registration to subscribers
+func (m *MockExecutionLayer) AddNode(nodeAddr common.Address, inSmoothingPool bool, feeDistributor common.Address) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.nodes[nodeAddr] = &mockNode{inSmoothingPool: inSmoothingPool, feeDistributor: feeDistributor}
+	m.publish(executionlayer.NodeEvent{Type: executionlayer.NodeRegistered, NodeAddress: nodeAddr, InSmoothingPool: inSmoothingPool})
+}
+
+// RemoveNode forgets a node, but not its minipools, so their fee recipients can't be determined,
+// as though the cache were inconsistent
+func (m *MockExecutionLayer) RemoveNode(nodeAddr common.Address) {
+	m.Lock()
+	defer m.Unlock()
+
+	delete(m.nodes, nodeAddr)
+	m.publish(executionlayer.NodeEvent{Type: executionlayer.NodeRegistrationReverted, NodeAddress: nodeAddr})
+}
+
+// SetSmoothingPoolStatus opts a known node in or out of the smoothing pool, and publishes the change to subscribers
+func (m *MockExecutionLayer) SetSmoothingPoolStatus(nodeAddr common.Address, inSmoothingPool bool) error {
+	m.Lock()
+	defer m.Unlock()
+
+	n, ok := m.nodes[nodeAddr]
+	if !ok {
+		return fmt.Errorf("node %s hasn't been added", nodeAddr.String())
+	}
+	n.inSmoothingPool = inSmoothingPool
+	m.publish(executionlayer.NodeEvent{Type: executionlayer.NodeSmoothingPoolStatusChanged, NodeAddress: nodeAddr, InSmoothingPool: inSmoothingPool})
+	return nil
+}
+
+// AddMinipool seeds minipool validators belonging to nodeAddr, which needn't have been added yet
+func (m *MockExecutionLayer) AddMinipool(nodeAddr common.Address, pubkeys ...rptypes.ValidatorPubkey) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, pubkey := range pubkeys {
+		m.minipools[pubkey] = nodeAddr
+	}
+}
+
+// SetMinipoolStatus sets the status of a minipool validator
+func (m *MockExecutionLayer) SetMinipoolStatus(pubkey rptypes.ValidatorPubkey, status feerecipient.MinipoolStatus) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.statuses[pubkey] = status
+}
+
+// SetWithdrawalAddress seeds the withdrawal address of a validator, or that it has BLS withdrawal credentials if addr is nil.
+// Withdrawal addresses of validators which weren't seeded can't be looked up.
+func (m *MockExecutionLayer) SetWithdrawalAddress(pubkey rptypes.ValidatorPubkey, addr *common.Address) {
+	m.Lock()
+	defer m.Unlock()
+
+	if addr != nil {
+		copied := *addr
+		addr = &copied
+	}
+	m.withdrawalAddresses[pubkey] = addr
+}
+
+// SetStale sets whether Stale, Status and CachedStatus report the cache as stale
+func (m *MockExecutionLayer) SetStale(stale bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.stale = stale
+}
+
+// SetWarming sets whether Warming reports the cache as still warming up
+func (m *MockExecutionLayer) SetWarming(warming bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.warming = warming
+}
+
+// SetAllowedFeeRecipients sets the fee recipients any minipool may use, besides the one expected of it
+func (m *MockExecutionLayer) SetAllowedFeeRecipients(allowlist ...executionlayer.AllowedFeeRecipient) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.allowlist = allowlist
+}
+
+// SetBlocks sets the highest block the cache has seen and the EC's head, as reported by Status
+func (m *MockExecutionLayer) SetBlocks(highestBlock, head uint64) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.highestBlock, m.head = highestBlock, head
+}
+
+func (m *MockExecutionLayer) Init() error {
+	return m.InitErr
+}
+
+// Deinit closes every subscriber's channel, like the ExecutionLayer does when it shuts down
+func (m *MockExecutionLayer) Deinit() {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, sub := range m.subscribers {
+		close(sub)
+	}
+	m.subscribers = nil
+}
+
+// sortedNodes returns the known node addresses in order, so iteration is deterministic. The lock must be held.
+func (m *MockExecutionLayer) sortedNodes() []common.Address {
+	out := make([]common.Address, 0, len(m.nodes))
+	for addr := range m.nodes {
+		out = append(out, addr)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Hex() < out[j].Hex() })
+	return out
+}
+
+func (m *MockExecutionLayer) ForEachNode(closure executionlayer.ForEachNodeClosure) error {
+	return m.ForEachNodeInfo(func(addr common.Address, _ bool, _ common.Address) bool {
+		return closure(addr)
+	})
+}
+
+// ForEachNodeInfo iterates over a snapshot of the nodes, so closure may seed the mock
+func (m *MockExecutionLayer) ForEachNodeInfo(closure executionlayer.ForEachNodeInfoClosure) error {
+	m.RLock()
+	addrs := m.sortedNodes()
+	nodes := make([]mockNode, len(addrs))
+	for i, addr := range addrs {
+		nodes[i] = *m.nodes[addr]
+	}
+	m.RUnlock()
+
+	for i, addr := range addrs {
+		if !closure(addr, nodes[i].inSmoothingPool, nodes[i].feeDistributor) {
+			break
+		}
+	}
+	return nil
+}
+
+// SnapshotNodes returns the nodes in order of address, rather than the order they were seeded in
+func (m *MockExecutionLayer) SnapshotNodes() *executionlayer.NodeSnapshot {
+	m.RLock()
+	defer m.RUnlock()
+
+	out := &executionlayer.NodeSnapshot{Block: m.highestBlock}
+	for _, addr := range m.sortedNodes() {
+		n := m.nodes[addr]
+		out.Nodes = append(out.Nodes, executionlayer.SnapshotNode{
+			Address:         addr,
+			InSmoothingPool: n.inSmoothingPool,
+			FeeDistributor:  n.feeDistributor,
+		})
+	}
+	return out
+}
+
+func (m *MockExecutionLayer) GetNodeInfo(nodeAddr common.Address) (*executionlayer.NodeInfo, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	n, ok := m.nodes[nodeAddr]
+	if !ok {
+		return nil, &executionlayer.NotFoundError{}
+	}
+
+	out := &executionlayer.NodeInfo{
+		InSmoothingPool: n.inSmoothingPool,
+		FeeDistributor:  n.feeDistributor,
+		MinipoolPubkeys: []rptypes.ValidatorPubkey{},
+	}
+	for pubkey, owner := range m.minipools {
+		if owner == nodeAddr {
+			out.MinipoolPubkeys = append(out.MinipoolPubkeys, pubkey)
+		}
+	}
+	sort.Slice(out.MinipoolPubkeys, func(i, j int) bool { return out.MinipoolPubkeys[i].Hex() < out.MinipoolPubkeys[j].Hex() })
+	return out, nil
+}
+
+func (m *MockExecutionLayer) GetNodeMinipools(nodeAddr common.Address) (*executionlayer.NodeMinipools, error) {
+	info, err := m.GetNodeInfo(nodeAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+	return &executionlayer.NodeMinipools{Pubkeys: info.MinipoolPubkeys, Block: m.highestBlock}, nil
+}
+
+// NodeRegistered reports whether the node was seeded. Unlike the ExecutionLayer, there's no chain to fall back to.
+func (m *MockExecutionLayer) NodeRegistered(ctx context.Context, nodeAddr common.Address) (bool, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	_, ok := m.nodes[nodeAddr]
+	return ok, nil
+}
+
+func (m *MockExecutionLayer) SmoothingPoolInfo() (*executionlayer.SmoothingPoolInfo, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	out := &executionlayer.SmoothingPoolInfo{
+		Address: m.SmoothingPool,
+		Nodes:   len(m.nodes),
+		Block:   m.highestBlock,
+	}
+	for _, n := range m.nodes {
+		if n.inSmoothingPool {
+			out.OptedInNodes++
+		}
+	}
+	return out, nil
+}
+
+// SubscribeNodeEvents returns a channel which receives the node changes seeded from now on.
+// Like the ExecutionLayer, subscribers which fall behind are dropped, and their channels closed.
+func (m *MockExecutionLayer) SubscribeNodeEvents() (<-chan executionlayer.NodeEvent, func()) {
+	m.Lock()
+	defer m.Unlock()
+
+	sub := make(chan executionlayer.NodeEvent, nodeEventBufferSize)
+	m.subscribers = append(m.subscribers, sub)
+	return sub, func() {
+		m.Lock()
+		defer m.Unlock()
+
+		for i, s := range m.subscribers {
+			if s == sub {
+				m.subscribers = append(m.subscribers[:i], m.subscribers[i+1:]...)
+				close(sub)
+				return
+			}
+		}
+	}
+}
+
+// publish sends an event to every subscriber without blocking. The lock must be held.
+func (m *MockExecutionLayer) publish(event executionlayer.NodeEvent) {
+	kept := m.subscribers[:0]
+	for _, sub := range m.subscribers {
+		select {
+		case sub <- event:
+			kept = append(kept, sub)
+		default:
+			close(sub)
+		}
+	}
+	m.subscribers = kept
+}
+
+func (m *MockExecutionLayer) ValidatorFeeRecipient(ctx context.Context, pubkey rptypes.ValidatorPubkey, queryNodeAddr *common.Address) (*feerecipient.Info, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	nodeAddr, ok := m.minipools[pubkey]
+	if !ok {
+		return nil, feerecipient.ErrNotMinipool
+	}
+	if queryNodeAddr != nil && *queryNodeAddr != nodeAddr {
+		return nil, feerecipient.ErrWrongNode
+	}
+
+	n, ok := m.nodes[nodeAddr]
+	if !ok {
+		return nil, &feerecipient.MissingNodeError{Pubkey: pubkey, NodeAddress: nodeAddr}
+	}
+
+	status, ok := m.statuses[pubkey]
+	if !ok {
+		status = feerecipient.MinipoolStatusStaking
+	}
+
+	if n.inSmoothingPool {
+		return &feerecipient.Info{Expected: m.SmoothingPool, Source: feerecipient.SourceSmoothingPool, NodeAddress: nodeAddr, Status: status}, nil
+	}
+	return &feerecipient.Info{Expected: n.feeDistributor, Source: feerecipient.SourceFeeDistributor, NodeAddress: nodeAddr, Status: status}, nil
+}
+
+func (m *MockExecutionLayer) ValidatorWithdrawalAddresses(ctx context.Context, pubkeys []rptypes.ValidatorPubkey) (map[rptypes.ValidatorPubkey]*common.Address, map[rptypes.ValidatorPubkey]error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	addrs := make(map[rptypes.ValidatorPubkey]*common.Address, len(pubkeys))
+	errs := make(map[rptypes.ValidatorPubkey]error)
+	for _, pubkey := range pubkeys {
+		addr, ok := m.withdrawalAddresses[pubkey]
+		if !ok {
+			errs[pubkey] = fmt.Errorf("the withdrawal address of validator %s hasn't been set", pubkey.String())
+			continue
+		}
+		addrs[pubkey] = addr
+	}
+	return addrs, errs
+}
+
+func (m *MockExecutionLayer) SoloValidatorFeeRecipient(ctx context.Context, pubkey rptypes.ValidatorPubkey, feeRecipient common.Address) (bool, error) {
+	addrs, errs := m.ValidatorWithdrawalAddresses(ctx, []rptypes.ValidatorPubkey{pubkey})
+	if err, ok := errs[pubkey]; ok {
+		return false, err
+	}
+
+	addr := addrs[pubkey]
+	return addr != nil && *addr == feeRecipient, nil
+}
+
+func (m *MockExecutionLayer) AllowedFeeRecipients() []executionlayer.AllowedFeeRecipient {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.allowlist
+}
+
+func (m *MockExecutionLayer) Status(ctx context.Context) (*executionlayer.Status, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if m.StatusErr != nil {
+		return nil, m.StatusErr
+	}
+
+	return m.cachedStatus(), nil
+}
+
+// CachedStatus is Status without the EC, so it's unaffected by StatusErr
+func (m *MockExecutionLayer) CachedStatus() (*executionlayer.Status, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.cachedStatus(), nil
+}
+
+func (m *MockExecutionLayer) cachedStatus() *executionlayer.Status {
+	return &executionlayer.Status{
+		HighestBlock: m.highestBlock,
+		Head:         m.head,
+		Nodes:        len(m.nodes),
+		Minipools:    len(m.minipools),
+		Stale:        m.stale,
+	}
+}
+
+func (m *MockExecutionLayer) Stale() bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.stale
+}
+
+func (m *MockExecutionLayer) Warming() bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.warming
+}
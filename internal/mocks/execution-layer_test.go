@@ -0,0 +1,146 @@
+package mocks
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/executionlayer"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipientstest"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+var (
+	testNode0          = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	testNode1          = common.HexToAddress("0x2222222222222222222222222222222222222222")
+	testSmoothingPool  = common.HexToAddress("0xcccccccccccccccccccccccccccccccccccccccc")
+	testFeeDistributor = common.HexToAddress("0xfdfdfdfdfdfdfdfdfdfdfdfdfdfdfdfdfdfdfdfd")
+)
+
+func testPubkey(b byte) rptypes.ValidatorPubkey {
+	var out rptypes.ValidatorPubkey
+	out[0] = b
+	return out
+}
+
+func seeded() *MockExecutionLayer {
+	m := NewMockExecutionLayer(testSmoothingPool)
+	m.AddNode(testNode0, true, common.Address{})
+	m.AddNode(testNode1, false, testFeeDistributor)
+	m.AddMinipool(testNode0, testPubkey(0x01), testPubkey(0x02))
+	m.AddMinipool(testNode1, testPubkey(0x03))
+	return m
+}
+
+func TestMockFeeRecipientContract(t *testing.T) {
+	feerecipientstest.TestFeeRecipientSource(t, seeded(), feerecipientstest.Fixture{
+		Known:        testPubkey(0x03),
+		FeeRecipient: testFeeDistributor,
+		Owner:        testNode1,
+		Unknown:      testPubkey(0xff),
+	})
+}
+
+func TestMockFeeRecipients(t *testing.T) {
+	m := seeded()
+
+	info, err := m.ValidatorFeeRecipient(context.Background(), testPubkey(0x01), &testNode0)
+	if err != nil || info.Expected != testSmoothingPool || info.Source != feerecipient.SourceSmoothingPool || info.NodeAddress != testNode0 {
+		t.Fatalf("unexpected fee recipient %+v %v", info, err)
+	}
+
+	// Opting out switches to the fee distributor
+	if err := m.SetSmoothingPoolStatus(testNode1, true); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := m.ValidatorFeeRecipient(context.Background(), testPubkey(0x03), nil); err != nil || info.Source != feerecipient.SourceSmoothingPool || info.Expected != testSmoothingPool {
+		t.Fatalf("unexpected fee recipient %+v %v", info, err)
+	}
+	if _, err := m.ValidatorFeeRecipient(context.Background(), testPubkey(0xff), nil); !errors.Is(err, feerecipient.ErrNotMinipool) {
+		t.Fatalf("expected %v, got %v", feerecipient.ErrNotMinipool, err)
+	}
+
+	// Minipools whose node is gone can't be answered for
+	m.RemoveNode(testNode1)
+	if _, err := m.ValidatorFeeRecipient(context.Background(), testPubkey(0x03), nil); !errors.Is(err, feerecipient.ErrInconsistentCache) {
+		t.Fatalf("expected %v, got %v", feerecipient.ErrInconsistentCache, err)
+	}
+	if err := m.SetSmoothingPoolStatus(testNode1, false); err == nil {
+		t.Fatal("expected an unknown node's status not to be set")
+	}
+}
+
+func TestMockNodes(t *testing.T) {
+	m := seeded()
+
+	var seen []common.Address
+	if err := m.ForEachNode(func(addr common.Address) bool {
+		seen = append(seen, addr)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 2 || seen[0] != testNode0 || seen[1] != testNode1 {
+		t.Fatalf("unexpected nodes %v", seen)
+	}
+
+	n, err := m.GetNodeInfo(testNode0)
+	if err != nil || !n.InSmoothingPool || len(n.MinipoolPubkeys) != 2 || n.MinipoolPubkeys[0] != testPubkey(0x01) {
+		t.Fatalf("unexpected node %+v %v", n, err)
+	}
+
+	events, unsubscribe := m.SubscribeNodeEvents()
+	defer unsubscribe()
+	if err := m.SetSmoothingPoolStatus(testNode1, true); err != nil {
+		t.Fatal(err)
+	}
+	if event := <-events; event.Type != executionlayer.NodeSmoothingPoolStatusChanged || event.NodeAddress != testNode1 || !event.InSmoothingPool {
+		t.Fatalf("unexpected event %+v", event)
+	}
+
+	// Shutting down closes subscriptions
+	m.Deinit()
+	if _, ok := <-events; ok {
+		t.Fatal("expected the subscription to be closed")
+	}
+}
+
+func TestMockWithdrawalAddresses(t *testing.T) {
+	m := seeded()
+	withdrawalAddr := common.HexToAddress("0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	m.SetWithdrawalAddress(testPubkey(0x10), &withdrawalAddr)
+	m.SetWithdrawalAddress(testPubkey(0x11), nil)
+
+	addrs, errs := m.ValidatorWithdrawalAddresses(context.Background(), []rptypes.ValidatorPubkey{testPubkey(0x10), testPubkey(0x11), testPubkey(0x12)})
+	if len(addrs) != 2 || *addrs[testPubkey(0x10)] != withdrawalAddr || addrs[testPubkey(0x11)] != nil || errs[testPubkey(0x12)] == nil {
+		t.Fatalf("unexpected withdrawal addresses %v %v", addrs, errs)
+	}
+
+	for pubkey, expected := range map[rptypes.ValidatorPubkey]bool{testPubkey(0x10): true, testPubkey(0x11): false} {
+		if ok, err := m.SoloValidatorFeeRecipient(context.Background(), pubkey, withdrawalAddr); err != nil || ok != expected {
+			t.Fatalf("expected %v for %s, got %v %v", expected, pubkey.String(), ok, err)
+		}
+	}
+}
+
+func TestMockStatus(t *testing.T) {
+	m := seeded()
+	m.SetBlocks(90, 100)
+	m.SetStale(true)
+
+	status, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := executionlayer.Status{HighestBlock: 90, Head: 100, Nodes: 2, Minipools: 3, Stale: true}
+	if *status != expected || !m.Stale() {
+		t.Fatalf("expected %+v, got %+v", expected, status)
+	}
+
+	m.StatusErr = errors.New("EC unavailable")
+	if _, err := m.Status(context.Background()); !errors.Is(err, m.StatusErr) {
+		t.Fatalf("expected %v, got %v", m.StatusErr, err)
+	}
+}
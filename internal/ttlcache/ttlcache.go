@@ -0,0 +1,81 @@
+// Package ttlcache remembers lookup results for a while, so repeated lookups of the same key only go to
+// the slower source behind the cache once per ttl
+package ttlcache
+
+import (
+	"sync"
+	"time"
+)
+
+// The most entries a Cache holds before it's swept
+const maxEntries = 65536
+
+type entry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// Cache remembers values, including empty ones, for its ttl
+type Cache[V any] struct {
+	sync.Mutex
+	ttl     time.Duration
+	entries map[string]entry[V]
+	// Now is the cache's clock, which tests may replace
+	Now func() time.Time
+}
+
+// New creates a Cache which remembers values for ttl. A ttl of 0 disables it, so nothing is remembered.
+func New[V any](ttl time.Duration) *Cache[V] {
+	return &Cache[V]{
+		ttl:     ttl,
+		entries: make(map[string]entry[V]),
+		Now:     time.Now,
+	}
+}
+
+// Get returns the value stored for key, if it hasn't expired
+func (c *Cache[V]) Get(key string) (V, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || !c.Now().Before(e.expires) {
+		var zero V
+		return zero, false
+	}
+
+	return e.value, true
+}
+
+// Put stores value for key until the ttl passes
+func (c *Cache[V]) Put(key string, value V) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.Now()
+	if len(c.entries) >= maxEntries {
+		// Drop whatever has expired, and if that isn't enough, start again
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxEntries {
+			c.entries = make(map[string]entry[V])
+		}
+	}
+
+	c.entries[key] = entry[V]{value: value, expires: now.Add(c.ttl)}
+}
+
+// Delete forgets the value stored for key, if there is one
+func (c *Cache[V]) Delete(key string) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.entries, key)
+}
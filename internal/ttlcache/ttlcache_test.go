@@ -0,0 +1,53 @@
+package ttlcache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	c := New[int](time.Minute)
+	now := time.Now()
+	c.Now = func() time.Time { return now }
+
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected an empty cache")
+	}
+
+	c.Put("a", 1)
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("expected 1, got %v %v", v, ok)
+	}
+
+	c.Delete("a")
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected the deleted value to be gone")
+	}
+
+	// Values expire after the ttl
+	c.Put("b", 2)
+	now = now.Add(time.Minute)
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("expected the value to have expired")
+	}
+}
+
+func TestCacheDisabled(t *testing.T) {
+	c := New[int](0)
+	c.Put("a", 1)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected a 0 ttl to disable the cache")
+	}
+}
+
+func TestCacheBounded(t *testing.T) {
+	c := New[int](time.Minute)
+	for i := 0; i < maxEntries+1; i++ {
+		c.Put(strconv.Itoa(i), i)
+	}
+
+	if len(c.entries) > maxEntries {
+		t.Fatalf("expected at most %d entries, got %d", maxEntries, len(c.entries))
+	}
+}
@@ -0,0 +1,201 @@
+package logging
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// The encodings log lines may be written in
+const (
+	EncodingJSON    = "json"
+	EncodingConsole = "console"
+)
+
+// DefaultDebugDuration is how long debug logging lasts once it's turned on at runtime, by default
+const DefaultDebugDuration = 15 * time.Minute
+
+type Config struct {
+	// The lowest level logged
+	Level zapcore.Level
+	// EncodingJSON or EncodingConsole
+	Encoding string
+	// The lowest level logged by each module's logger, overriding Level. See Named.
+	Modules map[string]zapcore.Level
+}
+
+// ParseModuleLevels parses a comma-separated list of module=level pairs, eg, executionlayer=debug,router=warn
+func ParseModuleLevels(s string) (map[string]zapcore.Level, error) {
+	out := make(map[string]zapcore.Level)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		module, level, ok := strings.Cut(pair, "=")
+		if !ok || module == "" {
+			return nil, fmt.Errorf("expected module=level, got %s", pair)
+		}
+
+		var l zapcore.Level
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("invalid level for %s: %w", module, err)
+		}
+		out[module] = l
+	}
+	return out, nil
+}
+
+// Logging builds the proxy's loggers, and decides which of their lines are written.
+// Each module logs through its own named logger, so its level can be set separately,
+// and all of them can be switched to debug level for a while without restarting.
+type Logging struct {
+	root   *zap.Logger
+	levels *levels
+}
+
+type levels struct {
+	level   zapcore.Level
+	modules map[string]zapcore.Level
+	// The lowest of level and the modules' levels
+	lowest zapcore.Level
+
+	// When debug logging turned on at runtime stops, in unix nanoseconds, or 0 if it isn't on
+	debugUntil atomic.Int64
+	// Replaceable for testing
+	now func() time.Time
+}
+
+func (l *levels) debugging() bool {
+	until := l.debugUntil.Load()
+	return until != 0 && l.now().UnixNano() < until
+}
+
+// enabled returns true if lines at lvl from the logger named name should be written
+func (l *levels) enabled(name string, lvl zapcore.Level) bool {
+	if l.debugging() {
+		return true
+	}
+
+	// Loggers a module names itself are still the module's, eg, executionlayer.lookups
+	module, _, _ := strings.Cut(name, ".")
+	if level, ok := l.modules[module]; ok {
+		return level.Enabled(lvl)
+	}
+	return l.level.Enabled(lvl)
+}
+
+func newLevels(cfg Config) *levels {
+	out := &levels{
+		level:   cfg.Level,
+		modules: cfg.Modules,
+		lowest:  cfg.Level,
+		now:     time.Now,
+	}
+	for _, level := range cfg.Modules {
+		if level < out.lowest {
+			out.lowest = level
+		}
+	}
+	return out
+}
+
+// levelCore filters the entries of the core it wraps by the level of the logger they came from.
+// Entries carry their logger's name, so one core serves every named logger.
+type levelCore struct {
+	zapcore.Core
+	levels *levels
+}
+
+func (c *levelCore) Enabled(lvl zapcore.Level) bool {
+	return c.levels.debugging() || c.levels.lowest.Enabled(lvl)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), levels: c.levels}
+}
+
+func (c *levelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.levels.enabled(ent.LoggerName, ent.Level) {
+		return ce
+	}
+	return c.Core.Check(ent, ce)
+}
+
+// New builds the loggers described by cfg, which write to stderr
+func New(cfg Config) (*Logging, error) {
+	zcfg := zap.NewProductionConfig()
+	switch cfg.Encoding {
+	case EncodingJSON:
+	case EncodingConsole:
+		zcfg.Encoding = EncodingConsole
+		zcfg.EncoderConfig = zap.NewDevelopmentEncoderConfig()
+	default:
+		return nil, fmt.Errorf("unknown encoding %s, expected %s or %s", cfg.Encoding, EncodingJSON, EncodingConsole)
+	}
+
+	l := newLevels(cfg)
+
+	// levelCore decides what's written, so the core it wraps must accept everything
+	zcfg.Level = zap.NewAtomicLevelAt(zapcore.DebugLevel)
+	root, err := zcfg.Build(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &levelCore{Core: core, levels: l}
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Logging{root: root, levels: l}, nil
+}
+
+// Logger returns the logger for lines which don't belong to a module, which are logged at the configured Level
+func (l *Logging) Logger() *zap.Logger {
+	return l.root
+}
+
+// Named returns module's logger, which logs at the module's level, if it has one, and otherwise the configured Level
+func (l *Logging) Named(module string) *zap.Logger {
+	return l.root.Named(module)
+}
+
+// DebugFor logs everything at debug level, regardless of the configured levels, for d. It returns when that stops.
+func (l *Logging) DebugFor(d time.Duration) time.Time {
+	until := l.levels.now().Add(d)
+	l.levels.debugUntil.Store(until.UnixNano())
+	l.root.Info("Debug logging enabled", zap.Time("until", until))
+	return until
+}
+
+// StopDebug goes back to the configured levels before DebugFor's duration is up
+func (l *Logging) StopDebug() {
+	if l.levels.debugUntil.Swap(0) != 0 {
+		l.root.Info("Debug logging disabled")
+	}
+}
+
+// DebugUntil returns when debug logging turned on by DebugFor stops, or the zero time if it isn't on
+func (l *Logging) DebugUntil() time.Time {
+	if !l.levels.debugging() {
+		return time.Time{}
+	}
+	return time.Unix(0, l.levels.debugUntil.Load())
+}
+
+// ToggleDebug calls StopDebug if debug logging is on, and otherwise DebugFor(d)
+func (l *Logging) ToggleDebug(d time.Duration) {
+	if l.levels.debugging() {
+		l.StopDebug()
+		return
+	}
+	l.DebugFor(d)
+}
+
+// Sync flushes any buffered log lines
+func (l *Logging) Sync() error {
+	return l.root.Sync()
+}
@@ -0,0 +1,126 @@
+package logging
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func testLogging(cfg Config) (*Logging, *observer.ObservedLogs) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	l := newLevels(cfg)
+	return &Logging{root: zap.New(&levelCore{Core: core, levels: l}), levels: l}, logs
+}
+
+func TestParseModuleLevels(t *testing.T) {
+	modules, err := ParseModuleLevels("executionlayer=debug, router=warn,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modules) != 2 || modules["executionlayer"] != zapcore.DebugLevel || modules["router"] != zapcore.WarnLevel {
+		t.Fatalf("unexpected module levels %v", modules)
+	}
+
+	if modules, err := ParseModuleLevels(""); err != nil || len(modules) != 0 {
+		t.Fatalf("expected no module levels, got %v %v", modules, err)
+	}
+
+	for _, invalid := range []string{"executionlayer", "=debug", "router=loud"} {
+		if _, err := ParseModuleLevels(invalid); err == nil {
+			t.Errorf("expected %s to be invalid", invalid)
+		}
+	}
+}
+
+func TestModuleLevels(t *testing.T) {
+	l, logs := testLogging(Config{
+		Level:   zapcore.InfoLevel,
+		Modules: map[string]zapcore.Level{"executionlayer": zapcore.DebugLevel, "router": zapcore.WarnLevel},
+	})
+
+	l.Logger().Debug("root debug")
+	l.Logger().Info("root info")
+	l.Named("executionlayer").Debug("executionlayer debug")
+	l.Named("executionlayer").Named("lookups").Debug("lookups debug")
+	l.Named("router").Info("router info")
+	l.Named("router").With(zap.String("request_id", "1")).Warn("router warn")
+	l.Named("api").Debug("api debug")
+	l.Named("api").Info("api info")
+
+	var written []string
+	for _, entry := range logs.All() {
+		written = append(written, entry.Message)
+	}
+	expected := []string{"root info", "executionlayer debug", "lookups debug", "router warn", "api info"}
+	if len(written) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, written)
+	}
+	for i := range expected {
+		if written[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, written)
+		}
+	}
+}
+
+func TestDebugFor(t *testing.T) {
+	l, logs := testLogging(Config{
+		Level:   zapcore.InfoLevel,
+		Modules: map[string]zapcore.Level{"router": zapcore.ErrorLevel},
+	})
+	now := time.Unix(1700000000, 0)
+	l.levels.now = func() time.Time { return now }
+	router := l.Named("router")
+
+	debugs := func() int {
+		return logs.FilterMessage("debug").Len()
+	}
+
+	router.Debug("debug")
+	if debugs() != 0 || !l.DebugUntil().IsZero() {
+		t.Fatal("expected debug logging to start off")
+	}
+
+	until := l.DebugFor(time.Minute)
+	if !until.Equal(now.Add(time.Minute)) || !l.DebugUntil().Equal(until) {
+		t.Fatalf("expected debug logging until %v, got %v", now.Add(time.Minute), l.DebugUntil())
+	}
+	router.Debug("debug")
+	l.Logger().Debug("debug")
+	if debugs() != 2 {
+		t.Fatalf("expected every logger to log at debug level, got %d lines", debugs())
+	}
+
+	// It stops by itself
+	now = now.Add(time.Minute)
+	router.Debug("debug")
+	router.Warn("debug")
+	if debugs() != 2 || !l.DebugUntil().IsZero() {
+		t.Fatal("expected debug logging to stop after a minute")
+	}
+
+	// Or when toggled
+	l.ToggleDebug(time.Hour)
+	router.Debug("debug")
+	l.ToggleDebug(time.Hour)
+	router.Debug("debug")
+	if debugs() != 3 {
+		t.Fatalf("expected the toggle to turn debug logging on and off, got %d lines", debugs())
+	}
+	if logs.FilterMessage("Debug logging disabled").Len() != 1 {
+		t.Fatal("expected turning debug logging off to be logged")
+	}
+}
+
+func TestNewEncodings(t *testing.T) {
+	for _, encoding := range []string{EncodingJSON, EncodingConsole} {
+		if _, err := New(Config{Encoding: encoding}); err != nil {
+			t.Fatalf("unexpected error for %s: %v", encoding, err)
+		}
+	}
+	if _, err := New(Config{Encoding: "logfmt"}); err == nil {
+		t.Fatal("expected an unknown encoding to be refused")
+	}
+}
@@ -0,0 +1,7 @@
+//go:build postgres
+
+package main
+
+// The postgres driver is only linked into builds with -tags postgres, so that deployments which don't use
+// -postgres-dsn don't carry it
+import _ "github.com/jackc/pgx/v5/stdlib"
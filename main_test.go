@@ -0,0 +1,196 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+)
+
+func TestGracefulShutdown(t *testing.T) {
+	logger = zap.NewNop()
+
+	// A slow request is in flight when SIGTERM arrives
+	started := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(500 * time.Millisecond)
+		_, _ = w.Write([]byte("done"))
+	})}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	signals := trapSignals()
+	defer signal.Reset()
+
+	timeout := 5 * time.Second
+	exited := make(chan struct{})
+	go func() {
+		<-signals
+		drain(timeout, shutdownHTTP(server))
+		close(exited)
+	}()
+
+	type result struct {
+		body string
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		results <- result{string(body), err}
+	}()
+
+	<-started
+	start := time.Now()
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(timeout + time.Second):
+		t.Fatal("timed out waiting for the server to drain")
+	}
+	if elapsed := time.Since(start); elapsed > timeout {
+		t.Fatalf("draining took %v, longer than the %v timeout", elapsed, timeout)
+	}
+
+	r := <-results
+	if r.err != nil {
+		t.Fatalf("expected the in-flight request to complete, got %v", r.err)
+	}
+	if r.body != "done" {
+		t.Fatalf("unexpected response %q", r.body)
+	}
+
+	// New connections are refused once drained
+	if _, err := http.Get("http://" + listener.Addr().String()); err == nil {
+		t.Fatal("expected new requests to be refused")
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	logger = zap.NewNop()
+
+	// A request which never finishes is closed once the timeout passes
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	failed := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+		failed <- err
+	}()
+	<-started
+
+	start := time.Now()
+	drain(100*time.Millisecond, shutdownHTTP(server))
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("draining took %v, expected it to give up after the timeout", elapsed)
+	}
+	if err := <-failed; err == nil {
+		t.Fatal("expected the unfinished request to be cut off")
+	}
+}
+
+func TestReloadOnSIGHUP(t *testing.T) {
+	logger = zap.NewNop()
+
+	reloaded := make(chan struct{}, 1)
+	stop := reloadOnSIGHUP(func() {
+		reloaded <- struct{}{}
+	})
+	defer stop()
+
+	// Every SIGHUP reloads, and none of them stop the process
+	for i := 0; i < 2; i++ {
+		if err := syscall.Kill(syscall.Getpid(), syscall.SIGHUP); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-reloaded:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected SIGHUP %d to reload", i)
+		}
+	}
+}
+
+func TestParseFeeRecipientSources(t *testing.T) {
+	sources, err := parseFeeRecipientSources("file, rocketpool,http,postgres", "fee-recipients.json", "https://example.com", "postgres://localhost/rescue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 4 || sources[0] != "file" || sources[1] != "rocketpool" || sources[2] != "http" || sources[3] != "postgres" {
+		t.Fatalf("unexpected sources %v", sources)
+	}
+
+	for _, tc := range []struct {
+		list, file, url, dsn string
+	}{
+		{"rocketpool,ens", "", "", ""},
+		{"", "", "", ""},
+		{"rocketpool,rocketpool", "", "", ""},
+		{"file", "", "", ""},
+		{"http", "fee-recipients.json", "", ""},
+		{"postgres", "", "https://example.com", ""},
+	} {
+		if _, err := parseFeeRecipientSources(tc.list, tc.file, tc.url, tc.dsn); err == nil {
+			t.Errorf("expected %q to be refused", tc.list)
+		}
+	}
+}
+
+func TestParseFeeRecipientAllowlist(t *testing.T) {
+	allowlist, err := parseFeeRecipientAllowlist("rocketTokenRETH, 0xaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeae")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allowlist) != 2 || allowlist[0] != "rocketTokenRETH" || allowlist[1] != common.HexToAddress("0xaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeae").String() {
+		t.Fatalf("unexpected allowlist %v", allowlist)
+	}
+
+	// A blank list allows nothing
+	allowlist, err = parseFeeRecipientAllowlist("")
+	if err != nil || len(allowlist) != 0 {
+		t.Fatalf("expected an empty allowlist, got %v %v", allowlist, err)
+	}
+
+	for _, list := range []string{"0x1234", "rocketTokenRETH,rocketTokenRETH", "0xaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeae,0xAEAEAEAEAEAEAEAEAEAEAEAEAEAEAEAEAEAEAEAE"} {
+		if _, err := parseFeeRecipientAllowlist(list); err == nil {
+			t.Errorf("expected %q to be refused", list)
+		}
+	}
+}
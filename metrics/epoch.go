@@ -44,6 +44,8 @@ func ObserveValidator(node common.Address, pubkey rptypes.ValidatorPubkey) {
 	iface, _ := epoch.LoadOrStore(node, nodeMap)
 	nodeMap = iface.(*sync.Map)
 	_, _ = nodeMap.LoadOrStore(pubkey, struct{}{})
+
+	observeRecentValidator(pubkey)
 }
 
 func previousEpochIdx() uint64 {
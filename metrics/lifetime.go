@@ -0,0 +1,257 @@
+package metrics
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Lifetime counters carry on from where they were when the proxy last stopped, rather than starting from zero,
+// so totals like the requests ever served survive deploys. They're checkpointed to a bbolt file.
+//
+// Each checkpoint writes the counters' absolute values, not the deltas since the last one, in a single
+// transaction, which bbolt commits atomically. So a checkpoint either lands completely or not at all, and
+// restoring one after a crash can never count anything twice. The price is that whatever was counted since
+// the last checkpoint is lost if the proxy crashes, so the totals may undercount by up to one interval's worth.
+// That's accepted over write-ahead logging each increment, which would put a disk write on every request.
+// Graceful shutdowns write a final checkpoint, so lose nothing.
+const (
+	// Authenticated requests served
+	LifetimeRequests = "requests"
+	// Guarded requests and calls rejected
+	LifetimeRejections = "rejections"
+	// Distinct nodes which made an authenticated request
+	LifetimeUsers = "users"
+)
+
+var lifetimeCounterNames = []string{LifetimeRequests, LifetimeRejections}
+
+var (
+	lifetimeCountersBucket = []byte("counters")
+	// Keyed by node address, so the distinct users counted before a restart aren't counted again after it
+	lifetimeUsersBucket = []byte("users")
+)
+
+type lifetimeCounters struct {
+	db     *bolt.DB
+	values map[string]*atomic.Uint64
+
+	users     sync.Map
+	userCount atomic.Uint64
+	// Users seen since the last checkpoint, which it has yet to write
+	newUsersLock sync.Mutex
+	newUsers     []common.Address
+
+	// When the last checkpoint was written, in unix nanoseconds
+	checkpointed atomic.Int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var lifetime atomic.Pointer[lifetimeCounters]
+
+// InitLifetimeMetrics restores the lifetime counters from the bbolt file at path, creating it if need be,
+// and checkpoints them to it every interval until DeinitLifetimeMetrics is called
+func InitLifetimeMetrics(path string, interval time.Duration) error {
+	// Another proxy using the same file holds a lock on it, so give up rather than wait forever
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %w", path, err)
+	}
+
+	l := &lifetimeCounters{
+		db:     db,
+		values: make(map[string]*atomic.Uint64, len(lifetimeCounterNames)),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	for _, name := range lifetimeCounterNames {
+		l.values[name] = &atomic.Uint64{}
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		counters, err := tx.CreateBucketIfNotExists(lifetimeCountersBucket)
+		if err != nil {
+			return err
+		}
+		users, err := tx.CreateBucketIfNotExists(lifetimeUsersBucket)
+		if err != nil {
+			return err
+		}
+
+		for name, value := range l.values {
+			if v := counters.Get([]byte(name)); len(v) == 8 {
+				value.Store(binary.BigEndian.Uint64(v))
+			}
+		}
+		return users.ForEach(func(k, v []byte) error {
+			l.users.Store(common.BytesToAddress(k), struct{}{})
+			l.userCount.Add(1)
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("unable to restore the lifetime counters from %s: %w", path, err)
+	}
+
+	l.checkpointed.Store(time.Now().UnixNano())
+	lifetime.Store(l)
+
+	r := NewMetricsRegistry("lifetime")
+	for name, value := range l.values {
+		value := value
+		r.CounterFunc(name, func() float64 {
+			return float64(value.Load())
+		})
+	}
+	r.CounterFunc(LifetimeUsers, func() float64 {
+		return float64(l.userCount.Load())
+	})
+	r.GaugeFunc("checkpointed_seconds", func() float64 {
+		return float64(l.checkpointed.Load()) / float64(time.Second)
+	})
+
+	go func() {
+		defer close(l.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-l.stop:
+				return
+			case <-ticker.C:
+				if err := l.checkpoint(); err != nil {
+					r.Counter("checkpoint_failed").Inc()
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// DeinitLifetimeMetrics stops checkpointing the lifetime counters, writes a final checkpoint and closes the file
+func DeinitLifetimeMetrics() error {
+	l := lifetime.Swap(nil)
+	if l == nil {
+		return nil
+	}
+
+	close(l.stop)
+	<-l.done
+	err := l.checkpoint()
+	if closeErr := l.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// checkpoint writes every counter's current value, and the users seen since the last checkpoint, in one transaction
+func (l *lifetimeCounters) checkpoint() error {
+	l.newUsersLock.Lock()
+	newUsers := l.newUsers
+	l.newUsers = nil
+	l.newUsersLock.Unlock()
+
+	err := l.db.Update(func(tx *bolt.Tx) error {
+		counters := tx.Bucket(lifetimeCountersBucket)
+		for name, value := range l.values {
+			v := make([]byte, 8)
+			binary.BigEndian.PutUint64(v, value.Load())
+			if err := counters.Put([]byte(name), v); err != nil {
+				return err
+			}
+		}
+
+		users := tx.Bucket(lifetimeUsersBucket)
+		for _, user := range newUsers {
+			if err := users.Put(user.Bytes(), []byte{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// Try the users again next time
+		l.newUsersLock.Lock()
+		l.newUsers = append(newUsers, l.newUsers...)
+		l.newUsersLock.Unlock()
+		return err
+	}
+
+	l.checkpointed.Store(time.Now().UnixNano())
+	return nil
+}
+
+func observeLifetime(name string) {
+	if l := lifetime.Load(); l != nil {
+		l.values[name].Add(1)
+	}
+}
+
+func observeLifetimeUser(node common.Address) {
+	l := lifetime.Load()
+	if l == nil {
+		return
+	}
+
+	if _, seen := l.users.LoadOrStore(node, struct{}{}); seen {
+		return
+	}
+	l.userCount.Add(1)
+	l.newUsersLock.Lock()
+	l.newUsers = append(l.newUsers, node)
+	l.newUsersLock.Unlock()
+}
+
+// ObserveRejection records a guarded request or call which was rejected
+func ObserveRejection() {
+	observeLifetime(LifetimeRejections)
+}
+
+// LifetimeStats are the lifetime counters' values, as of when they were read
+type LifetimeStats struct {
+	Requests   uint64 `json:"requests"`
+	Rejections uint64 `json:"rejections"`
+	Users      uint64 `json:"users"`
+	// When the values were last written to disk. Those counted since are lost if the proxy crashes.
+	Checkpointed time.Time `json:"checkpointed"`
+}
+
+// Lifetime returns the lifetime counters' values, or nil if they aren't being kept
+func Lifetime() *LifetimeStats {
+	l := lifetime.Load()
+	if l == nil {
+		return nil
+	}
+
+	return &LifetimeStats{
+		Requests:     l.values[LifetimeRequests].Load(),
+		Rejections:   l.values[LifetimeRejections].Load(),
+		Users:        l.userCount.Load(),
+		Checkpointed: time.Unix(0, l.checkpointed.Load()).UTC(),
+	}
+}
+
+// LifetimeHandler serves the lifetime counters' values as json, or 404 if they aren't being kept
+func LifetimeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats := Lifetime()
+		w.Header().Set("Content-Type", "application/json")
+		if stats == nil {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "lifetime counters aren't being kept"})
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(stats)
+	})
+}
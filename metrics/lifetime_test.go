@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// restartLifetime stops keeping the lifetime counters, as the proxy does when it shuts down, and starts
+// again with a fresh metrics namespace, as the next process would
+func restartLifetime(t *testing.T, path string, generation string) {
+	t.Helper()
+	if err := DeinitLifetimeMetrics(); err != nil {
+		t.Fatal(err)
+	}
+	Deinit()
+
+	if _, err := Init("lifetime_test_" + t.Name() + generation); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitLifetimeMetrics(path, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLifetimeCounters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.db")
+	if _, err := Init("lifetime_test_" + t.Name()); err != nil {
+		t.Fatal(err)
+	}
+	defer Deinit()
+	if err := InitLifetimeMetrics(path, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	defer DeinitLifetimeMetrics()
+
+	node := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	other := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	ObserveRequest(node)
+	ObserveRequest(node)
+	ObserveRequest(other)
+	ObserveRejection()
+
+	expect := func(requests, rejections, users uint64) {
+		t.Helper()
+		stats := Lifetime()
+		if stats.Requests != requests || stats.Rejections != rejections || stats.Users != users {
+			t.Fatalf("expected %d requests, %d rejections and %d users, got %+v", requests, rejections, users, stats)
+		}
+	}
+	expect(3, 1, 2)
+
+	// The counters carry on after a restart, and users seen before it aren't counted again
+	restartLifetime(t, path, "_restarted")
+	expect(3, 1, 2)
+	ObserveRequest(node)
+	ObserveRequest(common.HexToAddress("0x3333333333333333333333333333333333333333"))
+	expect(5, 1, 3)
+
+	// Counted since the last checkpoint is lost in a crash, but nothing checkpointed is counted twice
+	if err := lifetime.Load().checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	ObserveRejection()
+	crashed := lifetime.Swap(nil)
+	close(crashed.stop)
+	<-crashed.done
+	crashed.db.Close()
+	Deinit()
+	if _, err := Init("lifetime_test_" + t.Name() + "_crashed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitLifetimeMetrics(path, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	expect(5, 1, 3)
+
+	w := httptest.NewRecorder()
+	LifetimeHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/stats", nil))
+	var stats LifetimeStats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Requests != 5 || stats.Rejections != 1 || stats.Users != 3 || stats.Checkpointed.IsZero() {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestLifetimeCountersDisabled(t *testing.T) {
+	// Without a file, nothing is kept, and the stats endpoint says so
+	ObserveRejection()
+	if stats := Lifetime(); stats != nil {
+		t.Fatalf("expected no stats, got %+v", stats)
+	}
+
+	w := httptest.NewRecorder()
+	LifetimeHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/stats", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+}
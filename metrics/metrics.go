@@ -18,7 +18,7 @@ type Metrics struct {
 
 var mtx *Metrics
 
-type MetricsMap[M prometheus.Metric, O any] struct {
+type MetricsMap[M prometheus.Collector, O any] struct {
 	sync.RWMutex
 	m           map[string]M
 	initializor func(O) M
@@ -27,10 +27,40 @@ type MetricsMap[M prometheus.Metric, O any] struct {
 // MetricsRegistry proves a per-module api for creating
 // and updating metrics
 type MetricsRegistry struct {
-	subsystem  string
-	counters   MetricsMap[prometheus.Counter, prometheus.CounterOpts]
-	gauges     MetricsMap[prometheus.Gauge, prometheus.GaugeOpts]
-	histograms MetricsMap[prometheus.Histogram, prometheus.HistogramOpts]
+	subsystem   string
+	counters    MetricsMap[prometheus.Counter, prometheus.CounterOpts]
+	gauges      MetricsMap[prometheus.Gauge, prometheus.GaugeOpts]
+	histograms  MetricsMap[prometheus.Histogram, prometheus.HistogramOpts]
+	counterVecs MetricsMap[*prometheus.CounterVec, counterVecOpts]
+	gaugeVecs   MetricsMap[*prometheus.GaugeVec, gaugeVecOpts]
+	histVecs    MetricsMap[*prometheus.HistogramVec, histogramVecOpts]
+}
+
+type counterVecOpts struct {
+	prometheus.CounterOpts
+	labels []string
+}
+
+func newCounterVec(opts counterVecOpts) *prometheus.CounterVec {
+	return promauto.NewCounterVec(opts.CounterOpts, opts.labels)
+}
+
+type gaugeVecOpts struct {
+	prometheus.GaugeOpts
+	labels []string
+}
+
+func newGaugeVec(opts gaugeVecOpts) *prometheus.GaugeVec {
+	return promauto.NewGaugeVec(opts.GaugeOpts, opts.labels)
+}
+
+type histogramVecOpts struct {
+	prometheus.HistogramOpts
+	labels []string
+}
+
+func newHistogramVec(opts histogramVecOpts) *prometheus.HistogramVec {
+	return promauto.NewHistogramVec(opts.HistogramOpts, opts.labels)
 }
 
 // Init intializes the metrics package with the given namespace string.
@@ -67,6 +97,18 @@ func NewMetricsRegistry(subsystem string) *MetricsRegistry {
 			m:           make(map[string]prometheus.Histogram),
 			initializor: promauto.NewHistogram,
 		},
+		counterVecs: MetricsMap[*prometheus.CounterVec, counterVecOpts]{
+			m:           make(map[string]*prometheus.CounterVec),
+			initializor: newCounterVec,
+		},
+		gaugeVecs: MetricsMap[*prometheus.GaugeVec, gaugeVecOpts]{
+			m:           make(map[string]*prometheus.GaugeVec),
+			initializor: newGaugeVec,
+		},
+		histVecs: MetricsMap[*prometheus.HistogramVec, histogramVecOpts]{
+			m:           make(map[string]*prometheus.HistogramVec),
+			initializor: newHistogramVec,
+		},
 	}
 }
 
@@ -107,6 +149,21 @@ func (m *MetricsRegistry) Counter(name string) prometheus.Counter {
 	})
 }
 
+// CounterVec creates or fetches a prometheus CounterVec with the given label names
+// from the metrics registry and returns it.
+// The label names must be the same every time a given name is fetched.
+func (m *MetricsRegistry) CounterVec(name string, labels ...string) *prometheus.CounterVec {
+
+	return m.counterVecs.value(name, counterVecOpts{
+		CounterOpts: prometheus.CounterOpts{
+			Namespace: mtx.namespace,
+			Subsystem: m.subsystem,
+			Name:      name,
+		},
+		labels: labels,
+	})
+}
+
 // Gauge creates or fetches a prometheus Gauge from the metrics
 // registry and returns it.
 func (m *MetricsRegistry) Gauge(name string) prometheus.Gauge {
@@ -118,6 +175,21 @@ func (m *MetricsRegistry) Gauge(name string) prometheus.Gauge {
 	})
 }
 
+// GaugeVec creates or fetches a prometheus GaugeVec with the given label names
+// from the metrics registry and returns it.
+// The label names must be the same every time a given name is fetched.
+func (m *MetricsRegistry) GaugeVec(name string, labels ...string) *prometheus.GaugeVec {
+
+	return m.gaugeVecs.value(name, gaugeVecOpts{
+		GaugeOpts: prometheus.GaugeOpts{
+			Namespace: mtx.namespace,
+			Subsystem: m.subsystem,
+			Name:      name,
+		},
+		labels: labels,
+	})
+}
+
 func (m *MetricsRegistry) GaugeFunc(name string, handler func() float64) {
 	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
 		Namespace: mtx.namespace,
@@ -126,6 +198,16 @@ func (m *MetricsRegistry) GaugeFunc(name string, handler func() float64) {
 	}, handler)
 }
 
+// CounterFunc registers a prometheus Counter whose value is whatever handler returns when it's collected.
+// handler must never return less than it did before.
+func (m *MetricsRegistry) CounterFunc(name string, handler func() float64) {
+	_ = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: mtx.namespace,
+		Subsystem: m.subsystem,
+		Name:      name,
+	}, handler)
+}
+
 // Histogram creates or fetches a prometheus Histogram from the metrics
 // registry and returns it.
 func (m *MetricsRegistry) Histogram(name string) prometheus.Histogram {
@@ -136,3 +218,18 @@ func (m *MetricsRegistry) Histogram(name string) prometheus.Histogram {
 		Name:      name,
 	})
 }
+
+// HistogramVec creates or fetches a prometheus HistogramVec with the given label names
+// from the metrics registry and returns it.
+// The label names must be the same every time a given name is fetched.
+func (m *MetricsRegistry) HistogramVec(name string, labels ...string) *prometheus.HistogramVec {
+
+	return m.histVecs.value(name, histogramVecOpts{
+		HistogramOpts: prometheus.HistogramOpts{
+			Namespace: mtx.namespace,
+			Subsystem: m.subsystem,
+			Name:      name,
+		},
+		labels: labels,
+	})
+}
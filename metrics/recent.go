@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+// Validators are remembered in hourly buckets, so RecentValidators() covers the last 24-25 hours
+const recentBuckets = 25
+const recentBucketWidth = time.Hour
+
+type recentBucket struct {
+	start   int64
+	pubkeys sync.Map
+}
+
+var recent [recentBuckets]atomic.Pointer[recentBucket]
+
+func observeRecentValidator(pubkey rptypes.ValidatorPubkey) {
+	start := time.Now().Truncate(recentBucketWidth).Unix()
+	slot := &recent[(start/int64(recentBucketWidth.Seconds()))%recentBuckets]
+
+	bucket := slot.Load()
+	if bucket == nil || bucket.start != start {
+		// The bucket is from a previous day, so replace it. If another goroutine beat us to it, use theirs.
+		fresh := &recentBucket{start: start}
+		if slot.CompareAndSwap(bucket, fresh) {
+			bucket = fresh
+		} else {
+			bucket = slot.Load()
+		}
+	}
+
+	bucket.pubkeys.Store(pubkey, struct{}{})
+}
+
+// RecentValidators returns the pubkeys of the validators observed over the last day
+func RecentValidators() []rptypes.ValidatorPubkey {
+	cutoff := time.Now().Truncate(recentBucketWidth).Add(-(recentBuckets - 1) * recentBucketWidth).Unix()
+	seen := make(map[rptypes.ValidatorPubkey]struct{})
+
+	for i := range recent {
+		bucket := recent[i].Load()
+		if bucket == nil || bucket.start < cutoff {
+			continue
+		}
+
+		bucket.pubkeys.Range(func(key, value any) bool {
+			seen[key.(rptypes.ValidatorPubkey)] = struct{}{}
+			return true
+		})
+	}
+
+	out := make([]rptypes.ValidatorPubkey, 0, len(seen))
+	for pubkey := range seen {
+		out = append(out, pubkey)
+	}
+	return out
+}
@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// InitRuntimeMetrics replaces the default registry's Go collector with one which also exports
+// the runtime/metrics histograms, like scheduler latencies and GC pauses, and adds the build info.
+// The goroutine count, go_memstats_heap_inuse_bytes and go_gc_duration_seconds quantiles are kept.
+func InitRuntimeMetrics() error {
+	prometheus.Unregister(collectors.NewGoCollector())
+
+	for _, collector := range []prometheus.Collector{
+		collectors.NewGoCollector(collectors.WithGoCollections(
+			collectors.GoRuntimeMemStatsCollection | collectors.GoRuntimeMetricsCollection)),
+		collectors.NewBuildInfoCollector(),
+	} {
+		err := prometheus.Register(collector)
+		if err != nil && !errors.As(err, &prometheus.AlreadyRegisteredError{}) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestInitRuntimeMetrics(t *testing.T) {
+	if err := InitRuntimeMetrics(); err != nil {
+		t.Fatal(err)
+	}
+	// It's safe to call again
+	if err := InitRuntimeMetrics(); err != nil {
+		t.Fatal(err)
+	}
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]bool)
+	for _, family := range families {
+		found[family.GetName()] = true
+	}
+
+	for _, name := range []string{"go_goroutines", "go_memstats_heap_inuse_bytes", "go_gc_duration_seconds", "go_gc_heap_goal_bytes", "go_build_info"} {
+		if !found[name] {
+			t.Errorf("expected %s to be gathered", name)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type userStats struct {
+	requests atomic.Uint64
+	lastSeen atomic.Int64
+}
+
+// Node addresses aren't used as labels, to keep cardinality down. Instead, per-node
+// stats are kept here for the debug endpoint, and only aggregates are exported.
+var users sync.Map
+var usersWindow time.Duration
+var usersRegistry *MetricsRegistry
+
+// ObserveRequest records an authenticated request from the given node
+func ObserveRequest(node common.Address) {
+	observeLifetime(LifetimeRequests)
+	observeLifetimeUser(node)
+
+	if usersRegistry == nil {
+		return
+	}
+
+	usersRegistry.Counter("requests").Inc()
+
+	iface, ok := users.Load(node)
+	if !ok {
+		iface, _ = users.LoadOrStore(node, &userStats{})
+	}
+	stats := iface.(*userStats)
+	stats.requests.Add(1)
+	stats.lastSeen.Store(time.Now().UnixNano())
+}
+
+// expireUsers forgets nodes which haven't made a request within the window.
+// A node which makes a request as it's being expired may have that request go uncounted.
+func expireUsers() {
+	cutoff := time.Now().Add(-usersWindow).UnixNano()
+	users.Range(func(key, value any) bool {
+		if value.(*userStats).lastSeen.Load() < cutoff {
+			users.Delete(key)
+		}
+		return true
+	})
+}
+
+// ActiveUsers returns the number of distinct nodes which made a request within the window
+func ActiveUsers() (out float64) {
+	expireUsers()
+	users.Range(func(key, value any) bool {
+		out += 1
+		return true
+	})
+
+	return
+}
+
+type userEntry struct {
+	Node     string    `json:"node"`
+	Requests uint64    `json:"requests"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// UsersHandler dumps the per-node request table as json, busiest nodes first
+func UsersHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expireUsers()
+
+		out := make([]userEntry, 0)
+		users.Range(func(key, value any) bool {
+			stats := value.(*userStats)
+			out = append(out, userEntry{
+				Node:     key.(common.Address).String(),
+				Requests: stats.requests.Load(),
+				LastSeen: time.Unix(0, stats.lastSeen.Load()).UTC(),
+			})
+			return true
+		})
+		sort.Slice(out, func(i, j int) bool {
+			return out[i].Requests > out[j].Requests
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	})
+}
+
+// InitUserMetrics starts tracking the nodes which made a request within the given window
+func InitUserMetrics(window time.Duration) {
+	usersWindow = window
+	usersRegistry = NewMetricsRegistry("users")
+	usersRegistry.GaugeFunc("active", ActiveUsers)
+}
+
+// DeinitUserMetrics stops tracking requests and forgets every node
+func DeinitUserMetrics() {
+	usersRegistry = nil
+	users = sync.Map{}
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestUsers(t *testing.T) {
+	_, err := Init("users_test_" + t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Deinit()
+
+	InitUserMetrics(time.Hour)
+	defer DeinitUserMetrics()
+
+	busy := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	quiet := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	gone := common.HexToAddress("0x3333333333333333333333333333333333333333")
+
+	ObserveRequest(busy)
+	ObserveRequest(busy)
+	ObserveRequest(quiet)
+	ObserveRequest(gone)
+
+	// Age one node out of the window
+	iface, _ := users.Load(gone)
+	iface.(*userStats).lastSeen.Store(time.Now().Add(-2 * time.Hour).UnixNano())
+
+	if got := ActiveUsers(); got != 2 {
+		t.Fatalf("expected 2 active users, got %v", got)
+	}
+	if got := testutil.ToFloat64(usersRegistry.Counter("requests")); got != 4 {
+		t.Fatalf("expected 4 requests, got %v", got)
+	}
+
+	w := httptest.NewRecorder()
+	UsersHandler().ServeHTTP(w, httptest.NewRequest("GET", "/debug/users", nil))
+
+	var entries []userEntry
+	if err := json.NewDecoder(w.Body).Decode(&entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", entries)
+	}
+	if entries[0].Node != busy.String() || entries[0].Requests != 2 {
+		t.Fatalf("expected the busiest node first, got %+v", entries)
+	}
+	if entries[1].Node != quiet.String() || entries[1].Requests != 1 {
+		t.Fatalf("expected the quiet node second, got %+v", entries)
+	}
+}
@@ -23,6 +23,15 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type ApiClient interface {
 	GetRocketPoolNodes(ctx context.Context, in *RocketPoolNodesRequest, opts ...grpc.CallOption) (*RocketPoolNodes, error)
+	GetValidatorFeeRecipient(ctx context.Context, in *ValidatorFeeRecipientRequest, opts ...grpc.CallOption) (*ValidatorFeeRecipient, error)
+	GetNodeInfo(ctx context.Context, in *NodeInfoRequest, opts ...grpc.CallOption) (*NodeInfo, error)
+	StreamRocketPoolNodeEvents(ctx context.Context, in *RocketPoolNodeEventsRequest, opts ...grpc.CallOption) (Api_StreamRocketPoolNodeEventsClient, error)
+	GetSmoothingPoolInfo(ctx context.Context, in *SmoothingPoolInfoRequest, opts ...grpc.CallOption) (*SmoothingPoolInfo, error)
+	GetNodeMinipools(ctx context.Context, in *NodeMinipoolsRequest, opts ...grpc.CallOption) (*NodeMinipools, error)
+	// Admin only. The proxy requires an admin token or mTLS for these.
+	CreateCredential(ctx context.Context, in *CreateCredentialRequest, opts ...grpc.CallOption) (*Credential, error)
+	IntrospectCredential(ctx context.Context, in *IntrospectCredentialRequest, opts ...grpc.CallOption) (*CredentialInfo, error)
+	StreamGuardedRequests(ctx context.Context, in *GuardedRequestsRequest, opts ...grpc.CallOption) (Api_StreamGuardedRequestsClient, error)
 }
 
 type apiClient struct {
@@ -42,11 +51,138 @@ func (c *apiClient) GetRocketPoolNodes(ctx context.Context, in *RocketPoolNodesR
 	return out, nil
 }
 
+func (c *apiClient) GetValidatorFeeRecipient(ctx context.Context, in *ValidatorFeeRecipientRequest, opts ...grpc.CallOption) (*ValidatorFeeRecipient, error) {
+	out := new(ValidatorFeeRecipient)
+	err := c.cc.Invoke(ctx, "/pb.Api/GetValidatorFeeRecipient", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) GetNodeInfo(ctx context.Context, in *NodeInfoRequest, opts ...grpc.CallOption) (*NodeInfo, error) {
+	out := new(NodeInfo)
+	err := c.cc.Invoke(ctx, "/pb.Api/GetNodeInfo", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) StreamRocketPoolNodeEvents(ctx context.Context, in *RocketPoolNodeEventsRequest, opts ...grpc.CallOption) (Api_StreamRocketPoolNodeEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Api_ServiceDesc.Streams[0], "/pb.Api/StreamRocketPoolNodeEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &apiStreamRocketPoolNodeEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Api_StreamRocketPoolNodeEventsClient interface {
+	Recv() (*RocketPoolNodeEvent, error)
+	grpc.ClientStream
+}
+
+type apiStreamRocketPoolNodeEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *apiStreamRocketPoolNodeEventsClient) Recv() (*RocketPoolNodeEvent, error) {
+	m := new(RocketPoolNodeEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *apiClient) GetSmoothingPoolInfo(ctx context.Context, in *SmoothingPoolInfoRequest, opts ...grpc.CallOption) (*SmoothingPoolInfo, error) {
+	out := new(SmoothingPoolInfo)
+	err := c.cc.Invoke(ctx, "/pb.Api/GetSmoothingPoolInfo", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) GetNodeMinipools(ctx context.Context, in *NodeMinipoolsRequest, opts ...grpc.CallOption) (*NodeMinipools, error) {
+	out := new(NodeMinipools)
+	err := c.cc.Invoke(ctx, "/pb.Api/GetNodeMinipools", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) CreateCredential(ctx context.Context, in *CreateCredentialRequest, opts ...grpc.CallOption) (*Credential, error) {
+	out := new(Credential)
+	err := c.cc.Invoke(ctx, "/pb.Api/CreateCredential", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) IntrospectCredential(ctx context.Context, in *IntrospectCredentialRequest, opts ...grpc.CallOption) (*CredentialInfo, error) {
+	out := new(CredentialInfo)
+	err := c.cc.Invoke(ctx, "/pb.Api/IntrospectCredential", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) StreamGuardedRequests(ctx context.Context, in *GuardedRequestsRequest, opts ...grpc.CallOption) (Api_StreamGuardedRequestsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Api_ServiceDesc.Streams[1], "/pb.Api/StreamGuardedRequests", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &apiStreamGuardedRequestsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Api_StreamGuardedRequestsClient interface {
+	Recv() (*GuardedRequest, error)
+	grpc.ClientStream
+}
+
+type apiStreamGuardedRequestsClient struct {
+	grpc.ClientStream
+}
+
+func (x *apiStreamGuardedRequestsClient) Recv() (*GuardedRequest, error) {
+	m := new(GuardedRequest)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // ApiServer is the server API for Api service.
 // All implementations must embed UnimplementedApiServer
 // for forward compatibility
 type ApiServer interface {
 	GetRocketPoolNodes(context.Context, *RocketPoolNodesRequest) (*RocketPoolNodes, error)
+	GetValidatorFeeRecipient(context.Context, *ValidatorFeeRecipientRequest) (*ValidatorFeeRecipient, error)
+	GetNodeInfo(context.Context, *NodeInfoRequest) (*NodeInfo, error)
+	StreamRocketPoolNodeEvents(*RocketPoolNodeEventsRequest, Api_StreamRocketPoolNodeEventsServer) error
+	GetSmoothingPoolInfo(context.Context, *SmoothingPoolInfoRequest) (*SmoothingPoolInfo, error)
+	GetNodeMinipools(context.Context, *NodeMinipoolsRequest) (*NodeMinipools, error)
+	// Admin only. The proxy requires an admin token or mTLS for these.
+	CreateCredential(context.Context, *CreateCredentialRequest) (*Credential, error)
+	IntrospectCredential(context.Context, *IntrospectCredentialRequest) (*CredentialInfo, error)
+	StreamGuardedRequests(*GuardedRequestsRequest, Api_StreamGuardedRequestsServer) error
 	mustEmbedUnimplementedApiServer()
 }
 
@@ -57,6 +193,30 @@ type UnimplementedApiServer struct {
 func (UnimplementedApiServer) GetRocketPoolNodes(context.Context, *RocketPoolNodesRequest) (*RocketPoolNodes, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetRocketPoolNodes not implemented")
 }
+func (UnimplementedApiServer) GetValidatorFeeRecipient(context.Context, *ValidatorFeeRecipientRequest) (*ValidatorFeeRecipient, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetValidatorFeeRecipient not implemented")
+}
+func (UnimplementedApiServer) GetNodeInfo(context.Context, *NodeInfoRequest) (*NodeInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetNodeInfo not implemented")
+}
+func (UnimplementedApiServer) StreamRocketPoolNodeEvents(*RocketPoolNodeEventsRequest, Api_StreamRocketPoolNodeEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamRocketPoolNodeEvents not implemented")
+}
+func (UnimplementedApiServer) GetSmoothingPoolInfo(context.Context, *SmoothingPoolInfoRequest) (*SmoothingPoolInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSmoothingPoolInfo not implemented")
+}
+func (UnimplementedApiServer) GetNodeMinipools(context.Context, *NodeMinipoolsRequest) (*NodeMinipools, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetNodeMinipools not implemented")
+}
+func (UnimplementedApiServer) CreateCredential(context.Context, *CreateCredentialRequest) (*Credential, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateCredential not implemented")
+}
+func (UnimplementedApiServer) IntrospectCredential(context.Context, *IntrospectCredentialRequest) (*CredentialInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method IntrospectCredential not implemented")
+}
+func (UnimplementedApiServer) StreamGuardedRequests(*GuardedRequestsRequest, Api_StreamGuardedRequestsServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamGuardedRequests not implemented")
+}
 func (UnimplementedApiServer) mustEmbedUnimplementedApiServer() {}
 
 // UnsafeApiServer may be embedded to opt out of forward compatibility for this service.
@@ -88,6 +248,156 @@ func _Api_GetRocketPoolNodes_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Api_GetValidatorFeeRecipient_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ValidatorFeeRecipientRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiServer).GetValidatorFeeRecipient(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Api/GetValidatorFeeRecipient",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiServer).GetValidatorFeeRecipient(ctx, req.(*ValidatorFeeRecipientRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Api_GetNodeInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NodeInfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiServer).GetNodeInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Api/GetNodeInfo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiServer).GetNodeInfo(ctx, req.(*NodeInfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Api_StreamRocketPoolNodeEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(RocketPoolNodeEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ApiServer).StreamRocketPoolNodeEvents(m, &apiStreamRocketPoolNodeEventsServer{stream})
+}
+
+type Api_StreamRocketPoolNodeEventsServer interface {
+	Send(*RocketPoolNodeEvent) error
+	grpc.ServerStream
+}
+
+type apiStreamRocketPoolNodeEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *apiStreamRocketPoolNodeEventsServer) Send(m *RocketPoolNodeEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Api_GetSmoothingPoolInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SmoothingPoolInfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiServer).GetSmoothingPoolInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Api/GetSmoothingPoolInfo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiServer).GetSmoothingPoolInfo(ctx, req.(*SmoothingPoolInfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Api_GetNodeMinipools_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NodeMinipoolsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiServer).GetNodeMinipools(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Api/GetNodeMinipools",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiServer).GetNodeMinipools(ctx, req.(*NodeMinipoolsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Api_CreateCredential_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateCredentialRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiServer).CreateCredential(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Api/CreateCredential",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiServer).CreateCredential(ctx, req.(*CreateCredentialRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Api_IntrospectCredential_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IntrospectCredentialRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiServer).IntrospectCredential(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Api/IntrospectCredential",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiServer).IntrospectCredential(ctx, req.(*IntrospectCredentialRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Api_StreamGuardedRequests_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GuardedRequestsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ApiServer).StreamGuardedRequests(m, &apiStreamGuardedRequestsServer{stream})
+}
+
+type Api_StreamGuardedRequestsServer interface {
+	Send(*GuardedRequest) error
+	grpc.ServerStream
+}
+
+type apiStreamGuardedRequestsServer struct {
+	grpc.ServerStream
+}
+
+func (x *apiStreamGuardedRequestsServer) Send(m *GuardedRequest) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // Api_ServiceDesc is the grpc.ServiceDesc for Api service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -99,7 +409,42 @@ var Api_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetRocketPoolNodes",
 			Handler:    _Api_GetRocketPoolNodes_Handler,
 		},
+		{
+			MethodName: "GetValidatorFeeRecipient",
+			Handler:    _Api_GetValidatorFeeRecipient_Handler,
+		},
+		{
+			MethodName: "GetNodeInfo",
+			Handler:    _Api_GetNodeInfo_Handler,
+		},
+		{
+			MethodName: "GetSmoothingPoolInfo",
+			Handler:    _Api_GetSmoothingPoolInfo_Handler,
+		},
+		{
+			MethodName: "GetNodeMinipools",
+			Handler:    _Api_GetNodeMinipools_Handler,
+		},
+		{
+			MethodName: "CreateCredential",
+			Handler:    _Api_CreateCredential_Handler,
+		},
+		{
+			MethodName: "IntrospectCredential",
+			Handler:    _Api_IntrospectCredential_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamRocketPoolNodeEvents",
+			Handler:       _Api_StreamRocketPoolNodeEvents_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StreamGuardedRequests",
+			Handler:       _Api_StreamGuardedRequests_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "api.proto",
 }
@@ -0,0 +1,163 @@
+package pgstore
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeDB answers the Store's three queries from memory, so it can be tested without a database.
+// Rows are the values the real tables would return, in the order the queries select them.
+type fakeDB struct {
+	sync.Mutex
+	users             map[string][]driver.Value
+	nodeFeeRecipients map[string][]string
+	validators        map[string][]driver.Value
+	// If set, every query fails with it
+	err error
+
+	queries atomic.Int64
+}
+
+var (
+	fakeDBs      sync.Map
+	fakeDBsCount atomic.Int64
+)
+
+func init() {
+	sql.Register("pgstore-fake", fakeDriver{})
+}
+
+// openFakeDB returns a sql.DB backed by a new fakeDB
+func openFakeDB(t *testing.T) (*sql.DB, *fakeDB) {
+	name := fmt.Sprintf("fake-%d", fakeDBsCount.Add(1))
+	fake := &fakeDB{
+		users:             make(map[string][]driver.Value),
+		nodeFeeRecipients: make(map[string][]string),
+		validators:        make(map[string][]driver.Value),
+	}
+	fakeDBs.Store(name, fake)
+
+	db, err := sql.Open("pgstore-fake", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		fakeDBs.Delete(name)
+	})
+	return db, fake
+}
+
+func (f *fakeDB) query(query string, arg string) (driver.Rows, error) {
+	f.queries.Add(1)
+	f.Lock()
+	defer f.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	switch query {
+	case userQuery:
+		rows := &fakeRows{columns: []string{"password_hash", "operator_type", "node_address", "expires_at"}}
+		if row, ok := f.users[arg]; ok {
+			rows.rows = append(rows.rows, row)
+		}
+		return rows, nil
+	case nodeFeeRecipientsQuery:
+		rows := &fakeRows{columns: []string{"fee_recipient"}}
+		for _, feeRecipient := range f.nodeFeeRecipients[arg] {
+			rows.rows = append(rows.rows, []driver.Value{feeRecipient})
+		}
+		return rows, nil
+	case validatorQuery:
+		rows := &fakeRows{columns: []string{"fee_recipient", "node_address"}}
+		if row, ok := f.validators[arg]; ok {
+			rows.rows = append(rows.rows, row)
+		}
+		return rows, nil
+	}
+
+	return nil, fmt.Errorf("unexpected query %q", query)
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fake, ok := fakeDBs.Load(name)
+	if !ok {
+		return nil, fmt.Errorf("no fake database %s", name)
+	}
+
+	return &fakeConn{db: fake.(*fakeDB)}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions aren't supported")
+}
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return 1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("exec isn't supported")
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	arg, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("expected a string argument, got %T", args[0])
+	}
+
+	return s.db.query(s.query, arg)
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
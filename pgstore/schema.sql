@@ -0,0 +1,21 @@
+-- Users authenticate with their username and password, as node_address.
+-- password_hash is a bcrypt hash, eg from htpasswd -nB. Users can't authenticate after expires_at, if it's set.
+CREATE TABLE IF NOT EXISTS users (
+	username      text PRIMARY KEY,
+	password_hash text NOT NULL,
+	operator_type text NOT NULL DEFAULT 'rocketpool' CHECK (operator_type IN ('rocketpool', 'solo')),
+	node_address  text NOT NULL CHECK (node_address ~ '^0x[0-9a-f]{40}$'),
+	expires_at    timestamptz
+);
+
+-- Rows with a pubkey say which fee recipient that validator must use, and, if node_address is set,
+-- the only node which may use it. Rows without one list the fee recipients users authenticating as
+-- node_address may use, whichever validator they're for.
+CREATE TABLE IF NOT EXISTS fee_recipients (
+	pubkey        text CHECK (pubkey ~ '^0x[0-9a-f]{96}$'),
+	node_address  text CHECK (node_address ~ '^0x[0-9a-f]{40}$'),
+	fee_recipient text NOT NULL CHECK (fee_recipient ~ '^0x[0-9a-f]{40}$'),
+	CHECK (pubkey IS NOT NULL OR node_address IS NOT NULL)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS fee_recipients_pubkey ON fee_recipients (pubkey) WHERE pubkey IS NOT NULL;
+CREATE INDEX IF NOT EXISTS fee_recipients_node_address ON fee_recipients (node_address) WHERE pubkey IS NULL;
@@ -0,0 +1,282 @@
+// Package pgstore keeps credentials and fee recipients in a PostgreSQL database, for deployments with
+// too many users to manage in files. See schema.sql for the tables it reads.
+package pgstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/auth"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/internal/ttlcache"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Schema creates the tables a Store reads, if they don't exist
+//
+//go:embed schema.sql
+var Schema string
+
+const (
+	userQuery              = "SELECT password_hash, operator_type, node_address, expires_at FROM users WHERE username = $1"
+	nodeFeeRecipientsQuery = "SELECT fee_recipient FROM fee_recipients WHERE pubkey IS NULL AND node_address = $1"
+	validatorQuery         = "SELECT fee_recipient, node_address FROM fee_recipients WHERE pubkey = $1"
+)
+
+// user is a row of the users table, with the fee recipients its node is restricted to
+type user struct {
+	hash          []byte
+	operatorType  auth.OperatorType
+	nodeAddress   common.Address
+	expires       *time.Time
+	feeRecipients []common.Address
+
+	// sha256 of the last password which verified, so bcrypt isn't run on every request
+	sync.Mutex
+	verified *[sha256.Size]byte
+}
+
+// validator is a row of the fee_recipients table with a pubkey
+type validator struct {
+	feeRecipient common.Address
+	nodeAddress  *common.Address
+}
+
+// Store verifies credentials against the users table, and looks up fee recipients in the fee_recipients table.
+// Results are cached for a while, so the proxy's hot path doesn't wait on the database, at the cost of changes
+// taking up to the cache ttl to apply.
+type Store struct {
+	queryTimeout time.Duration
+
+	userStmt              *sql.Stmt
+	nodeFeeRecipientsStmt *sql.Stmt
+	validatorStmt         *sql.Stmt
+
+	// nil values mean there's no such user or validator
+	users      *ttlcache.Cache[*user]
+	validators *ttlcache.Cache[*validator]
+
+	m   *metrics.MetricsRegistry
+	now func() time.Time
+}
+
+// New prepares the queries a Store makes on db, whose connection pool it shares. Results are cached
+// for cacheTTL, or not at all if it's 0, and each query may take up to queryTimeout.
+func New(ctx context.Context, db *sql.DB, cacheTTL, queryTimeout time.Duration) (*Store, error) {
+	out := &Store{
+		queryTimeout: queryTimeout,
+		users:        ttlcache.New[*user](cacheTTL),
+		validators:   ttlcache.New[*validator](cacheTTL),
+		m:            metrics.NewMetricsRegistry("pgstore"),
+		now:          time.Now,
+	}
+
+	for _, stmt := range []struct {
+		query string
+		stmt  **sql.Stmt
+	}{
+		{userQuery, &out.userStmt},
+		{nodeFeeRecipientsQuery, &out.nodeFeeRecipientsStmt},
+		{validatorQuery, &out.validatorStmt},
+	} {
+		var err error
+		*stmt.stmt, err = db.PrepareContext(ctx, stmt.query)
+		if err != nil {
+			out.Close()
+			return nil, fmt.Errorf("error preparing %q: %w", stmt.query, err)
+		}
+	}
+
+	return out, nil
+}
+
+// Close releases the prepared statements. The database itself is left open.
+func (s *Store) Close() {
+	for _, stmt := range []*sql.Stmt{s.userStmt, s.nodeFeeRecipientsStmt, s.validatorStmt} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+func parseAddress(column, s string) (common.Address, error) {
+	if !common.IsHexAddress(s) {
+		return common.Address{}, fmt.Errorf("%s %q is not an address", column, s)
+	}
+
+	return common.HexToAddress(s), nil
+}
+
+func (s *Store) queryUser(ctx context.Context, username string) (*user, error) {
+	var hash, operatorType, nodeAddress string
+	var expires sql.NullTime
+	err := s.userStmt.QueryRowContext(ctx, username).Scan(&hash, &operatorType, &nodeAddress, &expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := &user{hash: []byte(hash)}
+	if out.operatorType, err = auth.ParseOperatorType(operatorType); err != nil {
+		return nil, err
+	}
+	if out.nodeAddress, err = parseAddress("node_address", nodeAddress); err != nil {
+		return nil, err
+	}
+	if expires.Valid {
+		out.expires = &expires.Time
+	}
+
+	rows, err := s.nodeFeeRecipientsStmt.QueryContext(ctx, strings.ToLower(out.nodeAddress.Hex()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var feeRecipient string
+		if err := rows.Scan(&feeRecipient); err != nil {
+			return nil, err
+		}
+		address, err := parseAddress("fee_recipient", feeRecipient)
+		if err != nil {
+			return nil, err
+		}
+		out.feeRecipients = append(out.feeRecipients, address)
+	}
+
+	return out, rows.Err()
+}
+
+// user returns the named user, or nil if there isn't one
+func (s *Store) user(username string) (*user, error) {
+	if cached, ok := s.users.Get(username); ok {
+		s.m.Counter("cache_hit").Inc()
+		return cached, nil
+	}
+	s.m.Counter("cache_miss").Inc()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.queryTimeout)
+	defer cancel()
+	out, err := s.queryUser(ctx, username)
+	if err != nil {
+		s.m.Counter("query_error").Inc()
+		return nil, fmt.Errorf("error looking up user %s: %w", username, err)
+	}
+
+	s.users.Put(username, out)
+	return out, nil
+}
+
+// Knows returns true if username is in the users table. If the database can't be reached, it returns
+// true too, so Verify reports the error rather than the credential being tried as another kind.
+func (s *Store) Knows(username string) bool {
+	u, err := s.user(username)
+	return err != nil || u != nil
+}
+
+func (s *Store) Verify(username, password string) (*auth.Credential, error) {
+	u, err := s.user(username)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, fmt.Errorf("%w: unknown user", auth.ErrInvalid)
+	}
+
+	sum := sha256.Sum256([]byte(password))
+	u.Lock()
+	cached := u.verified != nil && subtle.ConstantTimeCompare(sum[:], u.verified[:]) == 1
+	u.Unlock()
+	if !cached {
+		if err := bcrypt.CompareHashAndPassword(u.hash, []byte(password)); err != nil {
+			return nil, fmt.Errorf("%w: %v", auth.ErrInvalid, err)
+		}
+
+		u.Lock()
+		u.verified = &sum
+		u.Unlock()
+	}
+
+	if u.expires != nil && !s.now().Before(*u.expires) {
+		return nil, auth.ErrExpired
+	}
+
+	return &auth.Credential{
+		NodeAddress:   u.nodeAddress,
+		OperatorType:  u.operatorType,
+		ID:            "pgstore:" + username,
+		FeeRecipients: u.feeRecipients,
+	}, nil
+}
+
+func (s *Store) queryValidator(ctx context.Context, pubkey rptypes.ValidatorPubkey) (*validator, error) {
+	var feeRecipient string
+	var nodeAddress sql.NullString
+	err := s.validatorStmt.QueryRowContext(ctx, "0x"+pubkey.Hex()).Scan(&feeRecipient, &nodeAddress)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := &validator{}
+	if out.feeRecipient, err = parseAddress("fee_recipient", feeRecipient); err != nil {
+		return nil, err
+	}
+	if nodeAddress.Valid {
+		address, err := parseAddress("node_address", nodeAddress.String)
+		if err != nil {
+			return nil, err
+		}
+		out.nodeAddress = &address
+	}
+
+	return out, nil
+}
+
+func (s *Store) ValidatorFeeRecipient(ctx context.Context, pubkey rptypes.ValidatorPubkey, nodeAddr *common.Address) (*feerecipient.Info, error) {
+	key := pubkey.Hex()
+	v, ok := s.validators.Get(key)
+	if ok {
+		s.m.Counter("cache_hit").Inc()
+	} else {
+		s.m.Counter("cache_miss").Inc()
+
+		ctx, cancel := context.WithTimeout(ctx, s.queryTimeout)
+		defer cancel()
+		var err error
+		v, err = s.queryValidator(ctx, pubkey)
+		if err != nil {
+			s.m.Counter("query_error").Inc()
+			return nil, fmt.Errorf("error looking up validator %s: %w", key, err)
+		}
+		s.validators.Put(key, v)
+	}
+
+	if v == nil {
+		return nil, feerecipient.ErrNotMinipool
+	}
+	if v.nodeAddress != nil && nodeAddr != nil && *v.nodeAddress != *nodeAddr {
+		return nil, feerecipient.ErrWrongNode
+	}
+
+	out := &feerecipient.Info{Expected: v.feeRecipient}
+	if v.nodeAddress != nil {
+		out.NodeAddress = *v.nodeAddress
+	}
+	return out, nil
+}
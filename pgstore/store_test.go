@@ -0,0 +1,201 @@
+package pgstore
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/auth"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipientstest"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	testNode         = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	testFeeRecipient = common.HexToAddress("0x2222222222222222222222222222222222222222")
+	testPubkey       = rptypes.BytesToValidatorPubkey(common.FromHex("0x" + strings.Repeat("ab", 48)))
+	unknownPubkey    = rptypes.BytesToValidatorPubkey(common.FromHex("0x" + strings.Repeat("cd", 48)))
+)
+
+func testMetrics(t *testing.T) {
+	_, err := metrics.Init("pgstore_test_" + t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(metrics.Deinit)
+}
+
+func hash(t *testing.T, password string) string {
+	out, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func lower(address common.Address) string {
+	return strings.ToLower(address.Hex())
+}
+
+// testStore creates a Store on a fakeDB, whose clock can be moved with the returned function
+func testStore(t *testing.T, db *sql.DB, cacheTTL time.Duration) (*Store, func(time.Duration)) {
+	s, err := New(context.Background(), db, cacheTTL, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+
+	now := time.Now()
+	clock := func() time.Time { return now }
+	s.now = clock
+	s.users.Now = clock
+	s.validators.Now = clock
+	return s, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestStoreVerify(t *testing.T) {
+	testMetrics(t)
+	db, fake := openFakeDB(t)
+	restricted := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	fake.users["alice"] = []driver.Value{hash(t, "hunter2"), "solo", lower(testNode), nil}
+	fake.users["bob"] = []driver.Value{hash(t, "correct horse"), "rocketpool", lower(restricted), time.Now().Add(time.Hour)}
+	fake.nodeFeeRecipients[lower(restricted)] = []string{lower(testFeeRecipient)}
+	s, advance := testStore(t, db, time.Minute)
+
+	credential, err := s.Verify("alice", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credential.NodeAddress != testNode || credential.OperatorType != auth.OperatorSolo ||
+		len(credential.FeeRecipients) != 0 || credential.ID != "pgstore:alice" {
+		t.Fatalf("unexpected credential %+v", credential)
+	}
+
+	credential, err = s.Verify("bob", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credential.NodeAddress != restricted || len(credential.FeeRecipients) != 1 || credential.FeeRecipients[0] != testFeeRecipient {
+		t.Fatalf("unexpected credential %+v", credential)
+	}
+
+	if !s.Knows("alice") || s.Knows("carol") {
+		t.Fatal("expected alice to be the only one of alice and carol in the users table")
+	}
+	for _, pair := range [][2]string{{"alice", "hunter3"}, {"carol", "hunter2"}} {
+		if _, err := s.Verify(pair[0], pair[1]); !errors.Is(err, auth.ErrInvalid) {
+			t.Fatalf("%s: expected %v, got %v", pair[0], auth.ErrInvalid, err)
+		}
+	}
+
+	// bob can't authenticate once his credential expires
+	advance(2 * time.Hour)
+	if _, err := s.Verify("bob", "correct horse"); !errors.Is(err, auth.ErrExpired) {
+		t.Fatalf("expected %v, got %v", auth.ErrExpired, err)
+	}
+}
+
+func TestStoreCache(t *testing.T) {
+	testMetrics(t)
+	db, fake := openFakeDB(t)
+	fake.users["alice"] = []driver.Value{hash(t, "hunter2"), "rocketpool", lower(testNode), nil}
+	s, advance := testStore(t, db, time.Minute)
+
+	// The user and their node's fee recipients are queried once, and unknown users are remembered too
+	for i := 0; i < 3; i++ {
+		if _, err := s.Verify("alice", "hunter2"); err != nil {
+			t.Fatal(err)
+		}
+		if s.Knows("carol") {
+			t.Fatal("expected carol to be unknown")
+		}
+	}
+	if queries := fake.queries.Load(); queries != 3 {
+		t.Fatalf("expected 3 queries, got %d", queries)
+	}
+
+	// Changes are picked up once the cache expires
+	fake.Lock()
+	fake.users["alice"] = []driver.Value{hash(t, "hunter3"), "rocketpool", lower(testNode), nil}
+	fake.Unlock()
+	if _, err := s.Verify("alice", "hunter3"); !errors.Is(err, auth.ErrInvalid) {
+		t.Fatalf("expected the cached password to be checked, got %v", err)
+	}
+	advance(time.Minute)
+	if _, err := s.Verify("alice", "hunter3"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Errors aren't cached, and Verify reports them rather than another verifier being tried
+	advance(time.Minute)
+	fake.Lock()
+	fake.err = errors.New("connection refused")
+	fake.Unlock()
+	if !s.Knows("alice") {
+		t.Fatal("expected Knows to defer to Verify while the database is down")
+	}
+	if _, err := s.Verify("alice", "hunter3"); err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("expected the database error, got %v", err)
+	}
+	fake.Lock()
+	fake.err = nil
+	fake.Unlock()
+	if _, err := s.Verify("alice", "hunter3"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreWithoutCache(t *testing.T) {
+	testMetrics(t)
+	db, fake := openFakeDB(t)
+	s, _ := testStore(t, db, 0)
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.ValidatorFeeRecipient(context.Background(), unknownPubkey, nil); !errors.Is(err, feerecipient.ErrNotMinipool) {
+			t.Fatalf("expected %v, got %v", feerecipient.ErrNotMinipool, err)
+		}
+	}
+	if queries := fake.queries.Load(); queries != 2 {
+		t.Fatalf("expected every lookup to query, got %d queries", queries)
+	}
+}
+
+func TestStoreFeeRecipientContract(t *testing.T) {
+	testMetrics(t)
+	db, fake := openFakeDB(t)
+	fake.validators["0x"+testPubkey.Hex()] = []driver.Value{lower(testFeeRecipient), lower(testNode)}
+	s, _ := testStore(t, db, time.Minute)
+
+	feerecipientstest.TestFeeRecipientSource(t, s, feerecipientstest.Fixture{
+		Known:        testPubkey,
+		FeeRecipient: testFeeRecipient,
+		Owner:        testNode,
+		Unknown:      unknownPubkey,
+	})
+}
+
+func TestStoreInvalidRows(t *testing.T) {
+	testMetrics(t)
+	db, fake := openFakeDB(t)
+	fake.users["alice"] = []driver.Value{hash(t, "hunter2"), "lido", lower(testNode), nil}
+	fake.users["bob"] = []driver.Value{hash(t, "hunter2"), "rocketpool", "bob", nil}
+	fake.validators["0x"+testPubkey.Hex()] = []driver.Value{"0x1234", nil}
+	s, _ := testStore(t, db, time.Minute)
+
+	for _, username := range []string{"alice", "bob"} {
+		if _, err := s.Verify(username, "hunter2"); err == nil {
+			t.Fatalf("expected %s's row to be refused", username)
+		}
+	}
+	if _, err := s.ValidatorFeeRecipient(context.Background(), testPubkey, nil); err == nil || errors.Is(err, feerecipient.ErrNotMinipool) {
+		t.Fatal("expected the validator's row to be refused")
+	}
+}
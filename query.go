@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/client"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/pb"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Exit codes of the query subcommand
+const (
+	queryOK       = 0
+	queryFailed   = 1
+	queryUsage    = 2
+	queryNotFound = 3
+)
+
+const queryUsageText = `Usage: rescue-proxy query [flags] <command> [argument]
+
+Asks a running proxy's gRPC API about what's in its EL cache.
+
+Commands:
+  nodes                 list the rocket pool nodes
+  node <address>        a node's smoothing pool status, fee distributor and minipools
+  validator <pubkey>    a validator's expected fee recipient
+
+Exits with 3 if the node or validator isn't known, 2 for invalid arguments, and 1 for other errors.
+
+Flags:
+`
+
+// queryTable is a query's result, both as json and as table rows under its header
+type queryTable struct {
+	json   any
+	header []string
+	rows   [][]string
+}
+
+// runQuery runs the query subcommand with args, the command line after "query", and returns its exit code
+func runQuery(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("query", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, queryUsageText)
+		flags.PrintDefaults()
+	}
+	addr := flags.String("api-addr", "0.0.0.0:8080", "Address the proxy replies to gRPC API requests on, as passed to its -api-addr")
+	caFile := flags.String("ca-file", "", "Optional CA bundle to verify the API's TLS certificate with. Leave blank to connect in plaintext")
+	certFile := flags.String("cert-file", "", "Optional TLS certificate to present to the API, if it's started with -api-tls-client-ca-file")
+	keyFile := flags.String("key-file", "", "The key for -cert-file")
+	output := flags.String("output", "json", "How to print the result: json, or table")
+	details := flags.Bool("details", false, "Whether nodes includes each node's smoothing pool status and fee distributor")
+	timeout := flags.Duration("timeout", 5*time.Second, "How long to wait for the API to reply")
+	if err := flags.Parse(args); err != nil {
+		return queryUsage
+	}
+
+	if *output != "json" && *output != "table" {
+		fmt.Fprintf(stderr, "Invalid -output: expected json or table, got %q\n", *output)
+		return queryUsage
+	}
+
+	if (*certFile == "") != (*keyFile == "") {
+		fmt.Fprintf(stderr, "If either -cert-file or -key-file is set, both must be set\n")
+		return queryUsage
+	}
+	if *certFile != "" && *caFile == "" {
+		fmt.Fprintf(stderr, "-cert-file requires -ca-file\n")
+		return queryUsage
+	}
+
+	command, argument := flags.Arg(0), flags.Arg(1)
+	expectArgs := 2
+	if command == "nodes" {
+		expectArgs = 1
+	}
+	if flags.NArg() != expectArgs || (command != "nodes" && command != "node" && command != "validator") {
+		flags.Usage()
+		return queryUsage
+	}
+
+	conn, err := client.New(client.Config{Addr: *addr, CAFile: *caFile, CertFile: *certFile, KeyFile: *keyFile})
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return queryFailed
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	c := conn.API()
+	var result *queryTable
+	switch command {
+	case "nodes":
+		result, err = queryNodes(ctx, c, *details)
+	case "node":
+		if !common.IsHexAddress(argument) {
+			fmt.Fprintf(stderr, "Invalid node address %q\n", argument)
+			return queryUsage
+		}
+		result, err = queryNode(ctx, c, common.HexToAddress(argument))
+	case "validator":
+		pubkey, parseErr := rptypes.HexToValidatorPubkey(strings.TrimPrefix(argument, "0x"))
+		if parseErr != nil {
+			fmt.Fprintf(stderr, "Invalid validator pubkey %q: %v\n", argument, parseErr)
+			return queryUsage
+		}
+		result, err = queryValidator(ctx, c, pubkey)
+	}
+	if status.Code(err) == codes.NotFound {
+		fmt.Fprintf(stderr, "%s\n", status.Convert(err).Message())
+		return queryNotFound
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return queryFailed
+	}
+
+	if *output == "table" {
+		err = result.printTable(stdout)
+	} else {
+		err = result.printJSON(stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return queryFailed
+	}
+	return queryOK
+}
+
+func queryNodes(ctx context.Context, c pb.ApiClient, details bool) (*queryTable, error) {
+	r, err := c.GetRocketPoolNodes(ctx, &pb.RocketPoolNodesRequest{IncludeDetails: details})
+	if err != nil {
+		return nil, err
+	}
+
+	if !details {
+		out := &queryTable{header: []string{"ADDRESS"}}
+		addrs := make([]string, 0, len(r.GetNodeIds()))
+		for _, nodeID := range r.GetNodeIds() {
+			addr := common.BytesToAddress(nodeID).String()
+			addrs = append(addrs, addr)
+			out.rows = append(out.rows, []string{addr})
+		}
+		out.json = addrs
+		return out, nil
+	}
+
+	out := &queryTable{header: []string{"ADDRESS", "SMOOTHING POOL", "FEE DISTRIBUTOR"}}
+	nodes := make([]map[string]any, 0, len(r.GetNodes()))
+	for _, node := range r.GetNodes() {
+		addr := common.BytesToAddress(node.GetNodeId()).String()
+		feeDistributor := common.BytesToAddress(node.GetFeeDistributor()).String()
+		nodes = append(nodes, map[string]any{
+			"address":         addr,
+			"smoothing_pool":  node.GetSmoothingPool(),
+			"fee_distributor": feeDistributor,
+		})
+		out.rows = append(out.rows, []string{addr, fmt.Sprint(node.GetSmoothingPool()), feeDistributor})
+	}
+	out.json = nodes
+	return out, nil
+}
+
+func queryNode(ctx context.Context, c pb.ApiClient, nodeAddr common.Address) (*queryTable, error) {
+	r, err := c.GetNodeInfo(ctx, &pb.NodeInfoRequest{NodeId: nodeAddr.Bytes()})
+	if err != nil {
+		return nil, err
+	}
+
+	feeDistributor := common.BytesToAddress(r.GetFeeDistributor()).String()
+	pubkeys := make([]string, 0, len(r.GetMinipoolPubkeys()))
+	for _, pubkey := range r.GetMinipoolPubkeys() {
+		pubkeys = append(pubkeys, "0x"+hex.EncodeToString(pubkey))
+	}
+
+	// Each minipool gets a row, so the table stays readable for nodes with many of them
+	out := &queryTable{
+		json: map[string]any{
+			"address":          nodeAddr.String(),
+			"smoothing_pool":   r.GetSmoothingPool(),
+			"fee_distributor":  feeDistributor,
+			"minipool_pubkeys": pubkeys,
+		},
+		header: []string{"FIELD", "VALUE"},
+		rows: [][]string{
+			{"address", nodeAddr.String()},
+			{"smoothing_pool", fmt.Sprint(r.GetSmoothingPool())},
+			{"fee_distributor", feeDistributor},
+		},
+	}
+	for _, pubkey := range pubkeys {
+		out.rows = append(out.rows, []string{"minipool_pubkey", pubkey})
+	}
+	return out, nil
+}
+
+func queryValidator(ctx context.Context, c pb.ApiClient, pubkey rptypes.ValidatorPubkey) (*queryTable, error) {
+	r, err := c.GetValidatorFeeRecipient(ctx, &pb.ValidatorFeeRecipientRequest{Pubkey: pubkey.Bytes()})
+	if err != nil {
+		return nil, err
+	}
+
+	feeRecipient := common.BytesToAddress(r.GetFeeRecipient()).String()
+	nodeAddr := common.BytesToAddress(r.GetNodeId()).String()
+	return &queryTable{
+		json: map[string]any{
+			"pubkey":         "0x" + pubkey.Hex(),
+			"fee_recipient":  feeRecipient,
+			"smoothing_pool": r.GetSmoothingPool(),
+			"node_id":        nodeAddr,
+		},
+		header: []string{"FIELD", "VALUE"},
+		rows: [][]string{
+			{"pubkey", "0x" + pubkey.Hex()},
+			{"fee_recipient", feeRecipient},
+			{"smoothing_pool", fmt.Sprint(r.GetSmoothingPool())},
+			{"node_id", nodeAddr},
+		},
+	}, nil
+}
+
+func (q *queryTable) printJSON(w io.Writer) error {
+	j, err := json.Marshal(q.json)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", j)
+	return err
+}
+
+func (q *queryTable) printTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(q.header, "\t"))
+	for _, row := range q.rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
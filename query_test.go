@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/api"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/internal/mocks"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+	"go.uber.org/zap"
+)
+
+var (
+	queryNodeAddr       = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	queryFeeDistributor = common.HexToAddress("0x2222222222222222222222222222222222222222")
+	querySmoothingPool  = common.HexToAddress("0x3333333333333333333333333333333333333333")
+	queryPubkey         = rptypes.BytesToValidatorPubkey(bytes.Repeat([]byte{0xab}, 48))
+)
+
+// startQueryAPI serves an api on a unix socket, with a node which has one minipool, and returns the -api-addr to query it on.
+// configure may set up the api's TLS.
+func startQueryAPI(t *testing.T, configure func(*api.API)) string {
+	if _, err := metrics.Init("query_test_" + t.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	el := mocks.NewMockExecutionLayer(querySmoothingPool)
+	el.AddNode(queryNodeAddr, false, queryFeeDistributor)
+	el.AddMinipool(queryNodeAddr, queryPubkey)
+
+	addr := "unix://" + filepath.Join(t.TempDir(), "api.sock")
+	a := api.NewAPI(addr, el, zap.NewNop())
+	if configure != nil {
+		configure(a)
+	}
+	if err := a.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		a.Deinit()
+		metrics.Deinit()
+	})
+	return addr
+}
+
+func query(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := runQuery(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestQuery(t *testing.T) {
+	addr := startQueryAPI(t, nil)
+
+	for _, tc := range []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+	}{
+		{"nodes", []string{"nodes"}, queryOK, `["` + queryNodeAddr.String() + `"]`},
+		{"nodes with details", []string{"-details", "nodes"}, queryOK,
+			`[{"address":"` + queryNodeAddr.String() + `","fee_distributor":"` + queryFeeDistributor.String() + `","smoothing_pool":false}]`},
+		{"node", []string{"node", strings.ToLower(queryNodeAddr.String())}, queryOK,
+			`{"address":"` + queryNodeAddr.String() + `","fee_distributor":"` + queryFeeDistributor.String() + `","minipool_pubkeys":["0x` + queryPubkey.Hex() + `"],"smoothing_pool":false}`},
+		{"unknown node", []string{"node", querySmoothingPool.String()}, queryNotFound, ""},
+		{"validator", []string{"validator", "0x" + queryPubkey.Hex()}, queryOK,
+			`{"fee_recipient":"` + queryFeeDistributor.String() + `","node_id":"` + queryNodeAddr.String() + `","pubkey":"0x` + queryPubkey.Hex() + `","smoothing_pool":false}`},
+		{"unknown validator", []string{"validator", strings.Repeat("cd", 48)}, queryNotFound, ""},
+		{"invalid node", []string{"node", "0x1234"}, queryUsage, ""},
+		{"invalid validator", []string{"validator", "0x1234"}, queryUsage, ""},
+		{"missing argument", []string{"node"}, queryUsage, ""},
+		{"unknown command", []string{"minipools"}, queryUsage, ""},
+		{"invalid output", []string{"-output", "yaml", "nodes"}, queryUsage, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			code, stdout, stderr := query(append([]string{"-api-addr", addr}, tc.args...)...)
+			if code != tc.code {
+				t.Fatalf("expected exit code %d, got %d: %s", tc.code, code, stderr)
+			}
+			if strings.TrimSpace(stdout) != tc.stdout {
+				t.Fatalf("expected output %s, got %s", tc.stdout, stdout)
+			}
+		})
+	}
+}
+
+func TestQueryTable(t *testing.T) {
+	addr := startQueryAPI(t, nil)
+
+	code, stdout, stderr := query("-api-addr", addr, "-output", "table", "node", queryNodeAddr.String())
+	if code != queryOK {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 5 || strings.Fields(lines[0])[0] != "FIELD" {
+		t.Fatalf("expected a header and 4 rows, got %q", stdout)
+	}
+	if fields := strings.Fields(lines[4]); len(fields) != 2 || fields[0] != "minipool_pubkey" || fields[1] != "0x"+queryPubkey.Hex() {
+		t.Fatalf("expected a row for the minipool, got %q", lines[4])
+	}
+}
+
+// writeQueryCert writes a certificate for localhost signed by parent, or a self-signed CA if parent is nil,
+// and returns it with its key and the paths they were written to
+func writeQueryCert(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(t.TempDir(), name+".crt")
+	keyFile := filepath.Join(t.TempDir(), name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, certFile, keyFile
+}
+
+func TestQueryMutualTLS(t *testing.T) {
+	ca, caKey, caFile, _ := writeQueryCert(t, "ca", nil, nil)
+	_, _, serverCert, serverKey := writeQueryCert(t, "server", ca, caKey)
+	_, _, clientCert, clientKey := writeQueryCert(t, "client", ca, caKey)
+
+	addr := startQueryAPI(t, func(a *api.API) {
+		a.TLS.CertFile = serverCert
+		a.TLS.KeyFile = serverKey
+		a.TLS.ClientCAFile = caFile
+	})
+
+	// Without a client certificate, the api refuses the connection
+	if code, _, _ := query("-api-addr", addr, "-ca-file", caFile, "-timeout", "1s", "nodes"); code == queryOK {
+		t.Fatal("expected the query to fail without a client certificate")
+	}
+
+	code, stdout, stderr := query("-api-addr", addr, "-ca-file", caFile, "-cert-file", clientCert, "-key-file", clientKey, "nodes")
+	if code != queryOK {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+	var nodes []string
+	if err := json.Unmarshal([]byte(stdout), &nodes); err != nil || len(nodes) != 1 || nodes[0] != queryNodeAddr.String() {
+		t.Fatalf("expected the node to be listed, got %s %v", stdout, err)
+	}
+}
@@ -0,0 +1,136 @@
+package router
+
+import (
+	"errors"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/executionlayer"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// NewAuditLogger creates a logger which writes one json object per line to path.
+// path may be anything zap accepts as an output path, eg, a file, "stdout" or "stderr".
+// Every record is kept, since they're used to explain rejections to node operators after the fact.
+func NewAuditLogger(path string) (*zap.Logger, error) {
+	cfg := zap.NewProductionConfig()
+	cfg.Sampling = nil
+	cfg.DisableCaller = true
+	cfg.DisableStacktrace = true
+	cfg.OutputPaths = []string{path}
+	cfg.EncoderConfig.TimeKey = "timestamp"
+	cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	return cfg.Build()
+}
+
+// feeRecipientRejection is audited whenever a request is blocked for having the wrong fee recipient.
+// Everything in it is public chain data, so nothing is redacted.
+type feeRecipientRejection struct {
+	path                  string
+	nodeAddr              common.Address
+	pubkey                rptypes.ValidatorPubkey
+	submittedFeeRecipient string
+	expectedFeeRecipient  common.Address
+	// nil if the fee recipient source couldn't say
+	inSmoothingPool *bool
+}
+
+func newFeeRecipientRejection(path string, nodeAddr common.Address, pubkey rptypes.ValidatorPubkey, submitted string, expected *feerecipient.Info) *feeRecipientRejection {
+	out := &feeRecipientRejection{
+		path:                  path,
+		nodeAddr:              nodeAddr,
+		pubkey:                pubkey,
+		submittedFeeRecipient: submitted,
+		expectedFeeRecipient:  expected.Expected,
+	}
+
+	if expected.Source != feerecipient.SourceUnknown {
+		inSmoothingPool := expected.Source == feerecipient.SourceSmoothingPool
+		out.inSmoothingPool = &inSmoothingPool
+	}
+
+	return out
+}
+
+func (f *feeRecipientRejection) log(logger *zap.Logger) {
+	fields := []zap.Field{
+		zap.String("path", f.path),
+		zap.String("node", f.nodeAddr.String()),
+		zap.String("pubkey", f.pubkey.String()),
+		zap.String("submitted_fee_recipient", f.submittedFeeRecipient),
+		zap.String("expected_fee_recipient", f.expectedFeeRecipient.String()),
+	}
+	if f.inSmoothingPool != nil {
+		fields = append(fields, zap.Bool("smoothing_pool", *f.inSmoothingPool))
+	}
+
+	logger.Info("Rejected fee recipient", fields...)
+}
+
+// feeRecipientAllowlisted is audited whenever a fee recipient other than the expected one is accepted,
+// because it's on the allowlist, so the exception can be explained after the fact
+type feeRecipientAllowlisted struct {
+	path                  string
+	nodeAddr              common.Address
+	pubkey                rptypes.ValidatorPubkey
+	submittedFeeRecipient string
+	expectedFeeRecipient  common.Address
+	// The allowlist entry the fee recipient matched
+	reason string
+}
+
+func newFeeRecipientAllowlisted(path string, nodeAddr common.Address, pubkey rptypes.ValidatorPubkey, submitted string, expected *feerecipient.Info, allowed *executionlayer.AllowedFeeRecipient) *feeRecipientAllowlisted {
+	return &feeRecipientAllowlisted{
+		path:                  path,
+		nodeAddr:              nodeAddr,
+		pubkey:                pubkey,
+		submittedFeeRecipient: submitted,
+		expectedFeeRecipient:  expected.Expected,
+		reason:                allowed.Name,
+	}
+}
+
+func (f *feeRecipientAllowlisted) log(logger *zap.Logger) {
+	logger.Info("Accepted allowlisted fee recipient",
+		zap.String("path", f.path),
+		zap.String("node", f.nodeAddr.String()),
+		zap.String("pubkey", f.pubkey.String()),
+		zap.String("submitted_fee_recipient", f.submittedFeeRecipient),
+		zap.String("expected_fee_recipient", f.expectedFeeRecipient.String()),
+		zap.String("reason", f.reason))
+}
+
+// cacheInconsistency is audited whenever a validator is refused because its minipool's node is missing from
+// the EL cache, which means the cache is corrupt, so the affected validators can be found afterwards
+type cacheInconsistency struct {
+	path      string
+	nodeAddr  common.Address
+	pubkey    rptypes.ValidatorPubkey
+	ownerNode common.Address
+}
+
+// newCacheInconsistency returns the record of a lookup which failed with err, or nil if err isn't a *feerecipient.MissingNodeError
+func newCacheInconsistency(path string, nodeAddr common.Address, err error) *cacheInconsistency {
+	var missing *feerecipient.MissingNodeError
+	if !errors.As(err, &missing) {
+		return nil
+	}
+
+	return &cacheInconsistency{
+		path:      path,
+		nodeAddr:  nodeAddr,
+		pubkey:    missing.Pubkey,
+		ownerNode: missing.NodeAddress,
+	}
+}
+
+func (c *cacheInconsistency) log(logger *zap.Logger) {
+	logger.Info("Rejected validator with an inconsistent cache entry",
+		zap.String("path", c.path),
+		zap.String("node", c.nodeAddr.String()),
+		zap.String("pubkey", c.pubkey.String()),
+		zap.String("minipool_node", c.ownerNode.String()))
+}
@@ -0,0 +1,74 @@
+package router
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := NewAuditLogger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inSmoothingPool := true
+	rejection := &feeRecipientRejection{
+		path:                  "/eth/v1/validator/prepare_beacon_proposer",
+		nodeAddr:              common.HexToAddress("0x1111111111111111111111111111111111111111"),
+		pubkey:                rptypes.BytesToValidatorPubkey(make([]byte, rptypes.ValidatorPubkeyLength)),
+		submittedFeeRecipient: "0x2222222222222222222222222222222222222222",
+		expectedFeeRecipient:  common.HexToAddress("0x3333333333333333333333333333333333333333"),
+		inSmoothingPool:       &inSmoothingPool,
+	}
+	rejection.log(logger)
+	// Records without a smoothing pool status are still written
+	rejection.inSmoothingPool = nil
+	rejection.log(logger)
+	_ = logger.Sync()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var records []map[string]any
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		record := make(map[string]any)
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("expected one json object per line, got %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+
+	expected := map[string]any{
+		"path":                    rejection.path,
+		"node":                    rejection.nodeAddr.String(),
+		"pubkey":                  rejection.pubkey.String(),
+		"submitted_fee_recipient": rejection.submittedFeeRecipient,
+		"expected_fee_recipient":  rejection.expectedFeeRecipient.String(),
+		"smoothing_pool":          true,
+	}
+	for key, value := range expected {
+		if records[0][key] != value {
+			t.Errorf("expected %s to be %v, got %v", key, value, records[0][key])
+		}
+	}
+	if _, ok := records[0]["timestamp"].(string); !ok {
+		t.Errorf("expected a timestamp, got %v", records[0])
+	}
+	if _, ok := records[1]["smoothing_pool"]; ok {
+		t.Errorf("expected no smoothing pool status, got %v", records[1])
+	}
+}
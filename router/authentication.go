@@ -1,11 +1,13 @@
 package router
 
 import (
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"net/http"
-	"time"
+	"strings"
 
-	"github.com/Rocket-Pool-Rescue-Node/credentials"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/auth"
 	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -26,14 +28,13 @@ func (a *authenticationError) GRPCError() error {
 }
 
 var (
-	metricsRegistry    *metrics.MetricsRegistry
-	authValidityWindow time.Duration
-	cm                 *credentials.CredentialManager
+	metricsRegistry *metrics.MetricsRegistry
+	verifier        auth.Verifier
 )
 
 func malformed(err error) *authenticationError {
 	return &authenticationError{
-		msg:        "malformed credentials: " + err.Error(),
+		msg:        err.Error(),
 		httpStatus: http.StatusUnauthorized,
 		grpcCode:   codes.Unauthenticated,
 	}
@@ -41,7 +42,7 @@ func malformed(err error) *authenticationError {
 
 func invalid(err error) *authenticationError {
 	return &authenticationError{
-		msg:        "invalid credentials: " + err.Error(),
+		msg:        err.Error(),
 		httpStatus: http.StatusUnauthorized,
 		grpcCode:   codes.Unauthenticated,
 	}
@@ -55,49 +56,110 @@ func expired() *authenticationError {
 	}
 }
 
-// authenticate returns nil if the username/password are valid and current
-// username/password must be base64url encoded
-// otherwise, it returns an authentication error
-func authenticate(username, password string) (*credentials.AuthenticatedCredential, *authenticationError) {
+func revoked() *authenticationError {
+	return &authenticationError{
+		msg:        "credential revoked",
+		httpStatus: http.StatusForbidden,
+		grpcCode:   codes.PermissionDenied,
+	}
+}
 
-	ac := credentials.AuthenticatedCredential{}
-	if len(username) == 0 || len(password) == 0 {
-		metricsRegistry.Counter("malformed").Inc()
-		return nil, malformed(fmt.Errorf("username or password missing"))
+// The ways an HTTP request can carry its credential
+const (
+	credentialSourceBasic  = "basic"
+	credentialSourceBearer = "bearer"
+	credentialSourceHeader = "header"
+)
+
+// rescueCredentialHeader carries a credential for validator clients which can add headers to their requests,
+// but not credentials to the beacon node URL
+const rescueCredentialHeader = "X-Rescue-Credential"
+
+// requestCredentials returns the username and password a request was sent with, and which of the credentialSources
+// carried them, or no source if it wasn't sent any.
+//
+// They may be sent with basic auth, or as username:password, like the gRPC API's rprnauth metadata, in an
+// Authorization: Bearer header or an X-Rescue-Credential header. The Authorization header takes precedence,
+// but if both are sent, they must carry the same credential, so that a stale one is never silently used instead.
+func requestCredentials(r *http.Request) (string, string, string, *authenticationError) {
+	authorization := r.Header.Values("Authorization")
+	header := r.Header.Values(rescueCredentialHeader)
+	if len(authorization) > 1 || len(header) > 1 {
+		return "", "", "", malformed(errors.New("more than one credential was sent"))
 	}
 
-	err := ac.Base64URLDecode(username, password)
-	if err != nil {
-		metricsRegistry.Counter("malformed").Inc()
-		return nil, malformed(err)
+	var credential, source string
+	if len(authorization) == 1 {
+		scheme, token, _ := strings.Cut(strings.TrimSpace(authorization[0]), " ")
+		switch {
+		case strings.EqualFold(scheme, "Basic"):
+			username, password, ok := r.BasicAuth()
+			if !ok {
+				return "", "", "", malformed(errors.New("malformed basic auth credential"))
+			}
+			credential, source = username+":"+password, credentialSourceBasic
+		case strings.EqualFold(scheme, "Bearer"):
+			credential, source = strings.TrimSpace(token), credentialSourceBearer
+		default:
+			return "", "", "", malformed(fmt.Errorf("unsupported Authorization scheme %q", scheme))
+		}
 	}
 
-	err = cm.Verify(&ac)
-	if err != nil {
-		metricsRegistry.Counter("invalid").Inc()
-		return nil, invalid(err)
+	if len(header) == 1 {
+		value := strings.TrimSpace(header[0])
+		if source == "" {
+			credential, source = value, credentialSourceHeader
+		} else if subtle.ConstantTimeCompare([]byte(credential), []byte(value)) != 1 {
+			return "", "", "", malformed(errors.New("the Authorization and " + rescueCredentialHeader + " headers carry different credentials"))
+		}
 	}
 
-	// Grab the timestamp and make sure the credential is recent enough
-	ts := time.Unix(ac.Credential.Timestamp, 0)
-	now := time.Now()
-	if ts.Before(now) && now.Sub(ts) > authValidityWindow {
+	if source == "" {
+		return "", "", "", nil
+	}
+
+	username, password, ok := strings.Cut(credential, ":")
+	if !ok || username == "" || password == "" {
+		return "", "", "", malformed(fmt.Errorf("%s credential must be username:password", source))
+	}
+	return username, password, source, nil
+}
+
+// authenticate returns the credential if the username/password are valid and current,
+// whether they're an HMAC credential or a JWT.
+// otherwise, it returns an authentication error
+func authenticate(username, password string) (*auth.Credential, *authenticationError) {
+
+	credential, err := verifier.Verify(username, password)
+	switch {
+	case err == nil:
+	case errors.Is(err, auth.ErrMalformed):
+		metricsRegistry.Counter("malformed").Inc()
+		return nil, malformed(err)
+	case errors.Is(err, auth.ErrRevoked):
+		metricsRegistry.Counter("revoked").Inc()
+		return nil, revoked()
+	case errors.Is(err, auth.ErrExpired):
 		metricsRegistry.Counter("expired").Inc()
 		return nil, expired()
+	default:
+		metricsRegistry.Counter("invalid").Inc()
+		return nil, invalid(err)
 	}
 
 	metricsRegistry.Counter("valid").Inc()
-	return &ac, nil
+	metricsRegistry.CounterVec("valid_operator_type", "operator_type").WithLabelValues(string(credential.OperatorType)).Inc()
+	metrics.ObserveRequest(credential.NodeAddress)
+	return credential, nil
 }
 
-func InitAuth(credentialManager *credentials.CredentialManager, validityWindow time.Duration) {
-	authValidityWindow = validityWindow
-	cm = credentialManager
+// InitAuth sets the Verifier used to authenticate requests
+func InitAuth(v auth.Verifier) {
+	verifier = v
 	metricsRegistry = metrics.NewMetricsRegistry("authentication")
 }
 
 func DeinitAuth() {
-	authValidityWindow = 0
-	cm = nil
+	verifier = nil
 	metricsRegistry = nil
 }
@@ -2,24 +2,50 @@ package router
 
 import (
 	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/Rocket-Pool-Rescue-Node/credentials"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/auth"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/internal/mocks"
 	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
 )
 
 var nodeId = []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99,
 	0x99, 0x88, 0x77, 0x66, 0x55, 0x44, 0x33, 0x22, 0x11, 0x00}
 
+// Creates credentials the verifier installed by setup() accepts
+var cm = credentials.NewCredentialManager(sha256.New, []byte("test"))
+
+var jwtSecret = []byte("jwt-test")
+
 func setup(t *testing.T) func() {
 	_, err := metrics.Init("authentication_test_" + t.Name())
 	if err != nil {
 		t.Error(err)
 	}
 
-	cm := credentials.NewCredentialManager(sha256.New, []byte("test"))
-	InitAuth(cm, time.Minute*5)
+	jwtVerifier, err := auth.NewJWTVerifier(jwtSecret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmacVerifier, err := auth.NewHMACVerifier([][]byte{[]byte("test")}, time.Minute*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	InitAuth(&auth.FormatVerifier{
+		HMAC: hmacVerifier,
+		JWT:  jwtVerifier,
+	})
 	return func() {
 		metrics.Deinit()
 		DeinitAuth()
@@ -120,8 +146,8 @@ func TestFutureCredential(t *testing.T) {
 	teardown := setup(t)
 	defer teardown()
 
-	// Create a valid credential
-	cred, err := cm.Create(time.Now().Add(time.Hour), nodeId)
+	// Create a credential issued by a clock a little ahead of ours
+	cred, err := cm.Create(time.Now().Add(10*time.Second), nodeId)
 	if err != nil {
 		t.Error(err)
 	}
@@ -138,4 +164,427 @@ func TestFutureCredential(t *testing.T) {
 	if authErr != nil {
 		t.Error(authErr)
 	}
+
+	// Credentials issued further in the future than clocks disagree by aren't valid yet
+	cred, err = cm.Create(time.Now().Add(time.Hour), nodeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password, err = cred.Base64URLEncodePassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, authErr := authenticate(cred.Base64URLEncodeUsername(), password); authErr == nil || authErr.httpStatus != http.StatusUnauthorized {
+		t.Fatalf("expected a credential from the future to be rejected, got %v", authErr)
+	}
+}
+
+func TestJWTCredential(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"node_address":  common.BytesToAddress(nodeId).String(),
+		"operator_type": "rocketpool",
+		"exp":           time.Now().Add(time.Hour).Unix(),
+	}).SignedString(jwtSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// JWTs authenticate the same node as an HMAC credential would
+	ac, authErr := authenticate("anything", token)
+	if authErr != nil {
+		t.Fatal(authErr)
+	}
+	if ac.NodeAddress != common.BytesToAddress(nodeId) {
+		t.Fatalf("expected node %x, got %s", nodeId, ac.NodeAddress)
+	}
+
+	// And are rejected when expired
+	expiredToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"node_address":  common.BytesToAddress(nodeId).String(),
+		"operator_type": "rocketpool",
+		"exp":           time.Now().Add(-time.Hour).Unix(),
+	}).SignedString(jwtSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, authErr := authenticate("anything", expiredToken); authErr == nil || authErr.httpStatus != http.StatusUnauthorized {
+		t.Fatalf("expected an expired JWT to be rejected, got %v", authErr)
+	}
+}
+
+func TestOperatorTypeCredential(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+
+	issuer, err := auth.NewHMACVerifier([][]byte{[]byte("test")}, time.Minute*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	username, password, err := issuer.Create(common.BytesToAddress(nodeId), auth.OperatorSolo, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The operator type is passed to the handlers on the request context
+	var operatorType auth.OperatorType
+	pr := &ProxyRouter{Logger: zap.NewNop(), m: metrics.NewMetricsRegistry("http_proxy")}
+	handler := pr.authenticationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operatorType = requestOperatorType(r)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/eth/v1/node/version", nil)
+	r.SetBasicAuth(username, password)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if operatorType != auth.OperatorSolo {
+		t.Fatalf("expected operator type %s, got %q", auth.OperatorSolo, operatorType)
+	}
+	if got := testutil.ToFloat64(metricsRegistry.CounterVec("valid_operator_type", "operator_type").WithLabelValues(string(auth.OperatorSolo))); got != 1 {
+		t.Fatalf("expected 1 valid solo credential, got %v", got)
+	}
+
+	// Requests without a credential are treated as Rocket Pool ones
+	if operatorType := requestOperatorType(httptest.NewRequest(http.MethodGet, "/", nil)); operatorType != auth.OperatorRocketPool {
+		t.Fatalf("expected operator type %s, got %s", auth.OperatorRocketPool, operatorType)
+	}
+}
+
+func TestRevokedCredential(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+
+	list, err := auth.LoadRevocationList(filepath.Join(t.TempDir(), "revoked"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := list.Revoke(common.BytesToAddress(nodeId).String()); err != nil {
+		t.Fatal(err)
+	}
+	InitAuth(auth.Revoking(verifier, list))
+
+	cred, err := cm.Create(time.Now(), nodeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password, err := cred.Base64URLEncodePassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Revoked credentials are refused with a distinct error body
+	pr := &ProxyRouter{Logger: zap.NewNop(), m: metrics.NewMetricsRegistry("http_proxy")}
+	handler := pr.authenticationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("expected the request to be refused")
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/eth/v1/node/version", nil)
+	r.SetBasicAuth(cred.Base64URLEncodeUsername(), password)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", w.Code)
+	}
+	var body struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Message != "authentication failed, credential revoked" {
+		t.Fatalf("unexpected error message %q", body.Message)
+	}
+	if got := testutil.ToFloat64(metricsRegistry.Counter("revoked")); got != 1 {
+		t.Fatalf("expected 1 revoked request, got %v", got)
+	}
+}
+
+func TestCredentialSubjects(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		subjectType  auth.SubjectType
+		subject      string
+		pubkey       byte
+		feeRecipient common.Address
+		code         int
+	}{
+		{"node using its own minipool", auth.SubjectNodeAddress, testNode.String(), 0x01, testFeeDistributor, http.StatusOK},
+		{"node using a minipool of another node", auth.SubjectNodeAddress, testNode.String(), 0x02, testSmoothingPool, http.StatusForbidden},
+		// Validator credentials aren't tied to a node, but may only be used for their own validator
+		{"validator itself", auth.SubjectValidatorPubkey, "0x" + testValidatorPubkey(0x02).Hex(), 0x02, testSmoothingPool, http.StatusOK},
+		{"validator with the wrong fee recipient", auth.SubjectValidatorPubkey, "0x" + testValidatorPubkey(0x02).Hex(), 0x02, testWrongRecipient, http.StatusConflict},
+		{"validator using another validator", auth.SubjectValidatorPubkey, "0x" + testValidatorPubkey(0x02).Hex(), 0x01, testFeeDistributor, http.StatusForbidden},
+		// Partners may use any validator, with the fee recipient expected of it
+		{"partner using a minipool", auth.SubjectPartner, "acme", 0x01, testFeeDistributor, http.StatusOK},
+		{"partner using a minipool of another node", auth.SubjectPartner, "acme", 0x02, testSmoothingPool, http.StatusOK},
+		{"partner with the wrong fee recipient", auth.SubjectPartner, "acme", 0x02, testWrongRecipient, http.StatusConflict},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testMetrics(t)
+			verifier, err := auth.NewHMACVerifier([][]byte{[]byte("test")}, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			InitAuth(verifier)
+			t.Cleanup(DeinitAuth)
+
+			bn := newFakeBeaconNode(t, "bn")
+			pr, _ := guardedRouter(t, &ProxyRouter{SkipStatusCheck: true}, bn)
+			handler := pr.authenticationMiddleware(pr.registerValidator())
+
+			username, password, _, err := verifier.IssueSubject(tc.subjectType, tc.subject, auth.OperatorRocketPool, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest(http.MethodPost, registerValidatorPath, strings.NewReader(registration(testValidatorPubkey(tc.pubkey), tc.feeRecipient)))
+			r.SetBasicAuth(username, password)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d %s", tc.code, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRequestCredentials(t *testing.T) {
+	basic := func(r *http.Request) { r.SetBasicAuth("user", "s3cret") }
+	bearer := func(token string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Add("Authorization", "Bearer "+token) }
+	}
+	header := func(value string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Add(rescueCredentialHeader, value) }
+	}
+
+	for _, tc := range []struct {
+		name    string
+		headers []func(*http.Request)
+		source  string
+		// Whether the request is refused as malformed
+		malformed bool
+	}{
+		{"none", nil, "", false},
+		{"basic", []func(*http.Request){basic}, credentialSourceBasic, false},
+		{"bearer", []func(*http.Request){bearer("user:s3cret")}, credentialSourceBearer, false},
+		{"lowercase bearer", []func(*http.Request){func(r *http.Request) { r.Header.Set("Authorization", "bearer user:s3cret") }}, credentialSourceBearer, false},
+		{"header", []func(*http.Request){header("user:s3cret")}, credentialSourceHeader, false},
+		{"basic and the same header", []func(*http.Request){basic, header("user:s3cret")}, credentialSourceBasic, false},
+		{"bearer and the same header", []func(*http.Request){bearer("user:s3cret"), header(" user:s3cret ")}, credentialSourceBearer, false},
+		{"bearer and a different header", []func(*http.Request){bearer("user:s3cret"), header("user:other")}, "", true},
+		{"empty bearer", []func(*http.Request){bearer("")}, "", true},
+		{"bearer without a password", []func(*http.Request){bearer("user")}, "", true},
+		{"bearer without a username", []func(*http.Request){bearer(":s3cret")}, "", true},
+		{"empty header", []func(*http.Request){header("")}, "", true},
+		{"malformed basic", []func(*http.Request){func(r *http.Request) { r.Header.Set("Authorization", "Basic !!!") }}, "", true},
+		{"unknown scheme", []func(*http.Request){func(r *http.Request) { r.Header.Set("Authorization", "Token user:s3cret") }}, "", true},
+		{"two bearers", []func(*http.Request){bearer("user:s3cret"), bearer("user:s3cret")}, "", true},
+		{"two headers", []func(*http.Request){header("user:s3cret"), header("user:s3cret")}, "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/eth/v1/node/version", nil)
+			for _, set := range tc.headers {
+				set(r)
+			}
+
+			username, password, source, err := requestCredentials(r)
+			if tc.malformed {
+				if err == nil || err.httpStatus != http.StatusUnauthorized {
+					t.Fatalf("expected a 401, got %v", err)
+				}
+				if strings.Contains(err.Error(), "s3cret") {
+					t.Fatalf("expected the error not to repeat the credential, got %q", err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if source != tc.source {
+				t.Fatalf("expected source %q, got %q", tc.source, source)
+			}
+			if source != "" && (username != "user" || password != "s3cret") {
+				t.Fatalf("expected user:s3cret, got %s:%s", username, password)
+			}
+		})
+	}
+}
+
+func TestBearerCredential(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+
+	cred, err := cm.Create(time.Now(), nodeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	username := cred.Base64URLEncodeUsername()
+	password, err := cred.Base64URLEncodePassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every way of sending the credential authenticates the same node
+	pr := &ProxyRouter{Logger: zap.NewNop(), m: metrics.NewMetricsRegistry("http_proxy")}
+	for source, set := range map[string]func(*http.Request){
+		credentialSourceBasic:  func(r *http.Request) { r.SetBasicAuth(username, password) },
+		credentialSourceBearer: func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+username+":"+password) },
+		credentialSourceHeader: func(r *http.Request) { r.Header.Set(rescueCredentialHeader, username+":"+password) },
+	} {
+		var authed *auth.Credential
+		handler := pr.authenticationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authed = requestCredential(r)
+		}))
+		r := httptest.NewRequest(http.MethodGet, "/eth/v1/node/version", nil)
+		set(r)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if authed == nil || authed.NodeAddress != common.BytesToAddress(nodeId) {
+			t.Fatalf("expected %s to authenticate %s, got %d %s", source, common.BytesToAddress(nodeId).String(), w.Code, w.Body.String())
+		}
+		if got := testutil.ToFloat64(pr.m.CounterVec("auth_ok_source", "source").WithLabelValues(source)); got != 1 {
+			t.Fatalf("expected 1 request authenticated with %s, got %v", source, got)
+		}
+	}
+
+	// A credential which doesn't verify is refused however it's sent
+	handler := pr.authenticationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("expected the request to be refused")
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/eth/v1/node/version", nil)
+	r.Header.Set("Authorization", "Bearer "+username+":"+password+"x")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+
+	// And so is a malformed header, even alongside a valid credential
+	r = httptest.NewRequest(http.MethodGet, "/eth/v1/node/version", nil)
+	r.SetBasicAuth(username, password)
+	r.Header.Set(rescueCredentialHeader, "someone:else")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+	if got := testutil.ToFloat64(pr.m.Counter("malformed_credentials")); got != 1 {
+		t.Fatalf("expected 1 malformed credential, got %v", got)
+	}
+}
+
+func TestUnregisteredNode(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+
+	issuer, err := auth.NewHMACVerifier([][]byte{[]byte("test")}, time.Minute*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeAddr := common.BytesToAddress(nodeId)
+	el := mocks.NewMockExecutionLayer(testSmoothingPool)
+	pr := &ProxyRouter{Logger: zap.NewNop(), EL: el, RequireRegisteredNode: true, m: metrics.NewMetricsRegistry("http_proxy")}
+
+	serve := func(operatorType auth.OperatorType) *httptest.ResponseRecorder {
+		t.Helper()
+		username, password, err := issuer.Create(nodeAddr, operatorType, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler := pr.authenticationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		r := httptest.NewRequest(http.MethodGet, "/eth/v1/node/version", nil)
+		r.SetBasicAuth(username, password)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// A Rocket Pool credential for a node which was never registered is refused with a distinct reason
+	w := serve(auth.OperatorRocketPool)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", w.Code)
+	}
+	var body struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Message != "node "+nodeAddr.String()+" is not registered with Rocket Pool" {
+		t.Fatalf("unexpected error message %q", body.Message)
+	}
+	if got := testutil.ToFloat64(pr.m.Counter("unregistered_node")); got != 1 {
+		t.Fatalf("expected 1 unregistered node, got %v", got)
+	}
+
+	// Solo nodes aren't registered, so aren't checked
+	if w := serve(auth.OperatorSolo); w.Code != http.StatusOK {
+		t.Fatalf("expected the solo credential to be accepted, got %d", w.Code)
+	}
+
+	// Once the node registers, its credential is accepted
+	el.AddNode(nodeAddr, false, common.Address{})
+	if w := serve(auth.OperatorRocketPool); w.Code != http.StatusOK {
+		t.Fatalf("expected the registered node's credential to be accepted, got %d %s", w.Code, w.Body.String())
+	}
+
+	// And without RequireRegisteredNode, nobody is checked
+	el.RemoveNode(nodeAddr)
+	pr.RequireRegisteredNode = false
+	if w := serve(auth.OperatorRocketPool); w.Code != http.StatusOK {
+		t.Fatalf("expected the credential to be accepted, got %d", w.Code)
+	}
+}
+
+func TestAuthFailureLimit(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+
+	pr := &ProxyRouter{Logger: zap.NewNop(), m: metrics.NewMetricsRegistry("http_proxy")}
+	pr.live.Store(&live{authFailureLimiter: newRateLimiter(0.1, 2)})
+	handler := pr.authenticationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	cred, err := cm.Create(time.Now(), nodeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password, err := cred.Base64URLEncodePassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(ip string, password string) int {
+		r := httptest.NewRequest(http.MethodPost, prepareBeaconProposerPath, nil)
+		r.RemoteAddr = ip + ":1234"
+		r.SetBasicAuth(cred.Base64URLEncodeUsername(), password)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Successful authentications don't count
+	for i := 0; i < 3; i++ {
+		if code := request("192.0.2.1", password); code != http.StatusOK {
+			t.Fatalf("expected the valid credential to be accepted, got %d", code)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := request("192.0.2.1", "wrong"); code != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", code)
+		}
+	}
+
+	// Once the burst of failures is used up, even a valid credential isn't checked
+	if code := request("192.0.2.1", password); code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", code)
+	}
+	if code := request("192.0.2.2", password); code != http.StatusOK {
+		t.Fatalf("expected another IP to be allowed, got %d", code)
+	}
+	if got := testutil.ToFloat64(pr.m.CounterVec("rate_limited", "bucket").WithLabelValues("auth_failure")); got != 1 {
+		t.Fatalf("expected 1 throttled request, got %v", got)
+	}
 }
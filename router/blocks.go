@@ -0,0 +1,400 @@
+package router
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/auth"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+	"go.uber.org/zap"
+)
+
+const (
+	publishBlockPath          = "/eth/v1/beacon/blocks"
+	publishBlockV2Path        = "/eth/v2/beacon/blocks"
+	publishBlindedBlockPath   = "/eth/v1/beacon/blinded_blocks"
+	publishBlindedBlockV2Path = "/eth/v2/beacon/blinded_blocks"
+)
+
+// The most bytes a published block may be, before and after decompression. Since Deneb, blocks are
+// published with their blobs, so this is well above MaxGuardedBodySize.
+const maxPublishedBlockSize = 64 << 20
+
+// SSZ layout of the containers a published block's fee recipient is read from. Variable-size fields
+// are 4-byte offsets in their container's fixed part, and the first offset is the size of the fixed part.
+const (
+	// SignedBlockContents, the unblinded body since Deneb: signed_block, kzg_proofs and blobs are all offsets
+	sszBlockContentsFixedSize = 3 * 4
+	// SignedBeaconBlock: a message offset and a 96 byte signature
+	sszSignedBlockFixedSize = 4 + 96
+	// BeaconBlock: slot, proposer_index, parent_root, state_root and a body offset
+	sszBlockFixedSize  = 8 + 8 + 32 + 32 + 4
+	sszProposerIndexAt = 8
+	sszBlockBodyAt     = 80
+	// BeaconBlockBody: randao_reveal, eth1_data and graffiti, followed by the proposer_slashings offset
+	sszBodyFirstOffsetAt = 96 + 72 + 32
+	// The execution_payload, or execution_payload_header, offset follows four more offsets and the sync_aggregate
+	sszBodyPayloadAt = sszBodyFirstOffsetAt + 5*4 + 64 + 96
+	// ExecutionPayload and ExecutionPayloadHeader both start with parent_hash, then fee_recipient
+	sszPayloadFeeRecipientAt = 32
+)
+
+// publishedBlock is what the proxy checks in a block being published
+type publishedBlock struct {
+	ProposerIndex string
+	// The fee recipient of the block's execution payload, or nil before Bellatrix, when blocks had none
+	FeeRecipient *common.Address
+}
+
+type jsonPayloadFeeRecipient struct {
+	FeeRecipient string `json:"fee_recipient"`
+}
+
+type jsonBeaconBlock struct {
+	ProposerIndex string `json:"proposer_index"`
+	Body          struct {
+		ExecutionPayload       *jsonPayloadFeeRecipient `json:"execution_payload"`
+		ExecutionPayloadHeader *jsonPayloadFeeRecipient `json:"execution_payload_header"`
+	} `json:"body"`
+}
+
+// jsonPublishedBlock is either a SignedBeaconBlock, or, for unblinded blocks since Deneb, SignedBlockContents
+type jsonPublishedBlock struct {
+	Message     *jsonBeaconBlock `json:"message"`
+	SignedBlock *struct {
+		Message *jsonBeaconBlock `json:"message"`
+	} `json:"signed_block"`
+}
+
+// decodePublishedBlock parses a blocks or blinded_blocks body, in whichever encoding the request uses.
+// The fork is worked out from the body, so it's the same for every fork since Bellatrix whether or not
+// the request has an Eth-Consensus-Version header.
+func decodePublishedBlock(r *http.Request, body io.Reader, blinded bool) (*publishedBlock, error) {
+	if isSSZ(r) {
+		buf, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		return decodePublishedBlockSSZ(buf)
+	}
+
+	var signed jsonPublishedBlock
+	if err := json.NewDecoder(body).Decode(&signed); err != nil {
+		return nil, err
+	}
+
+	block := signed.Message
+	if signed.SignedBlock != nil {
+		block = signed.SignedBlock.Message
+	}
+	if block == nil {
+		return nil, errors.New("body isn't a signed beacon block")
+	}
+	if _, err := strconv.ParseUint(block.ProposerIndex, 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid proposer_index %q", block.ProposerIndex)
+	}
+
+	out := &publishedBlock{ProposerIndex: block.ProposerIndex}
+	payload := block.Body.ExecutionPayload
+	if blinded {
+		payload = block.Body.ExecutionPayloadHeader
+	}
+	if payload == nil {
+		return out, nil
+	}
+	if !common.IsHexAddress(payload.FeeRecipient) {
+		return nil, fmt.Errorf("invalid fee_recipient %q", payload.FeeRecipient)
+	}
+	feeRecipient := common.HexToAddress(payload.FeeRecipient)
+	out.FeeRecipient = &feeRecipient
+	return out, nil
+}
+
+// sszOffset reads the offset at position at of container, checking it's within the container
+func sszOffset(container []byte, at int) (int, error) {
+	if len(container) < at+4 {
+		return 0, fmt.Errorf("ssz container of %d bytes is too short for an offset at %d", len(container), at)
+	}
+
+	offset := int(binary.LittleEndian.Uint32(container[at : at+4]))
+	if offset > len(container) {
+		return 0, fmt.Errorf("ssz offset %d is beyond the end of its %d byte container", offset, len(container))
+	}
+	return offset, nil
+}
+
+// decodePublishedBlockSSZ parses an SSZ encoded SignedBeaconBlock, SignedBlindedBeaconBlock or SignedBlockContents.
+// Only the fields up to the execution payload's fee recipient are read. Forks add fields to the end of the block
+// body's fixed part, so the size of that tells whether there's an execution payload.
+func decodePublishedBlockSSZ(buf []byte) (*publishedBlock, error) {
+	first, err := sszOffset(buf, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unwrap SignedBlockContents, whose signed_block is followed by kzg_proofs
+	if first == sszBlockContentsFixedSize {
+		end, err := sszOffset(buf, 4)
+		if err != nil {
+			return nil, err
+		}
+		if end < first {
+			return nil, errors.New("ssz block contents have out of order offsets")
+		}
+		buf = buf[first:end]
+		if first, err = sszOffset(buf, 0); err != nil {
+			return nil, err
+		}
+	}
+	if first != sszSignedBlockFixedSize {
+		return nil, errors.New("ssz body isn't a signed beacon block")
+	}
+
+	message := buf[sszSignedBlockFixedSize:]
+	if len(message) < sszBlockFixedSize {
+		return nil, fmt.Errorf("ssz beacon block of %d bytes is too short", len(message))
+	}
+	out := &publishedBlock{
+		ProposerIndex: strconv.FormatUint(binary.LittleEndian.Uint64(message[sszProposerIndexAt:sszProposerIndexAt+8]), 10),
+	}
+
+	if bodyStart, err := sszOffset(message, sszBlockBodyAt); err != nil || bodyStart != sszBlockFixedSize {
+		return nil, errors.New("ssz beacon block has an invalid body offset")
+	}
+	body := message[sszBlockFixedSize:]
+	fixedSize, err := sszOffset(body, sszBodyFirstOffsetAt)
+	if err != nil {
+		return nil, err
+	}
+	if fixedSize < sszBodyPayloadAt+4 {
+		// Before Bellatrix
+		return out, nil
+	}
+
+	payloadStart, err := sszOffset(body, sszBodyPayloadAt)
+	if err != nil {
+		return nil, err
+	}
+	payloadEnd := len(body)
+	if fixedSize >= sszBodyPayloadAt+8 {
+		// Since Capella, bls_to_execution_changes follows the payload
+		if payloadEnd, err = sszOffset(body, sszBodyPayloadAt+4); err != nil {
+			return nil, err
+		}
+	}
+	if payloadStart < fixedSize || payloadEnd < payloadStart {
+		return nil, errors.New("ssz beacon block body has out of order offsets")
+	}
+
+	payload := body[payloadStart:payloadEnd]
+	if len(payload) < sszPayloadFeeRecipientAt+common.AddressLength {
+		return nil, fmt.Errorf("ssz execution payload of %d bytes is too short", len(payload))
+	}
+	feeRecipient := common.BytesToAddress(payload[sszPayloadFeeRecipientAt : sszPayloadFeeRecipientAt+common.AddressLength])
+	out.FeeRecipient = &feeRecipient
+	return out, nil
+}
+
+// publishBlock checks the fee recipient of a block being published, so validator clients can't propose
+// locally built blocks which pay someone other than the expected fee recipient.
+//
+// Blinded blocks' payloads are built by a relay's builder, whose coinbase is usually its own address, paying
+// the proposer with a transaction instead. That payment goes to the fee recipient in the validator's
+// register_validator request, which was already checked, so unless CheckBlindedBlocks is set,
+// blinded blocks with unexpected fee recipients are only counted.
+func (pr *ProxyRouter) publishBlock(blinded bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := pr.logger(r)
+		pr.m.CounterVec("publish_block", "blinded").WithLabelValues(strconv.FormatBool(blinded)).Inc()
+		if pr.handleIfWarming(w, r) || pr.rejectIfStale(w, r) {
+			return
+		}
+
+		// Clone the request body so it can still be proxied
+		r.Body = http.MaxBytesReader(w, r.Body, maxPublishedBlockSize)
+		buf, err := cloneRequestBody(r)
+		if isBodyTooLarge(err) {
+			pr.rejectBodyTooLarge(w, r)
+			return
+		}
+		if err != nil {
+			logger.Warn("Error cloning block publication request body", zap.Error(err))
+			writeInternalError(w, r)
+			return
+		}
+
+		body, err := decodeContent(r, buf)
+		if err != nil {
+			logger.Warn("Unable to decode block publication request body", zap.Error(err))
+			writeJSONError(w, r, http.StatusUnsupportedMediaType, err.Error())
+			return
+		}
+
+		// Parse the JSON or SSZ body of the request. Either way, the original bytes are proxied.
+		block, err := decodePublishedBlock(r, http.MaxBytesReader(w, io.NopCloser(body), maxPublishedBlockSize), blinded)
+		if isBodyTooLarge(err) {
+			pr.rejectBodyTooLarge(w, r)
+			return
+		}
+		if err != nil {
+			logger.Warn("Malformed block publication request", zap.Error(err))
+			writeJSONError(w, r, http.StatusBadRequest, "malformed block: "+err.Error())
+			return
+		}
+		guardedDecisionFrom(r.Context()).setValidators(1)
+
+		// Blocks from before Bellatrix have no fee recipient to check
+		if block.FeeRecipient == nil {
+			guardedDecisionFrom(r.Context()).accept()
+			pr.proxy.ServeHTTP(w, r)
+			return
+		}
+
+		pubkeyMap, err := tracedValidatorPubkeys(r.Context(), pr.CL, []string{block.ProposerIndex})
+		if pr.abandoned(w, r, "lookup") {
+			return
+		}
+		if err != nil {
+			logger.Error("Error while querying CL for validator pubkeys", zap.Error(err))
+			writeInternalError(w, r)
+			return
+		}
+		pubkey, found := pubkeyMap[block.ProposerIndex]
+		if !found {
+			countValidationOutcome(pr.m, outcomeRejectedUnknownValidator)
+			logger.Warn("Pubkey for index not found in response from cl.", zap.String("requested index", block.ProposerIndex))
+			writeJSONError(w, r, http.StatusBadRequest, "unknown proposer index "+block.ProposerIndex)
+			return
+		}
+
+		if !pr.checkBlockFeeRecipient(w, r, pubkey, *block.FeeRecipient, blinded) {
+			return
+		}
+
+		guardedDecisionFrom(r.Context()).accept()
+		pr.proxy.ServeHTTP(w, r)
+	}
+}
+
+// checkBlockFeeRecipient checks the fee recipient of a block proposed by pubkey, like prepare_beacon_proposer's.
+// Validators which aren't minipools, and which the credential doesn't otherwise restrict, may use any fee recipient,
+// like they can in register_validator. If the block is refused, it replies and returns false.
+func (pr *ProxyRouter) checkBlockFeeRecipient(w http.ResponseWriter, r *http.Request, pubkey rptypes.ValidatorPubkey, feeRecipient common.Address, blinded bool) bool {
+	logger := pr.logger(r)
+	authedNode, ok := r.Context().Value(prContextKey("node")).([]byte)
+	if !ok {
+		logger.Warn("Unable to retrieve node address cached on request context")
+		writeInternalError(w, r)
+		return false
+	}
+	authedNodeAddr := common.BytesToAddress(authedNode)
+	credential := requestCredential(r)
+	if !subjectAllowsValidator(credential, pubkey) {
+		pr.rejectOtherValidator(w, r, pubkey, nil)
+		return false
+	}
+	submitted := feeRecipient.String()
+	matches := func(expected common.Address) bool {
+		return expected == feeRecipient
+	}
+
+	// wrongFeeRecipient refuses the block, unless it's blinded and blinded blocks are only counted
+	wrongFeeRecipient := func(reject func()) bool {
+		if blinded && !pr.CheckBlindedBlocks {
+			pr.m.Counter("publish_blinded_block_builder_fee_recipient").Inc()
+			logger.Info("Proxying blinded block whose fee recipient is presumably its builder's",
+				zap.String("key", pubkey.String()), zap.String("got", submitted))
+			return true
+		}
+		reject()
+		return false
+	}
+
+	if allowed := requestFeeRecipients(r); len(allowed) > 0 {
+		outcome := allowedFeeRecipientOutcome(allowed, matches)
+		countValidationOutcome(pr.m, outcome)
+		if outcome != outcomeAccepted {
+			return wrongFeeRecipient(func() {
+				pr.rejectUnallowedFeeRecipient(w, r, pubkey, submitted, nil)
+			})
+		}
+		pr.m.Counter("publish_block_correct_fee_recipient").Inc()
+		return true
+	}
+
+	if requestOperatorType(r) == auth.OperatorSolo {
+		solo := tracedValidatorWithdrawalAddresses(r.Context(), pr.EL, []rptypes.ValidatorPubkey{pubkey})
+		withdrawalAddress, outcome, err := soloFeeRecipient(solo, pubkey, submitted)
+		if err != nil {
+			logger.Error("Unable to check the withdrawal address of a solo validator", zap.String("key", pubkey.String()), zap.Error(err))
+			writeInternalError(w, r)
+			return false
+		}
+		countSoloValidationOutcome(pr.m, outcome)
+		switch outcome {
+		case outcomeRejectedUnknownValidator:
+			pr.rejectSoloFeeRecipient(w, r, authedNodeAddr, pubkey, submitted, withdrawalAddress, outcome, nil)
+			return false
+		case outcomeRejectedWrongFeeRecipient:
+			return wrongFeeRecipient(func() {
+				pr.rejectSoloFeeRecipient(w, r, authedNodeAddr, pubkey, submitted, withdrawalAddress, outcome, nil)
+			})
+		}
+		pr.m.Counter("publish_block_correct_fee_recipient").Inc()
+		return true
+	}
+
+	expected, err := tracedValidatorFeeRecipient(r.Context(), pr.FeeRecipients, pubkey, subjectNodeFilter(credential))
+	if lookupFailed(err) {
+		countValidationOutcome(pr.m, outcomeRejectedCacheInconsistent)
+		pr.auditCacheInconsistency(r, authedNodeAddr, err)
+		logger.Error("Unable to determine expected fee recipient", zap.String("key", pubkey.String()), zap.Error(err))
+		writeJSONError(w, r, http.StatusForbidden, "unable to determine the expected fee recipient for validator "+pubkey.String())
+		return false
+	}
+	outcome, allowlisted := allowlistedOutcome(pr.m, feeRecipientOutcome(expected, err, true, matches), pr.EL.AllowedFeeRecipients(), matches)
+	if allowlisted != nil {
+		newFeeRecipientAllowlisted(r.URL.Path, authedNodeAddr, pubkey, submitted, expected, allowlisted).log(pr.auditLogger(r))
+	}
+	outcome = unenforcedStatusOutcome(pr.m, outcome, expected, pr.UnenforcedStatuses)
+	countValidationOutcome(pr.m, outcome)
+	switch outcome {
+	case outcomeRejectedNodeMismatch:
+		pr.m.Counter("publish_block_unowned").Inc()
+		pr.warnRejection(r, pubkey, "Block proposed by a minipool owned by another node", zap.String("key", pubkey.String()))
+		writeJSONError(w, r, http.StatusForbidden, "validator "+pubkey.String()+" is not one of your minipools")
+		return false
+	case outcomeRejectedWrongFeeRecipient:
+		return wrongFeeRecipient(func() {
+			pr.m.Counter("publish_block_incorrect_fee_recipient").Inc()
+			newFeeRecipientRejection(r.URL.Path, authedNodeAddr, pubkey, submitted, expected).log(pr.auditLogger(r))
+			pr.warnRejection(r, pubkey, "Block published with unexpected fee recipient", zap.String("key", pubkey.String()),
+				zap.String("expected", expected.Expected.String()), zap.String("got", submitted))
+			writeJSONError(w, r, http.StatusConflict, "validator "+pubkey.String()+" must use fee recipient "+expected.Expected.String())
+		})
+	}
+
+	if errors.Is(err, feerecipient.ErrNotMinipool) {
+		pr.m.Counter("publish_block_not_minipool").Inc()
+		return true
+	}
+	pr.m.Counter("publish_block_correct_fee_recipient").Inc()
+	return true
+}
+
+// isGuardedPath returns whether path is one of the guarded endpoints, which are rate limited per credential
+func isGuardedPath(path string) bool {
+	switch path {
+	case prepareBeaconProposerPath, registerValidatorPath,
+		publishBlockPath, publishBlockV2Path, publishBlindedBlockPath, publishBlindedBlockV2Path:
+		return true
+	}
+	return false
+}
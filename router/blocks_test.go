@@ -0,0 +1,390 @@
+package router
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/auth"
+	apiv1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec/altair"
+	"github.com/attestantio/go-eth2-client/spec/bellatrix"
+	"github.com/attestantio/go-eth2-client/spec/capella"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+type testFork int
+
+const (
+	forkPhase0 testFork = iota
+	forkAltair
+	forkBellatrix
+	forkCapella
+	forkDeneb
+	forkElectra
+)
+
+var testForks = map[testFork]string{
+	forkPhase0:    "phase0",
+	forkAltair:    "altair",
+	forkBellatrix: "bellatrix",
+	forkCapella:   "capella",
+	forkDeneb:     "deneb",
+	forkElectra:   "electra",
+}
+
+func zeroHex(n int) string {
+	return "0x" + strings.Repeat("00", n)
+}
+
+// jsonTestBlock is a fixture of the json body a validator client publishes a block of fork with. Lists are empty,
+// so the fields before and after the execution payload are all present. Unblinded blocks since Deneb are
+// published as block contents, with their blobs.
+func jsonTestBlock(fork testFork, blinded bool, proposerIndex uint64, feeRecipient common.Address) []byte {
+	body := map[string]any{
+		"randao_reveal":      zeroHex(96),
+		"eth1_data":          map[string]any{"deposit_root": zeroHex(32), "deposit_count": "0", "block_hash": zeroHex(32)},
+		"graffiti":           zeroHex(32),
+		"proposer_slashings": []any{},
+		"attester_slashings": []any{},
+		"attestations":       []any{},
+		"deposits":           []any{},
+		"voluntary_exits":    []any{},
+	}
+	if fork >= forkAltair {
+		body["sync_aggregate"] = map[string]any{"sync_committee_bits": zeroHex(64), "sync_committee_signature": zeroHex(96)}
+	}
+	if fork >= forkBellatrix {
+		payload := map[string]any{
+			"parent_hash":      zeroHex(32),
+			"fee_recipient":    feeRecipient.String(),
+			"state_root":       zeroHex(32),
+			"receipts_root":    zeroHex(32),
+			"logs_bloom":       zeroHex(256),
+			"prev_randao":      zeroHex(32),
+			"block_number":     "0",
+			"gas_limit":        "0",
+			"gas_used":         "0",
+			"timestamp":        "0",
+			"extra_data":       "0x",
+			"base_fee_per_gas": "0",
+			"block_hash":       zeroHex(32),
+		}
+		if blinded {
+			payload["transactions_root"] = zeroHex(32)
+		} else {
+			payload["transactions"] = []any{}
+		}
+		if fork >= forkCapella {
+			if blinded {
+				payload["withdrawals_root"] = zeroHex(32)
+			} else {
+				payload["withdrawals"] = []any{}
+			}
+			body["bls_to_execution_changes"] = []any{}
+		}
+		if fork >= forkDeneb {
+			payload["blob_gas_used"] = "0"
+			payload["excess_blob_gas"] = "0"
+			body["blob_kzg_commitments"] = []any{}
+		}
+		if fork >= forkElectra {
+			body["execution_requests"] = map[string]any{"deposits": []any{}, "withdrawals": []any{}, "consolidations": []any{}}
+		}
+
+		if blinded {
+			body["execution_payload_header"] = payload
+		} else {
+			body["execution_payload"] = payload
+		}
+	}
+
+	var out any = map[string]any{
+		"message": map[string]any{
+			"slot":           "1",
+			"proposer_index": fmt.Sprint(proposerIndex),
+			"parent_root":    zeroHex(32),
+			"state_root":     zeroHex(32),
+			"body":           body,
+		},
+		"signature": zeroHex(96),
+	}
+	if fork >= forkDeneb && !blinded {
+		out = map[string]any{"signed_block": out, "kzg_proofs": []any{zeroHex(48)}, "blobs": []any{}}
+	}
+
+	buf, err := json.Marshal(out)
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+func appendOffset(buf []byte, offset int) []byte {
+	return binary.LittleEndian.AppendUint32(buf, uint32(offset))
+}
+
+// sszTestBlock is the SSZ encoding of jsonTestBlock
+func sszTestBlock(fork testFork, blinded bool, proposerIndex uint64, feeRecipient common.Address) []byte {
+	var payload []byte
+	if fork >= forkBellatrix {
+		// parent_hash, fee_recipient, state_root, receipts_root, logs_bloom and prev_randao, then
+		// block_number, gas_limit, gas_used and timestamp
+		fixedSize := 32 + 20 + 32 + 32 + 256 + 32 + 4*8 + 4 + 32 + 32
+		if blinded {
+			fixedSize += 32
+			if fork >= forkCapella {
+				fixedSize += 32
+			}
+		} else {
+			fixedSize += 4
+			if fork >= forkCapella {
+				fixedSize += 4
+			}
+		}
+		if fork >= forkDeneb {
+			fixedSize += 2 * 8
+		}
+
+		payload = make([]byte, 0, fixedSize)
+		payload = append(payload, make([]byte, 32)...)
+		payload = append(payload, feeRecipient.Bytes()...)
+		payload = append(payload, make([]byte, 32+32+256+32+4*8)...)
+		// extra_data, base_fee_per_gas and block_hash
+		payload = appendOffset(payload, fixedSize)
+		payload = append(payload, make([]byte, 32+32)...)
+		if blinded {
+			payload = append(payload, make([]byte, fixedSize-len(payload))...)
+		} else {
+			// transactions and withdrawals are empty, so both start at the end of the fixed part
+			payload = appendOffset(payload, fixedSize)
+			if fork >= forkCapella {
+				payload = appendOffset(payload, fixedSize)
+			}
+			payload = append(payload, make([]byte, fixedSize-len(payload))...)
+		}
+	}
+
+	bodyFixedSize := 96 + 72 + 32 + 5*4
+	if fork >= forkAltair {
+		bodyFixedSize += 64 + 96
+	}
+	for f := forkBellatrix; f <= forkElectra; f++ {
+		if fork >= f {
+			bodyFixedSize += 4
+		}
+	}
+
+	body := make([]byte, 96+72+32)
+	// The five operation lists are empty
+	for i := 0; i < 5; i++ {
+		body = appendOffset(body, bodyFixedSize)
+	}
+	if fork >= forkAltair {
+		body = append(body, make([]byte, 64+96)...)
+	}
+	if fork >= forkBellatrix {
+		body = appendOffset(body, bodyFixedSize)
+	}
+	// bls_to_execution_changes, blob_kzg_commitments and execution_requests follow the payload
+	for f := forkCapella; f <= forkElectra; f++ {
+		if fork >= f {
+			body = appendOffset(body, bodyFixedSize+len(payload))
+		}
+	}
+	body = append(body, payload...)
+	if fork >= forkElectra {
+		// deposits, withdrawals and consolidations are empty
+		body = appendOffset(appendOffset(appendOffset(body, 12), 12), 12)
+	}
+
+	block := binary.LittleEndian.AppendUint64(nil, 1)
+	block = binary.LittleEndian.AppendUint64(block, proposerIndex)
+	block = append(block, make([]byte, 32+32)...)
+	block = appendOffset(block, len(block)+4)
+	block = append(block, body...)
+
+	signed := appendOffset(nil, 4+96)
+	signed = append(signed, make([]byte, 96)...)
+	signed = append(signed, block...)
+	if fork < forkDeneb || blinded {
+		return signed
+	}
+
+	// Block contents with one kzg proof and no blobs
+	contents := appendOffset(nil, 12)
+	contents = appendOffset(contents, 12+len(signed))
+	contents = appendOffset(contents, 12+len(signed)+48)
+	contents = append(contents, signed...)
+	return append(contents, make([]byte, 48)...)
+}
+
+type sszBlock interface {
+	json.Unmarshaler
+	MarshalSSZ() ([]byte, error)
+}
+
+// TestBlockFixtures checks the fixtures against go-eth2-client, for the forks it knows: the json must decode
+// into its types, and encode to the same SSZ.
+func TestBlockFixtures(t *testing.T) {
+	for _, tc := range []struct {
+		fork    testFork
+		blinded bool
+		block   sszBlock
+	}{
+		{forkPhase0, false, &phase0.SignedBeaconBlock{}},
+		{forkAltair, false, &altair.SignedBeaconBlock{}},
+		{forkBellatrix, false, &bellatrix.SignedBeaconBlock{}},
+		{forkBellatrix, true, &apiv1.SignedBlindedBeaconBlock{}},
+		{forkCapella, false, &capella.SignedBeaconBlock{}},
+	} {
+		name := fmt.Sprintf("%s blinded=%v", testForks[tc.fork], tc.blinded)
+		if err := tc.block.UnmarshalJSON(jsonTestBlock(tc.fork, tc.blinded, 7, testFeeDistributor)); err != nil {
+			t.Fatalf("%s: the json fixture isn't a block: %v", name, err)
+		}
+		ssz, err := tc.block.MarshalSSZ()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(ssz, sszTestBlock(tc.fork, tc.blinded, 7, testFeeDistributor)) {
+			t.Fatalf("%s: the ssz fixture doesn't match the json one", name)
+		}
+	}
+}
+
+func TestDecodePublishedBlock(t *testing.T) {
+	for fork, name := range testForks {
+		for _, blinded := range []bool{false, true} {
+			if blinded && fork < forkBellatrix {
+				continue
+			}
+
+			for _, ssz := range []bool{false, true} {
+				r := httptest.NewRequest(http.MethodPost, publishBlockV2Path, nil)
+				body := jsonTestBlock(fork, blinded, 7, testFeeDistributor)
+				if ssz {
+					r.Header.Set("Content-Type", "application/octet-stream")
+					body = sszTestBlock(fork, blinded, 7, testFeeDistributor)
+				}
+
+				block, err := decodePublishedBlock(r, bytes.NewReader(body), blinded)
+				if err != nil {
+					t.Fatalf("%s blinded=%v ssz=%v: %v", name, blinded, ssz, err)
+				}
+				if block.ProposerIndex != "7" {
+					t.Fatalf("%s blinded=%v ssz=%v: expected proposer 7, got %s", name, blinded, ssz, block.ProposerIndex)
+				}
+
+				// Blocks only have fee recipients since Bellatrix
+				if fork < forkBellatrix {
+					if block.FeeRecipient != nil {
+						t.Fatalf("%s ssz=%v: expected no fee recipient, got %s", name, ssz, block.FeeRecipient)
+					}
+					continue
+				}
+				if block.FeeRecipient == nil || *block.FeeRecipient != testFeeDistributor {
+					t.Fatalf("%s blinded=%v ssz=%v: expected fee recipient %s, got %v", name, blinded, ssz, testFeeDistributor, block.FeeRecipient)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodePublishedBlockMalformed(t *testing.T) {
+	valid := sszTestBlock(forkDeneb, false, 7, testFeeDistributor)
+
+	for name, body := range map[string][]byte{
+		"empty":             {},
+		"truncated":         valid[:len(valid)/2],
+		"not a block":       bytes.Repeat([]byte{0xff}, 256),
+		"offset past end":   append(appendOffset(nil, 12), bytes.Repeat([]byte{0xff}, 8)...),
+		"truncated payload": sszTestBlock(forkCapella, false, 7, testFeeDistributor)[:4+96+84+400],
+	} {
+		r := httptest.NewRequest(http.MethodPost, publishBlockPath, nil)
+		r.Header.Set("Content-Type", "application/octet-stream")
+		if _, err := decodePublishedBlock(r, bytes.NewReader(body), false); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+
+	for name, body := range map[string]string{
+		"not json":              "{",
+		"no message":            "{}",
+		"invalid index":         `{"message": {"proposer_index": "x"}}`,
+		"invalid fee recipient": `{"message": {"proposer_index": "1", "body": {"execution_payload": {"fee_recipient": "0x1234"}}}}`,
+	} {
+		r := httptest.NewRequest(http.MethodPost, publishBlockPath, nil)
+		if _, err := decodePublishedBlock(r, strings.NewReader(body), false); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestCheckBlockFeeRecipient(t *testing.T) {
+	for _, tc := range []struct {
+		name               string
+		pubkey             rptypes.ValidatorPubkey
+		feeRecipient       common.Address
+		operatorType       auth.OperatorType
+		blinded            bool
+		checkBlindedBlocks bool
+		code               int
+	}{
+		{"fee distributor", testValidatorPubkey(0x01), testFeeDistributor, auth.OperatorRocketPool, false, false, http.StatusOK},
+		{"wrong fee recipient", testValidatorPubkey(0x01), testWrongRecipient, auth.OperatorRocketPool, false, false, http.StatusConflict},
+		{"blinded block from a builder", testValidatorPubkey(0x01), testWrongRecipient, auth.OperatorRocketPool, true, false, http.StatusOK},
+		{"checked blinded block", testValidatorPubkey(0x01), testWrongRecipient, auth.OperatorRocketPool, true, true, http.StatusConflict},
+		{"checked blinded block with the fee distributor", testValidatorPubkey(0x01), testFeeDistributor, auth.OperatorRocketPool, true, true, http.StatusOK},
+		{"minipool of another node", testValidatorPubkey(0x02), testSmoothingPool, auth.OperatorRocketPool, false, false, http.StatusForbidden},
+		{"inconsistent cache", testValidatorPubkey(0x03), testFeeDistributor, auth.OperatorRocketPool, false, false, http.StatusForbidden},
+		{"not a minipool", testValidatorPubkey(0x04), testWrongRecipient, auth.OperatorRocketPool, false, false, http.StatusOK},
+		{"solo validator using its withdrawal address", testValidatorPubkey(0x04), testWithdrawalAddr, auth.OperatorSolo, false, false, http.StatusOK},
+		{"solo validator with the wrong fee recipient", testValidatorPubkey(0x04), testWrongRecipient, auth.OperatorSolo, false, false, http.StatusConflict},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testMetrics(t)
+			bn := newFakeBeaconNode(t, "bn")
+			pr, _ := guardedRouter(t, &ProxyRouter{CheckBlindedBlocks: tc.checkBlindedBlocks}, bn)
+
+			w := httptest.NewRecorder()
+			r := guardedRequest(publishBlockV2Path, "", tc.operatorType)
+			accepted := pr.checkBlockFeeRecipient(w, r, tc.pubkey, tc.feeRecipient, tc.blinded)
+			if accepted != (tc.code == http.StatusOK) || w.Code != tc.code {
+				t.Fatalf("expected %d, got %v %d %s", tc.code, accepted, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestPublishBlock(t *testing.T) {
+	testMetrics(t)
+	bn := newFakeBeaconNode(t, "bn")
+	pr, _ := guardedRouter(t, &ProxyRouter{}, bn)
+
+	// Blocks from before Bellatrix have no fee recipient, so they're proxied without looking up their proposer
+	w := httptest.NewRecorder()
+	pr.publishBlock(false)(w, guardedRequest(publishBlockPath, string(jsonTestBlock(forkAltair, false, 7, common.Address{})), auth.OperatorRocketPool))
+	if w.Code != http.StatusOK || bn.requests.Load() != 1 {
+		t.Fatalf("expected the block to be proxied, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r := guardedRequest(publishBlockPath, "not a block", auth.OperatorRocketPool)
+	r.Header.Set("Content-Type", "application/octet-stream")
+	pr.publishBlock(false)(w, r)
+	if w.Code != http.StatusBadRequest || bn.requests.Load() != 1 {
+		t.Fatalf("expected a malformed block to be refused, got %d", w.Code)
+	}
+
+	if got := testutil.ToFloat64(pr.m.CounterVec("publish_block", "blinded").WithLabelValues("false")); got != 2 {
+		t.Fatalf("expected 2 published blocks, got %v", got)
+	}
+}
@@ -0,0 +1,151 @@
+package router
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var errCircuitOpen = errors.New("circuit breaker is open, the beacon node is failing")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerHalfOpen:
+		return "half-open"
+	case breakerOpen:
+		return "open"
+	}
+	return "unknown"
+}
+
+// circuitBreaker stops requests being sent to an upstream after threshold consecutive failures.
+// Once cooldown has passed, a single trial request is let through, which closes the breaker if
+// it succeeds, and opens it again if it fails.
+type circuitBreaker struct {
+	sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	// Called with the lock held whenever the state changes
+	onChange func(from, to breakerState)
+
+	state    breakerState
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// newCircuitBreaker creates a circuitBreaker, or returns nil, which never trips, if threshold is 0
+func newCircuitBreaker(threshold int, cooldown time.Duration, onChange func(from, to breakerState)) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		onChange:  onChange,
+	}
+}
+
+func (b *circuitBreaker) setState(state breakerState) {
+	if b.state == state {
+		return
+	}
+
+	from := b.state
+	b.state = state
+	if b.onChange != nil {
+		b.onChange(from, state)
+	}
+}
+
+// available reports whether allow would let a request through, without taking the trial request
+func (b *circuitBreaker) available(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		return now.Sub(b.openedAt) >= b.cooldown
+	case breakerHalfOpen:
+		return !b.trial
+	}
+	return true
+}
+
+// allow reports whether a request may be sent. If it returns true, the outcome must be passed
+// to record, or release called if there wasn't one.
+func (b *circuitBreaker) allow(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		b.trial = true
+		return true
+	case breakerHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	}
+	return true
+}
+
+// record counts the outcome of a request let through by allow
+func (b *circuitBreaker) record(success bool, now time.Time) {
+	if b == nil {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.trial = false
+	if success {
+		b.failures = 0
+		b.setState(breakerClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = now
+		b.setState(breakerOpen)
+	}
+}
+
+// release gives up a request let through by allow without counting it, eg, because the client went away
+func (b *circuitBreaker) release() {
+	if b == nil {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.trial = false
+}
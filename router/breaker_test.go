@@ -0,0 +1,228 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var transitions []breakerState
+	b := newCircuitBreaker(3, time.Minute, func(from, to breakerState) {
+		transitions = append(transitions, to)
+	})
+	now := time.Now()
+
+	// Failures below the threshold, or interrupted by a success, don't trip it
+	for i := 0; i < 2; i++ {
+		b.allow(now)
+		b.record(false, now)
+	}
+	b.allow(now)
+	b.record(true, now)
+	for i := 0; i < 2; i++ {
+		b.allow(now)
+		b.record(false, now)
+	}
+	if !b.allow(now) {
+		t.Fatal("expected the breaker to be closed")
+	}
+
+	// The third consecutive failure does
+	b.record(false, now)
+	if b.allow(now) || b.available(now) {
+		t.Fatal("expected the breaker to be open")
+	}
+
+	// After the cooldown, only one trial request is let through
+	later := now.Add(time.Minute)
+	if !b.available(later) || !b.allow(later) {
+		t.Fatal("expected a trial request after the cooldown")
+	}
+	if b.allow(later) {
+		t.Fatal("expected only one trial request")
+	}
+
+	// A failed trial opens it again, and a successful one closes it
+	b.record(false, later)
+	if b.allow(later) {
+		t.Fatal("expected the failed trial to open the breaker")
+	}
+	evenLater := later.Add(time.Minute)
+	b.allow(evenLater)
+	b.record(true, evenLater)
+	if !b.allow(evenLater) || !b.allow(evenLater) {
+		t.Fatal("expected the successful trial to close the breaker")
+	}
+
+	expected := []breakerState{breakerOpen, breakerHalfOpen, breakerOpen, breakerHalfOpen, breakerClosed}
+	if len(transitions) != len(expected) {
+		t.Fatalf("expected transitions %v, got %v", expected, transitions)
+	}
+	for i := range expected {
+		if transitions[i] != expected[i] {
+			t.Fatalf("expected transitions %v, got %v", expected, transitions)
+		}
+	}
+}
+
+func TestCircuitBreakerRelease(t *testing.T) {
+	b := newCircuitBreaker(1, time.Minute, nil)
+	now := time.Now()
+	b.allow(now)
+	b.record(false, now)
+
+	// A trial which is abandoned frees up the next one
+	later := now.Add(time.Minute)
+	b.allow(later)
+	b.release()
+	if !b.allow(later) {
+		t.Fatal("expected another trial after the first was released")
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	b := newCircuitBreaker(0, time.Minute, nil)
+	if b != nil {
+		t.Fatal("expected a 0 threshold to disable the breaker")
+	}
+	for i := 0; i < 10; i++ {
+		b.record(false, time.Now())
+	}
+	if !b.allow(time.Now()) {
+		t.Fatal("expected a disabled breaker to allow every request")
+	}
+}
+
+// newHangingBeaconNode never responds, until the test ends
+func newHangingBeaconNode(t *testing.T) (*url.URL, *atomic.Int64) {
+	release := make(chan struct{})
+	requests := &atomic.Int64{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(func() {
+		close(release)
+		server.Close()
+	})
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u, requests
+}
+
+func TestHangingUpstreamTimeout(t *testing.T) {
+	testMetrics(t)
+	u, _ := newHangingBeaconNode(t)
+	p := newUpstreamPool([]*url.URL{u}, UpstreamTimeouts{ResponseHeader: 100 * time.Millisecond}, CircuitBreakerConfig{},
+		zap.NewNop(), metrics.NewMetricsRegistry("http_proxy"))
+
+	start := time.Now()
+	w := proxyRequest(t, p, http.MethodGet)
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502, got %d", w.Code)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected the request to time out, took %v", elapsed)
+	}
+}
+
+func TestHangingUpstreamBodyTimeout(t *testing.T) {
+	testMetrics(t)
+
+	// Sends headers, then never finishes the body
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := newUpstreamPool([]*url.URL{u}, UpstreamTimeouts{Request: 100 * time.Millisecond}, CircuitBreakerConfig{},
+		zap.NewNop(), metrics.NewMetricsRegistry("http_proxy"))
+
+	done := make(chan struct{})
+	go func() {
+		proxyRequest(t, p, http.MethodGet)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the request timeout to cut off the response body")
+	}
+}
+
+func TestCircuitBreakerFailsFast(t *testing.T) {
+	testMetrics(t)
+	u, requests := newHangingBeaconNode(t)
+	p := newUpstreamPool([]*url.URL{u}, UpstreamTimeouts{ResponseHeader: 50 * time.Millisecond}, CircuitBreakerConfig{Threshold: 2, Cooldown: time.Minute},
+		zap.NewNop(), metrics.NewMetricsRegistry("http_proxy"))
+
+	for i := 0; i < 2; i++ {
+		proxyRequest(t, p, http.MethodGet)
+	}
+
+	// Once open, requests aren't queued on the hung beacon node
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		if w := proxyRequest(t, p, http.MethodGet); w.Code != http.StatusBadGateway {
+			t.Fatalf("expected 502, got %d", w.Code)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("expected the open breaker to fail fast, took %v", elapsed)
+	}
+	if requests.Load() != 2 {
+		t.Fatalf("expected 2 upstream requests, got %d", requests.Load())
+	}
+
+	if got := testutil.ToFloat64(p.m.GaugeVec("upstream_circuit_state", "upstream").WithLabelValues(u.Host)); got != float64(breakerOpen) {
+		t.Fatalf("expected the breaker state metric to be open, got %v", got)
+	}
+	if got := testutil.ToFloat64(p.m.Counter("upstream_circuit_rejected")); got != 10 {
+		t.Fatalf("expected 10 rejected requests, got %v", got)
+	}
+}
+
+func TestCircuitBreakerFailsOver(t *testing.T) {
+	testMetrics(t)
+	hung, _ := newHangingBeaconNode(t)
+	b := newFakeBeaconNode(t, "b")
+	p := newUpstreamPool([]*url.URL{hung, b.url}, UpstreamTimeouts{ResponseHeader: 50 * time.Millisecond}, CircuitBreakerConfig{Threshold: 1, Cooldown: time.Minute},
+		zap.NewNop(), metrics.NewMetricsRegistry("http_proxy"))
+
+	// POSTs aren't retried, so the first one to the hung beacon node fails, and opens its breaker
+	for i := 0; i < 2; i++ {
+		proxyRequest(t, p, http.MethodPost)
+	}
+
+	for i := 0; i < 4; i++ {
+		w := proxyRequest(t, p, http.MethodPost)
+		if w.Code != http.StatusOK || w.Body.String() != "b" {
+			t.Fatalf("expected b to serve the request, got %d %q", w.Code, w.Body.String())
+		}
+	}
+}
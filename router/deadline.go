@@ -0,0 +1,87 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Clients may cap how long the proxy spends on a request with this header, in seconds or as a duration, eg, 2.5 or 2500ms.
+// Once the cap passes, lookups for the request and its upstream request are abandoned, and the proxy replies 504.
+const requestTimeoutHeader = "X-Request-Timeout"
+
+// parseRequestTimeout parses the value of the X-Request-Timeout header, which must be positive
+func parseRequestTimeout(v string) (time.Duration, error) {
+	timeout, err := time.ParseDuration(v)
+	if err != nil {
+		seconds, floatErr := strconv.ParseFloat(v, 64)
+		if floatErr != nil {
+			return 0, err
+		}
+		timeout = time.Duration(seconds * float64(time.Second))
+	}
+	if timeout <= 0 {
+		return 0, errors.New("the timeout must be positive")
+	}
+	return timeout, nil
+}
+
+// deadlineMiddleware gives requests which set the X-Request-Timeout header a deadline. Requests are already
+// cancelled when their client disconnects, so the deadline only needs adding to the request's context.
+// Invalid timeouts are ignored, rather than rejected, so a client can't lose service over a header it's optional to send.
+func (pr *ProxyRouter) deadlineMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := r.Header.Get(requestTimeoutHeader)
+		if v == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		timeout, err := parseRequestTimeout(v)
+		if err != nil {
+			pr.m.Counter("invalid_request_timeout").Inc()
+			pr.logger(r).Debug("Ignoring invalid request timeout", zap.String("timeout", v), zap.Error(err))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// cancellationReason says why a request's context is done, for the requests_cancelled metric
+func cancellationReason(ctx context.Context) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "timeout"
+	}
+	return "disconnected"
+}
+
+// abandoned reports whether the client of r disconnected, or its X-Request-Timeout passed, so the guarded handler
+// checking it can stop at stage rather than carry on looking things up for nobody. If so, it counts the request as
+// cancelled, rather than as a failed lookup, and replies 504 if the deadline passed. A disconnected client gets no body,
+// since there's nobody to read it.
+func (pr *ProxyRouter) abandoned(w http.ResponseWriter, r *http.Request, stage string) bool {
+	ctx := r.Context()
+	if ctx.Err() == nil {
+		return false
+	}
+
+	reason := cancellationReason(ctx)
+	pr.m.CounterVec("requests_cancelled", "stage", "reason").WithLabelValues(stage, reason).Inc()
+	pr.logger(r).Debug("Abandoning cancelled request", zap.String("path", r.URL.Path), zap.String("stage", stage),
+		zap.String("reason", reason))
+	if reason == "timeout" {
+		writeJSONError(w, r, http.StatusGatewayTimeout, "the request timed out")
+		return true
+	}
+	guardedDecisionFrom(ctx).reject("the client disconnected")
+	w.WriteHeader(http.StatusBadGateway)
+	return true
+}
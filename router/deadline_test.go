@@ -0,0 +1,194 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/auth"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+// slowFeeRecipients calls wait before each fee recipient lookup, to hold up the request being checked
+type slowFeeRecipients struct {
+	feerecipients.FeeRecipientSource
+	wait func()
+}
+
+func (s *slowFeeRecipients) ValidatorFeeRecipient(ctx context.Context, pubkey rptypes.ValidatorPubkey, nodeAddr *common.Address) (*feerecipient.Info, error) {
+	s.wait()
+	return s.FeeRecipientSource.ValidatorFeeRecipient(ctx, pubkey, nodeAddr)
+}
+
+func cancelledRequests(pr *ProxyRouter, stage string, reason string) float64 {
+	return testutil.ToFloat64(pr.m.CounterVec("requests_cancelled", "stage", "reason").WithLabelValues(stage, reason))
+}
+
+func TestParseRequestTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		value   string
+		timeout time.Duration
+		valid   bool
+	}{
+		{"2", 2 * time.Second, true},
+		{"2.5", 2500 * time.Millisecond, true},
+		{"2500ms", 2500 * time.Millisecond, true},
+		{"1m", time.Minute, true},
+		{"0", 0, false},
+		{"-1s", 0, false},
+		{"soon", 0, false},
+	} {
+		timeout, err := parseRequestTimeout(tc.value)
+		if (err == nil) != tc.valid || timeout != tc.timeout {
+			t.Fatalf("%q: expected %v (valid %v), got %v, %v", tc.value, tc.timeout, tc.valid, timeout, err)
+		}
+	}
+}
+
+func TestClientDisconnectsDuringValidation(t *testing.T) {
+	testMetrics(t)
+	bn := newFakeBeaconNode(t, "bn")
+	pr, el := guardedRouter(t, &ProxyRouter{SkipStatusCheck: true}, bn)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	pr.FeeRecipients = &slowFeeRecipients{FeeRecipientSource: el, wait: func() {
+		close(started)
+		<-release
+	}}
+
+	serverCtx := make(chan context.Context, 1)
+	handled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(handled)
+		serverCtx <- r.Context()
+		ctx := context.WithValue(r.Context(), prContextKey("node"), testNode.Bytes())
+		ctx = context.WithValue(ctx, prContextKey("operator_type"), auth.OperatorRocketPool)
+		pr.registerValidator()(w, r.WithContext(ctx))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+registerValidatorPath,
+		strings.NewReader(registration(testValidatorPubkey(0x01), testFeeDistributor)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientErr := make(chan error, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		clientErr <- err
+	}()
+
+	// The client gives up while the registration is being checked
+	<-started
+	cancel()
+	if err := <-clientErr; err == nil {
+		t.Fatal("expected the client's request to fail")
+	}
+	select {
+	case <-(<-serverCtx).Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the request's context to be cancelled once the client disconnected")
+	}
+	close(release)
+	<-handled
+
+	// So the check is abandoned, rather than the registration proxied for nobody
+	if bn.requests.Load() != 0 {
+		t.Fatalf("expected the registration not to be proxied, got %d upstream requests", bn.requests.Load())
+	}
+	if cancelled := cancelledRequests(pr, "validation", "disconnected"); cancelled != 1 {
+		t.Fatalf("expected 1 cancelled request, got %v", cancelled)
+	}
+}
+
+func TestRequestTimeoutHeader(t *testing.T) {
+	testMetrics(t)
+	bn := newFakeBeaconNode(t, "bn")
+	pr, el := guardedRouter(t, &ProxyRouter{SkipStatusCheck: true}, bn)
+	pr.FeeRecipients = &slowFeeRecipients{FeeRecipientSource: el, wait: func() {
+		time.Sleep(50 * time.Millisecond)
+	}}
+	handler := pr.deadlineMiddleware(pr.registerValidator())
+
+	// Checks which outlast the client's timeout are abandoned
+	r := guardedRequest(registerValidatorPath, registration(testValidatorPubkey(0x01), testFeeDistributor), auth.OperatorRocketPool)
+	r.Header.Set(requestTimeoutHeader, "10ms")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504, got %d %s", w.Code, w.Body.String())
+	}
+	var body errorResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected an error body, got %+v, %v", body, err)
+	}
+	if bn.requests.Load() != 0 {
+		t.Fatalf("expected the registration not to be proxied, got %d upstream requests", bn.requests.Load())
+	}
+	if cancelled := cancelledRequests(pr, "validation", "timeout"); cancelled != 1 {
+		t.Fatalf("expected 1 cancelled request, got %v", cancelled)
+	}
+
+	// Invalid timeouts are ignored
+	r = guardedRequest(registerValidatorPath, registration(testValidatorPubkey(0x01), testFeeDistributor), auth.OperatorRocketPool)
+	r.Header.Set(requestTimeoutHeader, "soon")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	if invalid := testutil.ToFloat64(pr.m.Counter("invalid_request_timeout")); invalid != 1 {
+		t.Fatalf("expected 1 invalid timeout, got %v", invalid)
+	}
+}
+
+func TestRequestTimeoutUpstream(t *testing.T) {
+	testMetrics(t)
+	slow := &fakeBeaconNode{Server: httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != healthPath {
+			// The request's context is only cancelled once its body has been read
+			_, _ = io.Copy(io.Discard, r.Body)
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}))}
+	defer slow.Close()
+	var err error
+	slow.url, err = url.Parse(slow.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, _ := guardedRouter(t, &ProxyRouter{SkipStatusCheck: true}, slow)
+
+	// A beacon node which outlasts the client's timeout is given up on, without counting it as the beacon node's error
+	r := guardedRequest(registerValidatorPath, registration(testValidatorPubkey(0x01), testFeeDistributor), auth.OperatorRocketPool)
+	r.Header.Set(requestTimeoutHeader, "50ms")
+	w := httptest.NewRecorder()
+	pr.deadlineMiddleware(pr.registerValidator()).ServeHTTP(w, r)
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504, got %d %s", w.Code, w.Body.String())
+	}
+	if cancelled := cancelledRequests(pr, "upstream", "timeout"); cancelled != 1 {
+		t.Fatalf("expected 1 cancelled request, got %v", cancelled)
+	}
+	if errors := testutil.ToFloat64(pr.m.Counter("upstream_errors")); errors != 0 {
+		t.Fatalf("expected no upstream errors, got %v", errors)
+	}
+}
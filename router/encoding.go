@@ -0,0 +1,47 @@
+package router
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+func contentEncoding(r *http.Request) string {
+	return strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+}
+
+// decodeContent wraps body in a decompressor, if the request's Content-Encoding calls for one.
+// Only the copy of the body used for validation is decompressed, the original bytes are proxied as-is.
+func decodeContent(r *http.Request, body io.Reader) (io.Reader, error) {
+	switch contentEncoding(r) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(body)
+	}
+
+	return nil, fmt.Errorf("unsupported Content-Encoding %q", r.Header.Get("Content-Encoding"))
+}
+
+// encodeContent compresses a rewritten body to match the request's Content-Encoding
+func encodeContent(r *http.Request, body []byte) ([]byte, error) {
+	switch contentEncoding(r) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		var out bytes.Buffer
+		w := gzip.NewWriter(&out)
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	}
+
+	return nil, fmt.Errorf("unsupported Content-Encoding %q", r.Header.Get("Content-Encoding"))
+}
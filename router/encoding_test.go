@@ -0,0 +1,190 @@
+package router
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func gzipBytes(t *testing.T, body []byte) []byte {
+	var out bytes.Buffer
+	w := gzip.NewWriter(&out)
+	if _, err := w.Write(body); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func gunzipBytes(t *testing.T, body []byte) []byte {
+	r, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// upstreamRecorder is a fake beacon node which records the last request it received
+type upstreamRecorder struct {
+	*httptest.Server
+	body            []byte
+	contentEncoding string
+	contentLength   string
+}
+
+func newUpstreamRecorder(t *testing.T) (*upstreamRecorder, *url.URL) {
+	out := &upstreamRecorder{}
+	out.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out.body, _ = io.ReadAll(r.Body)
+		out.contentEncoding = r.Header.Get("Content-Encoding")
+		out.contentLength = r.Header.Get("Content-Length")
+	}))
+
+	u, err := url.Parse(out.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out, u
+}
+
+func gzipRequest(body []byte) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/eth/v1/validator/prepare_beacon_proposer", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Content-Encoding", "gzip")
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return r
+}
+
+func TestGzipPrepareBeaconProposerPassthrough(t *testing.T) {
+	testMetrics(t)
+	proposers, _ := mixedProposers()
+	compressed := gzipBytes(t, proposers)
+	r := gzipRequest(compressed)
+
+	// The body is validated decompressed
+	clone, err := cloneRequestBody(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := decodeContent(r, clone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeProposerPreparations(r, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 4 {
+		t.Fatalf("expected 4 proposers, got %d", len(decoded))
+	}
+
+	// With correct fee recipients, nothing is rewritten and the original compressed bytes are proxied
+	upstream, upstreamURL := newUpstreamRecorder(t)
+	defer upstream.Close()
+	newReverseProxy(testUpstreams(t, upstreamURL)).ServeHTTP(httptest.NewRecorder(), r)
+
+	if !bytes.Equal(upstream.body, compressed) {
+		t.Fatal("expected the original compressed body to be proxied")
+	}
+	if upstream.contentEncoding != "gzip" {
+		t.Fatalf("expected Content-Encoding gzip, got %q", upstream.contentEncoding)
+	}
+}
+
+func TestGzipPrepareBeaconProposerRewrite(t *testing.T) {
+	testMetrics(t)
+	proposers, corrections := mixedProposers()
+	r := gzipRequest(gzipBytes(t, proposers))
+
+	clone, err := cloneRequestBody(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := decodeContent(r, clone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeProposerPreparations(r, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// With incorrect fee recipients, the rewritten body is compressed again
+	rewritten, err := rewriteProposerFeeRecipients(decoded, corrections, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := encodeContent(r, rewritten)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setRequestBody(r, compressed)
+
+	upstream, upstreamURL := newUpstreamRecorder(t)
+	defer upstream.Close()
+	newReverseProxy(testUpstreams(t, upstreamURL)).ServeHTTP(httptest.NewRecorder(), r)
+
+	if upstream.contentEncoding != "gzip" {
+		t.Fatalf("expected Content-Encoding gzip, got %q", upstream.contentEncoding)
+	}
+	if upstream.contentLength != strconv.Itoa(len(compressed)) {
+		t.Fatalf("expected Content-Length %d, got %s", len(compressed), upstream.contentLength)
+	}
+	if got := gunzipBytes(t, upstream.body); !bytes.Equal(got, rewritten) {
+		t.Fatalf("expected upstream to receive %s, got %s", rewritten, got)
+	}
+}
+
+func TestUnsupportedContentEncoding(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/eth/v1/validator/prepare_beacon_proposer", nil)
+	r.Header.Set("Content-Encoding", "br")
+
+	if _, err := decodeContent(r, bytes.NewReader(nil)); err == nil {
+		t.Fatal("expected an error for an unsupported Content-Encoding")
+	}
+	if _, err := encodeContent(r, nil); err == nil {
+		t.Fatal("expected an error for an unsupported Content-Encoding")
+	}
+}
+
+func TestAcceptEncodingPassthrough(t *testing.T) {
+	testMetrics(t)
+	response := []byte(`{"data":{"version":"Lighthouse/v4.0.0"}}`)
+	compressed := gzipBytes(t, response)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			_, _ = w.Write(response)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(compressed)
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/eth/v1/node/version", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	newReverseProxy(testUpstreams(t, upstreamURL)).ServeHTTP(w, r)
+
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a gzip response, got Content-Encoding %q", w.Header().Get("Content-Encoding"))
+	}
+	if !bytes.Equal(w.Body.Bytes(), compressed) {
+		t.Fatal("expected the compressed response to be proxied untouched")
+	}
+}
@@ -0,0 +1,55 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httputil"
+
+	"go.uber.org/zap"
+)
+
+// The beacon node's server-sent events endpoint, which validator clients hold open indefinitely
+const eventsPath = "/eth/v1/events"
+
+func isStreaming(ctx context.Context) bool {
+	streaming, _ := ctx.Value(prContextKey("streaming")).(bool)
+	return streaming
+}
+
+// newEventsProxy creates a proxy which flushes each event to the client as soon as the beacon
+// node sends it, instead of buffering the response.
+func newEventsProxy(upstreams *upstreamPool) *httputil.ReverseProxy {
+	proxy := newReverseProxy(upstreams)
+	proxy.FlushInterval = -1
+	return proxy
+}
+
+// eventStream proxies the beacon node's event stream. The upstream request shares the client
+// request's context, so it's torn down as soon as the client disconnects. Neither the proxy's
+// server nor its transport set write or response timeouts, so idle streams aren't cut off either.
+// Streams are ended when the proxy drains, since they'd otherwise hold up shutdown.
+func (pr *ProxyRouter) eventStream() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pr.m.Counter("event_stream").Inc()
+		if _, ok := w.(http.Flusher); !ok {
+			pr.logger(r).Warn("Response writer can't be flushed, events will be buffered", zap.String("uri", r.RequestURI))
+		}
+
+		active := pr.m.Gauge("event_streams_active")
+		active.Inc()
+		defer active.Dec()
+
+		// Streams are held open indefinitely, so they're exempt from the upstream request timeout
+		ctx, cancel := context.WithCancel(context.WithValue(r.Context(), prContextKey("streaming"), true))
+		defer cancel()
+		go func() {
+			select {
+			case <-pr.draining:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		pr.eventsProxy.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
@@ -0,0 +1,138 @@
+package router
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+func TestEventStream(t *testing.T) {
+	testMetrics(t)
+
+	// A fake beacon node which sends one event, then holds the stream open until the client goes away
+	upstreamDone := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(upstreamDone)
+		if r.URL.Path != eventsPath || r.URL.Query().Get("topics") != "head" {
+			t.Errorf("unexpected upstream request %s", r.URL.String())
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: head\ndata: {\"slot\":\"1\"}\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pr := &ProxyRouter{
+		Logger:      zap.NewNop(),
+		eventsProxy: newEventsProxy(testUpstreams(t, upstreamURL)),
+		m:           metrics.NewMetricsRegistry("http_proxy"),
+	}
+	proxy := httptest.NewServer(pr.eventStream())
+	defer proxy.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, proxy.URL+eventsPath+"?topics=head", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// The event arrives while the upstream response is still open
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	select {
+	case line := <-lines:
+		if line != "event: head" {
+			t.Fatalf("expected the head event, got %q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the event, was it buffered?")
+	}
+	if got := testutil.ToFloat64(pr.m.Gauge("event_streams_active")); got != 1 {
+		t.Fatalf("expected 1 active stream, got %v", got)
+	}
+
+	// Disconnecting the client tears down the upstream request
+	cancel()
+	select {
+	case <-upstreamDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the upstream request to be cancelled")
+	}
+}
+
+func TestEventStreamEndsOnDrain(t *testing.T) {
+	testMetrics(t)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pr := &ProxyRouter{
+		Logger:      zap.NewNop(),
+		eventsProxy: newEventsProxy(testUpstreams(t, upstreamURL)),
+		draining:    make(chan struct{}),
+		m:           metrics.NewMetricsRegistry("http_proxy"),
+	}
+	proxy := httptest.NewServer(pr.eventStream())
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + eventsPath + "?topics=head")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// Streams would otherwise hold up shutdown until the drain timeout
+	ended := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		close(ended)
+	}()
+	pr.Drain()
+	select {
+	case <-ended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the stream to end")
+	}
+
+	healthz := httptest.NewRecorder()
+	pr.healthz()(healthz, httptest.NewRequest(http.MethodGet, "/_/healthz", nil))
+	if healthz.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected draining to report not ready, got %d", healthz.Code)
+	}
+}
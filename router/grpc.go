@@ -4,18 +4,25 @@ import (
 	"bytes"
 	"context"
 	"encoding/hex"
+	"errors"
 	"net"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/auth"
 	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/consensuslayer"
 	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/executionlayer"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/guarded"
 	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/mwitkow/grpc-proxy/proxy"
 	prysmpb "github.com/prysmaticlabs/prysm/v3/proto/prysm/v1alpha1"
 	rptypes "github.com/rocket-pool/rocketpool-go/types"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -27,10 +34,20 @@ import (
 )
 
 type GRPCRouter struct {
-	Logger             *zap.Logger
-	EL                 *executionlayer.ExecutionLayer
-	CL                 *consensuslayer.ConsensusLayer
-	AuthValidityWindow time.Duration
+	Logger                 *zap.Logger
+	AuditLogger            *zap.Logger
+	EL                     executionlayer.RPInfoProvider
+	CL                     *consensuslayer.ConsensusLayer
+	FeeRecipients          feerecipients.FeeRecipientSource
+	AuthValidityWindow     time.Duration
+	RejectWhenStale        bool
+	UnknownValidatorPolicy UnknownValidatorPolicy
+	WarmupPolicy           WarmupPolicy
+	SkipStatusCheck        bool
+	UnenforcedStatuses     map[feerecipient.MinipoolStatus]bool
+	GuardedRequests        *guarded.Feed
+	// How often identical rejections of a validator are logged. If 0, every rejection is.
+	RejectionLogWindow time.Duration
 	TLS                struct {
 		CertFile string
 		KeyFile  string
@@ -40,21 +57,149 @@ type GRPCRouter struct {
 	upstream *grpc.ClientConn
 	listener net.Listener
 	m        *metrics.MetricsRegistry
+	policy   atomic.Value
+
+	rejectionLogs *rejectionSampler
 }
 
-type validationCb func(proto.Message, common.Address) error
+type validationCb func(context.Context, proto.Message, *auth.Credential) error
 
 type guardedServerStream struct {
 	grpc.ServerStream
-	router   *GRPCRouter
-	svcName  string
-	cb       validationCb
-	nodeAddr common.Address
+	ctx        context.Context
+	router     *GRPCRouter
+	svcName    string
+	fullMethod string
+	cb         validationCb
+	credential *auth.Credential
+	published  bool
+}
+
+// checkStale returns an error if the EL cache is too stale to check fee recipients against
+func (g *GRPCRouter) checkStale() error {
+	if !g.RejectWhenStale || !g.EL.Stale() {
+		return nil
+	}
+
+	g.m.Counter("stale_rejected").Inc()
+	countValidationOutcome(g.m, outcomeRejectedStaleCache)
+	g.Logger.Warn("Rejecting guarded request, EL cache is stale")
+	return status.Error(codes.Unavailable, "rescue node is temporarily unable to validate fee recipients")
+}
+
+func (g *GRPCRouter) auditFeeRecipientRejection(ctx context.Context, nodeAddr common.Address, pubkey rptypes.ValidatorPubkey, submitted []byte, expected *feerecipient.Info) {
+	method, _ := grpc.Method(ctx)
+	newFeeRecipientRejection(method, nodeAddr, pubkey, "0x"+hex.EncodeToString(submitted), expected).log(g.AuditLogger)
+}
+
+func (g *GRPCRouter) auditFeeRecipientAllowlisted(ctx context.Context, nodeAddr common.Address, pubkey rptypes.ValidatorPubkey, submitted []byte, expected *feerecipient.Info, allowed *executionlayer.AllowedFeeRecipient) {
+	method, _ := grpc.Method(ctx)
+	newFeeRecipientAllowlisted(method, nodeAddr, pubkey, "0x"+hex.EncodeToString(submitted), expected, allowed).log(g.AuditLogger)
+}
+
+// auditCacheInconsistency counts and audits a fee recipient lookup which failed because the validator's node is missing from the EL cache
+func (g *GRPCRouter) auditCacheInconsistency(ctx context.Context, nodeAddr common.Address, err error) {
+	method, _ := grpc.Method(ctx)
+	if inconsistency := newCacheInconsistency(method, nodeAddr, err); inconsistency != nil {
+		g.m.Counter("cache_inconsistent_rejected").Inc()
+		inconsistency.log(g.AuditLogger)
+	}
+}
+
+// checkAllowedFeeRecipient checks that a validator uses one of the fee recipients its credential allows
+func (g *GRPCRouter) checkAllowedFeeRecipient(credential *auth.Credential, pubkey rptypes.ValidatorPubkey, feeRecipient []byte) error {
+	outcome := allowedFeeRecipientOutcome(credential.FeeRecipients, func(allowed common.Address) bool {
+		return bytes.Equal(allowed.Bytes(), feeRecipient)
+	})
+	countValidationOutcome(g.m, outcome)
+	if outcome != outcomeAccepted {
+		g.m.Counter("credential_fee_recipient_rejected").Inc()
+		g.warnRejection(credential, pubkey, "Validator used a fee recipient its credential doesn't allow",
+			zap.String("key", pubkey.String()), zap.String("got", hex.EncodeToString(feeRecipient)))
+		return status.Error(codes.PermissionDenied, "incorrect fee recipient")
+	}
+
+	metrics.ObserveValidator(credential.NodeAddress, pubkey)
+	return nil
 }
 
-func (g *GRPCRouter) validatePrepareBeaconProposer(m proto.Message, nodeAddr common.Address) error {
+// checkSubjectValidator checks that a credential issued to a validator is only used for that validator
+func (g *GRPCRouter) checkSubjectValidator(credential *auth.Credential, pubkey rptypes.ValidatorPubkey) error {
+	if subjectAllowsValidator(credential, pubkey) {
+		return nil
+	}
+
+	countValidationOutcome(g.m, outcomeRejectedNodeMismatch)
+	g.m.Counter("credential_validator_rejected").Inc()
+	g.warnRejection(credential, pubkey, "Validator isn't the one its credential was issued to",
+		zap.String("key", pubkey.String()), zap.String("subject", credential.Subject))
+	return status.Errorf(codes.PermissionDenied, "validator %s is not the validator your credential was issued to", pubkey.String())
+}
+
+// checkSoloFeeRecipient checks that a validator authenticated with a solo credential uses its withdrawal address as its fee recipient
+func (g *GRPCRouter) checkSoloFeeRecipient(ctx context.Context, credential *auth.Credential, solo *withdrawalAddresses, nodeAddr common.Address, pubkey rptypes.ValidatorPubkey, feeRecipient []byte) error {
+	withdrawalAddress, err := solo.get(pubkey)
+	if err != nil {
+		g.Logger.Error("Unable to check the withdrawal address of a solo validator", zap.String("key", pubkey.String()), zap.Error(err))
+		return status.Error(codes.Internal, "internal error")
+	}
+
+	outcome := soloFeeRecipientOutcome(withdrawalAddress, func(expected common.Address) bool {
+		return bytes.Equal(expected.Bytes(), feeRecipient)
+	})
+	countSoloValidationOutcome(g.m, outcome)
+	switch outcome {
+	case outcomeRejectedUnknownValidator:
+		g.m.Counter("solo_bls_credentials").Inc()
+		g.warnRejection(credential, pubkey, "Solo validator has BLS withdrawal credentials", zap.String("key", pubkey.String()))
+		return status.Errorf(codes.PermissionDenied, "validator %s has no withdrawal address to use as its fee recipient", pubkey.String())
+	case outcomeRejectedWrongFeeRecipient:
+		g.m.Counter("solo_incorrect_fee_recipient").Inc()
+		g.auditFeeRecipientRejection(ctx, nodeAddr, pubkey, feeRecipient, &feerecipient.Info{Expected: *withdrawalAddress, NodeAddress: nodeAddr})
+		g.warnRejection(credential, pubkey, "Solo validator used a fee recipient other than its withdrawal address",
+			zap.String("key", pubkey.String()), zap.String("expected", withdrawalAddress.String()), zap.String("got", hex.EncodeToString(feeRecipient)))
+		return status.Error(codes.PermissionDenied, "incorrect fee recipient")
+	}
+
+	metrics.ObserveValidator(nodeAddr, pubkey)
+	return nil
+}
+
+// checkValidatorStatuses returns an error naming any of the registering validators which have exited or been slashed
+func (g *GRPCRouter) checkValidatorStatuses(ctx context.Context, registrations []*prysmpb.SignedValidatorRegistrationV1) error {
+	pubkeys := make([]rptypes.ValidatorPubkey, 0, len(registrations))
+	for _, registration := range registrations {
+		pubkeys = append(pubkeys, rptypes.BytesToValidatorPubkey(registration.Message.Pubkey))
+	}
+
+	statuses, err := tracedValidatorStatuses(ctx, g.CL, pubkeys)
+	if err != nil {
+		g.Logger.Error("Error while querying CL for validator statuses", zap.Error(err))
+		return status.Error(codes.Internal, "internal error")
+	}
+
+	if barred := barredValidators(pubkeys, statuses); len(barred) > 0 {
+		message := barredValidatorsMessage(g.m, barred, statuses)
+		g.Logger.Warn("register_validator called for exited or slashed validators", zap.Int("count", len(barred)),
+			zap.String("first", barred[0].String()), zap.String("status", statuses[barred[0]].String()))
+		return status.Error(codes.PermissionDenied, message)
+	}
+
+	return nil
+}
+
+func (g *GRPCRouter) validatePrepareBeaconProposer(ctx context.Context, m proto.Message, credential *auth.Credential) error {
+	nodeAddr := credential.NodeAddress
+	policy := g.policy.Load().(UnknownValidatorPolicy)
 
 	g.m.Counter("prepare_beacon_proposer").Inc()
+	if warming, err := g.checkWarming(); warming {
+		return err
+	}
+	if err := g.checkStale(); err != nil {
+		return err
+	}
+
 	pbp := &prysmpb.PrepareBeaconProposerRequest{}
 
 	unknown := []byte(m.ProtoReflect().GetUnknown())
@@ -64,6 +209,8 @@ func (g *GRPCRouter) validatePrepareBeaconProposer(m proto.Message, nodeAddr com
 		return status.Error(codes.Internal, "internal error")
 	}
 
+	guardedDecisionFrom(ctx).setValidators(len(pbp.Recipients))
+
 	// Create a slice of the indices
 	indices := make([]string, 0, len(pbp.Recipients))
 
@@ -72,12 +219,18 @@ func (g *GRPCRouter) validatePrepareBeaconProposer(m proto.Message, nodeAddr com
 	}
 
 	// Get the index->pubkey map
-	pubkeyMap, err := g.CL.GetValidatorPubkey(indices)
+	pubkeyMap, err := tracedValidatorPubkeys(ctx, g.CL, indices)
 	if err != nil {
 		g.Logger.Error("Error while querying CL for validator pubkeys", zap.Error(err))
 		return status.Error(codes.Internal, "internal error")
 	}
 
+	// Solo validators' withdrawal addresses are looked up together, rather than one at a time in the loop
+	var solo *withdrawalAddresses
+	if credential.OperatorType == auth.OperatorSolo && len(credential.FeeRecipients) == 0 {
+		solo = tracedValidatorWithdrawalAddresses(ctx, g.EL, pubkeysOf(pubkeyMap))
+	}
+
 	// Iterate the results and check the fee recipients against our expected values
 	// Note: we iterate the map from the gRPC request to ensure every key is present in the
 	// response from the consensuslayer abstraction
@@ -85,26 +238,82 @@ func (g *GRPCRouter) validatePrepareBeaconProposer(m proto.Message, nodeAddr com
 		index := strconv.FormatUint(uint64(proposer.ValidatorIndex), 10)
 		pubkey, found := pubkeyMap[index]
 		if !found {
+			countValidationOutcome(g.m, outcomeRejectedUnknownValidator)
 			g.Logger.Warn("Pubkey for index not found in response from cl.",
 				zap.String("requested index", index))
 			return status.Error(codes.PermissionDenied, "pubkey isn't owned by node")
 		}
+		if err := g.checkSubjectValidator(credential, pubkey); err != nil {
+			return err
+		}
+
+		// Credentials which only allow some fee recipients trump where the validator's from
+		if len(credential.FeeRecipients) > 0 {
+			if err := g.checkAllowedFeeRecipient(credential, pubkey, proposer.FeeRecipient); err != nil {
+				return err
+			}
+			g.m.Counter("prepare_beacon_correct_fee_recipient").Inc()
+			continue
+		}
+
+		// Solo validators must use their withdrawal address, which the EL cache doesn't know
+		if credential.OperatorType == auth.OperatorSolo {
+			if err := g.checkSoloFeeRecipient(ctx, credential, solo, nodeAddr, pubkey, proposer.FeeRecipient); err != nil {
+				return err
+			}
+			g.m.Counter("prepare_beacon_correct_fee_recipient").Inc()
+			continue
+		}
 
 		// Next we need to get the expected fee recipient for the pubkey
-		expectedFeeRecipient, unowned := g.EL.ValidatorFeeRecipient(pubkey, &nodeAddr)
-		if expectedFeeRecipient == nil {
+		expected, err := tracedValidatorFeeRecipient(ctx, g.FeeRecipients, pubkey, subjectNodeFilter(credential))
+		if lookupFailed(err) {
+			countValidationOutcome(g.m, outcomeRejectedCacheInconsistent)
+			g.auditCacheInconsistency(ctx, nodeAddr, err)
+			g.Logger.Error("Unable to determine expected fee recipient", zap.String("key", pubkey.String()), zap.Error(err))
+			return status.Error(codes.PermissionDenied, "unable to determine the expected fee recipient for validator "+pubkey.String())
+		}
+		matches := func(expected common.Address) bool {
+			return bytes.Equal(expected.Bytes(), proposer.FeeRecipient)
+		}
+		outcome, allowlisted := allowlistedOutcome(g.m, feeRecipientOutcome(expected, err, false, matches), g.EL.AllowedFeeRecipients(), matches)
+		if allowlisted != nil {
+			g.auditFeeRecipientAllowlisted(ctx, nodeAddr, pubkey, proposer.FeeRecipient, expected, allowlisted)
+		}
+		outcome = unenforcedStatusOutcome(g.m, outcome, expected, g.UnenforcedStatuses)
+		if outcome == outcomeRejectedUnknownValidator {
+			// There's no fee recipient to expect for validators which aren't minipools,
+			// so they're left untouched, if the UnknownValidatorPolicy allows them
+			allowed, err := allowUnknownValidator(g.m, policy, func() (bool, error) {
+				if len(proposer.FeeRecipient) != common.AddressLength {
+					return false, nil
+				}
+				return g.EL.SoloValidatorFeeRecipient(ctx, pubkey, common.BytesToAddress(proposer.FeeRecipient))
+			})
+			if err != nil {
+				g.Logger.Error("Unable to check the withdrawal address of an unknown validator", zap.String("key", pubkey.String()), zap.Error(err))
+				return status.Error(codes.Internal, "internal error")
+			}
+			if allowed {
+				countValidationOutcome(g.m, outcomeAccepted)
+				g.m.Counter("prepare_beacon_proposer_not_minipool").Inc()
+				continue
+			}
+		}
+		countValidationOutcome(g.m, outcome)
+		switch outcome {
+		case outcomeRejectedNodeMismatch, outcomeRejectedUnknownValidator:
 			g.m.Counter("prepare_beacon_proposer_unowned").Inc()
-			g.Logger.Warn("Pubkey not found in EL cache, or wasn't owned by the user",
+			g.warnRejection(credential, pubkey, "Pubkey not found in EL cache, or wasn't owned by the user",
 				zap.String("key", pubkey.String()),
-				zap.Bool("someone else's validator", unowned))
+				zap.Bool("someone else's validator", errors.Is(err, feerecipient.ErrWrongNode)))
 			return status.Error(codes.PermissionDenied, "pubkey belongs to someone else or isn't owned by a rp node")
-		}
-
-		if !bytes.Equal(expectedFeeRecipient.Bytes(), proposer.FeeRecipient) {
+		case outcomeRejectedWrongFeeRecipient:
 			g.m.Counter("prepare_beacon_incorrect_fee_recipient").Inc()
+			g.auditFeeRecipientRejection(ctx, nodeAddr, pubkey, proposer.FeeRecipient, expected)
 			// Looks like a cheater- fee recipient doesn't match expectations
-			g.Logger.Warn("prepare_beacon_proposer called with unexpected fee recipient",
-				zap.String("expected", expectedFeeRecipient.String()), zap.String("got", hex.EncodeToString(proposer.FeeRecipient)))
+			g.warnRejection(credential, pubkey, "prepare_beacon_proposer called with unexpected fee recipient",
+				zap.String("key", pubkey.String()), zap.String("expected", expected.Expected.String()), zap.String("got", hex.EncodeToString(proposer.FeeRecipient)))
 			return status.Error(codes.PermissionDenied, "incorrect fee recipient")
 		}
 
@@ -115,9 +324,17 @@ func (g *GRPCRouter) validatePrepareBeaconProposer(m proto.Message, nodeAddr com
 	return nil
 }
 
-func (g *GRPCRouter) validateRegisterValidators(m proto.Message, nodeAddr common.Address) error {
+func (g *GRPCRouter) validateRegisterValidators(ctx context.Context, m proto.Message, credential *auth.Credential) error {
+	nodeAddr := credential.NodeAddress
 
 	g.m.Counter("register_validator").Inc()
+	if warming, err := g.checkWarming(); warming {
+		return err
+	}
+	if err := g.checkStale(); err != nil {
+		return err
+	}
+
 	rv := &prysmpb.SignedValidatorRegistrationsV1{}
 
 	unknown := []byte(m.ProtoReflect().GetUnknown())
@@ -126,31 +343,108 @@ func (g *GRPCRouter) validateRegisterValidators(m proto.Message, nodeAddr common
 		g.Logger.Error("Error unmarshalling gRPC message", zap.Error(err))
 		return status.Error(codes.Internal, "internal error")
 	}
+	guardedDecisionFrom(ctx).setValidators(len(rv.Messages))
+	policy := g.policy.Load().(UnknownValidatorPolicy)
+
+	if !g.SkipStatusCheck {
+		if err := g.checkValidatorStatuses(ctx, rv.Messages); err != nil {
+			return err
+		}
+	}
+
+	// Solo validators' withdrawal addresses are looked up together, rather than one at a time in the loop
+	var solo *withdrawalAddresses
+	if credential.OperatorType == auth.OperatorSolo && len(credential.FeeRecipients) == 0 {
+		pubkeys := make([]rptypes.ValidatorPubkey, 0, len(rv.Messages))
+		for _, registration := range rv.Messages {
+			pubkeys = append(pubkeys, rptypes.BytesToValidatorPubkey(registration.Message.Pubkey))
+		}
+		solo = tracedValidatorWithdrawalAddresses(ctx, g.EL, pubkeys)
+	}
 
 	for _, registration := range rv.Messages {
 		pubkey := (*rptypes.ValidatorPubkey)(registration.Message.Pubkey)
+		if err := g.checkSubjectValidator(credential, *pubkey); err != nil {
+			return err
+		}
+
+		// Credentials which only allow some fee recipients trump where the validator's from
+		if len(credential.FeeRecipients) > 0 {
+			if err := g.checkAllowedFeeRecipient(credential, *pubkey, registration.Message.FeeRecipient); err != nil {
+				return err
+			}
+			g.m.Counter("register_validator_correct_fee_recipient").Inc()
+			continue
+		}
+
+		// Solo validators must use their withdrawal address, whatever the UnknownValidatorPolicy
+		if credential.OperatorType == auth.OperatorSolo {
+			if err := g.checkSoloFeeRecipient(ctx, credential, solo, nodeAddr, *pubkey, registration.Message.FeeRecipient); err != nil {
+				return err
+			}
+			g.m.Counter("register_validator_correct_fee_recipient").Inc()
+			continue
+		}
 
 		// Grab the expected fee recipient for the pubkey
-		expectedFeeRecipient, unowned := g.EL.ValidatorFeeRecipient(*pubkey, &nodeAddr)
-		if expectedFeeRecipient == nil {
-			// When unowned is true for register_validators, it means the pubkey was someone else's minipool
-			// we still want that to get rejected... however, if unowned is false and expectedFeeRecipient is nil,
-			// it means we're seeing a solo validator using mev-boost. Since register_validator requires a signature,
-			// we can allow this fee recipient.
-			if !unowned {
-				g.m.Counter("register_validator_not_minipool").Inc()
-				metrics.ObserveValidator(nodeAddr, *pubkey)
-				// Move on to the next pubkey
-				continue
+		expected, err := tracedValidatorFeeRecipient(ctx, g.FeeRecipients, *pubkey, subjectNodeFilter(credential))
+		if lookupFailed(err) {
+			// A minipool whose node we don't know can't be let through, whatever the policy
+			countValidationOutcome(g.m, outcomeRejectedCacheInconsistent)
+			g.auditCacheInconsistency(ctx, nodeAddr, err)
+			g.Logger.Error("Unable to determine expected fee recipient", zap.String("key", pubkey.String()), zap.Error(err))
+			return status.Error(codes.PermissionDenied, "unable to determine the expected fee recipient for validator "+pubkey.String())
+		}
+		// ErrWrongNode for register_validators means the pubkey was someone else's minipool, and
+		// we still want that to get rejected... however, ErrNotMinipool means we're seeing a solo validator
+		// using mev-boost. Since register_validator requires a signature, we can allow this fee recipient,
+		// if the UnknownValidatorPolicy does.
+		matches := func(expected common.Address) bool {
+			return bytes.Equal(expected.Bytes(), registration.Message.FeeRecipient)
+		}
+		outcome, allowlisted := allowlistedOutcome(g.m, feeRecipientOutcome(expected, err, true, matches), g.EL.AllowedFeeRecipients(), matches)
+		if allowlisted != nil {
+			g.auditFeeRecipientAllowlisted(ctx, nodeAddr, *pubkey, registration.Message.FeeRecipient, expected, allowlisted)
+		}
+		outcome = unenforcedStatusOutcome(g.m, outcome, expected, g.UnenforcedStatuses)
+		if outcome == outcomeAccepted && expected == nil {
+			allowed, err := allowUnknownValidator(g.m, policy, func() (bool, error) {
+				if len(registration.Message.FeeRecipient) != common.AddressLength {
+					return false, nil
+				}
+				return g.EL.SoloValidatorFeeRecipient(ctx, *pubkey, common.BytesToAddress(registration.Message.FeeRecipient))
+			})
+			if err != nil {
+				g.Logger.Error("Unable to check the withdrawal address of an unknown validator", zap.String("key", pubkey.String()), zap.Error(err))
+				return status.Error(codes.Internal, "internal error")
+			}
+			if !allowed {
+				outcome = outcomeRejectedUnknownValidator
 			}
-			g.Logger.Warn("Pubkey not found in EL cache. Not an RP validator?", zap.String("key", pubkey.String()))
-			return status.Error(codes.PermissionDenied, "pubkey belongs to someone else")
+		}
+		countValidationOutcome(g.m, outcome)
+		if outcome == outcomeAccepted && expected == nil {
+			g.m.Counter("register_validator_not_minipool").Inc()
+			metrics.ObserveValidator(nodeAddr, *pubkey)
+			// Move on to the next pubkey
+			continue
 		}
 
-		if !bytes.Equal(expectedFeeRecipient.Bytes(), registration.Message.FeeRecipient) {
+		switch outcome {
+		case outcomeRejectedNodeMismatch:
+			g.warnRejection(credential, *pubkey, "Pubkey not found in EL cache. Not an RP validator?", zap.String("key", pubkey.String()))
+			return status.Error(codes.PermissionDenied, "pubkey belongs to someone else")
+		case outcomeRejectedUnknownValidator:
+			g.m.Counter("register_validator_unknown_denied").Inc()
+			g.warnRejection(credential, *pubkey, "register_validator called for a validator which isn't a known minipool",
+				zap.String("key", pubkey.String()), zap.String("policy", string(policy)))
+			return status.Errorf(codes.PermissionDenied, "validator %s is not a known minipool, and the %s policy rejects it",
+				pubkey.String(), policy)
+		case outcomeRejectedWrongFeeRecipient:
 			g.m.Counter("register_validator_incorrect_fee_recipient").Inc()
-			g.Logger.Warn("register_validator called with unexpected fee recipient",
-				zap.String("expected", expectedFeeRecipient.String()),
+			g.auditFeeRecipientRejection(ctx, nodeAddr, *pubkey, registration.Message.FeeRecipient, expected)
+			g.warnRejection(credential, *pubkey, "register_validator called with unexpected fee recipient",
+				zap.String("key", pubkey.String()), zap.String("expected", expected.Expected.String()),
 				zap.String("got", hex.EncodeToString(registration.Message.FeeRecipient)))
 			return status.Error(codes.PermissionDenied, "incorrect fee recipient")
 		}
@@ -174,12 +468,24 @@ func (g *guardedServerStream) RecvMsg(m interface{}) error {
 	}
 
 	g.router.Logger.Debug("intercepted proto request", zap.String("svc", g.svcName))
-	if err := g.cb(pbMsg, g.nodeAddr); err != nil {
+	err := g.cb(g.Context(), pbMsg, g.credential)
+
+	// The proxy keeps receiving until the client's done sending, but each call carries one request
+	if !g.published {
+		g.published = true
+		g.router.publishGuarded(g.Context(), g.credential.NodeAddress, g.fullMethod, err)
+	}
+	if err != nil {
 		return err
 	}
 	return g.ServerStream.RecvMsg(m)
 }
 
+// Context carries the decision about the call, if it's being published
+func (g *guardedServerStream) Context() context.Context {
+	return g.ctx
+}
+
 func (g *GRPCRouter) payloadInterceptor() grpc.StreamServerInterceptor {
 	services := map[string]any{
 		"ethereum.eth.v1alpha1.BeaconChain":         struct{}{},
@@ -212,7 +518,7 @@ func (g *GRPCRouter) payloadInterceptor() grpc.StreamServerInterceptor {
 		}
 
 		// See https://github.com/prysmaticlabs/prysm/issues/11765
-		var nodeAddr common.Address
+		credential := &auth.Credential{}
 		if method[2] != "DomainData" && method[2] != "SubscribeCommitteeSubnets" {
 			val, exists := md["rprnauth"]
 			if !exists || len(val) < 1 {
@@ -221,14 +527,14 @@ func (g *GRPCRouter) payloadInterceptor() grpc.StreamServerInterceptor {
 				return status.Error(codes.Unauthenticated, "headers missing")
 			}
 
-			auth := strings.Split(val[0], ":")
-			if len(auth) != 2 {
+			authHeader := strings.Split(val[0], ":")
+			if len(authHeader) != 2 {
 				g.m.Counter("auth_header_malformed").Inc()
 				g.Logger.Debug("grpc access with invalid auth header")
 				return status.Error(codes.Unauthenticated, "headers invalid")
 			}
 
-			ac, err := authenticate(auth[0], auth[1])
+			ac, err := tracedAuthenticate(ctx, authHeader[0], authHeader[1])
 			if err != nil {
 				g.m.Counter("unauthed").Inc()
 				g.Logger.Debug("Unable to authenticate credentials", zap.Error(err))
@@ -236,19 +542,28 @@ func (g *GRPCRouter) payloadInterceptor() grpc.StreamServerInterceptor {
 			}
 
 			g.m.Counter("auth_ok").Inc()
+			if ac.WithinGrace {
+				g.Logger.Info("Accepted an expired credential within the grace period",
+					zap.String("node", ac.NodeAddress.String()), zap.String("credential_id", ac.ID))
+			}
 			g.Logger.Debug("Proxying guarded grpc service", zap.String("method", info.FullMethod))
 
-			nodeAddr = common.BytesToAddress(ac.Credential.NodeId)
+			credential = ac
 		}
 
 		if cb, matched := msgCbs[method[2]]; matched {
 			g.m.Counter("guarded_service_call").Inc()
+			if g.GuardedRequests != nil {
+				ctx, _ = withGuardedDecision(ctx)
+			}
 			wrapper := &guardedServerStream{
 				ServerStream: stream,
+				ctx:          ctx,
 				router:       g,
 				svcName:      method[2],
+				fullMethod:   info.FullMethod,
 				cb:           cb,
-				nodeAddr:     nodeAddr}
+				credential:   credential}
 
 			return handler(srv, wrapper)
 		}
@@ -275,10 +590,34 @@ func (g *GRPCRouter) transportCredentials() (credentials.TransportCredentials, e
 	return credentials.NewServerTLSFromFile(g.TLS.CertFile, g.TLS.KeyFile)
 }
 
+// SetUnknownValidatorPolicy changes the UnknownValidatorPolicy requests are checked against, once Init has been called
+func (g *GRPCRouter) SetUnknownValidatorPolicy(policy UnknownValidatorPolicy) {
+	g.policy.Store(policy)
+}
+
 func (g *GRPCRouter) Init(listenAddr string, beaconAddr string) error {
 	var err error
 
 	g.m = metrics.NewMetricsRegistry("grpc_proxy")
+	g.rejectionLogs = newRejectionSampler(g.RejectionLogWindow)
+
+	if g.AuditLogger == nil {
+		g.AuditLogger = zap.NewNop()
+	}
+
+	// Unless other sources are configured, fee recipients are those of Rocket Pool minipools
+	if g.FeeRecipients == nil {
+		g.FeeRecipients = g.EL
+	}
+
+	if g.UnknownValidatorPolicy == "" {
+		g.UnknownValidatorPolicy = UnknownValidatorAllow
+	}
+	g.policy.Store(g.UnknownValidatorPolicy)
+
+	if g.WarmupPolicy == "" {
+		g.WarmupPolicy = WarmupFailClosed
+	}
 
 	g.listener, err = net.Listen("tcp", listenAddr)
 	if err != nil {
@@ -293,7 +632,8 @@ func (g *GRPCRouter) Init(listenAddr string, beaconAddr string) error {
 	}
 
 	g.upstream, err = grpc.Dial(beaconAddr,
-		grpc.WithTransportCredentials(tc))
+		grpc.WithTransportCredentials(tc),
+		grpc.WithStreamInterceptor(otelgrpc.StreamClientInterceptor()))
 	if err != nil {
 		return err
 	}
@@ -302,7 +642,7 @@ func (g *GRPCRouter) Init(listenAddr string, beaconAddr string) error {
 
 	g.proxy = proxy.NewProxy(g.upstream,
 		grpc.UnknownServiceHandler(proxy.TransparentHandler(director)),
-		grpc.StreamInterceptor(g.payloadInterceptor()))
+		grpc.ChainStreamInterceptor(otelgrpc.StreamServerInterceptor(), g.payloadInterceptor()))
 
 	go func() {
 		server := g.proxy
@@ -314,6 +654,27 @@ func (g *GRPCRouter) Init(listenAddr string, beaconAddr string) error {
 	return nil
 }
 
+// Shutdown stops accepting new requests, and waits for in-flight ones to finish until ctx is done,
+// at which point any that remain, including streams opened by the upstream, are cancelled.
+func (g *GRPCRouter) Shutdown(ctx context.Context) {
+	g.Logger.Debug("Draining grpc proxy")
+	stopped := make(chan struct{})
+	go func() {
+		g.proxy.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		g.Logger.Warn("Timed out draining gRPC proxy requests")
+		g.proxy.Stop()
+		<-stopped
+	}
+	g.listener.Close()
+	g.upstream.Close()
+}
+
 func (g *GRPCRouter) Deinit() {
 	g.Logger.Debug("Stopping grpc proxy")
 	// GracefulStop doesn't close streams opened by the upstream, so call Stop instead
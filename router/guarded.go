@@ -0,0 +1,118 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/guarded"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	"google.golang.org/grpc/status"
+)
+
+// guardedDecision is filled in while a guarded request is handled, and published once it's been decided
+type guardedDecision struct {
+	validators int
+	accepted   bool
+	reason     string
+}
+
+func withGuardedDecision(ctx context.Context) (context.Context, *guardedDecision) {
+	decision := &guardedDecision{}
+	return context.WithValue(ctx, prContextKey("guarded_decision"), decision), decision
+}
+
+// guardedDecisionFrom returns the decision being made about a guarded request, or nil for other requests.
+// Its methods may be called on nil.
+func guardedDecisionFrom(ctx context.Context) *guardedDecision {
+	decision, _ := ctx.Value(prContextKey("guarded_decision")).(*guardedDecision)
+	return decision
+}
+
+func (d *guardedDecision) setValidators(validators int) {
+	if d != nil {
+		d.validators = validators
+	}
+}
+
+// accept records that the request is being proxied
+func (d *guardedDecision) accept() {
+	if d != nil {
+		d.accepted = true
+	}
+}
+
+// reject records why the request was refused. Only the first reason is kept,
+// and errors after it was accepted, eg, from the beacon node, don't change the decision.
+func (d *guardedDecision) reject(reason string) {
+	if d != nil && !d.accepted && d.reason == "" {
+		d.reason = reason
+	}
+}
+
+func (d *guardedDecision) request(nodeAddr common.Address, endpoint string) guarded.Request {
+	return guarded.Request{
+		Time:        time.Now(),
+		NodeAddress: nodeAddr,
+		Endpoint:    endpoint,
+		Validators:  d.validators,
+		Accepted:    d.accepted,
+		Reason:      d.reason,
+	}
+}
+
+// statusRecorder remembers the status a handler replied with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// publishGuarded publishes the decision about each request to a guarded endpoint to GuardedRequests, if it's set,
+// and counts those rejected. Rejections which didn't give a reason are described by their status. Requests abandoned
+// because their client went away or they timed out weren't refused, so aren't counted.
+func (pr *ProxyRouter) publishGuarded(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, decision := withGuardedDecision(r.Context())
+		recorder := &statusRecorder{ResponseWriter: w}
+		next(recorder, r.WithContext(ctx))
+
+		if !decision.accepted && recorder.status >= http.StatusBadRequest && ctx.Err() == nil {
+			metrics.ObserveRejection()
+		}
+		if pr.GuardedRequests == nil {
+			return
+		}
+
+		decision.reject(http.StatusText(recorder.status))
+		authedNode, _ := r.Context().Value(prContextKey("node")).([]byte)
+		pr.GuardedRequests.Publish(decision.request(common.BytesToAddress(authedNode), r.URL.Path))
+	}
+}
+
+// publishGuarded publishes the decision about a guarded gRPC call, if it's being published, and counts it if it was
+// rejected, which err is the reason for
+func (g *GRPCRouter) publishGuarded(ctx context.Context, nodeAddr common.Address, method string, err error) {
+	if err != nil {
+		metrics.ObserveRejection()
+	}
+
+	decision := guardedDecisionFrom(ctx)
+	if decision == nil {
+		return
+	}
+
+	if err != nil {
+		decision.reject(status.Convert(err).Message())
+	} else {
+		decision.accept()
+	}
+	g.GuardedRequests.Publish(decision.request(nodeAddr, method))
+}
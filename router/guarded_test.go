@@ -0,0 +1,88 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/guarded"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPublishGuarded(t *testing.T) {
+	_, err := metrics.Init("guarded_test_" + t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer metrics.Deinit()
+
+	pr := &ProxyRouter{GuardedRequests: guarded.NewFeed()}
+	requests, unsubscribe := pr.GuardedRequests.Subscribe()
+	defer unsubscribe()
+	node := common.HexToAddress("0x1111111111111111111111111111111111111111")
+
+	testCases := []struct {
+		name     string
+		handler  http.HandlerFunc
+		accepted bool
+		reason   string
+	}{
+		{
+			name: "accepted",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				guardedDecisionFrom(r.Context()).setValidators(2)
+				guardedDecisionFrom(r.Context()).accept()
+				// The beacon node failing doesn't change what the proxy decided
+				writeJSONError(w, r, http.StatusBadGateway, "unable to reach the beacon node")
+			},
+			accepted: true,
+		},
+		{
+			name: "rejected",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				guardedDecisionFrom(r.Context()).setValidators(2)
+				writeJSONError(w, r, http.StatusConflict, "wrong fee recipient")
+			},
+			reason: "wrong fee recipient",
+		},
+		{
+			name: "failed",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				guardedDecisionFrom(r.Context()).setValidators(2)
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			reason: "Internal Server Error",
+		},
+	}
+
+	for _, tc := range testCases {
+		r := httptest.NewRequest(http.MethodPost, registerValidatorPath, nil)
+		r = r.WithContext(context.WithValue(r.Context(), prContextKey("node"), node.Bytes()))
+		pr.publishGuarded(tc.handler)(httptest.NewRecorder(), r)
+
+		request := <-requests
+		if request.NodeAddress != node || request.Endpoint != registerValidatorPath || request.Validators != 2 ||
+			request.Accepted != tc.accepted || request.Reason != tc.reason {
+			t.Fatalf("%s: unexpected request %+v", tc.name, request)
+		}
+	}
+
+	// gRPC calls are rejected with their error's message
+	g := &GRPCRouter{GuardedRequests: pr.GuardedRequests}
+	ctx, _ := withGuardedDecision(context.Background())
+	g.publishGuarded(ctx, node, "/ethereum.eth.v1alpha1.BeaconNodeValidator/PrepareBeaconProposer", status.Error(codes.PermissionDenied, "incorrect fee recipient"))
+	if request := <-requests; request.Accepted || request.Reason != "incorrect fee recipient" {
+		t.Fatalf("unexpected request %+v", request)
+	}
+
+	// Requests which aren't being published are left alone
+	g.publishGuarded(context.Background(), node, "", errors.New("ignored"))
+	if len(requests) != 0 {
+		t.Fatal("expected nothing to be published")
+	}
+}
@@ -0,0 +1,198 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+)
+
+// GuardMode decides what a guard does with the requests it checks
+type GuardMode string
+
+const (
+	// GuardEnforce rejects requests which fail the guard's checks
+	GuardEnforce GuardMode = "enforce"
+	// GuardShadow checks requests like GuardEnforce, and counts and audits those which would have been rejected,
+	// but proxies them anyway
+	GuardShadow GuardMode = "shadow"
+	// GuardOff proxies requests without checking them
+	GuardOff GuardMode = "off"
+)
+
+// The guards whose modes can be set, each of which checks one or more endpoints
+const (
+	GuardPrepareBeaconProposer  = "prepare_beacon_proposer"
+	GuardRegisterValidator      = "register_validator"
+	GuardPublishBlock           = "publish_block"
+	GuardKeymanagerFeeRecipient = "keymanager_fee_recipient"
+)
+
+var guards = map[string]bool{
+	GuardPrepareBeaconProposer:  true,
+	GuardRegisterValidator:      true,
+	GuardPublishBlock:           true,
+	GuardKeymanagerFeeRecipient: true,
+}
+
+// Guards returns the names of the guards whose modes can be set, sorted
+func Guards() []string {
+	out := make([]string, 0, len(guards))
+	for guard := range guards {
+		out = append(out, guard)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// shadowWarningHeader is set on responses to requests shadow mode forwarded, if ShadowWarningHeader is set
+const shadowWarningHeader = "Warning"
+
+// ParseGuardModes parses a comma-separated list of guard=mode pairs, eg, publish_block=shadow,keymanager_fee_recipient=off.
+// Guards which aren't listed enforce.
+func ParseGuardModes(s string) (map[string]GuardMode, error) {
+	out := make(map[string]GuardMode)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		guard, mode, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected guard=mode, got %s", pair)
+		}
+		if !guards[guard] {
+			return nil, fmt.Errorf("unknown guard %q, expected one of %s", guard, strings.Join(Guards(), ", "))
+		}
+		switch GuardMode(mode) {
+		case GuardEnforce, GuardShadow, GuardOff:
+		default:
+			return nil, fmt.Errorf("unknown mode %q for %s, expected enforce, shadow or off", mode, guard)
+		}
+		out[guard] = GuardMode(mode)
+	}
+	return out, nil
+}
+
+// guardMode returns the mode of guard, which enforces unless GuardModes says otherwise
+func (pr *ProxyRouter) guardMode(guard string) GuardMode {
+	if mode, ok := pr.GuardModes[guard]; ok {
+		return mode
+	}
+	return GuardEnforce
+}
+
+// shadowWriter hides whatever a guarded handler replies until it decides to proxy the request,
+// so that a rejection can be replaced by the beacon node's response
+type shadowWriter struct {
+	http.ResponseWriter
+	decision *guardedDecision
+	// The headers the handler set while rejecting the request, which are discarded
+	rejectionHeader http.Header
+	// The status the handler rejected the request with
+	status int
+}
+
+func (s *shadowWriter) Header() http.Header {
+	if s.decision.accepted {
+		return s.ResponseWriter.Header()
+	}
+	return s.rejectionHeader
+}
+
+func (s *shadowWriter) WriteHeader(code int) {
+	if s.decision.accepted {
+		s.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if s.status == 0 {
+		s.status = code
+	}
+}
+
+func (s *shadowWriter) Write(b []byte) (int, error) {
+	if s.decision.accepted {
+		return s.ResponseWriter.Write(b)
+	}
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return len(b), nil
+}
+
+// guard applies the mode of guard to next, the handler which checks its requests. Reads, which aren't checked,
+// are passed to next as they are. Rate limits and authentication aren't part of any guard, so apply in every mode.
+func (pr *ProxyRouter) guard(guard string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+
+		switch pr.guardMode(guard) {
+		case GuardOff:
+			pr.m.CounterVec("guard_off", "guard").WithLabelValues(guard).Inc()
+			guardedDecisionFrom(r.Context()).accept()
+			pr.proxy.ServeHTTP(w, r)
+		case GuardShadow:
+			pr.shadow(guard, next, w, r)
+		default:
+			next(w, r)
+		}
+	}
+}
+
+// shadow runs next, and if it would have rejected the request, counts and audits the rejection, then proxies the
+// request anyway. The body next read is kept, so the request can be proxied as it was received.
+func (pr *ProxyRouter) shadow(guard string, next http.HandlerFunc, w http.ResponseWriter, r *http.Request) {
+	// The decision says whether next proxied the request. publishGuarded only makes one if something's listening.
+	decision := guardedDecisionFrom(r.Context())
+	if decision == nil {
+		var ctx context.Context
+		ctx, decision = withGuardedDecision(r.Context())
+		r = r.WithContext(ctx)
+	}
+
+	body := r.Body
+	if body == nil {
+		body = http.NoBody
+	}
+	read := &bytes.Buffer{}
+	checked := r.WithContext(r.Context())
+	checked.Body = io.NopCloser(io.TeeReader(body, read))
+
+	sw := &shadowWriter{ResponseWriter: w, decision: decision, rejectionHeader: make(http.Header)}
+	next(sw, checked)
+	if decision.accepted {
+		return
+	}
+
+	decision.reject(http.StatusText(sw.status))
+	pr.m.CounterVec("shadow_rejected", "guard").WithLabelValues(guard).Inc()
+	authedNode, _ := r.Context().Value(prContextKey("node")).([]byte)
+	pr.auditLogger(r).Info("Proxied request shadow mode would have rejected",
+		zap.String("path", r.URL.Path),
+		zap.String("guard", guard),
+		zap.String("node", common.BytesToAddress(authedNode).String()),
+		zap.Int("status", sw.status),
+		zap.String("reason", decision.reason))
+	if pr.ShadowWarningHeader {
+		w.Header().Set(shadowWarningHeader, "299 rescue-proxy "+strconv.Quote("this request would have been rejected: "+decision.reason))
+	}
+
+	// Whatever next didn't read follows what it did
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(read, body), body}
+	decision.accept()
+	pr.proxy.ServeHTTP(w, r)
+}
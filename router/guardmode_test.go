@@ -0,0 +1,143 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/auth"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/guarded"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestParseGuardModes(t *testing.T) {
+	modes, err := ParseGuardModes("publish_block=shadow, keymanager_fee_recipient=off,register_validator=enforce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modes) != 3 || modes[GuardPublishBlock] != GuardShadow || modes[GuardKeymanagerFeeRecipient] != GuardOff ||
+		modes[GuardRegisterValidator] != GuardEnforce {
+		t.Fatalf("unexpected modes %v", modes)
+	}
+
+	if modes, err := ParseGuardModes(""); err != nil || len(modes) != 0 {
+		t.Fatalf("expected no modes, got %v %v", modes, err)
+	}
+
+	for _, s := range []string{"publish_block", "get_block=shadow", "publish_block=observe", "=off"} {
+		if _, err := ParseGuardModes(s); err == nil {
+			t.Fatalf("expected an error for %s", s)
+		}
+	}
+}
+
+func TestGuardShadow(t *testing.T) {
+	testMetrics(t)
+	core, logs := observer.New(zapcore.InfoLevel)
+	bn := newFakeBeaconNode(t, "bn")
+	pr, _ := guardedRouter(t, &ProxyRouter{
+		SkipStatusCheck:     true,
+		GuardModes:          map[string]GuardMode{GuardRegisterValidator: GuardShadow},
+		ShadowWarningHeader: true,
+		GuardedRequests:     guarded.NewFeed(),
+	}, bn)
+	pr.AuditLogger = zap.New(core)
+	requests, unsubscribe := pr.GuardedRequests.Subscribe()
+	defer unsubscribe()
+	handler := pr.publishGuarded(pr.guard(GuardRegisterValidator, pr.registerValidator()))
+
+	// A registration with the wrong fee recipient is checked, counted and audited like it would be rejected,
+	// then proxied as it was received
+	body := registration(testValidatorPubkey(0x01), testWrongRecipient)
+	w := httptest.NewRecorder()
+	handler(w, guardedRequest(registerValidatorPath, body, auth.OperatorRocketPool))
+	if w.Code != http.StatusOK || w.Body.String() != "bn" {
+		t.Fatalf("expected the beacon node's response, got %d %s", w.Code, w.Body.String())
+	}
+	if got := bn.body.Load(); got != body {
+		t.Fatalf("expected the original body to be proxied, got %v", got)
+	}
+	if warning := w.Header().Get("Warning"); !strings.Contains(warning, "must use fee recipient") {
+		t.Fatalf("expected a warning with the rejection, got %q", warning)
+	}
+	if ct := w.Header().Get("Content-Type"); strings.Contains(ct, "json") {
+		t.Fatalf("expected the rejection's headers to be discarded, got Content-Type %s", ct)
+	}
+	if got := testutil.ToFloat64(pr.m.Counter("register_validator_incorrect_fee_recipient")); got != 1 {
+		t.Fatalf("expected the wrong fee recipient to be counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(pr.m.CounterVec("shadow_rejected", "guard").WithLabelValues(GuardRegisterValidator)); got != 1 {
+		t.Fatalf("expected 1 shadow rejection, got %v", got)
+	}
+	if len(logs.FilterMessage("Rejected fee recipient").All()) != 1 {
+		t.Fatalf("expected the rejection to be audited, got %v", logs.All())
+	}
+	shadowed := logs.FilterMessage("Proxied request shadow mode would have rejected").All()
+	if len(shadowed) != 1 || shadowed[0].ContextMap()["guard"] != GuardRegisterValidator || shadowed[0].ContextMap()["status"] != int64(http.StatusConflict) {
+		t.Fatalf("expected the shadow rejection to be audited, got %v", logs.All())
+	}
+	if request := <-requests; !request.Accepted || !strings.Contains(request.Reason, "must use fee recipient") {
+		t.Fatalf("expected the request to be published as proxied, with the reason it would have been rejected, got %+v", request)
+	}
+
+	// Requests which pass aren't counted
+	w = httptest.NewRecorder()
+	handler(w, guardedRequest(registerValidatorPath, registration(testValidatorPubkey(0x01), testFeeDistributor), auth.OperatorRocketPool))
+	if w.Code != http.StatusOK || w.Header().Get("Warning") != "" {
+		t.Fatalf("expected the request to be proxied without a warning, got %d %v", w.Code, w.Header())
+	}
+	if got := testutil.ToFloat64(pr.m.CounterVec("shadow_rejected", "guard").WithLabelValues(GuardRegisterValidator)); got != 1 {
+		t.Fatalf("expected 1 shadow rejection, got %v", got)
+	}
+	if request := <-requests; !request.Accepted || request.Reason != "" {
+		t.Fatalf("expected an accepted request, got %+v", request)
+	}
+	if bn.requests.Load() != 2 {
+		t.Fatalf("expected both requests to reach the beacon node, got %d", bn.requests.Load())
+	}
+}
+
+func TestGuardModesIndependent(t *testing.T) {
+	testMetrics(t)
+	bn := newFakeBeaconNode(t, "bn")
+	pr, _ := guardedRouter(t, &ProxyRouter{
+		SkipStatusCheck: true,
+		GuardModes:      map[string]GuardMode{GuardPrepareBeaconProposer: GuardOff, GuardRegisterValidator: GuardShadow},
+	}, bn)
+
+	body := registration(testValidatorPubkey(0x02), testSmoothingPool)
+	for _, tc := range []struct {
+		guard string
+		code  int
+	}{
+		{GuardPrepareBeaconProposer, http.StatusOK},
+		{GuardRegisterValidator, http.StatusOK},
+		{GuardPublishBlock, http.StatusForbidden},
+	} {
+		w := httptest.NewRecorder()
+		pr.guard(tc.guard, pr.registerValidator())(w, guardedRequest(registerValidatorPath, body, auth.OperatorRocketPool))
+		if w.Code != tc.code {
+			t.Fatalf("expected %d with %s's mode, got %d", tc.code, tc.guard, w.Code)
+		}
+	}
+
+	// Only the guard that's off skips checking, and only the shadowed one counts what it would have rejected
+	if got := testutil.ToFloat64(pr.m.Counter("register_validator")); got != 2 {
+		t.Fatalf("expected 2 requests to be checked, got %v", got)
+	}
+	if got := testutil.ToFloat64(pr.m.CounterVec("guard_off", "guard").WithLabelValues(GuardPrepareBeaconProposer)); got != 1 {
+		t.Fatalf("expected 1 unchecked request, got %v", got)
+	}
+	for guard, expected := range map[string]float64{GuardRegisterValidator: 1, GuardPublishBlock: 0} {
+		if got := testutil.ToFloat64(pr.m.CounterVec("shadow_rejected", "guard").WithLabelValues(guard)); got != expected {
+			t.Fatalf("expected %v shadow rejections for %s, got %v", expected, guard, got)
+		}
+	}
+	if bn.requests.Load() != 2 {
+		t.Fatalf("expected 2 requests to reach the beacon node, got %d", bn.requests.Load())
+	}
+}
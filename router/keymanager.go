@@ -0,0 +1,218 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/executionlayer"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/mux"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+	"go.uber.org/zap"
+)
+
+// The keymanager API's per-validator fee recipient endpoint, which is checked like the guarded endpoints
+const keymanagerFeeRecipientPath = "/eth/v1/validator/{pubkey:0x[0-9a-fA-F]{96}}/feerecipient"
+
+// keymanagerPaths are the keymanager API's endpoints, which are refused when passthrough is disabled.
+// The pubkey pattern keeps them from matching beacon node endpoints under /eth/v1/validator.
+var keymanagerPaths = []string{
+	"/eth/v1/keystores",
+	"/eth/v1/remotekeys",
+	keymanagerFeeRecipientPath,
+	"/eth/v1/validator/{pubkey:0x[0-9a-fA-F]{96}}/gas_limit",
+	"/eth/v1/validator/{pubkey:0x[0-9a-fA-F]{96}}/graffiti",
+	"/eth/v1/validator/{pubkey:0x[0-9a-fA-F]{96}}/voluntary_exit",
+}
+
+// setFeeRecipientRequest is the body of a keymanager API POST to the feerecipient endpoint
+type setFeeRecipientRequest struct {
+	EthAddress string `json:"ethaddress"`
+}
+
+// keymanagerFeeRecipientOutcome decides whether a keymanager API request may set (POST) or delete (DELETE)
+// a validator's fee recipient, given the results of ValidatorFeeRecipient().
+//
+// Deleting a minipool's fee recipient reverts it to the validator client's default, which can't be checked,
+// so it's rejected. Validators which aren't minipools may delete theirs, but may not set one, since
+// prepare_beacon_proposer would reject it anyway.
+func keymanagerFeeRecipientOutcome(method string, info *feerecipient.Info, err error, submitted string) validationOutcome {
+	if method == http.MethodDelete {
+		switch {
+		case errors.Is(err, feerecipient.ErrWrongNode):
+			return outcomeRejectedNodeMismatch
+		case errors.Is(err, feerecipient.ErrNotMinipool):
+			return outcomeAccepted
+		case err != nil:
+			return outcomeRejectedCacheInconsistent
+		}
+
+		return outcomeRejectedWrongFeeRecipient
+	}
+
+	return feeRecipientOutcome(info, err, false, func(expected common.Address) bool {
+		return strings.EqualFold(expected.String(), submitted)
+	})
+}
+
+// keymanagerFeeRecipient proxies reads of a validator's fee recipient as-is, and checks attempts to set or
+// delete it against the expected fee recipient.
+func (pr *ProxyRouter) keymanagerFeeRecipient() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			pr.proxy.ServeHTTP(w, r)
+			return
+		}
+
+		logger := pr.logger(r)
+		pr.m.CounterVec("keymanager_fee_recipient", "method").WithLabelValues(r.Method).Inc()
+		if pr.handleIfWarming(w, r) || pr.rejectIfStale(w, r) {
+			return
+		}
+
+		pubkey, err := rptypes.HexToValidatorPubkey(strings.TrimPrefix(mux.Vars(r)["pubkey"], "0x"))
+		if err != nil {
+			logger.Warn("Malformed pubkey in keymanager feerecipient request", zap.Error(err))
+			writeJSONError(w, r, http.StatusBadRequest, "invalid validator pubkey: "+err.Error())
+			return
+		}
+
+		var submitted string
+		if r.Method == http.MethodPost {
+			// Clone the request body so it can still be proxied
+			r.Body = pr.limitBody(w, r.Body)
+			buf, err := cloneRequestBody(r)
+			if isBodyTooLarge(err) {
+				pr.rejectBodyTooLarge(w, r)
+				return
+			}
+			if err != nil {
+				logger.Warn("Error cloning keymanager feerecipient request body", zap.Error(err))
+				writeInternalError(w, r)
+				return
+			}
+
+			body, err := decodeContent(r, buf)
+			if err != nil {
+				logger.Warn("Unable to decode keymanager feerecipient request body", zap.Error(err))
+				writeJSONError(w, r, http.StatusUnsupportedMediaType, err.Error())
+				return
+			}
+
+			var request setFeeRecipientRequest
+			err = json.NewDecoder(pr.limitBody(w, io.NopCloser(body))).Decode(&request)
+			if isBodyTooLarge(err) {
+				pr.rejectBodyTooLarge(w, r)
+				return
+			}
+			if err != nil || !common.IsHexAddress(request.EthAddress) {
+				logger.Warn("Malformed keymanager feerecipient request", zap.Error(err))
+				writeJSONError(w, r, http.StatusBadRequest, "ethaddress must be a hex encoded address")
+				return
+			}
+			submitted = request.EthAddress
+		}
+
+		// Grab the authorized node address
+		authedNode, ok := r.Context().Value(prContextKey("node")).([]byte)
+		if !ok {
+			logger.Warn("Unable to retrieve node address cached on request context")
+			writeInternalError(w, r)
+			return
+		}
+		authedNodeAddr := common.BytesToAddress(authedNode)
+		credential := requestCredential(r)
+		if !subjectAllowsValidator(credential, pubkey) {
+			pr.rejectOtherValidator(w, r, pubkey, nil)
+			return
+		}
+
+		// Credentials which only allow some fee recipients can't delete one, which would revert to an unchecked default
+		if allowed := requestFeeRecipients(r); len(allowed) > 0 {
+			outcome := outcomeRejectedWrongFeeRecipient
+			if r.Method == http.MethodPost {
+				outcome = allowedFeeRecipientOutcome(allowed, func(allowed common.Address) bool {
+					return strings.EqualFold(allowed.String(), submitted)
+				})
+			}
+			countValidationOutcome(pr.m, outcome)
+			if outcome != outcomeAccepted {
+				pr.rejectUnallowedFeeRecipient(w, r, pubkey, submitted, nil)
+				return
+			}
+
+			pr.proxy.ServeHTTP(w, r)
+			return
+		}
+
+		expected, err := tracedValidatorFeeRecipient(r.Context(), pr.FeeRecipients, pubkey, subjectNodeFilter(credential))
+		if lookupFailed(err) {
+			countValidationOutcome(pr.m, outcomeRejectedCacheInconsistent)
+			pr.auditCacheInconsistency(r, authedNodeAddr, err)
+			logger.Error("Unable to determine expected fee recipient", zap.String("key", pubkey.String()), zap.Error(err))
+			writeJSONError(w, r, http.StatusForbidden, "unable to determine the expected fee recipient for validator "+pubkey.String())
+			return
+		}
+
+		outcome := keymanagerFeeRecipientOutcome(r.Method, expected, err, submitted)
+		if r.Method == http.MethodPost {
+			var allowlisted *executionlayer.AllowedFeeRecipient
+			outcome, allowlisted = allowlistedOutcome(pr.m, outcome, pr.EL.AllowedFeeRecipients(), func(allowed common.Address) bool {
+				return strings.EqualFold(allowed.String(), submitted)
+			})
+			if allowlisted != nil {
+				newFeeRecipientAllowlisted(r.URL.Path, authedNodeAddr, pubkey, submitted, expected, allowlisted).log(pr.auditLogger(r))
+			}
+			outcome = unenforcedStatusOutcome(pr.m, outcome, expected, pr.UnenforcedStatuses)
+		}
+		countValidationOutcome(pr.m, outcome)
+		switch outcome {
+		case outcomeRejectedNodeMismatch, outcomeRejectedUnknownValidator:
+			pr.warnRejection(r, pubkey, "Keymanager feerecipient request for a validator which isn't one of the user's minipools",
+				zap.String("key", pubkey.String()),
+				zap.Bool("someone else's validator", errors.Is(err, feerecipient.ErrWrongNode)))
+			writeJSONError(w, r, http.StatusForbidden, "validator "+pubkey.String()+" is not one of your minipools")
+			return
+		case outcomeRejectedWrongFeeRecipient:
+			if r.Method == http.MethodDelete {
+				pr.warnRejection(r, pubkey, "Keymanager feerecipient delete for a minipool", zap.String("key", pubkey.String()))
+				writeJSONError(w, r, http.StatusConflict, "the fee recipient of validator "+pubkey.String()+" can't be deleted, it must stay "+expected.Expected.String())
+				return
+			}
+
+			newFeeRecipientRejection(r.URL.Path, authedNodeAddr, pubkey, submitted, expected).log(pr.auditLogger(r))
+			pr.warnRejection(r, pubkey, "Keymanager feerecipient set to an unexpected fee recipient", zap.String("key", pubkey.String()),
+				zap.String("expected", expected.Expected.String()), zap.String("got", submitted))
+			writeJSONError(w, r, http.StatusConflict, "validator "+pubkey.String()+" must use fee recipient "+expected.Expected.String())
+			return
+		}
+
+		pr.proxy.ServeHTTP(w, r)
+	}
+}
+
+// keymanagerDisabled refuses keymanager API requests, when passthrough is disabled
+func (pr *ProxyRouter) keymanagerDisabled() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pr.m.Counter("keymanager_disabled").Inc()
+		pr.logger(r).Debug("Refusing keymanager API request", zap.String("uri", r.RequestURI))
+		writeJSONError(w, r, http.StatusForbidden, "the keymanager API is not available through this proxy")
+	}
+}
+
+// keymanagerRoutes checks fee recipients set through the keymanager API, or refuses it entirely if
+// DisableKeymanager is set. Its other endpoints are simply proxied.
+func (pr *ProxyRouter) keymanagerRoutes(router *mux.Router) {
+	if pr.DisableKeymanager {
+		for _, path := range keymanagerPaths {
+			router.Path(path).HandlerFunc(pr.keymanagerDisabled())
+		}
+		return
+	}
+
+	router.Path(keymanagerFeeRecipientPath).HandlerFunc(timeValidation(pr.guard(GuardKeymanagerFeeRecipient, pr.keymanagerFeeRecipient())))
+}
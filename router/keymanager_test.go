@@ -0,0 +1,223 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/internal/mocks"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+var (
+	minipoolPubkey = "0x" + strings.Repeat("ab", 48)
+	unknownPubkey  = "0x" + strings.Repeat("cd", 48)
+)
+
+func TestKeymanagerFeeRecipientOutcome(t *testing.T) {
+	feeRecipient := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	info := &feerecipient.Info{Expected: feeRecipient, Source: feerecipient.SourceFeeDistributor}
+	other := "0x2222222222222222222222222222222222222222"
+
+	for _, tc := range []struct {
+		name      string
+		method    string
+		expected  *feerecipient.Info
+		err       error
+		submitted string
+		outcome   validationOutcome
+	}{
+		{"set minipool, correct fee recipient", http.MethodPost, info, nil, strings.ToLower(feeRecipient.String()), outcomeAccepted},
+		{"set minipool, wrong fee recipient", http.MethodPost, info, nil, other, outcomeRejectedWrongFeeRecipient},
+		{"set someone else's minipool", http.MethodPost, nil, feerecipient.ErrWrongNode, other, outcomeRejectedNodeMismatch},
+		{"set unknown validator", http.MethodPost, nil, feerecipient.ErrNotMinipool, other, outcomeRejectedUnknownValidator},
+		{"set inconsistent minipool", http.MethodPost, nil, feerecipient.ErrInconsistentCache, other, outcomeRejectedCacheInconsistent},
+		{"delete minipool", http.MethodDelete, info, nil, "", outcomeRejectedWrongFeeRecipient},
+		{"delete someone else's minipool", http.MethodDelete, nil, feerecipient.ErrWrongNode, "", outcomeRejectedNodeMismatch},
+		{"delete unknown validator", http.MethodDelete, nil, feerecipient.ErrNotMinipool, "", outcomeAccepted},
+		{"delete inconsistent minipool", http.MethodDelete, nil, feerecipient.ErrInconsistentCache, "", outcomeRejectedCacheInconsistent},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if outcome := keymanagerFeeRecipientOutcome(tc.method, tc.expected, tc.err, tc.submitted); outcome != tc.outcome {
+				t.Fatalf("expected %s, got %s", tc.outcome, outcome)
+			}
+		})
+	}
+}
+
+// keymanagerRouter routes like Init does, proxying everything else to bn.
+// Unless pr has one, its EL is warm, but knows no validators.
+func keymanagerRouter(t *testing.T, pr *ProxyRouter, bn *fakeBeaconNode) http.Handler {
+	if pr.EL == nil {
+		pr.EL = mocks.NewMockExecutionLayer(common.Address{})
+	}
+	pr.Logger = zap.NewNop()
+	pr.m = metrics.NewMetricsRegistry("http_proxy")
+	pr.proxy = newReverseProxy(testUpstreams(t, bn.url))
+
+	router := mux.NewRouter()
+	pr.keymanagerRoutes(router)
+	router.PathPrefix("/").Handler(pr.proxy)
+	return router
+}
+
+func TestKeymanagerGetFeeRecipient(t *testing.T) {
+	testMetrics(t)
+	bn := newFakeBeaconNode(t, "bn")
+	router := keymanagerRouter(t, &ProxyRouter{}, bn)
+
+	// Reads are proxied without consulting the EL, whoever's validator it is
+	for _, pubkey := range []string{minipoolPubkey, unknownPubkey} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/eth/v1/validator/"+pubkey+"/feerecipient", nil))
+		if w.Code != http.StatusOK || w.Body.String() != "bn" {
+			t.Fatalf("expected the read of %s to be proxied, got %d %q", pubkey, w.Code, w.Body.String())
+		}
+	}
+	if bn.requests.Load() != 2 {
+		t.Fatalf("expected 2 upstream requests, got %d", bn.requests.Load())
+	}
+}
+
+func TestKeymanagerMalformedSetFeeRecipient(t *testing.T) {
+	testMetrics(t)
+	bn := newFakeBeaconNode(t, "bn")
+	router := keymanagerRouter(t, &ProxyRouter{}, bn)
+
+	for _, body := range []string{"", "{", `{"ethaddress": "0x1234"}`, `{"fee_recipient": "0x1111111111111111111111111111111111111111"}`} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/eth/v1/validator/"+minipoolPubkey+"/feerecipient", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for body %q, got %d", body, w.Code)
+		}
+	}
+	if bn.requests.Load() != 0 {
+		t.Fatalf("expected malformed requests not to be proxied, got %d", bn.requests.Load())
+	}
+}
+
+func TestKeymanagerBodyTooLarge(t *testing.T) {
+	testMetrics(t)
+	bn := newFakeBeaconNode(t, "bn")
+	router := keymanagerRouter(t, &ProxyRouter{MaxGuardedBodySize: 64}, bn)
+
+	body := `{"ethaddress": "0x1111111111111111111111111111111111111111"}` + strings.Repeat(" ", 64)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/eth/v1/validator/"+minipoolPubkey+"/feerecipient", strings.NewReader(body)))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", w.Code)
+	}
+	if bn.requests.Load() != 0 {
+		t.Fatalf("expected the oversized request not to be proxied, got %d", bn.requests.Load())
+	}
+}
+
+func TestKeymanagerDisabled(t *testing.T) {
+	testMetrics(t)
+	bn := newFakeBeaconNode(t, "bn")
+	router := keymanagerRouter(t, &ProxyRouter{DisableKeymanager: true}, bn)
+
+	for _, tc := range []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/eth/v1/validator/" + minipoolPubkey + "/feerecipient"},
+		{http.MethodPost, "/eth/v1/validator/" + minipoolPubkey + "/feerecipient"},
+		{http.MethodDelete, "/eth/v1/validator/" + unknownPubkey + "/feerecipient"},
+		{http.MethodGet, "/eth/v1/keystores"},
+		{http.MethodPost, "/eth/v1/remotekeys"},
+		{http.MethodPost, "/eth/v1/validator/" + unknownPubkey + "/gas_limit"},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, strings.NewReader("{}")))
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("expected %s %s to be refused, got %d", tc.method, tc.path, w.Code)
+		}
+	}
+	if bn.requests.Load() != 0 {
+		t.Fatalf("expected no keymanager requests to be proxied, got %d", bn.requests.Load())
+	}
+
+	// Beacon node endpoints under /eth/v1/validator aren't affected
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/eth/v1/validator/duties/proposer/1", nil))
+	if w.Code != http.StatusOK || bn.requests.Load() != 1 {
+		t.Fatalf("expected the beacon node endpoint to be proxied, got %d", w.Code)
+	}
+}
+
+func TestKeymanagerPassthrough(t *testing.T) {
+	testMetrics(t)
+	bn := newFakeBeaconNode(t, "bn")
+	router := keymanagerRouter(t, &ProxyRouter{}, bn)
+
+	// Other keymanager endpoints are proxied as-is
+	for _, path := range []string{"/eth/v1/keystores", "/eth/v1/remotekeys", "/eth/v1/validator/" + unknownPubkey + "/gas_limit"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected %s to be proxied, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestKeymanagerAllowedFeeRecipients(t *testing.T) {
+	testMetrics(t)
+	bn := newFakeBeaconNode(t, "bn")
+	router := keymanagerRouter(t, &ProxyRouter{}, bn)
+	allowed := common.HexToAddress("0x1111111111111111111111111111111111111111")
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		body   string
+		code   int
+	}{
+		{"allowed fee recipient", http.MethodPost, `{"ethaddress": "` + strings.ToLower(allowed.String()) + `"}`, http.StatusOK},
+		{"other fee recipient", http.MethodPost, `{"ethaddress": "0x2222222222222222222222222222222222222222"}`, http.StatusConflict},
+		{"delete", http.MethodDelete, "", http.StatusConflict},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// The EL isn't consulted, so it doesn't matter whose validator it is
+			r := httptest.NewRequest(tc.method, "/eth/v1/validator/"+unknownPubkey+"/feerecipient", strings.NewReader(tc.body))
+			ctx := context.WithValue(r.Context(), prContextKey("node"), common.Address{}.Bytes())
+			ctx = context.WithValue(ctx, prContextKey("fee_recipients"), []common.Address{allowed})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r.WithContext(ctx))
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d", tc.code, w.Code)
+			}
+		})
+	}
+	if bn.requests.Load() != 1 {
+		t.Fatalf("expected only the allowed fee recipient to be proxied, got %d", bn.requests.Load())
+	}
+}
+
+func TestKeymanagerSetFeeRecipientWarming(t *testing.T) {
+	testMetrics(t)
+	bn := newFakeBeaconNode(t, "bn")
+	el := mocks.NewMockExecutionLayer(common.Address{})
+	el.SetWarming(true)
+	router := keymanagerRouter(t, &ProxyRouter{EL: el}, bn)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/eth/v1/validator/"+minipoolPubkey+"/feerecipient", strings.NewReader(`{"ethaddress": "0x1111111111111111111111111111111111111111"}`)))
+	if w.Code != http.StatusServiceUnavailable || bn.requests.Load() != 0 {
+		t.Fatalf("expected the fee recipient not to be set while the cache is warming up, got %d", w.Code)
+	}
+
+	// Reads don't need the cache
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/eth/v1/validator/"+minipoolPubkey+"/feerecipient", nil))
+	if w.Code != http.StatusOK || bn.requests.Load() != 1 {
+		t.Fatalf("expected the read to be proxied, got %d", w.Code)
+	}
+}
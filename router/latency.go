@@ -0,0 +1,114 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// The outcomes request latencies are labelled with
+const (
+	// The request's fee recipients were checked, and it was proxied
+	latencyValidatedAccepted = "validated-accepted"
+	// The request's fee recipients were checked, and it was refused
+	latencyValidatedRejected = "validated-rejected"
+	// The request wasn't checked
+	latencyPassthrough = "passthrough"
+)
+
+// requestTiming is filled in while a request is handled, to split its latency between our validation and the beacon node.
+// Requests are handled and proxied on one goroutine, so it isn't locked.
+type requestTiming struct {
+	validating      bool
+	validationStart time.Time
+	// Set once validation has finished, by the request being proxied or refused
+	validation time.Duration
+	validated  bool
+
+	proxied  bool
+	upstream time.Duration
+}
+
+func withRequestTiming(ctx context.Context) (context.Context, *requestTiming) {
+	timing := &requestTiming{}
+	return context.WithValue(ctx, prContextKey("request_timing"), timing), timing
+}
+
+// requestTimingFrom returns the timing of a request, or nil if it isn't being timed. Its methods may be called on nil.
+func requestTimingFrom(ctx context.Context) *requestTiming {
+	timing, _ := ctx.Value(prContextKey("request_timing")).(*requestTiming)
+	return timing
+}
+
+func (t *requestTiming) startValidation() {
+	if t != nil {
+		t.validating = true
+		t.validationStart = time.Now()
+	}
+}
+
+// endValidation records how long validation took, the first time it's called after startValidation
+func (t *requestTiming) endValidation() {
+	if t != nil && t.validating && !t.validated {
+		t.validated = true
+		t.validation = time.Since(t.validationStart)
+	}
+}
+
+// addUpstream records time spent waiting on the beacon node. Retries on another one add up.
+func (t *requestTiming) addUpstream(d time.Duration) {
+	if t != nil {
+		t.proxied = true
+		t.upstream += d
+	}
+}
+
+func (t *requestTiming) outcome() string {
+	switch {
+	case !t.validating:
+		return latencyPassthrough
+	case t.proxied:
+		return latencyValidatedAccepted
+	default:
+		return latencyValidatedRejected
+	}
+}
+
+// latencyMiddleware times each request, labelled by its route and outcome.
+// request_duration_seconds is the whole time the proxy spent on it, validation_duration_seconds the part of that
+// spent checking its fee recipients, including looking validators up, and upstream_duration_seconds the part spent
+// waiting for the beacon node to respond to the proxied request.
+func (pr *ProxyRouter) latencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx, timing := withRequestTiming(r.Context())
+		next.ServeHTTP(w, r.WithContext(ctx))
+
+		route := classifyRoute(r.URL.Path)
+		outcome := timing.outcome()
+		pr.m.HistogramVec("request_duration_seconds", "route", "outcome").WithLabelValues(route, outcome).Observe(time.Since(start).Seconds())
+		if timing.validating {
+			timing.endValidation()
+			pr.m.HistogramVec("validation_duration_seconds", "route", "outcome").WithLabelValues(route, outcome).Observe(timing.validation.Seconds())
+		}
+		if timing.proxied {
+			pr.m.HistogramVec("upstream_duration_seconds", "route", "outcome").WithLabelValues(route, outcome).Observe(timing.upstream.Seconds())
+		}
+	})
+}
+
+// timeValidation times a handler which checks fee recipients before proxying the request,
+// until the request is proxied or the handler returns. GET requests are only proxied, so they aren't.
+func timeValidation(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+
+		timing := requestTimingFrom(r.Context())
+		timing.startValidation()
+		next(w, r)
+		timing.endValidation()
+	}
+}
@@ -0,0 +1,66 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/auth"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// histogramCount returns how many observations one of pr's histograms has with the given route and outcome
+func histogramCount(t *testing.T, pr *ProxyRouter, name string, route string, outcome string) uint64 {
+	t.Helper()
+
+	m := &dto.Metric{}
+	h := pr.m.HistogramVec(name, "route", "outcome").WithLabelValues(route, outcome).(prometheus.Histogram)
+	if err := h.Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestLatencyHistograms(t *testing.T) {
+	testMetrics(t)
+	bn := newFakeBeaconNode(t, "bn")
+	pr, _ := guardedRouter(t, &ProxyRouter{SkipStatusCheck: true}, bn)
+	guarded := pr.latencyMiddleware(timeValidation(pr.registerValidator()))
+	passthrough := pr.latencyMiddleware(pr.proxy)
+
+	for _, body := range []string{
+		registration(testValidatorPubkey(0x01), testFeeDistributor),
+		registration(testValidatorPubkey(0x01), testWrongRecipient),
+		registration(testValidatorPubkey(0x01), testWrongRecipient),
+	} {
+		guarded.ServeHTTP(httptest.NewRecorder(), guardedRequest(registerValidatorPath, body, auth.OperatorRocketPool))
+	}
+	passthrough.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/eth/v1/beacon/states/head/validators/1234", nil))
+
+	stateRoute := "/eth/v1/beacon/states/{state_id}/validators/{validator_id}"
+	for _, tc := range []struct {
+		name    string
+		route   string
+		outcome string
+		count   uint64
+	}{
+		{"request_duration_seconds", registerValidatorPath, latencyValidatedAccepted, 1},
+		{"validation_duration_seconds", registerValidatorPath, latencyValidatedAccepted, 1},
+		{"upstream_duration_seconds", registerValidatorPath, latencyValidatedAccepted, 1},
+		{"request_duration_seconds", registerValidatorPath, latencyValidatedRejected, 2},
+		{"validation_duration_seconds", registerValidatorPath, latencyValidatedRejected, 2},
+		// Rejected requests never reach the beacon node
+		{"upstream_duration_seconds", registerValidatorPath, latencyValidatedRejected, 0},
+		{"request_duration_seconds", stateRoute, latencyPassthrough, 1},
+		{"upstream_duration_seconds", stateRoute, latencyPassthrough, 1},
+		{"validation_duration_seconds", stateRoute, latencyPassthrough, 0},
+	} {
+		if count := histogramCount(t, pr, tc.name, tc.route, tc.outcome); count != tc.count {
+			t.Errorf("expected %d observations of %s for %s %s, got %d", tc.count, tc.name, tc.route, tc.outcome, count)
+		}
+	}
+	if bn.requests.Load() != 2 {
+		t.Fatalf("expected 2 requests to be proxied, got %d", bn.requests.Load())
+	}
+}
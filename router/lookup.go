@@ -0,0 +1,81 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/mux"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+	"go.uber.org/zap"
+)
+
+// The proxy's own endpoints for its users, as opposed to the beacon API, live under this prefix
+const rescuePathPrefix = "/rescue/"
+
+// Where node operators can ask which fee recipient the proxy expects a validator to use
+const feeRecipientLookupPath = "/rescue/v1/fee_recipient/{pubkey}"
+
+// feeRecipientLookupResponse is the body of a successful fee recipient lookup
+type feeRecipientLookupResponse struct {
+	Pubkey       string `json:"pubkey"`
+	FeeRecipient string `json:"fee_recipient"`
+	Source       string `json:"source"`
+	NodeAddress  string `json:"node_address,omitempty"`
+}
+
+// feeRecipientLookup replies with the fee recipient the proxy expects a validator to use, so node operators
+// can check it with curl. It answers for any validator the fee recipient sources know, not only the
+// authenticated node's, as the gRPC API's GetValidatorFeeRecipient does.
+func (pr *ProxyRouter) feeRecipientLookup() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := pr.logger(r)
+		result := pr.m.CounterVec("fee_recipient_lookup", "result")
+
+		pubkey, err := rptypes.HexToValidatorPubkey(strings.TrimPrefix(mux.Vars(r)["pubkey"], "0x"))
+		if err != nil {
+			result.WithLabelValues("invalid").Inc()
+			writeJSONError(w, r, http.StatusBadRequest, "invalid validator pubkey: "+err.Error())
+			return
+		}
+
+		if pr.EL.Warming() {
+			result.WithLabelValues("warming").Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(warmupRetryAfterSeconds))
+			writeJSONError(w, r, http.StatusServiceUnavailable, "the fee recipient cache is warming up, try again later")
+			return
+		}
+
+		info, err := tracedValidatorFeeRecipient(r.Context(), pr.FeeRecipients, pubkey, nil)
+		if errors.Is(err, feerecipient.ErrNotMinipool) {
+			result.WithLabelValues("not_found").Inc()
+			writeJSONError(w, r, http.StatusNotFound, "validator "+pubkey.String()+" is not known to the proxy")
+			return
+		}
+		if err != nil {
+			result.WithLabelValues("error").Inc()
+			logger.Warn("Unable to look up expected fee recipient", zap.String("key", pubkey.String()), zap.Error(err))
+			writeJSONError(w, r, http.StatusInternalServerError, "unable to determine the expected fee recipient for validator "+pubkey.String())
+			return
+		}
+
+		result.WithLabelValues("ok").Inc()
+		response := feeRecipientLookupResponse{
+			Pubkey:       "0x" + pubkey.Hex(),
+			FeeRecipient: info.Expected.String(),
+			Source:       info.Source.String(),
+		}
+		if info.NodeAddress != (common.Address{}) {
+			response.NodeAddress = info.NodeAddress.String()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&response); err != nil {
+			logger.Warn("Error writing fee recipient lookup response", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestFeeRecipientLookup(t *testing.T) {
+	testMetrics(t)
+	bn := newFakeBeaconNode(t, "bn")
+	pr, el := guardedRouter(t, &ProxyRouter{}, bn)
+	router := mux.NewRouter()
+	router.Path(feeRecipientLookupPath).Methods(http.MethodGet).HandlerFunc(pr.feeRecipientLookup())
+
+	lookup := func(pubkey string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, strings.Replace(feeRecipientLookupPath, "{pubkey}", pubkey, 1), nil))
+		return w
+	}
+
+	for _, tc := range []struct {
+		name     string
+		pubkey   string
+		code     int
+		expected feeRecipientLookupResponse
+	}{
+		{"fee distributor", "0x" + testValidatorPubkey(0x01).Hex(), http.StatusOK, feeRecipientLookupResponse{
+			Pubkey: "0x" + testValidatorPubkey(0x01).Hex(), FeeRecipient: testFeeDistributor.String(), Source: "fee_distributor", NodeAddress: testNode.String(),
+		}},
+		// Other nodes' validators can be looked up too, and the 0x prefix is optional
+		{"smoothing pool", testValidatorPubkey(0x02).Hex(), http.StatusOK, feeRecipientLookupResponse{
+			Pubkey: "0x" + testValidatorPubkey(0x02).Hex(), FeeRecipient: testSmoothingPool.String(), Source: "smoothing_pool", NodeAddress: testOtherNode.String(),
+		}},
+		{"not a minipool", "0x" + testValidatorPubkey(0x04).Hex(), http.StatusNotFound, feeRecipientLookupResponse{}},
+		{"inconsistent cache", "0x" + testValidatorPubkey(0x03).Hex(), http.StatusInternalServerError, feeRecipientLookupResponse{}},
+		{"invalid pubkey", "0x1234", http.StatusBadRequest, feeRecipientLookupResponse{}},
+	} {
+		w := lookup(tc.pubkey)
+		if w.Code != tc.code {
+			t.Fatalf("%s: expected %d, got %d %s", tc.name, tc.code, w.Code, w.Body.String())
+		}
+		if tc.code != http.StatusOK {
+			continue
+		}
+
+		var got feeRecipientLookupResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.expected {
+			t.Fatalf("%s: expected %+v, got %+v", tc.name, tc.expected, got)
+		}
+	}
+
+	// Until the cache is warm, there's nothing to look up
+	el.SetWarming(true)
+	if w := lookup("0x" + testValidatorPubkey(0x01).Hex()); w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 503 with a Retry-After while warming up, got %d", w.Code)
+	}
+}
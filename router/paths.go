@@ -0,0 +1,114 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// PathPolicyMode decides whether requests for paths on neither the allowlist nor the denylist are proxied
+type PathPolicyMode string
+
+const (
+	// PathDefaultAllow proxies paths unless they're denylisted
+	PathDefaultAllow PathPolicyMode = "default-allow"
+	// PathDefaultDeny refuses paths unless they're allowlisted
+	PathDefaultDeny PathPolicyMode = "default-deny"
+)
+
+// DefaultAllowedPaths lists the beacon API prefixes validator clients need, which are allowed by default
+var DefaultAllowedPaths = []string{
+	"/eth/v1/beacon",
+	"/eth/v1/config",
+	"/eth/v1/events",
+	"/eth/v1/node",
+	"/eth/v1/validator",
+	"/eth/v2/beacon",
+	"/eth/v2/validator",
+	"/eth/v3/validator",
+}
+
+// PathPolicy decides which beacon API paths are proxied. A prefix matches the path itself and
+// everything beneath it, so /eth/v1/node matches /eth/v1/node/version but not /eth/v1/nodes.
+// The longest matching prefix in either list wins, and deny wins ties, so an allowed prefix can
+// have a denied subtree and vice versa. Paths which match neither list are handled according to Mode.
+type PathPolicy struct {
+	Mode  PathPolicyMode
+	Allow []string
+	Deny  []string
+}
+
+// ParsePathPolicyMode converts a flag value to a PathPolicyMode
+func ParsePathPolicyMode(s string) (PathPolicyMode, error) {
+	switch mode := PathPolicyMode(s); mode {
+	case PathDefaultAllow, PathDefaultDeny:
+		return mode, nil
+	}
+
+	return "", fmt.Errorf("unknown mode %q, expected default-allow or default-deny", s)
+}
+
+// ParsePathPrefixes parses a comma-separated list of path prefixes, eg, /eth/v1/node,/eth/v1/config
+func ParsePathPrefixes(s string) ([]string, error) {
+	out := []string{}
+	if strings.TrimSpace(s) == "" {
+		return out, nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		prefix := strings.TrimSpace(entry)
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("invalid path %q, paths must start with /", prefix)
+		}
+		out = append(out, prefix)
+	}
+
+	return out, nil
+}
+
+// longestMatch returns the length of the longest prefix in prefixes matching p, or -1 if none do
+func longestMatch(prefixes []string, p string) int {
+	out := -1
+	for _, prefix := range prefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if p != prefix && !strings.HasPrefix(p, prefix+"/") {
+			continue
+		}
+		if len(prefix) > out {
+			out = len(prefix)
+		}
+	}
+	return out
+}
+
+// Allowed returns whether requests for p should be proxied
+func (pp *PathPolicy) Allowed(p string) bool {
+	// Clean the path first, so dot segments can't be used to climb out of an allowed prefix
+	p = path.Clean("/" + p)
+
+	allow := longestMatch(pp.Allow, p)
+	deny := longestMatch(pp.Deny, p)
+	if allow < 0 && deny < 0 {
+		return pp.Mode != PathDefaultDeny
+	}
+	return allow > deny
+}
+
+// pathPolicyMiddleware refuses requests for paths the PathPolicy doesn't allow with 403, before they're proxied.
+// The proxy's own /_/ and /rescue/ endpoints aren't beacon API paths, so they're always allowed.
+func (pr *ProxyRouter) pathPolicyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := path.Clean("/" + r.URL.Path)
+		if strings.HasPrefix(p, "/_/") || strings.HasPrefix(p, rescuePathPrefix) || pr.PathPolicy.Allowed(p) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		pr.m.Counter("path_denied").Inc()
+		pr.logger(r).Debug("Refusing request for a path the policy doesn't allow", zap.String("path", r.URL.Path))
+		writeJSONError(w, r, http.StatusForbidden, "this endpoint is not available through the proxy")
+	})
+}
@@ -0,0 +1,116 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+func TestPathPolicyDefaultDeny(t *testing.T) {
+	pp := PathPolicy{Mode: PathDefaultDeny, Allow: DefaultAllowedPaths}
+
+	for _, tc := range []struct {
+		path    string
+		allowed bool
+	}{
+		{"/eth/v1/node/version", true},
+		{"/eth/v1/events", true},
+		{prepareBeaconProposerPath, true},
+		{registerValidatorPath, true},
+		{"/eth/v2/beacon/blocks/head", true},
+		{"/eth/v3/validator/blocks/1", true},
+		// Client-specific endpoints aren't part of the standard API
+		{"/lighthouse/health", false},
+		{"/lighthouse/validator_inclusion/1/global", false},
+		{"/teku/v1/admin/liveness", false},
+		{"/nimbus/v1/chain/head", false},
+		{"/eth/v1/debug/beacon/heads", false},
+		// Prefixes only match whole path segments
+		{"/eth/v1/nodes", false},
+		// Dot segments can't climb out of an allowed prefix
+		{"/eth/v1/node/../../../lighthouse/health", false},
+		{"/", false},
+	} {
+		if allowed := pp.Allowed(tc.path); allowed != tc.allowed {
+			t.Errorf("expected Allowed(%s) to be %v, got %v", tc.path, tc.allowed, allowed)
+		}
+	}
+}
+
+func TestPathPolicyDefaultAllow(t *testing.T) {
+	pp := PathPolicy{
+		Mode:  PathDefaultAllow,
+		Allow: []string{"/eth/v1/debug/fork_choice"},
+		Deny:  []string{"/eth/v1/debug", "/lighthouse/"},
+	}
+
+	for _, tc := range []struct {
+		path    string
+		allowed bool
+	}{
+		{"/eth/v1/node/version", true},
+		{"/teku/v1/admin/liveness", true},
+		{"/lighthouse/health", false},
+		{"/eth/v1/debug/beacon/heads", false},
+		// The longer allowed prefix wins
+		{"/eth/v1/debug/fork_choice", true},
+	} {
+		if allowed := pp.Allowed(tc.path); allowed != tc.allowed {
+			t.Errorf("expected Allowed(%s) to be %v, got %v", tc.path, tc.allowed, allowed)
+		}
+	}
+
+	// Deny wins ties
+	pp = PathPolicy{Mode: PathDefaultAllow, Allow: []string{"/eth/v1/node"}, Deny: []string{"/eth/v1/node/"}}
+	if pp.Allowed("/eth/v1/node/version") {
+		t.Fatal("expected the denylist to win a tie")
+	}
+}
+
+func TestPathPolicyMiddleware(t *testing.T) {
+	testMetrics(t)
+	pr := &ProxyRouter{
+		Logger:     zap.NewNop(),
+		PathPolicy: PathPolicy{Mode: PathDefaultDeny, Allow: DefaultAllowedPaths},
+		m:          metrics.NewMetricsRegistry("http_proxy"),
+	}
+	proxied := 0
+	handler := pr.pathPolicyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied++
+	}))
+
+	request := func(path string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	if code := request("/eth/v1/node/syncing"); code != http.StatusOK {
+		t.Fatalf("expected a standard endpoint to be proxied, got %d", code)
+	}
+	if code := request("/lighthouse/health"); code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a lighthouse endpoint, got %d", code)
+	}
+	if code := request("/teku/v1/admin/liveness"); code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a teku endpoint, got %d", code)
+	}
+
+	// The proxy's own endpoints are always allowed
+	if code := request("/_/healthz"); code != http.StatusOK {
+		t.Fatalf("expected /_/healthz to be allowed, got %d", code)
+	}
+	if code := request("/rescue/v1/fee_recipient/0x01"); code != http.StatusOK {
+		t.Fatalf("expected /rescue/ endpoints to be allowed, got %d", code)
+	}
+
+	if proxied != 3 {
+		t.Fatalf("expected 3 requests to reach the handler, got %d", proxied)
+	}
+	if got := testutil.ToFloat64(pr.m.Counter("path_denied")); got != 2 {
+		t.Fatalf("expected 2 denied requests, got %v", got)
+	}
+}
@@ -0,0 +1,196 @@
+package router
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// How long a bucket may go unused before it's forgotten
+const rateLimiterIdleTimeout = 10 * time.Minute
+
+type rateLimiterBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter is a set of token buckets, one per key
+type rateLimiter struct {
+	sync.Mutex
+	limit     rate.Limit
+	burst     int
+	buckets   map[string]*rateLimiterBucket
+	lastSweep time.Time
+}
+
+// newRateLimiter creates a rateLimiter which allows limit requests per second per key, in bursts of up to burst.
+// A limit of 0 disables rate limiting, and returns nil.
+func newRateLimiter(limit float64, burst int) *rateLimiter {
+	if limit <= 0 {
+		return nil
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		limit:   rate.Limit(limit),
+		burst:   burst,
+		buckets: make(map[string]*rateLimiterBucket),
+	}
+}
+
+// allow takes a token from key's bucket. If there are none, it returns false and how long until there will be.
+func (rl *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	if rl == nil {
+		return true, 0
+	}
+
+	rl.Lock()
+	defer rl.Unlock()
+
+	if now.Sub(rl.lastSweep) > rateLimiterIdleTimeout {
+		for k, bucket := range rl.buckets {
+			if now.Sub(bucket.lastSeen) > rateLimiterIdleTimeout {
+				delete(rl.buckets, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	bucket, ok := rl.buckets[key]
+	if !ok {
+		bucket = &rateLimiterBucket{limiter: rate.NewLimiter(rl.limit, rl.burst)}
+		rl.buckets[key] = bucket
+	}
+	bucket.lastSeen = now
+
+	reservation := bucket.limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay == 0 {
+		return true, 0
+	}
+
+	// Don't hold on to tokens for requests that are rejected
+	reservation.CancelAt(now)
+	return false, delay
+}
+
+// exhausted returns whether key's bucket is empty, and if so how long until it won't be, without taking a token
+func (rl *rateLimiter) exhausted(key string, now time.Time) (bool, time.Duration) {
+	if rl == nil {
+		return false, 0
+	}
+
+	rl.Lock()
+	defer rl.Unlock()
+
+	bucket, ok := rl.buckets[key]
+	if !ok {
+		return false, 0
+	}
+
+	reservation := bucket.limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	reservation.CancelAt(now)
+	return delay > 0, delay
+}
+
+// writeRateLimited replies 429, telling the client how many seconds to wait before retrying
+func writeRateLimited(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeJSONError(w, r, http.StatusTooManyRequests, "rate limit exceeded")
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// limitGuarded rate limits a guarded endpoint by the subject of the request's credential, usually a node address,
+// before next reads the request body.
+// It must be installed behind the authentication middleware.
+func (pr *ProxyRouter) limitGuarded(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(prContextKey("node")).([]byte); !ok {
+			pr.logger(r).Warn("Unable to retrieve node address cached on request context")
+			writeInternalError(w, r)
+			return
+		}
+
+		subject := requestCredential(r).SubjectKey()
+		allowed, retryAfter := pr.live.Load().guardedLimiter.allow(subject, time.Now())
+		if !allowed {
+			pr.m.CounterVec("rate_limited", "bucket").WithLabelValues("credential").Inc()
+			pr.logger(r).Debug("Rate limited guarded request", zap.String("uri", r.RequestURI),
+				zap.String("subject", subject))
+			writeRateLimited(w, r, retryAfter)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// ipRateLimitMiddleware rate limits requests to unguarded endpoints by remote IP, before they're authenticated.
+// Guarded endpoints are limited per credential instead, by limitGuarded.
+func (pr *ProxyRouter) ipRateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isGuardedPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := remoteIP(r)
+		allowed, retryAfter := pr.live.Load().ipLimiter.allow(ip, time.Now())
+		if !allowed {
+			pr.m.CounterVec("rate_limited", "bucket").WithLabelValues("ip").Inc()
+			pr.logger(r).Debug("Rate limited request", zap.String("uri", r.RequestURI), zap.String("ip", ip))
+			writeRateLimited(w, r, retryAfter)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authFailureLimiter limits failed authentications per remote IP, on every authenticated endpoint.
+// Checking a password may cost a bcrypt comparison, so guarded endpoints, which skip the per-IP limit, need one too.
+func (pr *ProxyRouter) authFailureLimiter() *rateLimiter {
+	l := pr.live.Load()
+	if l == nil {
+		return nil
+	}
+	return l.authFailureLimiter
+}
+
+// limitAuthFailures replies 429 and returns false if the request's IP has failed to authenticate too often,
+// before its credential is checked
+func (pr *ProxyRouter) limitAuthFailures(w http.ResponseWriter, r *http.Request) bool {
+	ip := remoteIP(r)
+	exhausted, retryAfter := pr.authFailureLimiter().exhausted(ip, time.Now())
+	if !exhausted {
+		return true
+	}
+
+	pr.m.CounterVec("rate_limited", "bucket").WithLabelValues("auth_failure").Inc()
+	pr.logger(r).Debug("Rate limited request after failed authentications", zap.String("uri", r.RequestURI),
+		zap.String("ip", ip))
+	writeRateLimited(w, r, retryAfter)
+	return false
+}
+
+// authFailed takes a token from the request's IP's bucket of failed authentications
+func (pr *ProxyRouter) authFailed(r *http.Request) {
+	pr.authFailureLimiter().allow(remoteIP(r), time.Now())
+}
@@ -0,0 +1,143 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+func TestRateLimiterBurst(t *testing.T) {
+	rl := newRateLimiter(1, 3)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := rl.allow("a", now); !ok {
+			t.Fatalf("expected request %d to be allowed", i)
+		}
+	}
+
+	ok, retryAfter := rl.allow("a", now)
+	if ok {
+		t.Fatal("expected the request after the burst to be limited")
+	}
+	if retryAfter <= 0 || retryAfter > time.Second {
+		t.Fatalf("unexpected retry after %v", retryAfter)
+	}
+
+	// Buckets are independent
+	if ok, _ := rl.allow("b", now); !ok {
+		t.Fatal("expected another key to be allowed")
+	}
+
+	// Rejected requests don't use up tokens, so one is available a second later
+	if ok, _ := rl.allow("a", now.Add(time.Second)); !ok {
+		t.Fatal("expected the bucket to refill")
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	rl := newRateLimiter(0, 10)
+	if rl != nil {
+		t.Fatal("expected a 0 limit to disable rate limiting")
+	}
+	for i := 0; i < 100; i++ {
+		if ok, _ := rl.allow("a", time.Now()); !ok {
+			t.Fatal("expected a disabled limiter to allow every request")
+		}
+	}
+}
+
+func TestRateLimiterForgetsIdleBuckets(t *testing.T) {
+	rl := newRateLimiter(1, 1)
+	now := time.Now()
+	rl.allow("a", now)
+	rl.allow("b", now.Add(2*rateLimiterIdleTimeout))
+
+	if _, ok := rl.buckets["a"]; ok {
+		t.Fatal("expected the idle bucket to be forgotten")
+	}
+	if len(rl.buckets) != 1 {
+		t.Fatalf("expected 1 bucket, got %d", len(rl.buckets))
+	}
+}
+
+func TestLimitGuarded(t *testing.T) {
+	testMetrics(t)
+	pr := &ProxyRouter{
+		Logger: zap.NewNop(),
+		m:      metrics.NewMetricsRegistry("http_proxy"),
+	}
+	pr.live.Store(&live{guardedLimiter: newRateLimiter(0.1, 1)})
+	called := 0
+	handler := pr.limitGuarded(func(w http.ResponseWriter, r *http.Request) {
+		called++
+	})
+
+	request := func(node byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, registerValidatorPath, nil)
+		r = r.WithContext(context.WithValue(r.Context(), prContextKey("node"), []byte{node}))
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	if w := request(1); w.Code != http.StatusOK {
+		t.Fatalf("expected the first request to be allowed, got %d", w.Code)
+	}
+	w := request(1)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "10" {
+		t.Fatalf("expected Retry-After 10, got %q", w.Header().Get("Retry-After"))
+	}
+	if w := request(2); w.Code != http.StatusOK {
+		t.Fatalf("expected another node to be allowed, got %d", w.Code)
+	}
+
+	if called != 2 {
+		t.Fatalf("expected the handler to be called twice, got %d", called)
+	}
+	if got := testutil.ToFloat64(pr.m.CounterVec("rate_limited", "bucket").WithLabelValues("credential")); got != 1 {
+		t.Fatalf("expected 1 throttled request, got %v", got)
+	}
+}
+
+func TestIPRateLimitMiddleware(t *testing.T) {
+	testMetrics(t)
+	pr := &ProxyRouter{
+		Logger: zap.NewNop(),
+		m:      metrics.NewMetricsRegistry("http_proxy"),
+	}
+	pr.live.Store(&live{ipLimiter: newRateLimiter(1, 1)})
+	handler := pr.ipRateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(path string) int {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := request("/eth/v1/node/version"); code != http.StatusOK {
+		t.Fatalf("expected the first request to be allowed, got %d", code)
+	}
+	if code := request("/eth/v1/node/version"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", code)
+	}
+
+	// Guarded endpoints are limited per credential instead
+	if code := request(prepareBeaconProposerPath); code != http.StatusOK {
+		t.Fatalf("expected guarded requests to skip the per-IP limit, got %d", code)
+	}
+	if got := testutil.ToFloat64(pr.m.CounterVec("rate_limited", "bucket").WithLabelValues("ip")); got != 1 {
+		t.Fatalf("expected 1 throttled request, got %v", got)
+	}
+}
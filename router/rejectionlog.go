@@ -0,0 +1,113 @@
+package router
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/auth"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+	"go.uber.org/zap"
+)
+
+// DefaultRejectionLogWindow is how often an identical rejection is logged, by default
+const DefaultRejectionLogWindow = 5 * time.Minute
+
+// How many distinct rejections are remembered. Past that, ones which haven't been logged for a window are forgotten,
+// along with how many times they were suppressed, and if that isn't enough, new ones are logged without being remembered.
+const maxSampledRejections = 10000
+
+// rejectionKey identifies identical rejections: the same validator, refused for the same reason, by the same credential subject
+type rejectionKey struct {
+	subject string
+	pubkey  rptypes.ValidatorPubkey
+	reason  string
+}
+
+type sampledRejection struct {
+	logged     time.Time
+	suppressed int
+}
+
+// rejectionSampler deduplicates the log lines of rejected validators, since a misconfigured validator client retries
+// every slot. The first of identical rejections is logged, then at most one per window, saying how many were suppressed
+// since the last. Only the operational log is sampled; audit records are always written.
+type rejectionSampler struct {
+	sync.Mutex
+	window time.Duration
+	seen   map[rejectionKey]*sampledRejection
+	// Replaceable for testing
+	now func() time.Time
+}
+
+// newRejectionSampler creates a rejectionSampler which logs identical rejections at most once per window.
+// If window isn't positive, it returns nil, which logs every rejection.
+func newRejectionSampler(window time.Duration) *rejectionSampler {
+	if window <= 0 {
+		return nil
+	}
+
+	return &rejectionSampler{
+		window: window,
+		seen:   make(map[rejectionKey]*sampledRejection),
+		now:    time.Now,
+	}
+}
+
+// sample reports whether a rejection should be logged, and if so, how many identical ones were suppressed since the last was
+func (s *rejectionSampler) sample(key rejectionKey) (bool, int) {
+	if s == nil {
+		return true, 0
+	}
+
+	now := s.now()
+	s.Lock()
+	defer s.Unlock()
+
+	previous, ok := s.seen[key]
+	if ok && now.Sub(previous.logged) < s.window {
+		previous.suppressed++
+		return false, 0
+	}
+	if ok {
+		suppressed := previous.suppressed
+		*previous = sampledRejection{logged: now}
+		return true, suppressed
+	}
+
+	if len(s.seen) >= maxSampledRejections {
+		for k, v := range s.seen {
+			if now.Sub(v.logged) >= s.window {
+				delete(s.seen, k)
+			}
+		}
+	}
+	if len(s.seen) < maxSampledRejections {
+		s.seen[key] = &sampledRejection{logged: now}
+	}
+	return true, 0
+}
+
+// warn logs a rejection of pubkey at warning level, unless an identical one was logged within the window
+func (s *rejectionSampler) warn(logger *zap.Logger, suppressed func(), subject string, pubkey rptypes.ValidatorPubkey, msg string, fields ...zap.Field) {
+	log, count := s.sample(rejectionKey{subject: subject, pubkey: pubkey, reason: msg})
+	if !log {
+		suppressed()
+		return
+	}
+
+	if count > 0 {
+		fields = append(fields, zap.Int("suppressed", count))
+	}
+	logger.Warn(msg, fields...)
+}
+
+// warnRejection logs that a request's validator was rejected, sampled by the credential's subject, pubkey and msg
+func (pr *ProxyRouter) warnRejection(r *http.Request, pubkey rptypes.ValidatorPubkey, msg string, fields ...zap.Field) {
+	pr.rejectionLogs.warn(pr.logger(r), pr.m.Counter("rejection_logs_suppressed").Inc, requestCredential(r).SubjectKey(), pubkey, msg, fields...)
+}
+
+// warnRejection logs that a credential's validator was rejected, sampled by the credential's subject, pubkey and msg
+func (g *GRPCRouter) warnRejection(credential *auth.Credential, pubkey rptypes.ValidatorPubkey, msg string, fields ...zap.Field) {
+	g.rejectionLogs.warn(g.Logger, g.m.Counter("rejection_logs_suppressed").Inc, credential.SubjectKey(), pubkey, msg, fields...)
+}
@@ -0,0 +1,129 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/auth"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRejectionSampler(t *testing.T) {
+	s := newRejectionSampler(time.Minute)
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+
+	key := rejectionKey{subject: "node_address:" + testNode.String(), pubkey: testValidatorPubkey(0x01), reason: "wrong fee recipient"}
+	if log, suppressed := s.sample(key); !log || suppressed != 0 {
+		t.Fatalf("expected the first rejection to be logged, got %v %d", log, suppressed)
+	}
+	for i := 0; i < 3; i++ {
+		now = now.Add(12 * time.Second)
+		if log, _ := s.sample(key); log {
+			t.Fatalf("expected rejection %d to be suppressed within the window", i)
+		}
+	}
+
+	// Other validators, reasons and subjects are sampled separately
+	for _, other := range []rejectionKey{
+		{subject: key.subject, pubkey: testValidatorPubkey(0x02), reason: key.reason},
+		{subject: key.subject, pubkey: key.pubkey, reason: "not a minipool"},
+		{subject: "partner:acme", pubkey: key.pubkey, reason: key.reason},
+	} {
+		if log, _ := s.sample(other); !log {
+			t.Fatalf("expected %+v to be logged", other)
+		}
+	}
+
+	// Once the window has passed, the next is logged with the number suppressed
+	now = now.Add(30 * time.Second)
+	if log, suppressed := s.sample(key); !log || suppressed != 3 {
+		t.Fatalf("expected the rejection to be logged with 3 suppressed, got %v %d", log, suppressed)
+	}
+	now = now.Add(time.Second)
+	if log, _ := s.sample(key); log {
+		t.Fatal("expected the window to restart")
+	}
+
+	// A nil sampler logs everything
+	var disabled *rejectionSampler
+	for i := 0; i < 3; i++ {
+		if log, suppressed := disabled.sample(key); !log || suppressed != 0 {
+			t.Fatal("expected a nil sampler to log every rejection")
+		}
+	}
+	if newRejectionSampler(0) != nil {
+		t.Fatal("expected a 0 window to disable sampling")
+	}
+}
+
+func TestRejectionSamplerCapacity(t *testing.T) {
+	s := newRejectionSampler(time.Minute)
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+
+	key := func(i int) rejectionKey {
+		return rejectionKey{subject: "partner:acme", pubkey: testValidatorPubkey(0x01), reason: strconv.Itoa(i)}
+	}
+	for i := 0; i < maxSampledRejections; i++ {
+		s.sample(key(i))
+	}
+
+	// While every entry is within its window, new rejections are logged without being remembered
+	overflow := key(maxSampledRejections)
+	for i := 0; i < 2; i++ {
+		if log, _ := s.sample(overflow); !log {
+			t.Fatal("expected a rejection past capacity to be logged")
+		}
+	}
+	if len(s.seen) != maxSampledRejections {
+		t.Fatalf("expected %d remembered rejections, got %d", maxSampledRejections, len(s.seen))
+	}
+
+	// Once they've expired, they're pruned to make room
+	now = now.Add(time.Minute)
+	s.sample(overflow)
+	if len(s.seen) != 1 {
+		t.Fatalf("expected expired rejections to be pruned, got %d", len(s.seen))
+	}
+	if log, _ := s.sample(overflow); log {
+		t.Fatal("expected the new rejection to be remembered")
+	}
+}
+
+func TestRejectionLogsSampled(t *testing.T) {
+	testMetrics(t)
+	core, logs := observer.New(zapcore.InfoLevel)
+	auditCore, audits := observer.New(zapcore.InfoLevel)
+	bn := newFakeBeaconNode(t, "bn")
+	pr, _ := guardedRouter(t, &ProxyRouter{SkipStatusCheck: true}, bn)
+	pr.Logger = zap.New(core)
+	pr.AuditLogger = zap.New(auditCore)
+	pr.rejectionLogs = newRejectionSampler(time.Hour)
+
+	body := registration(testValidatorPubkey(0x01), testWrongRecipient)
+	for i := 0; i < 5; i++ {
+		w := httptest.NewRecorder()
+		pr.registerValidator()(w, guardedRequest(registerValidatorPath, body, auth.OperatorRocketPool))
+		if w.Code != http.StatusConflict {
+			t.Fatalf("expected 409, got %d", w.Code)
+		}
+	}
+
+	if warned := logs.FilterMessage("register_validator called with unexpected fee recipient").Len(); warned != 1 {
+		t.Fatalf("expected 1 warning, got %d", warned)
+	}
+	if got := testutil.ToFloat64(pr.m.Counter("rejection_logs_suppressed")); got != 4 {
+		t.Fatalf("expected 4 suppressed warnings, got %v", got)
+	}
+	// Every rejection is still audited
+	if audited := audits.FilterMessage("Rejected fee recipient").Len(); audited != 5 {
+		t.Fatalf("expected 5 audit records, got %d", audited)
+	}
+}
@@ -0,0 +1,227 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Settings are the parts of the proxy's configuration which can be changed while it's running, with Reload.
+// Everything else, like the listeners and the execution layer, is fixed until the proxy restarts.
+type Settings struct {
+	BeaconNodes            []*url.URL
+	UnknownValidatorPolicy UnknownValidatorPolicy
+	GuardedRateLimit       float64
+	GuardedRateBurst       int
+	IPRateLimit            float64
+	IPRateBurst            int
+}
+
+// settingsFile is the format LoadSettings reads. Settings it leaves out keep their defaults.
+type settingsFile struct {
+	BeaconNodes            *string  `json:"bn_url"`
+	UnknownValidatorPolicy *string  `json:"unknown_validator_policy"`
+	GuardedRateLimit       *float64 `json:"guarded_rate_limit"`
+	GuardedRateBurst       *int     `json:"guarded_rate_burst"`
+	IPRateLimit            *float64 `json:"ip_rate_limit"`
+	IPRateBurst            *int     `json:"ip_rate_burst"`
+}
+
+// LoadSettings reads a json file of settings over defaults. A missing bn_url, for instance, leaves
+// defaults.BeaconNodes as it is. bn_url is a comma-separated list, like the -bn-url flag.
+func LoadSettings(path string, defaults Settings) (Settings, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Settings{}, err
+	}
+
+	var file settingsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return Settings{}, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+
+	out := defaults
+	if file.BeaconNodes != nil {
+		out.BeaconNodes = nil
+		for _, bnURL := range strings.Split(*file.BeaconNodes, ",") {
+			base, err := url.Parse(strings.TrimSpace(bnURL))
+			if err != nil {
+				return Settings{}, fmt.Errorf("invalid bn_url %s: %w", bnURL, err)
+			}
+			out.BeaconNodes = append(out.BeaconNodes, base)
+		}
+	}
+	if file.UnknownValidatorPolicy != nil {
+		out.UnknownValidatorPolicy = UnknownValidatorPolicy(*file.UnknownValidatorPolicy)
+	}
+	if file.GuardedRateLimit != nil {
+		out.GuardedRateLimit = *file.GuardedRateLimit
+	}
+	if file.GuardedRateBurst != nil {
+		out.GuardedRateBurst = *file.GuardedRateBurst
+	}
+	if file.IPRateLimit != nil {
+		out.IPRateLimit = *file.IPRateLimit
+	}
+	if file.IPRateBurst != nil {
+		out.IPRateBurst = *file.IPRateBurst
+	}
+
+	if err := out.Validate(); err != nil {
+		return Settings{}, fmt.Errorf("invalid settings in %s: %w", path, err)
+	}
+	return out, nil
+}
+
+// Validate returns an error if the proxy can't run with s
+func (s Settings) Validate() error {
+	if len(s.BeaconNodes) == 0 {
+		return fmt.Errorf("at least one beacon node is required")
+	}
+	for _, beaconNode := range s.BeaconNodes {
+		if beaconNode.Scheme != "http" && beaconNode.Scheme != "https" {
+			return fmt.Errorf("beacon node %s isn't http or https", beaconNode.String())
+		}
+	}
+
+	if _, err := ParseUnknownValidatorPolicy(string(s.UnknownValidatorPolicy)); err != nil {
+		return err
+	}
+
+	if s.GuardedRateLimit < 0 || s.GuardedRateBurst < 1 {
+		return fmt.Errorf("the guarded rate limit may not be negative, and its burst must be at least 1")
+	}
+	if s.IPRateLimit < 0 || s.IPRateBurst < 1 {
+		return fmt.Errorf("the ip rate limit may not be negative, and its burst must be at least 1")
+	}
+	return nil
+}
+
+func formatBeaconNodes(beaconNodes []*url.URL) string {
+	out := make([]string, 0, len(beaconNodes))
+	for _, beaconNode := range beaconNodes {
+		out = append(out, beaconNode.String())
+	}
+	return strings.Join(out, ",")
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Diff describes each setting which differs between s and next, as "name: old -> new"
+func (s Settings) Diff(next Settings) []string {
+	var out []string
+	diff := func(name, old, new string) {
+		if old != new {
+			out = append(out, name+": "+old+" -> "+new)
+		}
+	}
+
+	diff("bn_url", formatBeaconNodes(s.BeaconNodes), formatBeaconNodes(next.BeaconNodes))
+	diff("unknown_validator_policy", string(s.UnknownValidatorPolicy), string(next.UnknownValidatorPolicy))
+	diff("guarded_rate_limit", formatFloat(s.GuardedRateLimit), formatFloat(next.GuardedRateLimit))
+	diff("guarded_rate_burst", strconv.Itoa(s.GuardedRateBurst), strconv.Itoa(next.GuardedRateBurst))
+	diff("ip_rate_limit", formatFloat(s.IPRateLimit), formatFloat(next.IPRateLimit))
+	diff("ip_rate_burst", strconv.Itoa(s.IPRateBurst), strconv.Itoa(next.IPRateBurst))
+	return out
+}
+
+// live is what requests are served with. Reload replaces it as a whole, so each request
+// sees either the old settings or the new ones, never a mix of the two.
+type live struct {
+	Settings
+	upstreams      *upstreamPool
+	guardedLimiter *rateLimiter
+	ipLimiter      *rateLimiter
+	// Failed authentications per IP, limited like guarded requests. See authFailureLimiter.
+	authFailureLimiter *rateLimiter
+}
+
+// newLive creates what's needed to serve requests with s. Anything whose settings haven't changed
+// since previous is carried over, so health checks and rate limit buckets aren't reset needlessly.
+func (pr *ProxyRouter) newLive(s Settings, previous *live) *live {
+	out := &live{Settings: s}
+
+	if previous != nil && formatBeaconNodes(previous.BeaconNodes) == formatBeaconNodes(s.BeaconNodes) {
+		out.upstreams = previous.upstreams
+	} else {
+		out.upstreams = newUpstreamPool(s.BeaconNodes, pr.UpstreamTimeouts, pr.CircuitBreaker, pr.Logger, pr.m)
+		if len(s.BeaconNodes) > 1 {
+			// There's nothing to fail over to with a single beacon node
+			out.upstreams.start(pr.HealthCheckInterval)
+		}
+	}
+
+	if previous != nil && previous.GuardedRateLimit == s.GuardedRateLimit && previous.GuardedRateBurst == s.GuardedRateBurst {
+		out.guardedLimiter = previous.guardedLimiter
+		out.authFailureLimiter = previous.authFailureLimiter
+	} else {
+		out.guardedLimiter = newRateLimiter(s.GuardedRateLimit, s.GuardedRateBurst)
+		out.authFailureLimiter = newRateLimiter(s.GuardedRateLimit, s.GuardedRateBurst)
+	}
+
+	if previous != nil && previous.IPRateLimit == s.IPRateLimit && previous.IPRateBurst == s.IPRateBurst {
+		out.ipLimiter = previous.ipLimiter
+	} else {
+		out.ipLimiter = newRateLimiter(s.IPRateLimit, s.IPRateBurst)
+	}
+
+	return out
+}
+
+// liveUpstreams is the proxies' transport. Each request goes to the beacon nodes which are current when it's sent.
+type liveUpstreams struct {
+	pr *ProxyRouter
+}
+
+// RoundTrip implements http.RoundTripper
+func (l liveUpstreams) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Once a request is proxied, it's been validated
+	timing := requestTimingFrom(req.Context())
+	timing.endValidation()
+
+	start := time.Now()
+	resp, err := l.pr.live.Load().upstreams.RoundTrip(req)
+	timing.addUpstream(time.Since(start))
+	return resp, err
+}
+
+// Settings returns the settings the proxy is currently serving requests with
+func (pr *ProxyRouter) Settings() Settings {
+	return pr.live.Load().Settings
+}
+
+// Reload starts serving requests with s, once Init has been called, and returns a description of each setting
+// which changed. If s is invalid, nothing changes. Requests already in flight finish with the settings they started with.
+func (pr *ProxyRouter) Reload(s Settings) ([]string, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	pr.reloadLock.Lock()
+	defer pr.reloadLock.Unlock()
+
+	current := pr.live.Load()
+	changed := current.Diff(s)
+	if len(changed) == 0 {
+		pr.Logger.Info("Reloaded settings, nothing changed")
+		return nil, nil
+	}
+
+	next := pr.newLive(s, current)
+	pr.live.Store(next)
+	if next.upstreams != current.upstreams {
+		current.upstreams.retire()
+	}
+
+	pr.Logger.Info("Reloaded settings", zap.Strings("changed", changed))
+	return changed, nil
+}
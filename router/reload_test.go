@@ -0,0 +1,170 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/auth"
+)
+
+func TestReloadUnknownValidatorPolicy(t *testing.T) {
+	testMetrics(t)
+	bn := newFakeBeaconNode(t, "bn")
+	pr, _ := guardedRouter(t, &ProxyRouter{SkipStatusCheck: true, UnknownValidatorPolicy: UnknownValidatorAllow}, bn)
+
+	request := func() int {
+		w := httptest.NewRecorder()
+		pr.registerValidator()(w, guardedRequest(registerValidatorPath, registration(testValidatorPubkey(0x04), testWrongRecipient), auth.OperatorRocketPool))
+		return w.Code
+	}
+	if code := request(); code != http.StatusOK {
+		t.Fatalf("expected the unknown validator to be allowed, got %d", code)
+	}
+
+	settings := pr.Settings()
+	settings.UnknownValidatorPolicy = UnknownValidatorDeny
+	changed, err := pr.Reload(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 || changed[0] != "unknown_validator_policy: allow -> deny" {
+		t.Fatalf("unexpected changes %v", changed)
+	}
+
+	// The very next request is checked against the new policy
+	if code := request(); code != http.StatusForbidden {
+		t.Fatalf("expected the unknown validator to be denied after reloading, got %d", code)
+	}
+	if bn.requests.Load() != 1 {
+		t.Fatalf("expected only the first request to be proxied, got %d", bn.requests.Load())
+	}
+}
+
+func TestReloadBeaconNodes(t *testing.T) {
+	testMetrics(t)
+	a := newFakeBeaconNode(t, "a")
+	b := newFakeBeaconNode(t, "b")
+	pr, _ := guardedRouter(t, &ProxyRouter{}, a)
+
+	request := func() string {
+		w := httptest.NewRecorder()
+		pr.proxy.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/eth/v1/node/version", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d", w.Code)
+		}
+		return w.Body.String()
+	}
+	if got := request(); got != "a" {
+		t.Fatalf("expected the request to go to a, got %s", got)
+	}
+
+	previous := pr.live.Load().upstreams
+	settings := pr.Settings()
+	settings.BeaconNodes = []*url.URL{b.url}
+	changed, err := pr.Reload(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 || changed[0] != "bn_url: "+a.url.String()+" -> "+b.url.String() {
+		t.Fatalf("unexpected changes %v", changed)
+	}
+	if got := request(); got != "b" {
+		t.Fatalf("expected the request to go to b after reloading, got %s", got)
+	}
+	if !previous.retired.Load() || a.requests.Load() != 1 {
+		t.Fatalf("expected a to be retired after 1 request, got %d", a.requests.Load())
+	}
+}
+
+func TestReloadIsAtomic(t *testing.T) {
+	testMetrics(t)
+	a := newFakeBeaconNode(t, "a")
+	b := newFakeBeaconNode(t, "b")
+	pr, _ := guardedRouter(t, &ProxyRouter{UnknownValidatorPolicy: UnknownValidatorAllow}, a)
+	before := pr.live.Load()
+
+	// A valid beacon node and rate limit don't apply if the policy is invalid
+	settings := pr.Settings()
+	settings.BeaconNodes = []*url.URL{b.url}
+	settings.GuardedRateLimit = 5
+	settings.UnknownValidatorPolicy = "permissive"
+	if _, err := pr.Reload(settings); err == nil {
+		t.Fatal("expected an invalid policy to be refused")
+	}
+	if pr.live.Load() != before {
+		t.Fatal("expected nothing to change")
+	}
+
+	// Unchanged settings are left alone entirely
+	changed, err := pr.Reload(pr.Settings())
+	if err != nil || len(changed) != 0 || pr.live.Load() != before {
+		t.Fatalf("expected reloading the same settings to change nothing, got %v %v", changed, err)
+	}
+
+	// Rate limiters whose limits haven't changed keep their buckets
+	settings = pr.Settings()
+	settings.IPRateLimit = 10
+	if _, err := pr.Reload(settings); err != nil {
+		t.Fatal(err)
+	}
+	after := pr.live.Load()
+	if after.upstreams != before.upstreams || after.guardedLimiter != before.guardedLimiter || after.ipLimiter == before.ipLimiter {
+		t.Fatal("expected only the ip rate limiter to be replaced")
+	}
+}
+
+func TestLoadSettings(t *testing.T) {
+	defaults := Settings{
+		BeaconNodes:            []*url.URL{{Scheme: "http", Host: "localhost:5052"}},
+		UnknownValidatorPolicy: UnknownValidatorAllow,
+		GuardedRateLimit:       1,
+		GuardedRateBurst:       10,
+		IPRateLimit:            100,
+		IPRateBurst:            200,
+	}
+	path := filepath.Join(t.TempDir(), "settings.json")
+	write := func(contents string) {
+		if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Settings the file leaves out keep their defaults
+	write(`{"bn_url": "http://a:5052, https://b", "unknown_validator_policy": "deny", "ip_rate_limit": 0}`)
+	settings, err := LoadSettings(path, defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatBeaconNodes(settings.BeaconNodes); got != "http://a:5052,https://b" {
+		t.Fatalf("unexpected beacon nodes %s", got)
+	}
+	if settings.UnknownValidatorPolicy != UnknownValidatorDeny || settings.IPRateLimit != 0 ||
+		settings.GuardedRateLimit != 1 || settings.GuardedRateBurst != 10 || settings.IPRateBurst != 200 {
+		t.Fatalf("unexpected settings %+v", settings)
+	}
+	if changed := defaults.Diff(settings); len(changed) != 3 {
+		t.Fatalf("expected 3 changes, got %v", changed)
+	}
+
+	for name, contents := range map[string]string{
+		"not json":           `bn_url=http://a`,
+		"no beacon nodes":    `{"bn_url": ""}`,
+		"unsupported scheme": `{"bn_url": "ws://a"}`,
+		"invalid policy":     `{"unknown_validator_policy": "permissive"}`,
+		"negative limit":     `{"guarded_rate_limit": -1}`,
+		"empty burst":        `{"ip_rate_burst": 0}`,
+	} {
+		write(contents)
+		if _, err := LoadSettings(path, defaults); err == nil {
+			t.Fatalf("expected %s to be refused", name)
+		}
+	}
+
+	if _, err := LoadSettings(filepath.Join(t.TempDir(), "missing.json"), defaults); err == nil {
+		t.Fatal("expected a missing file to be refused")
+	}
+}
@@ -0,0 +1,83 @@
+package router
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// The header request IDs are read from, forwarded upstream in, and returned to the client in
+const requestIDHeader = "X-Request-Id"
+
+// Incoming request IDs longer than this are replaced, so they can't bloat the logs
+const maxRequestIDLength = 128
+
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID checks that a client-provided request ID is printable ascii, without
+// spaces, so that it can't be used to forge log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(prContextKey("request_id")).(string)
+	return id
+}
+
+// requestLogger returns the logger tagged with the request ID of ctx, or fallback if there isn't one
+func requestLogger(ctx context.Context, fallback *zap.Logger) *zap.Logger {
+	if logger, ok := ctx.Value(prContextKey("logger")).(*zap.Logger); ok {
+		return logger
+	}
+	return fallback
+}
+
+func (pr *ProxyRouter) logger(r *http.Request) *zap.Logger {
+	return requestLogger(r.Context(), pr.Logger)
+}
+
+// auditLogger returns the audit logger tagged with the request ID of r
+func (pr *ProxyRouter) auditLogger(r *http.Request) *zap.Logger {
+	if id := requestID(r.Context()); id != "" {
+		return pr.AuditLogger.With(zap.String("request_id", id))
+	}
+	return pr.AuditLogger
+}
+
+// requestIDMiddleware tags each request with an ID, honoring the client's if it sent one.
+// The ID is forwarded upstream, returned in the response headers, and included in every log line
+// and error body for the request, so reports from node operators can be matched to the logs.
+func (pr *ProxyRouter) requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		r.Header.Set(requestIDHeader, id)
+		w.Header().Set(requestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), prContextKey("request_id"), id)
+		ctx = context.WithValue(ctx, prContextKey("logger"), pr.Logger.With(zap.String("request_id", id)))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,106 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	pr := &ProxyRouter{Logger: zap.NewNop()}
+
+	var seen string
+	handler := pr.requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Get(requestIDHeader)
+		if requestID(r.Context()) != seen {
+			t.Errorf("expected the context and header IDs to match, got %q and %q", requestID(r.Context()), seen)
+		}
+	}))
+
+	request := func(id string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/eth/v1/node/version", nil)
+		if id != "" {
+			r.Header.Set(requestIDHeader, id)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// An ID is generated if the client didn't send one
+	w := request("")
+	if len(seen) != 32 || w.Header().Get(requestIDHeader) != seen {
+		t.Fatalf("expected a generated ID in the request and response, got %q and %q", seen, w.Header().Get(requestIDHeader))
+	}
+
+	// The client's ID is honored
+	if request("vc-1234"); seen != "vc-1234" {
+		t.Fatalf("expected the client's ID, got %q", seen)
+	}
+
+	// Unless it could be used to forge log lines
+	if request("a\nb"); seen == "a\nb" || len(seen) != 32 {
+		t.Fatalf("expected an invalid ID to be replaced, got %q", seen)
+	}
+}
+
+func TestRequestIDLogging(t *testing.T) {
+	testMetrics(t)
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(core)
+
+	var forwarded string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get(requestIDHeader)
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pr := &ProxyRouter{
+		Logger: logger,
+		proxy:  newReverseProxy(newUpstreamPool([]*url.URL{upstreamURL}, UpstreamTimeouts{}, CircuitBreakerConfig{}, logger, metrics.NewMetricsRegistry("http_proxy"))),
+	}
+
+	// Every log line, including the upstream's, shares the request's ID, as does the error body
+	var id string
+	handler := pr.requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id = requestID(r.Context())
+		pr.logger(r).Info("Validating request")
+		pr.proxy.ServeHTTP(httptest.NewRecorder(), r)
+		writeJSONError(w, r, http.StatusConflict, "wrong fee recipient")
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/eth/v1/node/version", nil))
+
+	if forwarded != id {
+		t.Fatalf("expected %q to be forwarded upstream, got %q", id, forwarded)
+	}
+	if logs.Len() < 2 {
+		t.Fatalf("expected the handler and upstream to log, got %d lines", logs.Len())
+	}
+	for _, entry := range logs.All() {
+		if got := entry.ContextMap()["request_id"]; got != id {
+			t.Fatalf("expected %q to be logged with request_id %q, got %v", entry.Message, id, got)
+		}
+	}
+
+	var body struct {
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.RequestID != id {
+		t.Fatalf("expected the error body to include %q, got %q", id, body.RequestID)
+	}
+}
@@ -0,0 +1,195 @@
+package router
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+)
+
+// The most responses the cache will hold, so varying the query string can't grow it without bound
+const maxCachedResponses = 1024
+
+// DefaultResponseCacheTTLs lists the GET endpoints whose responses are cached by default.
+// A TTL of 0 caches the response for the life of the process.
+var DefaultResponseCacheTTLs = map[string]time.Duration{
+	"/eth/v1/beacon/genesis":          0,
+	"/eth/v1/config/deposit_contract": 0,
+	"/eth/v1/config/fork_schedule":    0,
+	"/eth/v1/config/spec":             0,
+	"/eth/v1/node/syncing":            3 * time.Second,
+}
+
+// ParseResponseCacheTTLs parses a comma-separated list of paths to cache, each optionally followed by
+// =TTL, eg, /eth/v1/config/spec,/eth/v1/node/syncing=3s. Paths without a TTL are cached forever.
+func ParseResponseCacheTTLs(s string) (map[string]time.Duration, error) {
+	out := make(map[string]time.Duration)
+	if strings.TrimSpace(s) == "" {
+		return out, nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		path, ttl, hasTTL := strings.Cut(strings.TrimSpace(entry), "=")
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("invalid path %q, paths must start with /", path)
+		}
+
+		var d time.Duration
+		if hasTTL {
+			var err error
+			d, err = time.ParseDuration(ttl)
+			if err != nil {
+				return nil, fmt.Errorf("invalid TTL for %s: %w", path, err)
+			}
+			if d <= 0 {
+				return nil, fmt.Errorf("invalid TTL for %s, omit it to cache forever", path)
+			}
+		}
+		out[path] = d
+	}
+
+	return out, nil
+}
+
+// FormatResponseCacheTTLs is the inverse of ParseResponseCacheTTLs
+func FormatResponseCacheTTLs(ttls map[string]time.Duration) string {
+	entries := make([]string, 0, len(ttls))
+	for path, ttl := range ttls {
+		if ttl == 0 {
+			entries = append(entries, path)
+			continue
+		}
+		entries = append(entries, path+"="+ttl.String())
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+// perRequestHeaders are set for each request by the proxy, rather than by the upstream's response, so they aren't
+// cached. The request ID middleware sets its header before the response is captured, for one.
+var perRequestHeaders = []string{requestIDHeader, shadowWarningHeader, "Date", "Set-Cookie"}
+
+type cachedResponse struct {
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+func (c *cachedResponse) expired(now time.Time) bool {
+	return !c.expires.IsZero() && now.After(c.expires)
+}
+
+// responseCache holds upstream responses to GET requests for endpoints whose responses rarely,
+// if ever, change. Only 200 OK responses are cached.
+type responseCache struct {
+	sync.RWMutex
+	entries map[string]*cachedResponse
+	m       *metrics.MetricsRegistry
+}
+
+func newResponseCache(m *metrics.MetricsRegistry) *responseCache {
+	return &responseCache{
+		entries: make(map[string]*cachedResponse),
+		m:       m,
+	}
+}
+
+// Responses vary by content type and encoding as well as the path and query string
+func responseCacheKey(r *http.Request) string {
+	return r.URL.RequestURI() + "\n" + r.Header.Get("Accept") + "\n" + r.Header.Get("Accept-Encoding")
+}
+
+func (c *responseCache) get(key string) *cachedResponse {
+	c.RLock()
+	defer c.RUnlock()
+
+	entry, ok := c.entries[key]
+	if !ok || entry.expired(time.Now()) {
+		return nil
+	}
+	return entry
+}
+
+func (c *responseCache) put(key string, entry *cachedResponse) {
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxCachedResponses {
+		// Make room by dropping expired entries, or don't cache at all
+		now := time.Now()
+		for k, e := range c.entries {
+			if e.expired(now) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxCachedResponses {
+			return
+		}
+	}
+
+	c.entries[key] = entry
+}
+
+// responseCapture copies a response into a buffer while writing it to the client
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rc *responseCapture) WriteHeader(status int) {
+	rc.status = status
+	rc.ResponseWriter.WriteHeader(status)
+}
+
+func (rc *responseCapture) Write(b []byte) (int, error) {
+	if rc.status == 0 {
+		rc.status = http.StatusOK
+	}
+	rc.body.Write(b)
+	return rc.ResponseWriter.Write(b)
+}
+
+// handler serves GET requests from the cache, and caches successful responses from next for ttl.
+// A ttl of 0 caches them forever.
+func (c *responseCache) handler(next http.Handler, ttl time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := responseCacheKey(r)
+		if entry := c.get(key); entry != nil {
+			c.m.Counter("response_cache_hit").Inc()
+			// Headers already set for this request, like its ID, are kept
+			for k, v := range entry.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(entry.body)
+			return
+		}
+
+		c.m.Counter("response_cache_miss").Inc()
+		capture := &responseCapture{ResponseWriter: w}
+		next.ServeHTTP(capture, r)
+
+		// Errors, including the proxy's own 502s, may be transient
+		if capture.status != http.StatusOK {
+			return
+		}
+
+		entry := &cachedResponse{
+			header: w.Header().Clone(),
+			body:   capture.body.Bytes(),
+		}
+		for _, k := range perRequestHeaders {
+			entry.header.Del(k)
+		}
+		if ttl > 0 {
+			entry.expires = time.Now().Add(ttl)
+		}
+		c.put(key, entry)
+	}
+}
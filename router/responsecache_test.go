@@ -0,0 +1,168 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+// countingHandler is a fake upstream which counts its requests and echoes the request URI
+type countingHandler struct {
+	requests atomic.Int64
+	status   int
+}
+
+func (h *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.requests.Add(1)
+	w.Header().Set("Content-Type", "application/json")
+	if h.status != 0 {
+		w.WriteHeader(h.status)
+	}
+	fmt.Fprintf(w, `{"data":%q}`, r.URL.RequestURI())
+}
+
+func cacheGet(t *testing.T, handler http.Handler, uri string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, uri, nil))
+	return w
+}
+
+func TestResponseCacheHit(t *testing.T) {
+	testMetrics(t)
+	upstream := &countingHandler{}
+	cache := newResponseCache(metrics.NewMetricsRegistry("http_proxy"))
+	handler := cache.handler(upstream, 0)
+
+	first := cacheGet(t, handler, "/eth/v1/config/spec")
+	second := cacheGet(t, handler, "/eth/v1/config/spec")
+	if upstream.requests.Load() != 1 {
+		t.Fatalf("expected 1 upstream request, got %d", upstream.requests.Load())
+	}
+	if second.Code != http.StatusOK || second.Body.String() != first.Body.String() {
+		t.Fatalf("expected the cached response, got %d %s", second.Code, second.Body.String())
+	}
+	if second.Header().Get("Content-Type") != "application/json" {
+		t.Fatal("expected the cached response's headers")
+	}
+	if got := testutil.ToFloat64(cache.m.Counter("response_cache_hit")); got != 1 {
+		t.Fatalf("expected 1 hit, got %v", got)
+	}
+	if got := testutil.ToFloat64(cache.m.Counter("response_cache_miss")); got != 1 {
+		t.Fatalf("expected 1 miss, got %v", got)
+	}
+}
+
+func TestResponseCacheRequestIDs(t *testing.T) {
+	testMetrics(t)
+	upstream := &countingHandler{}
+	cache := newResponseCache(metrics.NewMetricsRegistry("http_proxy"))
+	pr := &ProxyRouter{Logger: zap.NewNop()}
+	handler := pr.requestIDMiddleware(cache.handler(upstream, 0))
+
+	request := func(id string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/eth/v1/config/spec", nil)
+		r.Header.Set(requestIDHeader, id)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// Each hit carries its own request's ID, not the one which filled the entry
+	if w := request("first"); w.Header().Get(requestIDHeader) != "first" {
+		t.Fatalf("expected request ID first, got %q", w.Header().Get(requestIDHeader))
+	}
+	second := request("second")
+	if upstream.requests.Load() != 1 {
+		t.Fatalf("expected 1 upstream request, got %d", upstream.requests.Load())
+	}
+	if ids := second.Header().Values(requestIDHeader); len(ids) != 1 || ids[0] != "second" {
+		t.Fatalf("expected request ID second, got %v", ids)
+	}
+	if second.Header().Get("Content-Type") != "application/json" {
+		t.Fatal("expected the cached response's headers")
+	}
+}
+
+func TestResponseCacheKeysOnQuery(t *testing.T) {
+	testMetrics(t)
+	upstream := &countingHandler{}
+	handler := newResponseCache(metrics.NewMetricsRegistry("http_proxy")).handler(upstream, 0)
+
+	a := cacheGet(t, handler, "/eth/v1/config/spec?a=1")
+	b := cacheGet(t, handler, "/eth/v1/config/spec?a=2")
+	if upstream.requests.Load() != 2 {
+		t.Fatalf("expected 2 upstream requests, got %d", upstream.requests.Load())
+	}
+	if a.Body.String() == b.Body.String() {
+		t.Fatal("expected different responses for different query strings")
+	}
+}
+
+func TestResponseCacheSkipsErrors(t *testing.T) {
+	testMetrics(t)
+	upstream := &countingHandler{status: http.StatusServiceUnavailable}
+	handler := newResponseCache(metrics.NewMetricsRegistry("http_proxy")).handler(upstream, 0)
+
+	cacheGet(t, handler, "/eth/v1/beacon/genesis")
+	w := cacheGet(t, handler, "/eth/v1/beacon/genesis")
+	if upstream.requests.Load() != 2 {
+		t.Fatalf("expected errors not to be cached, got %d upstream requests", upstream.requests.Load())
+	}
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected the upstream error, got %d", w.Code)
+	}
+}
+
+func TestResponseCacheTTL(t *testing.T) {
+	testMetrics(t)
+	upstream := &countingHandler{}
+	handler := newResponseCache(metrics.NewMetricsRegistry("http_proxy")).handler(upstream, 10*time.Millisecond)
+
+	cacheGet(t, handler, "/eth/v1/node/syncing")
+	cacheGet(t, handler, "/eth/v1/node/syncing")
+	if upstream.requests.Load() != 1 {
+		t.Fatalf("expected 1 upstream request, got %d", upstream.requests.Load())
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	cacheGet(t, handler, "/eth/v1/node/syncing")
+	if upstream.requests.Load() != 2 {
+		t.Fatalf("expected the expired response to be fetched again, got %d upstream requests", upstream.requests.Load())
+	}
+}
+
+func TestParseResponseCacheTTLs(t *testing.T) {
+	ttls, err := ParseResponseCacheTTLs("/eth/v1/config/spec, /eth/v1/node/syncing=3s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ttls) != 2 || ttls["/eth/v1/config/spec"] != 0 || ttls["/eth/v1/node/syncing"] != 3*time.Second {
+		t.Fatalf("unexpected TTLs %v", ttls)
+	}
+
+	ttls, err = ParseResponseCacheTTLs("")
+	if err != nil || ttls == nil || len(ttls) != 0 {
+		t.Fatalf("expected an empty allowlist, got %v %v", ttls, err)
+	}
+
+	for _, s := range []string{"eth/v1/config/spec", "/eth/v1/node/syncing=soon", "/eth/v1/node/syncing=0s"} {
+		if _, err := ParseResponseCacheTTLs(s); err == nil {
+			t.Fatalf("expected an error parsing %q", s)
+		}
+	}
+
+	ttls, err = ParseResponseCacheTTLs(FormatResponseCacheTTLs(DefaultResponseCacheTTLs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ttls) != len(DefaultResponseCacheTTLs) {
+		t.Fatalf("expected the defaults to round trip, got %v", ttls)
+	}
+}
@@ -4,28 +4,98 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/auth"
 	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/consensuslayer"
 	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/executionlayer"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/guarded"
 	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/gorilla/mux"
 	rptypes "github.com/rocket-pool/rocketpool-go/types"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.uber.org/zap"
 )
 
+// The guarded endpoints, whose fee recipients are checked
+const (
+	prepareBeaconProposerPath = "/eth/v1/validator/prepare_beacon_proposer"
+	registerValidatorPath     = "/eth/v1/validator/register_validator"
+)
+
+// DefaultMaxGuardedBodySize is how many bytes a guarded request's body may be, before and after decompression,
+// unless MaxGuardedBodySize says otherwise. It's room for over ten thousand validators in either encoding.
+const DefaultMaxGuardedBodySize = 4 << 20
+
+// DefaultMaxRegistrations is how many validators a register_validator request may register,
+// unless MaxRegistrations says otherwise
+const DefaultMaxRegistrations = 10000
+
+var (
+	// errTooManyRegistrations stops a register_validator body being read once it has more than MaxRegistrations entries
+	errTooManyRegistrations = errors.New("too many registrations")
+	// errRegistrationRefused stops a register_validator body being read once an entry has been refused
+	errRegistrationRefused = errors.New("registration refused")
+)
+
 type ProxyRouter struct {
-	proxy              *httputil.ReverseProxy
-	Logger             *zap.Logger
-	EL                 *executionlayer.ExecutionLayer
-	CL                 *consensuslayer.ConsensusLayer
-	AuthValidityWindow time.Duration
+	proxy                  *httputil.ReverseProxy
+	eventsProxy            *httputil.ReverseProxy
+	Logger                 *zap.Logger
+	AuditLogger            *zap.Logger
+	EL                     executionlayer.RPInfoProvider
+	CL                     *consensuslayer.ConsensusLayer
+	FeeRecipients          feerecipients.FeeRecipientSource
+	AuthValidityWindow     time.Duration
+	RejectWhenStale        bool
+	UnknownValidatorPolicy UnknownValidatorPolicy
+	WarmupPolicy           WarmupPolicy
+	RewriteFeeRecipients   bool
+	CheckBlindedBlocks     bool
+	HealthCheckInterval    time.Duration
+	UpstreamTimeouts       UpstreamTimeouts
+	CircuitBreaker         CircuitBreakerConfig
+	ResponseCacheTTLs      map[string]time.Duration
+	PathPolicy             PathPolicy
+	GuardedRateLimit       float64
+	GuardedRateBurst       int
+	IPRateLimit            float64
+	IPRateBurst            int
+	DisableKeymanager      bool
+	SkipStatusCheck        bool
+	MaxGuardedBodySize     int64
+	// The most validators a register_validator request may register. Larger requests are rejected with 413.
+	MaxRegistrations int
+	// Whether to reject Rocket Pool node credentials whose node address was never registered, with 403
+	RequireRegisteredNode bool
+	// Minipool statuses whose validators may use any fee recipient, eg, dissolved
+	UnenforcedStatuses map[feerecipient.MinipoolStatus]bool
+	// The mode of each guard, keyed by its name, eg, GuardPublishBlock. Guards which aren't listed enforce.
+	GuardModes map[string]GuardMode
+	// Whether responses to requests shadow mode proxied say they would have been rejected, in a Warning header
+	ShadowWarningHeader bool
+	GuardedRequests     *guarded.Feed
+	// How often identical rejections of a validator are logged. If 0, every rejection is.
+	RejectionLogWindow time.Duration
+	live               atomic.Pointer[live]
+	rejectionLogs      *rejectionSampler
+	reloadLock         sync.Mutex
+	draining           chan struct{}
+	drainOnce          sync.Once
+	handler            http.Handler
 	m                  *metrics.MetricsRegistry
 }
 
@@ -46,25 +116,184 @@ func cloneRequestBody(r *http.Request) (io.ReadCloser, error) {
 	return clone, nil
 }
 
+// limitBody fails reads from body with an *http.MaxBytesError once more than MaxGuardedBodySize bytes are read,
+// so validator clients can't make the proxy buffer or decompress arbitrarily large bodies
+func (pr *ProxyRouter) limitBody(w http.ResponseWriter, body io.ReadCloser) io.ReadCloser {
+	limit := pr.MaxGuardedBodySize
+	if limit <= 0 {
+		limit = DefaultMaxGuardedBodySize
+	}
+	return http.MaxBytesReader(w, body, limit)
+}
+
+// maxRegistrations returns how many validators a register_validator request may register
+func (pr *ProxyRouter) maxRegistrations() int {
+	if pr.MaxRegistrations <= 0 {
+		return DefaultMaxRegistrations
+	}
+	return pr.MaxRegistrations
+}
+
+func isBodyTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
+
+// rejectBodyTooLarge replies 413 to a guarded request whose body, or decompressed body, exceeded the limit
+func (pr *ProxyRouter) rejectBodyTooLarge(w http.ResponseWriter, r *http.Request) {
+	pr.m.Counter("body_too_large").Inc()
+	pr.logger(r).Warn("Rejecting guarded request with an oversized body", zap.String("path", r.URL.Path))
+	writeJSONError(w, r, http.StatusRequestEntityTooLarge, "the request body is too large")
+}
+
+// setRequestBody replaces the body of r, keeping its Content-Length consistent
+func setRequestBody(r *http.Request, body []byte) {
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+// rewriteProposerFeeRecipients returns proposers encoded as json, or SSZ if ssz is set, with the fee recipients
+// of the entries in corrections, keyed by their position in proposers, replaced. Every other entry is left untouched.
+func rewriteProposerFeeRecipients(proposers consensuslayer.PrepareBeaconProposerRequest, corrections map[int]common.Address, ssz bool) ([]byte, error) {
+	for i, feeRecipient := range corrections {
+		proposers[i].FeeRecipient = feeRecipient.String()
+	}
+
+	if ssz {
+		return encodeProposerPreparationsSSZ(proposers)
+	}
+	return json.Marshal(proposers)
+}
+
+// errorResponse is the body of the proxy's error replies, in the format beacon nodes use, so validator clients can log it
+type errorResponse struct {
+	Code     int            `json:"code"`
+	Message  string         `json:"message"`
+	Failures []entryFailure `json:"failures,omitempty"`
+	// The request ID is included, so node operators can quote it when reporting the error
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// entryFailure says why an entry of a batch request, like register_validator's, was rejected. It's the beacon API's
+// IndexedErrorMessage failure, along with the validator and fee recipients involved, where they're known.
+type entryFailure struct {
+	Index                int    `json:"index"`
+	Message              string `json:"message"`
+	Pubkey               string `json:"pubkey,omitempty"`
+	FeeRecipient         string `json:"fee_recipient,omitempty"`
+	ExpectedFeeRecipient string `json:"expected_fee_recipient,omitempty"`
+}
+
+// writeJSONError replies with an error body in the format beacon nodes use, so validator clients can log it.
+func writeJSONError(w http.ResponseWriter, r *http.Request, code int, message string) {
+	writeJSONFailures(w, r, code, message, nil)
+}
+
+// writeJSONFailures replies like writeJSONError, listing the entries of a batch request which were rejected
+func writeJSONFailures(w http.ResponseWriter, r *http.Request, code int, message string, failures []entryFailure) {
+	guardedDecisionFrom(r.Context()).reject(message)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(&errorResponse{
+		Code:      code,
+		Message:   message,
+		Failures:  failures,
+		RequestID: requestID(r.Context()),
+	})
+}
+
+// writeInternalError replies 500 to a request the proxy failed to check, for reasons it has already logged
+func writeInternalError(w http.ResponseWriter, r *http.Request) {
+	writeJSONError(w, r, http.StatusInternalServerError, "the proxy was unable to check the request, try again later")
+}
+
+// batchEntry is the entry of a batch request being checked, so that its rejection can say which it was.
+// Requests which aren't batches, like published blocks, have none.
+type batchEntry struct {
+	index int
+	// As submitted, or as the beacon node knows it, if it's looked up by index
+	pubkey       string
+	feeRecipient string
+}
+
+// rejectEntry replies like writeJSONError, listing entry as the failure if there is one.
+// expected is the fee recipient entry's validator must use, if that's why it was rejected.
+func rejectEntry(w http.ResponseWriter, r *http.Request, code int, message string, entry *batchEntry, expected *common.Address) {
+	if entry == nil {
+		writeJSONError(w, r, code, message)
+		return
+	}
+
+	failure := entryFailure{
+		Index:        entry.index,
+		Message:      message,
+		Pubkey:       entry.pubkey,
+		FeeRecipient: entry.feeRecipient,
+	}
+	if expected != nil {
+		failure.ExpectedFeeRecipient = expected.String()
+	}
+	writeJSONFailures(w, r, code, message, []entryFailure{failure})
+}
+
+// rejectIfStale replies 503 and returns true if the EL cache is too stale to check fee recipients against
+func (pr *ProxyRouter) rejectIfStale(w http.ResponseWriter, r *http.Request) bool {
+	if !pr.RejectWhenStale || !pr.EL.Stale() {
+		return false
+	}
+
+	pr.m.Counter("stale_rejected").Inc()
+	countValidationOutcome(pr.m, outcomeRejectedStaleCache)
+	pr.logger(r).Warn("Rejecting guarded request, EL cache is stale")
+	writeJSONError(w, r, http.StatusServiceUnavailable, "the fee recipient cache is stale, try again later")
+	return true
+}
+
 func (pr *ProxyRouter) prepareBeaconProposer() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		logger := pr.logger(r)
+		// Every validator in a request is checked against the same policy, even if it's reloaded meanwhile
+		policy := pr.live.Load().UnknownValidatorPolicy
 		pr.m.Counter("prepare_beacon_proposer").Inc()
+		if pr.handleIfWarming(w, r) || pr.rejectIfStale(w, r) {
+			return
+		}
+
 		// Clone the request body so it can still be proxied
+		r.Body = pr.limitBody(w, r.Body)
 		buf, err := cloneRequestBody(r)
+		if isBodyTooLarge(err) {
+			pr.rejectBodyTooLarge(w, r)
+			return
+		}
 		if err != nil {
-			pr.Logger.Warn("Error cloning prepare_beacon_proposers request body", zap.Error(err))
-			w.WriteHeader(http.StatusInternalServerError)
+			logger.Warn("Error cloning prepare_beacon_proposers request body", zap.Error(err))
+			writeInternalError(w, r)
 			return
 		}
 
-		// Parse JSON body of request
-		var proposers consensuslayer.PrepareBeaconProposerRequest
-		if err := json.NewDecoder(buf).Decode(&proposers); err != nil {
-			pr.Logger.Warn("Malformed prepare_beacon_proposers request", zap.Error(err))
-			w.WriteHeader(http.StatusBadRequest)
+		body, err := decodeContent(r, buf)
+		if err != nil {
+			logger.Warn("Unable to decode prepare_beacon_proposers request body", zap.Error(err))
+			writeJSONError(w, r, http.StatusUnsupportedMediaType, err.Error())
 			return
 		}
 
+		// Parse the JSON or SSZ body of the request. Either way, the original bytes are proxied.
+		proposers, err := decodeProposerPreparations(r, pr.limitBody(w, io.NopCloser(body)))
+		if isBodyTooLarge(err) {
+			pr.rejectBodyTooLarge(w, r)
+			return
+		}
+		if err != nil {
+			logger.Warn("Malformed prepare_beacon_proposers request", zap.Error(err))
+			writeJSONError(w, r, http.StatusBadRequest, "malformed request body: "+err.Error())
+			return
+		}
+
+		guardedDecisionFrom(r.Context()).setValidators(len(proposers))
+
 		// Create a slice of the indices
 		indices := make([]string, 0, len(proposers))
 
@@ -74,50 +303,165 @@ func (pr *ProxyRouter) prepareBeaconProposer() http.HandlerFunc {
 		}
 
 		// Get the index->pubkey map
-		pubkeyMap, err := pr.CL.GetValidatorPubkey(indices)
+		pubkeyMap, err := tracedValidatorPubkeys(r.Context(), pr.CL, indices)
+		if pr.abandoned(w, r, "lookup") {
+			return
+		}
 		if err != nil {
-			pr.Logger.Error("Error while querying CL for validator pubkeys", zap.Error(err))
-			w.WriteHeader(http.StatusInternalServerError)
+			logger.Error("Error while querying CL for validator pubkeys", zap.Error(err))
+			writeInternalError(w, r)
 			return
 		}
 
 		// Grab the authorized node address
 		authedNode, ok := r.Context().Value(prContextKey("node")).([]byte)
 		if !ok {
-			pr.Logger.Warn("Unable to retrieve node address cached on request context")
-			w.WriteHeader(http.StatusInternalServerError)
+			logger.Warn("Unable to retrieve node address cached on request context")
+			writeInternalError(w, r)
 			return
 		}
 		authedNodeAddr := common.BytesToAddress(authedNode)
+		credential := requestCredential(r)
+		operatorType := requestOperatorType(r)
+		allowedFeeRecipients := requestFeeRecipients(r)
+
+		// In rewrite mode, incorrect fee recipients are replaced with the expected ones, by position in proposers
+		corrections := make(map[int]common.Address)
+
+		// Solo validators' withdrawal addresses are looked up together, rather than one at a time in the loop
+		var solo *withdrawalAddresses
+		if operatorType == auth.OperatorSolo && len(allowedFeeRecipients) == 0 {
+			solo = tracedValidatorWithdrawalAddresses(r.Context(), pr.EL, pubkeysOf(pubkeyMap))
+		}
 
 		// Iterate the results and check the fee recipients against our expected values
 		// Note: we iterate the map from the HTTP request to ensure every key is present in the
 		// response from the consensuslayer abstraction
-		for _, proposer := range proposers {
+		for i, proposer := range proposers {
+			entry := &batchEntry{index: i, feeRecipient: proposer.FeeRecipient}
 			pubkey, found := pubkeyMap[proposer.ValidatorIndex]
 			if !found {
-				pr.Logger.Warn("Pubkey for index not found in response from cl.",
+				countValidationOutcome(pr.m, outcomeRejectedUnknownValidator)
+				logger.Warn("Pubkey for index not found in response from cl.",
 					zap.String("requested index", proposer.ValidatorIndex))
-				w.WriteHeader(http.StatusBadRequest)
+				rejectEntry(w, r, http.StatusBadRequest, "unknown validator index "+proposer.ValidatorIndex, entry, nil)
+				return
+			}
+			entry.pubkey = "0x" + pubkey.Hex()
+			if !subjectAllowsValidator(credential, pubkey) {
+				pr.rejectOtherValidator(w, r, pubkey, entry)
 				return
 			}
 
+			// Credentials which only allow some fee recipients trump where the validator's from.
+			// Which of them a validator should use is ambiguous, so they're never rewritten.
+			if len(allowedFeeRecipients) > 0 {
+				outcome := allowedFeeRecipientOutcome(allowedFeeRecipients, func(allowed common.Address) bool {
+					return strings.EqualFold(allowed.String(), proposer.FeeRecipient)
+				})
+				countValidationOutcome(pr.m, outcome)
+				if outcome != outcomeAccepted {
+					pr.rejectUnallowedFeeRecipient(w, r, pubkey, proposer.FeeRecipient, entry)
+					return
+				}
+
+				pr.m.Counter("prepare_beacon_correct_fee_recipient").Inc()
+				metrics.ObserveValidator(authedNodeAddr, pubkey)
+				continue
+			}
+
+			// Solo validators must use their withdrawal address, which the EL cache doesn't know
+			if operatorType == auth.OperatorSolo {
+				withdrawalAddress, outcome, err := soloFeeRecipient(solo, pubkey, proposer.FeeRecipient)
+				if err != nil {
+					logger.Error("Unable to check the withdrawal address of a solo validator", zap.String("key", pubkey.String()), zap.Error(err))
+					writeInternalError(w, r)
+					return
+				}
+				if pr.RewriteFeeRecipients && outcome == outcomeRejectedWrongFeeRecipient {
+					countSoloValidationOutcome(pr.m, outcomeRewrittenFeeRecipient)
+					pr.m.Counter("prepare_beacon_rewritten_fee_recipient").Inc()
+					logger.Info("Rewriting unexpected fee recipient of a solo validator in prepare_beacon_proposer",
+						zap.String("key", pubkey.String()),
+						zap.String("expected", withdrawalAddress.String()), zap.String("got", proposer.FeeRecipient))
+					corrections[i] = *withdrawalAddress
+					metrics.ObserveValidator(authedNodeAddr, pubkey)
+					continue
+				}
+				countSoloValidationOutcome(pr.m, outcome)
+				if outcome != outcomeAccepted {
+					pr.rejectSoloFeeRecipient(w, r, authedNodeAddr, pubkey, proposer.FeeRecipient, withdrawalAddress, outcome, entry)
+					return
+				}
+
+				pr.m.Counter("prepare_beacon_correct_fee_recipient").Inc()
+				metrics.ObserveValidator(authedNodeAddr, pubkey)
+				continue
+			}
+
 			// Next we need to get the expected fee recipient for the pubkey
-			expectedFeeRecipient, unowned := pr.EL.ValidatorFeeRecipient(pubkey, &authedNodeAddr)
-			if expectedFeeRecipient == nil {
+			expected, err := tracedValidatorFeeRecipient(r.Context(), pr.FeeRecipients, pubkey, subjectNodeFilter(credential))
+			if lookupFailed(err) {
+				countValidationOutcome(pr.m, outcomeRejectedCacheInconsistent)
+				pr.auditCacheInconsistency(r, authedNodeAddr, err)
+				logger.Error("Unable to determine expected fee recipient", zap.String("key", pubkey.String()), zap.Error(err))
+				rejectEntry(w, r, http.StatusForbidden, "unable to determine the expected fee recipient for validator "+pubkey.String(), entry, nil)
+				return
+			}
+			matches := func(expected common.Address) bool {
+				return strings.EqualFold(expected.String(), proposer.FeeRecipient)
+			}
+			outcome, allowlisted := allowlistedOutcome(pr.m, feeRecipientOutcome(expected, err, false, matches), pr.EL.AllowedFeeRecipients(), matches)
+			if allowlisted != nil {
+				newFeeRecipientAllowlisted(r.URL.Path, authedNodeAddr, pubkey, proposer.FeeRecipient, expected, allowlisted).log(pr.auditLogger(r))
+			}
+			outcome = unenforcedStatusOutcome(pr.m, outcome, expected, pr.UnenforcedStatuses)
+			if outcome == outcomeRejectedUnknownValidator {
+				// There's no fee recipient to expect, or rewrite to, for validators which aren't minipools,
+				// so they're left untouched, if the UnknownValidatorPolicy allows them
+				allowed, err := allowUnknownValidator(pr.m, policy, func() (bool, error) {
+					if !common.IsHexAddress(proposer.FeeRecipient) {
+						return false, nil
+					}
+					return pr.EL.SoloValidatorFeeRecipient(r.Context(), pubkey, common.HexToAddress(proposer.FeeRecipient))
+				})
+				if err != nil {
+					logger.Error("Unable to check the withdrawal address of an unknown validator", zap.String("key", pubkey.String()), zap.Error(err))
+					writeInternalError(w, r)
+					return
+				}
+				if allowed {
+					countValidationOutcome(pr.m, outcomeAccepted)
+					pr.m.Counter("prepare_beacon_proposer_not_minipool").Inc()
+					continue
+				}
+			}
+			if pr.RewriteFeeRecipients && outcome == outcomeRejectedWrongFeeRecipient {
+				countValidationOutcome(pr.m, outcomeRewrittenFeeRecipient)
+				pr.m.Counter("prepare_beacon_rewritten_fee_recipient").Inc()
+				logger.Info("Rewriting unexpected fee recipient in prepare_beacon_proposer",
+					zap.String("key", pubkey.String()),
+					zap.String("expected", expected.Expected.String()), zap.String("got", proposer.FeeRecipient))
+				corrections[i] = expected.Expected
+				metrics.ObserveValidator(authedNodeAddr, pubkey)
+				continue
+			}
+			countValidationOutcome(pr.m, outcome)
+			switch outcome {
+			case outcomeRejectedNodeMismatch, outcomeRejectedUnknownValidator:
 				pr.m.Counter("prepare_beacon_proposer_unowned").Inc()
-				pr.Logger.Warn("Pubkey not found in EL cache, or wasn't owned by the user",
+				pr.warnRejection(r, pubkey, "Pubkey not found in EL cache, or wasn't owned by the user",
 					zap.String("key", pubkey.String()),
-					zap.Bool("someone else's validator", unowned))
-				w.WriteHeader(http.StatusForbidden)
+					zap.Bool("someone else's validator", errors.Is(err, feerecipient.ErrWrongNode)))
+				rejectEntry(w, r, http.StatusForbidden, "validator "+pubkey.String()+" is not one of your minipools", entry, nil)
 				return
-			}
-			if !strings.EqualFold(expectedFeeRecipient.String(), proposer.FeeRecipient) {
+			case outcomeRejectedWrongFeeRecipient:
 				// Looks like a cheater- fee recipient doesn't match expectations
 				pr.m.Counter("prepare_beacon_incorrect_fee_recipient").Inc()
-				pr.Logger.Warn("prepare_beacon_proposer called with unexpected fee recipient",
-					zap.String("expected", expectedFeeRecipient.String()), zap.String("got", proposer.FeeRecipient))
-				w.WriteHeader(http.StatusConflict)
+				newFeeRecipientRejection(r.URL.Path, authedNodeAddr, pubkey, proposer.FeeRecipient, expected).log(pr.auditLogger(r))
+				pr.warnRejection(r, pubkey, "prepare_beacon_proposer called with unexpected fee recipient", zap.String("key", pubkey.String()),
+					zap.String("expected", expected.Expected.String()), zap.String("got", proposer.FeeRecipient))
+				rejectEntry(w, r, http.StatusConflict, "validator "+pubkey.String()+" must use fee recipient "+expected.Expected.String(), entry, &expected.Expected)
 				return
 			}
 
@@ -125,72 +469,364 @@ func (pr *ProxyRouter) prepareBeaconProposer() http.HandlerFunc {
 			metrics.ObserveValidator(authedNodeAddr, pubkey)
 		}
 
+		if len(corrections) > 0 {
+			body, err := rewriteProposerFeeRecipients(proposers, corrections, isSSZ(r))
+			if err == nil {
+				body, err = encodeContent(r, body)
+			}
+			if err != nil {
+				logger.Error("Error encoding rewritten prepare_beacon_proposer request", zap.Error(err))
+				writeInternalError(w, r)
+				return
+			}
+			setRequestBody(r, body)
+		}
+
 		// At this point all the fee recipients match our expectations. Proxy the request
+		if pr.abandoned(w, r, "validation") {
+			return
+		}
+		guardedDecisionFrom(r.Context()).accept()
 		pr.proxy.ServeHTTP(w, r)
 	}
 }
 
+// pubkeysOf returns the pubkeys of an index to pubkey map
+func pubkeysOf(pubkeyMap map[string]rptypes.ValidatorPubkey) []rptypes.ValidatorPubkey {
+	out := make([]rptypes.ValidatorPubkey, 0, len(pubkeyMap))
+	for _, pubkey := range pubkeyMap {
+		out = append(out, pubkey)
+	}
+
+	return out
+}
+
+// requestOperatorType returns the operator type of the credential a request was authenticated with
+func requestOperatorType(r *http.Request) auth.OperatorType {
+	operatorType, ok := r.Context().Value(prContextKey("operator_type")).(auth.OperatorType)
+	if !ok {
+		return auth.OperatorRocketPool
+	}
+
+	return operatorType
+}
+
+// requestCredential returns the subject of the credential a request was authenticated with. Requests whose
+// context only has a node address have a node address subject.
+func requestCredential(r *http.Request) *auth.Credential {
+	if credential, ok := r.Context().Value(prContextKey("credential")).(*auth.Credential); ok {
+		return credential
+	}
+
+	authedNode, _ := r.Context().Value(prContextKey("node")).([]byte)
+	return &auth.Credential{NodeAddress: common.BytesToAddress(authedNode), SubjectType: auth.SubjectNodeAddress}
+}
+
+// rejectOtherValidator responds to a request with a validator other than the one its credential was issued to
+func (pr *ProxyRouter) rejectOtherValidator(w http.ResponseWriter, r *http.Request, pubkey rptypes.ValidatorPubkey, entry *batchEntry) {
+	countValidationOutcome(pr.m, outcomeRejectedNodeMismatch)
+	pr.m.Counter("credential_validator_rejected").Inc()
+	pr.warnRejection(r, pubkey, "Validator isn't the one its credential was issued to",
+		zap.String("key", pubkey.String()), zap.String("subject", requestCredential(r).Subject))
+	rejectEntry(w, r, http.StatusForbidden, "validator "+pubkey.String()+" is not the validator your credential was issued to", entry, nil)
+}
+
+// requestFeeRecipients returns the fee recipients the credential a request was authenticated with restricts validators to, if any
+func requestFeeRecipients(r *http.Request) []common.Address {
+	feeRecipients, _ := r.Context().Value(prContextKey("fee_recipients")).([]common.Address)
+	return feeRecipients
+}
+
+// auditCacheInconsistency counts and audits a fee recipient lookup which failed because the validator's node is missing
+// from the EL cache. Other failures, like fee distributors which haven't been looked up yet, are transient, so aren't audited.
+func (pr *ProxyRouter) auditCacheInconsistency(r *http.Request, nodeAddr common.Address, err error) {
+	if inconsistency := newCacheInconsistency(r.URL.Path, nodeAddr, err); inconsistency != nil {
+		pr.m.Counter("cache_inconsistent_rejected").Inc()
+		inconsistency.log(pr.auditLogger(r))
+	}
+}
+
+// rejectUnallowedFeeRecipient responds to a request with a validator whose fee recipient its credential doesn't allow
+func (pr *ProxyRouter) rejectUnallowedFeeRecipient(w http.ResponseWriter, r *http.Request, pubkey rptypes.ValidatorPubkey, submitted string, entry *batchEntry) {
+	pr.m.Counter("credential_fee_recipient_rejected").Inc()
+	pr.warnRejection(r, pubkey, "Validator used a fee recipient its credential doesn't allow",
+		zap.String("key", pubkey.String()), zap.String("got", submitted))
+	rejectEntry(w, r, http.StatusConflict, "validator "+pubkey.String()+" must use one of the fee recipients your credential allows", entry, nil)
+}
+
+// withdrawalAddresses are the withdrawal addresses of the validators in a request authenticated with a solo credential.
+// They're looked up all at once, as a validator client may register hundreds of validators in one request.
+type withdrawalAddresses struct {
+	addrs map[rptypes.ValidatorPubkey]*common.Address
+	errs  map[rptypes.ValidatorPubkey]error
+}
+
+// get returns the withdrawal address of a validator, or nil if it has BLS withdrawal credentials
+func (w *withdrawalAddresses) get(pubkey rptypes.ValidatorPubkey) (*common.Address, error) {
+	if err, ok := w.errs[pubkey]; ok {
+		return nil, err
+	}
+
+	addr, ok := w.addrs[pubkey]
+	if !ok {
+		return nil, fmt.Errorf("the withdrawal address of validator %s wasn't looked up", pubkey.String())
+	}
+	return addr, nil
+}
+
+// soloFeeRecipient decides whether feeRecipient is the withdrawal address of a validator authenticated with a solo credential
+func soloFeeRecipient(solo *withdrawalAddresses, pubkey rptypes.ValidatorPubkey, feeRecipient string) (*common.Address, validationOutcome, error) {
+	withdrawalAddress, err := solo.get(pubkey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return withdrawalAddress, soloFeeRecipientOutcome(withdrawalAddress, func(expected common.Address) bool {
+		return strings.EqualFold(expected.String(), feeRecipient)
+	}), nil
+}
+
+// rejectSoloFeeRecipient responds to a request with a solo validator whose fee recipient soloFeeRecipient rejected
+func (pr *ProxyRouter) rejectSoloFeeRecipient(w http.ResponseWriter, r *http.Request, nodeAddr common.Address, pubkey rptypes.ValidatorPubkey, submitted string, withdrawalAddress *common.Address, outcome validationOutcome, entry *batchEntry) {
+	if outcome == outcomeRejectedUnknownValidator {
+		pr.m.Counter("solo_bls_credentials").Inc()
+		pr.warnRejection(r, pubkey, "Solo validator has BLS withdrawal credentials", zap.String("key", pubkey.String()))
+		rejectEntry(w, r, http.StatusForbidden, "validator "+pubkey.String()+" has no withdrawal address to use as its fee recipient", entry, nil)
+		return
+	}
+
+	pr.m.Counter("solo_incorrect_fee_recipient").Inc()
+	newFeeRecipientRejection(r.URL.Path, nodeAddr, pubkey, submitted, &feerecipient.Info{Expected: *withdrawalAddress, NodeAddress: nodeAddr}).log(pr.auditLogger(r))
+	pr.warnRejection(r, pubkey, "Solo validator used a fee recipient other than its withdrawal address", zap.String("key", pubkey.String()),
+		zap.String("expected", withdrawalAddress.String()), zap.String("got", submitted))
+	rejectEntry(w, r, http.StatusConflict, "validator "+pubkey.String()+" must use fee recipient "+withdrawalAddress.String(), entry, withdrawalAddress)
+}
+
 func (pr *ProxyRouter) registerValidator() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		logger := pr.logger(r)
+		// Every validator in a request is checked against the same policy, even if it's reloaded meanwhile
+		policy := pr.live.Load().UnknownValidatorPolicy
 		pr.m.Counter("register_validator").Inc()
+		if pr.handleIfWarming(w, r) || pr.rejectIfStale(w, r) {
+			return
+		}
+
 		// Clone the request body so it can still be proxied
+		r.Body = pr.limitBody(w, r.Body)
 		buf, err := cloneRequestBody(r)
+		if isBodyTooLarge(err) {
+			pr.rejectBodyTooLarge(w, r)
+			return
+		}
 		if err != nil {
-			pr.Logger.Warn("Error cloning register_validator request body", zap.Error(err))
-			w.WriteHeader(http.StatusInternalServerError)
+			logger.Warn("Error cloning register_validator request body", zap.Error(err))
+			writeInternalError(w, r)
 			return
 		}
 
-		// Parse JSON body of request
-		var validators consensuslayer.RegisterValidatorRequest
-		if err := json.NewDecoder(buf).Decode(&validators); err != nil {
-			pr.Logger.Warn("Malformed register_validator request", zap.Error(err))
-			w.WriteHeader(http.StatusBadRequest)
+		body, err := decodeContent(r, buf)
+		if err != nil {
+			logger.Warn("Unable to decode register_validator request body", zap.Error(err))
+			writeJSONError(w, r, http.StatusUnsupportedMediaType, err.Error())
 			return
 		}
 
 		// Grab the authorized node address
 		authedNode, ok := r.Context().Value(prContextKey("node")).([]byte)
 		if !ok {
-			pr.Logger.Warn("Unable to retrieve node address cached on request context")
-			w.WriteHeader(http.StatusInternalServerError)
+			logger.Warn("Unable to retrieve node address cached on request context")
+			writeInternalError(w, r)
 			return
 		}
 		authedNodeAddr := common.BytesToAddress(authedNode)
+		credential := requestCredential(r)
+		operatorType := requestOperatorType(r)
+		allowedFeeRecipients := requestFeeRecipients(r)
+		maxRegistrations := pr.maxRegistrations()
 
-		for _, validator := range validators {
-			pubkeyStr := strings.TrimPrefix(validator.Message.Pubkey, "0x")
+		// Parse the JSON or SSZ body of the request one registration at a time. Either way, the original bytes are proxied.
+		// Registrations which can be refused on their own are refused as soon as they're read, without parsing the rest.
+		var pubkeys []rptypes.ValidatorPubkey
+		var feeRecipients []string
+		err = streamValidatorRegistrations(r, pr.limitBody(w, io.NopCloser(body)), func(message consensuslayer.RegisterValidatorMessage) error {
+			if len(pubkeys) >= maxRegistrations {
+				return errTooManyRegistrations
+			}
 
+			entry := &batchEntry{index: len(pubkeys), pubkey: message.Pubkey, feeRecipient: message.FeeRecipient}
+			pubkeyStr := strings.TrimPrefix(message.Pubkey, "0x")
 			pubkey, err := rptypes.HexToValidatorPubkey(pubkeyStr)
 			if err != nil {
-				pr.Logger.Warn("Malformed pubkey in register_validator_request", zap.Error(err), zap.String("pubkey", pubkeyStr))
-				w.WriteHeader(http.StatusInternalServerError)
+				logger.Warn("Malformed pubkey in register_validator_request", zap.Error(err), zap.String("pubkey", pubkeyStr))
+				rejectEntry(w, r, http.StatusBadRequest, "invalid validator pubkey: "+err.Error(), entry, nil)
+				return errRegistrationRefused
+			}
+			if !subjectAllowsValidator(credential, pubkey) {
+				entry.pubkey = "0x" + pubkey.Hex()
+				pr.rejectOtherValidator(w, r, pubkey, entry)
+				return errRegistrationRefused
+			}
+
+			pubkeys = append(pubkeys, pubkey)
+			feeRecipients = append(feeRecipients, message.FeeRecipient)
+			return nil
+		})
+		if errors.Is(err, errRegistrationRefused) {
+			return
+		}
+		if errors.Is(err, errTooManyRegistrations) {
+			pr.m.Counter("register_validator_too_many").Inc()
+			logger.Warn("Rejecting register_validator request with too many registrations", zap.Int("max", maxRegistrations))
+			writeJSONError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("at most %d validators may be registered per request", maxRegistrations))
+			return
+		}
+		if isBodyTooLarge(err) {
+			pr.rejectBodyTooLarge(w, r)
+			return
+		}
+		if err != nil {
+			logger.Warn("Malformed register_validator request", zap.Error(err))
+			writeJSONError(w, r, http.StatusBadRequest, "malformed request body: "+err.Error())
+			return
+		}
+		guardedDecisionFrom(r.Context()).setValidators(len(pubkeys))
+
+		// Exited and slashed validators are refused before their fee recipients are even considered
+		if !pr.SkipStatusCheck {
+			statuses, err := tracedValidatorStatuses(r.Context(), pr.CL, pubkeys)
+			if pr.abandoned(w, r, "lookup") {
+				return
+			}
+			if err != nil {
+				logger.Error("Error while querying CL for validator statuses", zap.Error(err))
+				writeInternalError(w, r)
 				return
 			}
 
-			// Grab the expected fee recipient for the pubkey
-			expectedFeeRecipient, unowned := pr.EL.ValidatorFeeRecipient(pubkey, &authedNodeAddr)
-			if expectedFeeRecipient == nil {
-				// When unowned is true for register_validators, it means the pubkey was someone else's minipool
-				// we still want that to get rejected... however, if unowned is false and expectedFeeRecipient is nil,
-				// it means we're seeing a solo validator using mev-boost. Since register_validator requires a signature,
-				// we can allow this fee recipient.
-				if !unowned {
-					pr.m.Counter("register_validator_not_minipool").Inc()
-					metrics.ObserveValidator(authedNodeAddr, pubkey)
-					// Move on to the next pubkey
-					continue
+			if barred := barredValidators(pubkeys, statuses); len(barred) > 0 {
+				message := barredValidatorsMessage(pr.m, barred, statuses)
+				logger.Warn("register_validator called for exited or slashed validators", zap.Int("count", len(barred)),
+					zap.String("first", barred[0].String()), zap.String("status", statuses[barred[0]].String()))
+				writeJSONFailures(w, r, http.StatusForbidden, message, barredValidatorFailures(pubkeys, feeRecipients, barred, statuses))
+				return
+			}
+		}
+
+		// Solo validators' withdrawal addresses are looked up together, rather than one at a time in the loop
+		var solo *withdrawalAddresses
+		if operatorType == auth.OperatorSolo && len(allowedFeeRecipients) == 0 {
+			solo = tracedValidatorWithdrawalAddresses(r.Context(), pr.EL, pubkeys)
+		}
+
+		for i, pubkey := range pubkeys {
+			// Large batches take a while to check, which there's no point carrying on with if the client's gone
+			if pr.abandoned(w, r, "validation") {
+				return
+			}
+
+			feeRecipient := feeRecipients[i]
+			entry := &batchEntry{index: i, pubkey: "0x" + pubkey.Hex(), feeRecipient: feeRecipient}
+
+			// Credentials which only allow some fee recipients trump where the validator's from
+			if len(allowedFeeRecipients) > 0 {
+				outcome := allowedFeeRecipientOutcome(allowedFeeRecipients, func(allowed common.Address) bool {
+					return strings.EqualFold(allowed.String(), feeRecipient)
+				})
+				countValidationOutcome(pr.m, outcome)
+				if outcome != outcomeAccepted {
+					pr.rejectUnallowedFeeRecipient(w, r, pubkey, feeRecipient, entry)
+					return
 				}
-				pr.Logger.Warn("Pubkey not found in EL cache. Not an RP validator?", zap.String("key", pubkey.String()))
-				w.WriteHeader(http.StatusForbidden)
+
+				pr.m.Counter("register_validator_correct_fee_recipient").Inc()
+				metrics.ObserveValidator(authedNodeAddr, pubkey)
+				continue
+			}
+
+			// Solo validators must use their withdrawal address, whatever the UnknownValidatorPolicy
+			if operatorType == auth.OperatorSolo {
+				withdrawalAddress, outcome, err := soloFeeRecipient(solo, pubkey, feeRecipient)
+				if err != nil {
+					logger.Error("Unable to check the withdrawal address of a solo validator", zap.String("key", pubkey.String()), zap.Error(err))
+					writeInternalError(w, r)
+					return
+				}
+				countSoloValidationOutcome(pr.m, outcome)
+				if outcome != outcomeAccepted {
+					pr.rejectSoloFeeRecipient(w, r, authedNodeAddr, pubkey, feeRecipient, withdrawalAddress, outcome, entry)
+					return
+				}
+
+				pr.m.Counter("register_validator_correct_fee_recipient").Inc()
+				metrics.ObserveValidator(authedNodeAddr, pubkey)
+				continue
+			}
+
+			// Grab the expected fee recipient for the pubkey
+			expected, err := tracedValidatorFeeRecipient(r.Context(), pr.FeeRecipients, pubkey, subjectNodeFilter(credential))
+			if lookupFailed(err) {
+				// A minipool whose node we don't know can't be let through, whatever the policy
+				countValidationOutcome(pr.m, outcomeRejectedCacheInconsistent)
+				pr.auditCacheInconsistency(r, authedNodeAddr, err)
+				logger.Error("Unable to determine expected fee recipient", zap.String("key", pubkey.String()), zap.Error(err))
+				rejectEntry(w, r, http.StatusForbidden, "unable to determine the expected fee recipient for validator "+pubkey.String(), entry, nil)
 				return
 			}
+			// ErrWrongNode for register_validators means the pubkey was someone else's minipool, and
+			// we still want that to get rejected... however, ErrNotMinipool means we're seeing a solo validator
+			// using mev-boost. Since register_validator requires a signature, we can allow this fee recipient,
+			// if the UnknownValidatorPolicy does.
+			matches := func(expected common.Address) bool {
+				return strings.EqualFold(expected.String(), feeRecipient)
+			}
+			outcome, allowlisted := allowlistedOutcome(pr.m, feeRecipientOutcome(expected, err, true, matches), pr.EL.AllowedFeeRecipients(), matches)
+			if allowlisted != nil {
+				newFeeRecipientAllowlisted(r.URL.Path, authedNodeAddr, pubkey, feeRecipient, expected, allowlisted).log(pr.auditLogger(r))
+			}
+			outcome = unenforcedStatusOutcome(pr.m, outcome, expected, pr.UnenforcedStatuses)
+			if outcome == outcomeAccepted && expected == nil {
+				allowed, err := allowUnknownValidator(pr.m, policy, func() (bool, error) {
+					if !common.IsHexAddress(feeRecipient) {
+						return false, nil
+					}
+					return pr.EL.SoloValidatorFeeRecipient(r.Context(), pubkey, common.HexToAddress(feeRecipient))
+				})
+				if err != nil {
+					logger.Error("Unable to check the withdrawal address of an unknown validator", zap.String("key", pubkey.String()), zap.Error(err))
+					writeInternalError(w, r)
+					return
+				}
+				if !allowed {
+					outcome = outcomeRejectedUnknownValidator
+				}
+			}
+			countValidationOutcome(pr.m, outcome)
+			if outcome == outcomeAccepted && expected == nil {
+				pr.m.Counter("register_validator_not_minipool").Inc()
+				metrics.ObserveValidator(authedNodeAddr, pubkey)
+				// Move on to the next pubkey
+				continue
+			}
 
-			if !strings.EqualFold(expectedFeeRecipient.String(), validator.Message.FeeRecipient) {
+			switch outcome {
+			case outcomeRejectedNodeMismatch:
+				pr.warnRejection(r, pubkey, "Pubkey not found in EL cache. Not an RP validator?", zap.String("key", pubkey.String()))
+				rejectEntry(w, r, http.StatusForbidden, "validator "+pubkey.String()+" is not one of your minipools", entry, nil)
+				return
+			case outcomeRejectedUnknownValidator:
+				pr.m.Counter("register_validator_unknown_denied").Inc()
+				pr.warnRejection(r, pubkey, "register_validator called for a validator which isn't a known minipool",
+					zap.String("key", pubkey.String()), zap.String("policy", string(policy)))
+				message := "validator " + pubkey.String() + " is not a known minipool, and the " + string(policy) + " policy rejects it"
+				rejectEntry(w, r, http.StatusForbidden, message, entry, nil)
+				return
+			case outcomeRejectedWrongFeeRecipient:
 				pr.m.Counter("register_validator_incorrect_fee_recipient").Inc()
-				pr.Logger.Warn("register_validator called with unexpected fee recipient",
-					zap.String("expected", expectedFeeRecipient.String()), zap.String("got", validator.Message.FeeRecipient))
-				w.WriteHeader(http.StatusConflict)
+				newFeeRecipientRejection(r.URL.Path, authedNodeAddr, pubkey, feeRecipient, expected).log(pr.auditLogger(r))
+				pr.warnRejection(r, pubkey, "register_validator called with unexpected fee recipient", zap.String("key", pubkey.String()),
+					zap.String("expected", expected.Expected.String()), zap.String("got", feeRecipient))
+				rejectEntry(w, r, http.StatusConflict, "validator "+pubkey.String()+" must use fee recipient "+expected.Expected.String(), entry, &expected.Expected)
 				return
 			}
 
@@ -200,59 +836,233 @@ func (pr *ProxyRouter) registerValidator() http.HandlerFunc {
 		}
 
 		// At this point all the fee recipients match our expectations. Proxy the request
+		if pr.abandoned(w, r, "validation") {
+			return
+		}
+		guardedDecisionFrom(r.Context()).accept()
 		pr.proxy.ServeHTTP(w, r)
 	}
 }
 
+func (pr *ProxyRouter) healthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := pr.logger(r)
+		pr.m.Counter("healthz").Inc()
+
+		// Tell load balancers to stop sending traffic while in-flight requests finish
+		if pr.isDraining() {
+			pr.m.Counter("healthz_draining").Inc()
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		// Until the cache is warm, there's no status to report
+		if pr.EL.Warming() {
+			pr.healthzWarming(w, r)
+			return
+		}
+
+		// The head is the one the EL last fetched, so health checks never wait on the EC
+		status, err := pr.EL.CachedStatus()
+		if err != nil {
+			logger.Warn("Error getting EL status for healthcheck", zap.Error(err))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if status.Stale {
+			pr.m.Counter("healthz_stale").Inc()
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			logger.Warn("Error writing healthcheck response", zap.Error(err))
+		}
+	}
+}
+
 // Adds authentication to any handler.
 func (pr *ProxyRouter) authenticationMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := pr.logger(r)
 		// If this is an "internal" request, do not bother with auth
 		if strings.HasPrefix(r.RequestURI, "/_/") {
-			pr.Logger.Debug("Request on unauthenticated endpoint", zap.String("uri", r.RequestURI))
+			logger.Debug("Request on unauthenticated endpoint", zap.String("uri", r.RequestURI))
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Authenticate the request here, return 403 and exit early as needed.
-		// Start by grabbing basicauth
-		username, password, ok := r.BasicAuth()
-		if !ok {
+		if !pr.limitAuthFailures(w, r) {
+			return
+		}
+
+		// Authenticate the request here, return 401 or 403 and exit early as needed.
+		// Start by grabbing the credential, from basic auth or one of the headers that can carry it
+		username, password, source, malformedErr := requestCredentials(r)
+		if malformedErr != nil {
+			pr.m.Counter("malformed_credentials").Inc()
+			logger.Debug("Received request with malformed credentials", zap.Error(malformedErr))
+			writeJSONError(w, r, malformedErr.httpStatus, malformedErr.Error())
+			return
+		}
+		if source == "" {
 			pr.m.Counter("missing_credentials").Inc()
-			pr.Logger.Debug("Received request with no credentials on guarded endpoint")
-			w.WriteHeader(http.StatusUnauthorized)
+			logger.Debug("Received request with no credentials on guarded endpoint")
+			writeJSONError(w, r, http.StatusUnauthorized, "authentication failed, missing credentials")
 			return
 		}
 
-		ac, err := authenticate(username, password)
+		ac, err := tracedAuthenticate(r.Context(), username, password)
 		if err != nil {
 			pr.m.Counter("unauthed").Inc()
-			pr.Logger.Debug("Unable to authenticate credentials", zap.Error(err))
-			w.WriteHeader(err.httpStatus)
+			pr.authFailed(r)
+			logger.Debug("Unable to authenticate credentials", zap.Error(err))
+			writeJSONError(w, r, err.httpStatus, err.Error())
+			return
+		}
+
+		if !pr.checkNodeRegistered(w, r, ac) {
 			return
 		}
 
 		// If auth succeeds:
 		pr.m.Counter("auth_ok").Inc()
-		pr.Logger.Debug("Proxying Guarded URI", zap.String("uri", r.RequestURI))
-		// Add the node address to the request context
-		ctx := context.WithValue(r.Context(), prContextKey("node"), ac.Credential.NodeId)
+		pr.m.CounterVec("auth_ok_source", "source").WithLabelValues(source).Inc()
+		if ac.WithinGrace {
+			logger.Info("Accepted an expired credential within the grace period",
+				zap.String("subject", ac.SubjectKey()), zap.String("credential_id", ac.ID))
+		}
+		logger.Debug("Proxying Guarded URI", zap.String("uri", r.RequestURI), zap.String("credential_id", ac.ID))
+		// Add the credential, its node address, operator type and any fee recipients it's restricted to to the request context
+		ctx := context.WithValue(r.Context(), prContextKey("credential"), ac)
+		ctx = context.WithValue(ctx, prContextKey("node"), ac.NodeAddress.Bytes())
+		ctx = context.WithValue(ctx, prContextKey("operator_type"), ac.OperatorType)
+		ctx = context.WithValue(ctx, prContextKey("fee_recipients"), ac.FeeRecipients)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func (pr *ProxyRouter) Init(beaconNode *url.URL) {
+// checkNodeRegistered replies 403 and returns false if RequireRegisteredNode is set and ac is a Rocket Pool node credential
+// whose node was never registered. Credentials restricted to their own fee recipients may name a placeholder node, and
+// solo nodes aren't registered by definition, so neither is checked.
+func (pr *ProxyRouter) checkNodeRegistered(w http.ResponseWriter, r *http.Request, ac *auth.Credential) bool {
+	if !pr.RequireRegisteredNode || !ac.IsNode() || ac.OperatorType == auth.OperatorSolo || len(ac.FeeRecipients) > 0 {
+		return true
+	}
 
-	// Create the reverse proxy.
-	pr.proxy = httputil.NewSingleHostReverseProxy(beaconNode)
+	registered, err := pr.EL.NodeRegistered(r.Context(), ac.NodeAddress)
+	if err != nil {
+		pr.logger(r).Warn("Unable to check whether the credential's node is registered",
+			zap.String("node", ac.NodeAddress.String()), zap.Error(err))
+		writeInternalError(w, r)
+		return false
+	}
+	if registered {
+		return true
+	}
+
+	pr.m.Counter("unregistered_node").Inc()
+	pr.logger(r).Debug("Rejecting credential for a node which isn't registered with Rocket Pool",
+		zap.String("node", ac.NodeAddress.String()), zap.String("credential_id", ac.ID))
+	writeJSONError(w, r, http.StatusForbidden, fmt.Sprintf("node %s is not registered with Rocket Pool", ac.NodeAddress.String()))
+	return false
+}
+
+// newReverseProxy creates the proxy to the beacon nodes, which upstreams picks between. Request headers,
+// including Accept-Encoding, are forwarded as-is, and since the transport only decompresses responses to
+// requests it compressed itself, compressed responses stay compressed all the way to the validator client.
+func newReverseProxy(upstreams *upstreamPool) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			if _, ok := req.Header["User-Agent"]; !ok {
+				// Explicitly disable the default User-Agent, like httputil.NewSingleHostReverseProxy does
+				req.Header.Set("User-Agent", "")
+			}
+		},
+		Transport: upstreams,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			// Requests the client abandoned aren't the beacon node's fault, so are counted apart from its errors
+			if ctx := r.Context(); ctx.Err() != nil {
+				upstreams.m.CounterVec("requests_cancelled", "stage", "reason").WithLabelValues("upstream", cancellationReason(ctx)).Inc()
+				if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					writeJSONError(w, r, http.StatusGatewayTimeout, "the request timed out")
+					return
+				}
+				// The client went away, so there's nobody to reply to
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+
+			upstreams.m.Counter("upstream_errors").Inc()
+			requestLogger(r.Context(), upstreams.logger).Warn("Error proxying request", zap.String("uri", r.RequestURI), zap.Error(err))
+			writeJSONError(w, r, http.StatusBadGateway, "unable to reach the beacon node")
+		},
+	}
+}
+
+// Init creates the proxy to the given beacon nodes, and starts checking their health.
+// Requests are spread between the healthy ones.
+func (pr *ProxyRouter) Init(beaconNodes []*url.URL) {
 
 	pr.m = metrics.NewMetricsRegistry("http_proxy")
+	pr.rejectionLogs = newRejectionSampler(pr.RejectionLogWindow)
+	pr.draining = make(chan struct{})
+
+	if pr.HealthCheckInterval == 0 {
+		pr.HealthCheckInterval = defaultHealthCheckInterval
+	}
+
+	if pr.AuditLogger == nil {
+		pr.AuditLogger = zap.NewNop()
+	}
+
+	// Unless other sources are configured, fee recipients are those of Rocket Pool minipools
+	if pr.FeeRecipients == nil {
+		pr.FeeRecipients = pr.EL
+	}
+
+	if pr.UnknownValidatorPolicy == "" {
+		pr.UnknownValidatorPolicy = UnknownValidatorAllow
+	}
+
+	if pr.WarmupPolicy == "" {
+		pr.WarmupPolicy = WarmupFailClosed
+	}
+
+	if pr.ResponseCacheTTLs == nil {
+		pr.ResponseCacheTTLs = DefaultResponseCacheTTLs
+	}
+
+	if pr.PathPolicy.Mode == "" {
+		pr.PathPolicy.Mode = PathDefaultAllow
+	}
+
+	if pr.PathPolicy.Allow == nil {
+		pr.PathPolicy.Allow = DefaultAllowedPaths
+	}
+
+	// Create the reverse proxy. Requests go to whichever beacon nodes are current when they're sent,
+	// so that they can be reloaded.
+	current := pr.newLive(Settings{
+		BeaconNodes:            beaconNodes,
+		UnknownValidatorPolicy: pr.UnknownValidatorPolicy,
+		GuardedRateLimit:       pr.GuardedRateLimit,
+		GuardedRateBurst:       pr.GuardedRateBurst,
+		IPRateLimit:            pr.IPRateLimit,
+		IPRateBurst:            pr.IPRateBurst,
+	}, nil)
+	pr.live.Store(current)
+	pr.proxy = newReverseProxy(current.upstreams)
+	pr.proxy.Transport = liveUpstreams{pr}
+	pr.eventsProxy = newEventsProxy(current.upstreams)
+	pr.eventsProxy.Transport = liveUpstreams{pr}
 
 	router := mux.NewRouter()
 
 	// Path to check the status of the rescue node. Simply 200 OK.
 	router.Path("/_/status").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		pr.Logger.Debug("Received healthcheck, replying 200 OK")
+		pr.logger(r).Debug("Received healthcheck, replying 200 OK")
 		_, err := w.Write([]byte("OK\n"))
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -263,16 +1073,90 @@ func (pr *ProxyRouter) Init(beaconNode *url.URL) {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	router.Path("/eth/v1/validator/prepare_beacon_proposer").
-		HandlerFunc(pr.prepareBeaconProposer())
+	// Path to check whether the EL cache is warm and current. 200 OK if so, 503 otherwise.
+	router.Path("/_/healthz").HandlerFunc(pr.healthz())
+
+	router.Path(prepareBeaconProposerPath).
+		HandlerFunc(timeValidation(pr.publishGuarded(pr.limitGuarded(pr.guard(GuardPrepareBeaconProposer, pr.prepareBeaconProposer())))))
+
+	router.Path(registerValidatorPath).
+		HandlerFunc(timeValidation(pr.publishGuarded(pr.limitGuarded(pr.guard(GuardRegisterValidator, pr.registerValidator())))))
+
+	// So are the fee recipients of published blocks
+	for path, blinded := range map[string]bool{
+		publishBlockPath:          false,
+		publishBlockV2Path:        false,
+		publishBlindedBlockPath:   true,
+		publishBlindedBlockV2Path: true,
+	} {
+		router.Path(path).Methods(http.MethodPost).
+			HandlerFunc(timeValidation(pr.publishGuarded(pr.limitGuarded(pr.guard(GuardPublishBlock, pr.publishBlock(blinded))))))
+	}
+
+	// Fee recipients set through the keymanager API are checked too
+	pr.keymanagerRoutes(router)
+
+	// Node operators can ask which fee recipient their validators should use
+	router.Path(feeRecipientLookupPath).Methods(http.MethodGet).HandlerFunc(pr.feeRecipientLookup())
 
-	router.Path("/eth/v1/validator/register_validator").
-		HandlerFunc(pr.registerValidator())
+	// Server-sent events need to be streamed, rather than buffered
+	router.Path(eventsPath).HandlerFunc(pr.eventStream())
+
+	// Responses from endpoints that rarely change are cached
+	cache := newResponseCache(pr.m)
+	for path, ttl := range pr.ResponseCacheTTLs {
+		router.Path(path).Methods(http.MethodGet).HandlerFunc(cache.handler(pr.proxy, ttl))
+	}
+
+	// The proxy's own paths are never proxied, so the path policy can allow them all
+	for _, prefix := range []string{"/_/", rescuePathPrefix} {
+		router.PathPrefix(prefix).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeJSONError(w, r, http.StatusNotFound, "not found")
+		})
+	}
 
 	// By default, simply reverse-proxy every request
 	router.PathPrefix("/").Handler(pr.proxy)
 
-	// Install the authentication middleware
+	// Install the request ID, deadline, rate limiting, authentication, path policy and latency middleware.
+	// Only requests which are authenticated and allowed are timed.
+	router.Use(pr.requestIDMiddleware)
+	router.Use(pr.deadlineMiddleware)
+	router.Use(pr.ipRateLimitMiddleware)
 	router.Use(pr.authenticationMiddleware)
-	http.Handle("/", router)
+	router.Use(pr.pathPolicyMiddleware)
+	router.Use(pr.latencyMiddleware)
+	pr.handler = otelhttp.NewHandler(router, "http_proxy")
+}
+
+// ServeHTTP proxies a request, once Init has been called.
+// The proxy has its own handler, rather than http.DefaultServeMux, so debug handlers registered there aren't served publicly.
+func (pr *ProxyRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pr.handler.ServeHTTP(w, r)
+}
+
+// Drain reports the proxy as not ready on /_/healthz, and ends any event streams,
+// so that the server can be shut down once the remaining requests finish.
+func (pr *ProxyRouter) Drain() {
+	pr.drainOnce.Do(func() {
+		pr.Logger.Info("Draining in-flight requests")
+		close(pr.draining)
+	})
+}
+
+func (pr *ProxyRouter) isDraining() bool {
+	select {
+	case <-pr.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// Deinit stops checking the health of the beacon nodes
+func (pr *ProxyRouter) Deinit() {
+	pr.reloadLock.Lock()
+	defer pr.reloadLock.Unlock()
+
+	pr.live.Load().upstreams.stop()
 }
@@ -0,0 +1,630 @@
+package router
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/auth"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/consensuslayer"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/executionlayer"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/internal/mocks"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// mixedProposers has minipools with wrong fee recipients at positions 0 and 2, and unknown validators at 1 and 3.
+// Like validator clients send it, the body isn't compact, so re-encoding it changes its length.
+func mixedProposers() ([]byte, map[int]common.Address) {
+	body := []byte(`[
+  {"validator_index": "1", "fee_recipient": "0x1111111111111111111111111111111111111111"},
+  {"validator_index": "2", "fee_recipient": "0xabcdefabcdefabcdefabcdefabcdefabcdefabcd"},
+  {"validator_index": "3", "fee_recipient": "0x3333333333333333333333333333333333333333"},
+  {"validator_index": "4", "fee_recipient": "0xABCDEFABCDEFABCDEFABCDEFABCDEFABCDEFABCD"}
+]`)
+
+	corrections := map[int]common.Address{
+		0: common.HexToAddress("0x2222222222222222222222222222222222222222"),
+		2: common.HexToAddress("0x4444444444444444444444444444444444444444"),
+	}
+	return body, corrections
+}
+
+func TestRewriteProposerFeeRecipients(t *testing.T) {
+	body, corrections := mixedProposers()
+
+	var proposers consensuslayer.PrepareBeaconProposerRequest
+	if err := json.Unmarshal(body, &proposers); err != nil {
+		t.Fatal(err)
+	}
+	original := append(consensuslayer.PrepareBeaconProposerRequest(nil), proposers...)
+
+	rewritten, err := rewriteProposerFeeRecipients(proposers, corrections, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got consensuslayer.PrepareBeaconProposerRequest
+	if err := json.Unmarshal(rewritten, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(original) {
+		t.Fatalf("expected %d proposers, got %d", len(original), len(got))
+	}
+	for i := range original {
+		if got[i].ValidatorIndex != original[i].ValidatorIndex {
+			t.Errorf("expected proposer %d to keep index %s, got %s", i, original[i].ValidatorIndex, got[i].ValidatorIndex)
+		}
+
+		expected := original[i].FeeRecipient
+		if correction, ok := corrections[i]; ok {
+			expected = correction.String()
+		}
+		// Unknown validators' entries are untouched, down to their case
+		if got[i].FeeRecipient != expected {
+			t.Errorf("expected proposer %d to have fee recipient %s, got %s", i, expected, got[i].FeeRecipient)
+		}
+	}
+}
+
+func TestSetRequestBody(t *testing.T) {
+	body, corrections := mixedProposers()
+	var proposers consensuslayer.PrepareBeaconProposerRequest
+	if err := json.Unmarshal(body, &proposers); err != nil {
+		t.Fatal(err)
+	}
+	rewritten, err := rewriteProposerFeeRecipients(proposers, corrections, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rewritten) == len(body) {
+		t.Fatal("expected the rewritten body to change length, so Content-Length is exercised")
+	}
+
+	// The upstream beacon node must receive exactly the rewritten body
+	var received []byte
+	var contentLength string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentLength = r.Header.Get("Content-Length")
+		received, _ = io.ReadAll(r.Body)
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/eth/v1/validator/prepare_beacon_proposer", bytes.NewReader(body))
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	setRequestBody(r, rewritten)
+	httputil.NewSingleHostReverseProxy(upstreamURL).ServeHTTP(httptest.NewRecorder(), r)
+
+	if !bytes.Equal(received, rewritten) {
+		t.Fatalf("expected upstream to receive %s, got %s", rewritten, received)
+	}
+	if contentLength != strconv.Itoa(len(rewritten)) {
+		t.Fatalf("expected Content-Length %d, got %s", len(rewritten), contentLength)
+	}
+}
+
+var (
+	testNode           = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	testOtherNode      = common.HexToAddress("0x2222222222222222222222222222222222222222")
+	testSmoothingPool  = common.HexToAddress("0x3333333333333333333333333333333333333333")
+	testFeeDistributor = common.HexToAddress("0x4444444444444444444444444444444444444444")
+	testWithdrawalAddr = common.HexToAddress("0x5555555555555555555555555555555555555555")
+	testWrongRecipient = common.HexToAddress("0x6666666666666666666666666666666666666666")
+)
+
+func testValidatorPubkey(b byte) rptypes.ValidatorPubkey {
+	var out rptypes.ValidatorPubkey
+	out[0] = b
+	return out
+}
+
+// guardedRouter returns pr, proxying to bn, with a MockExecutionLayer which knows:
+//   - 0x01, a minipool of testNode, which isn't in the smoothing pool
+//   - 0x02, a minipool of testOtherNode, which is
+//   - 0x03, a minipool of a node which isn't in the cache
+//   - 0x04, a validator with testWithdrawalAddr as its withdrawal address
+//   - 0x05, a validator with BLS withdrawal credentials
+func guardedRouter(t testing.TB, pr *ProxyRouter, bn *fakeBeaconNode) (*ProxyRouter, *mocks.MockExecutionLayer) {
+	el := mocks.NewMockExecutionLayer(testSmoothingPool)
+	el.AddNode(testNode, false, testFeeDistributor)
+	el.AddNode(testOtherNode, true, common.Address{})
+	el.AddMinipool(testNode, testValidatorPubkey(0x01))
+	el.AddMinipool(testOtherNode, testValidatorPubkey(0x02))
+	el.AddMinipool(common.HexToAddress("0x7777777777777777777777777777777777777777"), testValidatorPubkey(0x03))
+	el.SetWithdrawalAddress(testValidatorPubkey(0x04), &testWithdrawalAddr)
+	el.SetWithdrawalAddress(testValidatorPubkey(0x05), nil)
+
+	// Accepted validators are counted for the epoch metrics
+	metrics.InitEpochMetrics()
+	pr.Logger = zap.NewNop()
+	pr.AuditLogger = zap.NewNop()
+	pr.m = metrics.NewMetricsRegistry("http_proxy")
+	pr.EL = el
+	pr.FeeRecipients = el
+	if pr.UnknownValidatorPolicy == "" {
+		pr.UnknownValidatorPolicy = UnknownValidatorAllow
+	}
+	pr.live.Store(pr.newLive(Settings{
+		BeaconNodes:            []*url.URL{bn.url},
+		UnknownValidatorPolicy: pr.UnknownValidatorPolicy,
+		GuardedRateBurst:       1,
+		IPRateBurst:            1,
+	}, nil))
+	pr.proxy = newReverseProxy(pr.live.Load().upstreams)
+	pr.proxy.Transport = liveUpstreams{pr}
+	return pr, el
+}
+
+// guardedRequest is a request to a guarded endpoint authenticated as testNode, with the given operator type
+func guardedRequest(path string, body string, operatorType auth.OperatorType) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	ctx := context.WithValue(r.Context(), prContextKey("node"), testNode.Bytes())
+	ctx = context.WithValue(ctx, prContextKey("operator_type"), operatorType)
+	return r.WithContext(ctx)
+}
+
+func registration(pubkey rptypes.ValidatorPubkey, feeRecipient common.Address) string {
+	return fmt.Sprintf(`[{"message": {"fee_recipient": "%s", "pubkey": "0x%s"}}]`, feeRecipient, pubkey.Hex())
+}
+
+func TestRegisterValidator(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		pubkey       rptypes.ValidatorPubkey
+		feeRecipient common.Address
+		operatorType auth.OperatorType
+		policy       UnknownValidatorPolicy
+		code         int
+	}{
+		{"fee distributor", testValidatorPubkey(0x01), testFeeDistributor, auth.OperatorRocketPool, "", http.StatusOK},
+		{"wrong fee recipient", testValidatorPubkey(0x01), testWrongRecipient, auth.OperatorRocketPool, "", http.StatusConflict},
+		{"minipool of another node", testValidatorPubkey(0x02), testSmoothingPool, auth.OperatorRocketPool, "", http.StatusForbidden},
+		{"inconsistent cache", testValidatorPubkey(0x03), testFeeDistributor, auth.OperatorRocketPool, "", http.StatusForbidden},
+		{"allowed unknown validator", testValidatorPubkey(0x04), testWrongRecipient, auth.OperatorRocketPool, UnknownValidatorAllow, http.StatusOK},
+		{"denied unknown validator", testValidatorPubkey(0x04), testWithdrawalAddr, auth.OperatorRocketPool, UnknownValidatorDeny, http.StatusForbidden},
+		{"unknown validator using its withdrawal address", testValidatorPubkey(0x04), testWithdrawalAddr, auth.OperatorRocketPool, UnknownValidatorRequireSoloAuth, http.StatusOK},
+		{"unknown validator not using its withdrawal address", testValidatorPubkey(0x04), testWrongRecipient, auth.OperatorRocketPool, UnknownValidatorRequireSoloAuth, http.StatusForbidden},
+		{"solo validator using its withdrawal address", testValidatorPubkey(0x04), testWithdrawalAddr, auth.OperatorSolo, "", http.StatusOK},
+		{"solo validator with the wrong fee recipient", testValidatorPubkey(0x04), testWrongRecipient, auth.OperatorSolo, "", http.StatusConflict},
+		{"solo validator with BLS withdrawal credentials", testValidatorPubkey(0x05), testWithdrawalAddr, auth.OperatorSolo, "", http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testMetrics(t)
+			bn := newFakeBeaconNode(t, "bn")
+			pr, _ := guardedRouter(t, &ProxyRouter{SkipStatusCheck: true, UnknownValidatorPolicy: tc.policy}, bn)
+
+			w := httptest.NewRecorder()
+			pr.registerValidator()(w, guardedRequest(registerValidatorPath, registration(tc.pubkey, tc.feeRecipient), tc.operatorType))
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d %s", tc.code, w.Code, w.Body.String())
+			}
+
+			// Only accepted registrations reach the beacon node
+			proxied := int64(0)
+			if tc.code == http.StatusOK {
+				proxied = 1
+			}
+			if bn.requests.Load() != proxied {
+				t.Fatalf("expected %d upstream requests, got %d", proxied, bn.requests.Load())
+			}
+		})
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	testMetrics(t)
+	bn := newFakeBeaconNode(t, "bn")
+	pr, el := guardedRouter(t, &ProxyRouter{}, bn)
+	el.SetBlocks(100, 101)
+
+	// The cached status is reported, so an EC which can't be reached right now doesn't hold the check up
+	el.StatusErr = fmt.Errorf("connection refused")
+	w := httptest.NewRecorder()
+	pr.healthz()(w, httptest.NewRequest(http.MethodGet, "/_/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	var status executionlayer.Status
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.HighestBlock != 100 || status.Head != 101 || status.Stale {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	el.SetStale(true)
+	w = httptest.NewRecorder()
+	pr.healthz()(w, httptest.NewRequest(http.MethodGet, "/_/healthz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected a stale cache to report not ready, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRegisterValidatorSmoothingPool(t *testing.T) {
+	testMetrics(t)
+	bn := newFakeBeaconNode(t, "bn")
+	pr, el := guardedRouter(t, &ProxyRouter{SkipStatusCheck: true}, bn)
+
+	// Once the node opts in, its minipools must use the smoothing pool
+	if err := el.SetSmoothingPoolStatus(testNode, true); err != nil {
+		t.Fatal(err)
+	}
+	for feeRecipient, code := range map[common.Address]int{
+		testSmoothingPool:  http.StatusOK,
+		testFeeDistributor: http.StatusConflict,
+	} {
+		w := httptest.NewRecorder()
+		pr.registerValidator()(w, guardedRequest(registerValidatorPath, registration(testValidatorPubkey(0x01), feeRecipient), auth.OperatorRocketPool))
+		if w.Code != code {
+			t.Fatalf("expected %d for fee recipient %s, got %d", code, feeRecipient, w.Code)
+		}
+	}
+}
+
+func TestRegisterValidatorAllowlisted(t *testing.T) {
+	testMetrics(t)
+	core, logs := observer.New(zapcore.InfoLevel)
+	bn := newFakeBeaconNode(t, "bn")
+	pr, el := guardedRouter(t, &ProxyRouter{SkipStatusCheck: true}, bn)
+	pr.AuditLogger = zap.New(core)
+
+	rETH := common.HexToAddress("0xaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeae")
+	el.SetAllowedFeeRecipients(executionlayer.AllowedFeeRecipient{Name: "rocketTokenRETH", Address: rETH})
+
+	for feeRecipient, code := range map[common.Address]int{
+		rETH:               http.StatusOK,
+		testWrongRecipient: http.StatusConflict,
+	} {
+		w := httptest.NewRecorder()
+		pr.registerValidator()(w, guardedRequest(registerValidatorPath, registration(testValidatorPubkey(0x01), feeRecipient), auth.OperatorRocketPool))
+		if w.Code != code {
+			t.Fatalf("expected %d for fee recipient %s, got %d", code, feeRecipient, w.Code)
+		}
+	}
+
+	allowlisted := logs.FilterMessage("Accepted allowlisted fee recipient").All()
+	if len(allowlisted) != 1 || allowlisted[0].ContextMap()["reason"] != "rocketTokenRETH" {
+		t.Fatalf("expected the allowlisted fee recipient to be audited with its reason, got %v", allowlisted)
+	}
+	if got := testutil.ToFloat64(pr.m.CounterVec("validation_outcome", "outcome").WithLabelValues(string(outcomeAcceptedAllowlisted))); got != 1 {
+		t.Fatalf("expected 1 allowlisted outcome, got %v", got)
+	}
+}
+
+func TestRegisterValidatorUnenforcedStatus(t *testing.T) {
+	testMetrics(t)
+	bn := newFakeBeaconNode(t, "bn")
+	unenforced := map[feerecipient.MinipoolStatus]bool{feerecipient.MinipoolStatusDissolved: true}
+	pr, el := guardedRouter(t, &ProxyRouter{SkipStatusCheck: true, UnenforcedStatuses: unenforced}, bn)
+
+	// Staking minipools must use their fee recipient, but dissolved ones may use any
+	for status, code := range map[feerecipient.MinipoolStatus]int{
+		feerecipient.MinipoolStatusStaking:   http.StatusConflict,
+		feerecipient.MinipoolStatusDissolved: http.StatusOK,
+	} {
+		el.SetMinipoolStatus(testValidatorPubkey(0x01), status)
+		w := httptest.NewRecorder()
+		pr.registerValidator()(w, guardedRequest(registerValidatorPath, registration(testValidatorPubkey(0x01), testWrongRecipient), auth.OperatorRocketPool))
+		if w.Code != code {
+			t.Fatalf("expected %d for a %s minipool, got %d", code, status, w.Code)
+		}
+	}
+
+	if got := testutil.ToFloat64(pr.m.CounterVec("validation_outcome", "outcome").WithLabelValues(string(outcomeAcceptedMinipoolStatus))); got != 1 {
+		t.Fatalf("expected 1 unenforced outcome, got %v", got)
+	}
+}
+
+func TestRegisterValidatorCacheInconsistent(t *testing.T) {
+	testMetrics(t)
+	core, logs := observer.New(zapcore.InfoLevel)
+	bn := newFakeBeaconNode(t, "bn")
+	pr, _ := guardedRouter(t, &ProxyRouter{SkipStatusCheck: true, UnknownValidatorPolicy: UnknownValidatorAllow}, bn)
+	pr.AuditLogger = zap.New(core)
+
+	// A minipool whose node is missing is refused, rather than allowed like an unknown validator. Node credentials
+	// are refused another node's minipool first, so the request uses a partner credential, which isn't tied to one.
+	r := guardedRequest(registerValidatorPath, registration(testValidatorPubkey(0x03), testFeeDistributor), auth.OperatorRocketPool)
+	partner := &auth.Credential{SubjectType: auth.SubjectPartner, Subject: "acme"}
+	r = r.WithContext(context.WithValue(r.Context(), prContextKey("credential"), partner))
+	w := httptest.NewRecorder()
+	pr.registerValidator()(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", w.Code)
+	}
+	if bn.requests.Load() != 0 {
+		t.Fatal("expected the registration not to reach the beacon node")
+	}
+
+	audited := logs.FilterMessage("Rejected validator with an inconsistent cache entry").All()
+	if len(audited) != 1 {
+		t.Fatalf("expected the inconsistency to be audited, got %v", logs.All())
+	}
+	fields := audited[0].ContextMap()
+	if fields["pubkey"] != testValidatorPubkey(0x03).String() || fields["node"] != testNode.String() ||
+		fields["minipool_node"] != "0x7777777777777777777777777777777777777777" {
+		t.Fatalf("unexpected audit record %v", fields)
+	}
+	if got := testutil.ToFloat64(pr.m.Counter("cache_inconsistent_rejected")); got != 1 {
+		t.Fatalf("expected 1 inconsistent cache rejection, got %v", got)
+	}
+}
+
+func TestRegisterValidatorTooMany(t *testing.T) {
+	testMetrics(t)
+	bn := newFakeBeaconNode(t, "bn")
+	pr, _ := guardedRouter(t, &ProxyRouter{SkipStatusCheck: true, MaxRegistrations: 2}, bn)
+
+	entry := strings.Trim(registration(testValidatorPubkey(0x01), testFeeDistributor), "[]")
+	for _, tc := range []struct {
+		entries int
+		code    int
+	}{
+		{2, http.StatusOK},
+		{3, http.StatusRequestEntityTooLarge},
+	} {
+		body := "[" + strings.TrimSuffix(strings.Repeat(entry+",", tc.entries), ",") + "]"
+		w := httptest.NewRecorder()
+		pr.registerValidator()(w, guardedRequest(registerValidatorPath, body, auth.OperatorRocketPool))
+		if w.Code != tc.code {
+			t.Fatalf("expected %d for %d registrations, got %d %s", tc.code, tc.entries, w.Code, w.Body.String())
+		}
+	}
+
+	if bn.requests.Load() != 1 {
+		t.Fatalf("expected only the smaller request to reach the beacon node, got %d", bn.requests.Load())
+	}
+	if got := testutil.ToFloat64(pr.m.Counter("register_validator_too_many")); got != 1 {
+		t.Fatalf("expected 1 request with too many registrations, got %v", got)
+	}
+}
+
+func TestRegisterValidatorRefusedEarly(t *testing.T) {
+	testMetrics(t)
+	bn := newFakeBeaconNode(t, "bn")
+	pr, _ := guardedRouter(t, &ProxyRouter{SkipStatusCheck: true}, bn)
+
+	// A credential for one validator refuses any other as soon as it's read, so the rest of the body,
+	// malformed or not, isn't
+	refused := strings.Trim(registration(testValidatorPubkey(0x02), testSmoothingPool), "[]")
+	credential := &auth.Credential{SubjectType: auth.SubjectValidatorPubkey, Subject: testValidatorPubkey(0x01).String()}
+	for name, body := range map[string]string{
+		"malformed":   "[" + refused + `, {"message": `,
+		"too many":    "[" + refused + strings.Repeat(","+refused, DefaultMaxRegistrations) + "]",
+		"well formed": "[" + refused + "," + strings.Trim(registration(testValidatorPubkey(0x01), testFeeDistributor), "[]") + "]",
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := guardedRequest(registerValidatorPath, body, auth.OperatorRocketPool)
+			r = r.WithContext(context.WithValue(r.Context(), prContextKey("credential"), credential))
+			w := httptest.NewRecorder()
+			pr.registerValidator()(w, r)
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("expected 403, got %d %s", w.Code, w.Body.String())
+			}
+		})
+	}
+	if bn.requests.Load() != 0 {
+		t.Fatal("expected no registrations to reach the beacon node")
+	}
+}
+
+func TestGuardedStaleCache(t *testing.T) {
+	testMetrics(t)
+	bn := newFakeBeaconNode(t, "bn")
+	pr, el := guardedRouter(t, &ProxyRouter{SkipStatusCheck: true, RejectWhenStale: true}, bn)
+	el.SetStale(true)
+
+	body := registration(testValidatorPubkey(0x01), testFeeDistributor)
+	for path, handler := range map[string]http.HandlerFunc{
+		prepareBeaconProposerPath: pr.prepareBeaconProposer(),
+		registerValidatorPath:     pr.registerValidator(),
+	} {
+		w := httptest.NewRecorder()
+		handler(w, guardedRequest(path, body, auth.OperatorRocketPool))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("expected %s to be refused while the cache is stale, got %d", path, w.Code)
+		}
+	}
+
+	// Once it's caught up, requests are checked as usual
+	el.SetStale(false)
+	w := httptest.NewRecorder()
+	pr.registerValidator()(w, guardedRequest(registerValidatorPath, body, auth.OperatorRocketPool))
+	if w.Code != http.StatusOK || bn.requests.Load() != 1 {
+		t.Fatalf("expected the registration to be proxied, got %d", w.Code)
+	}
+}
+
+func gzipped(t testing.TB, body []byte) []byte {
+	var out bytes.Buffer
+	w := gzip.NewWriter(&out)
+	if _, err := w.Write(body); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestGuardedBodyTooLarge(t *testing.T) {
+	testMetrics(t)
+	bn := newFakeBeaconNode(t, "bn")
+	pr, _ := guardedRouter(t, &ProxyRouter{SkipStatusCheck: true, MaxGuardedBodySize: 1024}, bn)
+
+	valid := registration(testValidatorPubkey(0x01), testFeeDistributor)
+	// Whitespace is valid json, so only the size is wrong with these
+	padded := valid + strings.Repeat(" ", 1024)
+	bomb := gzipped(t, []byte(valid+strings.Repeat(" ", 1<<20)))
+
+	for _, tc := range []struct {
+		name    string
+		body    []byte
+		gzipped bool
+		code    int
+	}{
+		{"within the limit", []byte(valid), false, http.StatusOK},
+		{"compressed within the limit", gzipped(t, []byte(valid)), true, http.StatusOK},
+		{"over the limit", []byte(padded), false, http.StatusRequestEntityTooLarge},
+		{"decompressed over the limit", bomb, true, http.StatusRequestEntityTooLarge},
+	} {
+		for path, handler := range map[string]http.HandlerFunc{
+			prepareBeaconProposerPath: pr.prepareBeaconProposer(),
+			registerValidatorPath:     pr.registerValidator(),
+		} {
+			// prepare_beacon_proposer needs a CL to get past parsing, so only register_validator is proxied
+			if tc.code == http.StatusOK && path == prepareBeaconProposerPath {
+				continue
+			}
+
+			r := guardedRequest(path, string(tc.body), auth.OperatorRocketPool)
+			if tc.gzipped {
+				r.Header.Set("Content-Encoding", "gzip")
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tc.code {
+				t.Fatalf("%s: expected %d from %s, got %d %s", tc.name, tc.code, path, w.Code, w.Body.String())
+			}
+		}
+	}
+
+	if bn.requests.Load() != 2 {
+		t.Fatalf("expected only the bodies within the limit to be proxied, got %d requests", bn.requests.Load())
+	}
+}
+
+// FuzzRegisterValidator checks that no body, compressed or not, makes register_validator panic or fail with a 5xx
+var updateGolden = flag.Bool("update-golden", false, "rewrite the golden files under testdata instead of comparing against them")
+
+// TestErrorBodies compares the bodies of rejections with those under testdata/errors
+func TestErrorBodies(t *testing.T) {
+	correct := strings.Trim(registration(testValidatorPubkey(0x01), testFeeDistributor), "[]")
+	wrong := strings.Trim(registration(testValidatorPubkey(0x01), testWrongRecipient), "[]")
+
+	for _, tc := range []struct {
+		name         string
+		body         string
+		encoding     string
+		operatorType auth.OperatorType
+		code         int
+	}{
+		{"wrong_fee_recipient", "[" + correct + "," + wrong + "]", "", auth.OperatorRocketPool, http.StatusConflict},
+		{"other_nodes_minipool", registration(testValidatorPubkey(0x02), testSmoothingPool), "", auth.OperatorRocketPool, http.StatusForbidden},
+		{"solo_wrong_fee_recipient", registration(testValidatorPubkey(0x04), testWrongRecipient), "", auth.OperatorSolo, http.StatusConflict},
+		{"malformed_pubkey", `[{"message": {"fee_recipient": "0x1234", "pubkey": "0x1234"}}]`, "", auth.OperatorRocketPool, http.StatusBadRequest},
+		{"malformed_body", `[{"message": `, "", auth.OperatorRocketPool, http.StatusBadRequest},
+		{"unsupported_encoding", registration(testValidatorPubkey(0x01), testFeeDistributor), "br", auth.OperatorRocketPool, http.StatusUnsupportedMediaType},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testMetrics(t)
+			bn := newFakeBeaconNode(t, "bn")
+			pr, _ := guardedRouter(t, &ProxyRouter{SkipStatusCheck: true}, bn)
+
+			r := guardedRequest(registerValidatorPath, tc.body, tc.operatorType)
+			if tc.encoding != "" {
+				r.Header.Set("Content-Encoding", tc.encoding)
+			}
+			w := httptest.NewRecorder()
+			pr.registerValidator()(w, r)
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d %s", tc.code, w.Code, w.Body.String())
+			}
+			compareGolden(t, filepath.Join("testdata", "errors", tc.name+".json"), w)
+		})
+	}
+
+	t.Run("missing_credentials", func(t *testing.T) {
+		testMetrics(t)
+		pr := &ProxyRouter{Logger: zap.NewNop(), m: metrics.NewMetricsRegistry("http_proxy")}
+		handler := pr.authenticationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Fatal("expected the request to be refused")
+		}))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, registerValidatorPath, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", w.Code)
+		}
+		compareGolden(t, filepath.Join("testdata", "errors", "missing_credentials.json"), w)
+	})
+}
+
+// compareGolden checks that w replied with a json body matching the file at path, or rewrites it with -update-golden
+func compareGolden(t *testing.T, path string, w *httptest.ResponseRecorder) {
+	t.Helper()
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("expected a json body, got %q", contentType)
+	}
+
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, w.Body.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(w.Body.Bytes(), expected) {
+		t.Fatalf("unexpected body for %s\nexpected: %s\ngot:      %s", path, expected, w.Body.String())
+	}
+}
+
+func FuzzRegisterValidator(f *testing.F) {
+	testMetrics(f)
+	bn := newFakeBeaconNode(f, "bn")
+	pr, _ := guardedRouter(f, &ProxyRouter{SkipStatusCheck: true, MaxGuardedBodySize: 64 << 10}, bn)
+	handler := pr.registerValidator()
+
+	for _, body := range []string{
+		registration(testValidatorPubkey(0x01), testFeeDistributor),
+		registration(testValidatorPubkey(0x02), testSmoothingPool),
+		registration(testValidatorPubkey(0x04), testWrongRecipient),
+		`[{"message": {"fee_recipient": "0x1234", "pubkey": "0x1234"}}]`,
+		`[null]`,
+		`{}`,
+	} {
+		f.Add([]byte(body), false)
+		f.Add(gzipped(f, []byte(body)), true)
+	}
+
+	f.Fuzz(func(t *testing.T, body []byte, compressed bool) {
+		r := guardedRequest(registerValidatorPath, string(body), auth.OperatorRocketPool)
+		if compressed {
+			r.Header.Set("Content-Encoding", "gzip")
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code >= http.StatusInternalServerError {
+			t.Fatalf("expected a 4xx or success, got %d %s", w.Code, w.Body.String())
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package router
+
+import (
+	"path"
+	"strings"
+)
+
+// otherRoute labels the metrics of requests for paths which aren't in apiRoutes
+const otherRoute = "other"
+
+// apiRoutes are the beacon API endpoints validator clients use, and the proxy's own, with their path parameters
+// in braces. Metrics are labelled with these rather than raw paths, so each pubkey, slot or state id doesn't
+// become a label value of its own. Earlier routes win when more than one matches.
+var apiRoutes = []string{
+	"/_/status",
+	"/_/healthz",
+	feeRecipientLookupPath,
+
+	"/eth/v1/beacon/genesis",
+	"/eth/v1/beacon/states/{state_id}/root",
+	"/eth/v1/beacon/states/{state_id}/fork",
+	"/eth/v1/beacon/states/{state_id}/finality_checkpoints",
+	"/eth/v1/beacon/states/{state_id}/validators",
+	"/eth/v1/beacon/states/{state_id}/validators/{validator_id}",
+	"/eth/v1/beacon/states/{state_id}/validator_balances",
+	"/eth/v1/beacon/states/{state_id}/committees",
+	"/eth/v1/beacon/states/{state_id}/sync_committees",
+	"/eth/v1/beacon/states/{state_id}/randao",
+	"/eth/v1/beacon/headers",
+	"/eth/v1/beacon/headers/{block_id}",
+	publishBlockPath,
+	publishBlockV2Path,
+	publishBlindedBlockPath,
+	publishBlindedBlockV2Path,
+	"/eth/v1/beacon/blocks/{block_id}/root",
+	"/eth/v1/beacon/blocks/{block_id}/attestations",
+	"/eth/v2/beacon/blocks/{block_id}",
+	"/eth/v1/beacon/blinded_blocks/{block_id}",
+	"/eth/v1/beacon/pool/attestations",
+	"/eth/v2/beacon/pool/attestations",
+	"/eth/v1/beacon/pool/attester_slashings",
+	"/eth/v1/beacon/pool/proposer_slashings",
+	"/eth/v1/beacon/pool/sync_committees",
+	"/eth/v1/beacon/pool/voluntary_exits",
+	"/eth/v1/beacon/pool/bls_to_execution_changes",
+
+	"/eth/v1/config/spec",
+	"/eth/v1/config/fork_schedule",
+	"/eth/v1/config/deposit_contract",
+
+	"/eth/v1/node/identity",
+	"/eth/v1/node/peers",
+	"/eth/v1/node/peer_count",
+	"/eth/v1/node/version",
+	"/eth/v1/node/syncing",
+	healthPath,
+
+	eventsPath,
+
+	prepareBeaconProposerPath,
+	registerValidatorPath,
+	"/eth/v1/validator/duties/attester/{epoch}",
+	"/eth/v1/validator/duties/proposer/{epoch}",
+	"/eth/v1/validator/duties/sync/{epoch}",
+	"/eth/v1/validator/liveness/{epoch}",
+	"/eth/v1/validator/attestation_data",
+	"/eth/v1/validator/aggregate_attestation",
+	"/eth/v1/validator/aggregate_and_proofs",
+	"/eth/v1/validator/beacon_committee_subscriptions",
+	"/eth/v1/validator/sync_committee_subscriptions",
+	"/eth/v1/validator/sync_committee_contribution",
+	"/eth/v1/validator/contribution_and_proofs",
+	"/eth/v1/validator/blinded_blocks/{slot}",
+	"/eth/v2/validator/blocks/{slot}",
+	"/eth/v3/validator/blocks/{slot}",
+
+	// The keymanager API
+	"/eth/v1/keystores",
+	"/eth/v1/remotekeys",
+	"/eth/v1/validator/{pubkey}/feerecipient",
+	"/eth/v1/validator/{pubkey}/gas_limit",
+	"/eth/v1/validator/{pubkey}/graffiti",
+}
+
+// routeTable finds the route a path belongs to
+type routeTable struct {
+	// Each route's segments, by how many there are, in the order they were given
+	routes map[int][][]string
+}
+
+func splitPath(p string) []string {
+	return strings.Split(strings.Trim(p, "/"), "/")
+}
+
+func newRouteTable(routes []string) *routeTable {
+	out := &routeTable{routes: make(map[int][][]string)}
+	for _, route := range routes {
+		segments := splitPath(route)
+		out.routes[len(segments)] = append(out.routes[len(segments)], segments)
+	}
+	return out
+}
+
+// classify returns the first route which matches p, or otherRoute if none does.
+// A parameter in braces matches any one non-empty segment.
+func (t *routeTable) classify(p string) string {
+	segments := splitPath(path.Clean("/" + p))
+
+	for _, route := range t.routes[len(segments)] {
+		matched := true
+		for i, segment := range route {
+			if strings.HasPrefix(segment, "{") {
+				matched = segments[i] != ""
+			} else {
+				matched = segment == segments[i]
+			}
+			if !matched {
+				break
+			}
+		}
+		if matched {
+			return "/" + strings.Join(route, "/")
+		}
+	}
+
+	return otherRoute
+}
+
+var knownRoutes = newRouteTable(apiRoutes)
+
+// classifyRoute returns the route a request path belongs to, for labelling metrics
+func classifyRoute(p string) string {
+	return knownRoutes.classify(p)
+}
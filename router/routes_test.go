@@ -0,0 +1,31 @@
+package router
+
+import "testing"
+
+func TestClassifyRoute(t *testing.T) {
+	for _, tc := range []struct {
+		path  string
+		route string
+	}{
+		{prepareBeaconProposerPath, prepareBeaconProposerPath},
+		{"/eth/v1/node/version", "/eth/v1/node/version"},
+		{"/eth/v1/beacon/states/head/validators/0x" + testValidatorPubkey(0x01).Hex(), "/eth/v1/beacon/states/{state_id}/validators/{validator_id}"},
+		{"/eth/v1/beacon/states/12345/validators/67", "/eth/v1/beacon/states/{state_id}/validators/{validator_id}"},
+		{"/eth/v1/validator/duties/proposer/1000", "/eth/v1/validator/duties/proposer/{epoch}"},
+		{"/eth/v3/validator/blocks/8000000", "/eth/v3/validator/blocks/{slot}"},
+		{"/eth/v1/validator/0x" + testValidatorPubkey(0x01).Hex() + "/feerecipient", "/eth/v1/validator/{pubkey}/feerecipient"},
+		{"/rescue/v1/fee_recipient/0x01", feeRecipientLookupPath},
+		// Trailing slashes and dot segments are cleaned up first
+		{"/eth/v1/node/version/", "/eth/v1/node/version"},
+		{"/eth/v1/node/../node/syncing", "/eth/v1/node/syncing"},
+		// Parameters don't match empty segments, and unknown paths are all the same route
+		{"/eth/v1/beacon/states//validators", otherRoute},
+		{"/lighthouse/health", otherRoute},
+		{"/eth/v1/node/version/0x01", otherRoute},
+		{"/", otherRoute},
+	} {
+		if route := classifyRoute(tc.path); route != tc.route {
+			t.Errorf("expected %s to be classified as %s, got %s", tc.path, tc.route, route)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package router
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/consensuslayer"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	prysmpb "github.com/prysmaticlabs/prysm/v3/proto/prysm/v1alpha1"
+)
+
+// The SSZ size of a proposer preparation, a uint64 validator index followed by a 20 byte fee recipient
+const proposerPreparationSSZSize = 8 + common.AddressLength
+
+// isSSZ returns true if the request body is SSZ encoded rather than json
+func isSSZ(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/octet-stream"
+}
+
+// decodeProposerPreparations parses a prepare_beacon_proposer body, in whichever encoding the request uses
+func decodeProposerPreparations(r *http.Request, body io.Reader) (consensuslayer.PrepareBeaconProposerRequest, error) {
+	var out consensuslayer.PrepareBeaconProposerRequest
+	if !isSSZ(r) {
+		err := json.NewDecoder(body).Decode(&out)
+		return out, err
+	}
+
+	buf, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf)%proposerPreparationSSZSize != 0 {
+		return nil, fmt.Errorf("ssz body of %d bytes isn't a list of proposer preparations", len(buf))
+	}
+
+	out = make(consensuslayer.PrepareBeaconProposerRequest, len(buf)/proposerPreparationSSZSize)
+	for i := range out {
+		item := buf[i*proposerPreparationSSZSize : (i+1)*proposerPreparationSSZSize]
+		out[i].ValidatorIndex = strconv.FormatUint(binary.LittleEndian.Uint64(item[:8]), 10)
+		out[i].FeeRecipient = common.BytesToAddress(item[8:]).Hex()
+	}
+	return out, nil
+}
+
+// encodeProposerPreparationsSSZ is the inverse of decodeProposerPreparations, for SSZ requests
+func encodeProposerPreparationsSSZ(proposers consensuslayer.PrepareBeaconProposerRequest) ([]byte, error) {
+	out := make([]byte, 0, len(proposers)*proposerPreparationSSZSize)
+	for _, proposer := range proposers {
+		index, err := strconv.ParseUint(proposer.ValidatorIndex, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if !common.IsHexAddress(proposer.FeeRecipient) {
+			return nil, fmt.Errorf("invalid fee recipient %s", proposer.FeeRecipient)
+		}
+
+		out = binary.LittleEndian.AppendUint64(out, index)
+		out = append(out, common.HexToAddress(proposer.FeeRecipient).Bytes()...)
+	}
+	return out, nil
+}
+
+// decodeValidatorRegistrations parses a register_validator body, in whichever encoding the request uses, all at once
+func decodeValidatorRegistrations(r *http.Request, body io.Reader) (consensuslayer.RegisterValidatorRequest, error) {
+	out := consensuslayer.RegisterValidatorRequest{}
+	err := streamValidatorRegistrations(r, body, func(message consensuslayer.RegisterValidatorMessage) error {
+		out = append(out, consensuslayer.RegisterValidatorRequest{{Message: message}}...)
+		return nil
+	})
+	return out, err
+}
+
+// streamValidatorRegistrations parses a register_validator body one registration at a time, in whichever encoding
+// the request uses, and calls each with every registration in turn, as it's read. Only one registration is held in
+// memory at once. If each returns an error, the rest of the body isn't read, and the error is returned.
+func streamValidatorRegistrations(r *http.Request, body io.Reader, each func(consensuslayer.RegisterValidatorMessage) error) error {
+	if !isSSZ(r) {
+		return streamJSONValidatorRegistrations(body, each)
+	}
+
+	size := (&prysmpb.SignedValidatorRegistrationV1{}).SizeSSZ()
+	buf := make([]byte, size)
+	for read := 0; ; read += size {
+		n, err := io.ReadFull(body, buf)
+		if err == io.EOF {
+			return nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("ssz body of %d bytes isn't a list of signed validator registrations", read+n)
+		}
+		if err != nil {
+			return err
+		}
+
+		registration := &prysmpb.SignedValidatorRegistrationV1{}
+		if err := registration.UnmarshalSSZ(buf); err != nil {
+			return err
+		}
+
+		err = each(consensuslayer.RegisterValidatorMessage{
+			FeeRecipient: common.BytesToAddress(registration.Message.FeeRecipient).Hex(),
+			Pubkey:       hexutil.Encode(registration.Message.Pubkey),
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// streamJSONValidatorRegistrations reads a json array of registrations token by token, rather than all at once
+func streamJSONValidatorRegistrations(body io.Reader, each func(consensuslayer.RegisterValidatorMessage) error) error {
+	decoder := json.NewDecoder(body)
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	// Like Unmarshal, null is an empty list
+	if token == nil {
+		return nil
+	}
+	if token != json.Delim('[') {
+		return fmt.Errorf("expected a list of signed validator registrations, got %v", token)
+	}
+
+	for decoder.More() {
+		var registration struct {
+			Message consensuslayer.RegisterValidatorMessage `json:"message"`
+		}
+		if err := decoder.Decode(&registration); err != nil {
+			return err
+		}
+		if err := each(registration.Message); err != nil {
+			return err
+		}
+	}
+
+	// Consume the closing bracket, so a truncated list is malformed
+	_, err = decoder.Token()
+	return err
+}
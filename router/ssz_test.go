@@ -0,0 +1,316 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/consensuslayer"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	prysmpb "github.com/prysmaticlabs/prysm/v3/proto/prysm/v1alpha1"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+func sszRequest(path string, body []byte) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/octet-stream")
+	return r
+}
+
+// registrationsSSZ encodes registrations the way Prysm's validator client does
+func registrationsSSZ(t *testing.T, registrations ...*prysmpb.SignedValidatorRegistrationV1) []byte {
+	var out []byte
+	for _, registration := range registrations {
+		buf, err := registration.MarshalSSZ()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, buf...)
+	}
+	return out
+}
+
+func testRegistration(pubkeyByte byte, feeRecipient common.Address) *prysmpb.SignedValidatorRegistrationV1 {
+	return &prysmpb.SignedValidatorRegistrationV1{
+		Message: &prysmpb.ValidatorRegistrationV1{
+			FeeRecipient: feeRecipient.Bytes(),
+			GasLimit:     30000000,
+			Timestamp:    1670000000,
+			Pubkey:       bytes.Repeat([]byte{pubkeyByte}, 48),
+		},
+		Signature: bytes.Repeat([]byte{0xaa}, 96),
+	}
+}
+
+func TestDecodeValidatorRegistrationsSSZ(t *testing.T) {
+	feeRecipient0 := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	feeRecipient1 := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	body := registrationsSSZ(t, testRegistration(0x01, feeRecipient0), testRegistration(0x02, feeRecipient1))
+
+	validators, err := decodeValidatorRegistrations(sszRequest("/eth/v1/validator/register_validator", body), bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(validators) != 2 {
+		t.Fatalf("expected 2 registrations, got %d", len(validators))
+	}
+	for i, expected := range []struct {
+		feeRecipient common.Address
+		pubkey       string
+	}{
+		{feeRecipient0, hexutil.Encode(bytes.Repeat([]byte{0x01}, 48))},
+		{feeRecipient1, hexutil.Encode(bytes.Repeat([]byte{0x02}, 48))},
+	} {
+		if !strings.EqualFold(validators[i].Message.FeeRecipient, expected.feeRecipient.String()) {
+			t.Errorf("expected fee recipient %s, got %s", expected.feeRecipient.String(), validators[i].Message.FeeRecipient)
+		}
+		if validators[i].Message.Pubkey != expected.pubkey {
+			t.Errorf("expected pubkey %s, got %s", expected.pubkey, validators[i].Message.Pubkey)
+		}
+	}
+
+	// Truncated bodies are malformed
+	if _, err := decodeValidatorRegistrations(sszRequest("/", body[:len(body)-1]), bytes.NewReader(body[:len(body)-1])); err == nil {
+		t.Fatal("expected an error for a truncated body")
+	}
+}
+
+func TestStreamValidatorRegistrationsSSZ(t *testing.T) {
+	body := registrationsSSZ(t, testRegistration(0x01, common.Address{}), testRegistration(0x02, common.Address{}), testRegistration(0x03, common.Address{}))
+
+	// Returning an error stops the body being read any further
+	stop := errors.New("stop")
+	var pubkeys []string
+	reader := bytes.NewReader(body)
+	err := streamValidatorRegistrations(sszRequest(registerValidatorPath, body), reader, func(message consensuslayer.RegisterValidatorMessage) error {
+		pubkeys = append(pubkeys, message.Pubkey)
+		if len(pubkeys) == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Fatalf("expected the callback's error, got %v", err)
+	}
+	if len(pubkeys) != 2 || pubkeys[1] != hexutil.Encode(bytes.Repeat([]byte{0x02}, 48)) {
+		t.Fatalf("unexpected registrations %v", pubkeys)
+	}
+	if reader.Len() != len(body)/3 {
+		t.Fatalf("expected the last registration not to be read, %d bytes left", reader.Len())
+	}
+}
+
+func TestStreamValidatorRegistrationsJSON(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, registerValidatorPath, nil)
+	for body, expected := range map[string]int{
+		`null`: 0,
+		`[]`:   0,
+		`[{"message": {"fee_recipient": "0x1111111111111111111111111111111111111111", "pubkey": "0x01"}}, {"message": {"pubkey": "0x02"}}]`: 2,
+	} {
+		n := 0
+		err := streamValidatorRegistrations(r, strings.NewReader(body), func(consensuslayer.RegisterValidatorMessage) error {
+			n++
+			return nil
+		})
+		if err != nil || n != expected {
+			t.Fatalf("expected %d registrations in %s, got %d %v", expected, body, n, err)
+		}
+	}
+
+	for _, body := range []string{``, `{}`, `[{"message": {}}`, `[1]`} {
+		err := streamValidatorRegistrations(r, strings.NewReader(body), func(consensuslayer.RegisterValidatorMessage) error {
+			return nil
+		})
+		if err == nil {
+			t.Fatalf("expected an error for %s", body)
+		}
+	}
+}
+
+// BenchmarkValidatorRegistrations compares the memory used reading a batch of ten thousand registrations all at once,
+// as register_validator used to, with streaming them and keeping only the fields which are checked
+func BenchmarkValidatorRegistrations(b *testing.B) {
+	var entries []string
+	for i := 0; i < 10000; i++ {
+		entries = append(entries, fmt.Sprintf(`{"message": {"fee_recipient": "0x1111111111111111111111111111111111111111", "gas_limit": "30000000", "timestamp": "1670000000", "pubkey": "0x%096x"}, "signature": "0x%0192x"}`, i, i))
+	}
+	body := []byte("[" + strings.Join(entries, ",") + "]")
+	r := httptest.NewRequest(http.MethodPost, registerValidatorPath, nil)
+
+	b.Run("unmarshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var validators consensuslayer.RegisterValidatorRequest
+			if err := json.NewDecoder(bytes.NewReader(body)).Decode(&validators); err != nil {
+				b.Fatal(err)
+			}
+			pubkeys := make([]rptypes.ValidatorPubkey, 0, len(validators))
+			for _, validator := range validators {
+				pubkey, err := rptypes.HexToValidatorPubkey(strings.TrimPrefix(validator.Message.Pubkey, "0x"))
+				if err != nil {
+					b.Fatal(err)
+				}
+				pubkeys = append(pubkeys, pubkey)
+			}
+		}
+	})
+
+	b.Run("stream", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var pubkeys []rptypes.ValidatorPubkey
+			var feeRecipients []string
+			err := streamValidatorRegistrations(r, bytes.NewReader(body), func(message consensuslayer.RegisterValidatorMessage) error {
+				pubkey, err := rptypes.HexToValidatorPubkey(strings.TrimPrefix(message.Pubkey, "0x"))
+				if err != nil {
+					return err
+				}
+				pubkeys = append(pubkeys, pubkey)
+				feeRecipients = append(feeRecipients, message.FeeRecipient)
+				return nil
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestProposerPreparationsSSZRoundTrip(t *testing.T) {
+	proposers := consensuslayer.PrepareBeaconProposerRequest{
+		{ValidatorIndex: "1", FeeRecipient: "0x1111111111111111111111111111111111111111"},
+		{ValidatorIndex: "18446744073709551615", FeeRecipient: "0xabcdefabcdefabcdefabcdefabcdefabcdefabcd"},
+	}
+	body, err := encodeProposerPreparationsSSZ(proposers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != 2*proposerPreparationSSZSize {
+		t.Fatalf("expected %d bytes, got %d", 2*proposerPreparationSSZSize, len(body))
+	}
+	// validator_index is little endian
+	if body[0] != 0x01 || body[7] != 0x00 {
+		t.Fatalf("unexpected validator index encoding %x", body[:8])
+	}
+
+	decoded, err := decodeProposerPreparations(sszRequest("/eth/v1/validator/prepare_beacon_proposer", body), bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(proposers) {
+		t.Fatalf("expected %d proposers, got %d", len(proposers), len(decoded))
+	}
+	for i := range proposers {
+		if decoded[i].ValidatorIndex != proposers[i].ValidatorIndex || !strings.EqualFold(decoded[i].FeeRecipient, proposers[i].FeeRecipient) {
+			t.Errorf("expected %+v, got %+v", proposers[i], decoded[i])
+		}
+	}
+
+	if _, err := decodeProposerPreparations(sszRequest("/", body[:5]), bytes.NewReader(body[:5])); err == nil {
+		t.Fatal("expected an error for a truncated body")
+	}
+}
+
+func TestDecodeJSONBodies(t *testing.T) {
+	body := []byte(`[{"validator_index": "1", "fee_recipient": "0x1111111111111111111111111111111111111111"}]`)
+	r := httptest.NewRequest(http.MethodPost, "/eth/v1/validator/prepare_beacon_proposer", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+
+	proposers, err := decodeProposerPreparations(r, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proposers) != 1 || proposers[0].ValidatorIndex != "1" {
+		t.Fatalf("unexpected proposers %+v", proposers)
+	}
+
+	// A body which is SSZ but labelled as json is malformed
+	ssz := registrationsSSZ(t, testRegistration(0x01, common.Address{}))
+	if _, err := decodeValidatorRegistrations(r, bytes.NewReader(ssz)); err == nil {
+		t.Fatal("expected an error decoding ssz as json")
+	}
+}
+
+// addSSZSeeds adds the SSZ encodings of some well-formed bodies to a fuzz target's corpus.
+// The json seeds, in the formats Lighthouse, Teku and Nimbus send, are under testdata/fuzz.
+func addSSZSeeds(f *testing.F, bodies ...[]byte) {
+	for _, body := range bodies {
+		f.Add(body, true)
+	}
+	f.Add([]byte{}, true)
+}
+
+func FuzzDecodeProposerPreparations(f *testing.F) {
+	seed, err := encodeProposerPreparationsSSZ(consensuslayer.PrepareBeaconProposerRequest{
+		{ValidatorIndex: "1", FeeRecipient: "0x1111111111111111111111111111111111111111"},
+		{ValidatorIndex: "18446744073709551615", FeeRecipient: "0xabcdefabcdefabcdefabcdefabcdefabcdefabcd"},
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+	addSSZSeeds(f, seed)
+
+	f.Fuzz(func(t *testing.T, body []byte, ssz bool) {
+		r := httptest.NewRequest(http.MethodPost, prepareBeaconProposerPath, nil)
+		if ssz {
+			r.Header.Set("Content-Type", "application/octet-stream")
+		}
+
+		proposers, err := decodeProposerPreparations(r, bytes.NewReader(body))
+		if err != nil || !ssz {
+			return
+		}
+
+		// Every SSZ body that decodes re-encodes to the same bytes
+		encoded, err := encodeProposerPreparationsSSZ(proposers)
+		if err != nil {
+			t.Fatalf("couldn't re-encode %+v: %v", proposers, err)
+		}
+		if !bytes.Equal(encoded, body) {
+			t.Fatalf("expected %x to round trip, got %x", body, encoded)
+		}
+	})
+}
+
+func FuzzDecodeValidatorRegistrations(f *testing.F) {
+	var bodies [][]byte
+	for _, registration := range []*prysmpb.SignedValidatorRegistrationV1{
+		testRegistration(0x01, common.HexToAddress("0x1111111111111111111111111111111111111111")),
+		testRegistration(0x02, common.Address{}),
+	} {
+		buf, err := registration.MarshalSSZ()
+		if err != nil {
+			f.Fatal(err)
+		}
+		bodies = append(bodies, buf, append(buf, buf...))
+	}
+	addSSZSeeds(f, bodies...)
+
+	size := (&prysmpb.SignedValidatorRegistrationV1{}).SizeSSZ()
+	f.Fuzz(func(t *testing.T, body []byte, ssz bool) {
+		r := httptest.NewRequest(http.MethodPost, registerValidatorPath, nil)
+		if ssz {
+			r.Header.Set("Content-Type", "application/octet-stream")
+		}
+
+		validators, err := decodeValidatorRegistrations(r, bytes.NewReader(body))
+		if err != nil || !ssz {
+			return
+		}
+
+		if len(validators)*size != len(body) {
+			t.Fatalf("expected %d registrations in %d bytes, got %d", len(body)/size, len(body), len(validators))
+		}
+		for _, validator := range validators {
+			if !common.IsHexAddress(validator.Message.FeeRecipient) || len(validator.Message.Pubkey) != 2+2*48 {
+				t.Fatalf("unexpected registration %+v", validator.Message)
+			}
+		}
+	})
+}
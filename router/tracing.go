@@ -0,0 +1,90 @@
+package router
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/auth"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/consensuslayer"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/executionlayer"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	apiv1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Spans are no-ops until tracing.Init() installs a tracer provider
+var tracer = otel.Tracer("github.com/Rocket-Pool-Rescue-Node/rescue-proxy/router")
+
+// tracedAuthenticate wraps authenticate() in a span, so time spent verifying the credential is visible
+func tracedAuthenticate(ctx context.Context, username, password string) (*auth.Credential, *authenticationError) {
+	_, span := tracer.Start(ctx, "authenticate")
+	defer span.End()
+
+	ac, err := authenticate(username, password)
+	if err != nil {
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	return ac, err
+}
+
+// tracedValidatorPubkeys wraps a CL index to pubkey lookup in a span
+func tracedValidatorPubkeys(ctx context.Context, cl *consensuslayer.ConsensusLayer, indices []string) (map[string]rptypes.ValidatorPubkey, error) {
+	_, span := tracer.Start(ctx, "GetValidatorPubkey", trace.WithAttributes(attribute.Int("validators", len(indices))))
+	defer span.End()
+
+	pubkeyMap, err := cl.GetValidatorPubkey(ctx, indices)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	return pubkeyMap, err
+}
+
+// tracedValidatorFeeRecipient wraps a fee recipient source lookup in a span
+func tracedValidatorFeeRecipient(ctx context.Context, source feerecipients.FeeRecipientSource, pubkey rptypes.ValidatorPubkey, nodeAddr *common.Address) (*feerecipient.Info, error) {
+	_, span := tracer.Start(ctx, "ValidatorFeeRecipient", trace.WithAttributes(attribute.String("pubkey", pubkey.String())))
+	defer span.End()
+
+	info, err := source.ValidatorFeeRecipient(ctx, pubkey, nodeAddr)
+	span.SetAttributes(attribute.Bool("unowned", errors.Is(err, feerecipient.ErrWrongNode)))
+	if info != nil {
+		span.SetAttributes(attribute.String("source", info.Source.String()))
+	}
+	if lookupFailed(err) {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	return info, err
+}
+
+// tracedValidatorWithdrawalAddresses wraps a batch of withdrawal address lookups, which may go to the CL, in a span
+func tracedValidatorWithdrawalAddresses(ctx context.Context, el executionlayer.RPInfoProvider, pubkeys []rptypes.ValidatorPubkey) *withdrawalAddresses {
+	_, span := tracer.Start(ctx, "ValidatorWithdrawalAddresses", trace.WithAttributes(attribute.Int("validators", len(pubkeys))))
+	defer span.End()
+
+	addrs, errs := el.ValidatorWithdrawalAddresses(ctx, pubkeys)
+	span.SetAttributes(attribute.Int("failed", len(errs)))
+	if len(errs) > 0 {
+		span.SetStatus(otelcodes.Error, "some withdrawal addresses couldn't be looked up")
+	}
+	return &withdrawalAddresses{addrs: addrs, errs: errs}
+}
+
+// tracedValidatorStatuses wraps a CL validator status lookup in a span
+func tracedValidatorStatuses(ctx context.Context, cl *consensuslayer.ConsensusLayer, pubkeys []rptypes.ValidatorPubkey) (map[rptypes.ValidatorPubkey]apiv1.ValidatorState, error) {
+	_, span := tracer.Start(ctx, "GetValidatorStatuses", trace.WithAttributes(attribute.Int("validators", len(pubkeys))))
+	defer span.End()
+
+	statuses, err := cl.GetValidatorStatuses(ctx, pubkeys)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	return statuses, err
+}
@@ -0,0 +1,62 @@
+package router
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracedAuthenticate(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	defer func() {
+		_ = provider.Shutdown(context.Background())
+	}()
+
+	cred, err := cm.Create(time.Now(), nodeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	username := cred.Base64URLEncodeUsername()
+	password, err := cred.Base64URLEncodePassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The request's span is the parent of the authentication span
+	ctx, parent := tracer.Start(context.Background(), "request")
+	if _, err := tracedAuthenticate(ctx, username, password); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tracedAuthenticate(ctx, username, "bogus"); err == nil {
+		t.Fatal("expected a bogus password to fail authentication")
+	}
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+
+	for i, expected := range []otelcodes.Code{otelcodes.Unset, otelcodes.Error} {
+		span := spans[i]
+		if span.Name() != "authenticate" {
+			t.Errorf("expected an authenticate span, got %s", span.Name())
+		}
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("expected the authenticate span to be a child of the request span")
+		}
+		if span.Status().Code != expected {
+			t.Errorf("expected status %v, got %v", expected, span.Status().Code)
+		}
+	}
+}
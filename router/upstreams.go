@@ -0,0 +1,308 @@
+package router
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultHealthCheckInterval = 10 * time.Second
+	healthCheckTimeout         = 5 * time.Second
+	healthPath                 = "/eth/v1/node/health"
+)
+
+// UpstreamTimeouts bound how long the proxy waits on a beacon node. 0 disables a timeout.
+type UpstreamTimeouts struct {
+	Dial           time.Duration
+	TLSHandshake   time.Duration
+	ResponseHeader time.Duration
+	// The whole request, including reading the response body. Event streams are exempt.
+	Request time.Duration
+}
+
+// CircuitBreakerConfig sets how many consecutive failures stop requests being sent to a
+// beacon node, and for how long. A Threshold of 0 disables the breaker.
+type CircuitBreakerConfig struct {
+	Threshold int
+	Cooldown  time.Duration
+}
+
+type upstream struct {
+	url     *url.URL
+	healthy atomic.Bool
+	breaker *circuitBreaker
+}
+
+// upstreamPool is the transport the proxies use. It sends each request to the next healthy beacon node
+// in turn, and retries idempotent requests once on another beacon node if the first one fails.
+type upstreamPool struct {
+	upstreams      []*upstream
+	next           atomic.Uint64
+	base           http.RoundTripper
+	requestTimeout time.Duration
+	logger         *zap.Logger
+	m              *metrics.MetricsRegistry
+	// Set once the pool's been replaced by a reload, so requests still in flight on it don't skew the metrics
+	retired atomic.Bool
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newTransport(timeouts UpstreamTimeouts) http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   timeouts.Dial,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = timeouts.TLSHandshake
+	transport.ResponseHeaderTimeout = timeouts.ResponseHeader
+
+	return otelhttp.NewTransport(transport)
+}
+
+func newUpstreamPool(beaconNodes []*url.URL, timeouts UpstreamTimeouts, breaker CircuitBreakerConfig, logger *zap.Logger, m *metrics.MetricsRegistry) *upstreamPool {
+	out := &upstreamPool{
+		base:           newTransport(timeouts),
+		requestTimeout: timeouts.Request,
+		logger:         logger,
+		m:              m,
+	}
+
+	// Every beacon node is assumed healthy until it's checked
+	for _, beaconNode := range beaconNodes {
+		u := &upstream{url: beaconNode}
+		u.healthy.Store(true)
+		u.breaker = newCircuitBreaker(breaker.Threshold, breaker.Cooldown, func(from, to breakerState) {
+			logger.Warn("Beacon node circuit breaker changed state", zap.String("upstream", u.url.Host),
+				zap.Stringer("from", from), zap.Stringer("to", to))
+			m.GaugeVec("upstream_circuit_state", "upstream").WithLabelValues(u.url.Host).Set(float64(to))
+			m.CounterVec("upstream_circuit_transitions", "upstream", "state").WithLabelValues(u.url.Host, to.String()).Inc()
+		})
+		out.upstreams = append(out.upstreams, u)
+	}
+	m.Gauge("upstreams_healthy").Set(float64(len(out.upstreams)))
+
+	return out
+}
+
+// pick returns the index of the next healthy upstream other than exclude, whose circuit breaker is closed.
+// If none are healthy, unhealthy ones are used rather than failing outright. If every circuit
+// breaker is open, it returns -1.
+func (p *upstreamPool) pick(exclude int) int {
+	n := uint64(len(p.upstreams))
+	now := time.Now()
+	start := p.next.Add(1) - 1
+	for i := uint64(0); i < n; i++ {
+		index := int((start + i) % n)
+		u := p.upstreams[index]
+		if index != exclude && u.healthy.Load() && u.breaker.available(now) {
+			return index
+		}
+	}
+
+	for i := uint64(0); i < n; i++ {
+		index := int((start + i) % n)
+		if index != exclude && p.upstreams[index].breaker.available(now) {
+			return index
+		}
+	}
+
+	if exclude >= 0 && p.upstreams[exclude].breaker.available(now) {
+		return exclude
+	}
+	return -1
+}
+
+func (p *upstreamPool) roundTrip(clientCtx context.Context, req *http.Request, index int) (*http.Response, error) {
+	if index < 0 {
+		p.m.Counter("upstream_circuit_rejected").Inc()
+		return nil, errCircuitOpen
+	}
+
+	u := p.upstreams[index]
+	if !u.breaker.allow(time.Now()) {
+		p.m.Counter("upstream_circuit_rejected").Inc()
+		return nil, errCircuitOpen
+	}
+
+	out := req.Clone(req.Context())
+	out.URL.Scheme = u.url.Scheme
+	out.URL.Host = u.url.Host
+	out.URL.Path = strings.TrimSuffix(u.url.Path, "/") + req.URL.Path
+	out.URL.RawPath = ""
+
+	p.m.CounterVec("upstream_requests", "upstream").WithLabelValues(u.url.Host).Inc()
+	requestLogger(req.Context(), p.logger).Debug("Proxying request", zap.String("uri", req.URL.RequestURI()), zap.String("upstream", u.url.Host))
+	resp, err := p.base.RoundTrip(out)
+	if clientCtx.Err() != nil {
+		// The client went away, which says nothing about the beacon node
+		u.breaker.release()
+		return resp, err
+	}
+
+	u.breaker.record(err == nil && resp.StatusCode < http.StatusInternalServerError, time.Now())
+	if err != nil {
+		// Don't wait for the next health check to stop using a beacon node which refuses connections
+		p.setHealthy(index, false)
+	}
+	return resp, err
+}
+
+// cancelOnClose cancels a request's timeout once its response body has been read
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// RoundTrip implements http.RoundTripper
+func (p *upstreamPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	clientCtx := req.Context()
+	if p.requestTimeout > 0 && !isStreaming(clientCtx) {
+		ctx, cancel := context.WithTimeout(clientCtx, p.requestTimeout)
+		req = req.WithContext(ctx)
+
+		resp, err := p.retryingRoundTrip(clientCtx, req)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+		return resp, nil
+	}
+
+	return p.retryingRoundTrip(clientCtx, req)
+}
+
+func (p *upstreamPool) retryingRoundTrip(clientCtx context.Context, req *http.Request) (*http.Response, error) {
+	index := p.pick(-1)
+	resp, err := p.roundTrip(clientCtx, req, index)
+	if err == nil && resp.StatusCode < http.StatusInternalServerError {
+		return resp, nil
+	}
+
+	// Only idempotent requests are retried. Signed objects are POSTed, and mustn't be submitted twice.
+	if len(p.upstreams) < 2 || index < 0 || (req.Method != http.MethodGet && req.Method != http.MethodHead) || req.Context().Err() != nil {
+		return resp, err
+	}
+
+	// A request body can only be replayed if it can be fetched again
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return resp, err
+		}
+		body, bodyErr := req.GetBody()
+		if bodyErr != nil {
+			return resp, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+
+	retry := p.pick(index)
+	if retry < 0 || retry == index {
+		return resp, err
+	}
+	if err == nil {
+		resp.Body.Close()
+	}
+
+	p.m.Counter("upstream_retries").Inc()
+	requestLogger(req.Context(), p.logger).Debug("Retrying request on another upstream", zap.String("uri", req.URL.RequestURI()),
+		zap.String("failed", p.upstreams[index].url.Host), zap.String("upstream", p.upstreams[retry].url.Host))
+	return p.roundTrip(clientCtx, req, retry)
+}
+
+func (p *upstreamPool) setHealthy(index int, healthy bool) {
+	u := p.upstreams[index]
+	if u.healthy.Swap(healthy) == healthy || p.retired.Load() {
+		return
+	}
+
+	if healthy {
+		p.logger.Info("Beacon node is healthy again", zap.String("upstream", u.url.Host))
+		p.m.Gauge("upstreams_healthy").Inc()
+	} else {
+		p.logger.Warn("Beacon node is unhealthy", zap.String("upstream", u.url.Host))
+		p.m.Gauge("upstreams_healthy").Dec()
+	}
+}
+
+func (p *upstreamPool) checkHealth(ctx context.Context, client *http.Client) {
+	for i, u := range p.upstreams {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(u.url.String(), "/")+healthPath, nil)
+		if err != nil {
+			p.setHealthy(i, false)
+			continue
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			p.setHealthy(i, false)
+			continue
+		}
+		resp.Body.Close()
+
+		// 206 means the beacon node is syncing, which is no use to validator clients
+		p.setHealthy(i, resp.StatusCode == http.StatusOK)
+	}
+}
+
+// start checks the health of every upstream on the given interval, until stop is called
+func (p *upstreamPool) start(interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	client := &http.Client{Timeout: healthCheckTimeout}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			p.checkHealth(ctx, client)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (p *upstreamPool) stop() {
+	if p.cancel == nil {
+		return
+	}
+
+	p.cancel()
+	p.wg.Wait()
+}
+
+// retire stops checking the health of a pool which has been replaced
+func (p *upstreamPool) retire() {
+	p.retired.Store(true)
+	p.stop()
+}
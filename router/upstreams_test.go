@@ -0,0 +1,201 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+func testMetrics(t testing.TB) {
+	_, err := metrics.Init("router_test_" + strings.ReplaceAll(t.Name(), "/", "_"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(metrics.Deinit)
+}
+
+func testUpstreams(t testing.TB, beaconNodes ...*url.URL) *upstreamPool {
+	return newUpstreamPool(beaconNodes, UpstreamTimeouts{}, CircuitBreakerConfig{}, zap.NewNop(), metrics.NewMetricsRegistry("http_proxy"))
+}
+
+// fakeBeaconNode counts the requests it receives and responds with the given status
+type fakeBeaconNode struct {
+	*httptest.Server
+	url      *url.URL
+	requests atomic.Int64
+	status   atomic.Int64
+	health   atomic.Int64
+	// The body of the last request, as a string
+	body atomic.Value
+}
+
+func newFakeBeaconNode(t testing.TB, name string) *fakeBeaconNode {
+	out := &fakeBeaconNode{}
+	out.status.Store(http.StatusOK)
+	out.health.Store(http.StatusOK)
+	out.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == healthPath {
+			w.WriteHeader(int(out.health.Load()))
+			return
+		}
+
+		out.requests.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		out.body.Store(string(body))
+		w.WriteHeader(int(out.status.Load()))
+		fmt.Fprint(w, name)
+	}))
+	t.Cleanup(out.Close)
+
+	var err error
+	out.url, err = url.Parse(out.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func proxyRequest(t *testing.T, p *upstreamPool, method string) *httptest.ResponseRecorder {
+	var body io.Reader
+	if method == http.MethodPost {
+		body = strings.NewReader("{}")
+	}
+	r := httptest.NewRequest(method, "/eth/v1/node/version", body)
+	w := httptest.NewRecorder()
+	newReverseProxy(p).ServeHTTP(w, r)
+	return w
+}
+
+func TestUpstreamRoundRobin(t *testing.T) {
+	testMetrics(t)
+	a := newFakeBeaconNode(t, "a")
+	b := newFakeBeaconNode(t, "b")
+	p := testUpstreams(t, a.url, b.url)
+
+	for i := 0; i < 4; i++ {
+		if w := proxyRequest(t, p, http.MethodGet); w.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d", w.Code)
+		}
+	}
+
+	if a.requests.Load() != 2 || b.requests.Load() != 2 {
+		t.Fatalf("expected requests to be split evenly, got %d and %d", a.requests.Load(), b.requests.Load())
+	}
+	requests := p.m.CounterVec("upstream_requests", "upstream")
+	if got := testutil.ToFloat64(requests.WithLabelValues(a.url.Host)); got != 2 {
+		t.Fatalf("expected 2 requests recorded for %s, got %v", a.url.Host, got)
+	}
+}
+
+func TestUpstreamRetryOn5xx(t *testing.T) {
+	testMetrics(t)
+	a := newFakeBeaconNode(t, "a")
+	b := newFakeBeaconNode(t, "b")
+	a.status.Store(http.StatusServiceUnavailable)
+	p := testUpstreams(t, a.url, b.url)
+
+	w := proxyRequest(t, p, http.MethodGet)
+	if w.Code != http.StatusOK || w.Body.String() != "b" {
+		t.Fatalf("expected b to serve the request, got %d %q", w.Code, w.Body.String())
+	}
+	if a.requests.Load() != 1 {
+		t.Fatalf("expected a to be tried first, got %d requests", a.requests.Load())
+	}
+	if got := testutil.ToFloat64(p.m.Counter("upstream_retries")); got != 1 {
+		t.Fatalf("expected 1 retry, got %v", got)
+	}
+}
+
+func TestUpstreamRetryOnConnectionError(t *testing.T) {
+	testMetrics(t)
+	a := newFakeBeaconNode(t, "a")
+	b := newFakeBeaconNode(t, "b")
+	a.Close()
+	p := testUpstreams(t, a.url, b.url)
+
+	w := proxyRequest(t, p, http.MethodGet)
+	if w.Code != http.StatusOK || w.Body.String() != "b" {
+		t.Fatalf("expected b to serve the request, got %d %q", w.Code, w.Body.String())
+	}
+
+	// The failed upstream is skipped without waiting for a health check
+	if p.upstreams[0].healthy.Load() {
+		t.Fatal("expected the closed upstream to be marked unhealthy")
+	}
+	if got := testutil.ToFloat64(p.m.Gauge("upstreams_healthy")); got != 1 {
+		t.Fatalf("expected 1 healthy upstream, got %v", got)
+	}
+}
+
+func TestUpstreamNoRetryOnPost(t *testing.T) {
+	testMetrics(t)
+	a := newFakeBeaconNode(t, "a")
+	b := newFakeBeaconNode(t, "b")
+	a.status.Store(http.StatusInternalServerError)
+	p := testUpstreams(t, a.url, b.url)
+
+	w := proxyRequest(t, p, http.MethodPost)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected the 500 to be passed through, got %d", w.Code)
+	}
+	if b.requests.Load() != 0 {
+		t.Fatal("expected the POST not to be retried")
+	}
+	if got := testutil.ToFloat64(p.m.Counter("upstream_retries")); got != 0 {
+		t.Fatalf("expected no retries, got %v", got)
+	}
+}
+
+func TestUpstreamHealthCheck(t *testing.T) {
+	testMetrics(t)
+	a := newFakeBeaconNode(t, "a")
+	b := newFakeBeaconNode(t, "b")
+	// 206 means syncing
+	a.health.Store(http.StatusPartialContent)
+	p := testUpstreams(t, a.url, b.url)
+
+	p.checkHealth(context.Background(), http.DefaultClient)
+	if p.upstreams[0].healthy.Load() || !p.upstreams[1].healthy.Load() {
+		t.Fatal("expected only b to be healthy")
+	}
+
+	for i := 0; i < 3; i++ {
+		proxyRequest(t, p, http.MethodGet)
+	}
+	if a.requests.Load() != 0 || b.requests.Load() != 3 {
+		t.Fatalf("expected only b to be used, got %d and %d", a.requests.Load(), b.requests.Load())
+	}
+
+	// Once a is synced it's used again
+	a.health.Store(http.StatusOK)
+	p.checkHealth(context.Background(), http.DefaultClient)
+	if !p.upstreams[0].healthy.Load() {
+		t.Fatal("expected a to be healthy again")
+	}
+	if got := testutil.ToFloat64(p.m.Gauge("upstreams_healthy")); got != 2 {
+		t.Fatalf("expected 2 healthy upstreams, got %v", got)
+	}
+}
+
+func TestUpstreamsAllUnhealthy(t *testing.T) {
+	testMetrics(t)
+	a := newFakeBeaconNode(t, "a")
+	a.health.Store(http.StatusServiceUnavailable)
+	p := testUpstreams(t, a.url)
+
+	// With nothing healthy, requests are still attempted rather than failed outright
+	p.checkHealth(context.Background(), http.DefaultClient)
+	if w := proxyRequest(t, p, http.MethodGet); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", w.Code)
+	}
+}
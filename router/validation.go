@@ -0,0 +1,275 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/auth"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/executionlayer"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	apiv1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+// validationOutcome is the label a validated prepare_beacon_proposer or register_validator entry is counted under
+type validationOutcome string
+
+const (
+	outcomeAccepted                  validationOutcome = "accepted"
+	outcomeAcceptedAllowlisted       validationOutcome = "accepted_allowlisted"
+	outcomeAcceptedMinipoolStatus    validationOutcome = "accepted_minipool_status"
+	outcomeRejectedWrongFeeRecipient validationOutcome = "rejected_wrong_fee_recipient"
+	outcomeRejectedUnknownValidator  validationOutcome = "rejected_unknown_validator"
+	outcomeRejectedNodeMismatch      validationOutcome = "rejected_node_mismatch"
+	outcomeRejectedStaleCache        validationOutcome = "rejected_stale_cache"
+	outcomeRejectedCacheInconsistent validationOutcome = "rejected_cache_inconsistent"
+	outcomeRewrittenFeeRecipient     validationOutcome = "rewritten_fee_recipient"
+	outcomeRejectedWarming           validationOutcome = "rejected_cache_warming"
+	outcomeUnvalidatedWarming        validationOutcome = "unvalidated_cache_warming"
+)
+
+// UnknownValidatorPolicy decides whether validators which aren't known minipools may use any fee recipient
+// in register_validator requests. prepare_beacon_proposer requests aren't signed, so unknown validators are
+// rejected there, unless fee recipients are being rewritten.
+type UnknownValidatorPolicy string
+
+const (
+	// UnknownValidatorAllow accepts any fee recipient for unknown validators
+	UnknownValidatorAllow UnknownValidatorPolicy = "allow"
+	// UnknownValidatorDeny rejects unknown validators
+	UnknownValidatorDeny UnknownValidatorPolicy = "deny"
+	// UnknownValidatorRequireSoloAuth only accepts an unknown validator's withdrawal address as its fee recipient
+	UnknownValidatorRequireSoloAuth UnknownValidatorPolicy = "require-solo-auth"
+)
+
+// ParseUnknownValidatorPolicy converts a flag value to an UnknownValidatorPolicy
+func ParseUnknownValidatorPolicy(s string) (UnknownValidatorPolicy, error) {
+	switch policy := UnknownValidatorPolicy(s); policy {
+	case UnknownValidatorAllow, UnknownValidatorDeny, UnknownValidatorRequireSoloAuth:
+		return policy, nil
+	}
+
+	return "", fmt.Errorf("unknown policy %q, expected allow, deny or require-solo-auth", s)
+}
+
+// allowUnknownValidator applies policy to a validator which isn't a known minipool, and counts the decision.
+// isSoloFeeRecipient is only called for UnknownValidatorRequireSoloAuth.
+func allowUnknownValidator(m *metrics.MetricsRegistry, policy UnknownValidatorPolicy, isSoloFeeRecipient func() (bool, error)) (bool, error) {
+	allowed := policy != UnknownValidatorDeny
+	if policy == UnknownValidatorRequireSoloAuth {
+		var err error
+		allowed, err = isSoloFeeRecipient()
+		if err != nil {
+			m.CounterVec("unknown_validator_policy", "policy", "decision").WithLabelValues(string(policy), "error").Inc()
+			return false, err
+		}
+	}
+
+	decision := "allowed"
+	if !allowed {
+		decision = "denied"
+	}
+	m.CounterVec("unknown_validator_policy", "policy", "decision").WithLabelValues(string(policy), decision).Inc()
+	return allowed, nil
+}
+
+// lookupFailed is whether err means a fee recipient source couldn't say what a validator's fee recipient
+// should be, rather than that it doesn't know the validator, or that the validator is someone else's
+func lookupFailed(err error) bool {
+	return err != nil && !errors.Is(err, feerecipient.ErrNotMinipool) && !errors.Is(err, feerecipient.ErrWrongNode)
+}
+
+// subjectNodeFilter returns the node ValidatorFeeRecipient should require a credential's validators to belong to,
+// or nil if the credential wasn't issued to a node, so isn't tied to one
+func subjectNodeFilter(credential *auth.Credential) *common.Address {
+	if !credential.IsNode() {
+		return nil
+	}
+	nodeAddr := credential.NodeAddress
+	return &nodeAddr
+}
+
+// subjectAllowsValidator reports whether a credential may be used for pubkey. Credentials issued to a validator
+// may only be used for that validator; other subjects are checked against the fee recipient sources.
+func subjectAllowsValidator(credential *auth.Credential, pubkey rptypes.ValidatorPubkey) bool {
+	if credential.SubjectType != auth.SubjectValidatorPubkey {
+		return true
+	}
+	return strings.EqualFold(strings.TrimPrefix(credential.Subject, "0x"), pubkey.Hex())
+}
+
+// feeRecipientOutcome decides whether a validator may use a fee recipient, given the results of
+// ValidatorFeeRecipient() and a function which compares the fee recipient to the expected one.
+// Failed lookups are rejected; callers should check lookupFailed() first, to report them.
+//
+// register_validator requests are signed, so validators which aren't minipools may use any fee
+// recipient there. allowNonMinipools should only be set for them.
+func feeRecipientOutcome(info *feerecipient.Info, err error, allowNonMinipools bool, matches func(common.Address) bool) validationOutcome {
+	switch {
+	case errors.Is(err, feerecipient.ErrWrongNode):
+		return outcomeRejectedNodeMismatch
+	case errors.Is(err, feerecipient.ErrNotMinipool):
+		if allowNonMinipools {
+			return outcomeAccepted
+		}
+		return outcomeRejectedUnknownValidator
+	case err != nil:
+		return outcomeRejectedCacheInconsistent
+	}
+
+	if !matches(info.Expected) {
+		return outcomeRejectedWrongFeeRecipient
+	}
+
+	return outcomeAccepted
+}
+
+// allowlistedOutcome gives a validator whose fee recipient isn't the one expected of it a second chance, if it's on
+// the allowlist of fee recipients any minipool may use. Returns the entry it matched, and counts it, if it did.
+func allowlistedOutcome(m *metrics.MetricsRegistry, outcome validationOutcome, allowlist []executionlayer.AllowedFeeRecipient, matches func(common.Address) bool) (validationOutcome, *executionlayer.AllowedFeeRecipient) {
+	if outcome != outcomeRejectedWrongFeeRecipient {
+		return outcome, nil
+	}
+
+	for i := range allowlist {
+		if matches(allowlist[i].Address) {
+			m.CounterVec("allowlisted_fee_recipient", "reason").WithLabelValues(allowlist[i].Name).Inc()
+			return outcomeAcceptedAllowlisted, &allowlist[i]
+		}
+	}
+
+	return outcome, nil
+}
+
+// ParseMinipoolStatuses parses a comma-separated list of minipool statuses, eg, dissolved,withdrawable
+func ParseMinipoolStatuses(s string) (map[feerecipient.MinipoolStatus]bool, error) {
+	out := make(map[feerecipient.MinipoolStatus]bool)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		status, err := feerecipient.ParseMinipoolStatus(name)
+		if err != nil {
+			return nil, err
+		}
+		out[status] = true
+	}
+	return out, nil
+}
+
+// unenforcedStatusOutcome accepts a minipool validator whose fee recipient isn't the one expected of it, if its
+// minipool's status is one whose fee recipient isn't enforced, eg, dissolved minipools, which will never propose.
+// Counts it, if it does.
+func unenforcedStatusOutcome(m *metrics.MetricsRegistry, outcome validationOutcome, info *feerecipient.Info, unenforced map[feerecipient.MinipoolStatus]bool) validationOutcome {
+	if outcome != outcomeRejectedWrongFeeRecipient || info == nil || !unenforced[info.Status] {
+		return outcome
+	}
+
+	m.CounterVec("minipool_status_unenforced", "status").WithLabelValues(info.Status.String()).Inc()
+	return outcomeAcceptedMinipoolStatus
+}
+
+// soloFeeRecipientOutcome decides whether a validator authenticated with a solo credential may use a fee recipient,
+// given its withdrawal address, which it must use. Validators with BLS withdrawal credentials have no withdrawal
+// address, so they're rejected as unknown.
+func soloFeeRecipientOutcome(withdrawalAddress *common.Address, matches func(common.Address) bool) validationOutcome {
+	if withdrawalAddress == nil {
+		return outcomeRejectedUnknownValidator
+	}
+
+	if !matches(*withdrawalAddress) {
+		return outcomeRejectedWrongFeeRecipient
+	}
+
+	return outcomeAccepted
+}
+
+// allowedFeeRecipientOutcome decides whether a validator may use a fee recipient, when its credential
+// only allows some fee recipients, wherever the validator's from
+func allowedFeeRecipientOutcome(allowed []common.Address, matches func(common.Address) bool) validationOutcome {
+	for _, feeRecipient := range allowed {
+		if matches(feeRecipient) {
+			return outcomeAccepted
+		}
+	}
+
+	return outcomeRejectedWrongFeeRecipient
+}
+
+func countValidationOutcome(m *metrics.MetricsRegistry, outcome validationOutcome) {
+	m.CounterVec("validation_outcome", "outcome").WithLabelValues(string(outcome)).Inc()
+}
+
+// countSoloValidationOutcome counts outcomes for validators authenticated with solo credentials
+// separately as well, since they're checked against their withdrawal address instead of the EL cache
+func countSoloValidationOutcome(m *metrics.MetricsRegistry, outcome validationOutcome) {
+	countValidationOutcome(m, outcome)
+	m.CounterVec("solo_validation_outcome", "outcome").WithLabelValues(string(outcome)).Inc()
+}
+
+// Validators in these states have exited, or are being ejected for being slashed,
+// so have no business registering with relays
+var barredValidatorStates = map[apiv1.ValidatorState]bool{
+	apiv1.ValidatorStateActiveSlashed:      true,
+	apiv1.ValidatorStateExitedUnslashed:    true,
+	apiv1.ValidatorStateExitedSlashed:      true,
+	apiv1.ValidatorStateWithdrawalPossible: true,
+	apiv1.ValidatorStateWithdrawalDone:     true,
+}
+
+// barredValidators returns the pubkeys whose status bars them from register_validator, in order and without duplicates.
+// Validators with no status aren't on the beacon chain yet, so aren't barred.
+func barredValidators(pubkeys []rptypes.ValidatorPubkey, statuses map[rptypes.ValidatorPubkey]apiv1.ValidatorState) []rptypes.ValidatorPubkey {
+	var out []rptypes.ValidatorPubkey
+	seen := make(map[rptypes.ValidatorPubkey]bool)
+	for _, pubkey := range pubkeys {
+		status, ok := statuses[pubkey]
+		if !ok || !barredValidatorStates[status] || seen[pubkey] {
+			continue
+		}
+		seen[pubkey] = true
+		out = append(out, pubkey)
+	}
+
+	return out
+}
+
+// barredValidatorsMessage names barred validators and their statuses, counting each rejection by status
+func barredValidatorsMessage(m *metrics.MetricsRegistry, barred []rptypes.ValidatorPubkey, statuses map[rptypes.ValidatorPubkey]apiv1.ValidatorState) string {
+	names := make([]string, 0, len(barred))
+	for _, pubkey := range barred {
+		status := statuses[pubkey].String()
+		m.CounterVec("register_validator_status_rejected", "status").WithLabelValues(status).Inc()
+		names = append(names, fmt.Sprintf("%s (%s)", pubkey.String(), status))
+	}
+
+	return "exited or slashed validators can't register: " + strings.Join(names, ", ")
+}
+
+// barredValidatorFailures lists the entries of a register_validator request whose validators are barred,
+// in the order they were registered
+func barredValidatorFailures(pubkeys []rptypes.ValidatorPubkey, feeRecipients []string, barred []rptypes.ValidatorPubkey, statuses map[rptypes.ValidatorPubkey]apiv1.ValidatorState) []entryFailure {
+	isBarred := make(map[rptypes.ValidatorPubkey]bool, len(barred))
+	for _, pubkey := range barred {
+		isBarred[pubkey] = true
+	}
+
+	var out []entryFailure
+	for i, pubkey := range pubkeys {
+		if !isBarred[pubkey] {
+			continue
+		}
+		out = append(out, entryFailure{
+			Index:        i,
+			Message:      fmt.Sprintf("validator %s is %s, so can't register", pubkey.String(), statuses[pubkey].String()),
+			Pubkey:       "0x" + pubkey.Hex(),
+			FeeRecipient: feeRecipients[i],
+		})
+	}
+	return out
+}
@@ -0,0 +1,408 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/executionlayer"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/feerecipients/feerecipient"
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/metrics"
+	apiv1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+func TestFeeRecipientOutcome(t *testing.T) {
+	feeRecipient := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	matches := func(expected common.Address) bool {
+		return expected == feeRecipient
+	}
+	correct := &feerecipient.Info{Expected: feeRecipient, Source: feerecipient.SourceSmoothingPool}
+	wrong := &feerecipient.Info{Expected: common.HexToAddress("0x2222222222222222222222222222222222222222"), Source: feerecipient.SourceFeeDistributor}
+	inconsistent := fmt.Errorf("%w: minipool isn't in the node index", feerecipient.ErrInconsistentCache)
+
+	for _, tc := range []struct {
+		name              string
+		expected          *feerecipient.Info
+		err               error
+		allowNonMinipools bool
+		outcome           validationOutcome
+	}{
+		{"correct fee recipient", correct, nil, false, outcomeAccepted},
+		{"wrong fee recipient", wrong, nil, false, outcomeRejectedWrongFeeRecipient},
+		{"wrong fee recipient, signed", wrong, nil, true, outcomeRejectedWrongFeeRecipient},
+		{"someone else's minipool", nil, feerecipient.ErrWrongNode, false, outcomeRejectedNodeMismatch},
+		{"someone else's minipool, signed", nil, feerecipient.ErrWrongNode, true, outcomeRejectedNodeMismatch},
+		{"not a minipool", nil, feerecipient.ErrNotMinipool, false, outcomeRejectedUnknownValidator},
+		{"not a minipool, signed", nil, feerecipient.ErrNotMinipool, true, outcomeAccepted},
+		{"inconsistent cache", nil, inconsistent, false, outcomeRejectedCacheInconsistent},
+		{"inconsistent cache, signed", nil, inconsistent, true, outcomeRejectedCacheInconsistent},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if outcome := feeRecipientOutcome(tc.expected, tc.err, tc.allowNonMinipools, matches); outcome != tc.outcome {
+				t.Fatalf("expected %s, got %s", tc.outcome, outcome)
+			}
+		})
+	}
+}
+
+func TestAllowlistedOutcome(t *testing.T) {
+	_, err := metrics.Init("validation_test_" + t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer metrics.Deinit()
+
+	rETH := executionlayer.AllowedFeeRecipient{Name: "rocketTokenRETH", Address: common.HexToAddress("0xaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeaeae")}
+	extra := common.HexToAddress("0xbebebebebebebebebebebebebebebebebebebebe")
+	allowlist := []executionlayer.AllowedFeeRecipient{rETH, {Name: extra.String(), Address: extra}}
+	m := metrics.NewMetricsRegistry("http_proxy")
+
+	for _, tc := range []struct {
+		name         string
+		outcome      validationOutcome
+		feeRecipient common.Address
+		result       validationOutcome
+		reason       string
+	}{
+		{"rETH", outcomeRejectedWrongFeeRecipient, rETH.Address, outcomeAcceptedAllowlisted, "rocketTokenRETH"},
+		{"extra address", outcomeRejectedWrongFeeRecipient, extra, outcomeAcceptedAllowlisted, extra.String()},
+		{"not allowlisted", outcomeRejectedWrongFeeRecipient, common.HexToAddress("0x2222222222222222222222222222222222222222"), outcomeRejectedWrongFeeRecipient, ""},
+		{"already accepted", outcomeAccepted, rETH.Address, outcomeAccepted, ""},
+		// Only the fee recipient is allowlisted, not the validator
+		{"someone else's minipool", outcomeRejectedNodeMismatch, rETH.Address, outcomeRejectedNodeMismatch, ""},
+		{"not a minipool", outcomeRejectedUnknownValidator, rETH.Address, outcomeRejectedUnknownValidator, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			outcome, allowed := allowlistedOutcome(m, tc.outcome, allowlist, func(candidate common.Address) bool {
+				return candidate == tc.feeRecipient
+			})
+			if outcome != tc.result {
+				t.Fatalf("expected %s, got %s", tc.result, outcome)
+			}
+
+			if tc.reason == "" {
+				if allowed != nil {
+					t.Fatalf("expected no allowlist entry, got %+v", allowed)
+				}
+				return
+			}
+			if allowed == nil || allowed.Name != tc.reason {
+				t.Fatalf("expected the %s entry, got %+v", tc.reason, allowed)
+			}
+			if got := testutil.ToFloat64(m.CounterVec("allowlisted_fee_recipient", "reason").WithLabelValues(tc.reason)); got != 1 {
+				t.Fatalf("expected the match to be counted, got %v", got)
+			}
+		})
+	}
+}
+
+func TestUnenforcedStatusOutcome(t *testing.T) {
+	_, err := metrics.Init("validation_test_" + t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer metrics.Deinit()
+
+	m := metrics.NewMetricsRegistry("http_proxy")
+	unenforced := map[feerecipient.MinipoolStatus]bool{feerecipient.MinipoolStatusDissolved: true}
+	dissolved := &feerecipient.Info{Source: feerecipient.SourceFeeDistributor, Status: feerecipient.MinipoolStatusDissolved}
+	staking := &feerecipient.Info{Source: feerecipient.SourceFeeDistributor, Status: feerecipient.MinipoolStatusStaking}
+
+	for _, tc := range []struct {
+		name    string
+		outcome validationOutcome
+		info    *feerecipient.Info
+		result  validationOutcome
+	}{
+		{"dissolved", outcomeRejectedWrongFeeRecipient, dissolved, outcomeAcceptedMinipoolStatus},
+		{"staking", outcomeRejectedWrongFeeRecipient, staking, outcomeRejectedWrongFeeRecipient},
+		{"already accepted", outcomeAccepted, dissolved, outcomeAccepted},
+		// Only the fee recipient goes unenforced, not who owns the validator
+		{"someone else's minipool", outcomeRejectedNodeMismatch, nil, outcomeRejectedNodeMismatch},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if outcome := unenforcedStatusOutcome(m, tc.outcome, tc.info, unenforced); outcome != tc.result {
+				t.Fatalf("expected %s, got %s", tc.result, outcome)
+			}
+		})
+	}
+	if got := testutil.ToFloat64(m.CounterVec("minipool_status_unenforced", "status").WithLabelValues("dissolved")); got != 1 {
+		t.Fatalf("expected 1 unenforced status, got %v", got)
+	}
+}
+
+func TestParseMinipoolStatuses(t *testing.T) {
+	statuses, err := ParseMinipoolStatuses("dissolved, withdrawable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[feerecipient.MinipoolStatus]bool{feerecipient.MinipoolStatusDissolved: true, feerecipient.MinipoolStatusWithdrawable: true}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Fatalf("expected %v, got %v", expected, statuses)
+	}
+
+	if statuses, err := ParseMinipoolStatuses(""); err != nil || len(statuses) != 0 {
+		t.Fatalf("expected no statuses, got %v, %v", statuses, err)
+	}
+	if _, err := ParseMinipoolStatuses("dissolved,exited"); err == nil {
+		t.Fatal("expected an unknown status to be rejected")
+	}
+}
+
+func TestLookupFailed(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		failed bool
+	}{
+		{nil, false},
+		{feerecipient.ErrNotMinipool, false},
+		{feerecipient.ErrWrongNode, false},
+		{fmt.Errorf("wrapped: %w", feerecipient.ErrWrongNode), false},
+		{feerecipient.ErrInconsistentCache, true},
+		{fmt.Errorf("fee recipient endpoint responded with 500"), true},
+	} {
+		if failed := lookupFailed(tc.err); failed != tc.failed {
+			t.Errorf("%v: expected %v, got %v", tc.err, tc.failed, failed)
+		}
+	}
+}
+
+func TestSoloFeeRecipientOutcome(t *testing.T) {
+	withdrawalAddress := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	other := common.HexToAddress("0x2222222222222222222222222222222222222222")
+
+	for _, tc := range []struct {
+		name              string
+		withdrawalAddress *common.Address
+		feeRecipient      common.Address
+		outcome           validationOutcome
+	}{
+		{"withdrawal address", &withdrawalAddress, withdrawalAddress, outcomeAccepted},
+		{"other fee recipient", &withdrawalAddress, other, outcomeRejectedWrongFeeRecipient},
+		{"BLS withdrawal credentials", nil, withdrawalAddress, outcomeRejectedUnknownValidator},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			outcome := soloFeeRecipientOutcome(tc.withdrawalAddress, func(expected common.Address) bool {
+				return expected == tc.feeRecipient
+			})
+			if outcome != tc.outcome {
+				t.Fatalf("expected %s, got %s", tc.outcome, outcome)
+			}
+		})
+	}
+}
+
+func TestAllowedFeeRecipientOutcome(t *testing.T) {
+	allowed := []common.Address{
+		common.HexToAddress("0x1111111111111111111111111111111111111111"),
+		common.HexToAddress("0x2222222222222222222222222222222222222222"),
+	}
+
+	for _, tc := range []struct {
+		name         string
+		feeRecipient common.Address
+		outcome      validationOutcome
+	}{
+		{"first allowed", allowed[0], outcomeAccepted},
+		{"second allowed", allowed[1], outcomeAccepted},
+		{"not allowed", common.HexToAddress("0x3333333333333333333333333333333333333333"), outcomeRejectedWrongFeeRecipient},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			outcome := allowedFeeRecipientOutcome(allowed, func(allowed common.Address) bool {
+				return allowed == tc.feeRecipient
+			})
+			if outcome != tc.outcome {
+				t.Fatalf("expected %s, got %s", tc.outcome, outcome)
+			}
+		})
+	}
+}
+
+func TestCountValidationOutcome(t *testing.T) {
+	_, err := metrics.Init("validation_test_" + t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer metrics.Deinit()
+
+	m := metrics.NewMetricsRegistry("http_proxy")
+	countValidationOutcome(m, outcomeAccepted)
+	countValidationOutcome(m, outcomeAccepted)
+	countValidationOutcome(m, outcomeRejectedStaleCache)
+
+	outcomes := m.CounterVec("validation_outcome", "outcome")
+	for outcome, expected := range map[validationOutcome]float64{
+		outcomeAccepted:                  2,
+		outcomeRejectedStaleCache:        1,
+		outcomeRejectedWrongFeeRecipient: 0,
+	} {
+		if got := testutil.ToFloat64(outcomes.WithLabelValues(string(outcome))); got != expected {
+			t.Errorf("expected %s to be %v, got %v", outcome, expected, got)
+		}
+	}
+
+	// Solo outcomes are counted with the rest, and on their own
+	countSoloValidationOutcome(m, outcomeRejectedWrongFeeRecipient)
+	if got := testutil.ToFloat64(outcomes.WithLabelValues(string(outcomeRejectedWrongFeeRecipient))); got != 1 {
+		t.Errorf("expected %s to be 1, got %v", outcomeRejectedWrongFeeRecipient, got)
+	}
+	if got := testutil.ToFloat64(m.CounterVec("solo_validation_outcome", "outcome").WithLabelValues(string(outcomeRejectedWrongFeeRecipient))); got != 1 {
+		t.Errorf("expected 1 solo %s, got %v", outcomeRejectedWrongFeeRecipient, got)
+	}
+}
+
+func TestBarredValidators(t *testing.T) {
+	_, err := metrics.Init("validation_test_" + t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer metrics.Deinit()
+
+	pubkey := func(b byte) rptypes.ValidatorPubkey {
+		var out rptypes.ValidatorPubkey
+		out[0] = b
+		return out
+	}
+	active, exiting, slashed, exited, withdrawn, pending := pubkey(1), pubkey(2), pubkey(3), pubkey(4), pubkey(5), pubkey(6)
+	statuses := map[rptypes.ValidatorPubkey]apiv1.ValidatorState{
+		active:    apiv1.ValidatorStateActiveOngoing,
+		exiting:   apiv1.ValidatorStateActiveExiting,
+		slashed:   apiv1.ValidatorStateActiveSlashed,
+		exited:    apiv1.ValidatorStateExitedUnslashed,
+		withdrawn: apiv1.ValidatorStateWithdrawalDone,
+		pending:   apiv1.ValidatorStatePendingQueued,
+	}
+
+	// Validators the beacon node doesn't know of, like unseen, aren't barred
+	unseen := pubkey(7)
+	if barred := barredValidators([]rptypes.ValidatorPubkey{active, exiting, pending, unseen}, statuses); len(barred) != 0 {
+		t.Fatalf("expected no validators to be barred, got %v", barred)
+	}
+
+	barred := barredValidators([]rptypes.ValidatorPubkey{withdrawn, active, slashed, withdrawn, exited}, statuses)
+	if !reflect.DeepEqual(barred, []rptypes.ValidatorPubkey{withdrawn, slashed, exited}) {
+		t.Fatalf("unexpected barred validators %v", barred)
+	}
+
+	m := metrics.NewMetricsRegistry("http_proxy")
+	message := barredValidatorsMessage(m, barred, statuses)
+	for _, pubkey := range barred {
+		expected := pubkey.String() + " (" + statuses[pubkey].String() + ")"
+		if !strings.Contains(message, expected) {
+			t.Fatalf("expected %q to name %s", message, expected)
+		}
+	}
+	rejected := m.CounterVec("register_validator_status_rejected", "status")
+	for status, expected := range map[string]float64{"withdrawal_done": 1, "active_slashed": 1, "exited_unslashed": 1, "active_ongoing": 0} {
+		if got := testutil.ToFloat64(rejected.WithLabelValues(status)); got != expected {
+			t.Errorf("expected %s to be %v, got %v", status, expected, got)
+		}
+	}
+
+	// Every entry registering a barred validator is listed, in order
+	pubkeys := []rptypes.ValidatorPubkey{withdrawn, active, slashed, withdrawn, exited}
+	feeRecipients := []string{"0x01", "0x02", "0x03", "0x04", "0x05"}
+	failures := barredValidatorFailures(pubkeys, feeRecipients, barred, statuses)
+	indices := make([]int, 0, len(failures))
+	for _, failure := range failures {
+		indices = append(indices, failure.Index)
+		if failure.Pubkey != "0x"+pubkeys[failure.Index].Hex() || failure.FeeRecipient != feeRecipients[failure.Index] {
+			t.Errorf("unexpected failure %+v", failure)
+		}
+	}
+	if !reflect.DeepEqual(indices, []int{0, 2, 3, 4}) {
+		t.Fatalf("expected entries 0, 2, 3 and 4 to fail, got %v", indices)
+	}
+}
+
+func TestAllowUnknownValidator(t *testing.T) {
+	_, err := metrics.Init("validation_test_" + t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer metrics.Deinit()
+
+	m := metrics.NewMetricsRegistry("http_proxy")
+	solo := func() (bool, error) { return true, nil }
+	notSolo := func() (bool, error) { return false, nil }
+	for _, tc := range []struct {
+		policy             UnknownValidatorPolicy
+		isSoloFeeRecipient func() (bool, error)
+		allowed            bool
+	}{
+		{UnknownValidatorAllow, notSolo, true},
+		{UnknownValidatorDeny, solo, false},
+		{UnknownValidatorRequireSoloAuth, solo, true},
+		{UnknownValidatorRequireSoloAuth, notSolo, false},
+	} {
+		allowed, err := allowUnknownValidator(m, tc.policy, tc.isSoloFeeRecipient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed != tc.allowed {
+			t.Errorf("expected %s to return %v, got %v", tc.policy, tc.allowed, allowed)
+		}
+	}
+
+	if _, err := allowUnknownValidator(m, UnknownValidatorRequireSoloAuth, func() (bool, error) {
+		return false, fmt.Errorf("beacon node unavailable")
+	}); err == nil {
+		t.Fatal("expected the withdrawal address lookup error to be returned")
+	}
+
+	decisions := m.CounterVec("unknown_validator_policy", "policy", "decision")
+	for _, tc := range []struct {
+		policy   UnknownValidatorPolicy
+		decision string
+		expected float64
+	}{
+		{UnknownValidatorAllow, "allowed", 1},
+		{UnknownValidatorDeny, "denied", 1},
+		{UnknownValidatorRequireSoloAuth, "allowed", 1},
+		{UnknownValidatorRequireSoloAuth, "denied", 1},
+		{UnknownValidatorRequireSoloAuth, "error", 1},
+	} {
+		if got := testutil.ToFloat64(decisions.WithLabelValues(string(tc.policy), tc.decision)); got != tc.expected {
+			t.Errorf("expected %s/%s to be %v, got %v", tc.policy, tc.decision, tc.expected, got)
+		}
+	}
+}
+
+func TestParseUnknownValidatorPolicy(t *testing.T) {
+	for _, s := range []string{"allow", "deny", "require-solo-auth"} {
+		if policy, err := ParseUnknownValidatorPolicy(s); err != nil || string(policy) != s {
+			t.Errorf("expected %s to parse, got %v, %v", s, policy, err)
+		}
+	}
+
+	if _, err := ParseUnknownValidatorPolicy("permissive"); err == nil {
+		t.Error("expected an unknown policy to be rejected")
+	}
+}
+
+func TestWriteJSONError(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeJSONError(w, httptest.NewRequest(http.MethodPost, registerValidatorPath, nil), http.StatusForbidden, "validator 0x01 is not a known minipool")
+
+	if w.Code != http.StatusForbidden || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response %d %v", w.Code, w.Header())
+	}
+
+	var body struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Code != http.StatusForbidden || body.Message != "validator 0x01 is not a known minipool" {
+		t.Fatalf("unexpected body %+v", body)
+	}
+}
@@ -0,0 +1,89 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// WarmupPolicy decides what happens to guarded requests while the EL cache is warming up, and fee recipients can't be checked yet
+type WarmupPolicy string
+
+const (
+	// WarmupFailClosed rejects guarded requests with 503 until the cache is warm
+	WarmupFailClosed WarmupPolicy = "fail-closed"
+	// WarmupPassThrough proxies guarded requests without checking their fee recipients until the cache is warm
+	WarmupPassThrough WarmupPolicy = "pass-through"
+)
+
+// How long clients rejected during warm-up are told to wait before retrying
+const warmupRetryAfterSeconds = 30
+
+// ParseWarmupPolicy converts a flag value to a WarmupPolicy
+func ParseWarmupPolicy(s string) (WarmupPolicy, error) {
+	switch policy := WarmupPolicy(s); policy {
+	case WarmupFailClosed, WarmupPassThrough:
+		return policy, nil
+	}
+
+	return "", fmt.Errorf("unknown policy %q, expected fail-closed or pass-through", s)
+}
+
+// handleIfWarming applies the WarmupPolicy to a guarded request and returns true if the EL cache is still warming up
+func (pr *ProxyRouter) handleIfWarming(w http.ResponseWriter, r *http.Request) bool {
+	if !pr.EL.Warming() {
+		return false
+	}
+
+	if pr.WarmupPolicy == WarmupPassThrough {
+		pr.m.Counter("warming_passed_through").Inc()
+		countValidationOutcome(pr.m, outcomeUnvalidatedWarming)
+		pr.logger(r).Warn("Proxying guarded request without checking it, EL cache is warming up")
+		guardedDecisionFrom(r.Context()).accept()
+		pr.proxy.ServeHTTP(w, r)
+		return true
+	}
+
+	pr.m.Counter("warming_rejected").Inc()
+	countValidationOutcome(pr.m, outcomeRejectedWarming)
+	pr.logger(r).Warn("Rejecting guarded request, EL cache is warming up")
+	w.Header().Set("Retry-After", strconv.Itoa(warmupRetryAfterSeconds))
+	writeJSONError(w, r, http.StatusServiceUnavailable, "the fee recipient cache is warming up, try again later")
+	return true
+}
+
+// checkWarming applies the WarmupPolicy to a guarded request. If the EL cache is still warming up, it returns true,
+// and an error if the request should be rejected rather than proxied unchecked.
+func (g *GRPCRouter) checkWarming() (bool, error) {
+	if !g.EL.Warming() {
+		return false, nil
+	}
+
+	if g.WarmupPolicy == WarmupPassThrough {
+		g.m.Counter("warming_passed_through").Inc()
+		countValidationOutcome(g.m, outcomeUnvalidatedWarming)
+		g.Logger.Warn("Proxying guarded request without checking it, EL cache is warming up")
+		return true, nil
+	}
+
+	g.m.Counter("warming_rejected").Inc()
+	countValidationOutcome(g.m, outcomeRejectedWarming)
+	g.Logger.Warn("Rejecting guarded request, EL cache is warming up")
+	return true, status.Error(codes.Unavailable, "rescue node is warming up, try again later")
+}
+
+// healthzWarming replies 503 to readiness checks while the EL cache is warming up
+func (pr *ProxyRouter) healthzWarming(w http.ResponseWriter, r *http.Request) {
+	pr.m.Counter("healthz_warming").Inc()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(warmupRetryAfterSeconds))
+	w.WriteHeader(http.StatusServiceUnavailable)
+
+	if _, err := w.Write([]byte("{\"warming\":true}\n")); err != nil {
+		pr.logger(r).Warn("Error writing healthcheck response", zap.Error(err))
+	}
+}
@@ -0,0 +1,97 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Rocket-Pool-Rescue-Node/rescue-proxy/auth"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseWarmupPolicy(t *testing.T) {
+	for s, expected := range map[string]WarmupPolicy{
+		"fail-closed":  WarmupFailClosed,
+		"pass-through": WarmupPassThrough,
+	} {
+		policy, err := ParseWarmupPolicy(s)
+		if err != nil || policy != expected {
+			t.Fatalf("expected %q to parse as %q, got %q %v", s, expected, policy, err)
+		}
+	}
+
+	if _, err := ParseWarmupPolicy("open"); err == nil {
+		t.Fatal("expected an unknown policy to be refused")
+	}
+}
+
+func TestGuardedWarming(t *testing.T) {
+	testMetrics(t)
+	bn := newFakeBeaconNode(t, "bn")
+	pr, el := guardedRouter(t, &ProxyRouter{SkipStatusCheck: true}, bn)
+	el.SetWarming(true)
+
+	body := registration(testValidatorPubkey(0x01), testFeeDistributor)
+	for path, handler := range map[string]http.HandlerFunc{
+		prepareBeaconProposerPath: pr.prepareBeaconProposer(),
+		registerValidatorPath:     pr.registerValidator(),
+	} {
+		w := httptest.NewRecorder()
+		handler(w, guardedRequest(path, body, auth.OperatorRocketPool))
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+			t.Fatalf("expected %s to be refused with a Retry-After while the cache is warming up, got %d %v", path, w.Code, w.Header())
+		}
+	}
+	if bn.requests.Load() != 0 {
+		t.Fatalf("expected nothing to be proxied, got %d upstream requests", bn.requests.Load())
+	}
+	if got := testutil.ToFloat64(pr.m.CounterVec("validation_outcome", "outcome").WithLabelValues(string(outcomeRejectedWarming))); got != 2 {
+		t.Fatalf("expected 2 warming rejections, got %v", got)
+	}
+
+	healthz := httptest.NewRecorder()
+	pr.healthz()(healthz, httptest.NewRequest(http.MethodGet, "/_/healthz", nil))
+	if healthz.Code != http.StatusServiceUnavailable || !strings.Contains(healthz.Body.String(), `"warming":true`) {
+		t.Fatalf("expected warming to report not ready, got %d %s", healthz.Code, healthz.Body.String())
+	}
+
+	// Once it's warm, requests are checked as usual
+	el.SetWarming(false)
+	w := httptest.NewRecorder()
+	pr.registerValidator()(w, guardedRequest(registerValidatorPath, body, auth.OperatorRocketPool))
+	if w.Code != http.StatusOK || bn.requests.Load() != 1 {
+		t.Fatalf("expected the registration to be proxied, got %d", w.Code)
+	}
+
+	healthz = httptest.NewRecorder()
+	pr.healthz()(healthz, httptest.NewRequest(http.MethodGet, "/_/healthz", nil))
+	if healthz.Code != http.StatusOK {
+		t.Fatalf("expected a warm cache to report ready, got %d %s", healthz.Code, healthz.Body.String())
+	}
+}
+
+func TestGuardedWarmingPassThrough(t *testing.T) {
+	testMetrics(t)
+	bn := newFakeBeaconNode(t, "bn")
+	pr, el := guardedRouter(t, &ProxyRouter{SkipStatusCheck: true, WarmupPolicy: WarmupPassThrough}, bn)
+	el.SetWarming(true)
+
+	// Fee recipients can't be checked yet, so even a wrong one is proxied
+	body := registration(testValidatorPubkey(0x01), testWrongRecipient)
+	w := httptest.NewRecorder()
+	pr.registerValidator()(w, guardedRequest(registerValidatorPath, body, auth.OperatorRocketPool))
+	if w.Code != http.StatusOK || bn.requests.Load() != 1 {
+		t.Fatalf("expected the registration to be proxied unchecked, got %d", w.Code)
+	}
+	if got := testutil.ToFloat64(pr.m.Counter("warming_passed_through")); got != 1 {
+		t.Fatalf("expected 1 unchecked request, got %v", got)
+	}
+
+	el.SetWarming(false)
+	w = httptest.NewRecorder()
+	pr.registerValidator()(w, guardedRequest(registerValidatorPath, body, auth.OperatorRocketPool))
+	if w.Code != http.StatusConflict || bn.requests.Load() != 1 {
+		t.Fatalf("expected the wrong fee recipient to be refused once the cache is warm, got %d", w.Code)
+	}
+}
@@ -0,0 +1,250 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"net"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// The first of the development accounts anvil funds at genesis
+const anvilKey = "ac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80"
+
+// shimRuntime is the code of every shim contract. Rather than implementing any of Rocket Pool, a shim answers
+// each call with whatever response was stored for its exact calldata, and reverts if there isn't one.
+// Responses are kept in the code of data contracts, behind a 0x00 byte so they can't start with 0xef,
+// and the shim's storage maps keccak256(calldata) to the data contract holding the response.
+// Three selectors nothing in Rocket Pool uses are reserved:
+//
+//	0xffffffff key value: stores value (a data contract's address) under key
+//	0xfffffffd topic0 topic1 data...: emits LOG2, like the rocketNodeManager events
+//	0xfffffffe topic0 topic1 topic2 data...: emits LOG3, like the rocketMinipoolManager events
+var shimRuntime = []byte{
+	0x60, 0x00, 0x35, 0x60, 0xe0, 0x1c, // PUSH1 0x00 CALLDATALOAD PUSH1 0xe0 SHR: the selector
+	0x80, 0x63, 0xff, 0xff, 0xff, 0xff, 0x14, 0x61, 0x00, 0x4c, 0x57, // DUP1 PUSH4 0xffffffff EQ PUSH2 store JUMPI
+	0x80, 0x63, 0xff, 0xff, 0xff, 0xfd, 0x14, 0x61, 0x00, 0x55, 0x57, // DUP1 PUSH4 0xfffffffd EQ PUSH2 log2 JUMPI
+	0x80, 0x63, 0xff, 0xff, 0xff, 0xfe, 0x14, 0x61, 0x00, 0x6a, 0x57, // DUP1 PUSH4 0xfffffffe EQ PUSH2 log3 JUMPI
+	0x50, // POP
+
+	// Look up the data contract stored for keccak256(calldata), reverting if there isn't one
+	0x36, 0x60, 0x00, 0x60, 0x00, 0x37, // CALLDATASIZE PUSH1 0x00 PUSH1 0x00 CALLDATACOPY
+	0x36, 0x60, 0x00, 0x20, 0x54, // CALLDATASIZE PUSH1 0x00 KECCAK256 SLOAD
+	0x80, 0x61, 0x00, 0x3c, 0x57, // DUP1 PUSH2 respond JUMPI
+	0x60, 0x00, 0x80, 0xfd, // PUSH1 0x00 DUP1 REVERT
+
+	// respond (0x3c): return the data contract's code, less its leading 0x00
+	0x5b,                         // JUMPDEST
+	0x60, 0x01, 0x81, 0x3b, 0x03, // PUSH1 0x01 DUP2 EXTCODESIZE SUB
+	0x80, 0x60, 0x01, 0x60, 0x00, 0x84, 0x3c, // DUP1 PUSH1 0x01 PUSH1 0x00 DUP5 EXTCODECOPY
+	0x60, 0x00, 0xf3, // PUSH1 0x00 RETURN
+
+	// store (0x4c)
+	0x5b,                                     // JUMPDEST
+	0x60, 0x24, 0x35, 0x60, 0x04, 0x35, 0x55, // PUSH1 0x24 CALLDATALOAD PUSH1 0x04 CALLDATALOAD SSTORE
+	0x00, // STOP
+
+	// log2 (0x55)
+	0x5b,                               // JUMPDEST
+	0x60, 0x24, 0x35, 0x60, 0x04, 0x35, // PUSH1 0x24 CALLDATALOAD PUSH1 0x04 CALLDATALOAD
+	0x60, 0x44, 0x36, 0x03, // PUSH1 0x44 CALLDATASIZE SUB
+	0x80, 0x60, 0x44, 0x60, 0x00, 0x37, // DUP1 PUSH1 0x44 PUSH1 0x00 CALLDATACOPY
+	0x60, 0x00, 0xa2, // PUSH1 0x00 LOG2
+	0x00, // STOP
+
+	// log3 (0x6a)
+	0x5b,                                                 // JUMPDEST
+	0x60, 0x44, 0x35, 0x60, 0x24, 0x35, 0x60, 0x04, 0x35, // PUSH1 0x44 CALLDATALOAD PUSH1 0x24 CALLDATALOAD PUSH1 0x04 CALLDATALOAD
+	0x60, 0x64, 0x36, 0x03, // PUSH1 0x64 CALLDATASIZE SUB
+	0x80, 0x60, 0x64, 0x60, 0x00, 0x37, // DUP1 PUSH1 0x64 PUSH1 0x00 CALLDATACOPY
+	0x60, 0x00, 0xa3, // PUSH1 0x00 LOG3
+	0x00, // STOP
+}
+
+// initCode returns contract creation code which deploys runtime
+func initCode(runtime []byte) []byte {
+	size := len(runtime)
+	return append([]byte{
+		0x61, byte(size >> 8), byte(size), 0x80, // PUSH2 size DUP1
+		0x60, 0x0c, 0x60, 0x00, 0x39, // PUSH1 0x0c PUSH1 0x00 CODECOPY
+		0x60, 0x00, 0xf3, // PUSH1 0x00 RETURN
+	}, runtime...)
+}
+
+// chain is an anvil instance, and an account to send transactions to it from
+type chain struct {
+	t      *testing.T
+	url    string
+	client *ethclient.Client
+
+	key     *ecdsa.PrivateKey
+	from    common.Address
+	chainID *big.Int
+}
+
+// freeAddr returns a loopback address nothing is listening on
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// startAnvil runs anvil until the test ends, skipping the test if it isn't installed
+func startAnvil(t *testing.T) *chain {
+	path, err := exec.LookPath("anvil")
+	if err != nil {
+		t.Skip("anvil isn't installed, see https://book.getfoundry.sh/getting-started/installation")
+	}
+
+	host, port, err := net.SplitHostPort(freeAddr(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(path, "--host", host, "--port", port, "--silent")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	c := &chain{t: t, url: "ws://" + net.JoinHostPort(host, port)}
+	waitFor(t, "anvil to start", func() bool {
+		c.client, err = ethclient.Dial(c.url)
+		return err == nil
+	})
+	t.Cleanup(c.client.Close)
+
+	c.key, err = crypto.HexToECDSA(anvilKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.from = crypto.PubkeyToAddress(c.key.PublicKey)
+	c.chainID, err = c.client.ChainID(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// send signs and sends a transaction, and waits for it to be mined. A nil to creates a contract.
+func (c *chain) send(to *common.Address, data []byte) *types.Receipt {
+	ctx := context.Background()
+
+	nonce, err := c.client.PendingNonceAt(ctx, c.from)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	gasPrice, err := c.client.SuggestGasPrice(ctx)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	gas, err := c.client.EstimateGas(ctx, ethereum.CallMsg{From: c.from, To: to, Data: data})
+	if err != nil {
+		c.t.Fatalf("couldn't estimate gas: %v", err)
+	}
+
+	tx, err := types.SignNewTx(c.key, types.LatestSignerForChainID(c.chainID), &types.LegacyTx{
+		Nonce:    nonce,
+		GasPrice: gasPrice,
+		Gas:      gas,
+		To:       to,
+		Data:     data,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if err := c.client.SendTransaction(ctx, tx); err != nil {
+		c.t.Fatal(err)
+	}
+
+	// anvil mines each transaction as it arrives
+	var receipt *types.Receipt
+	waitFor(c.t, "transaction "+tx.Hash().String()+" to be mined", func() bool {
+		receipt, err = c.client.TransactionReceipt(ctx, tx.Hash())
+		if err != nil && !errors.Is(err, ethereum.NotFound) {
+			c.t.Fatal(err)
+		}
+		return err == nil
+	})
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		c.t.Fatalf("transaction %s failed", tx.Hash().String())
+	}
+	return receipt
+}
+
+// deploy creates a contract with the given runtime code
+func (c *chain) deploy(runtime []byte) common.Address {
+	return c.send(nil, initCode(runtime)).ContractAddress
+}
+
+// shim is a deployed shim contract
+type shim struct {
+	c       *chain
+	address common.Address
+}
+
+func (c *chain) deployShim() *shim {
+	return &shim{c: c, address: c.deploy(shimRuntime)}
+}
+
+// respond makes calls to the shim with exactly calldata return response
+func (s *shim) respond(calldata []byte, response []byte) {
+	data := s.c.deploy(append([]byte{0x00}, response...))
+
+	call := []byte{0xff, 0xff, 0xff, 0xff}
+	call = append(call, crypto.Keccak256(calldata)...)
+	call = append(call, common.LeftPadBytes(data.Bytes(), 32)...)
+	s.c.send(&s.address, call)
+}
+
+// emit makes the shim log an event with two or three topics, and returns the block it was logged in
+func (s *shim) emit(topics []common.Hash, data []byte) uint64 {
+	var call []byte
+	switch len(topics) {
+	case 2:
+		call = []byte{0xff, 0xff, 0xff, 0xfd}
+	case 3:
+		call = []byte{0xff, 0xff, 0xff, 0xfe}
+	default:
+		s.c.t.Fatalf("shims can't log %d topics", len(topics))
+	}
+	for _, topic := range topics {
+		call = append(call, topic.Bytes()...)
+	}
+	call = append(call, data...)
+
+	receipt := s.c.send(&s.address, call)
+	if len(receipt.Logs) != 1 {
+		s.c.t.Fatalf("expected the shim to log 1 event, got %d", len(receipt.Logs))
+	}
+	return receipt.BlockNumber.Uint64()
+}
+
+// waitFor polls cond until it's true, failing the test if it takes too long
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(30 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,115 @@
+//go:build integration
+
+package integration
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+// Static beacon API responses, which are just enough for the proxy to connect
+var beaconResponses = map[string]string{
+	"/eth/v1/beacon/genesis": `{"data":{"genesis_time":"1606824023",` +
+		`"genesis_validators_root":"0x4b363db94e286120d76eb905340fdd4e54bfe9f06bf33ff6cf5ad27f511bfe95","genesis_fork_version":"0x00000000"}}`,
+	"/eth/v1/config/spec":             `{"data":{"SLOTS_PER_EPOCH":"32"}}`,
+	"/eth/v1/config/deposit_contract": `{"data":{"chain_id":"31337","address":"0x00000000219ab540356cbb839cbe05303d7705fa"}}`,
+	"/eth/v1/config/fork_schedule":    `{"data":[{"previous_version":"0x00000000","current_version":"0x00000000","epoch":"0"}]}`,
+	"/eth/v1/node/version":            `{"data":{"version":"stub/v1.0.0"}}`,
+	"/eth/v1/node/syncing":            `{"data":{"head_slot":"100","sync_distance":"0","is_syncing":false}}`,
+}
+
+// beaconStub is a beacon node serving the static responses, the validators it's given, and an empty head event stream.
+// It accepts every prepare_beacon_proposer request, and remembers their bodies, so tests can tell which were proxied.
+type beaconStub struct {
+	*httptest.Server
+
+	// Validator pubkeys by index
+	validators map[string]rptypes.ValidatorPubkey
+
+	lock      sync.Mutex
+	proposers []string
+}
+
+func newBeaconStub(t *testing.T, validators map[string]rptypes.ValidatorPubkey) *beaconStub {
+	b := &beaconStub{validators: validators}
+	b.Server = httptest.NewServer(http.HandlerFunc(b.serve))
+	t.Cleanup(b.Close)
+	return b
+}
+
+func (b *beaconStub) serve(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/eth/v1/validator/prepare_beacon_proposer":
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b.lock.Lock()
+		b.proposers = append(b.proposers, string(body))
+		b.lock.Unlock()
+		w.WriteHeader(http.StatusOK)
+		return
+	case r.URL.Path == "/eth/v1/node/health":
+		w.WriteHeader(http.StatusOK)
+		return
+	case r.URL.Path == "/eth/v1/events":
+		// Nothing happens on the stub's chain, so the stream stays open and empty
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		return
+	case strings.HasPrefix(r.URL.Path, "/eth/v1/beacon/states/") && strings.HasSuffix(r.URL.Path, "/validators"):
+		b.serveValidators(w, r)
+		return
+	}
+
+	response, ok := beaconResponses[r.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, response)
+}
+
+// serveValidators answers for the requested validators it knows, by index or pubkey
+func (b *beaconStub) serveValidators(w http.ResponseWriter, r *http.Request) {
+	var ids []string
+	for _, id := range strings.Split(r.URL.Query().Get("id"), ",") {
+		ids = append(ids, strings.TrimSpace(id))
+	}
+
+	data := make([]string, 0, len(ids))
+	for index, pubkey := range b.validators {
+		for _, id := range ids {
+			if id != index && !strings.EqualFold(strings.TrimPrefix(id, "0x"), pubkey.Hex()) {
+				continue
+			}
+			data = append(data, `{"index":"`+index+`","balance":"32000000000","status":"active_ongoing",`+
+				`"validator":{"pubkey":"0x`+pubkey.Hex()+`","withdrawal_credentials":"0x01`+strings.Repeat("00", 31)+`",`+
+				`"effective_balance":"32000000000","slashed":false,"activation_eligibility_epoch":"0","activation_epoch":"0",`+
+				`"exit_epoch":"18446744073709551615","withdrawable_epoch":"18446744073709551615"}}`)
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, `{"data":[`+strings.Join(data, ",")+`]}`)
+}
+
+// proxied returns the bodies of the prepare_beacon_proposer requests the stub has received
+func (b *beaconStub) proxied() []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return append([]string(nil), b.proposers...)
+}
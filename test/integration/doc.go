@@ -0,0 +1,7 @@
+// Package integration runs the rescue-proxy binary end to end, against anvil and a stub beacon node.
+// Its tests are behind the integration build tag, and skip when anvil isn't installed:
+//
+//	go test -tags integration ./test/integration/
+//
+// test/integration/Dockerfile builds an image with anvil in it, which is how CI runs them.
+package integration
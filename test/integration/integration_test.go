@@ -0,0 +1,249 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Pool-Rescue-Node/credentials"
+	"github.com/ethereum/go-ethereum/common"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+const hmacSecret = "integration-secret"
+
+// The proxy binary, built once by TestMain
+var proxyBinary string
+
+func TestMain(m *testing.M) {
+	os.Exit(func() int {
+		dir, err := os.MkdirTemp("", "rescue-proxy-integration")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer os.RemoveAll(dir)
+
+		proxyBinary = filepath.Join(dir, "rescue-proxy")
+		build := exec.Command("go", "build", "-o", proxyBinary, ".")
+		build.Dir = filepath.Join("..", "..")
+		build.Stdout, build.Stderr = os.Stderr, os.Stderr
+		if err := build.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't build the proxy: %v\n", err)
+			return 1
+		}
+
+		return m.Run()
+	}())
+}
+
+// syncBuffer collects the proxy's output, which is written while tests read it
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+// proxy is a running rescue-proxy binary
+type proxy struct {
+	t           *testing.T
+	addr        string
+	inspectAddr string
+}
+
+// startProxy runs the proxy against the chain's rocketStorage and the beacon stub until the test ends,
+// and waits for its EL cache to warm up. Its output is logged if the test fails.
+func startProxy(t *testing.T, c *chain, rp *rocketPool, bn *beaconStub) *proxy {
+	p := &proxy{t: t, addr: freeAddr(t), inspectAddr: freeAddr(t)}
+
+	output := &syncBuffer{}
+	cmd := exec.Command(proxyBinary,
+		"-ec-url", c.url,
+		"-bn-url", bn.URL,
+		"-network", "custom",
+		"-rocketstorage-addr", rp.storage.address.String(),
+		"-hmac-secret", hmacSecret,
+		"-addr", p.addr,
+		"-admin-addr", freeAddr(t),
+		"-api-addr", freeAddr(t),
+		"-inspect-addr", p.inspectAddr,
+		// Requests are sent faster than validator clients would
+		"-guarded-rate-limit", "0",
+		"-debug",
+	)
+	cmd.Stdout, cmd.Stderr = output, output
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		if t.Failed() {
+			t.Logf("proxy output:\n%s", output.String())
+		}
+	})
+
+	// The EL cache is reported healthy once it's been preloaded and is following the chain
+	client := &http.Client{Timeout: time.Second}
+	waitFor(t, "the proxy to start", func() bool {
+		resp, err := client.Get("http://" + p.addr + "/_/healthz")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	})
+	return p
+}
+
+// node is what the proxy's cache inspection endpoint knows about a node
+type node struct {
+	Address         string   `json:"address"`
+	SmoothingPool   bool     `json:"smoothing_pool"`
+	FeeDistributor  string   `json:"fee_distributor"`
+	MinipoolPubkeys []string `json:"minipool_pubkeys"`
+}
+
+// node returns what the proxy's EL cache knows about addr, or nil if it doesn't know it
+func (p *proxy) node(addr common.Address) *node {
+	resp, err := http.Get("http://" + p.inspectAddr + "/admin/node/" + addr.String())
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		p.t.Fatalf("unexpected status %d inspecting node %s", resp.StatusCode, addr.String())
+	}
+	out := &node{}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		p.t.Fatal(err)
+	}
+	return out
+}
+
+// prepareBeaconProposer sends a prepare_beacon_proposer request for a validator, authenticated as nodeAddr,
+// and returns the response's status code
+func (p *proxy) prepareBeaconProposer(nodeAddr common.Address, index string, feeRecipient common.Address) int {
+	cred, err := credentials.NewCredentialManager(sha256.New, []byte(hmacSecret)).Create(time.Now(), nodeAddr.Bytes())
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	password, err := cred.Base64URLEncodePassword()
+	if err != nil {
+		p.t.Fatal(err)
+	}
+
+	body := fmt.Sprintf(`[{"validator_index":%q,"fee_recipient":%q}]`, index, feeRecipient.String())
+	r, err := http.NewRequest(http.MethodPost, "http://"+p.addr+"/eth/v1/validator/prepare_beacon_proposer", strings.NewReader(body))
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.SetBasicAuth(cred.Base64URLEncodeUsername(), password)
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode
+}
+
+func pubkey(b byte) rptypes.ValidatorPubkey {
+	var out rptypes.ValidatorPubkey
+	out[0] = b
+	return out
+}
+
+func TestProxy(t *testing.T) {
+	c := startAnvil(t)
+	rp := c.deployRocketPool()
+
+	// One node already exists when the proxy starts, and is in the smoothing pool
+	preloaded := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	preloadedMinipool := common.HexToAddress("0x1111111111111111111111111111111111110001")
+	rp.addNode(preloaded, true, common.HexToAddress("0x1111111111111111111111111111111111fdfdfd"))
+	rp.addMinipool(preloadedMinipool, pubkey(0x01))
+	rp.listNodes(preloaded, preloadedMinipool)
+
+	// Another registers once it's running, and isn't
+	registered := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	registeredMinipool := common.HexToAddress("0x2222222222222222222222222222222222220001")
+	registeredDistributor := common.HexToAddress("0x2222222222222222222222222222222222fdfdfd")
+
+	bn := newBeaconStub(t, map[string]rptypes.ValidatorPubkey{"1": pubkey(0x01), "2": pubkey(0x02)})
+	p := startProxy(t, c, rp, bn)
+
+	// Cold start: the existing node and its minipool are preloaded
+	n := p.node(preloaded)
+	if n == nil || !n.SmoothingPool || len(n.MinipoolPubkeys) != 1 || n.MinipoolPubkeys[0] != pubkey(0x01).String() {
+		t.Fatalf("unexpected preloaded node %+v", n)
+	}
+	if n := p.node(registered); n != nil {
+		t.Fatalf("expected %s not to be known yet, got %+v", registered.String(), n)
+	}
+
+	// Event-driven updates: registering a node and creating a minipool are picked up from the logs
+	rp.addNode(registered, false, registeredDistributor)
+	rp.addMinipool(registeredMinipool, pubkey(0x02))
+	rp.registerNode(registered)
+	rp.createMinipool(registeredMinipool, registered)
+	waitFor(t, "the registered node's minipool to be cached", func() bool {
+		n := p.node(registered)
+		return n != nil && len(n.MinipoolPubkeys) == 1
+	})
+	n = p.node(registered)
+	if n.SmoothingPool || !strings.EqualFold(n.FeeDistributor, registeredDistributor.String()) || n.MinipoolPubkeys[0] != pubkey(0x02).String() {
+		t.Fatalf("unexpected registered node %+v", n)
+	}
+
+	// Validators using their node's expected fee recipients are proxied
+	if code := p.prepareBeaconProposer(preloaded, "1", rp.smoothingPool.address); code != http.StatusOK {
+		t.Fatalf("expected the smoothing pool fee recipient to be accepted, got %d", code)
+	}
+	if code := p.prepareBeaconProposer(registered, "2", registeredDistributor); code != http.StatusOK {
+		t.Fatalf("expected the fee distributor fee recipient to be accepted, got %d", code)
+	}
+	if proxied := bn.proxied(); len(proxied) != 2 {
+		t.Fatalf("expected 2 requests to be proxied, got %v", proxied)
+	}
+
+	// Validators using anything else aren't
+	if code := p.prepareBeaconProposer(registered, "2", common.HexToAddress("0x3333333333333333333333333333333333333333")); code != http.StatusConflict {
+		t.Fatalf("expected the wrong fee recipient to be rejected with %d, got %d", http.StatusConflict, code)
+	}
+	if code := p.prepareBeaconProposer(preloaded, "2", registeredDistributor); code != http.StatusForbidden {
+		t.Fatalf("expected another node's validator to be rejected with %d, got %d", http.StatusForbidden, code)
+	}
+	if proxied := bn.proxied(); len(proxied) != 2 {
+		t.Fatalf("expected the rejected requests not to be proxied, got %v", proxied)
+	}
+}
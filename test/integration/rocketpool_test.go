@@ -0,0 +1,159 @@
+//go:build integration
+
+package integration
+
+import (
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/rocket-pool/rocketpool-go/rocketpool"
+	rptypes "github.com/rocket-pool/rocketpool-go/types"
+)
+
+// Just the parts of the Rocket Pool ABIs the proxy uses
+const (
+	rocketStorageABI = `[
+		{"name":"getAddress","type":"function","stateMutability":"view","inputs":[{"name":"_key","type":"bytes32"}],"outputs":[{"name":"r","type":"address"}]},
+		{"name":"getString","type":"function","stateMutability":"view","inputs":[{"name":"_key","type":"bytes32"}],"outputs":[{"name":"","type":"string"}]}
+	]`
+	rocketNodeManagerABI = `[
+		{"name":"getNodeCount","type":"function","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"uint256"}]},
+		{"name":"getNodeAt","type":"function","stateMutability":"view","inputs":[{"name":"_index","type":"uint256"}],"outputs":[{"name":"","type":"address"}]},
+		{"name":"getSmoothingPoolRegistrationState","type":"function","stateMutability":"view","inputs":[{"name":"_nodeAddress","type":"address"}],"outputs":[{"name":"","type":"bool"}]},
+		{"name":"NodeRegistered","type":"event","anonymous":false,"inputs":[{"indexed":true,"name":"node","type":"address"},{"indexed":false,"name":"time","type":"uint256"}]},
+		{"name":"NodeSmoothingPoolStateChanged","type":"event","anonymous":false,"inputs":[{"indexed":true,"name":"node","type":"address"},{"indexed":false,"name":"state","type":"bool"}]}
+	]`
+	rocketMinipoolManagerABI = `[
+		{"name":"getMinipoolCount","type":"function","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"uint256"}]},
+		{"name":"getNodeMinipoolCount","type":"function","stateMutability":"view","inputs":[{"name":"_nodeAddress","type":"address"}],"outputs":[{"name":"","type":"uint256"}]},
+		{"name":"getNodeMinipoolAt","type":"function","stateMutability":"view","inputs":[{"name":"_nodeAddress","type":"address"},{"name":"_index","type":"uint256"}],"outputs":[{"name":"","type":"address"}]},
+		{"name":"getMinipoolExists","type":"function","stateMutability":"view","inputs":[{"name":"_minipoolAddress","type":"address"}],"outputs":[{"name":"","type":"bool"}]},
+		{"name":"getMinipoolPubkey","type":"function","stateMutability":"view","inputs":[{"name":"_minipoolAddress","type":"address"}],"outputs":[{"name":"","type":"bytes"}]},
+		{"name":"MinipoolCreated","type":"event","anonymous":false,"inputs":[{"indexed":true,"name":"minipool","type":"address"},{"indexed":true,"name":"node","type":"address"},{"indexed":false,"name":"time","type":"uint256"}]},
+		{"name":"MinipoolDestroyed","type":"event","anonymous":false,"inputs":[{"indexed":true,"name":"minipool","type":"address"},{"indexed":true,"name":"node","type":"address"},{"indexed":false,"name":"time","type":"uint256"}]}
+	]`
+	rocketNodeDistributorFactoryABI = `[
+		{"name":"getProxyAddress","type":"function","stateMutability":"view","inputs":[{"name":"_nodeAddress","type":"address"}],"outputs":[{"name":"","type":"address"}]}
+	]`
+	rocketSmoothingPoolABI = `[]`
+)
+
+// contractShim is a shim standing in for one of the Rocket Pool contracts
+type contractShim struct {
+	*shim
+	abi abi.ABI
+}
+
+// respond makes the shim answer method called with args with results
+func (s *contractShim) respond(method string, args []interface{}, results ...interface{}) {
+	calldata, err := s.abi.Pack(method, args...)
+	if err != nil {
+		s.c.t.Fatal(err)
+	}
+	response, err := s.abi.Methods[method].Outputs.Pack(results...)
+	if err != nil {
+		s.c.t.Fatal(err)
+	}
+	s.shim.respond(calldata, response)
+}
+
+// emit logs event with the given indexed topics and unindexed data, as the contract would
+func (s *contractShim) emit(event string, indexed []common.Hash, data ...interface{}) uint64 {
+	packed, err := s.abi.Events[event].Inputs.NonIndexed().Pack(data...)
+	if err != nil {
+		s.c.t.Fatal(err)
+	}
+	return s.shim.emit(append([]common.Hash{s.abi.Events[event].ID}, indexed...), packed)
+}
+
+// rocketPool is just enough of Rocket Pool for the proxy to run against: rocketStorage, and the contracts the proxy
+// reads, registered in it. What it answers is seeded by the test, and nothing it answers is checked for consistency.
+type rocketPool struct {
+	c *chain
+
+	storage            *contractShim
+	nodeManager        *contractShim
+	minipoolManager    *contractShim
+	distributorFactory *contractShim
+	smoothingPool      *contractShim
+
+	nodes     []common.Address
+	minipools int
+}
+
+func (c *chain) deployRocketPool() *rocketPool {
+	rp := &rocketPool{c: c}
+	rp.storage = rp.deploy("", rocketStorageABI)
+	rp.nodeManager = rp.deploy("rocketNodeManager", rocketNodeManagerABI)
+	rp.minipoolManager = rp.deploy("rocketMinipoolManager", rocketMinipoolManagerABI)
+	rp.distributorFactory = rp.deploy("rocketNodeDistributorFactory", rocketNodeDistributorFactoryABI)
+	rp.smoothingPool = rp.deploy("rocketSmoothingPool", rocketSmoothingPoolABI)
+
+	// The proxy counts both when it starts
+	rp.nodeManager.respond("getNodeCount", nil, big.NewInt(0))
+	rp.minipoolManager.respond("getMinipoolCount", nil, big.NewInt(0))
+	return rp
+}
+
+// deploy deploys a shim for a contract, and registers its address and ABI in rocketStorage under name,
+// the way RocketPool.GetContract looks them up. rocketStorage itself has no name.
+func (rp *rocketPool) deploy(name string, abiJSON string) *contractShim {
+	parsed, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		rp.c.t.Fatal(err)
+	}
+	s := &contractShim{shim: rp.c.deployShim(), abi: parsed}
+	if name == "" {
+		return s
+	}
+
+	encoded, err := rocketpool.EncodeAbiStr(abiJSON)
+	if err != nil {
+		rp.c.t.Fatal(err)
+	}
+	rp.storage.respond("getAddress", []interface{}{crypto.Keccak256Hash([]byte("contract.address"), []byte(name))}, s.address)
+	rp.storage.respond("getString", []interface{}{crypto.Keccak256Hash([]byte("contract.abi"), []byte(name))}, encoded)
+	return s
+}
+
+// addNode seeds a node's smoothing pool status and fee distributor, so the proxy can read them once it learns of it.
+// It isn't one of the nodes the proxy preloads until listNodes is called.
+func (rp *rocketPool) addNode(node common.Address, inSmoothingPool bool, feeDistributor common.Address) {
+	rp.nodeManager.respond("getSmoothingPoolRegistrationState", []interface{}{node}, inSmoothingPool)
+	rp.distributorFactory.respond("getProxyAddress", []interface{}{node}, feeDistributor)
+}
+
+// addMinipool seeds a minipool's details
+func (rp *rocketPool) addMinipool(minipool common.Address, pubkey rptypes.ValidatorPubkey) {
+	rp.minipoolManager.respond("getMinipoolExists", []interface{}{minipool}, true)
+	rp.minipoolManager.respond("getMinipoolPubkey", []interface{}{minipool}, pubkey.Bytes())
+	rp.minipools++
+	rp.minipoolManager.respond("getMinipoolCount", nil, big.NewInt(int64(rp.minipools)))
+}
+
+// listNodes makes node, and the given minipools, part of the node and node minipool lists the proxy preloads
+func (rp *rocketPool) listNodes(node common.Address, minipools ...common.Address) {
+	rp.nodeManager.respond("getNodeAt", []interface{}{big.NewInt(int64(len(rp.nodes)))}, node)
+	rp.nodes = append(rp.nodes, node)
+	rp.nodeManager.respond("getNodeCount", nil, big.NewInt(int64(len(rp.nodes))))
+
+	rp.minipoolManager.respond("getNodeMinipoolCount", []interface{}{node}, big.NewInt(int64(len(minipools))))
+	for i, minipool := range minipools {
+		rp.minipoolManager.respond("getNodeMinipoolAt", []interface{}{node, big.NewInt(int64(i))}, minipool)
+	}
+}
+
+// registerNode emits NodeRegistered for node
+func (rp *rocketPool) registerNode(node common.Address) uint64 {
+	return rp.nodeManager.emit("NodeRegistered", []common.Hash{common.BytesToHash(node.Bytes())}, big.NewInt(time.Now().Unix()))
+}
+
+// createMinipool emits MinipoolCreated for a minipool of node
+func (rp *rocketPool) createMinipool(minipool common.Address, node common.Address) uint64 {
+	return rp.minipoolManager.emit("MinipoolCreated",
+		[]common.Hash{common.BytesToHash(minipool.Bytes()), common.BytesToHash(node.Bytes())}, big.NewInt(time.Now().Unix()))
+}